@@ -3,6 +3,8 @@ package api
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"strings"
 	"sync"
@@ -12,6 +14,8 @@ import (
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/listener"
+	"github.com/42wim/matterbridge/hook/signature"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/mitchellh/mapstructure"
@@ -23,6 +27,11 @@ type API struct {
 	sync.RWMutex
 	*bridge.Config
 	mrouter *melody.Melody
+
+	pending    []config.Message
+	batchTimer *time.Timer
+
+	grpcClients map[chan config.Message]struct{}
 }
 
 type Message struct {
@@ -39,30 +48,7 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	e.HideBanner = true
 	e.HidePort = true
 
-	b.mrouter = melody.New()
-	b.mrouter.HandleMessage(func(s *melody.Session, msg []byte) {
-		message := config.Message{}
-		err := json.Unmarshal(msg, &message)
-		if err != nil {
-			b.Log.Errorf("failed to decode message from byte[] '%s'", string(msg))
-			return
-		}
-		b.handleWebsocketMessage(message, s)
-	})
-	b.mrouter.HandleConnect(func(session *melody.Session) {
-		greet := b.getGreeting()
-		data, err := json.Marshal(greet)
-		if err != nil {
-			b.Log.Errorf("failed to encode message '%v'", greet)
-			return
-		}
-		err = session.Write(data)
-		if err != nil {
-			b.Log.Errorf("failed to write message '%s'", string(data))
-			return
-		}
-		// TODO: send message history buffer from `b.Messages` here
-	})
+	b.mrouter = b.newWebsocketRouter()
 
 	b.Messages = ring.Ring{}
 	if b.GetInt("Buffer") != 0 {
@@ -84,17 +70,64 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	e.GET("/api/messages", b.handleMessages)
 	e.GET("/api/stream", b.handleStream)
 	e.GET("/api/websocket", b.handleWebsocket)
-	e.POST("/api/message", b.handlePostMessage)
+	e.POST("/api/message", b.handlePostMessage, b.verifySignature(b.WebhookVerifier(signature.ModeGitHub)))
+	e.POST("/api/webhook", b.handlePostWebhook, b.verifySignature(b.WebhookVerifier(signature.ModeGitHub)))
+	e.POST("/api/verify", b.handlePostVerify, b.verifySignature(b.WebhookVerifier(signature.ModeGitHub)))
+	e.GET("/api/msgmap", b.handleGetMsgMap)
+	e.POST("/api/msgmap", b.handlePostMsgMap, b.verifySignature(b.WebhookVerifier(signature.ModeGitHub)))
 	go func() {
 		if b.GetString("BindAddress") == "" {
 			b.Log.Fatalf("No BindAddress configured.")
 		}
-		b.Log.Infof("Listening on %s", b.GetString("BindAddress"))
-		b.Log.Fatal(e.Start(b.GetString("BindAddress")))
+		b.Log.Fatal(listener.Serve(b.Log, b.ListenerConfig(b.GetString("BindAddress")), e))
 	}()
+	if b.GetString("GRPCBindAddress") != "" {
+		go func() {
+			b.Log.Fatal(b.serveGRPC())
+		}()
+	}
 	return b
 }
 
+// newWebsocketRouter returns the melody instance of /api/websocket.
+func (b *API) newWebsocketRouter() *melody.Melody {
+	m := melody.New()
+	if b.GetString("StreamCompression") != "" {
+		m.Upgrader.EnableCompression = true
+	}
+	if keepAlive := b.keepAlive(); keepAlive > 0 {
+		m.Config.PingPeriod = keepAlive
+		m.Config.PongWait = keepAlive * 2
+	}
+	m.HandleMessage(func(s *melody.Session, msg []byte) {
+		if b.handleSubscribe(s, msg) {
+			return
+		}
+		message := config.Message{}
+		err := json.Unmarshal(msg, &message)
+		if err != nil {
+			b.Log.Errorf("failed to decode message from byte[] '%s'", string(msg))
+			return
+		}
+		b.handleWebsocketMessage(message, s)
+	})
+	m.HandleConnect(func(session *melody.Session) {
+		greet := b.getGreeting()
+		data, err := json.Marshal(greet)
+		if err != nil {
+			b.Log.Errorf("failed to encode message '%v'", greet)
+			return
+		}
+		err = session.Write(data)
+		if err != nil {
+			b.Log.Errorf("failed to write message '%s'", string(data))
+			return
+		}
+		// TODO: send message history buffer from `b.Messages` here
+	})
+	return m
+}
+
 func (b *API) Connect() error {
 	return nil
 }
@@ -108,21 +141,52 @@ func (b *API) JoinChannel(channel config.ChannelInfo) error {
 }
 
 func (b *API) Send(msg config.Message) (string, error) {
+	if b.GetBool("LegacyJoinLeave") {
+		msg.Event = config.LegacyEvent(msg.Event)
+	}
 	b.Lock()
-	defer b.Unlock()
 	// ignore delete messages
-	if msg.Event == config.EventMsgDelete {
+	if msg.Event != config.EventMsgDelete {
+		b.Log.Debugf("enqueueing message from %s on ring buffer", msg.Username)
+		b.Messages.Enqueue(msg)
+		b.queueBroadcast(msg)
+	}
+	b.sendGRPC(msg)
+	b.Unlock()
+
+	// the webhook also gets the deletes, of the messages it returned an ID for
+	if b.GetString("WebhookURL") == "" || (msg.Event == config.EventMsgDelete && msg.ID == "") {
 		return "", nil
 	}
-	b.Log.Debugf("enqueueing message from %s on ring buffer", msg.Username)
-	b.Messages.Enqueue(msg)
+	return b.sendWebhook(msg)
+}
 
-	data, err := json.Marshal(msg)
-	if err != nil {
-		b.Log.Errorf("failed to encode message  '%s'", msg)
+// verifySignature rejects requests that aren't signed with WebhookSigningSecret.
+func (b *API) verifySignature(v signature.Verifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if v == nil {
+				return next(c)
+			}
+			if _, err := signature.ReadAndVerify(v, c.Response(), c.Request(), b.maxBodySize()); err != nil {
+				b.Log.Warnf("rejecting request from %s: %s", c.RealIP(), err)
+				if errors.Is(err, signature.ErrTooLarge) {
+					return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+				}
+				return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+			}
+			return next(c)
+		}
+	}
+}
+
+// maxBodySize is the size limit of the signed requests, they can carry files of
+// MediaDownloadSize in base64.
+func (b *API) maxBodySize() int64 {
+	if b.General == nil {
+		return signature.MaxBodySize
 	}
-	_ = b.mrouter.Broadcast(data)
-	return "", nil
+	return signature.MaxBodySize + int64(b.General.MediaDownloadSize)*4/3
 }
 
 func (b *API) handleHealthcheck(c echo.Context) error {
@@ -139,7 +203,10 @@ func (b *API) handlePostMessage(c echo.Context) error {
 	message.Protocol = "api"
 	message.Account = b.Account
 	message.ID = ""
-	message.Timestamp = time.Now()
+	// a timestamp can be given for messages that were sent earlier, eg when importing history
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
 
 	var (
 		fm map[string]interface{}
@@ -158,6 +225,11 @@ func (b *API) handlePostMessage(c echo.Context) error {
 				return err
 			}
 		}
+		// a file can be only a URL, the gateway downloads it for the bridges that upload files
+		if _, ok = fm["Data"]; !ok && fi.URL != "" {
+			message.Extra["file"][i] = fi
+			continue
+		}
 		// mapstructure doesn't decode base64 into []byte, so it must be done manually for fi.Data
 		if ds, ok = fm["Data"].(string); !ok {
 			return echo.NewHTTPError(http.StatusInternalServerError, "invalid format for data")
@@ -175,6 +247,60 @@ func (b *API) handlePostMessage(c echo.Context) error {
 	return c.JSON(http.StatusOK, message)
 }
 
+// handlePostVerify is called by an external verification service when a user
+// passed the verification of a gateway with Verification Mode "url".
+func (b *API) handlePostVerify(c echo.Context) error {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if req.Token == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "token missing")
+	}
+	b.Remote <- config.Message{
+		Event:   config.EventUserVerified,
+		Text:    req.Token,
+		Channel: "api",
+		Account: b.Account,
+	}
+	return c.NoContent(http.StatusAccepted)
+}
+
+// handleGetMsgMap returns the copies on the other bridges of the message with the
+// given account and id, eg /api/msgmap?account=discord.mydiscord&id=123.
+func (b *API) handleGetMsgMap(c echo.Context) error {
+	account, id := c.QueryParam("account"), c.QueryParam("id")
+	if account == "" || id == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "account and id are required")
+	}
+	if b.MessageMap == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "message map not available")
+	}
+	mappings := b.MessageMap.MessageMappings(account, id)
+	if len(mappings) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "message not found")
+	}
+	return c.JSON(http.StatusOK, mappings)
+}
+
+// handlePostMsgMap links messages that were sent outside of matterbridge to a message,
+// so edits and deletes of that message are relayed to them too.
+func (b *API) handlePostMsgMap(c echo.Context) error {
+	var m bridge.MessageMapping
+	if err := c.Bind(&m); err != nil {
+		return err
+	}
+	if b.MessageMap == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "message map not available")
+	}
+	if err := b.MessageMap.LinkMessages(m); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
 func (b *API) handleMessages(c echo.Context) error {
 	b.Lock()
 	defer b.Unlock()
@@ -190,26 +316,82 @@ func (b *API) getGreeting() config.Message {
 	}
 }
 
+// dequeue takes at most max messages from the ring buffer.
+func (b *API) dequeue(max int) []config.Message {
+	b.Lock()
+	defer b.Unlock()
+	var msgs []config.Message
+	for len(msgs) < max {
+		msg := b.Messages.Dequeue()
+		if msg == nil {
+			break
+		}
+		msgs = append(msgs, msg.(config.Message))
+	}
+	return msgs
+}
+
 func (b *API) handleStream(c echo.Context) error {
+	encoding := b.streamEncoding(c.Request())
 	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if encoding != "" {
+		c.Response().Header().Set(echo.HeaderContentEncoding, encoding)
+	}
 	c.Response().WriteHeader(http.StatusOK)
-	greet := b.getGreeting()
-	if err := json.NewEncoder(c.Response()).Encode(greet); err != nil {
+
+	var w io.Writer = c.Response()
+	cw, err := newFlushWriter(c.Response(), encoding)
+	if err != nil {
 		return err
 	}
-	c.Response().Flush()
+	if cw != nil {
+		defer cw.Close()
+		w = cw
+	}
+	write := func(v interface{}) error {
+		if err := json.NewEncoder(w).Encode(v); err != nil {
+			return err
+		}
+		if cw != nil {
+			if err := cw.Flush(); err != nil {
+				return err
+			}
+		}
+		c.Response().Flush()
+		return nil
+	}
+
+	if err := write(b.getGreeting()); err != nil {
+		return err
+	}
+
+	interval := 100 * time.Millisecond
+	if b.batchSize() > 1 {
+		interval = b.batchDelay()
+	}
+	lastWrite := time.Now()
 	for {
 		select {
 		// TODO: this causes issues, messages should be broadcasted to all connected clients
 		default:
-			msg := b.Messages.Dequeue()
-			if msg != nil {
-				if err := json.NewEncoder(c.Response()).Encode(msg); err != nil {
+			msgs := b.dequeue(b.batchSize())
+			switch {
+			case len(msgs) > 0:
+				var frame interface{} = msgs
+				if b.batchSize() == 1 {
+					frame = msgs[0]
+				}
+				if err := write(frame); err != nil {
 					return err
 				}
-				c.Response().Flush()
+				lastWrite = time.Now()
+			case b.keepAlive() > 0 && time.Since(lastWrite) >= b.keepAlive():
+				if err := write(b.getKeepAlive()); err != nil {
+					return err
+				}
+				lastWrite = time.Now()
 			}
-			time.Sleep(100 * time.Millisecond)
+			time.Sleep(interval)
 		case <-c.Request().Context().Done():
 			return nil
 		}
@@ -228,14 +410,17 @@ func (b *API) handleWebsocketMessage(message config.Message, s *melody.Session)
 		b.Log.Errorf("failed to encode message for loopback '%v'", message)
 		return
 	}
-	_ = b.mrouter.BroadcastOthers(data, s)
+	_ = b.mrouter.BroadcastFilter(data, func(q *melody.Session) bool {
+		return q != s && sessionSubscription(q).matches(message)
+	})
 
 	b.Log.Debugf("Sending websocket message from %s on %s to gateway", message.Username, "api")
 	b.Remote <- message
 }
 
 func (b *API) handleWebsocket(c echo.Context) error {
-	err := b.mrouter.HandleRequest(c.Response(), c.Request())
+	keys := map[string]interface{}{subscriptionKey: newSubscription(c.QueryParams())}
+	err := b.mrouter.HandleRequestWithKeys(c.Response(), c.Request(), keys)
 	if err != nil {
 		b.Log.Errorf("error in websocket handling  '%v'", err)
 		return err
@@ -0,0 +1,4 @@
+// Package apipb contains the protobuf messages and the gRPC service of the api bridge.
+package apipb
+
+//go:generate protoc -I../../.. --go_out=../../.. --go_opt=paths=source_relative --go-grpc_out=../../.. --go-grpc_opt=paths=source_relative bridge/api/apipb/api.proto
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/api/apipb"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/listener"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcClientBuffer is the amount of messages queued for a gRPC client, when a client
+// doesn't keep up the messages that don't fit are dropped.
+const grpcClientBuffer = 100
+
+// grpcServer serves the apipb.Bridge service on GRPCBindAddress.
+type grpcServer struct {
+	apipb.UnimplementedBridgeServer
+	b *API
+}
+
+func (b *API) serveGRPC() error {
+	ln, err := listener.ListenTLS(b.Log, b.ListenerConfig(b.GetString("GRPCBindAddress")))
+	if err != nil {
+		return err
+	}
+	return b.newGRPCServer().Serve(ln)
+}
+
+func (b *API) newGRPCServer() *grpc.Server {
+	srv := grpc.NewServer(grpc.StreamInterceptor(b.grpcAuth))
+	apipb.RegisterBridgeServer(srv, &grpcServer{b: b})
+	return srv
+}
+
+// grpcAuth rejects the streams without an "authorization: Bearer <Token>" header when
+// Token is set, like the REST API.
+func (b *API) grpcAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	token := b.GetString("Token")
+	if token == "" {
+		return handler(srv, ss)
+	}
+	md, _ := metadata.FromIncomingContext(ss.Context())
+	for _, auth := range md.Get("authorization") {
+		if strings.TrimPrefix(auth, "Bearer ") == token {
+			return handler(srv, ss)
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid or missing token")
+}
+
+// Stream sends the messages sent to the api bridge to the client, including the deletes,
+// and relays the messages of the client until it disconnects.
+func (s *grpcServer) Stream(stream apipb.Bridge_StreamServer) error {
+	b := s.b
+	msgs := b.subscribeGRPC()
+	defer b.unsubscribeGRPC(msgs)
+
+	if err := stream.Send(toProtoMessage(b.getGreeting())); err != nil {
+		return err
+	}
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			pm, err := stream.Recv()
+			if err != nil {
+				// the client closing its side still receives the messages
+				if !errors.Is(err, io.EOF) {
+					errs <- err
+				}
+				return
+			}
+			b.handleGRPCMessage(pm)
+		}
+	}()
+	for {
+		select {
+		case msg := <-msgs:
+			if err := stream.Send(toProtoMessage(msg)); err != nil {
+				return err
+			}
+		case err := <-errs:
+			return err
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func (b *API) subscribeGRPC() chan config.Message {
+	msgs := make(chan config.Message, grpcClientBuffer)
+	b.Lock()
+	defer b.Unlock()
+	if b.grpcClients == nil {
+		b.grpcClients = make(map[chan config.Message]struct{})
+	}
+	b.grpcClients[msgs] = struct{}{}
+	return msgs
+}
+
+func (b *API) unsubscribeGRPC(msgs chan config.Message) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.grpcClients, msgs)
+}
+
+// sendGRPC queues msg for the gRPC clients. The caller must hold the lock.
+func (b *API) sendGRPC(msg config.Message) {
+	for msgs := range b.grpcClients {
+		select {
+		case msgs <- msg:
+		default:
+			b.Log.Warnf("gRPC client is too slow, dropping message %s", msg.ID)
+		}
+	}
+}
+
+func (b *API) handleGRPCMessage(pm *apipb.Message) {
+	// these values are fixed, like for /api/websocket
+	rmsg := config.Message{
+		Text:     pm.GetText(),
+		Channel:  "api",
+		Username: pm.GetUsername(),
+		UserID:   pm.GetUserId(),
+		Avatar:   pm.GetAvatar(),
+		Account:  b.Account,
+		Event:    pm.GetEvent(),
+		Protocol: "api",
+		Gateway:  pm.GetGateway(),
+		ParentID: pm.GetParentId(),
+		Extra:    make(map[string][]interface{}),
+	}
+	rmsg.Timestamp = time.Now()
+	if pm.GetTimestamp() != nil {
+		rmsg.Timestamp = pm.GetTimestamp().AsTime()
+	}
+	for _, f := range pm.GetFiles() {
+		if len(f.GetData()) == 0 {
+			continue
+		}
+		if err := helper.HandleDownloadSize(b.Log, &rmsg, f.GetName(), int64(len(f.GetData())), b.General); err != nil {
+			b.Log.Error(err)
+			continue
+		}
+		data := f.GetData()
+		helper.HandleDownloadData(b.Log, &rmsg, f.GetName(), f.GetComment(), f.GetUrl(), &data, b.General)
+	}
+	b.Log.Debugf("Sending gRPC message from %s on %s to gateway", rmsg.Username, "api")
+	b.Remote <- rmsg
+}
+
+func toProtoMessage(msg config.Message) *apipb.Message {
+	pm := &apipb.Message{
+		Text:     msg.Text,
+		Channel:  msg.Channel,
+		Username: msg.Username,
+		UserId:   msg.UserID,
+		Avatar:   msg.Avatar,
+		Account:  msg.Account,
+		Event:    msg.Event,
+		Protocol: msg.Protocol,
+		Gateway:  msg.Gateway,
+		ParentId: msg.ParentID,
+		ThreadId: msg.ThreadID,
+		Id:       msg.ID,
+	}
+	if !msg.Timestamp.IsZero() {
+		pm.Timestamp = timestamppb.New(msg.Timestamp)
+	}
+	for _, f := range msg.Extra["file"] {
+		fi, ok := f.(config.FileInfo)
+		if !ok {
+			continue
+		}
+		// files that can't be read are still sent with their URL
+		data, _ := fi.Bytes()
+		pm.Files = append(pm.Files, &apipb.File{
+			Name:        fi.Name,
+			Data:        data,
+			ContentType: fi.ContentType,
+			Comment:     fi.Comment,
+			Url:         fi.URL,
+			Size:        fi.Size,
+			Avatar:      fi.Avatar,
+			Sha:         fi.SHA,
+			NativeId:    fi.NativeID,
+		})
+	}
+	return pm
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/api/apipb"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func dialGRPC(t *testing.T, b *API) apipb.BridgeClient {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := b.newGRPCServer()
+	go srv.Serve(ln) //nolint:errcheck
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return apipb.NewBridgeClient(conn)
+}
+
+func TestGRPCStream(t *testing.T) {
+	b := newTestAPI(t, `Token="secret"`)
+	client := dialGRPC(t, b)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.Stream(ctx)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	stream, err = client.Stream(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret"))
+	require.NoError(t, err)
+	greeting, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, config.EventAPIConnected, greeting.GetEvent())
+
+	// the bridge can only send once the client is subscribed, which it is after the greeting
+	data := []byte("hi")
+	_, err = b.Send(config.Message{Text: "hello", Username: "bob", Gateway: "gw1", ID: "1", Extra: map[string][]interface{}{
+		"file": {config.FileInfo{Name: "a.txt", Data: &data}},
+	}})
+	require.NoError(t, err)
+	_, err = b.Send(config.Message{Event: config.EventMsgDelete, ID: "1"})
+	require.NoError(t, err)
+	pm, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", pm.GetText())
+	assert.Equal(t, "bob", pm.GetUsername())
+	assert.Equal(t, "gw1", pm.GetGateway())
+	if assert.Len(t, pm.GetFiles(), 1) {
+		assert.Equal(t, "a.txt", pm.GetFiles()[0].GetName())
+		assert.Equal(t, data, pm.GetFiles()[0].GetData())
+	}
+	pm, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, config.EventMsgDelete, pm.GetEvent())
+
+	require.NoError(t, stream.Send(&apipb.Message{Text: "from a bot", Username: "bot", Gateway: "gw1", Channel: "ignored",
+		Files: []*apipb.File{{Name: "b.txt", Data: []byte("file")}}}))
+	select {
+	case rmsg := <-b.Remote:
+		assert.Equal(t, "from a bot", rmsg.Text)
+		assert.Equal(t, "bot", rmsg.Username)
+		assert.Equal(t, "gw1", rmsg.Gateway)
+		assert.Equal(t, "api", rmsg.Channel)
+		assert.Equal(t, "api", rmsg.Protocol)
+		assert.Equal(t, "api.local", rmsg.Account)
+		assert.False(t, rmsg.Timestamp.IsZero())
+		assert.Len(t, rmsg.Extra["file"], 1)
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+	compressionAuto = "auto"
+
+	defaultBatchDelay = 500 * time.Millisecond
+)
+
+// flushWriter is a compressing writer that can push its buffered data to the client.
+type flushWriter interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// streamEncoding returns the compression to use for the stream, based on the
+// StreamCompression setting and what the client accepts.
+func (b *API) streamEncoding(r *http.Request) string {
+	setting := strings.ToLower(b.GetString("StreamCompression"))
+	if setting == "" {
+		return ""
+	}
+	requested := r.URL.Query().Get("compression")
+	if requested == "" {
+		requested = r.Header.Get(echo.HeaderAcceptEncoding)
+	}
+	accepts := func(enc string) bool {
+		return strings.Contains(strings.ToLower(requested), enc)
+	}
+	switch setting {
+	case compressionAuto:
+		if accepts(compressionZstd) {
+			return compressionZstd
+		}
+		if accepts(compressionGzip) {
+			return compressionGzip
+		}
+	case compressionGzip, compressionZstd:
+		if accepts(setting) {
+			return setting
+		}
+	default:
+		b.Log.Errorf("unknown StreamCompression %s, not compressing", setting)
+	}
+	return ""
+}
+
+func newFlushWriter(w io.Writer, encoding string) (flushWriter, error) {
+	switch encoding {
+	case compressionGzip:
+		return gzip.NewWriter(w), nil
+	case compressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	}
+	return nil, nil
+}
+
+// batchSize returns the maximum amount of messages per frame, 1 means no batching.
+func (b *API) batchSize() int {
+	if size := b.GetInt("StreamBatchSize"); size > 1 {
+		return size
+	}
+	return 1
+}
+
+func (b *API) batchDelay() time.Duration {
+	if delay := b.GetInt("StreamBatchDelay"); delay > 0 {
+		return time.Duration(delay) * time.Millisecond
+	}
+	return defaultBatchDelay
+}
+
+func (b *API) keepAlive() time.Duration {
+	return time.Duration(b.GetInt("StreamKeepAlive")) * time.Second
+}
+
+func (b *API) getKeepAlive() config.Message {
+	return config.Message{
+		Event:     config.EventAPIKeepAlive,
+		Timestamp: time.Now(),
+	}
+}
+
+// encodeFrame marshals the messages as a single object, or as an array when batching is enabled.
+func (b *API) encodeFrame(msgs []config.Message) ([]byte, error) {
+	if b.batchSize() == 1 && len(msgs) == 1 {
+		return json.Marshal(msgs[0])
+	}
+	return json.Marshal(msgs)
+}
+
+// queueBroadcast sends msg to the websocket clients, collecting messages in
+// batches when StreamBatchSize is set. The caller must hold the lock.
+func (b *API) queueBroadcast(msg config.Message) {
+	if b.batchSize() == 1 {
+		b.broadcast([]config.Message{msg})
+		return
+	}
+	b.pending = append(b.pending, msg)
+	if len(b.pending) >= b.batchSize() {
+		b.flushPending()
+		return
+	}
+	if b.batchTimer == nil {
+		b.batchTimer = time.AfterFunc(b.batchDelay(), func() {
+			b.Lock()
+			defer b.Unlock()
+			b.flushPending()
+		})
+	}
+}
+
+// flushPending broadcasts the pending batch. The caller must hold the lock.
+func (b *API) flushPending() {
+	if b.batchTimer != nil {
+		b.batchTimer.Stop()
+		b.batchTimer = nil
+	}
+	if len(b.pending) == 0 {
+		return
+	}
+	b.broadcast(b.pending)
+	b.pending = nil
+}
+
+// broadcast sends msgs to the websocket clients, filtered by their subscription.
+func (b *API) broadcast(msgs []config.Message) {
+	sessions, err := b.mrouter.Sessions()
+	if err != nil {
+		return
+	}
+	// the frame of all the messages is shared by the clients that get all of them
+	var all []byte
+	for _, s := range sessions {
+		filtered := sessionSubscription(s).filter(msgs)
+		var data []byte
+		switch {
+		case len(filtered) == 0:
+			continue
+		case len(filtered) == len(msgs) && all != nil:
+			data = all
+		default:
+			if data, err = b.encodeFrame(filtered); err != nil {
+				b.Log.Errorf("failed to encode messages '%#v'", filtered)
+				return
+			}
+			if len(filtered) == len(msgs) {
+				all = data
+			}
+		}
+		_ = s.Write(data)
+	}
+}
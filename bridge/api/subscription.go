@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/olahol/melody"
+)
+
+const (
+	subscriptionKey = "subscription"
+
+	// eventMessage subscribes to the messages without an event.
+	eventMessage = "message"
+)
+
+// subscription filters the messages sent to a websocket client, an empty list matches
+// everything.
+type subscription struct {
+	Gateways []string `json:"gateways"`
+	Channels []string `json:"channels"` // the channels the messages were sent in
+	Events   []string `json:"events"`   // "message" for the messages without event
+}
+
+// subscribeRequest changes the subscription of a websocket client, it's sent instead of a
+// message: {"subscribe":{"gateways":["gateway1"],"events":["message","msg_delete"]}}.
+type subscribeRequest struct {
+	Subscribe *subscription `json:"subscribe"`
+}
+
+// newSubscription returns the subscription of the ?gateway=, ?channel= and ?event= query
+// parameters of /api/websocket, which take comma separated lists.
+func newSubscription(query url.Values) *subscription {
+	list := func(key string) []string {
+		var res []string
+		for _, v := range query[key] {
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					res = append(res, item)
+				}
+			}
+		}
+		return res
+	}
+	return &subscription{Gateways: list("gateway"), Channels: list("channel"), Events: list("event")}
+}
+
+func (sub *subscription) matches(msg config.Message) bool {
+	if sub == nil {
+		return true
+	}
+	event := msg.Event
+	if event == "" {
+		event = eventMessage
+	}
+	return matchesAny(sub.Gateways, msg.Gateway) && matchesAny(sub.Channels, msg.Channel) && matchesAny(sub.Events, event)
+}
+
+func matchesAny(list []string, s string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the messages of msgs that match sub.
+func (sub *subscription) filter(msgs []config.Message) []config.Message {
+	var res []config.Message
+	for _, msg := range msgs {
+		if sub.matches(msg) {
+			res = append(res, msg)
+		}
+	}
+	return res
+}
+
+func sessionSubscription(s *melody.Session) *subscription {
+	if v, ok := s.Get(subscriptionKey); ok {
+		return v.(*subscription)
+	}
+	return nil
+}
+
+// handleSubscribe changes the subscription of s if msg is a subscribeRequest.
+func (b *API) handleSubscribe(s *melody.Session, msg []byte) bool {
+	var req subscribeRequest
+	if err := json.Unmarshal(msg, &req); err != nil || req.Subscribe == nil {
+		return false
+	}
+	b.Log.Debugf("websocket client %s subscribed to %#v", s.Request.RemoteAddr, *req.Subscribe)
+	s.Set(subscriptionKey, req.Subscribe)
+	return true
+}
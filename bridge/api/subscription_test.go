@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionMatches(t *testing.T) {
+	sub := newSubscription(url.Values{"gateway": {"gw1, gw2"}, "event": {"message", "msg_delete"}})
+	assert.Equal(t, []string{"gw1", "gw2"}, sub.Gateways)
+	assert.Empty(t, sub.Channels)
+	assert.True(t, sub.matches(config.Message{Gateway: "gw2", Channel: "general"}))
+	assert.True(t, sub.matches(config.Message{Gateway: "gw1", Event: config.EventMsgDelete}))
+	assert.False(t, sub.matches(config.Message{Gateway: "gw3"}))
+	assert.False(t, sub.matches(config.Message{Gateway: "gw1", Event: config.EventJoinLeave}))
+	assert.True(t, (*subscription)(nil).matches(config.Message{Gateway: "gw3"}))
+}
+
+func dialWebsocket(t *testing.T, srv *httptest.Server, query string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/websocket"+query, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	var greeting config.Message
+	require.NoError(t, conn.ReadJSON(&greeting))
+	assert.Equal(t, config.EventAPIConnected, greeting.Event)
+	return conn
+}
+
+func TestWebsocketSubscription(t *testing.T) {
+	b := newTestAPI(t, "")
+	b.mrouter = b.newWebsocketRouter()
+	e := echo.New()
+	e.GET("/api/websocket", b.handleWebsocket)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	all := dialWebsocket(t, srv, "")
+	gw2 := dialWebsocket(t, srv, "?gateway=gw2")
+	actions := dialWebsocket(t, srv, "")
+	// a client can change its subscription
+	require.NoError(t, actions.WriteJSON(map[string]interface{}{"subscribe": map[string]interface{}{"events": []string{"user_action"}}}))
+	// the subscribe request is read asynchronously
+	time.Sleep(100 * time.Millisecond)
+
+	_, err := b.Send(config.Message{Text: "one", Gateway: "gw1"})
+	require.NoError(t, err)
+	_, err = b.Send(config.Message{Text: "two", Gateway: "gw2"})
+	require.NoError(t, err)
+
+	var msg config.Message
+	require.NoError(t, all.ReadJSON(&msg))
+	assert.Equal(t, "one", msg.Text)
+	require.NoError(t, all.ReadJSON(&msg))
+	assert.Equal(t, "two", msg.Text)
+	require.NoError(t, gw2.ReadJSON(&msg))
+	assert.Equal(t, "two", msg.Text)
+
+	// messages sent over the socket are relayed and sent to the other clients that match them
+	require.NoError(t, gw2.WriteJSON(config.Message{Text: "three", Username: "web", Gateway: "gw1"}))
+	rmsg := <-b.Remote
+	assert.Equal(t, "three", rmsg.Text)
+	assert.Equal(t, "api", rmsg.Channel)
+	require.NoError(t, all.ReadJSON(&msg))
+	assert.Equal(t, "three", msg.Text)
+
+	actions.SetReadDeadline(time.Now().Add(100 * time.Millisecond)) //nolint:errcheck
+	assert.Error(t, actions.ReadJSON(&msg))
+}
+
+func TestSendLegacyJoinLeave(t *testing.T) {
+	b := newTestAPI(t, "LegacyJoinLeave=true")
+	for _, event := range []string{config.EventJoin, config.EventKick, config.EventUserAction} {
+		_, err := b.Send(config.Message{Event: event, Gateway: "gw1"})
+		require.NoError(t, err)
+	}
+	msgs := b.dequeue(10)
+	require.Len(t, msgs, 3)
+	assert.Equal(t, config.EventJoinLeave, msgs[0].Event)
+	assert.Equal(t, config.EventJoinLeave, msgs[1].Event)
+	assert.Equal(t, config.EventUserAction, msgs[2].Event)
+
+	b = newTestAPI(t, "")
+	_, err := b.Send(config.Message{Event: config.EventPart, Gateway: "gw1"})
+	require.NoError(t, err)
+	assert.Equal(t, config.EventPart, b.dequeue(1)[0].Event)
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/hook/signature"
+	"github.com/labstack/echo/v4"
+)
+
+const webhookTimeout = 10 * time.Second
+
+// webhookMessage is the body of POST /api/webhook, see the api section of
+// matterbridge.toml.sample. Unlike /api/message it doesn't take the internal
+// config.Message, so it stays the same when matterbridge changes.
+type webhookMessage struct {
+	Text      string        `json:"text"`
+	Username  string        `json:"username"`
+	UserID    string        `json:"userid"`
+	Avatar    string        `json:"avatar"`
+	Gateway   string        `json:"gateway"`
+	Event     string        `json:"event"`
+	ID        string        `json:"id"`
+	ParentID  string        `json:"parent_id"`
+	Timestamp time.Time     `json:"timestamp"`
+	Files     []webhookFile `json:"files"`
+}
+
+type webhookFile struct {
+	Name    string `json:"name"`
+	Comment string `json:"comment"`
+	// URL is sent as a link when there's no Data
+	URL  string `json:"url"`
+	Data []byte `json:"data"` // base64
+}
+
+// handlePostWebhook relays a webhookMessage. Messages with an id can be edited by posting
+// them again with the same id and deleted with the event "msg_delete".
+func (b *API) handlePostWebhook(c echo.Context) error {
+	var wm webhookMessage
+	if err := c.Bind(&wm); err != nil {
+		return err
+	}
+	switch wm.Event {
+	case "", config.EventUserAction, config.EventMsgDelete:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "unsupported event "+wm.Event)
+	}
+	if wm.Event == config.EventMsgDelete && wm.ID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "id is required to delete a message")
+	}
+	if wm.Gateway == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "gateway is required")
+	}
+	rmsg := config.Message{
+		Text:      wm.Text,
+		Channel:   "api",
+		Username:  wm.Username,
+		UserID:    wm.UserID,
+		Avatar:    wm.Avatar,
+		Account:   b.Account,
+		Event:     wm.Event,
+		Protocol:  "api",
+		Gateway:   wm.Gateway,
+		ParentID:  wm.ParentID,
+		ID:        wm.ID,
+		Timestamp: wm.Timestamp,
+		Extra:     make(map[string][]interface{}),
+	}
+	if rmsg.Timestamp.IsZero() {
+		rmsg.Timestamp = time.Now()
+	}
+	if rmsg.Event == config.EventMsgDelete {
+		rmsg.Text = config.EventMsgDelete
+	}
+	for _, f := range wm.Files {
+		if len(f.Data) == 0 {
+			if f.URL != "" {
+				rmsg.Text = strings.TrimSpace(rmsg.Text + "\n" + strings.TrimSpace(f.Comment+" "+f.URL))
+			}
+			continue
+		}
+		if err := helper.HandleDownloadSize(b.Log, &rmsg, f.Name, int64(len(f.Data)), b.General); err != nil {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+		}
+		data := f.Data
+		helper.HandleDownloadData(b.Log, &rmsg, f.Name, f.Comment, f.URL, &data, b.General)
+	}
+	if rmsg.Text == "" && len(rmsg.Extra["file"]) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "text or files are required")
+	}
+	b.Log.Debugf("Sending webhook message from %s on %s to gateway", rmsg.Username, "api")
+	b.Remote <- rmsg
+	return c.NoContent(http.StatusAccepted)
+}
+
+// sendWebhook posts msg to WebhookURL, as the JSON of config.Message or with the template
+// WebhookPayload. The body is signed with WebhookSigningSecret. When the response is a JSON
+// object with an "id", it's used as the ID of the message, so edits and deletes get it.
+func (b *API) sendWebhook(msg config.Message) (string, error) {
+	body, err := b.webhookPayload(msg)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, b.GetString("WebhookURL"), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret := b.GetString("WebhookSigningSecret"); secret != "" {
+		(&signature.GitHub{Secret: secret}).Sign(req.Header, body)
+	}
+	resp, err := b.HTTPClient(webhookTimeout).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("webhook returned %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	var reply struct {
+		ID string `json:"id"`
+	}
+	if json.Unmarshal(data, &reply) == nil {
+		return reply.ID, nil
+	}
+	return "", nil
+}
+
+// webhookPayload returns the body of the webhook of msg. The placeholders of WebhookPayload
+// are replaced by the JSON escaped fields of msg, to be used within JSON strings, except
+// {FILES} which is a JSON array of the links of the files.
+func (b *API) webhookPayload(msg config.Message) ([]byte, error) {
+	tmpl := b.GetString("WebhookPayload")
+	if tmpl == "" {
+		return json.Marshal(msg)
+	}
+	files := []string{}
+	for _, f := range msg.Extra["file"] {
+		if fi, ok := f.(config.FileInfo); ok && fi.URL != "" {
+			files = append(files, fi.URL)
+		}
+	}
+	filesJSON, err := json.Marshal(files)
+	if err != nil {
+		return nil, err
+	}
+	payload := strings.NewReplacer(
+		"{TEXT}", jsonEscape(msg.Text),
+		"{NICK}", jsonEscape(msg.Username),
+		"{USERID}", jsonEscape(msg.UserID),
+		"{AVATAR}", jsonEscape(msg.Avatar),
+		"{CHANNEL}", jsonEscape(msg.Channel),
+		"{GATEWAY}", jsonEscape(msg.Gateway),
+		"{PROTOCOL}", jsonEscape(msg.Protocol),
+		"{ACCOUNT}", jsonEscape(msg.Account),
+		"{EVENT}", jsonEscape(msg.Event),
+		"{ID}", jsonEscape(msg.ID),
+		"{PARENTID}", jsonEscape(msg.ParentID),
+		"{TIMESTAMP}", msg.Timestamp.Format(time.RFC3339),
+		"{FILES}", string(filesJSON),
+	).Replace(tmpl)
+	if !json.Valid([]byte(payload)) {
+		return nil, fmt.Errorf("WebhookPayload is not valid JSON: %s", payload)
+	}
+	return []byte(payload), nil
+}
+
+// jsonEscape returns s escaped for a JSON string, without the quotes.
+func jsonEscape(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data[1 : len(data)-1])
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/hook/signature"
+	"github.com/labstack/echo/v4"
+	"github.com/olahol/melody"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAPI(t *testing.T, cfg string) *API {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	br := bridge.New(&config.Bridge{Account: "api.local"})
+	br.Config = config.NewConfigFromString(logger, []byte("[api.local]\n"+cfg))
+	br.General = &config.Protocol{MediaDownloadSize: 1000000}
+	br.Log = logrus.NewEntry(logger)
+	return &API{Config: &bridge.Config{Bridge: br, Remote: make(chan config.Message, 10)}, mrouter: melody.New()}
+}
+
+func TestSendWebhook(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- r
+		bodies <- string(body)
+		fmt.Fprint(w, `{"id":"42"}`)
+	}))
+	defer srv.Close()
+
+	b := newTestAPI(t, fmt.Sprintf(`WebhookURL=%q
+WebhookSigningSecret="secret"
+WebhookPayload='{"content":"{NICK}: {TEXT}","files":{FILES}}'
+`, srv.URL))
+	id, err := b.Send(config.Message{Username: "bob", Text: "say \"hi\"\n", Protocol: "irc", Extra: map[string][]interface{}{
+		"file": {config.FileInfo{Name: "a.png", URL: "https://example.com/a.png"}},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, "42", id)
+	req, body := <-requests, <-bodies
+	assert.Equal(t, `{"content":"bob: say \"hi\"\n","files":["https://example.com/a.png"]}`, body)
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.NoError(t, (&signature.GitHub{Secret: "secret"}).Verify(req.Header, []byte(body)))
+
+	// deletes of messages the webhook didn't return an ID for aren't sent
+	_, err = b.Send(config.Message{Event: config.EventMsgDelete})
+	require.NoError(t, err)
+	_, err = b.Send(config.Message{Event: config.EventMsgDelete, ID: "42", Text: config.EventMsgDelete})
+	require.NoError(t, err)
+	<-requests
+	assert.Contains(t, <-bodies, "msg_delete")
+
+	b = newTestAPI(t, fmt.Sprintf("WebhookURL=%q\nWebhookPayload='{\"text\":{TEXT}}'\n", srv.URL))
+	_, err = b.Send(config.Message{Text: "not quoted"})
+	assert.Error(t, err)
+}
+
+func TestSendWebhookDefaultPayload(t *testing.T) {
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	b := newTestAPI(t, fmt.Sprintf("WebhookURL=%q\n", srv.URL))
+	id, err := b.Send(config.Message{Username: "bob", Text: "hello", Gateway: "gw1"})
+	require.NoError(t, err)
+	assert.Equal(t, "", id)
+	body := <-bodies
+	assert.Contains(t, body, `"text":"hello"`)
+	assert.Contains(t, body, `"gateway":"gw1"`)
+}
+
+func postWebhook(t *testing.T, b *API, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/webhook", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	err := b.handlePostWebhook(echo.New().NewContext(req, rec))
+	if he, ok := err.(*echo.HTTPError); ok {
+		rec.Code = he.Code
+	} else {
+		require.NoError(t, err)
+	}
+	return rec
+}
+
+func TestPostWebhook(t *testing.T) {
+	b := newTestAPI(t, "")
+
+	rec := postWebhook(t, b, `{"text":"hello","username":"ci","gateway":"gw1","id":"build-1",
+		"files":[{"name":"log.txt","data":"aGk="},{"comment":"see","url":"https://example.com/build/1"}]}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	select {
+	case rmsg := <-b.Remote:
+		assert.Equal(t, "hello\nsee https://example.com/build/1", rmsg.Text)
+		assert.Equal(t, "ci", rmsg.Username)
+		assert.Equal(t, "gw1", rmsg.Gateway)
+		assert.Equal(t, "api", rmsg.Channel)
+		assert.Equal(t, "api", rmsg.Protocol)
+		assert.Equal(t, "api.local", rmsg.Account)
+		assert.Equal(t, "build-1", rmsg.ID)
+		assert.False(t, rmsg.Timestamp.IsZero())
+		if assert.Len(t, rmsg.Extra["file"], 1) {
+			fi := rmsg.Extra["file"][0].(config.FileInfo)
+			assert.Equal(t, "log.txt", fi.Name)
+			data, err := fi.Bytes()
+			assert.NoError(t, err)
+			assert.Equal(t, "hi", string(data))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+	}
+
+	rec = postWebhook(t, b, `{"event":"msg_delete","gateway":"gw1","id":"build-1"}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	rmsg := <-b.Remote
+	assert.Equal(t, config.EventMsgDelete, rmsg.Event)
+	assert.Equal(t, "build-1", rmsg.ID)
+
+	for _, body := range []string{
+		`{"text":"hello"}`,
+		`{"text":"hello","gateway":"gw1","event":"join_leave"}`,
+		`{"event":"msg_delete","gateway":"gw1"}`,
+		`{"gateway":"gw1"}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, postWebhook(t, b, body).Code, body)
+	}
+}
@@ -1,12 +1,17 @@
 package bridge
 
 import (
+	"errors"
 	"log"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/listener"
+	"github.com/42wim/matterbridge/bridge/store"
+	"github.com/42wim/matterbridge/hook/signature"
 	"github.com/sirupsen/logrus"
 )
 
@@ -15,6 +20,115 @@ type Bridger interface {
 	Connect() error
 	JoinChannel(channel config.ChannelInfo) error
 	Disconnect() error
+	// Reload is called when the configuration cfg was reloaded, the bridge stays connected.
+	// The settings read with the Get functions already have their new value, bridges
+	// that keep settings applied at Connect can apply them here.
+	Reload(cfg config.Config) error
+}
+
+// DirectMessenger is implemented by bridges that can send a private message to a user.
+type DirectMessenger interface {
+	SendDirect(userID, text string) error
+}
+
+// MessageMap gives access to the IDs a message has on the different bridges of a gateway,
+// as kept by the router to relay edits, deletes and replies.
+type MessageMap interface {
+	// MessageMappings returns the mappings in all gateways the message with ID id on account is part of.
+	MessageMappings(account, id string) []MessageMapping
+	// LinkMessages adds the messages of m to the mapping of the message m.ID on m.Account.
+	LinkMessages(m MessageMapping) error
+	// TranslateID returns the ID on account dest in channel of the message with ID id on
+	// account, in gateway. It's used to reply to the copy of a message on another bridge.
+	TranslateID(gateway, account, id, dest, channel string) (string, bool)
+}
+
+// UserLister is implemented by bridges that know the users in their channels.
+type UserLister interface {
+	// ChannelUsers returns the names of the users in channel.
+	ChannelUsers(channel string) ([]string, error)
+}
+
+// Permalinker is implemented by bridges that can link to their messages.
+type Permalinker interface {
+	// Permalink returns the URL of the message with ID id in channel.
+	Permalink(channel, id string) (string, bool)
+}
+
+// HistoryFetcher is implemented by bridges that can fetch the history of their channels.
+type HistoryFetcher interface {
+	// FetchHistory sends the messages sent in channel since since, at most limit and
+	// oldest first, to the gateway with config.ExtraBackfill set.
+	FetchHistory(channel string, since time.Time, limit int) error
+}
+
+// Capabilities is implemented by bridges that support replies and reactions depending on
+// the server they're connected to, like IRC servers with IRCv3 message-tags. It overrides
+// bridgemap.ReplySupport and bridgemap.ReactionSupport.
+type Capabilities interface {
+	// NativeReplies returns true when messages with a ParentID are sent as replies.
+	NativeReplies() bool
+	// NativeReactions returns true when reactions are sent as reactions.
+	NativeReactions() bool
+}
+
+// Commands runs the commands users give on a bridge, like the slash commands of discord.
+type Commands interface {
+	// RunCommand runs cmd, given in channel on account, and returns the reply.
+	RunCommand(account, channel, cmd string) (string, error)
+}
+
+// CredentialsExpirer is implemented by bridges that know when their token, cookie or
+// session stops working.
+type CredentialsExpirer interface {
+	// CredentialsExpiry returns when the credentials expire, false when it isn't known.
+	CredentialsExpiry() (time.Time, bool)
+}
+
+// ErrorClassifier is implemented by bridges that can tell the errors of Send that sending
+// the message again won't fix, like a message that's too big or a channel the bot can't
+// post in. The offline queue drops these messages instead of retrying them.
+type ErrorClassifier interface {
+	// PermanentError returns true if err, returned by Send, fails the same way every time.
+	PermanentError(err error) bool
+}
+
+// PermanentStatus returns true for the HTTP status codes of the requests that fail the same
+// way when they're sent again: the 4xx but 401 (the credentials, not the message), 408 and
+// 429.
+func PermanentStatus(code int) bool {
+	return code >= 400 && code < 500 && code != http.StatusUnauthorized &&
+		code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
+
+// ErrNoLoginChannel is returned by Login when no LoginAccount and LoginChannel are
+// configured, the bridges then prompt on the console.
+var ErrNoLoginChannel = errors.New("no LoginAccount and LoginChannel configured")
+
+// Login posts the prompts of interactive logins, like the QR code to pair a device, in
+// the login channel so headless servers don't need a console.
+type Login interface {
+	// LoginNotice posts text about the login of account, with image if it isn't nil.
+	LoginNotice(account, text string, image *config.FileInfo) error
+	// LoginPrompt posts text about the login of account and returns the answer given in
+	// the login channel, or an error when there's none within timeout.
+	LoginPrompt(account, text string, timeout time.Duration) (string, error)
+}
+
+// MessageMapping is a message and its counterparts on the other bridges of a gateway.
+type MessageMapping struct {
+	Gateway  string       `json:"gateway"`
+	Account  string       `json:"account,omitempty"` // only known when looked up with the original message
+	Protocol string       `json:"protocol"`
+	ID       string       `json:"id"`
+	Messages []MessageRef `json:"messages"`
+}
+
+// MessageRef is a relayed copy of a message.
+type MessageRef struct {
+	Account string `json:"account"`
+	Channel string `json:"channel"`
+	ID      string `json:"id"`
 }
 
 type Bridge struct {
@@ -30,6 +144,14 @@ type Bridge struct {
 	Log            *logrus.Entry
 	Config         config.Config
 	General        *config.Protocol
+	Store          store.Store
+	MessageMap     MessageMap
+	Commands       Commands
+	Login          Login
+
+	// activeServer and serverIndex track the endpoint selected by ConnectFailover
+	activeServer string
+	serverIndex  int
 }
 
 type Config struct {
@@ -38,6 +160,11 @@ type Config struct {
 	Remote chan config.Message
 }
 
+// Reload is the Reload of the bridges that read their settings when they're used.
+func (c *Config) Reload(cfg config.Config) error {
+	return nil
+}
+
 // Factory is the factory function to create a bridge
 type Factory func(*Config) Bridger
 
@@ -61,7 +188,43 @@ func New(bridge *config.Bridge) *Bridge {
 }
 
 func (b *Bridge) JoinChannels() error {
-	return b.joinChannels(b.Channels, b.Joined)
+	return b.joinChannels(b.GetChannels(), b.Joined)
+}
+
+// GetChannels returns a copy of the channels of the bridge. The router changes them when
+// the configuration is reloaded, while the bridge may be reading them eg to reconnect.
+func (b *Bridge) GetChannels() map[string]config.ChannelInfo {
+	b.RLock()
+	defer b.RUnlock()
+	channels := make(map[string]config.ChannelInfo, len(b.Channels))
+	for ID, channel := range b.Channels {
+		channels[ID] = channel
+	}
+	return channels
+}
+
+// SetChannel adds channel to the channels of the bridge.
+func (b *Bridge) SetChannel(channel config.ChannelInfo) {
+	b.Lock()
+	b.Channels[channel.ID] = channel
+	b.Unlock()
+}
+
+// RemoveChannel removes the channel ID from the channels of the bridge, it isn't joined
+// again when the bridge reconnects.
+func (b *Bridge) RemoveChannel(ID string) {
+	b.Lock()
+	delete(b.Channels, ID)
+	delete(b.Joined, ID)
+	b.Unlock()
+}
+
+// ClearChannels removes all the channels of the bridge, before they're added again by the
+// reloaded configuration.
+func (b *Bridge) ClearChannels() {
+	b.Lock()
+	b.Channels = make(map[string]config.ChannelInfo)
+	b.Unlock()
 }
 
 // SetChannelMembers sets the newMembers to the bridge ChannelMembers
@@ -86,6 +249,42 @@ func (b *Bridge) joinChannels(channels map[string]config.ChannelInfo, exists map
 	return nil
 }
 
+// WebhookVerifier returns the verifier for incoming webhook requests configured
+// with WebhookSigningSecret, defaultMode is used when WebhookSignature isn't set.
+func (b *Bridge) WebhookVerifier(defaultMode string) signature.Verifier {
+	mode := b.GetString("WebhookSignature")
+	if mode == "" {
+		mode = defaultMode
+	}
+	v, err := signature.New(mode, b.GetString("WebhookSigningSecret"))
+	if err != nil {
+		b.Log.Fatalf("%s: %s", b.Account, err)
+	}
+	return v
+}
+
+// ListenerConfig returns the listener.Config of address, with the SocketMode and ACME
+// settings of the bridge or of the [general] section.
+func (b *Bridge) ListenerConfig(address string) listener.Config {
+	return listener.Config{
+		Address:          address,
+		SocketMode:       b.GetString("SocketMode"),
+		ACMEDomains:      b.GetStringSlice("ACMEDomains"),
+		ACMECacheDir:     b.GetString("ACMECacheDir"),
+		ACMEEmail:        b.GetString("ACMEEmail"),
+		ACMEDirectoryURL: b.GetString("ACMEDirectoryURL"),
+		ACMEHTTPAddress:  b.GetString("ACMEHTTPAddress"),
+	}
+}
+
+// NewBucket returns a bucket in the shared store that is private to this bridge.
+func (b *Bridge) NewBucket(name string) *store.Bucket {
+	if b.Store == nil {
+		b.Store = store.NewMemory()
+	}
+	return store.NewBucket(b.Store, name+":"+b.Account)
+}
+
 func (b *Bridge) GetConfigKey(key string) string {
 	return b.Account + "." + key
 }
@@ -2,6 +2,7 @@ package config
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -15,7 +16,12 @@ import (
 )
 
 const (
-	EventJoinLeave         = "join_leave"
+	EventJoinLeave         = "join_leave" // when the bridge can't tell a join from a leave
+	EventJoin              = "join"
+	EventPart              = "part"
+	EventQuit              = "quit" // left the server, Channel is empty when it's for all channels
+	EventKick              = "kick"
+	EventBan               = "ban"
 	EventTopicChange       = "topic_change"
 	EventFailure           = "failure"
 	EventFileFailureSize   = "file_failure_size"
@@ -25,13 +31,84 @@ const (
 	EventMsgDelete         = "msg_delete"
 	EventFileDelete        = "file_delete"
 	EventAPIConnected      = "api_connected"
+	EventAPIKeepAlive      = "api_keepalive"
 	EventUserTyping        = "user_typing"
 	EventGetChannelMembers = "get_channel_members"
 	EventNoticeIRC         = "notice_irc"
+	EventReactionAdd       = "reaction_add"
+	EventReactionRemove    = "reaction_remove"
+	EventUserVerified      = "user_verified"
+	EventBridgeStatus      = "bridge_status"
+	EventReloadConfig      = "reload_config"
+	EventBridgeEnable      = "bridge_enable"  // sent by the admin API, without Account, Text is the account to enable
+	EventBridgeDisable     = "bridge_disable" // sent by the admin API, without Account, Text is the account to disable
+	EventPresence          = "presence"        // Text is online, away, dnd or offline
+	EventSlowmode          = "slowmode"        // Text is the seconds between the messages of a user, 0 when it's off
+	EventThreadArchived    = "thread_archived" // ThreadID is the root message of the thread that was archived
+	// EventUserBan is a ban by a moderator of the user UserID (Username) in Channel, or on
+	// the whole server when it's empty. Text is the reason.
+	EventUserBan = "user_ban"
+	// EventMessageRemoveRequest is the removal of the message ID by a moderator, the message
+	// and its copies are removed on the bridges with ApplyModeration.
+	EventMessageRemoveRequest = "msg_remove_request"
+	// EventCallStarted is a voice or video call started by Username in the voice channel or
+	// room Text, in Channel or on the whole server when it's empty. See ExtraCallURL.
+	EventCallStarted = "call_started"
+	// EventNickChange is the change of the nick of Username (UserID) to Text, in Channel or
+	// on the whole server when it's empty.
+	EventNickChange = "nick_change"
 )
 
 const ParentIDNotFound = "msg-parent-not-found"
 
+const (
+	// ExtraUserCreated is the Message.Extra key with the creation time (time.Time) of the
+	// account of the sender, set by bridges that know it.
+	ExtraUserCreated = "user_created"
+	// ExtraForwarded is the Message.Extra key set by bridges for forwarded messages.
+	ExtraForwarded = "forwarded"
+	// ForwardedUnknown is the Message.ForwardedFrom of forwarded messages whose source is hidden.
+	ForwardedUnknown = "unknown"
+	// ExtraBot is the Message.Extra key set by bridges for messages sent by bots.
+	ExtraBot = "bot"
+	// ExtraBackfill is the Message.Extra key set by bridges for messages fetched from the
+	// history of a channel after a reconnect, and on imported messages.
+	ExtraBackfill = "backfill"
+	// ExtraQuote is the Message.Extra key with the Quote of the message a reply replies to,
+	// set by bridges that quote replies. The gateway adds the quote to the text for the
+	// destinations that can't reply to the parent message natively.
+	ExtraQuote = "quote"
+	// ExtraPriority is the Message.Extra key with the priority ("important" or "urgent") of
+	// the message, set by bridges with message priorities.
+	ExtraPriority = "priority"
+	// ExtraRequestedAck is the Message.Extra key set by bridges for messages whose sender
+	// requested an acknowledgement.
+	ExtraRequestedAck = "requested_ack"
+	// ExtraRoles is the Message.Extra key with the roles (strings) of the sender, set by
+	// bridges that know them: the names of the discord roles and the channel modes of IRC
+	// users (owner, admin, op, halfop and voice).
+	ExtraRoles = "roles"
+	// ExtraBanned is the Message.Extra key with the user ID and/or name (strings) of the
+	// user banned by an EventBan, set by bridges that know them.
+	ExtraBanned = "banned"
+	// ExtraCallURL is the Message.Extra key with the link to join the call of an
+	// EventCallStarted, ExtraCallVideo is set for video calls.
+	ExtraCallURL   = "call_url"
+	ExtraCallVideo = "call_video"
+)
+
+// The priorities of ExtraPriority.
+const (
+	PriorityImportant = "important"
+	PriorityUrgent    = "urgent"
+)
+
+// Quote is the message a reply replies to, see ExtraQuote.
+type Quote struct {
+	Username string
+	Text     string
+}
+
 type Message struct {
 	Text      string    `json:"text"`
 	Channel   string    `json:"channel"`
@@ -43,9 +120,39 @@ type Message struct {
 	Protocol  string    `json:"protocol"`
 	Gateway   string    `json:"gateway"`
 	ParentID  string    `json:"parent_id"`
+	ThreadID  string    `json:"thread_id"` // ID of the root message of the thread on the bridge
 	Timestamp time.Time `json:"timestamp"`
 	ID        string    `json:"id"`
 	Extra     map[string][]interface{}
+
+	// ForwardedFrom is the user or channel a forwarded message was forwarded from, or
+	// ForwardedUnknown. The gateway adds it to the text with the ForwardedFormat of the
+	// destination.
+	ForwardedFrom string `json:"forwarded_from,omitempty"`
+}
+
+// IsJoinLeave returns true if event is EventJoinLeave or one of the join, part, quit,
+// kick and ban events.
+func IsJoinLeave(event string) bool {
+	switch event {
+	case EventJoinLeave, EventJoin, EventPart, EventQuit, EventKick, EventBan:
+		return true
+	}
+	return false
+}
+
+// LegacyEvent returns EventJoinLeave for the join, part, quit, kick and ban events, which
+// were all relayed as join_leave before, and event otherwise.
+func LegacyEvent(event string) string {
+	if IsJoinLeave(event) {
+		return EventJoinLeave
+	}
+	return event
+}
+
+// IsForwarded returns true if m was forwarded, with or without a ForwardedFrom.
+func (m Message) IsForwarded() bool {
+	return m.ForwardedFrom != "" || (m.Extra != nil && len(m.Extra[ExtraForwarded]) > 0)
 }
 
 func (m Message) ParentNotFound() bool {
@@ -57,14 +164,25 @@ func (m Message) ParentValid() bool {
 }
 
 type FileInfo struct {
-	Name     string
-	Data     *[]byte
+	Name string
+	// Data is the content of the file. Deprecated: it's kept for the bridges that read it
+	// directly, use Open or Bytes instead. Media is used when Data is nil.
+	Data        *[]byte
+	Media       *Media `json:"-"`
+	ContentType string
+	// Caption is the text sent with the file. The gateway renders it in Comment for every
+	// destination, bridges read Comment when sending and may set either when receiving.
+	Caption  string
 	Comment  string
 	URL      string
 	Size     int64
 	Avatar   bool
 	SHA      string
 	NativeID string
+	// Thumbnail is a small preview of an image, uploaded with the file to the mediaserver at
+	// ThumbnailURL.
+	Thumbnail    *Media `json:"-"`
+	ThumbnailURL string
 }
 
 type ChannelInfo struct {
@@ -74,6 +192,7 @@ type ChannelInfo struct {
 	ID          string
 	SameChannel map[string]bool
 	Options     ChannelOptions
+	Untrusted   bool
 }
 
 type ChannelMember struct {
@@ -86,103 +205,285 @@ type ChannelMember struct {
 
 type ChannelMembers []ChannelMember
 
+// MessageFormat overrides RemoteNickFormat and MessageTemplate for a class of messages
+// (action, system, bot, notice, media).
+type MessageFormat struct {
+	RemoteNickFormat string
+	MessageTemplate  string
+}
+
 type Protocol struct {
-	AllowMention           []string // discord
-	AuthCode               string   // steam
-	BindAddress            string   // mattermost, slack // DEPRECATED
-	Buffer                 int      // api
-	Charset                string   // irc
-	ClientID               string   // msteams
-	ColorNicks             bool     // only irc for now
-	Debug                  bool     // general
-	DebugLevel             int      // only for irc now
-	DisableWebPagePreview  bool     // telegram
-	EditSuffix             string   // mattermost, slack, discord, telegram, gitter
-	EditDisable            bool     // mattermost, slack, discord, telegram, gitter
-	HTMLDisable            bool     // matrix
-	IconURL                string   // mattermost, slack
-	IgnoreFailureOnStart   bool     // general
-	IgnoreNicks            string   // all protocols
-	IgnoreMessages         string   // all protocols
-	Jid                    string   // xmpp
-	JoinDelay              string   // all protocols
-	Label                  string   // all protocols
-	Login                  string   // mattermost, matrix
-	LogFile                string   // general
-	MediaDownloadBlackList []string
-	MediaDownloadPath      string // Basically MediaServerUpload, but instead of uploading it, just write it to a file on the same server.
-	MediaDownloadSize      int    // all protocols
-	MediaServerDownload    string
-	MediaServerUpload      string
-	MediaConvertTgs        string     // telegram
-	MediaConvertWebPToPNG  bool       // telegram
-	MessageDelay           int        // IRC, time in millisecond to wait between messages
-	MessageFormat          string     // telegram
-	MessageLength          int        // IRC, max length of a message allowed
-	MessageQueue           int        // IRC, size of message queue for flood control
-	MessageSplit           bool       // IRC, split long messages with newlines on MessageLength instead of clipping
-	MessageSplitMaxCount   int        // discord, split long messages into at most this many messages instead of clipping (MessageLength=1950 cannot be configured)
-	Muc                    string     // xmpp
-	MxID                   string     // matrix
-	Name                   string     // all protocols
-	Nick                   string     // all protocols
-	NickFormatter          string     // mattermost, slack
-	NickServNick           string     // IRC
-	NickServUsername       string     // IRC
-	NickServPassword       string     // IRC
-	NicksPerRow            int        // mattermost, slack
-	NoHomeServerSuffix     bool       // matrix
-	NoSendJoinPart         bool       // all protocols
-	NoTLS                  bool       // mattermost, xmpp
-	Password               string     // IRC,mattermost,XMPP,matrix
-	PrefixMessagesWithNick bool       // mattemost, slack
-	PreserveThreading      bool       // slack
-	Protocol               string     // all protocols
-	QuoteDisable           bool       // telegram,discord
-	QuoteFormat            string     // telegram,discord
-	QuoteLengthLimit       int        // telegram,discord
-	RealName               string     // IRC
-	RejoinDelay            int        // IRC
-	ReplaceMessages        [][]string // all protocols
-	ReplaceNicks           [][]string // all protocols
-	RemoteNickFormat       string     // all protocols
-	RunCommands            []string   // IRC
-	Server                 string     // IRC,mattermost,XMPP,discord,matrix
-	SessionFile            string     // msteams,whatsapp
-	ShowJoinPart           bool       // all protocols
-	ShowTopicChange        bool       // slack
-	ShowUserTyping         bool       // slack
-	ShowEmbeds             bool       // discord
-	SkipTLSVerify          bool       // IRC, mattermost
-	SkipVersionCheck       bool       // mattermost
-	StripNick              bool       // all protocols
-	StripMarkdown          bool       // irc
-	SyncTopic              bool       // slack
-	TengoModifyMessage     string     // general
-	Team                   string     // mattermost, keybase
-	TeamID                 string     // msteams
-	TenantID               string     // msteams
-	Token                  string     // gitter, slack, discord, api, matrix
-	Topic                  string     // zulip
-	URL                    string     // mattermost, slack // DEPRECATED
-	UseAPI                 bool       // mattermost, slack
-	UseLocalAvatar         []string   // discord
-	UseSASL                bool       // IRC
-	UseTLS                 bool       // IRC
-	UseDiscriminator       bool       // discord
-	UseFirstName           bool       // telegram
-	UseUserName            bool       // discord, matrix, mattermost
-	UseInsecureURL         bool       // telegram
-	UserName               string     // IRC
-	VerboseJoinPart        bool       // IRC
-	WebhookBindAddress     string     // mattermost, slack
-	WebhookURL             string     // mattermost, slack
+	ACMECacheDir              string                   // api, matrix, general
+	ACMEDirectoryURL          string                   // api, matrix, general
+	ACMEDomains               []string                 // api, matrix, general
+	ACMEEmail                 string                   // api, matrix, general
+	ACMEHTTPAddress           string                   // api, matrix, general
+	AdminBindAddress          string                   // general
+	AdminDashboard            bool                     // general
+	AdminProfiling            bool                     // general
+	AdminToken                string                   // general
+	AllowMention              []string                 // discord, zulip
+	ApplyModeration           bool                     // all protocols
+	AppService                bool                     // matrix
+	AppServiceBindAddress     string                   // matrix
+	AppServiceToken           string                   // matrix
+	AppServiceURL             string                   // matrix
+	AppToken                  string                   // slack, app-level token (xapp-) for Socket Mode
+	ArchiveDays               int                      // general
+	ArchivePath               string                   // general
+	AuthCode                  string                   // steam
+	Backfill                  bool                     // discord, matrix, slack
+	BackfillLimit             int                      // discord, matrix, slack
+	BackfillMaxAge            int                      // discord, matrix, slack
+	BindAddress               string                   // mattermost, slack // DEPRECATED
+	BindInterface             string                   // irc, discord, matrix, mumble, nostr, slack, telegram, zulip
+	BotAPIURL                 string                   // telegram
+	BreakerCooldown           int                      // all protocols
+	BreakerThreshold          int                      // all protocols
+	Buffer                    int                      // api
+	CallFormat                string                   // all protocols
+	CaptionMode               string                   // all protocols, embed, message or drop
+	ChaosDelay                int                      // all protocols, ChaosMode only
+	ChaosDropPercent          int                      // all protocols, ChaosMode only
+	ChaosErrorPercent         int                      // all protocols, ChaosMode only
+	ChaosMode                 bool                     // general
+	Charset                   string                   // irc
+	ClientID                  string                   // msteams
+	ColorNicks                bool                     // only irc for now
+	CredentialsWarnDays       int                      // general
+	Debug                     bool                     // general
+	DebugLevel                int                      // only for irc now
+	DialFallbackDelay         int                      // irc, discord, matrix, mumble, nostr, slack, telegram, zulip
+	DisableWebPagePreview     bool                     // telegram
+	EditCoalesceDelay         int                      // all protocols
+	EditFallback              string                   // all protocols
+	EditHistory               int                      // general
+	EditMaxAge                int                      // all protocols
+	EditSuffix                string                   // mattermost, slack, discord, telegram, gitter
+	EditDisable               bool                     // mattermost, slack, discord, telegram, gitter
+	EmojiMap                  [][]string               // rocketchat
+	Format                    map[string]MessageFormat // all protocols
+	ForwardedFormat           string                   // all protocols
+	GRPCBindAddress           string                   // api
+	HistoryCommandUsers       []string                 // general
+	HomeserverToken           string                   // matrix
+	HTMLDisable               bool                     // matrix
+	HTTPHeaders               [][]string               // all http based protocols
+	IconURL                   string                   // mattermost, slack
+	IdentityLinking           bool                     // general
+	IdentityNames             bool                     // general
+	IgnoreCommandUsers        []string                 // general
+	IgnoreFailureOnStart      bool                     // general
+	IgnoreNicks               string                   // all protocols
+	IgnoreMessages            string                   // all protocols
+	IgnoreRemoteUsers         []string                 // all protocols
+	IPVersion                 string                   // irc, discord, matrix, nostr, slack, telegram, zulip
+	Jid                       string                   // xmpp
+	JoinDelay                 string                   // all protocols
+	Label                     string                   // all protocols
+	LegacyJoinLeave           bool                     // api, general
+	LinkCommandPersistent     bool                     // general
+	LinkCommandPrefix         string                   // general
+	LinkCommandTTL            int                      // general
+	LinkCommandUsers          []string                 // general
+	LoadBalanceAccounts       []string                 // all protocols
+	LocalAddress              string                   // irc, discord, matrix, mumble, nostr, slack, telegram, zulip
+	Login                     string                   // mattermost, matrix
+	LogFile                   string                   // general
+	LoginAccount              string                   // general
+	LoginChannel              string                   // general
+	LoginUsers                []string                 // general
+	LowMemory                 bool                     // general
+	MediaConvertImages        string                   // general, png or jpeg, the format of the converted webp, heic and tiff images
+	MediaDownloadBandwidth    int                      // general, KB/s
+	MediaDownloadBlackList    []string
+	MediaDownloadParallel     int    // general
+	MediaDownloadPath         string // Basically MediaServerUpload, but instead of uploading it, just write it to a file on the same server.
+	MediaDownloadPerBridge    int    // general
+	MediaDownloadSize         int    // all protocols
+	MediaMaxDimension         int    // general, pixels, the bigger images are scaled down
+	MediaMaxImageSize         int    // general, bytes, the bigger images are compressed
+	MediaMaxSize              int    // all protocols, bytes, the bigger files are relayed as a link
+	MediaS3AccessKey          string // general
+	MediaS3ACL                string // general
+	MediaS3Bucket             string // general
+	MediaS3Endpoint           string // general, for S3 compatible storage like MinIO
+	MediaS3Region             string // general
+	MediaS3SecretKey          string // general
+	MediaServerAvatars        bool   // all protocols, copy the avatars of the messages sent to this bridge to the mediaserver
+	MediaServerDownload       string
+	MediaServerUpload         string
+	MediaConvertTgs           string     // telegram
+	MediaConvertWebPToPNG     bool       // telegram
+	MediaThumbnails           bool       // all protocols, send the thumbnail of an image with a link instead of the image
+	MediaThumbnailSize        int        // general, pixels, the size of the thumbnails of the images
+	MediaUploadBackend        string     // general, s3 to upload to S3 instead of MediaServerUpload
+	MentionFormat             string     // all protocols
+	MessageDelay              int        // IRC, time in millisecond to wait between messages
+	MessageFormat             string     // telegram
+	MessageIDStore            string     // general
+	MessageIDStorePath        string     // general
+	MessageLength             int        // IRC, max length of a message allowed
+	MessageQueue              int        // IRC, size of message queue for flood control
+	MessagesPerMinute         int        // telegram
+	MessageSplit              bool       // IRC, split long messages with newlines on MessageLength instead of clipping
+	MessageSplitMaxCount      int        // discord, split long messages into at most this many messages instead of clipping (MessageLength=1950 cannot be configured)
+	MessageTemplate           string     // all protocols
+	MetricsBindAddress        string     // general
+	Muc                       string     // xmpp
+	MxID                      string     // matrix
+	Name                      string     // all protocols
+	Nick                      string     // all protocols
+	NickFormatter             string     // mattermost, slack
+	NickServNick              string     // IRC
+	NickServUsername          string     // IRC
+	NickServPassword          string     // IRC
+	NicksPerRow               int        // mattermost, slack
+	NoHomeServerSuffix        bool       // matrix
+	NoSendJoinPart            bool       // all protocols
+	NoTLS                     bool       // mattermost, xmpp
+	OfflineQueueMaxAttempts   int        // all protocols
+	OfflineQueueRetryDelay    int        // all protocols
+	OfflineQueueRetryMaxDelay int        // all protocols
+	OfflineQueueSize          int        // all protocols
+	OfflineQueueTTL           int        // all protocols
+	Password                  string     // IRC,mattermost,XMPP,matrix
+	PermalinkFormat           string     // discord, matrix, mattermost, slack, telegram
+	PrefixMessagesWithNick    bool       // mattemost, slack
+	PreserveThreading         bool       // slack
+	PrivateKey                string     // nostr, secret key as nsec or hex
+	Protocol                  string     // all protocols
+	PuppetIdleTimeout         int        // matrix, IRC, seconds
+	PuppetPoolSize            int        // IRC, default 10
+	PuppetPrefix              string     // matrix
+	Puppets                   bool       // IRC, a connection for every remote user
+	QuoteDisable              bool       // telegram,discord
+	QuoteFormat               string     // telegram,discord
+	QuoteLengthLimit          int        // telegram,discord
+	RateLimit                 int        // all protocols, messages a minute sent to the bridge
+	RateLimitBurst            int        // all protocols
+	RealName                  string     // IRC
+	RejoinDelay               int        // IRC
+	Relays                    []string   // nostr
+	ReloadOnConfigChange      bool       // general
+	ReplaceMessages           [][]string // all protocols
+	ReplaceNicks              [][]string // all protocols
+	RemoteNickFormat          string     // all protocols
+	ReplyContext              bool       // all protocols, quote the parent of replies on bridges without replies
+	ReplyContextFormat        string     // all protocols
+	ReplyContextLength        int        // all protocols, default 80
+	ResolveWellKnown          bool       // matrix
+	RunCommands               []string   // IRC
+	SelfReportInterval        int        // general
+	Server                    string     // IRC,mattermost,XMPP,discord,matrix
+	Servers                   []string   // xmpp, matrix, mattermost
+	ShardCount                int        // discord
+	ShowCalls                 bool       // all protocols
+	ShowNickChange            bool       // all protocols
+	ShowPresence              bool       // xmpp
+	ShowReactions             bool       // all protocols
+	SlashCommands             bool       // discord
+	StreamBatchDelay          int        // api, time in millisecond to collect messages in a batch
+	StreamBatchSize           int        // api
+	StreamCompression         string     // api
+	StreamKeepAlive           int        // api, seconds between keepalive messages
+	SessionFile               string     // msteams,whatsapp
+	ShowJoinPart              bool       // all protocols
+	ShowTopicChange           bool       // slack
+	ShowUserTyping            bool       // slack
+	ShowEmbeds                bool       // discord
+	SkipTLSVerify             bool       // IRC, mattermost
+	SocketMode                string     // api, general
+	SkipVersionCheck          bool       // mattermost
+	StripNick                 bool       // all protocols
+	StripMarkdown             bool       // irc
+	StoreBackend              string     // general
+	StorePath                 string     // general
+	StoreRedisAddress         string     // general
+	StoreRedisPassword        string     // general
+	StoreRedisDB              int        // general
+	SyncTopic                 bool       // slack
+	TengoModifyMessage        string     // general
+	Team                      string     // mattermost, keybase
+	TeamID                    string     // msteams
+	TenantID                  string     // msteams
+	TestCommandTimeout        int        // general
+	TestCommandUsers          []string   // general
+	TimestampDelay            int        // all protocols
+	TimestampFormat           string     // all protocols
+	TimestampTimezone         string     // all protocols
+	Token                     string     // gitter, slack, discord, api, matrix
+	Topic                     string     // zulip
+	URL                       string     // mattermost, slack // DEPRECATED
+	UseAPI                    bool       // mattermost, slack
+	UseLocalAvatar            []string   // discord
+	UserAgent                 string     // all http based protocols
+	UserOptOut                bool       // general
+	UserRateLimit             int        // all protocols, messages a minute of a single user sent to the bridge
+	UseSASL                   bool       // IRC
+	UseTLS                    bool       // IRC
+	UseDiscriminator          bool       // discord
+	UseFirstName              bool       // telegram
+	UseUserName               bool       // discord, matrix, mattermost
+	UseInsecureURL            bool       // telegram
+	UserName                  string     // IRC
+	VerboseJoinPart           bool       // IRC
+	WebhookBindAddress        string     // mattermost, slack
+	WebhookPayload            string     // api, template of the JSON body of outgoing webhooks
+	WebhookURL                string     // api, mattermost, slack
+	WebhookSignature          string     // api, mattermost, rocketchat, slack
+	WebhookSigningSecret      string     // api, mattermost, rocketchat, slack
 }
 
 type ChannelOptions struct {
 	Key        string // irc, xmpp
 	WebhookURL string // discord
 	Topic      string // zulip
+
+	// content policy for messages sent to this channel
+	StripImages        bool
+	StripFiles         bool
+	StripLinks         bool
+	AllowedLinkDomains []string
+	DropForwards       bool
+	Redact             []string // email, phone, ip, creditcard or a regular expression
+	RedactReplacement  string
+
+	// DropUnknownForwards drops the forwarded messages without a known source, with
+	// AllowedForwardSources only the forwards from these sources are relayed, see
+	// Message.ForwardedFrom
+	DropUnknownForwards   bool
+	AllowedForwardSources []string
+
+	// UrgentMention is added to urgent messages sent to this channel, e.g. <@&roleid> to
+	// ping a role on discord
+	UrgentMention string
+
+	// summary mode of this channel, for mirrors of busy channels: above SummaryThreshold
+	// messages a minute only some messages are relayed, SummaryMode is sample or digest
+	SummaryThreshold int
+	SummaryMode      string
+	SummaryNotice    string
+
+	// RequireRoles relays the messages of this channel only when the sender has one of
+	// these roles, see config.ExtraRoles
+	RequireRoles []string
+
+	// RateLimit is the number of messages a minute sent to this channel, the messages over
+	// it are coalesced like for the RateLimit of the bridge
+	RateLimit int
+
+	// NoiseFilter is drop or aggregate, for the messages that are only emoji, a sticker or
+	// a GIF, NoiseKinds are emoji, sticker and gif (default all of them)
+	NoiseFilter string
+	NoiseKinds  []string
+
+	// QuietHours are the "[days ]HH:MM-HH:MM" periods the messages to this channel are held
+	// and sent as one digest afterwards, with QuietNotice, or dropped with QuietMode drop
+	QuietHours    []string
+	QuietTimezone string
+	QuietMode     string
+	QuietNotice   string
 }
 
 type Bridge struct {
@@ -190,14 +491,143 @@ type Bridge struct {
 	Channel     string
 	Options     ChannelOptions
 	SameChannel bool
+	Untrusted   bool
 }
 
 type Gateway struct {
-	Name   string
-	Enable bool
-	In     []Bridge
-	Out    []Bridge
-	InOut  []Bridge
+	Name         string
+	Enable       bool
+	In           []Bridge
+	Out          []Bridge
+	InOut        []Bridge
+	Moderation   Moderation
+	Quarantine   Quarantine
+	Quota        Quota
+	Verification Verification
+	Summary      Summary
+	Script       string   // tengo or lua script run on the messages before they're relayed
+	TypingRate   int      // typing and presence events a minute sent to a channel
+	OnlyUsers    []string // usernames or user IDs, only their messages are relayed when set
+	Slowmode     string   // queue or drop, how the slowmode of a channel applies to relayed messages
+	MediaMaxSize int      // bytes, the bigger files are relayed as a link
+	JoinLeave    JoinLeave
+	Alerts       Alerts
+	Migration    Migration
+	Spam         Spam
+	Mentions     Mentions
+}
+
+// Alerts posts an alert in a channel when messages match rules, like keyword lists.
+type Alerts struct {
+	Account string
+	Channel string
+	Mention string // prepended to the alerts, eg @here
+	Rules   []AlertRule
+}
+
+// AlertRule matches the messages with keywords, or all messages without them, and alerts
+// when a user sends Threshold matching messages in Window.
+type AlertRule struct {
+	Name         string
+	Keywords     []string // case-insensitive words or phrases
+	KeywordsFile string   // file with a keyword on each line
+	Threshold    int      // default 1
+	Window       int      // seconds, default 60
+}
+
+// JoinLeave configures which join, part, quit, kick and ban events are relayed. The
+// events that aren't set follow the ShowJoinPart setting of the destination.
+type JoinLeave struct {
+	ShowJoins     *bool
+	ShowParts     *bool
+	ShowQuits     *bool
+	ShowKicks     *bool
+	ShowBans      *bool
+	CollapseQuits bool // relay the quits of a netsplit as one message
+}
+
+// Migration moves the community of a channel of the gateway to another channel of the
+// gateway. During the transition the messages relayed to one of them are sent to both, but
+// only the messages of the primary channel are relayed.
+type Migration struct {
+	FromAccount string
+	FromChannel string
+	ToAccount   string
+	ToChannel   string
+	Primary     string // from (default) or to
+	Until       string // 2006-01-02, the From channel is left alone from this day on
+}
+
+// Moderation configures the channel where messages from untrusted channels
+// are held until a moderator approves them.
+type Moderation struct {
+	Account    string
+	Channel    string
+	Moderators []string // account:userid or account:username, nobody can approve when empty
+	Timeout    int      // seconds before a held message is discarded
+}
+
+// Quarantine configures what happens with messages from new accounts.
+type Quarantine struct {
+	MinAccountAge int    // days, only for bridges that know the account creation time
+	MinMessages   int    // messages seen from the account before
+	Action        string // drop (default), flag or moderate
+}
+
+// Quota limits how much users and channels can relay.
+type Quota struct {
+	UserMessages    int    // messages per hour per user
+	UserMedia       int    // MB of files per day per user
+	ChannelMessages int    // messages per hour per channel
+	ChannelMedia    int    // MB of files per day per channel
+	Action          string // drop (default), throttle or notify
+}
+
+// Spam filters the spam and abuse relayed by the gateway, and drops the messages of the
+// users on its ban list.
+type Spam struct {
+	Patterns           []string // regular expressions
+	Keywords           []string // case-insensitive words or phrases
+	KeywordsFile       string   // file with a keyword on each line
+	BlockedDomains     []string // links to these domains and their subdomains
+	BlockedDomainsFile string   // file with a domain on each line
+	MaxMentions        int      // mentions in a message at most
+	Action             string   // drop (default), replace or notify
+	Replacement        string   // replaces the matches with action replace, default "[removed]"
+	Account            string   // the channel notified of the spam with action notify
+	Channel            string
+	Moderators         []string // usernames or user IDs who ban with a reply to a notice, everyone in the channel when empty
+	BanList            string   // name of the ban list, shared by the gateways with the same BanList
+	Banned             []string // account:userid or account:username, always on the ban list
+	SyncBans           bool     // add the users banned on the bridges of the gateway to the ban list
+}
+
+// Mentions translates the @nick mentions typed on a bridge of a gateway into the mentions of
+// the same user on the other bridges, which notify them.
+type Mentions struct {
+	Identities [][]string // the accounts of a user as account:userid or account:nick, eg ["telegram.main:alice", "slack.work:U123"]
+	Learn      bool       // link the users with the same username on the bridges of the gateway
+}
+
+// Summary posts summaries of the messages of a gateway, made by an OpenAI-compatible
+// chat completions endpoint, in a channel.
+type Summary struct {
+	URL               string // chat completions endpoint
+	Token             string // API key, sent as bearer token
+	Model             string
+	Prompt            string // system prompt
+	Interval          int    // minutes between summaries
+	MinMessages       int    // messages needed for a summary
+	Account           string // where the summaries are posted
+	Channel           string
+	Redact            []string // redaction rules applied before the messages are sent out
+	RedactReplacement string
+}
+
+// Verification requires users to pass a challenge before their messages are relayed.
+type Verification struct {
+	Mode string // challenge (emoji challenge) or url (external verification)
+	URL  string // verification URL for mode url, {TOKEN} is replaced by the verification token
 }
 
 type Tengo struct {
@@ -205,6 +635,7 @@ type Tengo struct {
 	Message          string
 	RemoteNickFormat string
 	OutMessage       string
+	RateLimit        int
 }
 
 type SameChannelGateway struct {
@@ -247,14 +678,19 @@ type Config interface {
 	GetString(key string) (string, bool)
 	GetStringSlice(key string) ([]string, bool)
 	GetStringSlice2D(key string) ([][]string, bool)
+	// Reload reads the configuration file again. The values of the Get functions change right
+	// away, of BridgeValues only the accounts and gateways are replaced.
+	Reload() error
 }
 
 type config struct {
 	sync.RWMutex
 
-	logger *logrus.Entry
-	v      *viper.Viper
-	cv     *BridgeValues
+	logger  *logrus.Entry
+	v       *viper.Viper
+	cv      *BridgeValues
+	path    string
+	cfgtype string
 }
 
 // NewConfig instantiates a new configuration based on the specified configuration file path.
@@ -281,13 +717,50 @@ func NewConfig(rootLogger *logrus.Logger, cfgfile string) Config {
 	if mycfg.cv.General.MediaDownloadSize == 0 {
 		mycfg.cv.General.MediaDownloadSize = 1000000
 	}
-	viper.WatchConfig()
-	viper.OnConfigChange(func(e fsnotify.Event) {
-		logger.Println("Config file changed:", e.Name)
-	})
+	mycfg.path = cfgfile
 	return mycfg
 }
 
+// WatchFile calls fn when the file cfgfile changes. The directory is watched, as editors
+// often replace the file instead of writing it, and changes within a second are collected.
+func WatchFile(logger *logrus.Entry, cfgfile string, fn func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	cfgfile = filepath.Clean(cfgfile)
+	if err := watcher.Add(filepath.Dir(cfgfile)); err != nil {
+		watcher.Close()
+		return err
+	}
+	go func() {
+		defer watcher.Close()
+		var timer *time.Timer
+		for {
+			select {
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(e.Name) != cfgfile || e.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				logger.Println("Config file changed:", e.Name)
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(time.Second, fn)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Errorf("Watching %s failed: %s", cfgfile, err)
+			}
+		}
+	}()
+	return nil
+}
+
 // detectConfigType detects JSON and YAML formats, defaults to TOML.
 func detectConfigType(cfgfile string) string {
 	fileExt := filepath.Ext(cfgfile)
@@ -321,10 +794,59 @@ func newConfigFromString(logger *logrus.Entry, input []byte, cfgtype string) *co
 		logger.Fatalf("Failed to load the configuration: %s", err)
 	}
 	return &config{
-		logger: logger,
-		v:      viper.GetViper(),
-		cv:     cfg,
+		logger:  logger,
+		v:       viper.GetViper(),
+		cv:      cfg,
+		cfgtype: cfgtype,
+	}
+}
+
+func (c *config) Reload() error {
+	if c.path == "" {
+		return errors.New("the configuration wasn't read from a file")
+	}
+	input, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return err
+	}
+	// check the new configuration first, a failed read empties viper
+	v := viper.New()
+	v.SetConfigType(c.cfgtype)
+	if err := v.ReadConfig(bytes.NewBuffer(input)); err != nil {
+		return err
+	}
+	cv := &BridgeValues{}
+	if err := v.Unmarshal(cv); err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	if err := c.v.ReadConfig(bytes.NewBuffer(input)); err != nil {
+		return err
 	}
+	// the settings of [general] and [tengo] in the struct are only read at startup, the
+	// bridges keep a pointer to General
+	c.cv.API = cv.API
+	c.cv.IRC = cv.IRC
+	c.cv.Mattermost = cv.Mattermost
+	c.cv.Matrix = cv.Matrix
+	c.cv.Slack = cv.Slack
+	c.cv.SlackLegacy = cv.SlackLegacy
+	c.cv.Steam = cv.Steam
+	c.cv.Gitter = cv.Gitter
+	c.cv.XMPP = cv.XMPP
+	c.cv.Discord = cv.Discord
+	c.cv.Telegram = cv.Telegram
+	c.cv.Rocketchat = cv.Rocketchat
+	c.cv.SSHChat = cv.SSHChat
+	c.cv.WhatsApp = cv.WhatsApp
+	c.cv.Zulip = cv.Zulip
+	c.cv.Keybase = cv.Keybase
+	c.cv.Mumble = cv.Mumble
+	c.cv.Gateway = cv.Gateway
+	c.cv.SameChannelGateway = cv.SameChannelGateway
+	return nil
 }
 
 func (c *config) BridgeValues() *BridgeValues {
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// MediaReader reads the content of a file attached to a message, sequentially or at an offset.
+// It must be closed when done.
+type MediaReader interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Media is the content of a file attached to a message, kept in memory or on disk.
+// Bridges read it with Open so uploads can be streamed instead of copied for every destination.
+type Media struct {
+	size        int64
+	contentType string
+	data        []byte
+	path        string
+	file        *os.File
+}
+
+// NewMemoryMedia returns media for data. The content type is detected when it's empty.
+func NewMemoryMedia(data []byte, contentType string) *Media {
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return &Media{size: int64(len(data)), contentType: contentType, data: data}
+}
+
+// NewFileMedia returns media for the file at path. The content type is detected when it's empty.
+func NewFileMedia(path, contentType string) (*Media, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Media{size: st.Size(), contentType: contentType, path: path}
+	if contentType == "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		head := make([]byte, 512)
+		n, err := io.ReadFull(f, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, err
+		}
+		m.contentType = http.DetectContentType(head[:n])
+	}
+	return m, nil
+}
+
+// NewTempMedia returns media for data written to a temporary file, so data doesn't need to
+// stay in memory. The file is removed right away on systems that allow it and goes away
+// when the media isn't used anymore.
+func NewTempMedia(data []byte, contentType string) (*Media, error) {
+	return NewTempMediaReader(bytes.NewReader(data), contentType)
+}
+
+// NewTempMediaReader is NewTempMedia for the content read from r, which is copied to the
+// temporary file without being kept in memory.
+func NewTempMediaReader(r io.Reader, contentType string) (*Media, error) {
+	f, err := ioutil.TempFile("", "matterbridge-media-")
+	if err != nil {
+		return nil, err
+	}
+	size, err := io.Copy(f, r)
+	if err == nil && contentType == "" {
+		head := make([]byte, 512)
+		var n int
+		n, err = f.ReadAt(head, 0)
+		if err == io.EOF {
+			err = nil
+		}
+		contentType = http.DetectContentType(head[:n])
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	m := &Media{size: size, contentType: contentType, file: f}
+	// the open file can't be removed on windows, read it from the path instead
+	if err := os.Remove(f.Name()); err != nil {
+		f.Close()
+		m.file, m.path = nil, f.Name()
+	}
+	return m, nil
+}
+
+// Size returns the size of the media in bytes.
+func (m *Media) Size() int64 {
+	return m.size
+}
+
+// ContentType returns the MIME type of the media.
+func (m *Media) ContentType() string {
+	return m.contentType
+}
+
+// OnDisk returns true if the media is kept in a file instead of in memory.
+func (m *Media) OnDisk() bool {
+	return m.file != nil || m.path != ""
+}
+
+// Open returns a new reader for the media, every reader starts at the beginning.
+func (m *Media) Open() (MediaReader, error) {
+	if m.file != nil {
+		return fileReader{io.NewSectionReader(m.file, 0, m.size)}, nil
+	}
+	if m.path != "" {
+		return os.Open(m.path)
+	}
+	return memoryReader{bytes.NewReader(m.data)}, nil
+}
+
+// Bytes returns the content of the media, files on disk are read in memory.
+func (m *Media) Bytes() ([]byte, error) {
+	if m.file != nil {
+		return ioutil.ReadAll(io.NewSectionReader(m.file, 0, m.size))
+	}
+	if m.path != "" {
+		return ioutil.ReadFile(m.path)
+	}
+	return m.data, nil
+}
+
+type memoryReader struct {
+	*bytes.Reader
+}
+
+func (memoryReader) Close() error {
+	return nil
+}
+
+// fileReader reads a temporary file that is shared by all readers of the media, it's
+// closed when the media is garbage collected.
+type fileReader struct {
+	*io.SectionReader
+}
+
+func (fileReader) Close() error {
+	return nil
+}
+
+// Open returns a reader for the content of the file, from Media or else from Data.
+func (f FileInfo) Open() (MediaReader, error) {
+	if f.Media != nil {
+		return f.Media.Open()
+	}
+	if f.Data != nil {
+		return memoryReader{bytes.NewReader(*f.Data)}, nil
+	}
+	return memoryReader{bytes.NewReader(nil)}, nil
+}
+
+// Bytes returns the content of the file, from Data or else from Media.
+// Prefer Open, Bytes reads files on disk in memory.
+func (f FileInfo) Bytes() ([]byte, error) {
+	if f.Data != nil {
+		return *f.Data, nil
+	}
+	if f.Media != nil {
+		return f.Media.Bytes()
+	}
+	return nil, nil
+}
+
+// CaptionText returns the caption of the file, its Comment when the bridge that received it
+// didn't set Caption.
+func (f FileInfo) CaptionText() string {
+	if f.Caption != "" {
+		return f.Caption
+	}
+	return f.Comment
+}
+
+// DataSize returns the size of the content of the file, or Size when the content
+// wasn't downloaded.
+func (f FileInfo) DataSize() int64 {
+	switch {
+	case f.Media != nil:
+		return f.Media.Size()
+	case f.Data != nil:
+		return int64(len(*f.Data))
+	}
+	return f.Size
+}
+
+// MimeType returns the content type of the file, if known.
+func (f FileInfo) MimeType() string {
+	if f.ContentType != "" {
+		return f.ContentType
+	}
+	if f.Media != nil {
+		return f.Media.ContentType()
+	}
+	return ""
+}
+
+// WithData returns a copy of f with Data filled from Media, for the bridges that still
+// read Data directly.
+func (f FileInfo) WithData() (FileInfo, error) {
+	if f.Data != nil || f.Media == nil {
+		return f, nil
+	}
+	data, err := f.Media.Bytes()
+	if err != nil {
+		return f, err
+	}
+	f.Data = &data
+	return f, nil
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryMedia(t *testing.T) {
+	m := NewMemoryMedia([]byte("hello world"), "")
+	assert.Equal(t, int64(11), m.Size())
+	assert.Equal(t, "text/plain; charset=utf-8", m.ContentType())
+
+	r, err := m.Open()
+	require.NoError(t, err)
+	defer r.Close()
+	b := make([]byte, 5)
+	_, err = r.ReadAt(b, 6)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(b))
+	data, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+}
+
+func TestFileMedia(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, ioutil.WriteFile(path, []byte("on disk"), 0o600))
+	m, err := NewFileMedia(path, "application/x-test")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), m.Size())
+	assert.Equal(t, "application/x-test", m.ContentType())
+
+	data, err := m.Bytes()
+	require.NoError(t, err)
+	assert.Equal(t, "on disk", string(data))
+
+	_, err = NewFileMedia(filepath.Join(t.TempDir(), "missing"), "")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestTempMedia(t *testing.T) {
+	m, err := NewTempMedia([]byte("hello world"), "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), m.Size())
+	assert.Equal(t, "text/plain; charset=utf-8", m.ContentType())
+	assert.Nil(t, m.data)
+
+	for i := 0; i < 2; i++ {
+		r, err := m.Open()
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(data))
+		require.NoError(t, r.Close())
+	}
+	data, err := m.Bytes()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+}
+
+func TestTempMediaReader(t *testing.T) {
+	m, err := NewTempMediaReader(strings.NewReader("streamed"), "")
+	require.NoError(t, err)
+	assert.True(t, m.OnDisk())
+	assert.Equal(t, int64(8), m.Size())
+	assert.Equal(t, "text/plain; charset=utf-8", m.ContentType())
+	data, err := m.Bytes()
+	require.NoError(t, err)
+	assert.Equal(t, "streamed", string(data))
+
+	m, err = NewTempMediaReader(strings.NewReader(""), "application/x-test")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), m.Size())
+	assert.Equal(t, "application/x-test", m.ContentType())
+	assert.False(t, NewMemoryMedia(nil, "").OnDisk())
+}
+
+func TestFileInfoContent(t *testing.T) {
+	data := []byte("legacy")
+	legacy := FileInfo{Name: "a.txt", Data: &data}
+	assert.Equal(t, int64(6), legacy.DataSize())
+	r, err := legacy.Open()
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "legacy", string(b))
+
+	fi := FileInfo{Name: "b.txt", Media: NewMemoryMedia([]byte("media"), "")}
+	assert.Equal(t, int64(5), fi.DataSize())
+	assert.Equal(t, "text/plain; charset=utf-8", fi.MimeType())
+	withData, err := fi.WithData()
+	require.NoError(t, err)
+	require.NotNil(t, withData.Data)
+	assert.Equal(t, "media", string(*withData.Data))
+	assert.Nil(t, fi.Data)
+
+	assert.Equal(t, int64(42), FileInfo{Size: 42}.DataSize())
+}
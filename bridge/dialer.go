@@ -0,0 +1,148 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	ipVersion4       = "4"
+	ipVersion6       = "6"
+	ipVersionPrefer4 = "prefer4"
+	ipVersionPrefer6 = "prefer6"
+)
+
+// Dialer returns a net.Dialer configured with the LocalAddress, BindInterface and
+// DialFallbackDelay settings of this account.
+//
+// Without IPVersion restrictions the dialer races IPv4 and IPv6 (Happy Eyeballs, RFC 6555)
+// and DialFallbackDelay is the time in milliseconds before the fallback family is tried.
+func (b *Bridge) Dialer() (*net.Dialer, error) {
+	d := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if delay := b.GetInt("DialFallbackDelay"); delay != 0 {
+		d.FallbackDelay = time.Duration(delay) * time.Millisecond
+	}
+	local, err := b.localIP()
+	if err != nil {
+		return nil, err
+	}
+	if local != nil {
+		d.LocalAddr = &net.TCPAddr{IP: local}
+	}
+	return d, nil
+}
+
+// localIP returns the local address to bind to, from LocalAddress or BindInterface.
+func (b *Bridge) localIP() (net.IP, error) {
+	if addr := b.GetString("LocalAddress"); addr != "" {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid LocalAddress %s", addr)
+		}
+		return ip, nil
+	}
+	name := b.GetString("BindInterface")
+	if name == "" {
+		return nil, nil
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	ip := interfaceIP(addrs, b.ipVersion())
+	if ip == nil {
+		return nil, fmt.Errorf("no usable address found on interface %s", name)
+	}
+	return ip, nil
+}
+
+// interfaceIP returns the global unicast address of addrs of the family of version, or of
+// the other family when version isn't restricted to one. It returns nil if there's none.
+func interfaceIP(addrs []net.Addr, version string) net.IP {
+	want6 := version == ipVersion6 || version == ipVersionPrefer6
+	var fallback net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || !ipnet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if (ipnet.IP.To4() == nil) == want6 {
+			return ipnet.IP
+		}
+		if fallback == nil {
+			fallback = ipnet.IP
+		}
+	}
+	if version == ipVersion4 || version == ipVersion6 {
+		return nil
+	}
+	return fallback
+}
+
+func (b *Bridge) ipVersion() string {
+	return strings.ToLower(b.GetString("IPVersion"))
+}
+
+// DialContext connects to address like net.Dialer.DialContext, honouring the
+// IPVersion setting of this account ("4", "6", "prefer4", "prefer6").
+func (b *Bridge) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d, err := b.Dialer()
+	if err != nil {
+		return nil, err
+	}
+	if network != "tcp" && network != "udp" {
+		return d.DialContext(ctx, network, address)
+	}
+	switch b.ipVersion() {
+	case ipVersion4:
+		return d.DialContext(ctx, network+"4", address)
+	case ipVersion6:
+		return d.DialContext(ctx, network+"6", address)
+	case ipVersionPrefer4, ipVersionPrefer6:
+		first, second := network+"4", network+"6"
+		if b.ipVersion() == ipVersionPrefer6 {
+			first, second = second, first
+		}
+		conn, err := d.DialContext(ctx, first, address)
+		if err == nil {
+			return conn, nil
+		}
+		b.Log.Debugf("dialing %s over %s failed (%s), trying %s", address, first, err, second)
+		return d.DialContext(ctx, second, address)
+	}
+	return d.DialContext(ctx, network, address)
+}
+
+// Dial is DialContext without a context, for libraries that need a Dial function.
+func (b *Bridge) Dial(network, address string) (net.Conn, error) {
+	return b.DialContext(context.Background(), network, address)
+}
+
+// HTTPTransport returns a http.Transport that dials using DialContext.
+func (b *Bridge) HTTPTransport() *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = b.DialContext
+	return tr
+}
+
+// WebsocketDialer returns a websocket.Dialer that dials using DialContext.
+func (b *Bridge) WebsocketDialer() *websocket.Dialer {
+	return &websocket.Dialer{
+		NetDialContext:   b.DialContext,
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 45 * time.Second,
+	}
+}
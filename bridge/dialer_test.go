@@ -0,0 +1,83 @@
+package bridge
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBridge(t *testing.T, settings string) *Bridge {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	b := New(&config.Bridge{Account: "irc.test"})
+	b.Config = config.NewConfigFromString(logger, []byte("[irc.test]\n"+settings))
+	b.General = &config.Protocol{}
+	b.Log = logrus.NewEntry(logger)
+	return b
+}
+
+func TestInterfaceIP(t *testing.T) {
+	v4 := &net.IPNet{IP: net.ParseIP("192.0.2.10"), Mask: net.CIDRMask(24, 32)}
+	v6 := &net.IPNet{IP: net.ParseIP("2001:db8::10"), Mask: net.CIDRMask(64, 128)}
+	loopback := &net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)}
+	both := []net.Addr{loopback, v4, v6}
+
+	assert.Equal(t, v4.IP, interfaceIP(both, ""))
+	assert.Equal(t, v4.IP, interfaceIP(both, ipVersion4))
+	assert.Equal(t, v6.IP, interfaceIP(both, ipVersion6))
+	assert.Equal(t, v6.IP, interfaceIP(both, ipVersionPrefer6))
+	// the other family when preferred only
+	assert.Equal(t, v4.IP, interfaceIP([]net.Addr{v4}, ipVersionPrefer6))
+	assert.Nil(t, interfaceIP([]net.Addr{v4}, ipVersion6))
+	assert.Nil(t, interfaceIP([]net.Addr{v6}, ipVersion4))
+	// loopback addresses aren't used
+	assert.Nil(t, interfaceIP([]net.Addr{loopback}, ""))
+}
+
+func TestDialer(t *testing.T) {
+	d, err := newTestBridge(t, "LocalAddress=\"127.0.0.1\"\nDialFallbackDelay=100").Dialer()
+	require.NoError(t, err)
+	assert.Equal(t, &net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, d.LocalAddr)
+	assert.Equal(t, int64(100), d.FallbackDelay.Milliseconds())
+
+	d, err = newTestBridge(t, "").Dialer()
+	require.NoError(t, err)
+	assert.Nil(t, d.LocalAddr)
+	assert.Zero(t, d.FallbackDelay)
+
+	_, err = newTestBridge(t, "LocalAddress=\"nope\"").Dialer()
+	assert.Error(t, err)
+	_, err = newTestBridge(t, "BindInterface=\"missing0\"").Dialer()
+	assert.Error(t, err)
+}
+
+func TestDialContextIPVersion(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	addr := ln.Addr().String()
+
+	for _, version := range []string{"", "4", "prefer4", "prefer6"} {
+		conn, err := newTestBridge(t, "IPVersion=\""+version+"\"").DialContext(context.Background(), "tcp", addr)
+		require.NoError(t, err, version)
+		conn.Close()
+	}
+	// an IPv4 address can't be dialed over IPv6 only
+	_, err = newTestBridge(t, "IPVersion=\"6\"").DialContext(context.Background(), "tcp", addr)
+	assert.Error(t, err)
+}
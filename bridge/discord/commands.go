@@ -0,0 +1,69 @@
+package bdiscord
+
+import (
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/bwmarrin/discordgo"
+)
+
+// commandName is the name of the slash command, its subcommands are the commands of
+// bridge.Commands.
+const commandName = "bridge"
+
+var bridgeCommand = &discordgo.ApplicationCommand{
+	Name:        commandName,
+	Description: "Show the bridges of this channel",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "status",
+			Description: "Show the bridges of this channel and whether they're sending",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "who",
+			Description: "Show who is in the bridged channels",
+		},
+	},
+}
+
+// registerCommands registers the /bridge command in the guild of the bridge. The bot needs
+// the applications.commands scope for it.
+func (b *Bdiscord) registerCommands() {
+	if _, err := b.c.ApplicationCommandCreate(b.userID, b.guildID, bridgeCommand); err != nil {
+		b.Log.Errorf("Could not register the /%s command, does the bot have the applications.commands scope? %s", commandName, err)
+	}
+}
+
+// interactionCreate runs the /bridge commands and replies to the user only.
+func (b *Bdiscord) interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.GuildID != b.guildID || i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+	data := i.ApplicationCommandData()
+	if data.Name != commandName || len(data.Options) == 0 {
+		return
+	}
+
+	channelID := i.ChannelID
+	if parentID, ok := b.threadParent(channelID); ok {
+		channelID = parentID
+	}
+	reply := "This channel isn't bridged"
+	if channel := b.getChannelName(channelID); channel != "" && b.Commands != nil {
+		var err error
+		reply, err = b.Commands.RunCommand(b.Account, channel, data.Options[0].Name)
+		if err != nil {
+			reply = err.Error()
+		}
+	}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: helper.ClipMessage(reply, MessageLength, b.GetString("MessageClipped")),
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		b.Log.Errorf("Could not reply to /%s %s: %s", commandName, data.Options[0].Name, err)
+	}
+}
@@ -1,7 +1,7 @@
 package bdiscord
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -17,6 +17,11 @@ import (
 const (
 	MessageLength = 1950
 	cFileUpload   = "file_upload"
+
+	// threadNameLength is the maximum length of the name of a thread.
+	threadNameLength = 100
+	// threadArchiveDuration is the inactivity in minutes after which the threads we start are archived.
+	threadArchiveDuration = 1440
 )
 
 type Bdiscord struct {
@@ -24,6 +29,12 @@ type Bdiscord struct {
 
 	c *discordgo.Session
 
+	// shards are the gateway connections shared with the accounts with the same token, c is
+	// the connection of shard
+	shards   *shardPool
+	shard    int
+	handlers []func()
+
 	nick    string
 	userID  string
 	guildID string
@@ -43,7 +54,7 @@ type Bdiscord struct {
 }
 
 func New(cfg *bridge.Config) bridge.Bridger {
-	newCache, err := lru.New(5000)
+	newCache, err := lru.New(helper.CacheSize(cfg.General, 5000))
 	if err != nil {
 		cfg.Log.Fatalf("Could not create LRU cache: %v", err)
 	}
@@ -64,8 +75,7 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	return b
 }
 
-func (b *Bdiscord) Connect() error {
-	var err error
+func (b *Bdiscord) Connect() (err error) {
 	token := b.GetString("Token")
 	b.Log.Info("Connecting")
 	if !strings.HasPrefix(b.GetString("Token"), "Bot ") {
@@ -76,25 +86,23 @@ func (b *Bdiscord) Connect() error {
 		token = strings.Replace(b.GetString("Token"), "User ", "", -1)
 	}
 
-	b.c, err = discordgo.New(token)
+	// accounts with the same token share the connections to the discord gateway
+	b.shards, err = b.getShardPool(token, !strings.HasPrefix(b.GetString("Token"), "User "))
 	if err != nil {
 		return err
 	}
-	b.Log.Info("Connection succeeded")
-	// Add privileged intent for guild member tracking. This is needed to track nicks
-	// for display names and @mention translation
-	b.c.Identify.Intents = discordgo.MakeIntent(discordgo.IntentsAllWithoutPrivileged |
-		discordgo.IntentsGuildMembers)
+	b.shard = -1
+	defer func() {
+		if err != nil {
+			b.release() //nolint:errcheck
+		}
+	}()
 
-	err = b.c.Open()
+	guilds, err := b.shards.rest.UserGuilds(100, "", "", false)
 	if err != nil {
 		return err
 	}
-	guilds, err := b.c.UserGuilds(100, "", "", false)
-	if err != nil {
-		return err
-	}
-	userinfo, err := b.c.User("@me")
+	userinfo, err := b.shards.rest.User("@me")
 	if err != nil {
 		return err
 	}
@@ -117,7 +125,7 @@ func (b *Bdiscord) Connect() error {
 		}
 
 		// Getting this guild's channel could result in a permission error
-		b.channels, err = b.c.GuildChannels(guild.ID)
+		b.channels, err = b.shards.rest.GuildChannels(guild.ID)
 		if err != nil {
 			return fmt.Errorf("could not get %#v's channels: %w", b.GetString("Server"), err)
 		}
@@ -146,6 +154,12 @@ func (b *Bdiscord) Connect() error {
 		return err
 	}
 
+	b.c, b.shard, err = b.shards.acquire(b.guildID)
+	if err != nil {
+		return err
+	}
+	b.Log.Info("Connection succeeded")
+
 	// Legacy note: WebhookURL used to have an actual webhook URL that we would edit,
 	// but we stopped doing that due to Discord making rate limits more aggressive.
 	//
@@ -169,7 +183,7 @@ func (b *Bdiscord) Connect() error {
 	b.transmitter.Log = b.Log
 
 	var webhookChannelIDs []string
-	for _, channel := range b.Channels {
+	for _, channel := range b.GetChannels() {
 		channelID := b.getChannelID(channel.Name) // note(qaisjp): this readlocks channelsMutex
 
 		// If a WebhookURL was not explicitly provided for this channel,
@@ -223,33 +237,81 @@ func (b *Bdiscord) Connect() error {
 		}
 	}
 
-	b.c.AddHandler(b.messageCreate)
-	b.c.AddHandler(b.messageTyping)
-	b.c.AddHandler(b.messageUpdate)
-	b.c.AddHandler(b.messageDelete)
-	b.c.AddHandler(b.messageDeleteBulk)
-	b.c.AddHandler(b.memberAdd)
-	b.c.AddHandler(b.memberRemove)
-	b.c.AddHandler(b.memberUpdate)
+	b.handlers = []func(){
+		b.c.AddHandler(b.messageCreate),
+		b.c.AddHandler(b.messageTyping),
+		b.c.AddHandler(b.messageUpdate),
+		b.c.AddHandler(b.messageDelete),
+		b.c.AddHandler(b.messageDeleteBulk),
+		b.c.AddHandler(b.messageReactionAdd),
+		b.c.AddHandler(b.messageReactionRemove),
+		b.c.AddHandler(b.memberAdd),
+		b.c.AddHandler(b.memberRemove),
+		b.c.AddHandler(b.memberBan),
+		b.c.AddHandler(b.memberUpdate),
+		b.c.AddHandler(b.channelUpdate),
+		b.c.AddHandler(b.threadUpdate),
+		b.c.AddHandler(b.voiceStateUpdate),
+	}
 	if b.GetInt("debuglevel") == 1 {
-		b.c.AddHandler(b.messageEvent)
+		b.handlers = append(b.handlers, b.c.AddHandler(b.messageEvent))
+	}
+	if b.GetBool("SlashCommands") {
+		b.handlers = append(b.handlers, b.c.AddHandler(b.interactionCreate))
+		b.registerCommands()
 	}
 
 	return nil
 }
 
 func (b *Bdiscord) Disconnect() error {
-	return b.c.Close()
+	return b.release()
+}
+
+// release removes the handlers of the bridge from the shared session and releases it.
+func (b *Bdiscord) release() error {
+	for _, remove := range b.handlers {
+		remove()
+	}
+	b.handlers = nil
+	if b.shards == nil {
+		return nil
+	}
+	err := b.shards.release(b.shard)
+	b.shards = nil
+	return err
 }
 
 func (b *Bdiscord) JoinChannel(channel config.ChannelInfo) error {
 	b.channelsMutex.Lock()
-	defer b.channelsMutex.Unlock()
-
 	b.channelInfoMap[channel.ID] = &channel
+	b.channelsMutex.Unlock()
+
+	// the gateway only hears about the changes of the slowmode from channelUpdate
+	channelID := b.getChannelID(channel.Name)
+	if seconds := b.slowmode(channelID); seconds > 0 {
+		go b.sendSlowmode(channelID, seconds)
+	}
 	return nil
 }
 
+// SendDirect sends text as a private message to the user with userID.
+func (b *Bdiscord) SendDirect(userID, text string) error {
+	ch, err := b.c.UserChannelCreate(userID)
+	if err != nil {
+		return err
+	}
+	_, err = b.c.ChannelMessageSend(ch.ID, text)
+	return err
+}
+
+// PermanentError implements bridge.ErrorClassifier, the requests discord rejects, like a
+// message that's too long or a channel the bot can't post in.
+func (b *Bdiscord) PermanentError(err error) bool {
+	var restErr *discordgo.RESTError
+	return errors.As(err, &restErr) && restErr.Response != nil && bridge.PermanentStatus(restErr.Response.StatusCode)
+}
+
 func (b *Bdiscord) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
@@ -266,6 +328,10 @@ func (b *Bdiscord) Send(msg config.Message) (string, error) {
 		return "", nil
 	}
 
+	if msg.Event == config.EventReactionAdd || msg.Event == config.EventReactionRemove {
+		return "", b.sendReaction(&msg, channelID)
+	}
+
 	// Make a action /me of the message
 	if msg.Event == config.EventUserAction {
 		msg.Text = "_" + msg.Text + "_"
@@ -276,10 +342,23 @@ func (b *Bdiscord) Send(msg config.Message) (string, error) {
 		msg.ParentID = ""
 	}
 
-	// Use webhook to send the message
+	// Send replies in the thread of the root message, the root message itself is in the channel.
+	webhookChannelID, threadID := channelID, ""
+	if msg.ThreadID != "" {
+		channelID = b.threadChannelID(channelID, &msg)
+		if msg.ParentID == msg.ThreadID {
+			msg.ParentID = ""
+		}
+		if channelID != webhookChannelID {
+			threadID = channelID
+		}
+	}
+
+	// Use webhook to send the message, the webhook of a channel also sends to its threads.
+	// Webhooks can't reply to messages.
 	useWebhooks := b.shouldMessageUseWebhooks(&msg)
 	if useWebhooks && msg.Event != config.EventMsgDelete && msg.ParentID == "" {
-		return b.handleEventWebhook(&msg, channelID)
+		return b.handleEventWebhook(&msg, webhookChannelID, threadID)
 	}
 
 	return b.handleEventBotUser(&msg, channelID)
@@ -381,10 +460,14 @@ func (b *Bdiscord) handleEventBotUser(msg *config.Message, channelID string) (st
 func (b *Bdiscord) handleUploadFile(msg *config.Message, channelID string) (string, error) {
 	for _, f := range msg.Extra["file"] {
 		fi := f.(config.FileInfo)
+		r, err := fi.Open()
+		if err != nil {
+			return "", fmt.Errorf("file upload failed: %s", err)
+		}
 		file := discordgo.File{
 			Name:        fi.Name,
-			ContentType: "",
-			Reader:      bytes.NewReader(*fi.Data),
+			ContentType: fi.MimeType(),
+			Reader:      r,
 		}
 		m := discordgo.MessageSend{
 			Content:         msg.Username + fi.Comment,
@@ -392,6 +475,7 @@ func (b *Bdiscord) handleUploadFile(msg *config.Message, channelID string) (stri
 			AllowedMentions: b.getAllowedMentions(),
 		}
 		res, err := b.c.ChannelMessageSendComplex(channelID, &m)
+		r.Close()
 		if err != nil {
 			return "", fmt.Errorf("file upload failed: %s", err)
 		}
@@ -1,8 +1,6 @@
 package bdiscord
 
 import (
-	"strings"
-
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/bwmarrin/discordgo"
 	"github.com/davecgh/go-spew/spew"
@@ -42,6 +40,68 @@ func (b *Bdiscord) messageDeleteBulk(s *discordgo.Session, m *discordgo.MessageD
 	}
 }
 
+func (b *Bdiscord) messageReactionAdd(s *discordgo.Session, m *discordgo.MessageReactionAdd) { //nolint:unparam
+	if m.GuildID != b.guildID {
+		b.Log.Debugf("Ignoring messageReactionAdd because it originates from a different guild")
+		return
+	}
+	rmsg, ok := b.reactionMessage(s, m.MessageReaction, config.EventReactionAdd)
+	if !ok {
+		return
+	}
+	if m.Member != nil && m.Member.User != nil {
+		rmsg.Username = b.getNick(m.Member.User, m.GuildID)
+	}
+
+	b.Log.Debugf("<= Sending message from %s to gateway", b.Account)
+	b.Log.Debugf("<= Message is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
+func (b *Bdiscord) messageReactionRemove(s *discordgo.Session, m *discordgo.MessageReactionRemove) { //nolint:unparam
+	if m.GuildID != b.guildID {
+		b.Log.Debugf("Ignoring messageReactionRemove because it originates from a different guild")
+		return
+	}
+	rmsg, ok := b.reactionMessage(s, m.MessageReaction, config.EventReactionRemove)
+	if !ok {
+		return
+	}
+	if member, err := s.State.Member(m.GuildID, m.UserID); err == nil && member.User != nil {
+		rmsg.Username = b.getNick(member.User, m.GuildID)
+	}
+
+	b.Log.Debugf("<= Sending message from %s to gateway", b.Account)
+	b.Log.Debugf("<= Message is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
+// reactionMessage returns the message for the reaction m, reactions of the bot itself are
+// the ones relayed from the other bridges and are skipped.
+func (b *Bdiscord) reactionMessage(s *discordgo.Session, m *discordgo.MessageReaction, event string) (config.Message, bool) {
+	if s.State.User != nil && m.UserID == s.State.User.ID {
+		return config.Message{}, false
+	}
+	rmsg := config.Message{
+		Account:  b.Account,
+		UserID:   m.UserID,
+		Event:    event,
+		Text:     m.Emoji.Name,
+		ParentID: m.MessageID,
+		Channel:  b.getChannelName(m.ChannelID),
+	}
+	// custom emoji only exist on this server, the other bridges get their name
+	if m.Emoji.ID != "" {
+		rmsg.Text = ":" + m.Emoji.Name + ":"
+	}
+	if rmsg.Channel == "" {
+		if parentID, ok := b.threadParent(m.ChannelID); ok {
+			rmsg.Channel = b.getChannelName(parentID)
+		}
+	}
+	return rmsg, true
+}
+
 func (b *Bdiscord) messageEvent(s *discordgo.Session, m *discordgo.Event) {
 	b.Log.Debug(spew.Sdump(m.Struct))
 }
@@ -84,43 +144,27 @@ func (b *Bdiscord) messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdat
 	}
 }
 
-func (b *Bdiscord) handleQuote(s *discordgo.Session, m *discordgo.Message, msg string) string {
+// handleQuote adds the message m replies to as quote to rmsg.
+func (b *Bdiscord) handleQuote(s *discordgo.Session, m *discordgo.Message, rmsg *config.Message) {
 	if b.GetBool("QuoteDisable") {
-		return msg
+		return
 	}
 	if m.MessageReference == nil {
-		return msg
+		return
 	}
 	refMsgRef := m.MessageReference
 	refMsg, err := s.ChannelMessage(refMsgRef.ChannelID, refMsgRef.MessageID)
 	if err != nil {
 		b.Log.Errorf("Error getting quoted message %s:%s: %s", refMsgRef.ChannelID, refMsgRef.MessageID, err)
-		return msg
+		return
 	}
 
-	quoteMessage := refMsg.Content
 	quoteNick := refMsg.Author.Username
 	fromWebhook := m.WebhookID != ""
 	if !fromWebhook && b.GetBool("UseDiscriminator") {
 		quoteNick += "#" + refMsg.Author.Discriminator
 	}
-
-	format := b.GetString("quoteformat")
-	if format == "" {
-		format = "{MESSAGE} (re @{QUOTENICK}: {QUOTEMESSAGE})"
-	}
-	quoteMessagelength := len([]rune(quoteMessage))
-	if b.GetInt("QuoteLengthLimit") != 0 && quoteMessagelength >= b.GetInt("QuoteLengthLimit") {
-		runes := []rune(quoteMessage)
-		quoteMessage = string(runes[0:b.GetInt("QuoteLengthLimit")])
-		if quoteMessagelength > b.GetInt("QuoteLengthLimit") {
-			quoteMessage += "..."
-		}
-	}
-	format = strings.ReplaceAll(format, "{MESSAGE}", m.Content)
-	format = strings.ReplaceAll(format, "{QUOTENICK}", quoteNick)
-	format = strings.ReplaceAll(format, "{QUOTEMESSAGE}", quoteMessage)
-	return format
+	rmsg.Extra[config.ExtraQuote] = []interface{}{config.Quote{Username: quoteNick, Text: refMsg.Content}}
 }
 
 func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) { //nolint:unparam
@@ -128,6 +172,12 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 		b.Log.Debugf("Ignoring messageCreate because it originates from a different guild")
 		return
 	}
+	b.receiveMessage(s, m.Message, false)
+}
+
+// receiveMessage relays the message m, received or fetched from the history of a channel
+// when backfill is set.
+func (b *Bdiscord) receiveMessage(s *discordgo.Session, m *discordgo.Message, backfill bool) {
 	var err error
 
 	// not relay our own messages
@@ -146,12 +196,12 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 		}
 	}
 
-	rmsg := config.Message{Account: b.Account, Avatar: "https://cdn.discordapp.com/avatars/" + m.Author.ID + "/" + m.Author.Avatar + ".jpg", UserID: m.Author.ID, ID: m.ID}
+	rmsg := config.Message{Account: b.Account, Avatar: "https://cdn.discordapp.com/avatars/" + m.Author.ID + "/" + m.Author.Avatar + ".jpg", UserID: m.Author.ID, ID: m.ID, Extra: make(map[string][]interface{})}
 
-	b.Log.Debugf("== Receiving event %#v", m.Message)
+	b.Log.Debugf("== Receiving event %#v", m)
 
 	if m.Content != "" {
-		m.Message.Content = b.replaceChannelMentions(m.Message.Content)
+		m.Content = b.replaceChannelMentions(m.Content)
 		rmsg.Text, err = m.ContentWithMoreMentionsReplaced(b.c)
 		if err != nil {
 			b.Log.Errorf("ContentWithMoreMentionsReplaced failed: %s", err)
@@ -162,6 +212,14 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 	// set channel name
 	rmsg.Channel = b.getChannelName(m.ChannelID)
 
+	// messages in a thread are relayed to the channel of the thread
+	if rmsg.Channel == "" {
+		if parentID, ok := b.threadParent(m.ChannelID); ok {
+			rmsg.Channel = b.getChannelName(parentID)
+			rmsg.ThreadID = m.ChannelID
+		}
+	}
+
 	fromWebhook := m.WebhookID != ""
 	if !fromWebhook && !b.GetBool("UseUserName") {
 		rmsg.Username = b.getNick(m.Author, m.GuildID)
@@ -172,9 +230,16 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 		}
 	}
 
+	// messages published in a followed channel are forwards of that channel, their author
+	// is the webhook named after it
+	if m.Flags&discordgo.MessageFlagsIsCrossPosted != 0 {
+		rmsg.Extra[config.ExtraForwarded] = []interface{}{true}
+		rmsg.ForwardedFrom = m.Author.Username
+	}
+
 	// if we have embedded content add it to text
-	if b.GetBool("ShowEmbeds") && m.Message.Embeds != nil {
-		for _, embed := range m.Message.Embeds {
+	if b.GetBool("ShowEmbeds") && m.Embeds != nil {
+		for _, embed := range m.Embeds {
 			rmsg.Text += handleEmbed(embed)
 		}
 	}
@@ -195,18 +260,82 @@ func (b *Bdiscord) messageCreate(s *discordgo.Session, m *discordgo.MessageCreat
 	rmsg.Text = replaceEmotes(rmsg.Text)
 
 	// Handle Reply thread
-	rmsg.Text = b.handleQuote(s, m.Message, rmsg.Text)
+	b.handleQuote(s, m, &rmsg)
 
 	// Add our parent id if it exists, and if it's not referring to a message in another channel
 	if ref := m.MessageReference; ref != nil && ref.ChannelID == m.ChannelID {
 		rmsg.ParentID = ref.MessageID
 	}
 
+	rmsg.Timestamp = m.Timestamp
+	// edits are ordered by their time, see the gateway
+	if m.EditedTimestamp != nil {
+		rmsg.Timestamp = *m.EditedTimestamp
+	}
+
+	// discord IDs contain the creation time of the account
+	if !fromWebhook {
+		if created, err := discordgo.SnowflakeTimestamp(m.Author.ID); err == nil {
+			rmsg.Extra[config.ExtraUserCreated] = []interface{}{created}
+		}
+	}
+	if m.Author.Bot {
+		rmsg.Extra[config.ExtraBot] = []interface{}{true}
+	}
+	if roles := b.memberRoles(m); len(roles) > 0 {
+		rmsg.Extra[config.ExtraRoles] = roles
+	}
+	if backfill {
+		rmsg.Extra[config.ExtraBackfill] = []interface{}{true}
+	}
+
 	b.Log.Debugf("<= Sending message from %s on %s to gateway", m.Author.Username, b.Account)
 	b.Log.Debugf("<= Message is %#v", rmsg)
 	b.Remote <- rmsg
 }
 
+// channelUpdate sends the changes of the slowmode of the channels to the gateway.
+func (b *Bdiscord) channelUpdate(s *discordgo.Session, m *discordgo.ChannelUpdate) {
+	if m.GuildID != b.guildID || m.Channel == nil {
+		return
+	}
+	changed := false
+	b.channelsMutex.Lock()
+	for _, channel := range b.channels {
+		if channel.ID == m.ID && channel.RateLimitPerUser != m.RateLimitPerUser {
+			channel.RateLimitPerUser = m.RateLimitPerUser
+			changed = true
+		}
+	}
+	b.channelsMutex.Unlock()
+	if changed {
+		b.sendSlowmode(m.ID, m.RateLimitPerUser)
+	}
+}
+
+// threadUpdate tells the gateway when a thread in one of the channels is archived, the
+// counterparts of the thread get a notice.
+func (b *Bdiscord) threadUpdate(s *discordgo.Session, m *discordgo.ThreadUpdate) {
+	if m.GuildID != b.guildID || m.Channel == nil || m.ThreadMetadata == nil || !m.ThreadMetadata.Archived {
+		return
+	}
+	if m.BeforeUpdate != nil && m.BeforeUpdate.ThreadMetadata != nil && m.BeforeUpdate.ThreadMetadata.Archived {
+		return
+	}
+	channel := b.getChannelName(m.ParentID)
+	if channel == "" {
+		return
+	}
+	b.Log.Debugf("<= Sending thread archive of %s in %s to gateway", m.ID, channel)
+	b.Remote <- config.Message{
+		Event:    config.EventThreadArchived,
+		Channel:  channel,
+		Account:  b.Account,
+		ThreadID: m.ID,
+		Username: "system",
+	}
+}
+
 func (b *Bdiscord) memberUpdate(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
 	if m.GuildID != b.guildID {
 		b.Log.Debugf("Ignoring memberUpdate because it originates from a different guild")
@@ -257,7 +386,7 @@ func (b *Bdiscord) memberAdd(s *discordgo.Session, m *discordgo.GuildMemberAdd)
 
 	rmsg := config.Message{
 		Account:  b.Account,
-		Event:    config.EventJoinLeave,
+		Event:    config.EventJoin,
 		Username: "system",
 		Text:     username + " joins",
 	}
@@ -285,7 +414,7 @@ func (b *Bdiscord) memberRemove(s *discordgo.Session, m *discordgo.GuildMemberRe
 
 	rmsg := config.Message{
 		Account:  b.Account,
-		Event:    config.EventJoinLeave,
+		Event:    config.EventPart,
 		Username: "system",
 		Text:     username + " leaves",
 	}
@@ -294,6 +423,82 @@ func (b *Bdiscord) memberRemove(s *discordgo.Session, m *discordgo.GuildMemberRe
 	b.Remote <- rmsg
 }
 
+func (b *Bdiscord) memberBan(s *discordgo.Session, m *discordgo.GuildBanAdd) {
+	if m.GuildID != b.guildID || m.User == nil {
+		return
+	}
+	// the bridges with ApplyModeration ban the user too
+	b.Remote <- config.Message{
+		Account:  b.Account,
+		Event:    config.EventUserBan,
+		UserID:   m.User.ID,
+		Username: m.User.Username,
+	}
+	if b.GetBool("nosendjoinpart") {
+		return
+	}
+	rmsg := config.Message{
+		Account:  b.Account,
+		Event:    config.EventBan,
+		Username: "system",
+		Text:     m.User.Username + " is banned",
+		Extra:    map[string][]interface{}{config.ExtraBanned: {m.User.ID, m.User.Username}},
+	}
+	b.Log.Debugf("<= Sending message from %s to gateway", b.Account)
+	b.Log.Debugf("<= Message is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
+// voiceStateUpdate announces the calls in the voice channels of the guild, the first member
+// joining a voice channel starts a call.
+func (b *Bdiscord) voiceStateUpdate(s *discordgo.Session, v *discordgo.VoiceStateUpdate) {
+	if v.VoiceState == nil || v.GuildID != b.guildID || v.ChannelID == "" {
+		return
+	}
+	if v.BeforeUpdate != nil && v.BeforeUpdate.ChannelID == v.ChannelID {
+		// muted, deafened or the camera turned on or off
+		return
+	}
+	guild, err := s.State.Guild(b.guildID)
+	if err != nil {
+		return
+	}
+	members := 0
+	s.State.RLock()
+	for _, vs := range guild.VoiceStates {
+		if vs.ChannelID == v.ChannelID {
+			members++
+		}
+	}
+	s.State.RUnlock()
+	// the state is updated before the handlers run
+	if members != 1 {
+		return
+	}
+	username := v.UserID
+	if v.Member != nil && v.Member.User != nil {
+		username = v.Member.User.Username
+		if v.Member.Nick != "" {
+			username = v.Member.Nick
+		}
+	}
+	extra := map[string][]interface{}{config.ExtraCallURL: {"https://discord.com/channels/" + b.guildID + "/" + v.ChannelID}}
+	if v.SelfVideo {
+		extra[config.ExtraCallVideo] = []interface{}{true}
+	}
+	rmsg := config.Message{
+		Account:  b.Account,
+		Event:    config.EventCallStarted,
+		UserID:   v.UserID,
+		Username: username,
+		Text:     b.getChannelName(v.ChannelID),
+		Extra:    extra,
+	}
+	b.Log.Debugf("<= Sending message from %s to gateway", b.Account)
+	b.Log.Debugf("<= Message is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
 func handleEmbed(embed *discordgo.MessageEmbed) string {
 	var t []string
 	var result string
@@ -3,9 +3,12 @@ package bdiscord
 import (
 	"errors"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
 	"github.com/bwmarrin/discordgo"
 )
 
@@ -135,6 +138,67 @@ func (b *Bdiscord) getChannelName(id string) string {
 	return ""
 }
 
+// threadParent returns the ID of the channel of the thread channelID, if channelID is a thread.
+func (b *Bdiscord) threadParent(channelID string) (string, bool) {
+	ch, err := b.c.State.Channel(channelID)
+	if err != nil {
+		if ch, err = b.c.Channel(channelID); err != nil {
+			return "", false
+		}
+	}
+	if !ch.IsThread() {
+		return "", false
+	}
+	return ch.ParentID, true
+}
+
+// threadChannelID returns the ID of the thread started from the message msg.ThreadID in
+// channelID, starting the thread when it doesn't exist yet, or was deleted, and unarchiving
+// it when it's archived. When the thread can't be used msg.ThreadID is cleared and channelID
+// is returned.
+func (b *Bdiscord) threadChannelID(channelID string, msg *config.Message) string {
+	// the ID of a thread started from a message is the ID of that message
+	if ch, err := b.c.State.Channel(msg.ThreadID); err == nil && ch.IsThread() {
+		return b.reopenThread(channelID, ch, msg)
+	}
+	if ch, err := b.c.Channel(msg.ThreadID); err == nil && ch.IsThread() {
+		return b.reopenThread(channelID, ch, msg)
+	}
+	name := "thread"
+	if root, err := b.c.ChannelMessage(channelID, msg.ThreadID); err == nil && root.Content != "" {
+		name = helper.ClipMessage(root.Content, threadNameLength, "...")
+	}
+	thread, err := b.c.MessageThreadStart(channelID, msg.ThreadID, name, threadArchiveDuration)
+	if err != nil {
+		b.Log.Errorf("starting thread on message %s failed: %s", msg.ThreadID, err)
+		msg.ThreadID = ""
+		return channelID
+	}
+	return thread.ID
+}
+
+// reopenThread unarchives the thread so msg can be sent in it and returns its ID. Threads
+// locked by the moderators stay closed, msg.ThreadID is then cleared and channelID is
+// returned.
+func (b *Bdiscord) reopenThread(channelID string, thread *discordgo.Channel, msg *config.Message) string {
+	if thread.ThreadMetadata == nil || !thread.ThreadMetadata.Archived {
+		return thread.ID
+	}
+	if thread.ThreadMetadata.Locked {
+		b.Log.Debugf("thread %s is locked, sending in the channel", thread.ID)
+		msg.ThreadID = ""
+		return channelID
+	}
+	archived := false
+	if _, err := b.c.ChannelEditComplex(thread.ID, &discordgo.ChannelEdit{Archived: &archived}); err != nil {
+		b.Log.Errorf("unarchiving thread %s failed: %s", thread.ID, err)
+		msg.ThreadID = ""
+		return channelID
+	}
+	b.Log.Debugf("unarchived thread %s", thread.ID)
+	return thread.ID
+}
+
 func (b *Bdiscord) getCategoryChannelName(name, parentID string) string {
 	var usesCat bool
 	// do we have a category configuration in the channel config
@@ -267,3 +331,93 @@ func enumerateUsernames(s string) []string {
 	}
 	return usernames
 }
+
+// sendReaction adds or removes the reaction msg of the bot on the message msg.ParentID.
+// Custom emoji are looked up by name in the emoji of the server.
+func (b *Bdiscord) sendReaction(msg *config.Message, channelID string) error {
+	if !msg.ParentValid() {
+		return nil
+	}
+	emojiID := msg.Text
+	if name := strings.Trim(msg.Text, ":"); name != msg.Text {
+		guild, err := b.c.State.Guild(b.guildID)
+		if err != nil {
+			return err
+		}
+		emojiID = ""
+		for _, e := range guild.Emojis {
+			if e.Name == name {
+				emojiID = e.APIName()
+				break
+			}
+		}
+		if emojiID == "" {
+			b.Log.Debugf("not sending reaction %s: unknown emoji", msg.Text)
+			return nil
+		}
+	}
+	if msg.Event == config.EventReactionRemove {
+		return b.c.MessageReactionRemove(channelID, msg.ParentID, emojiID, "@me")
+	}
+	return b.c.MessageReactionAdd(channelID, msg.ParentID, emojiID)
+}
+
+// Permalink implements bridge.Permalinker.
+func (b *Bdiscord) Permalink(channel, id string) (string, bool) {
+	channelID := b.getChannelID(channel)
+	if channelID == "" || id == "" {
+		return "", false
+	}
+	return "https://discord.com/channels/" + b.guildID + "/" + channelID + "/" + id, true
+}
+
+// memberRoles returns the names of the roles of the author of m, see config.ExtraRoles.
+func (b *Bdiscord) memberRoles(m *discordgo.Message) []interface{} {
+	if m.Author == nil || b.c == nil || b.c.State == nil {
+		return nil
+	}
+	var ids []string
+	if m.Member != nil {
+		ids = m.Member.Roles
+	} else {
+		b.membersMutex.RLock()
+		if member, ok := b.userMemberMap[m.Author.ID]; ok {
+			ids = member.Roles
+		}
+		b.membersMutex.RUnlock()
+	}
+	var roles []interface{}
+	for _, id := range ids {
+		if role, err := b.c.State.Role(b.guildID, id); err == nil {
+			roles = append(roles, role.Name)
+		}
+	}
+	return roles
+}
+
+// slowmode returns the seconds between the messages of a user in the channel channelID.
+func (b *Bdiscord) slowmode(channelID string) int {
+	b.channelsMutex.RLock()
+	defer b.channelsMutex.RUnlock()
+	for _, channel := range b.channels {
+		if channel.ID == channelID {
+			return channel.RateLimitPerUser
+		}
+	}
+	return 0
+}
+
+// sendSlowmode tells the gateway the slowmode of the channel channelID.
+func (b *Bdiscord) sendSlowmode(channelID string, seconds int) {
+	rmsg := config.Message{
+		Account: b.Account,
+		Event:   config.EventSlowmode,
+		Text:    strconv.Itoa(seconds),
+		Channel: b.getChannelName(channelID),
+	}
+	if rmsg.Channel == "" {
+		return
+	}
+	b.Log.Debugf("<= Sending slowmode %ss of %s to gateway", rmsg.Text, rmsg.Channel)
+	b.Remote <- rmsg
+}
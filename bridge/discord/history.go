@@ -0,0 +1,54 @@
+package bdiscord
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// discordEpoch is the time in milliseconds of the first discord snowflake.
+	discordEpoch = 1420070400000
+	// historyPageSize is the most messages discord returns at once.
+	historyPageSize = 100
+)
+
+// snowflakeAt returns the smallest snowflake of a message sent at t.
+func snowflakeAt(t time.Time) string {
+	return strconv.FormatInt((t.UnixMilli()-discordEpoch)<<22, 10)
+}
+
+// FetchHistory implements bridge.HistoryFetcher.
+func (b *Bdiscord) FetchHistory(channel string, since time.Time, limit int) error {
+	channelID := b.getChannelID(channel)
+	if channelID == "" {
+		return fmt.Errorf("could not find channel ID for %s", channel)
+	}
+	after := snowflakeAt(since)
+	for fetched := 0; fetched < limit; {
+		size := limit - fetched
+		if size > historyPageSize {
+			size = historyPageSize
+		}
+		msgs, err := b.c.ChannelMessages(channelID, size, "", after, "")
+		if err != nil {
+			return err
+		}
+		// oldest first
+		sort.Slice(msgs, func(i, j int) bool { return msgs[i].Timestamp.Before(msgs[j].Timestamp) })
+		for _, m := range msgs {
+			if !m.Timestamp.After(since) {
+				continue
+			}
+			m.GuildID = b.guildID
+			b.receiveMessage(b.c, m, true)
+		}
+		fetched += len(msgs)
+		if len(msgs) < size {
+			break
+		}
+		after = msgs[len(msgs)-1].ID
+	}
+	return nil
+}
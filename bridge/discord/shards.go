@@ -0,0 +1,189 @@
+package bdiscord
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+)
+
+// shardIdentifyInterval is the time Discord wants between the identifies of the shards of a
+// bot, divided by the max_concurrency of the bot.
+const shardIdentifyInterval = 5 * time.Second
+
+var (
+	shardPoolsMutex sync.Mutex
+	shardPools      = make(map[string]*shardPool)
+)
+
+// shardPool holds the gateway connections of a token, which are shared by the accounts
+// with that token. Discord sends the events of a guild on shard (guild ID >> 22) % shard
+// count, only the shards of the guilds of the accounts are connected.
+type shardPool struct {
+	sync.Mutex
+
+	token string
+	// rest is used for the API calls done before the guild is known, it isn't connected
+	rest             *discordgo.Session
+	count            int
+	identifyInterval time.Duration
+	lastIdentify     time.Time
+	sessions         map[int]*discordgo.Session
+	refs             map[int]int
+	users            int
+	log              *logrus.Entry
+
+	// setup configures a new session before it's opened
+	setup func(s *discordgo.Session)
+	open  func(s *discordgo.Session) error
+	close func(s *discordgo.Session) error
+}
+
+func newShardPool(token string, rest *discordgo.Session, count int, log *logrus.Entry) *shardPool {
+	if count < 1 {
+		count = 1
+	}
+	return &shardPool{
+		token:            token,
+		rest:             rest,
+		count:            count,
+		identifyInterval: shardIdentifyInterval,
+		sessions:         make(map[int]*discordgo.Session),
+		refs:             make(map[int]int),
+		log:              log,
+		setup:            func(s *discordgo.Session) {},
+		open:             func(s *discordgo.Session) error { return s.Open() },
+		close:            func(s *discordgo.Session) error { return s.Close() },
+	}
+}
+
+// getShardPool returns the pool of the token of the bridge and adds the bridge to its users,
+// which must call release when it disconnects. The number of shards is ShardCount or, when
+// that isn't set, the number Discord recommends for bots.
+func (b *Bdiscord) getShardPool(token string, bot bool) (*shardPool, error) {
+	shardPoolsMutex.Lock()
+	defer shardPoolsMutex.Unlock()
+	if pool, ok := shardPools[token]; ok {
+		pool.Lock()
+		pool.users++
+		pool.Unlock()
+		return pool, nil
+	}
+
+	rest, err := discordgo.New(token)
+	if err != nil {
+		return nil, err
+	}
+	rest.Client = b.HTTPClient(20 * time.Second)
+	count := b.GetInt("ShardCount")
+	maxConcurrency := 1
+	if count == 0 && bot {
+		gw, err := rest.GatewayBot()
+		if err != nil {
+			b.Log.Warnf("Could not get the recommended number of shards, using 1: %s", err)
+		} else {
+			count = gw.Shards
+			if gw.SessionStartLimit.MaxConcurrency > 1 {
+				maxConcurrency = gw.SessionStartLimit.MaxConcurrency
+			}
+		}
+	}
+	pool := newShardPool(token, rest, count, b.Log)
+	pool.identifyInterval /= time.Duration(maxConcurrency)
+	pool.setup = func(s *discordgo.Session) {
+		s.Client = b.HTTPClient(20 * time.Second)
+		s.Dialer = b.WebsocketDialer()
+		// Add privileged intent for guild member tracking. This is needed to track nicks
+		// for display names and @mention translation
+		s.Identify.Intents = discordgo.MakeIntent(discordgo.IntentsAllWithoutPrivileged |
+			discordgo.IntentsGuildMembers)
+		// TODO: use the zlib-stream transport compression, which compresses the whole
+		// connection instead of the big payloads only. discordgo decompresses every binary
+		// message with a new zlib reader and doesn't add compress=zlib-stream to the gateway
+		// URL, so it needs a change upstream first. Until then only the payload
+		// compression of the identify is used.
+		s.Compress = true
+		s.Identify.Compress = true
+	}
+	pool.users = 1
+	shardPools[token] = pool
+	if pool.count > 1 {
+		b.Log.Infof("Using %d shards", pool.count)
+	}
+	return pool, nil
+}
+
+// shardOf returns the shard of the guild guildID.
+func (p *shardPool) shardOf(guildID string) int {
+	id, err := strconv.ParseUint(guildID, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return int((id >> 22) % uint64(p.count))
+}
+
+// acquire returns the connected session of the shard of the guild guildID, connecting it
+// first if no other account uses that shard.
+func (p *shardPool) acquire(guildID string) (*discordgo.Session, int, error) {
+	p.Lock()
+	defer p.Unlock()
+	shard := p.shardOf(guildID)
+	if s, ok := p.sessions[shard]; ok {
+		p.refs[shard]++
+		return s, shard, nil
+	}
+
+	s, err := discordgo.New(p.token)
+	if err != nil {
+		return nil, shard, err
+	}
+	s.ShardID = shard
+	s.ShardCount = p.count
+	p.setup(s)
+	s.AddHandler(func(s *discordgo.Session, e *discordgo.Disconnect) {
+		p.log.Warnf("Shard %d of %d disconnected, reconnecting", s.ShardID, s.ShardCount)
+	})
+	s.AddHandler(func(s *discordgo.Session, e *discordgo.Resumed) {
+		p.log.Infof("Shard %d of %d resumed", s.ShardID, s.ShardCount)
+	})
+
+	if wait := p.identifyInterval - time.Since(p.lastIdentify); wait > 0 {
+		time.Sleep(wait)
+	}
+	p.lastIdentify = time.Now()
+	if p.count > 1 {
+		p.log.Infof("Connecting shard %d of %d", shard, p.count)
+	}
+	if err := p.open(s); err != nil {
+		return nil, shard, err
+	}
+	p.sessions[shard] = s
+	p.refs[shard] = 1
+	return s, shard, nil
+}
+
+// release removes a user of the pool, which used the session of shard when it's not -1.
+// Sessions and pools without users are closed.
+func (p *shardPool) release(shard int) error {
+	shardPoolsMutex.Lock()
+	defer shardPoolsMutex.Unlock()
+	p.Lock()
+	defer p.Unlock()
+
+	var err error
+	if s, ok := p.sessions[shard]; ok {
+		p.refs[shard]--
+		if p.refs[shard] <= 0 {
+			delete(p.sessions, shard)
+			delete(p.refs, shard)
+			err = p.close(s)
+		}
+	}
+	p.users--
+	if p.users <= 0 && shardPools[p.token] == p {
+		delete(shardPools, p.token)
+	}
+	return err
+}
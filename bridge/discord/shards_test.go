@@ -0,0 +1,57 @@
+package bdiscord
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardPool(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	p := newShardPool("Bot token", nil, 4, logrus.NewEntry(logger))
+	p.identifyInterval = 0
+	var opened, closed []int
+	p.open = func(s *discordgo.Session) error {
+		opened = append(opened, s.ShardID)
+		return nil
+	}
+	p.close = func(s *discordgo.Session) error {
+		closed = append(closed, s.ShardID)
+		return nil
+	}
+	p.users = 3
+	shardPools[p.token] = p
+
+	// (197038439483310086 >> 22) % 4 is 2
+	assert.Equal(t, 2, p.shardOf("197038439483310086"))
+	assert.Equal(t, 0, p.shardOf("not an id"))
+
+	s1, shard1, err := p.acquire("197038439483310086")
+	require.NoError(t, err)
+	assert.Equal(t, 2, shard1)
+	assert.Equal(t, 2, s1.ShardID)
+	assert.Equal(t, 4, s1.ShardCount)
+
+	// guilds on the same shard share the session
+	s2, shard2, err := p.acquire("197038439483310086")
+	require.NoError(t, err)
+	assert.Same(t, s1, s2)
+	assert.Equal(t, shard1, shard2)
+	assert.Equal(t, []int{2}, opened)
+
+	// the session is closed when its last user releases it
+	require.NoError(t, p.release(shard1))
+	assert.Empty(t, closed)
+	require.NoError(t, p.release(shard2))
+	assert.Equal(t, []int{2}, closed)
+	assert.Contains(t, shardPools, p.token)
+
+	// a user that didn't get to acquire a shard
+	require.NoError(t, p.release(-1))
+	assert.NotContains(t, shardPools, p.token)
+}
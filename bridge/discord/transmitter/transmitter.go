@@ -5,7 +5,8 @@
 //
 // - Creating new webhooks, whenever necessary
 // - Loading webhooks that we have previously created
-// - Sending new messages
+// - Replacing webhooks that were deleted
+// - Sending new messages, also in threads
 // - Editing messages, via message ID
 // - Deleting messages, via message ID
 //
@@ -64,12 +65,28 @@ func New(session *discordgo.Session, guild string, title string, autoCreate bool
 
 // Send transmits a message to the given channel with the provided webhook data, and waits until Discord responds with message data.
 func (t *Transmitter) Send(channelID string, params *discordgo.WebhookParams) (*discordgo.Message, error) {
+	return t.SendThread(channelID, "", params)
+}
+
+// SendThread transmits a message to the thread threadID of the given channel, like Send.
+// An empty threadID sends to the channel itself.
+//
+// When the webhook of the channel was deleted, a new one is created and the message is sent again.
+func (t *Transmitter) SendThread(channelID string, threadID string, params *discordgo.WebhookParams) (*discordgo.Message, error) {
 	wh, err := t.getOrCreateWebhook(channelID)
 	if err != nil {
 		return nil, err
 	}
 
-	msg, err := t.session.WebhookExecute(wh.ID, wh.Token, true, params)
+	msg, err := t.session.WebhookThreadExecute(wh.ID, wh.Token, true, threadID, params)
+	if isUnknownWebhookError(err) && t.autoCreate {
+		t.Log.Infof("Webhook %s of %s was deleted", wh.ID, channelID)
+		t.removeWebhook(channelID, wh)
+		if wh, err = t.getOrCreateWebhook(channelID); err != nil {
+			return nil, err
+		}
+		msg, err = t.session.WebhookThreadExecute(wh.ID, wh.Token, true, threadID, params)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("execute failed: %w", err)
 	}
@@ -79,6 +96,11 @@ func (t *Transmitter) Send(channelID string, params *discordgo.WebhookParams) (*
 
 // Edit will edit a message in a channel, if possible.
 func (t *Transmitter) Edit(channelID string, messageID string, params *discordgo.WebhookParams) error {
+	return t.EditThread(channelID, "", messageID, params)
+}
+
+// EditThread will edit a message in the thread threadID of a channel, like Edit.
+func (t *Transmitter) EditThread(channelID string, threadID string, messageID string, params *discordgo.WebhookParams) error {
 	wh := t.getWebhook(channelID)
 
 	if wh == nil {
@@ -86,6 +108,9 @@ func (t *Transmitter) Edit(channelID string, messageID string, params *discordgo
 	}
 
 	uri := discordgo.EndpointWebhookToken(wh.ID, wh.Token) + "/messages/" + messageID
+	if threadID != "" {
+		uri += "?thread_id=" + threadID
+	}
 	_, err := t.session.RequestWithBucketID("PATCH", uri, params, discordgo.EndpointWebhookToken("", ""))
 	if err != nil {
 		return err
@@ -184,6 +209,16 @@ func (t *Transmitter) createWebhook(channel string) (*discordgo.Webhook, error)
 	return wh, nil
 }
 
+// removeWebhook forgets the webhook wh of channel, unless it was replaced already.
+func (t *Transmitter) removeWebhook(channel string, wh *discordgo.Webhook) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.channelWebhooks[channel] == wh {
+		delete(t.channelWebhooks, channel)
+	}
+}
+
 func (t *Transmitter) getWebhook(channel string) *discordgo.Webhook {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
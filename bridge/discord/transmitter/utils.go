@@ -18,6 +18,13 @@ func isDiscordPermissionError(err error) bool {
 	return restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeMissingPermissions
 }
 
+// isUnknownWebhookError returns true if err is a Discord RESTError with code discordgo.ErrCodeUnknownWebhook,
+// the webhook was deleted.
+func isUnknownWebhookError(err error) bool {
+	restErr, ok := err.(*discordgo.RESTError)
+	return ok && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeUnknownWebhook
+}
+
 // getDiscordUserID gets own user ID from state, and fallback on API request
 func getDiscordUserID(session *discordgo.Session) (string, error) {
 	if user := session.State.User; user != nil {
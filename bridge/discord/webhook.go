@@ -1,7 +1,6 @@
 package bdiscord
 
 import (
-	"bytes"
 	"strings"
 
 	"github.com/42wim/matterbridge/bridge/config"
@@ -43,12 +42,13 @@ func (b *Bdiscord) maybeGetLocalAvatar(msg *config.Message) string {
 	return ""
 }
 
-func (b *Bdiscord) webhookSendTextOnly(msg *config.Message, channelID string) (string, error) {
+func (b *Bdiscord) webhookSendTextOnly(msg *config.Message, channelID, threadID string) (string, error) {
 	msgParts := helper.ClipOrSplitMessage(msg.Text, MessageLength, b.GetString("MessageClipped"), b.GetInt("MessageSplitMaxCount"))
 	msgIds := []string{}
 	for _, msgPart := range msgParts {
-		res, err := b.transmitter.Send(
+		res, err := b.transmitter.SendThread(
 			channelID,
+			threadID,
 			&discordgo.WebhookParams{
 				Content:         msgPart,
 				Username:        msg.Username,
@@ -66,20 +66,26 @@ func (b *Bdiscord) webhookSendTextOnly(msg *config.Message, channelID string) (s
 	return strings.Join(msgIds, ";"), nil
 }
 
-func (b *Bdiscord) webhookSendFilesOnly(msg *config.Message, channelID string) error {
+func (b *Bdiscord) webhookSendFilesOnly(msg *config.Message, channelID, threadID string) error {
 	for _, f := range msg.Extra["file"] {
 		fi := f.(config.FileInfo) //nolint:forcetypeassert
+		r, err := fi.Open()
+		if err != nil {
+			b.Log.Errorf("Could not read file %s for message %#v: %s", fi.Name, msg, err)
+			return err
+		}
 		file := discordgo.File{
 			Name:        fi.Name,
-			ContentType: "",
-			Reader:      bytes.NewReader(*fi.Data),
+			ContentType: fi.MimeType(),
+			Reader:      r,
 		}
 		content := fi.Comment
 
 		// Cannot use the resulting ID for any edits anyway, so throw it away.
 		// This has to be re-enabled when we implement message deletion.
-		_, err := b.transmitter.Send(
+		_, err = b.transmitter.SendThread(
 			channelID,
+			threadID,
 			&discordgo.WebhookParams{
 				Username:        msg.Username,
 				AvatarURL:       msg.Avatar,
@@ -88,6 +94,7 @@ func (b *Bdiscord) webhookSendFilesOnly(msg *config.Message, channelID string) e
 				AllowedMentions: b.getAllowedMentions(),
 			},
 		)
+		r.Close()
 		if err != nil {
 			b.Log.Errorf("Could not send file %#v for message %#v: %s", file, msg, err)
 			return err
@@ -97,9 +104,10 @@ func (b *Bdiscord) webhookSendFilesOnly(msg *config.Message, channelID string) e
 }
 
 // webhookSend send one or more message via webhook, taking care of file
-// uploads (from slack, telegram or mattermost).
+// uploads (from slack, telegram or mattermost). The messages are sent to the
+// thread threadID of the channel if it's set.
 // Returns messageID and error.
-func (b *Bdiscord) webhookSend(msg *config.Message, channelID string) (string, error) {
+func (b *Bdiscord) webhookSend(msg *config.Message, channelID, threadID string) (string, error) {
 	var (
 		res string
 		err error
@@ -114,19 +122,19 @@ func (b *Bdiscord) webhookSend(msg *config.Message, channelID string) (string, e
 
 	// We can't send empty messages.
 	if msg.Text != "" {
-		res, err = b.webhookSendTextOnly(msg, channelID)
+		res, err = b.webhookSendTextOnly(msg, channelID, threadID)
 	}
 
 	if err == nil && msg.Extra != nil {
-		err = b.webhookSendFilesOnly(msg, channelID)
+		err = b.webhookSendFilesOnly(msg, channelID, threadID)
 	}
 
 	return res, err
 }
 
-func (b *Bdiscord) handleEventWebhook(msg *config.Message, channelID string) (string, error) {
+func (b *Bdiscord) handleEventWebhook(msg *config.Message, channelID, threadID string) (string, error) {
 	// skip events
-	if msg.Event != "" && msg.Event != config.EventUserAction && msg.Event != config.EventJoinLeave && msg.Event != config.EventTopicChange {
+	if msg.Event != "" && msg.Event != config.EventUserAction && !config.IsJoinLeave(msg.Event) && msg.Event != config.EventTopicChange {
 		return "", nil
 	}
 
@@ -153,7 +161,7 @@ func (b *Bdiscord) handleEventWebhook(msg *config.Message, channelID string) (st
 		for i := range msgParts {
 			// In case of split-messages where some parts remain the same (i.e. only a typo-fix in a huge message), this causes some noop-updates.
 			// TODO: Optimize away noop-updates of un-edited messages
-			editErr = b.transmitter.Edit(channelID, msgIds[i], &discordgo.WebhookParams{
+			editErr = b.transmitter.EditThread(channelID, threadID, msgIds[i], &discordgo.WebhookParams{
 				Content:         msgParts[i],
 				Username:        msg.Username,
 				AllowedMentions: b.getAllowedMentions(),
@@ -170,7 +178,7 @@ func (b *Bdiscord) handleEventWebhook(msg *config.Message, channelID string) (st
 
 	b.Log.Debugf("Processing webhook sending for message %#v", msg)
 	msg.Text = b.replaceUserMentions(msg.Text)
-	msgID, err := b.webhookSend(msg, channelID)
+	msgID, err := b.webhookSend(msg, channelID, threadID)
 	if err != nil {
 		b.Log.Errorf("Could not broadcast via webhook for message %#v: %s", msgID, err)
 		return "", err
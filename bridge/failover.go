@@ -0,0 +1,115 @@
+package bridge
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const healthCheckTimeout = 10 * time.Second
+
+// Servers returns the endpoints configured for this account: Servers when set,
+// otherwise the single Server.
+func (b *Bridge) Servers() []string {
+	if servers := b.GetStringSlice("Servers"); len(servers) > 0 {
+		return servers
+	}
+	return []string{b.GetString("Server")}
+}
+
+// Server returns the endpoint the bridge is currently using.
+func (b *Bridge) Server() string {
+	b.RLock()
+	defer b.RUnlock()
+	if b.activeServer != "" {
+		return b.activeServer
+	}
+	return b.GetString("Server")
+}
+
+// ConnectFailover calls connect for the configured servers until one succeeds.
+// It starts with the server that was used last, so that a reconnect moves on to
+// the next server when the current one keeps failing. Servers for which check
+// returns an error are skipped; when check is nil a TCP connection is tried.
+func (b *Bridge) ConnectFailover(check func(server string) error, connect func(server string) error) error {
+	servers := b.Servers()
+	if check == nil {
+		check = b.checkTCP
+	}
+	b.RLock()
+	start := b.serverIndex
+	b.RUnlock()
+
+	var errs []string
+	for i := 0; i < len(servers); i++ {
+		idx := (start + i) % len(servers)
+		server := servers[idx]
+		if len(servers) > 1 {
+			if err := check(server); err != nil {
+				b.Log.Warnf("%s: server %s is unhealthy: %s", b.Account, server, err)
+				errs = append(errs, fmt.Sprintf("%s: %s", server, err))
+				continue
+			}
+		}
+		b.Lock()
+		b.activeServer = server
+		b.serverIndex = idx
+		b.Unlock()
+		err := connect(server)
+		if err == nil {
+			return nil
+		}
+		b.Log.Warnf("%s: connecting to %s failed: %s", b.Account, server, err)
+		errs = append(errs, fmt.Sprintf("%s: %s", server, err))
+	}
+	// start with the next server on the next attempt
+	b.Lock()
+	b.serverIndex = (start + 1) % len(servers)
+	b.Unlock()
+	return fmt.Errorf("all servers failed: %s", strings.Join(errs, ", "))
+}
+
+// checkTCP checks that a TCP connection can be made to server,
+// which can be a host:port or an URL.
+func (b *Bridge) checkTCP(server string) error {
+	address := server
+	if u, err := url.Parse(server); err == nil && u.Host != "" {
+		address = u.Host
+		if u.Port() == "" {
+			port := "443"
+			if u.Scheme == "http" || u.Scheme == "ws" {
+				port = "80"
+			}
+			address = net.JoinHostPort(u.Hostname(), port)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	conn, err := b.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// CheckHTTP returns a health check that does a GET on path of the server and
+// expects a 200 response.
+func (b *Bridge) CheckHTTP(path string) func(server string) error {
+	return func(server string) error {
+		if !strings.Contains(server, "://") {
+			server = "https://" + server
+		}
+		resp, err := b.HTTPClient(healthCheckTimeout).Get(strings.TrimSuffix(server, "/") + path)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	}
+}
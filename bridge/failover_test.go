@@ -0,0 +1,72 @@
+package bridge
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectFailover(t *testing.T) {
+	// a single server isn't health checked
+	b := newTestBridge(t, "Server=\"https://one.example.com\"")
+	var connected []string
+	connect := func(server string) error {
+		connected = append(connected, server)
+		return nil
+	}
+	require.NoError(t, b.ConnectFailover(func(string) error {
+		t.Fatal("health check of a single server")
+		return nil
+	}, connect))
+	assert.Equal(t, []string{"https://one.example.com"}, connected)
+	assert.Equal(t, "https://one.example.com", b.Server())
+
+	// the unhealthy servers are skipped
+	b = newTestBridge(t, "Servers=[\"one\", \"two\", \"three\"]")
+	connected = nil
+	check := func(server string) error {
+		if server == "one" {
+			return errors.New("down")
+		}
+		return nil
+	}
+	require.NoError(t, b.ConnectFailover(check, connect))
+	assert.Equal(t, []string{"two"}, connected)
+	assert.Equal(t, "two", b.Server())
+
+	// a reconnect starts with the server used last and moves on when it fails
+	connected = nil
+	failing := func(server string) error {
+		connected = append(connected, server)
+		if server == "two" {
+			return errors.New("refused")
+		}
+		return nil
+	}
+	require.NoError(t, b.ConnectFailover(check, failing))
+	assert.Equal(t, []string{"two", "three"}, connected)
+	assert.Equal(t, "three", b.Server())
+
+	// when all fail the next attempt starts with the next server
+	err := b.ConnectFailover(func(string) error { return errors.New("down") }, connect)
+	assert.EqualError(t, err, "all servers failed: three: down, one: down, two: down")
+	connected = nil
+	require.NoError(t, b.ConnectFailover(func(string) error { return nil }, connect))
+	assert.Equal(t, []string{"one"}, connected)
+}
+
+func TestCheckHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_matrix/client/versions" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	b := newTestBridge(t, "")
+	assert.NoError(t, b.CheckHTTP("/_matrix/client/versions")(srv.URL+"/"))
+	assert.EqualError(t, b.CheckHTTP("/health")(srv.URL), "unexpected status 404 Not Found")
+}
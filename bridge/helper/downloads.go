@@ -0,0 +1,206 @@
+package helper
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultDownloadConcurrency          = 4
+	defaultDownloadConcurrencyPerBridge = 2
+)
+
+// DownloadPool limits the media downloads of all bridges together, so a burst of
+// attachments can't use all connections and bandwidth. Bridges that download in the
+// background with Go can use at most a part of the slots, so a busy bridge doesn't
+// starve the others.
+type DownloadPool struct {
+	mu        sync.Mutex
+	global    chan struct{}
+	perBridge map[string]chan struct{}
+	bridgeMax int
+	limiter   *rate.Limiter
+}
+
+// Downloads is the download pool used by all bridges.
+var Downloads = NewDownloadPool(defaultDownloadConcurrency, defaultDownloadConcurrencyPerBridge, 0)
+
+// NewDownloadPool returns a pool running at most concurrency downloads, perBridge of them
+// for the same bridge, with a total bandwidth of kbps KB/s (0 for unlimited).
+func NewDownloadPool(concurrency, perBridge, kbps int) *DownloadPool {
+	if concurrency <= 0 {
+		concurrency = defaultDownloadConcurrency
+	}
+	if perBridge <= 0 || perBridge > concurrency {
+		perBridge = concurrency
+	}
+	p := &DownloadPool{
+		global:    make(chan struct{}, concurrency),
+		perBridge: make(map[string]chan struct{}),
+		bridgeMax: perBridge,
+	}
+	if kbps > 0 {
+		p.limiter = rate.NewLimiter(rate.Limit(kbps*1024), kbps*1024)
+	}
+	return p
+}
+
+// ConfigureDownloads sets up Downloads with the MediaDownload* settings of the [general] section.
+func ConfigureDownloads(general *config.Protocol) {
+	parallel, perBridge := general.MediaDownloadParallel, general.MediaDownloadPerBridge
+	if perBridge == 0 {
+		perBridge = defaultDownloadConcurrencyPerBridge
+	}
+	// a single download at a time keeps a single file in memory
+	if general.LowMemory && parallel == 0 {
+		parallel = 1
+	}
+	Downloads = NewDownloadPool(parallel, perBridge, general.MediaDownloadBandwidth)
+}
+
+func (p *DownloadPool) bridgeSlots(account string) chan struct{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	slots, ok := p.perBridge[account]
+	if !ok {
+		slots = make(chan struct{}, p.bridgeMax)
+		p.perBridge[account] = slots
+	}
+	return slots
+}
+
+// Fetch runs fn, which does a single download, once one of the global slots is free.
+// The Download* functions of this package use it already. It only limits the downloads,
+// the bridges that download in their receive loop still wait for them; signal, telegram,
+// matrix and slack download in the background with a ChannelQueue instead.
+func (p *DownloadPool) Fetch(fn func() error) error {
+	p.global <- struct{}{}
+	defer func() { <-p.global }()
+	return fn()
+}
+
+// Go runs fetch, which downloads the media of a message of account, in the background
+// once a slot of account is free. The downloads in fetch use the global slots.
+// The messages aren't kept in order, see ChannelQueue for that.
+func (p *DownloadPool) Go(account string, fetch func()) {
+	slots := p.bridgeSlots(account)
+	go func() {
+		slots <- struct{}{}
+		defer func() { <-slots }()
+		fetch()
+	}()
+}
+
+// ChannelQueue relays the messages received by a bridge in order per channel, while the
+// media of some of them are downloaded in the background: a message waits until the ones
+// received before it in its channel are relayed. Relay and Go must be called in the order
+// the messages are received, by the receive loop of the bridge.
+type ChannelQueue struct {
+	account string
+	mu      sync.Mutex
+	pending map[string]*channelQueue
+}
+
+type channelQueue struct {
+	messages []*queuedMessage
+	draining bool
+}
+
+type queuedMessage struct {
+	ready bool
+	relay func()
+}
+
+// NewChannelQueue returns the queue of the messages received by account.
+func NewChannelQueue(account string) *ChannelQueue {
+	return &ChannelQueue{account: account, pending: make(map[string]*channelQueue)}
+}
+
+// Relay runs relay, which sends a message of channel to the gateway, right away when no
+// message of channel is waiting for its media, after them otherwise.
+func (q *ChannelQueue) Relay(channel string, relay func()) {
+	q.mu.Lock()
+	c, ok := q.pending[channel]
+	if !ok {
+		q.mu.Unlock()
+		relay()
+		return
+	}
+	c.messages = append(c.messages, &queuedMessage{ready: true, relay: relay})
+	q.mu.Unlock()
+	q.drain(channel)
+}
+
+// Go runs fetch, which downloads the media of a message of channel, with Downloads.Go and
+// then relay like Relay does.
+func (q *ChannelQueue) Go(channel string, fetch func(), relay func()) {
+	m := &queuedMessage{relay: relay}
+	q.mu.Lock()
+	c, ok := q.pending[channel]
+	if !ok {
+		c = &channelQueue{}
+		q.pending[channel] = c
+	}
+	c.messages = append(c.messages, m)
+	q.mu.Unlock()
+	Downloads.Go(q.account, func() {
+		fetch()
+		q.mu.Lock()
+		m.ready = true
+		q.mu.Unlock()
+		q.drain(channel)
+	})
+}
+
+// drain relays the messages of channel that are ready, up to the first one that isn't.
+// A single goroutine drains a channel at a time, so they're relayed in order.
+func (q *ChannelQueue) drain(channel string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	c, ok := q.pending[channel]
+	if !ok || c.draining {
+		return
+	}
+	c.draining = true
+	for len(c.messages) > 0 && c.messages[0].ready {
+		m := c.messages[0]
+		c.messages = c.messages[1:]
+		q.mu.Unlock()
+		m.relay()
+		q.mu.Lock()
+	}
+	c.draining = false
+	if len(c.messages) == 0 {
+		delete(q.pending, channel)
+	}
+}
+
+// Reader returns r limited to the bandwidth of the pool.
+func (p *DownloadPool) Reader(r io.Reader) io.Reader {
+	if p.limiter == nil {
+		return r
+	}
+	return &rateReader{r: r, limiter: p.limiter}
+}
+
+type rateReader struct {
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *rateReader) Read(b []byte) (int, error) {
+	if len(b) > r.limiter.Burst() {
+		b = b[:r.limiter.Burst()]
+	}
+	n, err := r.r.Read(b)
+	if n > 0 {
+		if werr := r.limiter.WaitN(context.Background(), n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
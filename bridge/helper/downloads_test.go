@@ -0,0 +1,96 @@
+package helper
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadPoolLimits(t *testing.T) {
+	p := NewDownloadPool(3, 1, 0)
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		p.Go("slack.test", func() {
+			defer wg.Done()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+	}
+	wg.Wait()
+	// only one download at a time for the same bridge
+	assert.Equal(t, int32(1), maxRunning)
+
+	// the global limit
+	assert.Len(t, p.global, 0)
+	assert.NoError(t, p.Fetch(func() error {
+		assert.Len(t, p.global, 1)
+		return nil
+	}))
+}
+
+func TestDownloadPoolBandwidth(t *testing.T) {
+	p := NewDownloadPool(1, 1, 1)
+	start := time.Now()
+	data, err := io.ReadAll(p.Reader(bytes.NewReader(make([]byte, 1536))))
+	assert.NoError(t, err)
+	assert.Len(t, data, 1536)
+	// the first KB is the burst, the rest takes about half a second at 1 KB/s
+	assert.Greater(t, time.Since(start), 400*time.Millisecond)
+
+	assert.Equal(t, bytes.NewReader(nil), NewDownloadPool(1, 1, 0).Reader(bytes.NewReader(nil)))
+}
+
+func TestChannelQueue(t *testing.T) {
+	q := NewChannelQueue("slack.test")
+	var mu sync.Mutex
+	var relayed []string
+	relay := func(text string) func() {
+		return func() {
+			mu.Lock()
+			relayed = append(relayed, text)
+			mu.Unlock()
+		}
+	}
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	q.Go("general", func() { <-release }, relay("image"))
+	q.Relay("general", func() { relay("after the image")(); wg.Done() })
+	// the other channels aren't held up
+	q.Relay("random", relay("other channel"))
+	q.Go("random", func() {}, func() { relay("other image")(); wg.Done() })
+
+	mu.Lock()
+	assert.Equal(t, []string{"other channel"}, relayed)
+	mu.Unlock()
+	close(release)
+	wg.Wait()
+	wg.Add(1)
+	q.Relay("general", func() { relay("last")(); wg.Done() })
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(t, relayed, 5)
+	var general []string
+	for _, text := range relayed {
+		if text == "image" || text == "after the image" || text == "last" {
+			general = append(general, text)
+		}
+	}
+	assert.Equal(t, []string{"image", "after the image", "last"}, general)
+}
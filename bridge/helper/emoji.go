@@ -0,0 +1,37 @@
+package helper
+
+import (
+	"strings"
+
+	"github.com/kyokomi/emoji/v2"
+)
+
+// variationSelector is appended to some emoji to request their emoji presentation.
+const variationSelector = "\ufe0f"
+
+// EmojiFromShortcode returns the unicode emoji of the shortcode name, as used for
+// reactions by slack and mattermost. Unknown shortcodes, eg of custom emoji, are
+// returned as ":name:".
+func EmojiFromShortcode(name string) string {
+	code := ":" + strings.Trim(name, ":") + ":"
+	if e, ok := emoji.CodeMap()[code]; ok {
+		return e
+	}
+	return code
+}
+
+// EmojiShortcode returns the shortcode name of the unicode emoji e, the reverse of
+// EmojiFromShortcode. A ":name:" shortcode is returned without the colons, false is
+// returned when e isn't a known emoji.
+func EmojiShortcode(e string) (string, bool) {
+	if len(e) > 2 && strings.HasPrefix(e, ":") && strings.HasSuffix(e, ":") {
+		return strings.Trim(e, ":"), true
+	}
+	rev := emoji.RevCodeMap()
+	for _, candidate := range []string{e, strings.TrimSuffix(e, variationSelector), e + variationSelector} {
+		if codes := rev[candidate]; len(codes) > 0 {
+			return strings.Trim(codes[0], ":"), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,28 @@
+package helper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmojiFromShortcode(t *testing.T) {
+	assert.Equal(t, "\U0001f44d", EmojiFromShortcode("+1"))
+	assert.Equal(t, "\U0001f44d", EmojiFromShortcode("thumbsup"))
+	assert.Equal(t, "\U0001f44d", EmojiFromShortcode(":thumbsup:"))
+	assert.Equal(t, ":partyparrot:", EmojiFromShortcode("partyparrot"))
+}
+
+func TestEmojiShortcode(t *testing.T) {
+	name, ok := EmojiShortcode("\U0001f44d")
+	assert.True(t, ok)
+	assert.Equal(t, "+1", name)
+	assert.Equal(t, "\U0001f44d", EmojiFromShortcode(name))
+
+	name, ok = EmojiShortcode(":partyparrot:")
+	assert.True(t, ok)
+	assert.Equal(t, "partyparrot", name)
+
+	_, ok = EmojiShortcode("not an emoji")
+	assert.False(t, ok)
+}
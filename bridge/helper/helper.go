@@ -2,8 +2,8 @@ package helper
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
-	"image/png"
 	"io"
 	"net/http"
 	"regexp"
@@ -11,9 +11,8 @@ import (
 	"time"
 	"unicode/utf8"
 
-	"golang.org/x/image/webp"
-
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
 	"github.com/gomarkdown/markdown"
 	"github.com/gomarkdown/markdown/html"
 	"github.com/gomarkdown/markdown/parser"
@@ -27,33 +26,119 @@ func DownloadFile(url string) (*[]byte, error) {
 
 // DownloadFileAuth downloads the given URL using the specified authentication token.
 func DownloadFileAuth(url string, auth string) (*[]byte, error) {
-	var buf bytes.Buffer
-	client := &http.Client{
-		Timeout: time.Second * 5,
-	}
-	req, err := http.NewRequest("GET", url, nil)
+	header := http.Header{}
 	if auth != "" {
-		req.Header.Add("Authorization", auth)
+		header.Set("Authorization", auth)
 	}
+	return DownloadFileClient(&http.Client{Timeout: time.Second * 5}, url, header)
+}
+
+// ErrFileTooBig is returned by DownloadFileLimit for the files bigger than its limit.
+var ErrFileTooBig = errors.New("file too big")
+
+// DownloadFileClient downloads the given URL with client, adding header to the request.
+func DownloadFileClient(client *http.Client, url string, header http.Header) (*[]byte, error) {
+	return DownloadFileLimit(client, url, header, 0)
+}
+
+// DownloadFileLimit downloads the given URL like DownloadFileClient, but doesn't read more
+// than limit bytes (0 for no limit): it returns ErrFileTooBig when the Content-Length or
+// the body is bigger.
+func DownloadFileLimit(client *http.Client, url string, header http.Header, limit int64) (*[]byte, error) {
+	var data *[]byte
+	err := Downloads.Fetch(func() error {
+		var err error
+		data, err = downloadFile(client, url, header, limit)
+		return err
+	})
+	return data, err
+}
+
+func downloadFile(client *http.Client, url string, header http.Header, limit int64) (*[]byte, error) {
+	var data []byte
+	err := download(client, url, header, limit, func(body io.Reader) error {
+		var buf bytes.Buffer
+		io.Copy(&buf, body)
+		if limit > 0 && int64(buf.Len()) > limit {
+			return ErrFileTooBig
+		}
+		data = buf.Bytes()
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return &data, nil
+}
+
+// DownloadMedia downloads the given URL like DownloadFileLimit. With LowMemory the file
+// is written to a temporary file while it's downloaded, it's never completely in memory.
+func DownloadMedia(client *http.Client, url string, header http.Header, limit int64, general *config.Protocol) (*config.Media, error) {
+	if !general.LowMemory {
+		data, err := DownloadFileLimit(client, url, header, limit)
+		if err != nil {
+			return nil, err
+		}
+		return config.NewMemoryMedia(*data, ""), nil
+	}
+	var media *config.Media
+	err := Downloads.Fetch(func() error {
+		return download(client, url, header, limit, func(body io.Reader) error {
+			m, err := config.NewTempMediaReader(body, "")
+			if err != nil {
+				return err
+			}
+			if limit > 0 && m.Size() > limit {
+				return ErrFileTooBig
+			}
+			media = m
+			return nil
+		})
+	})
+	return media, err
+}
+
+// download gets url and passes its body, limited to the bandwidth of Downloads and to a
+// byte more than limit, to read.
+func download(client *http.Client, url string, header http.Header, limit int64, read func(io.Reader) error) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		peek, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected HTTP status: %s, body: %.100s", resp.Status, peek)
+		return fmt.Errorf("unexpected HTTP status: %s, body: %.100s", resp.Status, peek)
 	}
-	io.Copy(&buf, resp.Body)
-	data := buf.Bytes()
-	return &data, nil
+	var body io.Reader = resp.Body
+	if limit > 0 {
+		if resp.ContentLength > limit {
+			return ErrFileTooBig
+		}
+		body = io.LimitReader(body, limit+1)
+	}
+	return read(Downloads.Reader(body))
 }
 
 // DownloadFileAuthRocket downloads the given URL using the specified Rocket user ID and authentication token.
 func DownloadFileAuthRocket(url, token, userID string) (*[]byte, error) {
+	var data *[]byte
+	err := Downloads.Fetch(func() error {
+		var err error
+		data, err = downloadFileAuthRocket(url, token, userID)
+		return err
+	})
+	return data, err
+}
+
+func downloadFileAuthRocket(url, token, userID string) (*[]byte, error) {
 	var buf bytes.Buffer
 	client := &http.Client{
 		Timeout: time.Second * 5,
@@ -71,7 +156,7 @@ func DownloadFileAuthRocket(url, token, userID string) (*[]byte, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	_, err = io.Copy(&buf, resp.Body)
+	_, err = io.Copy(&buf, Downloads.Reader(resp.Body))
 	data := buf.Bytes()
 	return &data, err
 }
@@ -123,12 +208,23 @@ func GetSubLines(message string, maxLineLength int, clippingMessage string) []st
 }
 
 // HandleExtra manages the supplementary details stored inside a message's 'Extra' field map.
+// The files too big to download (see CheckDownload) or to relay (MediaMaxSize of the
+// gateway) are sent as a link with their size when they have a URL, as a notice otherwise.
 func HandleExtra(msg *config.Message, general *config.Protocol) []config.Message {
 	extra := msg.Extra
 	rmsg := []config.Message{}
 	for _, f := range extra[config.EventFileFailureSize] {
 		fi := f.(config.FileInfo)
-		text := fmt.Sprintf("file %s too big to download (%#v > allowed size: %#v)", fi.Name, fi.Size, general.MediaDownloadSize)
+		var text string
+		switch {
+		case fi.URL != "":
+			// relay a link to the file instead
+			text = fmt.Sprintf("%s (%s): %s", fi.Name, FormatSize(fi.Size), fi.URL)
+		case int(fi.Size) > general.MediaDownloadSize:
+			text = fmt.Sprintf("file %s too big to download (%#v > allowed size: %#v)", fi.Name, fi.Size, general.MediaDownloadSize)
+		default:
+			text = fmt.Sprintf("file %s too big to relay (%s)", fi.Name, FormatSize(fi.Size))
+		}
 		rmsg = append(rmsg, config.Message{
 			Text:     text,
 			Username: "<system> ",
@@ -139,9 +235,53 @@ func HandleExtra(msg *config.Message, general *config.Protocol) []config.Message
 	return rmsg
 }
 
+// FormatSize returns size in bytes as a short human readable size, like 1.5 MB.
+func FormatSize(size int64) string {
+	const unit = 1000
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit && exp < 4; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "kMGTP"[exp])
+}
+
+// FileText returns the text sent for fi by the bridges that send files as a link: the
+// rendered caption and the URL as "caption: url", or whichever of them is set.
+func FileText(fi config.FileInfo) string {
+	switch {
+	case fi.Comment != "" && fi.URL != "":
+		return fi.Comment + ": " + fi.URL
+	case fi.URL != "":
+		return fi.URL
+	}
+	return fi.Comment
+}
+
+// lowMemoryCacheDivisor is how many times smaller the caches are with LowMemory.
+const lowMemoryCacheDivisor = 10
+
+// CacheSize returns size for a cache of recent messages, or a smaller size with LowMemory.
+func CacheSize(general *config.Protocol, size int) int {
+	if general != nil && general.LowMemory {
+		return size / lowMemoryCacheDivisor
+	}
+	return size
+}
+
+// HasMediaServer returns true if files are uploaded to a mediaserver, S3 or a path served
+// by the mediaserver, so bridges can give them a URL.
+func HasMediaServer(general *config.Protocol) bool {
+	return general.MediaServerUpload != "" || general.MediaUploadBackend == "s3" ||
+		(general.MediaServerDownload != "" && general.MediaDownloadPath != "")
+}
+
 // GetAvatar constructs a URL for a given user-avatar if it is available in the cache.
-func GetAvatar(av map[string]string, userid string, general *config.Protocol) string {
-	if sha, ok := av[userid]; ok {
+func GetAvatar(av *store.Bucket, userid string, general *config.Protocol) string {
+	if sha, ok := av.GetString(userid); ok {
 		return general.MediaServerDownload + "/" + sha + "/" + userid + ".png"
 	}
 	return ""
@@ -150,6 +290,23 @@ func GetAvatar(av map[string]string, userid string, general *config.Protocol) st
 // HandleDownloadSize checks a specified filename against the configured download blacklist
 // and checks a specified file-size against the configure limit.
 func HandleDownloadSize(logger *logrus.Entry, msg *config.Message, name string, size int64, general *config.Protocol) error {
+	return CheckDownload(logger, msg, DownloadInfo{Name: name, Size: size}, general)
+}
+
+// DownloadInfo is a file a bridge is about to download, see CheckDownload.
+type DownloadInfo struct {
+	Name string
+	Size int64
+	// URL is relayed instead of the file when it's too big to download. Only set it when the
+	// users of the other bridges can open it: the URLs of slack and telegram need the token
+	// of the bot, only mattermost and matrix have one.
+	URL string
+}
+
+// CheckDownload is HandleDownloadSize for file: a file too big to download is added to the
+// config.EventFileFailureSize files of msg, which HandleExtra relays as a link to its URL.
+func CheckDownload(logger *logrus.Entry, msg *config.Message, file DownloadInfo, general *config.Protocol) error {
+	name, size := file.Name, file.Size
 	// check blacklist here
 	for _, entry := range general.MediaDownloadBlackList {
 		if entry != "" {
@@ -170,6 +327,7 @@ func HandleDownloadSize(logger *logrus.Entry, msg *config.Message, name string,
 			Name:    name,
 			Comment: msg.Text,
 			Size:    size,
+			URL:     file.URL,
 		})
 		return fmt.Errorf("File %#v to large to download (%#v). MediaDownloadSize is %#v", name, size, general.MediaDownloadSize)
 	}
@@ -183,14 +341,29 @@ func HandleDownloadData(logger *logrus.Entry, msg *config.Message, name, comment
 
 // HandleDownloadData adds the data for a remote file into a Matterbridge gateway message.
 func HandleDownloadData2(logger *logrus.Entry, msg *config.Message, name, id, comment, url string, data *[]byte, general *config.Protocol) {
+	media := config.NewMemoryMedia(*data, "")
+	if general.LowMemory {
+		// keep the file on disk instead of in memory while it's relayed
+		if m, err := config.NewTempMedia(*data, media.ContentType()); err == nil {
+			media = m
+		} else {
+			logger.Errorf("Keeping %s in memory, writing it to a temporary file failed: %s", name, err)
+		}
+	}
+	HandleDownloadMedia(logger, msg, name, id, comment, url, media)
+}
+
+// HandleDownloadMedia adds media, the content of a remote file downloaded with
+// DownloadMedia, into a Matterbridge gateway message.
+func HandleDownloadMedia(logger *logrus.Entry, msg *config.Message, name, id, comment, url string, media *config.Media) {
 	var avatar bool
-	logger.Debugf("Download OK %#v %#v", name, len(*data))
+	logger.Debugf("Download OK %#v %#v", name, media.Size())
 	if msg.Event == config.EventAvatarDownload {
 		avatar = true
 	}
 	msg.Extra["file"] = append(msg.Extra["file"], config.FileInfo{
 		Name:     name,
-		Data:     data,
+		Media:    media,
 		URL:      url,
 		Comment:  comment,
 		Avatar:   avatar,
@@ -206,6 +379,51 @@ func RemoveEmptyNewLines(msg string) string {
 	return emptyLineMatcher.ReplaceAllString(strings.Trim(msg, "\n"), "\n")
 }
 
+// MessagePriority returns the priority of msg, if any, and true if its sender requested an
+// acknowledgement, see config.ExtraPriority and config.ExtraRequestedAck.
+func MessagePriority(msg *config.Message) (string, bool) {
+	if msg.Extra == nil {
+		return "", false
+	}
+	var priority string
+	if len(msg.Extra[config.ExtraPriority]) > 0 {
+		priority, _ = msg.Extra[config.ExtraPriority][0].(string)
+	}
+	return priority, len(msg.Extra[config.ExtraRequestedAck]) > 0
+}
+
+// PriorityMarker returns the text put before a message with priority on the bridges without
+// message priorities, like "[URGENT] [ACK REQUESTED] ".
+func PriorityMarker(priority string, ack bool) string {
+	var marker string
+	if priority != "" {
+		marker = "[" + strings.ToUpper(priority) + "] "
+	}
+	if ack {
+		marker += "[ACK REQUESTED] "
+	}
+	return marker
+}
+
+// DefaultQuoteFormat is the format of FormatQuote when none is configured.
+const DefaultQuoteFormat = "{MESSAGE} (re @{QUOTENICK}: {QUOTEMESSAGE})"
+
+// FormatQuote returns message with the quote of the message of nick it replies to, as
+// formatted by format with {MESSAGE}, {QUOTENICK} and {QUOTEMESSAGE}. The quote is
+// truncated at limit characters when limit isn't 0.
+func FormatQuote(format, message, nick, quote string, limit int) string {
+	if format == "" {
+		format = DefaultQuoteFormat
+	}
+	if runes := []rune(quote); limit != 0 && len(runes) > limit {
+		quote = string(runes[:limit]) + "..."
+	}
+	format = strings.ReplaceAll(format, "{MESSAGE}", message)
+	format = strings.ReplaceAll(format, "{QUOTENICK}", nick)
+	format = strings.ReplaceAll(format, "{QUOTEMESSAGE}", quote)
+	return format
+}
+
 // ClipMessage trims a message to the specified length if it exceeds it and adds a warning
 // to the message in case it does so.
 func ClipMessage(text string, length int, clippingMessage string) string {
@@ -276,16 +494,5 @@ func ParseMarkdown(input string) string {
 
 // ConvertWebPToPNG converts input data (which should be WebP format) to PNG format
 func ConvertWebPToPNG(data *[]byte) error {
-	r := bytes.NewReader(*data)
-	m, err := webp.Decode(r)
-	if err != nil {
-		return err
-	}
-	var output []byte
-	w := bytes.NewBuffer(output)
-	if err := png.Encode(w, m); err != nil {
-		return err
-	}
-	*data = w.Bytes()
-	return nil
+	return ConvertImage(data, "image.webp", imagePNG)
 }
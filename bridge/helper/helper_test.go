@@ -2,10 +2,15 @@ package helper
 
 import (
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 const testLineLength = 64
@@ -236,3 +241,115 @@ func TestClipOrSplitMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestFormatQuote(t *testing.T) {
+	assert.Equal(t, "yes (re @wim: hello world)", FormatQuote("", "yes", "wim", "hello world", 0))
+	assert.Equal(t, "> wim: hellö...\nyes", FormatQuote("> {QUOTENICK}: {QUOTEMESSAGE}\n{MESSAGE}", "yes", "wim", "hellö world", 5))
+	assert.Equal(t, "yes (re @wim: hello)", FormatQuote("", "yes", "wim", "hello", 5))
+}
+
+func TestFileText(t *testing.T) {
+	assert.Equal(t, "look: https://media/a.png", FileText(config.FileInfo{Comment: "look", URL: "https://media/a.png"}))
+	assert.Equal(t, "https://media/a.png", FileText(config.FileInfo{URL: "https://media/a.png"}))
+	assert.Equal(t, "look", FileText(config.FileInfo{Comment: "look"}))
+	assert.Empty(t, FileText(config.FileInfo{Name: "a.png"}))
+}
+
+func TestDownloadFileClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("data")) //nolint:errcheck
+	}))
+	defer ts.Close()
+
+	data, err := DownloadFileClient(ts.Client(), ts.URL, http.Header{"X-Token": {"secret"}})
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(*data))
+
+	_, err = DownloadFileClient(ts.Client(), ts.URL, nil)
+	assert.Error(t, err)
+}
+
+func TestDownloadFileLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			// no Content-Length
+			w.Write([]byte("more than ten bytes")) //nolint:errcheck
+			w.(http.Flusher).Flush()
+			w.Write([]byte(" and more")) //nolint:errcheck
+			return
+		}
+		w.Header().Set("Content-Length", "19")
+		w.Write([]byte("more than ten bytes")) //nolint:errcheck
+	}))
+	defer ts.Close()
+
+	_, err := DownloadFileLimit(ts.Client(), ts.URL, nil, 10)
+	assert.Equal(t, ErrFileTooBig, err)
+	_, err = DownloadFileLimit(ts.Client(), ts.URL+"/chunked", nil, 10)
+	assert.Equal(t, ErrFileTooBig, err)
+	data, err := DownloadFileLimit(ts.Client(), ts.URL, nil, 19)
+	require.NoError(t, err)
+	assert.Equal(t, "more than ten bytes", string(*data))
+}
+
+func TestDownloadMedia(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("more than ten bytes")) //nolint:errcheck
+	}))
+	defer ts.Close()
+
+	// with LowMemory the file is written to disk as it's downloaded
+	media, err := DownloadMedia(ts.Client(), ts.URL, nil, 0, &config.Protocol{LowMemory: true})
+	require.NoError(t, err)
+	assert.True(t, media.OnDisk())
+	assert.Equal(t, int64(19), media.Size())
+	assert.Equal(t, "text/plain; charset=utf-8", media.ContentType())
+	data, err := media.Bytes()
+	require.NoError(t, err)
+	assert.Equal(t, "more than ten bytes", string(data))
+	_, err = DownloadMedia(ts.Client(), ts.URL, nil, 10, &config.Protocol{LowMemory: true})
+	assert.Equal(t, ErrFileTooBig, err)
+
+	media, err = DownloadMedia(ts.Client(), ts.URL, nil, 0, &config.Protocol{})
+	require.NoError(t, err)
+	assert.False(t, media.OnDisk())
+	assert.Equal(t, int64(19), media.Size())
+}
+
+func TestLowMemory(t *testing.T) {
+	assert.Equal(t, 5000, CacheSize(&config.Protocol{}, 5000))
+	assert.Equal(t, 500, CacheSize(&config.Protocol{LowMemory: true}, 5000))
+
+	data := []byte("hello")
+	msg := &config.Message{Extra: make(map[string][]interface{})}
+	HandleDownloadData(logrus.NewEntry(logrus.New()), msg, "a.txt", "", "", &data, &config.Protocol{LowMemory: true})
+	fi := msg.Extra["file"][0].(config.FileInfo)
+	content, err := fi.Bytes()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+	assert.Equal(t, int64(5), fi.DataSize())
+}
+
+func TestHandleExtraLinks(t *testing.T) {
+	assert.Equal(t, "512 B", FormatSize(512))
+	assert.Equal(t, "2.5 MB", FormatSize(2500000))
+
+	general := &config.Protocol{MediaDownloadSize: 1000000}
+	msg := &config.Message{Text: "look", Extra: make(map[string][]interface{})}
+	require.Error(t, CheckDownload(logrus.NewEntry(logrus.New()), msg, DownloadInfo{Name: "a.mp4", Size: 2500000, URL: "https://example.com/a.mp4"}, general))
+	require.Error(t, HandleDownloadSize(logrus.NewEntry(logrus.New()), msg, "b.mp4", 2500000, general))
+	msg.Extra[config.EventFileFailureSize] = append(msg.Extra[config.EventFileFailureSize], config.FileInfo{Name: "c.png", Size: 5000})
+	texts := []string{}
+	for _, rmsg := range HandleExtra(msg, general) {
+		texts = append(texts, rmsg.Text)
+	}
+	assert.Equal(t, []string{
+		"a.mp4 (2.5 MB): https://example.com/a.mp4",
+		"file b.mp4 too big to download (2500000 > allowed size: 1000000)",
+		"file c.png too big to relay (5.0 kB)",
+	}, texts)
+}
@@ -0,0 +1,277 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // decode gif images
+	"image/jpeg"
+	"image/png"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	_ "golang.org/x/image/webp" // decode webp images
+)
+
+const (
+	imagePNG  = "png"
+	imageJPEG = "jpeg"
+
+	thumbnailQuality = 75
+
+	// maxImagePixels is the size of the biggest image decoded, an image of a few KB can
+	// claim to be of 100000x100000 pixels and use GBs once decoded.
+	maxImagePixels = 50 * 1000 * 1000
+
+	// the limits of ImageMagick, which decodes heic and tiff images sent by anyone
+	magickMaxDimension = 16384
+	magickMemory       = "256MiB"
+	magickTimeout      = 30 * time.Second
+)
+
+// convertedImages are the formats most bridges can't show, they're converted with
+// MediaConvertImages. Webp is decoded natively, heic and tiff with ImageMagick.
+var convertedImages = map[string]bool{".webp": true, ".heic": true, ".heif": true, ".tif": true, ".tiff": true}
+
+// externalImages are decoded with ImageMagick.
+var externalImages = map[string]bool{".heic": true, ".heif": true, ".tif": true, ".tiff": true}
+
+// jpegQualities are tried in turn to compress an image below MediaMaxImageSize, before
+// scaling it down.
+var jpegQualities = []int{85, 70, 55}
+
+// ImageOptions are the settings of the image pipeline of the [general] section.
+type ImageOptions struct {
+	Format        string // png or jpeg, the format of the converted images
+	MaxDimension  int    // pixels of the longest side
+	MaxSize       int    // bytes
+	ThumbnailSize int    // pixels of the longest side of the thumbnails
+}
+
+// NewImageOptions returns the image pipeline settings of general.
+func NewImageOptions(general *config.Protocol) ImageOptions {
+	format := strings.ToLower(general.MediaConvertImages)
+	if format == "jpg" {
+		format = imageJPEG
+	}
+	return ImageOptions{
+		Format:        format,
+		MaxDimension:  general.MediaMaxDimension,
+		MaxSize:       general.MediaMaxImageSize,
+		ThumbnailSize: general.MediaThumbnailSize,
+	}
+}
+
+// Enabled returns true if the pipeline changes images or makes thumbnails.
+func (o ImageOptions) Enabled() bool {
+	return o.Format != "" || o.MaxDimension > 0 || o.MaxSize > 0 || o.ThumbnailSize > 0
+}
+
+// IsImage returns true if name is an image the pipeline handles.
+func IsImage(name string) bool {
+	switch ext := strings.ToLower(filepath.Ext(name)); ext {
+	case ".png", ".jpg", ".jpeg", ".gif":
+		return true
+	default:
+		return convertedImages[ext]
+	}
+}
+
+// ProcessImage runs data, the image name, through the pipeline of opts: the webp, heic and
+// tiff images are converted to the Format of opts, the images bigger than MaxDimension are
+// scaled down and the images bigger than MaxSize are compressed as jpeg, and scaled down
+// until they fit. It returns the new data and name, unchanged when there's nothing to do.
+// Gifs are kept as they are, they would lose their animation.
+func ProcessImage(data []byte, name string, opts ImageOptions) ([]byte, string, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	convert := opts.Format != "" && convertedImages[ext]
+	tooBig := opts.MaxSize > 0 && len(data) > opts.MaxSize
+	if ext == ".gif" || (!convert && !tooBig && opts.MaxDimension <= 0) {
+		return data, name, nil
+	}
+	img, format, err := decodeImage(data, ext)
+	if err != nil {
+		return data, name, err
+	}
+	scaled := fit(img, opts.MaxDimension)
+	if !convert && !tooBig && scaled == img {
+		return data, name, nil
+	}
+	img = scaled
+	if convert {
+		format = opts.Format
+	}
+	if format != imageJPEG {
+		format = imagePNG
+	}
+	out, err := encodeImage(img, format, jpegQualities[0])
+	for i := 0; err == nil && opts.MaxSize > 0 && len(out) > opts.MaxSize; i++ {
+		if i >= len(jpegQualities) {
+			b := img.Bounds()
+			if b.Dx() < 64 && b.Dy() < 64 {
+				break
+			}
+			img = fit(img, max(b.Dx(), b.Dy())/2)
+		}
+		format = imageJPEG
+		out, err = encodeImage(img, format, jpegQualities[min(i, len(jpegQualities)-1)])
+	}
+	if err != nil {
+		return data, name, err
+	}
+	return out, withImageExt(name, format), nil
+}
+
+// Thumbnail returns a jpeg of the image data named name, with its longest side size pixels
+// at most. Its name is ThumbnailName(name).
+func Thumbnail(data []byte, name string, size int) ([]byte, error) {
+	img, _, err := decodeImage(data, strings.ToLower(filepath.Ext(name)))
+	if err != nil {
+		return nil, err
+	}
+	return encodeImage(fit(img, size), imageJPEG, thumbnailQuality)
+}
+
+// ThumbnailName returns the name of the thumbnail of the image name.
+func ThumbnailName(name string) string {
+	return withImageExt("thumb_"+name, imageJPEG)
+}
+
+// ConvertImage converts data, the image name, to format (png or jpeg).
+func ConvertImage(data *[]byte, name, format string) error {
+	img, _, err := decodeImage(*data, strings.ToLower(filepath.Ext(name)))
+	if err != nil {
+		return err
+	}
+	out, err := encodeImage(img, format, jpegQualities[0])
+	if err != nil {
+		return err
+	}
+	*data = out
+	return nil
+}
+
+func decodeImage(data []byte, ext string) (image.Image, string, error) {
+	if externalImages[ext] {
+		converted, err := convertExternal(data, strings.TrimPrefix(ext, "."))
+		if err != nil {
+			return nil, "", fmt.Errorf("converting %s failed: %s", ext, err)
+		}
+		data = converted
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		return nil, "", fmt.Errorf("image of %dx%d pixels is too big", cfg.Width, cfg.Height)
+	}
+	return image.Decode(bytes.NewReader(data))
+}
+
+// convertExternal converts data of format to png with ImageMagick.
+// This relies on an external command, like ConvertTgsToX. ImageMagick runs with limits on
+// the dimensions, memory and time, so a crafted image can't use all the resources.
+func convertExternal(data []byte, format string) ([]byte, error) {
+	command := "magick"
+	if _, err := exec.LookPath(command); err != nil {
+		// ImageMagick 6
+		command = "convert"
+		if _, err := exec.LookPath(command); err != nil {
+			return nil, errors.New("ImageMagick isn't installed")
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), magickTimeout)
+	defer cancel()
+	dimension := strconv.Itoa(magickMaxDimension)
+	cmd := exec.CommandContext(ctx, command,
+		"-limit", "width", dimension, "-limit", "height", dimension,
+		"-limit", "memory", magickMemory, "-limit", "time", strconv.Itoa(int(magickTimeout.Seconds())),
+		format+":-", "png:-")
+	cmd.Stdin = bytes.NewReader(data)
+	return cmd.Output()
+}
+
+func encodeImage(img image.Image, format string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if format == imageJPEG {
+		// jpeg has no transparency, the transparent parts are white
+		b := img.Bounds()
+		flat := image.NewRGBA(b)
+		draw.Draw(flat, b, image.White, image.Point{}, draw.Src)
+		draw.Draw(flat, b, img, b.Min, draw.Over)
+		err = jpeg.Encode(&buf, flat, &jpeg.Options{Quality: quality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+// fit returns img scaled down to have its longest side size pixels, img itself when it's
+// smaller or size is 0. The pixels are averaged, which is good enough for the downscaling.
+func fit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if size <= 0 || (w <= size && h <= size) {
+		return img
+	}
+	if w >= h {
+		w, h = size, max(1, h*size/w)
+	} else {
+		w, h = max(1, w*size/h), size
+	}
+	src := toRGBA(img)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+			var sum [4]uint64
+			var n uint64
+			for sy := y0; sy < max(y1, y0+1); sy++ {
+				row := src.Pix[src.PixOffset(x0, sy):]
+				for i := 0; i < 4*(max(x1, x0+1)-x0); i += 4 {
+					sum[0], sum[1], sum[2], sum[3] = sum[0]+uint64(row[i]), sum[1]+uint64(row[i+1]), sum[2]+uint64(row[i+2]), sum[3]+uint64(row[i+3])
+					n++
+				}
+			}
+			px := dst.Pix[dst.PixOffset(x, y):]
+			for i := range sum {
+				px[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// toRGBA returns img as an *image.RGBA, of which the pixels can be read without the cost
+// of img.At.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, img, b.Min, draw.Src)
+	return rgba
+}
+
+// withImageExt returns name with the extension of format.
+func withImageExt(name, format string) string {
+	ext := ".png"
+	if format == imageJPEG {
+		ext = ".jpg"
+	}
+	if cur := strings.ToLower(filepath.Ext(name)); cur == ext || (ext == ".jpg" && cur == ".jpeg") {
+		return name
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ext
+}
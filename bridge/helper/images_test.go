@@ -0,0 +1,105 @@
+package helper
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	rnd := rand.New(rand.NewSource(1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(rnd.Intn(256)), uint8(x), uint8(y), 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func imageSize(t *testing.T, data []byte) (int, int) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	return cfg.Width, cfg.Height
+}
+
+func TestProcessImage(t *testing.T) {
+	data := testPNG(t, 400, 200)
+
+	// nothing to do
+	out, name, err := ProcessImage(data, "a.png", ImageOptions{MaxDimension: 1000, MaxSize: len(data)})
+	require.NoError(t, err)
+	assert.Equal(t, "a.png", name)
+	assert.Equal(t, data, out)
+
+	out, name, err = ProcessImage(data, "a.png", ImageOptions{MaxDimension: 100})
+	require.NoError(t, err)
+	assert.Equal(t, "a.png", name)
+	w, h := imageSize(t, out)
+	assert.Equal(t, []int{100, 50}, []int{w, h})
+
+	// compressed as jpeg, then scaled down until it fits
+	out, name, err = ProcessImage(data, "a.png", ImageOptions{MaxSize: 5000})
+	require.NoError(t, err)
+	assert.Equal(t, "a.jpg", name)
+	assert.LessOrEqual(t, len(out), 5000)
+	w, _ = imageSize(t, out)
+	assert.Less(t, w, 400)
+
+	_, _, err = ProcessImage([]byte("not an image"), "b.png", ImageOptions{MaxDimension: 100})
+	assert.Error(t, err)
+	// gifs keep their animation
+	out, _, err = ProcessImage([]byte("GIF89a"), "c.gif", ImageOptions{MaxDimension: 100})
+	require.NoError(t, err)
+	assert.Equal(t, "GIF89a", string(out))
+}
+
+func TestThumbnail(t *testing.T) {
+	thumb, err := Thumbnail(testPNG(t, 200, 400), "a.png", 64)
+	require.NoError(t, err)
+	w, h := imageSize(t, thumb)
+	assert.Equal(t, []int{32, 64}, []int{w, h})
+	assert.Equal(t, "image/jpeg", http.DetectContentType(thumb))
+	assert.Equal(t, "thumb_a.jpg", ThumbnailName("a.png"))
+	assert.Equal(t, "thumb_b.jpeg", ThumbnailName("b.jpeg"))
+
+	assert.True(t, IsImage("photo.HEIC"))
+	assert.False(t, IsImage("notes.txt"))
+}
+
+func TestDecodeImageTooBig(t *testing.T) {
+	// a gif header claiming 10000x10000 pixels
+	data := []byte("GIF89a\x10\x27\x10\x27\x00\x00\x00;")
+	_, _, err := decodeImage(data, ".gif")
+	assert.EqualError(t, err, "image of 10000x10000 pixels is too big")
+	_, err = Thumbnail(data, "a.gif", 100)
+	assert.Error(t, err)
+}
+
+func TestFit(t *testing.T) {
+	// not an *image.RGBA and not starting at 0,0
+	img := image.NewNRGBA(image.Rect(10, 10, 410, 210))
+	for y := 10; y < 210; y++ {
+		for x := 10; x < 410; x++ {
+			c := color.NRGBA{R: 200, G: 100, B: 50, A: 255}
+			if x >= 210 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	scaled := fit(img, 100)
+	assert.Equal(t, image.Rect(0, 0, 100, 50), scaled.Bounds())
+	assert.Equal(t, color.RGBA{R: 200, G: 100, B: 50, A: 255}, scaled.At(10, 10))
+	assert.Equal(t, color.RGBA{B: 255, A: 255}, scaled.At(90, 40))
+	assert.Same(t, image.Image(img), fit(img, 1000))
+}
@@ -77,6 +77,8 @@ func SupportsFormat(format string) bool {
 	switch format {
 	case "png":
 		fallthrough
+	case "gif":
+		fallthrough
 	case "webp":
 		return true
 	default:
@@ -0,0 +1,167 @@
+package helper
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const s3DefaultRegion = "us-east-1"
+
+// S3Client puts files in a bucket of S3 or S3 compatible storage like MinIO, signing the
+// requests with AWS signature version 4.
+type S3Client struct {
+	Client    *http.Client
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	ACL       string
+	// PathStyle puts the bucket in the path of the URLs instead of in the host name,
+	// which is what S3 compatible storage on a custom endpoint expects.
+	PathStyle bool
+
+	now func() time.Time
+}
+
+// NewS3Client returns the client for the MediaS3 settings of general. The credentials
+// default to the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables.
+func NewS3Client(general *config.Protocol) *S3Client {
+	c := &S3Client{
+		Client:    &http.Client{Timeout: time.Minute},
+		Endpoint:  strings.TrimSuffix(general.MediaS3Endpoint, "/"),
+		Bucket:    general.MediaS3Bucket,
+		Region:    general.MediaS3Region,
+		AccessKey: general.MediaS3AccessKey,
+		SecretKey: general.MediaS3SecretKey,
+		ACL:       general.MediaS3ACL,
+		PathStyle: general.MediaS3Endpoint != "",
+		now:       time.Now,
+	}
+	if c.Region == "" {
+		c.Region = s3DefaultRegion
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	if c.AccessKey == "" {
+		c.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if c.SecretKey == "" {
+		c.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	return c
+}
+
+// URL returns the URL of the object key in the bucket.
+func (c *S3Client) URL(key string) string {
+	if c.PathStyle {
+		return c.Endpoint + "/" + c.Bucket + "/" + s3Escape(key)
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return c.Endpoint + "/" + c.Bucket + "/" + s3Escape(key)
+	}
+	return u.Scheme + "://" + c.Bucket + "." + u.Host + "/" + s3Escape(key)
+}
+
+// Put uploads the size bytes of body with SHA256 payloadHash, in hex, as the object key.
+func (c *S3Client) Put(key string, body io.Reader, size int64, payloadHash, contentType string) error {
+	req, err := http.NewRequest("PUT", c.URL(key), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	if c.ACL != "" {
+		req.Header.Set("X-Amz-Acl", c.ACL)
+	}
+	c.sign(req, payloadHash)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3 upload of %s failed: %s %s", key, resp.Status, msg)
+	}
+	return nil
+}
+
+// sign adds the AWS signature version 4 of req to its headers.
+func (c *S3Client) sign(req *http.Request, payloadHash string) {
+	t := c.now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + c.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), date)
+	for _, s := range []string{c.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+c.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// s3Escape escapes the segments of the object key like S3 expects in the path.
+func s3Escape(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(s), "+", "%2B")
+	}
+	return strings.Join(segments, "/")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,68 @@
+package bridge
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+)
+
+// downloadTimeout is the timeout of DownloadFile and DownloadMedia.
+const downloadTimeout = 5 * time.Second
+
+// HTTPHeader returns the headers set with HTTPHeaders and UserAgent, which are added to
+// the requests of HTTPClient.
+func (b *Bridge) HTTPHeader() http.Header {
+	header := http.Header{}
+	for _, h := range b.GetStringSlice2D("HTTPHeaders") {
+		if len(h) != 2 || h[0] == "" {
+			b.Log.Errorf("invalid HTTPHeaders entry %v, it must be [\"name\", \"value\"]", h)
+			continue
+		}
+		header.Add(h[0], h[1])
+	}
+	if ua := b.GetString("UserAgent"); ua != "" {
+		header.Set("User-Agent", ua)
+	}
+	return header
+}
+
+// headerTransport adds header to the requests of base.
+type headerTransport struct {
+	base   http.RoundTripper
+	header http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range t.header {
+		req.Header[name] = values
+	}
+	return t.base.RoundTrip(req)
+}
+
+// HTTPClient returns a http.Client that dials using DialContext and adds the headers of
+// HTTPHeader to its requests.
+func (b *Bridge) HTTPClient(timeout time.Duration) *http.Client {
+	var transport http.RoundTripper = b.HTTPTransport()
+	if header := b.HTTPHeader(); len(header) > 0 {
+		transport = &headerTransport{base: transport, header: header}
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+}
+
+// DownloadFile downloads url like helper.DownloadFile with the HTTPClient of the account,
+// the headers of header are added to the request.
+func (b *Bridge) DownloadFile(url string, header http.Header) (*[]byte, error) {
+	return helper.DownloadFileClient(b.HTTPClient(downloadTimeout), url, header)
+}
+
+// DownloadMedia downloads url like helper.DownloadMedia with the HTTPClient of the account,
+// so it's written to a temporary file as it's downloaded with LowMemory.
+func (b *Bridge) DownloadMedia(url string, header http.Header) (*config.Media, error) {
+	return helper.DownloadMedia(b.HTTPClient(downloadTimeout), url, header, 0, b.General)
+}
@@ -51,17 +51,10 @@ func (b *Birc) handleFiles(msg *config.Message) bool {
 		return false
 	}
 	for _, f := range msg.Extra["file"] {
-		fi := f.(config.FileInfo)
-		if fi.Comment != "" {
-			msg.Text += fi.Comment + " : "
+		if text := helper.FileText(f.(config.FileInfo)); text != "" {
+			msg.Text = text
 		}
-		if fi.URL != "" {
-			msg.Text = fi.URL
-			if fi.Comment != "" {
-				msg.Text = fi.Comment + " : " + fi.URL
-			}
-		}
-		b.Local <- config.Message{Text: msg.Text, Username: msg.Username, Channel: msg.Channel, Event: msg.Event}
+		b.Local <- config.Message{Text: msg.Text, Username: msg.Username, Channel: msg.Channel, Event: msg.Event, Account: msg.Account, UserID: msg.UserID, Protocol: msg.Protocol}
 	}
 	return true
 }
@@ -80,13 +73,25 @@ func (b *Birc) handleInvite(client *girc.Client, event girc.Event) {
 	}
 }
 
+// joinPartEvents are the events of the IRC join and leave commands.
+var joinPartEvents = map[string]string{
+	"JOIN": config.EventJoin,
+	"PART": config.EventPart,
+	"QUIT": config.EventQuit,
+	"KICK": config.EventKick,
+}
+
 func (b *Birc) handleJoinPart(client *girc.Client, event girc.Event) {
-	if len(event.Params) == 0 {
+	if len(event.Params) == 0 && event.Command != "QUIT" {
 		b.Log.Debugf("handleJoinPart: empty Params? %#v", event)
 		return
 	}
-	channel := strings.ToLower(event.Params[0])
-	if event.Command == "KICK" && event.Params[1] == b.Nick {
+	var channel string
+	// the parameter of a quit is its reason, a quit is for all channels
+	if event.Command != "QUIT" {
+		channel = strings.ToLower(event.Params[0])
+	}
+	if event.Command == "KICK" && len(event.Params) > 1 && event.Params[1] == b.Nick {
 		b.Log.Infof("Got kicked from %s by %s", channel, event.Source.Name)
 		time.Sleep(time.Duration(b.GetInt("RejoinDelay")) * time.Second)
 		b.Remote <- config.Message{Username: "system", Text: "rejoin", Channel: channel, Account: b.Account, Event: config.EventRejoinChannels}
@@ -100,16 +105,19 @@ func (b *Birc) handleJoinPart(client *girc.Client, event girc.Event) {
 		}
 	}
 	if event.Source.Name != b.Nick {
-		if b.GetBool("nosendjoinpart") {
+		if b.GetBool("nosendjoinpart") || b.isPuppet(event.Source.Name) {
 			return
 		}
-		msg := config.Message{Username: "system", Text: event.Source.Name + " " + strings.ToLower(event.Command) + "s", Channel: channel, Account: b.Account, Event: config.EventJoinLeave}
+		source := event.Source.Name
 		if b.GetBool("verbosejoinpart") {
-			b.Log.Debugf("<= Sending verbose JOIN_LEAVE event from %s to gateway", b.Account)
-			msg = config.Message{Username: "system", Text: event.Source.Name + " (" + event.Source.Ident + "@" + event.Source.Host + ") " + strings.ToLower(event.Command) + "s", Channel: channel, Account: b.Account, Event: config.EventJoinLeave}
-		} else {
-			b.Log.Debugf("<= Sending JOIN_LEAVE event from %s to gateway", b.Account)
+			source += " (" + event.Source.Ident + "@" + event.Source.Host + ")"
 		}
+		text := source + " " + strings.ToLower(event.Command) + "s"
+		if event.Command == "KICK" && len(event.Params) > 1 {
+			text += " " + event.Params[1]
+		}
+		msg := config.Message{Username: "system", Text: text, Channel: channel, Account: b.Account, Event: joinPartEvents[event.Command]}
+		b.Log.Debugf("<= Sending %s event from %s to gateway", msg.Event, b.Account)
 		b.Log.Debugf("<= Message is %#v", msg)
 		b.Remote <- msg
 		return
@@ -117,6 +125,57 @@ func (b *Birc) handleJoinPart(client *girc.Client, event girc.Event) {
 	b.Log.Debugf("handle %#v", event)
 }
 
+// handleMode sends a ban event for the bans set with a +b mode change, other mode changes
+// are ignored.
+func (b *Birc) handleMode(client *girc.Client, event girc.Event) {
+	if len(event.Params) < 3 || event.Params[1] != "+b" || event.Source == nil || b.GetBool("nosendjoinpart") {
+		return
+	}
+	msg := config.Message{
+		Username: "system",
+		Text:     event.Source.Name + " bans " + event.Params[2],
+		Channel:  strings.ToLower(event.Params[0]),
+		Account:  b.Account,
+		Event:    config.EventBan,
+		Extra:    map[string][]interface{}{config.ExtraBanned: banMaskUsers(event.Params[2])},
+	}
+	b.Log.Debugf("<= Sending %s event from %s to gateway", msg.Event, b.Account)
+	b.Remote <- msg
+}
+
+// handleNick sends a nick change event for the nick changes of the users, for all channels.
+func (b *Birc) handleNick(client *girc.Client, event girc.Event) {
+	if event.Source == nil || len(event.Params) == 0 {
+		return
+	}
+	nick := event.Last()
+	if event.Source.Name == b.Nick || b.isPuppet(event.Source.Name) || b.isPuppet(nick) {
+		return
+	}
+	msg := config.Message{
+		Username: event.Source.Name,
+		UserID:   event.Source.Ident + "@" + event.Source.Host,
+		Text:     nick,
+		Account:  b.Account,
+		Event:    config.EventNickChange,
+	}
+	b.Log.Debugf("<= Sending nick change of %s to %s from %s to gateway", msg.Username, nick, b.Account)
+	b.Remote <- msg
+}
+
+// banMaskUsers returns the nick and the ident@host (the UserID of the messages from IRC) of
+// the ban mask nick!ident@host, the parts with wildcards are left out.
+func banMaskUsers(mask string) []interface{} {
+	var users []interface{}
+	nick, userhost, _ := strings.Cut(mask, "!")
+	for _, user := range []string{nick, userhost} {
+		if user != "" && !strings.ContainsAny(user, "*?$:") {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
 func (b *Birc) handleNewConnection(client *girc.Client, event girc.Event) {
 	b.Log.Debug("Registering callbacks")
 	i := b.i
@@ -131,7 +190,10 @@ func (b *Birc) handleNewConnection(client *girc.Client, event girc.Event) {
 	i.Handlers.Clear("PART")
 	i.Handlers.Clear("QUIT")
 	i.Handlers.Clear("KICK")
+	i.Handlers.Clear("MODE")
+	i.Handlers.Clear("NICK")
 	i.Handlers.Clear("INVITE")
+	i.Handlers.Clear("TAGMSG")
 
 	i.Handlers.AddBg("PRIVMSG", b.handlePrivMsg)
 	i.Handlers.Add(girc.RPL_TOPICWHOTIME, b.handleTopicWhoTime)
@@ -140,7 +202,10 @@ func (b *Birc) handleNewConnection(client *girc.Client, event girc.Event) {
 	i.Handlers.AddBg("PART", b.handleJoinPart)
 	i.Handlers.AddBg("QUIT", b.handleJoinPart)
 	i.Handlers.AddBg("KICK", b.handleJoinPart)
+	i.Handlers.AddBg("MODE", b.handleMode)
+	i.Handlers.AddBg("NICK", b.handleNick)
 	i.Handlers.Add("INVITE", b.handleInvite)
+	i.Handlers.AddBg("TAGMSG", b.handleTagMsg)
 }
 
 func (b *Birc) handleNickServ() {
@@ -200,6 +265,10 @@ func (b *Birc) handlePrivMsg(client *girc.Client, event girc.Event) {
 		Channel:  strings.ToLower(event.Params[0]),
 		Account:  b.Account,
 		UserID:   event.Source.Ident + "@" + event.Source.Host,
+		ID:       eventMsgID(event),
+	}
+	if parent, ok := event.Tags.Get(tagReply); ok {
+		rmsg.ParentID = parent
 	}
 
 	b.Log.Debugf("== Receiving PRIVMSG: %s %s %#v", event.Source.Name, event.Last(), event)
@@ -251,10 +320,42 @@ func (b *Birc) handlePrivMsg(client *girc.Client, event girc.Event) {
 		rmsg.Text = string(output)
 	}
 
+	if roles := b.userRoles(event.Source.Name, rmsg.Channel); len(roles) > 0 {
+		rmsg.Extra = map[string][]interface{}{config.ExtraRoles: roles}
+	}
+
 	b.Log.Debugf("<= Sending message from %s on %s to gateway", event.Params[0], b.Account)
 	b.Remote <- rmsg
 }
 
+// userRoles returns the channel modes of nick in channel, see config.ExtraRoles.
+func (b *Birc) userRoles(nick, channel string) []interface{} {
+	user := b.i.LookupUser(nick)
+	if user == nil {
+		return nil
+	}
+	perms, ok := user.Perms.Lookup(channel)
+	if !ok {
+		return nil
+	}
+	var roles []interface{}
+	for _, mode := range []struct {
+		set  bool
+		role string
+	}{
+		{perms.Owner, "owner"},
+		{perms.Admin, "admin"},
+		{perms.Op, "op"},
+		{perms.HalfOp, "halfop"},
+		{perms.Voice, "voice"},
+	} {
+		if mode.set {
+			roles = append(roles, mode.role)
+		}
+	}
+	return roles
+}
+
 func (b *Birc) handleRunCommands() {
 	for _, cmd := range b.GetStringSlice("RunCommands") {
 		cmd = strings.ReplaceAll(cmd, "{BOTNICK}", b.Nick)
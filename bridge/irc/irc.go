@@ -32,6 +32,8 @@ type Birc struct {
 	FirstConnection, authDone                 bool
 	MessageDelay, MessageQueue, MessageLength int
 	channels                                  map[string]bool
+	keys                                      map[string]string // the keys of the channels, for the puppets
+	puppets                                   *puppets
 
 	*bridge.Config
 }
@@ -43,6 +45,8 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	b.names = make(map[string][]string)
 	b.connected = make(chan error)
 	b.channels = make(map[string]bool)
+	b.keys = make(map[string]string)
+	b.puppets = newPuppets()
 
 	if b.GetInt("MessageDelay") == 0 {
 		b.MessageDelay = 1300
@@ -110,17 +114,62 @@ func (b *Birc) Connect() error {
 		i.Handlers.Clear(girc.ALL_EVENTS)
 	}
 	go b.doSend()
+	if b.GetBool("Puppets") {
+		b.puppets.stop = make(chan struct{})
+		go b.expirePuppets(b.puppets.stop)
+	}
 	return nil
 }
 
 func (b *Birc) Disconnect() error {
 	b.i.Close()
 	close(b.Local)
+	if b.puppets.stop != nil {
+		close(b.puppets.stop)
+		b.puppets.stop = nil
+	}
+	return nil
+}
+
+// Reload applies the new MessageLength and changes the nick when Nick was changed.
+func (b *Birc) Reload(cfg config.Config) error {
+	if b.GetInt("MessageLength") == 0 {
+		b.MessageLength = 400
+	} else {
+		b.MessageLength = b.GetInt("MessageLength")
+	}
+	nick := b.GetString("Nick")
+	if b.i == nil || nick == "" || nick == b.i.Config.Nick {
+		return nil
+	}
+	b.Log.Infof("Changing nick to %s", nick)
+	// used again when reconnecting
+	b.i.Config.Nick = nick
+	if b.i.IsConnected() {
+		b.i.Cmd.Nick(nick)
+	}
 	return nil
 }
 
+// ChannelUsers implements bridge.UserLister with the nicks girc tracks in channel.
+func (b *Birc) ChannelUsers(channel string) ([]string, error) {
+	if b.i == nil || !b.i.IsConnected() {
+		return nil, errors.New("not connected")
+	}
+	ch := b.i.LookupChannel(channel)
+	if ch == nil {
+		return nil, fmt.Errorf("not in %s", channel)
+	}
+	var nicks []string
+	for _, user := range ch.Users(b.i) {
+		nicks = append(nicks, user.Nick)
+	}
+	return nicks, nil
+}
+
 func (b *Birc) JoinChannel(channel config.ChannelInfo) error {
 	b.channels[channel.Name] = true
+	b.keys[channel.Name] = channel.Options.Key
 	// need to check if we have nickserv auth done before joining channels
 	for {
 		if b.authDone {
@@ -151,6 +200,14 @@ func (b *Birc) Send(msg config.Message) (string, error) {
 		return "", nil
 	}
 
+	// reactions are only sent when the server has message-tags, see NativeReactions
+	if msg.Event == config.EventReactionAdd || msg.Event == config.EventReactionRemove {
+		if msg.ParentValid() && b.hasMessageTags() && len(b.Local) < b.MessageQueue {
+			b.Local <- msg
+		}
+		return "", nil
+	}
+
 	// Execute a command
 	if strings.HasPrefix(msg.Text, "!") {
 		b.Command(&msg)
@@ -184,13 +241,15 @@ func (b *Birc) Send(msg config.Message) (string, error) {
 
 		msg.Text = msgLines[i]
 		b.Local <- msg
+		// only the first line is the reply
+		msg.ParentID = ""
 	}
 	return "", nil
 }
 
 func (b *Birc) doConnect() {
 	for {
-		if err := b.i.Connect(); err != nil {
+		if err := b.i.DialerConnect(b.Bridge); err != nil {
 			b.Log.Errorf("disconnect: error: %s", err)
 			if b.FirstConnection {
 				b.connected <- err
@@ -226,6 +285,14 @@ func (b *Birc) doSend() {
 	throttle := time.NewTicker(rate)
 	for msg := range b.Local {
 		<-throttle.C
+		if msg.Event == config.EventReactionAdd || msg.Event == config.EventReactionRemove {
+			b.i.Send(reactionEvent(&msg))
+			continue
+		}
+		tags := b.replyTags(&msg)
+		if b.GetBool("Puppets") && b.sendPuppet(&msg, tags) {
+			continue
+		}
 		username := msg.Username
 		// Optional support for the proposed RELAYMSG extension, described at
 		// https://github.com/jlu5/ircv3-specifications/blob/master/extensions/relaymsg.md
@@ -239,12 +306,16 @@ func (b *Birc) doSend() {
 			if strings.HasPrefix(text, ":") && !strings.ContainsRune(text, ' ') {
 				text = ":" + text
 			}
+			var prefix string
+			if tags != nil {
+				prefix = tags.String() + " "
+			}
 
 			if msg.Event == config.EventUserAction {
-				b.i.Cmd.SendRawf("RELAYMSG %s %s :\x01ACTION %s\x01", msg.Channel, username, text) //nolint:errcheck
+				b.i.Cmd.SendRawf("%sRELAYMSG %s %s :\x01ACTION %s\x01", prefix, msg.Channel, username, text) //nolint:errcheck
 			} else {
 				b.Log.Debugf("Sending RELAYMSG to channel %s: nick=%s", msg.Channel, username)
-				b.i.Cmd.SendRawf("RELAYMSG %s %s :%s", msg.Channel, username, text) //nolint:errcheck
+				b.i.Cmd.SendRawf("%sRELAYMSG %s %s :%s", prefix, msg.Channel, username, text) //nolint:errcheck
 			}
 		} else {
 			if b.GetBool("Colornicks") {
@@ -254,13 +325,13 @@ func (b *Birc) doSend() {
 			}
 			switch msg.Event {
 			case config.EventUserAction:
-				b.i.Cmd.Action(msg.Channel, username+msg.Text)
+				b.i.Send(&girc.Event{Command: girc.PRIVMSG, Params: []string{msg.Channel, "\x01ACTION " + username + msg.Text + "\x01"}, Tags: tags})
 			case config.EventNoticeIRC:
 				b.Log.Debugf("Sending notice to channel %s", msg.Channel)
-				b.i.Cmd.Notice(msg.Channel, username+msg.Text)
+				b.i.Send(&girc.Event{Command: girc.NOTICE, Params: []string{msg.Channel, username + msg.Text}, Tags: tags})
 			default:
 				b.Log.Debugf("Sending to channel %s", msg.Channel)
-				b.i.Cmd.Message(msg.Channel, username+msg.Text)
+				b.i.Send(&girc.Event{Command: girc.PRIVMSG, Params: []string{msg.Channel, username + msg.Text}, Tags: tags})
 			}
 		}
 	}
@@ -363,7 +434,7 @@ func (b *Birc) skipPrivMsg(event girc.Event) bool {
 	}
 	// don't forward message from ourself
 	if event.Source != nil {
-		if event.Source.Name == b.Nick {
+		if event.Source.Name == b.Nick || b.isPuppet(event.Source.Name) {
 			return true
 		}
 	}
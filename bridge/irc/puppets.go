@@ -0,0 +1,312 @@
+package birc
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/lrstanley/girc"
+)
+
+const (
+	defaultPuppetPoolSize    = 10
+	defaultPuppetIdleTimeout = 30 * time.Minute
+	// defaultNickLen is used when the server doesn't announce its NICKLEN.
+	defaultNickLen = 16
+)
+
+// puppet is the IRC connection of a remote user, its messages are sent with the nick of
+// the user instead of prefixed with it.
+type puppet struct {
+	key    string
+	nick   string // the nick the user should have, girc appends _ on collisions
+	client *girc.Client
+
+	mu       sync.Mutex
+	lastUsed time.Time
+	ready    bool            // registered with the server
+	joined   map[string]bool // false while joining
+}
+
+// puppets are the connections of the remote users that were active last.
+type puppets struct {
+	sync.Mutex
+	users map[string]*puppet
+	stop  chan struct{}
+}
+
+func newPuppets() *puppets {
+	return &puppets{users: make(map[string]*puppet)}
+}
+
+// puppetKey identifies the remote user that sent msg, it's empty for the messages of the
+// bridges themselves.
+func puppetKey(msg *config.Message) string {
+	if msg.Account == "" || msg.Username == "" || msg.Username == "system" {
+		return ""
+	}
+	user := msg.UserID
+	if user == "" {
+		user = msg.Username
+	}
+	return msg.Account + " " + user
+}
+
+// puppetNick makes a valid IRC nick of at most length bytes of username, which is
+// formatted with RemoteNickFormat.
+func puppetNick(username string, length int) string {
+	var sb strings.Builder
+	for _, r := range username {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("[]\\`^{}|_-", r):
+			sb.WriteRune(r)
+		}
+	}
+	nick := sb.String()
+	if nick == "" {
+		nick = "user"
+	}
+	if nick[0] == '-' || (nick[0] >= '0' && nick[0] <= '9') {
+		nick = "_" + nick
+	}
+	if len(nick) > length {
+		nick = nick[:length]
+	}
+	return nick
+}
+
+// get returns the puppet with key, or nil.
+func (ps *puppets) get(key string) *puppet {
+	ps.Lock()
+	defer ps.Unlock()
+	return ps.users[key]
+}
+
+// add adds p and returns the least recently used puppet when the pool is over size, it
+// has to quit.
+func (ps *puppets) add(p *puppet, size int) *puppet {
+	ps.Lock()
+	defer ps.Unlock()
+	var evicted *puppet
+	if len(ps.users) >= size {
+		for _, other := range ps.users {
+			if evicted == nil || other.used().Before(evicted.used()) {
+				evicted = other
+			}
+		}
+		delete(ps.users, evicted.key)
+	}
+	ps.users[p.key] = p
+	return evicted
+}
+
+// remove removes p, unless it was replaced already.
+func (ps *puppets) remove(p *puppet) {
+	ps.Lock()
+	defer ps.Unlock()
+	if ps.users[p.key] == p {
+		delete(ps.users, p.key)
+	}
+}
+
+// idle removes and returns the puppets that weren't used since before.
+func (ps *puppets) idle(before time.Time) []*puppet {
+	ps.Lock()
+	defer ps.Unlock()
+	var idle []*puppet
+	for key, p := range ps.users {
+		if p.used().Before(before) {
+			idle = append(idle, p)
+			delete(ps.users, key)
+		}
+	}
+	return idle
+}
+
+// all removes and returns all puppets.
+func (ps *puppets) all() []*puppet {
+	return ps.idle(time.Now().Add(time.Hour))
+}
+
+// isPuppet returns true when nick is the nick of one of the puppets.
+func (ps *puppets) isPuppet(nick string) bool {
+	ps.Lock()
+	defer ps.Unlock()
+	for _, p := range ps.users {
+		if strings.EqualFold(p.client.GetNick(), nick) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *puppet) used() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastUsed
+}
+
+// inChannel marks p as used and returns true when it's in channel. Otherwise it starts
+// joining channel once it's registered.
+func (p *puppet) inChannel(channel, key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastUsed = time.Now()
+	joined, joining := p.joined[channel]
+	if joined || joining || !p.ready {
+		return joined
+	}
+	p.joined[channel] = false
+	if key != "" {
+		p.client.Cmd.JoinKey(channel, key)
+	} else {
+		p.client.Cmd.Join(channel)
+	}
+	return false
+}
+
+// quit disconnects p, also when it's still connecting.
+func (p *puppet) quit() {
+	if p.client.IsConnected() {
+		p.client.Quit("")
+		return
+	}
+	p.client.Close()
+}
+
+func (p *puppet) handleWelcome(client *girc.Client, event girc.Event) {
+	p.mu.Lock()
+	p.ready = true
+	p.mu.Unlock()
+}
+
+// handleJoinPart keeps track of the channels p is in.
+func (p *puppet) handleJoinPart(client *girc.Client, event girc.Event) {
+	if len(event.Params) == 0 || event.Source == nil {
+		return
+	}
+	channel := strings.ToLower(event.Params[0])
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case event.Command == "JOIN" && event.Source.Name == client.GetNick():
+		p.joined[channel] = true
+	case event.Command == "PART" && event.Source.Name == client.GetNick(),
+		event.Command == "KICK" && len(event.Params) > 1 && event.Params[1] == client.GetNick():
+		delete(p.joined, channel)
+	}
+}
+
+func (b *Birc) puppetPoolSize() int {
+	if size := b.GetInt("PuppetPoolSize"); size > 0 {
+		return size
+	}
+	return defaultPuppetPoolSize
+}
+
+func (b *Birc) puppetIdleTimeout() time.Duration {
+	if idle := b.GetInt("PuppetIdleTimeout"); idle > 0 {
+		return time.Duration(idle) * time.Second
+	}
+	return defaultPuppetIdleTimeout
+}
+
+func (b *Birc) nickLen() int {
+	if v, ok := b.i.GetServerOption("NICKLEN"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultNickLen
+}
+
+// isPuppet returns true when nick is one of our puppets, their messages and joins must not
+// be relayed again.
+func (b *Birc) isPuppet(nick string) bool {
+	return b.GetBool("Puppets") && b.puppets.isPuppet(nick)
+}
+
+// sendPuppet sends msg with the puppet of its user and returns true, or returns false when
+// it has to be sent by the bridge: for messages without a user and while the puppet is
+// connecting and joining the channel.
+func (b *Birc) sendPuppet(msg *config.Message, tags girc.Tags) bool {
+	key := puppetKey(msg)
+	if key == "" {
+		return false
+	}
+	nick := puppetNick(msg.Username, b.nickLen())
+	p := b.puppets.get(key)
+	if p == nil {
+		b.newPuppet(key, nick, msg.Protocol)
+		return false
+	}
+	if nick != p.nick {
+		p.nick = nick
+		p.client.Cmd.Nick(nick)
+	}
+	if !p.inChannel(msg.Channel, b.keys[msg.Channel]) {
+		return false
+	}
+	event := &girc.Event{Command: girc.PRIVMSG, Params: []string{msg.Channel, msg.Text}, Tags: tags}
+	switch msg.Event {
+	case config.EventUserAction:
+		event.Params[1] = "\x01ACTION " + msg.Text + "\x01"
+	case config.EventNoticeIRC:
+		event.Command = girc.NOTICE
+	}
+	b.Log.Debugf("Sending to channel %s as %s", msg.Channel, p.client.GetNick())
+	p.client.Send(event)
+	return true
+}
+
+// newPuppet connects the puppet of the user with key, the least recently used puppet quits
+// when the pool is full.
+func (b *Birc) newPuppet(key, nick, protocol string) {
+	client, err := b.getClient()
+	if err != nil {
+		b.Log.Errorf("puppet %s: %s", nick, err)
+		return
+	}
+	client.Config.Nick = nick
+	client.Config.Name = "relayed from " + protocol
+	p := &puppet{key: key, nick: nick, client: client, lastUsed: time.Now(), joined: make(map[string]bool)}
+	client.Handlers.Add(girc.RPL_WELCOME, p.handleWelcome)
+	client.Handlers.Add(girc.JOIN, p.handleJoinPart)
+	client.Handlers.Add(girc.PART, p.handleJoinPart)
+	client.Handlers.Add(girc.KICK, p.handleJoinPart)
+	if evicted := b.puppets.add(p, b.puppetPoolSize()); evicted != nil {
+		b.Log.Debugf("puppet pool is full, %s quits", evicted.client.GetNick())
+		evicted.quit()
+	}
+	b.Log.Debugf("Connecting puppet %s", nick)
+	go func() {
+		if err := client.DialerConnect(b.Bridge); err != nil {
+			b.Log.Debugf("puppet %s disconnected: %s", nick, err)
+		}
+		b.puppets.remove(p)
+	}()
+}
+
+// expirePuppets lets the puppets that weren't used for PuppetIdleTimeout quit, until stop
+// is closed.
+func (b *Birc) expirePuppets(stop chan struct{}) {
+	idle := b.puppetIdleTimeout()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			for _, p := range b.puppets.idle(time.Now().Add(-idle)) {
+				b.Log.Debugf("puppet %s is idle, it quits", p.client.GetNick())
+				p.quit()
+			}
+		case <-stop:
+			for _, p := range b.puppets.all() {
+				p.quit()
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package birc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/lrstanley/girc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPuppetNick(t *testing.T) {
+	assert.Equal(t, "bob|discord", puppetNick("bob|discord", 16))
+	assert.Equal(t, "[discord]bob", puppetNick("[discord] <bob> ", 16))
+	assert.Equal(t, "_42", puppetNick("42", 16))
+	assert.Equal(t, "moji", puppetNick("émoji", 16))
+	assert.Equal(t, "user", puppetNick("🎉", 16))
+	assert.Equal(t, "averyl", puppetNick("averylongnickname", 6))
+}
+
+func TestPuppetKey(t *testing.T) {
+	assert.Equal(t, "discord.test 123", puppetKey(&config.Message{Account: "discord.test", Username: "bob", UserID: "123"}))
+	assert.Equal(t, "discord.test bob", puppetKey(&config.Message{Account: "discord.test", Username: "bob"}))
+	assert.Equal(t, "", puppetKey(&config.Message{Account: "discord.test", Username: "system"}))
+	assert.Equal(t, "", puppetKey(&config.Message{Username: "bob"}))
+}
+
+func newTestPuppet(key string, used time.Time) *puppet {
+	return &puppet{key: key, nick: key, client: girc.New(girc.Config{Nick: key}), lastUsed: used, joined: make(map[string]bool)}
+}
+
+func TestPuppetPool(t *testing.T) {
+	ps := newPuppets()
+	now := time.Now()
+	assert.Nil(t, ps.add(newTestPuppet("a", now.Add(-time.Hour)), 2))
+	assert.Nil(t, ps.add(newTestPuppet("b", now.Add(-time.Minute)), 2))
+	assert.True(t, ps.isPuppet("A"))
+
+	// the least recently used puppet makes room
+	evicted := ps.add(newTestPuppet("c", now), 2)
+	if assert.NotNil(t, evicted) {
+		assert.Equal(t, "a", evicted.key)
+	}
+	assert.Nil(t, ps.get("a"))
+	assert.False(t, ps.isPuppet("a"))
+
+	idle := ps.idle(now.Add(-30 * time.Second))
+	if assert.Len(t, idle, 1) {
+		assert.Equal(t, "b", idle[0].key)
+	}
+	assert.NotNil(t, ps.get("c"))
+
+	// a replaced puppet doesn't remove its replacement
+	old := ps.get("c")
+	ps.users["c"] = newTestPuppet("c", now)
+	ps.remove(old)
+	assert.NotNil(t, ps.get("c"))
+	assert.Len(t, ps.all(), 1)
+}
+
+func TestPuppetChannels(t *testing.T) {
+	p := newTestPuppet("bob", time.Time{})
+	// nothing is joined before the puppet is registered
+	assert.False(t, p.inChannel("#test", ""))
+	assert.Empty(t, p.joined)
+
+	p.ready = true
+	assert.False(t, p.inChannel("#test", ""))
+	assert.Equal(t, map[string]bool{"#test": false}, p.joined)
+	p.handleJoinPart(p.client, *girc.ParseEvent(":bob!b@host JOIN #Test"))
+	assert.True(t, p.inChannel("#test", ""))
+	p.handleJoinPart(p.client, *girc.ParseEvent(":op!o@host KICK #test bob :bye"))
+	assert.Empty(t, p.joined)
+}
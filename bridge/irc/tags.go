@@ -0,0 +1,100 @@
+package birc
+
+import (
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/lrstanley/girc"
+)
+
+// IRCv3 message IDs and the reply and react client tags, see
+// https://ircv3.net/specs/extensions/message-ids, https://ircv3.net/specs/client-tags/reply
+// and https://ircv3.net/specs/client-tags/react
+const (
+	tagMsgID      = "msgid"
+	tagDraftMsgID = "draft/msgid"
+	tagReply      = "+draft/reply"
+	tagReact      = "+draft/react"
+	tagUnreact    = "+draft/unreact"
+)
+
+// tagValueEncoder escapes tag values, girc.Tags.Set only accepts ASCII and emoji aren't.
+var tagValueEncoder = strings.NewReplacer(`\`, `\\`, ";", `\:`, " ", `\s`, "\r", `\r`, "\n", `\n`)
+
+// hasMessageTags returns true when the server negotiated message-tags, without it the tags
+// of the messages we send are dropped by girc.
+func (b *Birc) hasMessageTags() bool {
+	return b.i != nil && b.i.IsConnected() && b.i.HasCapability("message-tags")
+}
+
+// NativeReplies implements bridge.Capabilities, replies are sent with +draft/reply.
+func (b *Birc) NativeReplies() bool {
+	return b.hasMessageTags()
+}
+
+// NativeReactions implements bridge.Capabilities, reactions are sent with +draft/react.
+func (b *Birc) NativeReactions() bool {
+	return b.hasMessageTags()
+}
+
+// eventMsgID returns the message ID the server gave event.
+func eventMsgID(event girc.Event) string {
+	if id, ok := event.Tags.Get(tagMsgID); ok {
+		return id
+	}
+	id, _ := event.Tags.Get(tagDraftMsgID)
+	return id
+}
+
+// replyTags returns the tags of msg when it's a reply to the message ParentID.
+func (b *Birc) replyTags(msg *config.Message) girc.Tags {
+	if !msg.ParentValid() || !b.hasMessageTags() {
+		return nil
+	}
+	return girc.Tags{tagReply: tagValueEncoder.Replace(msg.ParentID)}
+}
+
+// handleTagMsg sends the reactions of the IRC users, a TAGMSG with +draft/react or
+// +draft/unreact and the message they react to in +draft/reply, to the gateway.
+func (b *Birc) handleTagMsg(client *girc.Client, event girc.Event) {
+	if len(event.Params) == 0 || b.skipPrivMsg(event) {
+		return
+	}
+	parent, ok := event.Tags.Get(tagReply)
+	if !ok || parent == "" {
+		return
+	}
+	rmsg := config.Message{
+		Username: event.Source.Name,
+		Channel:  strings.ToLower(event.Params[0]),
+		Account:  b.Account,
+		UserID:   event.Source.Ident + "@" + event.Source.Host,
+		ParentID: parent,
+	}
+	if emoji, ok := event.Tags.Get(tagReact); ok && emoji != "" {
+		rmsg.Event, rmsg.Text = config.EventReactionAdd, emoji
+	} else if emoji, ok := event.Tags.Get(tagUnreact); ok && emoji != "" {
+		rmsg.Event, rmsg.Text = config.EventReactionRemove, emoji
+	} else {
+		return
+	}
+	b.Log.Debugf("<= Sending %s from %s on %s to gateway", rmsg.Event, rmsg.Username, b.Account)
+	b.Remote <- rmsg
+}
+
+// reactionEvent returns the TAGMSG that adds or removes the reaction msg on the message
+// ParentID.
+func reactionEvent(msg *config.Message) *girc.Event {
+	tag := tagReact
+	if msg.Event == config.EventReactionRemove {
+		tag = tagUnreact
+	}
+	return &girc.Event{
+		Command: "TAGMSG",
+		Params:  []string{msg.Channel},
+		Tags: girc.Tags{
+			tagReply: tagValueEncoder.Replace(msg.ParentID),
+			tag:      tagValueEncoder.Replace(msg.Text),
+		},
+	}
+}
@@ -0,0 +1,33 @@
+package birc
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/lrstanley/girc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventMsgID(t *testing.T) {
+	event := girc.ParseEvent("@msgid=abc;+draft/reply=def :bob!b@host PRIVMSG #test :hi")
+	assert.Equal(t, "abc", eventMsgID(*event))
+	parent, _ := event.Tags.Get(tagReply)
+	assert.Equal(t, "def", parent)
+	event = girc.ParseEvent("@draft/msgid=old :bob!b@host PRIVMSG #test :hi")
+	assert.Equal(t, "old", eventMsgID(*event))
+	event = girc.ParseEvent(":bob!b@host PRIVMSG #test :hi")
+	assert.Equal(t, "", eventMsgID(*event))
+}
+
+func TestReactionEvent(t *testing.T) {
+	event := reactionEvent(&config.Message{Event: config.EventReactionAdd, Channel: "#test", ParentID: "a;b", Text: "👍"})
+	assert.Equal(t, "@+draft/react=👍;+draft/reply=a\\:b TAGMSG #test", string(event.Bytes()))
+	event = reactionEvent(&config.Message{Event: config.EventReactionRemove, Channel: "#test", ParentID: "abc", Text: "👍"})
+	assert.Equal(t, "@+draft/reply=abc;+draft/unreact=👍 TAGMSG #test", string(event.Bytes()))
+
+	// the reactions of the servers are read back
+	event.Source = &girc.Source{Name: "bob", Ident: "b", Host: "host"}
+	parsed := girc.ParseEvent(string(event.Bytes()))
+	emoji, _ := parsed.Tags.Get(tagUnreact)
+	assert.Equal(t, "👍", emoji)
+}
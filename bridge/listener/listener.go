@@ -0,0 +1,189 @@
+// Package listener starts the built-in HTTP listeners of matterbridge (API bridge, ...)
+// and takes care of TLS for them, including ACME (Let's Encrypt) certificates.
+package listener
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Config describes a listener.
+type Config struct {
+	// Address to listen on, host:port or unix:/path/to/socket for a unix domain socket.
+	Address string
+	// SocketMode are the file permissions of a unix socket, as an octal string (eg "0660").
+	SocketMode string
+	// ACMEDomains enables ACME when non-empty, certificates are only requested for these domains.
+	ACMEDomains []string
+	// ACMECacheDir is where certificates and the ACME account key are stored.
+	ACMECacheDir string
+	// ACMEEmail is the contact address sent to the ACME provider.
+	ACMEEmail string
+	// ACMEDirectoryURL overrides the ACME provider, defaults to Let's Encrypt.
+	ACMEDirectoryURL string
+	// ACMEHTTPAddress is the address of the HTTP-01 challenge listener (usually :80).
+	// When empty only the TLS-ALPN-01 challenge is used, which needs Address to be on port 443.
+	ACMEHTTPAddress string
+}
+
+// NewConfig returns the Config of the listener on address with the SocketMode and ACME
+// settings of p, the [general] section or the section of a bridge.
+func NewConfig(address string, p *config.Protocol) Config {
+	return Config{
+		Address:          address,
+		SocketMode:       p.SocketMode,
+		ACMEDomains:      p.ACMEDomains,
+		ACMECacheDir:     p.ACMECacheDir,
+		ACMEEmail:        p.ACMEEmail,
+		ACMEDirectoryURL: p.ACMEDirectoryURL,
+		ACMEHTTPAddress:  p.ACMEHTTPAddress,
+	}
+}
+
+// Serve listens on cfg.Address and serves handler until an error occurs.
+func Serve(logger *logrus.Entry, cfg Config, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              cfg.Address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ln, err := ListenTLS(logger, cfg)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(ln)
+}
+
+// ListenTLS opens the socket described by cfg like Listen, with TLS when cfg has
+// ACMEDomains. The listeners with the same ACME settings share their certificates, and
+// their HTTP-01 challenge listener.
+func ListenTLS(logger *logrus.Entry, cfg Config) (net.Listener, error) {
+	ln, err := Listen(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.ACMEDomains) == 0 {
+		logger.Infof("Listening on %s", cfg.Address)
+		return ln, nil
+	}
+	m, err := acmeManager(logger, cfg)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	logger.Infof("Listening on %s (TLS, ACME for %v)", cfg.Address, cfg.ACMEDomains)
+	return tls.NewListener(ln, m.TLSConfig()), nil
+}
+
+// acmeManagers are the ACME managers of the listeners by their settings, and of the HTTP-01
+// challenge listeners by address.
+var acmeManagers = struct {
+	sync.Mutex
+	managers   map[string]*autocert.Manager
+	challenges map[string]*autocert.Manager
+}{
+	managers:   make(map[string]*autocert.Manager),
+	challenges: make(map[string]*autocert.Manager),
+}
+
+// acmeManager returns the manager of the ACME settings of cfg, and starts its HTTP-01
+// challenge listener when it's new.
+func acmeManager(logger *logrus.Entry, cfg Config) (*autocert.Manager, error) {
+	acmeManagers.Lock()
+	defer acmeManagers.Unlock()
+	key := strings.Join([]string{cfg.ACMECacheDir, cfg.ACMEEmail, cfg.ACMEDirectoryURL, strings.Join(cfg.ACMEDomains, ",")}, "\n")
+	if m, ok := acmeManagers.managers[key]; ok {
+		return m, nil
+	}
+	m, err := newManager(cfg)
+	if err != nil {
+		return nil, err
+	}
+	acmeManagers.managers[key] = m
+	if cfg.ACMEHTTPAddress == "" {
+		return m, nil
+	}
+	if _, ok := acmeManagers.challenges[cfg.ACMEHTTPAddress]; ok {
+		logger.Errorf("ACME HTTP-01 listener on %s is used by listeners with other ACME settings", cfg.ACMEHTTPAddress)
+		return m, nil
+	}
+	acmeManagers.challenges[cfg.ACMEHTTPAddress] = m
+	go func() {
+		logger.Infof("Listening for ACME HTTP-01 challenges on %s", cfg.ACMEHTTPAddress)
+		challenge := &http.Server{
+			Addr:              cfg.ACMEHTTPAddress,
+			Handler:           m.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		if err := challenge.ListenAndServe(); err != nil {
+			logger.Errorf("ACME HTTP-01 listener on %s failed: %s", cfg.ACMEHTTPAddress, err)
+		}
+	}()
+	return m, nil
+}
+
+// Listen opens the TCP or unix socket described by cfg.
+func Listen(cfg Config) (net.Listener, error) {
+	path, ok := unixPath(cfg.Address)
+	if !ok {
+		return net.Listen("tcp", cfg.Address)
+	}
+	// remove a stale socket of a previous run
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SocketMode != "" {
+		mode, err := strconv.ParseUint(cfg.SocketMode, 8, 32)
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("invalid SocketMode %s: %s", cfg.SocketMode, err)
+		}
+		if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+// unixPath returns the socket path if address is a unix:/path address.
+func unixPath(address string) (string, bool) {
+	if !strings.HasPrefix(address, "unix:") {
+		return "", false
+	}
+	return strings.TrimPrefix(address, "unix:"), true
+}
+
+func newManager(cfg Config) (*autocert.Manager, error) {
+	if cfg.ACMECacheDir == "" {
+		return nil, fmt.Errorf("ACME needs an ACMECacheDir to store the certificates")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+		Email:      cfg.ACMEEmail,
+	}
+	if cfg.ACMEDirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL}
+	}
+	return m, nil
+}
@@ -0,0 +1,91 @@
+package listener
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api.sock")
+	ln, err := Listen(Config{Address: "unix:" + path, SocketMode: "0600"})
+	require.NoError(t, err)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+	ln.Close()
+
+	// a stale socket doesn't prevent listening again
+	ln, err = Listen(Config{Address: "unix:" + path})
+	require.NoError(t, err)
+	ln.Close()
+
+	_, err = Listen(Config{Address: "unix:" + path, SocketMode: "rw"})
+	assert.Error(t, err)
+}
+
+func TestNewConfig(t *testing.T) {
+	cfg := NewConfig("127.0.0.1:4243", &config.Protocol{ACMEDomains: []string{"example.com"}, ACMECacheDir: "/var/lib/acme"})
+	assert.Equal(t, Config{Address: "127.0.0.1:4243", ACMEDomains: []string{"example.com"}, ACMECacheDir: "/var/lib/acme"}, cfg)
+	cfg = NewConfig("unix:/run/matterbridge/admin.sock", &config.Protocol{SocketMode: "0660"})
+	assert.Equal(t, Config{Address: "unix:/run/matterbridge/admin.sock", SocketMode: "0660"}, cfg)
+}
+
+func TestACMEManager(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	_, err := acmeManager(logger, Config{ACMEDomains: []string{"example.com"}})
+	assert.Error(t, err, "ACMECacheDir is required")
+
+	dir := t.TempDir()
+	m1, err := acmeManager(logger, Config{ACMEDomains: []string{"example.com"}, ACMECacheDir: dir})
+	require.NoError(t, err)
+	// the listeners with the same settings, like [general] ones, share the certificates
+	m2, err := acmeManager(logger, Config{Address: ":8443", ACMEDomains: []string{"example.com"}, ACMECacheDir: dir})
+	require.NoError(t, err)
+	assert.Same(t, m1, m2)
+	m3, err := acmeManager(logger, Config{ACMEDomains: []string{"other.example.com"}, ACMECacheDir: dir})
+	require.NoError(t, err)
+	assert.NotSame(t, m1, m3)
+	assert.NoError(t, m1.HostPolicy(context.Background(), "example.com"))
+	assert.Error(t, m1.HostPolicy(context.Background(), "other.example.com"))
+}
+
+func TestListenTLS(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	ln, err := ListenTLS(logger, Config{Address: "127.0.0.1:0", ACMEDomains: []string{"example.com"}, ACMECacheDir: t.TempDir()})
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// the handshake fails, there's no certificate for unknown.example.com
+			conn.(*tls.Conn).Handshake() //nolint:errcheck
+			conn.Close()
+		}
+	}()
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{ServerName: "unknown.example.com"})
+	if err == nil {
+		conn.Close()
+	}
+	require.Error(t, err)
+	// a TLS alert of the server, not a plain TCP listener
+	assert.Contains(t, err.Error(), "tls:")
+
+	// without ACMEDomains it's a plain listener
+	plain, err := ListenTLS(logger, Config{Address: "127.0.0.1:0"})
+	require.NoError(t, err)
+	_, ok := plain.(*net.TCPListener)
+	assert.True(t, ok)
+	plain.Close()
+}
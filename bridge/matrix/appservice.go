@@ -0,0 +1,424 @@
+package bmatrix
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/listener"
+	matrix "github.com/matterbridge/gomatrix"
+)
+
+const (
+	defaultPuppetPrefix = "_matterbridge_"
+
+	// seenTransactions is the number of transaction IDs remembered, the homeserver
+	// retries a transaction until it's acknowledged.
+	seenTransactions = 100
+)
+
+// appService keeps the state of the appservice mode, where messages from other bridges
+// are sent by a puppet user per remote sender instead of by the bot.
+type appService struct {
+	sync.Mutex
+	prefix  string
+	domain  string
+	puppets map[string]*puppet // by mxid
+	txns    []string
+	started sync.Once
+}
+
+// puppet is a matrix user in the namespace of the appservice standing in for a remote user.
+type puppet struct {
+	mxid        string
+	client      *matrix.Client
+	displayName string
+	avatar      string               // URL of the remote avatar that was set
+	rooms       map[string]time.Time // joined rooms and when the puppet last sent to them
+}
+
+func newAppService(prefix, mxid string) (*appService, error) {
+	i := strings.Index(mxid, ":")
+	if !strings.HasPrefix(mxid, "@") || i < 0 {
+		return nil, fmt.Errorf("MxID %q must be a full user ID (@bot:example.org) in appservice mode", mxid)
+	}
+	if prefix == "" {
+		prefix = defaultPuppetPrefix
+	}
+	return &appService{
+		prefix:  prefix,
+		domain:  mxid[i+1:],
+		puppets: make(map[string]*puppet),
+	}, nil
+}
+
+// escapeLocalpart maps s to the characters allowed in a user ID, like the
+// mapping of the matrix specification: uppercase becomes _ and lowercase,
+// other characters become =xx.
+func escapeLocalpart(s string) string {
+	var sb strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			sb.WriteByte('_')
+			sb.WriteByte(c + 'a' - 'A')
+		case c == '_':
+			sb.WriteString("__")
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '.', c == '-', c == '/':
+			sb.WriteByte(c)
+		default:
+			fmt.Fprintf(&sb, "=%02x", c)
+		}
+	}
+	return sb.String()
+}
+
+// puppetID returns the mxid of the puppet of the sender of msg.
+func (as *appService) puppetID(msg *config.Message) string {
+	remote := msg.UserID
+	if remote == "" {
+		remote = msg.Username
+	}
+	return "@" + as.prefix + escapeLocalpart(msg.Account+"_"+remote) + ":" + as.domain
+}
+
+// isPuppet returns true if mxid is in the namespace of the appservice.
+func (as *appService) isPuppet(mxid string) bool {
+	return strings.HasPrefix(mxid, "@"+as.prefix) && strings.HasSuffix(mxid, ":"+as.domain)
+}
+
+// isPuppet returns true if mxid is one of our puppets, their messages must not be relayed again.
+func (b *Bmatrix) isPuppet(mxid string) bool {
+	return b.as != nil && b.as.isPuppet(mxid)
+}
+
+// startAppService starts the listener for the transactions of the homeserver and
+// the goroutine letting idle puppets leave their rooms. It's called by Connect once
+// a server is connected, outside of the failover, and only starts them the first
+// time: a reconnect only replaces the client.
+func (b *Bmatrix) startAppService() {
+	b.as.started.Do(func() {
+		mux := http.NewServeMux()
+		for _, prefix := range []string{"/_matrix/app/v1", ""} {
+			mux.HandleFunc(prefix+"/transactions/", b.handleTransaction)
+			mux.HandleFunc(prefix+"/users/", b.handleUserQuery)
+			mux.HandleFunc(prefix+"/rooms/", b.handleRoomQuery)
+		}
+		go func() {
+			if err := listener.Serve(b.Log, b.ListenerConfig(b.GetString("AppServiceBindAddress")), mux); err != nil {
+				b.Log.Errorf("appservice listener failed: %s", err)
+			}
+		}()
+		if idle := b.GetInt("PuppetIdleTimeout"); idle > 0 {
+			go b.leaveIdleRooms(time.Duration(idle) * time.Second)
+		}
+	})
+}
+
+// authorized checks the hs_token the homeserver sends with every request.
+func (b *Bmatrix) authorized(w http.ResponseWriter, r *http.Request) bool {
+	token := r.URL.Query().Get("access_token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if token != "" && token == b.GetString("HomeserverToken") {
+		return true
+	}
+	writeMatrixError(w, http.StatusForbidden, "M_FORBIDDEN", "bad token")
+	return false
+}
+
+func writeMatrixError(w http.ResponseWriter, status int, errcode, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(httpError{Errcode: errcode, Err: msg})
+}
+
+func writeEmpty(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte("{}"))
+}
+
+// seen records txnID and returns true if it was handled before.
+func (as *appService) seen(txnID string) bool {
+	as.Lock()
+	defer as.Unlock()
+	for _, id := range as.txns {
+		if id == txnID {
+			return true
+		}
+	}
+	as.txns = append(as.txns, txnID)
+	if len(as.txns) > seenTransactions {
+		as.txns = as.txns[1:]
+	}
+	return false
+}
+
+// handleTransaction handles the events the homeserver pushes to the appservice,
+// they replace the /sync of the normal mode.
+func (b *Bmatrix) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	if !b.authorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodPut {
+		writeMatrixError(w, http.StatusMethodNotAllowed, "M_UNRECOGNIZED", "method not allowed")
+		return
+	}
+	txnID := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	var txn struct {
+		Events []*matrix.Event `json:"events"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&txn); err != nil {
+		writeMatrixError(w, http.StatusBadRequest, "M_NOT_JSON", err.Error())
+		return
+	}
+	if b.as.seen(txnID) {
+		writeEmpty(w)
+		return
+	}
+	for _, ev := range txn.Events {
+		switch ev.Type {
+		case "m.room.message", "m.room.redaction":
+			b.handleEvent(ev)
+		case "m.room.member":
+			b.handleMemberChange(ev)
+		case "m.reaction":
+			b.handleReaction(ev)
+		case "m.call.invite":
+			b.handleCallInvite(ev)
+		case "org.matrix.msc3401.call.member":
+			b.handleCallMember(ev)
+		}
+	}
+	writeEmpty(w)
+}
+
+// handleUserQuery tells the homeserver if a user of our namespace exists, puppets
+// are created on demand.
+func (b *Bmatrix) handleUserQuery(w http.ResponseWriter, r *http.Request) {
+	if !b.authorized(w, r) {
+		return
+	}
+	mxid := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if !b.as.isPuppet(mxid) {
+		writeMatrixError(w, http.StatusNotFound, "M_NOT_FOUND", "not a puppet")
+		return
+	}
+	if err := b.registerPuppet(strings.TrimSuffix(mxid[1:], ":"+b.as.domain)); err != nil {
+		b.Log.Errorf("registering puppet %s failed: %s", mxid, err)
+		writeMatrixError(w, http.StatusInternalServerError, "M_UNKNOWN", "registration failed")
+		return
+	}
+	writeEmpty(w)
+}
+
+// handleRoomQuery refuses room aliases, the appservice doesn't create rooms.
+func (b *Bmatrix) handleRoomQuery(w http.ResponseWriter, r *http.Request) {
+	if !b.authorized(w, r) {
+		return
+	}
+	writeMatrixError(w, http.StatusNotFound, "M_NOT_FOUND", "no such room")
+}
+
+// registerPuppet registers the user localpart in the namespace of the appservice.
+func (b *Bmatrix) registerPuppet(localpart string) error {
+	req := map[string]string{"type": "m.login.application_service", "username": localpart}
+	err := b.mc.MakeRequest("POST", b.mc.BuildURL("register"), req, nil)
+	if err != nil && handleError(err).Errcode == "M_USER_IN_USE" {
+		return nil
+	}
+	return err
+}
+
+// sender returns the client to send msg to roomID with. In appservice mode that's the
+// puppet of the sender of msg, puppeted is false when the bot sends msg with the
+// username in the text.
+func (b *Bmatrix) sender(msg *config.Message, roomID string) (mc *matrix.Client, puppeted bool) {
+	if b.as == nil || msg.Username == "" || msg.Username == "system" ||
+		config.IsJoinLeave(msg.Event) || msg.Event == config.EventTopicChange {
+		return b.mc, false
+	}
+	p, err := b.puppet(msg)
+	if err != nil {
+		b.Log.Errorf("puppet for %s failed, sending as bot: %s", msg.Username, err)
+		return b.mc, false
+	}
+	if err := b.joinPuppet(p, roomID); err != nil {
+		b.Log.Errorf("puppet %s can't join %s, sending as bot: %s", p.mxid, roomID, err)
+		return b.mc, false
+	}
+	return p.client, true
+}
+
+// puppet returns the puppet of the sender of msg, registering it and updating
+// its display name and avatar when needed.
+func (b *Bmatrix) puppet(msg *config.Message) (*puppet, error) {
+	mxid := b.as.puppetID(msg)
+	b.as.Lock()
+	defer b.as.Unlock()
+	p, ok := b.as.puppets[mxid]
+	if !ok {
+		if err := b.registerPuppet(strings.TrimSuffix(mxid[1:], ":"+b.as.domain)); err != nil {
+			return nil, err
+		}
+		client, err := matrix.NewClient(b.homeserver(), mxid, b.GetString("AppServiceToken"))
+		if err != nil {
+			return nil, err
+		}
+		client.Client = b.mc.Client
+		client.AppServiceUserID = mxid
+		p = &puppet{mxid: mxid, client: client, rooms: make(map[string]time.Time)}
+		b.as.puppets[mxid] = p
+	}
+
+	displayName := strings.TrimSpace(newMatrixUsername(msg.Username).plain)
+	if displayName != "" && displayName != p.displayName {
+		if err := p.client.SetDisplayName(displayName); err != nil {
+			b.Log.Errorf("setting display name of %s failed: %s", mxid, err)
+		} else {
+			p.displayName = displayName
+		}
+	}
+	if msg.Avatar != "" && msg.Avatar != p.avatar {
+		resp, err := b.mc.UploadLink(msg.Avatar)
+		if err == nil {
+			err = p.client.SetAvatarURL(resp.ContentURI)
+		}
+		if err != nil {
+			b.Log.Errorf("setting avatar of %s failed: %s", mxid, err)
+		} else {
+			p.avatar = msg.Avatar
+		}
+	}
+	return p, nil
+}
+
+// joinPuppet makes p join roomID, the bot invites it when the room isn't public.
+func (b *Bmatrix) joinPuppet(p *puppet, roomID string) error {
+	b.as.Lock()
+	defer b.as.Unlock()
+	if _, ok := p.rooms[roomID]; !ok {
+		if _, err := p.client.JoinRoom(roomID, "", nil); err != nil {
+			if _, err := b.mc.InviteUser(roomID, &matrix.ReqInviteUser{UserID: p.mxid}); err != nil {
+				return err
+			}
+			if _, err := p.client.JoinRoom(roomID, "", nil); err != nil {
+				return err
+			}
+		}
+	}
+	p.rooms[roomID] = time.Now()
+	return nil
+}
+
+// banPuppet bans the puppet of the user banned by the EventUserBan msg from roomID. Without
+// the appservice the messages of the user are sent by the bot, there's no one to ban.
+func (b *Bmatrix) banPuppet(msg *config.Message, roomID string) error {
+	if b.as == nil {
+		b.Log.Debugf("not banning %s in %s, the users of other bridges aren't puppeted", msg.Username, roomID)
+		return nil
+	}
+	puppetID := b.as.puppetID(msg)
+	b.Log.Infof("banning %s, the puppet of %s on %s, in %s", puppetID, msg.Username, msg.Account, roomID)
+	_, err := b.mc.BanUser(roomID, &matrix.ReqBanUser{UserID: puppetID, Reason: msg.Text})
+	return err
+}
+
+// leaveIdleRooms makes the puppets leave the rooms they didn't send to for idle.
+func (b *Bmatrix) leaveIdleRooms(idle time.Duration) {
+	interval := idle / 2
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	for range time.Tick(interval) {
+		b.as.Lock()
+		for _, p := range b.as.puppets {
+			for roomID, last := range p.rooms {
+				if time.Since(last) < idle {
+					continue
+				}
+				if _, err := p.client.LeaveRoom(roomID); err != nil {
+					b.Log.Errorf("puppet %s failed to leave %s: %s", p.mxid, roomID, err)
+					continue
+				}
+				b.Log.Debugf("puppet %s left idle room %s", p.mxid, roomID)
+				delete(p.rooms, roomID)
+			}
+		}
+		b.as.Unlock()
+	}
+}
+
+func randomToken() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Registration returns the appservice registration file of the matrix account,
+// to be added to the app_service_config_files of the homeserver.
+func Registration(account string, c config.Config) (string, error) {
+	cfg, ok := c.BridgeValues().Matrix[strings.TrimPrefix(account, "matrix.")]
+	if !ok {
+		return "", fmt.Errorf("no account %s configured", account)
+	}
+	var missing []string
+	for _, setting := range []struct{ key, value string }{
+		{"MxID", cfg.MxID},
+		{"AppServiceToken", cfg.AppServiceToken},
+		{"HomeserverToken", cfg.HomeserverToken},
+		{"AppServiceBindAddress", cfg.AppServiceBindAddress},
+	} {
+		if setting.value == "" {
+			missing = append(missing, setting.key)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("%s must be set for %s, random tokens you can use: %s %s",
+			strings.Join(missing, ", "), account, randomToken(), randomToken())
+	}
+	as, err := newAppService(cfg.PuppetPrefix, cfg.MxID)
+	if err != nil {
+		return "", err
+	}
+	url := cfg.AppServiceURL
+	if url == "" {
+		url = "http://" + cfg.AppServiceBindAddress
+		domains := cfg.ACMEDomains
+		if len(domains) == 0 {
+			domains = c.BridgeValues().General.ACMEDomains
+		}
+		// the listener has TLS with a certificate for the ACMEDomains
+		if _, port, err := net.SplitHostPort(cfg.AppServiceBindAddress); err == nil && len(domains) > 0 {
+			url = "https://" + net.JoinHostPort(domains[0], port)
+		}
+	}
+	sender := strings.TrimSuffix(cfg.MxID[1:], ":"+as.domain)
+	users := "@" + regexp.QuoteMeta(as.prefix) + ".*:" + regexp.QuoteMeta(as.domain)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "id: %q\n", "matterbridge-"+account)
+	fmt.Fprintf(&sb, "url: %q\n", url)
+	fmt.Fprintf(&sb, "as_token: %q\n", cfg.AppServiceToken)
+	fmt.Fprintf(&sb, "hs_token: %q\n", cfg.HomeserverToken)
+	fmt.Fprintf(&sb, "sender_localpart: %q\n", sender)
+	sb.WriteString("rate_limited: false\n")
+	sb.WriteString("namespaces:\n")
+	sb.WriteString("  users:\n")
+	sb.WriteString("    - exclusive: true\n")
+	fmt.Fprintf(&sb, "      regex: %q\n", users)
+	sb.WriteString("  aliases: []\n")
+	sb.WriteString("  rooms: []\n")
+	return sb.String(), nil
+}
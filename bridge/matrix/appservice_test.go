@@ -0,0 +1,100 @@
+package bmatrix
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigAppService = []byte(`
+[matrix.neo]
+MxID="@bridge:example.org"
+AppService=true
+AppServiceToken="astoken"
+HomeserverToken="hstoken"
+AppServiceBindAddress="127.0.0.1:9999"
+`)
+
+func newTestAppService(t *testing.T) (*Bmatrix, config.Config) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	cfg := config.NewConfigFromString(logger, testconfigAppService)
+	br := bridge.New(&config.Bridge{Account: "matrix.neo"})
+	br.Config = cfg
+	br.Log = logrus.NewEntry(logger)
+	b, ok := New(&bridge.Config{Bridge: br, Remote: make(chan config.Message, 10)}).(*Bmatrix)
+	require.True(t, ok)
+	require.NotNil(t, b.as)
+	return b, cfg
+}
+
+func TestEscapeLocalpart(t *testing.T) {
+	assert.Equal(t, "irc.libera___bob", escapeLocalpart("irc.libera_Bob"))
+	assert.Equal(t, "a=20b=3d", escapeLocalpart("a b="))
+}
+
+func TestPuppetID(t *testing.T) {
+	b, _ := newTestAppService(t)
+	mxid := b.as.puppetID(&config.Message{Account: "irc.libera", Username: "Bob"})
+	assert.Equal(t, "@_matterbridge_irc.libera___bob:example.org", mxid)
+	assert.Equal(t, "@_matterbridge_discord.test__1234:example.org",
+		b.as.puppetID(&config.Message{Account: "discord.test", Username: "Bob", UserID: "1234"}))
+	assert.True(t, b.isPuppet(mxid))
+	assert.False(t, b.isPuppet("@bob:example.org"))
+	assert.False(t, b.isPuppet("@_matterbridge_bob:other.org"))
+
+	// puppets show the nick, it isn't added to the text
+	assert.Equal(t, "{NICK}", b.GetString("RemoteNickFormat"))
+}
+
+func TestTransactionAuth(t *testing.T) {
+	b, _ := newTestAppService(t)
+	put := func(path, token string) int {
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader(`{"events":[]}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		b.handleTransaction(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusForbidden, put("/_matrix/app/v1/transactions/1", ""))
+	assert.Equal(t, http.StatusForbidden, put("/_matrix/app/v1/transactions/1", "astoken"))
+	assert.Equal(t, http.StatusOK, put("/_matrix/app/v1/transactions/1", "hstoken"))
+	assert.Equal(t, http.StatusOK, put("/transactions/2?access_token=hstoken", ""))
+
+	assert.True(t, b.as.seen("1"))
+	assert.False(t, b.as.seen("3"))
+
+	rec := httptest.NewRecorder()
+	b.handleUserQuery(rec, httptest.NewRequest(http.MethodGet, "/_matrix/app/v1/users/@bob:example.org?access_token=hstoken", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestRegistration(t *testing.T) {
+	_, cfg := newTestAppService(t)
+	reg, err := Registration("matrix.neo", cfg)
+	require.NoError(t, err)
+	assert.Contains(t, reg, `url: "http://127.0.0.1:9999"`)
+	assert.Contains(t, reg, `sender_localpart: "bridge"`)
+	assert.Contains(t, reg, `regex: "@_matterbridge_.*:example\\.org"`)
+
+	_, err = Registration("matrix.other", cfg)
+	assert.Error(t, err)
+
+	// the listener has TLS with ACME
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	cfg = config.NewConfigFromString(logger, append([]byte("[general]\nACMEDomains=[\"matterbridge.example.org\"]\n"), testconfigAppService...))
+	reg, err = Registration("matrix.neo", cfg)
+	require.NoError(t, err)
+	assert.Contains(t, reg, `url: "https://matterbridge.example.org:9999"`)
+}
@@ -0,0 +1,92 @@
+package bmatrix
+
+import (
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	matrix "github.com/matterbridge/gomatrix"
+)
+
+// callAnnounceAge is how old a call event can be to be announced, the older events of the
+// initial sync only update the members of the calls.
+const callAnnounceAge = 2 * time.Minute
+
+// handleCallInvite announces the 1:1 calls of the m.call.invite event ev.
+func (b *Bmatrix) handleCallInvite(ev *matrix.Event) {
+	if time.Since(time.Unix(0, ev.Timestamp*int64(time.Millisecond))) > callAnnounceAge {
+		return
+	}
+	video := false
+	if offer, ok := ev.Content["offer"].(map[string]interface{}); ok {
+		sdp, _ := offer["sdp"].(string)
+		video = strings.Contains(sdp, "m=video")
+	}
+	b.announceCall(ev, video)
+}
+
+// handleCallMember keeps the members of the group calls (Element Call) of the rooms, from
+// the org.matrix.msc3401.call.member state events ev, and announces a call when its first
+// member joins. The state key is the member, or the member and its device.
+func (b *Bmatrix) handleCallMember(ev *matrix.Event) {
+	if ev.StateKey == nil {
+		return
+	}
+	joined := len(ev.Content) > 0
+	if calls, ok := ev.Content["m.calls"].([]interface{}); ok {
+		joined = len(calls) > 0
+	}
+	if memberships, ok := ev.Content["memberships"].([]interface{}); ok {
+		joined = len(memberships) > 0
+	}
+
+	b.Lock()
+	if b.calls == nil {
+		b.calls = make(map[string]map[string]bool)
+	}
+	members := b.calls[ev.RoomID]
+	started := joined && len(members) == 0
+	if joined {
+		if members == nil {
+			members = make(map[string]bool)
+			b.calls[ev.RoomID] = members
+		}
+		members[*ev.StateKey] = true
+	} else {
+		delete(members, *ev.StateKey)
+	}
+	b.Unlock()
+
+	if !started || time.Since(time.Unix(0, ev.Timestamp*int64(time.Millisecond))) > callAnnounceAge {
+		return
+	}
+	b.announceCall(ev, true)
+}
+
+// announceCall sends an EventCallStarted for the call started by the sender of ev.
+func (b *Bmatrix) announceCall(ev *matrix.Event, video bool) {
+	if ev.Sender == b.UserID || b.isPuppet(ev.Sender) {
+		return
+	}
+	b.RLock()
+	channel, ok := b.RoomMap[ev.RoomID]
+	b.RUnlock()
+	if !ok {
+		return
+	}
+	extra := map[string][]interface{}{config.ExtraCallURL: {"https://matrix.to/#/" + ev.RoomID}}
+	if video {
+		extra[config.ExtraCallVideo] = []interface{}{true}
+	}
+	rmsg := config.Message{
+		Username: b.getDisplayName(ev.Sender),
+		UserID:   ev.Sender,
+		Channel:  channel,
+		Account:  b.Account,
+		Event:    config.EventCallStarted,
+		Text:     channel,
+		Extra:    extra,
+	}
+	b.Log.Debugf("<= Sending message from %s on %s to gateway", ev.Sender, b.Account)
+	b.Remote <- rmsg
+}
@@ -0,0 +1,52 @@
+package bmatrix
+
+import (
+	matrix "github.com/matterbridge/gomatrix"
+)
+
+// TODO: end-to-end encryption (olm/megolm). It needs mautrix-go's crypto machine with a
+// crypto store under Store, and the sync of the bridge moved from gomatrix to mautrix-go,
+// as the machine reads the to-device events, device lists and one-time key counts of the
+// sync responses, which gomatrix drops. Until then encrypted rooms are only reported: their
+// messages can't be read and nothing is sent to them, the messages would be in plaintext
+// in a room its members expect to be private.
+
+// checkEncryption warns when the room roomID of channel is encrypted.
+func (b *Bmatrix) checkEncryption(roomID, channel string) {
+	var content map[string]interface{}
+	if err := b.mc.StateEvent(roomID, "m.room.encryption", "", &content); err != nil {
+		// not encrypted
+		return
+	}
+	b.warnEncrypted(roomID, channel)
+}
+
+// handleEncrypted warns about the encrypted events that can't be relayed, and about the
+// rooms in which encryption is turned on after they were joined.
+func (b *Bmatrix) handleEncrypted(ev *matrix.Event) {
+	b.RLock()
+	channel, ok := b.RoomMap[ev.RoomID]
+	b.RUnlock()
+	if !ok {
+		return
+	}
+	b.warnEncrypted(ev.RoomID, channel)
+}
+
+// isEncrypted returns true if the room roomID is known to be encrypted.
+func (b *Bmatrix) isEncrypted(roomID string) bool {
+	b.RLock()
+	defer b.RUnlock()
+	return b.encrypted[roomID]
+}
+
+// warnEncrypted warns once per room that channel is encrypted.
+func (b *Bmatrix) warnEncrypted(roomID, channel string) {
+	b.Lock()
+	warned := b.encrypted[roomID]
+	b.encrypted[roomID] = true
+	b.Unlock()
+	if !warned {
+		b.Log.Errorf("%s (%s) is an encrypted room, end-to-end encryption isn't supported: nothing is relayed from or to it", channel, roomID)
+	}
+}
@@ -0,0 +1,58 @@
+package bmatrix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	matrix "github.com/matterbridge/gomatrix"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedRooms(t *testing.T) {
+	var sent int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.Contains(req.URL.Path, "/state/m.room.encryption"):
+			if strings.Contains(req.URL.Path, "!secret:example.org") {
+				w.Write([]byte(`{"algorithm":"m.megolm.v1.aes-sha2"}`))
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errcode":"M_NOT_FOUND"}`))
+		case strings.Contains(req.URL.Path, "/send/"):
+			sent++
+			w.Write([]byte(`{"event_id":"$1"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	b, _ := newTestAppService(t)
+	mc, err := matrix.NewClient(srv.URL, "@bridge:example.org", "token")
+	require.NoError(t, err)
+	b.mc = mc
+	b.RoomMap["!secret:example.org"] = "#secret:example.org"
+	b.RoomMap["!public:example.org"] = "#public:example.org"
+
+	b.checkEncryption("!secret:example.org", "#secret:example.org")
+	b.checkEncryption("!public:example.org", "#public:example.org")
+	assert.True(t, b.isEncrypted("!secret:example.org"))
+	assert.False(t, b.isEncrypted("!public:example.org"))
+
+	// nothing is sent to the encrypted room, it would be plaintext
+	ID, err := b.Send(config.Message{Text: "hi", Channel: "#secret:example.org", Username: "bob"})
+	assert.NoError(t, err)
+	assert.Empty(t, ID)
+	assert.Equal(t, 0, sent)
+
+	// encryption turned on after the room was joined
+	b.handleEncrypted(&matrix.Event{Type: "m.room.encryption", RoomID: "!public:example.org"})
+	assert.True(t, b.isEncrypted("!public:example.org"))
+	_, err = b.Send(config.Message{Text: "hi", Channel: "#public:example.org", Username: "bob"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+}
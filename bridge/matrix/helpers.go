@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"html"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -174,7 +176,7 @@ func (b *Bmatrix) getAvatarURL(sender string) string {
 		return ""
 	}
 
-	url := strings.ReplaceAll(s.AvatarURL, "mxc://", b.GetString("Server")+"/_matrix/media/r0/thumbnail/")
+	url := strings.ReplaceAll(s.AvatarURL, "mxc://", b.homeserver()+"/_matrix/media/r0/thumbnail/")
 	if url != "" {
 		url += "?width=37&height=37&method=crop"
 	}
@@ -213,3 +215,50 @@ func (b *Bmatrix) retry(f func() error) error {
 		}
 	}
 }
+
+// homeserver returns the base URL of the homeserver we're connected to.
+func (b *Bmatrix) homeserver() string {
+	return strings.TrimSuffix(b.mc.HomeserverURL.String(), "/")
+}
+
+type wellKnownClient struct {
+	Homeserver struct {
+		BaseURL string `json:"base_url"`
+	} `json:"m.homeserver"`
+}
+
+// resolveWellKnown looks up the homeserver base URL of server in its
+// /.well-known/matrix/client document, returning server unchanged when
+// there's no (valid) document.
+func (b *Bmatrix) resolveWellKnown(server string) string {
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" {
+		return server
+	}
+	resp, err := b.HTTPClient(10 * time.Second).Get("https://" + u.Host + "/.well-known/matrix/client")
+	if err != nil {
+		b.Log.Debugf("well-known lookup for %s failed: %s", u.Host, err)
+		return server
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b.Log.Debugf("well-known lookup for %s failed: %s", u.Host, resp.Status)
+		return server
+	}
+	var wk wellKnownClient
+	if err := json.NewDecoder(resp.Body).Decode(&wk); err != nil || wk.Homeserver.BaseURL == "" {
+		b.Log.Debugf("well-known lookup for %s returned no homeserver", u.Host)
+		return server
+	}
+	b.Log.Debugf("well-known lookup for %s returned %s", u.Host, wk.Homeserver.BaseURL)
+	return strings.TrimSuffix(wk.Homeserver.BaseURL, "/")
+}
+
+// Permalink implements bridge.Permalinker.
+func (b *Bmatrix) Permalink(channel, id string) (string, bool) {
+	roomID := b.getRoomID(channel)
+	if roomID == "" || id == "" {
+		return "", false
+	}
+	return "https://matrix.to/#/" + url.PathEscape(roomID) + "/" + url.PathEscape(id), true
+}
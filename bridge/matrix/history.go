@@ -0,0 +1,72 @@
+package bmatrix
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	matrix "github.com/matterbridge/gomatrix"
+)
+
+// historyPageSize is the number of events asked for at once.
+const historyPageSize = 100
+
+// messages returns the events of roomID before the pagination token from, the newest events
+// of the room when from is empty.
+func (b *Bmatrix) messages(roomID, from string, limit int) (*matrix.RespMessages, error) {
+	query := map[string]string{"dir": "b", "limit": strconv.Itoa(limit)}
+	if from != "" {
+		query["from"] = from
+	}
+	var resp *matrix.RespMessages
+	err := b.mc.MakeRequest("GET", b.mc.BuildURLWithQuery([]string{"rooms", roomID, "messages"}, query), nil, &resp)
+	return resp, err
+}
+
+// FetchHistory implements bridge.HistoryFetcher. Edits and redactions aren't fetched, like
+// the messages they change they've been seen already or are dropped.
+func (b *Bmatrix) FetchHistory(channel string, since time.Time, limit int) error {
+	roomID := b.getRoomID(channel)
+	if roomID == "" {
+		return fmt.Errorf("could not find room ID for %s", channel)
+	}
+	// matrix returns the newest events first
+	var events []matrix.Event
+	from := ""
+	done := false
+	for !done && len(events) < limit {
+		size := limit - len(events)
+		if size > historyPageSize {
+			size = historyPageSize
+		}
+		resp, err := b.messages(roomID, from, size)
+		if err != nil {
+			return err
+		}
+		for i := range resp.Chunk {
+			ev := resp.Chunk[i]
+			if !time.Unix(0, ev.Timestamp*int64(time.Millisecond)).After(since) {
+				done = true
+				break
+			}
+			if _, edit := ev.Content["m.new_content"]; ev.Type != "m.room.message" || edit {
+				continue
+			}
+			events = append(events, ev)
+		}
+		if len(resp.Chunk) < size || resp.End == "" || resp.End == from {
+			break
+		}
+		from = resp.End
+	}
+	if len(events) > limit {
+		events = events[:limit]
+	}
+
+	for i := len(events) - 1; i >= 0; i-- {
+		ev := events[i]
+		ev.RoomID = roomID
+		b.receiveEvent(&ev, true)
+	}
+	return nil
+}
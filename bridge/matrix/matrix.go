@@ -2,8 +2,10 @@ package bmatrix
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"mime"
+	"net/http"
 	"regexp"
 	"strings"
 	"sync"
@@ -36,6 +38,11 @@ type Bmatrix struct {
 	NicknameMap map[string]NicknameCacheEntry
 	RoomMap     map[string]string
 	rateMutex   sync.RWMutex
+	as          *appService
+	reactions   *reactions
+	calls       map[string]map[string]bool
+	encrypted   map[string]bool // encrypted rooms, see encryption.go
+	received    *helper.ChannelQueue
 	sync.RWMutex
 	*bridge.Config
 }
@@ -89,26 +96,76 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	b := &Bmatrix{Config: cfg}
 	b.RoomMap = make(map[string]string)
 	b.NicknameMap = make(map[string]NicknameCacheEntry)
+	b.encrypted = make(map[string]bool)
+	b.received = helper.NewChannelQueue(cfg.Account)
+	b.reactions = newReactions(cfg.General)
+	if b.GetBool("AppService") {
+		as, err := newAppService(b.GetString("PuppetPrefix"), b.GetString("MxID"))
+		if err != nil {
+			b.Log.Fatal(err)
+		}
+		b.as = as
+		// puppets have the name of the remote user, it doesn't need to be in the text
+		if !b.IsKeySet("RemoteNickFormat") {
+			b.Config.Config.Viper().Set(b.GetConfigKey("RemoteNickFormat"), "{NICK}")
+		}
+	}
 	return b
 }
 
 func (b *Bmatrix) Connect() error {
+	if err := b.ConnectFailover(b.checkServer, b.connect); err != nil {
+		return err
+	}
+	if b.as != nil {
+		b.startAppService()
+	}
+	return nil
+}
+
+// checkServer is the health check of server, it's only done when there are several Servers
+// to choose from.
+func (b *Bmatrix) checkServer(server string) error {
+	if b.GetBool("ResolveWellKnown") {
+		server = b.resolveWellKnown(server)
+	}
+	return b.CheckHTTP("/_matrix/client/versions")(server)
+}
+
+func (b *Bmatrix) connect(server string) error {
 	var err error
-	b.Log.Infof("Connecting %s", b.GetString("Server"))
+	if b.GetBool("ResolveWellKnown") {
+		server = b.resolveWellKnown(server)
+	}
+	b.Log.Infof("Connecting %s", server)
+	if b.as != nil {
+		b.mc, err = matrix.NewClient(
+			server, b.GetString("MxID"), b.GetString("AppServiceToken"),
+		)
+		if err != nil {
+			return err
+		}
+		b.mc.Client = b.HTTPClient(0)
+		b.UserID = b.GetString("MxID")
+		b.Log.Info("Using appservice mode, events are received from the homeserver")
+		return nil
+	}
 	if b.GetString("MxID") != "" && b.GetString("Token") != "" {
 		b.mc, err = matrix.NewClient(
-			b.GetString("Server"), b.GetString("MxID"), b.GetString("Token"),
+			server, b.GetString("MxID"), b.GetString("Token"),
 		)
 		if err != nil {
 			return err
 		}
+		b.mc.Client = b.HTTPClient(0)
 		b.UserID = b.GetString("MxID")
 		b.Log.Info("Using existing Matrix credentials")
 	} else {
-		b.mc, err = matrix.NewClient(b.GetString("Server"), "", "")
+		b.mc, err = matrix.NewClient(server, "", "")
 		if err != nil {
 			return err
 		}
+		b.mc.Client = b.HTTPClient(0)
 		resp, err := b.mc.Login(&matrix.ReqLogin{
 			Type:       "m.login.password",
 			User:       b.GetString("Login"),
@@ -140,23 +197,53 @@ func (b *Bmatrix) JoinChannel(channel config.ChannelInfo) error {
 		b.Lock()
 		b.RoomMap[resp.RoomID] = channel.Name
 		b.Unlock()
+		b.checkEncryption(resp.RoomID, channel.Name)
 
 		return nil
 	})
 }
 
+// PermanentError implements bridge.ErrorClassifier, the requests the homeserver rejects,
+// like an event that's too big or a room the bot isn't in anymore.
+func (b *Bmatrix) PermanentError(err error) bool {
+	var httpErr matrix.HTTPError
+	return errors.As(err, &httpErr) && bridge.PermanentStatus(httpErr.Code)
+}
+
 func (b *Bmatrix) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
 	channel := b.getRoomID(msg.Channel)
 	b.Log.Debugf("Channel %s maps to channel id %s", msg.Channel, channel)
 
+	if b.isEncrypted(channel) {
+		b.Log.Debugf("not sending to %s, it's an encrypted room", msg.Channel)
+		return "", nil
+	}
+
+	if msg.Event == config.EventUserBan {
+		return "", b.banPuppet(&msg, channel)
+	}
+
+	mc, puppeted := b.sender(&msg, channel)
+
+	if msg.Event == config.EventReactionAdd || msg.Event == config.EventReactionRemove {
+		return "", b.sendReaction(mc, &msg, channel)
+	}
+
+	if msg.Event == config.EventUserTyping {
+		return "", b.sendTyping(mc, channel)
+	}
+
 	username := newMatrixUsername(msg.Username)
+	if puppeted {
+		username = newMatrixUsername("")
+	}
 
 	body := username.plain + msg.Text
 	formattedBody := username.formatted + helper.ParseMarkdown(msg.Text)
 
-	if b.GetBool("SpoofUsername") {
+	if b.GetBool("SpoofUsername") && !puppeted {
 		// https://spec.matrix.org/v1.3/client-server-api/#mroommember
 		type stateMember struct {
 			AvatarURL   string `json:"avatar_url,omitempty"`
@@ -195,7 +282,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 		msgID := ""
 
 		err := b.retry(func() error {
-			resp, err := b.mc.SendMessageEvent(channel, "m.room.message", m)
+			resp, err := mc.SendMessageEvent(channel, "m.room.message", m)
 			if err != nil {
 				return err
 			}
@@ -217,7 +304,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 		msgID := ""
 
 		err := b.retry(func() error {
-			resp, err := b.mc.RedactEvent(channel, msg.ID, &matrix.ReqRedact{})
+			resp, err := mc.RedactEvent(channel, msg.ID, &matrix.ReqRedact{})
 			if err != nil {
 				return err
 			}
@@ -236,7 +323,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 			rmsg := rmsg
 
 			err := b.retry(func() error {
-				_, err := b.mc.SendText(channel, rmsg.Username+rmsg.Text)
+				_, err := mc.SendText(channel, rmsg.Username+rmsg.Text)
 
 				return err
 			})
@@ -246,7 +333,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 		}
 		// check if we have files to upload (from slack, telegram or mattermost)
 		if len(msg.Extra["file"]) > 0 {
-			return b.handleUploadFiles(&msg, channel)
+			return b.handleUploadFiles(mc, &msg, channel, puppeted)
 		}
 	}
 
@@ -281,7 +368,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 		}
 
 		err := b.retry(func() error {
-			_, err := b.mc.SendMessageEvent(channel, "m.room.message", rmsg)
+			_, err := mc.SendMessageEvent(channel, "m.room.message", rmsg)
 
 			return err
 		})
@@ -293,7 +380,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 	}
 
 	// Use notices to send join/leave events
-	if msg.Event == config.EventJoinLeave {
+	if config.IsJoinLeave(msg.Event) {
 		m := matrix.TextMessage{
 			MsgType:       "m.notice",
 			Body:          body,
@@ -312,7 +399,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 		)
 
 		err = b.retry(func() error {
-			resp, err = b.mc.SendMessageEvent(channel, "m.room.message", m)
+			resp, err = mc.SendMessageEvent(channel, "m.room.message", m)
 
 			return err
 		})
@@ -350,7 +437,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 		)
 
 		err = b.retry(func() error {
-			resp, err = b.mc.SendMessageEvent(channel, "m.room.message", m)
+			resp, err = mc.SendMessageEvent(channel, "m.room.message", m)
 
 			return err
 		})
@@ -368,7 +455,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 		)
 
 		err = b.retry(func() error {
-			resp, err = b.mc.SendText(channel, body)
+			resp, err = mc.SendText(channel, body)
 
 			return err
 		})
@@ -386,7 +473,7 @@ func (b *Bmatrix) Send(msg config.Message) (string, error) {
 	)
 
 	err = b.retry(func() error {
-		resp, err = b.mc.SendFormattedText(channel, body, formattedBody)
+		resp, err = mc.SendFormattedText(channel, body, formattedBody)
 
 		return err
 	})
@@ -402,6 +489,12 @@ func (b *Bmatrix) handlematrix() {
 	syncer.OnEventType("m.room.redaction", b.handleEvent)
 	syncer.OnEventType("m.room.message", b.handleEvent)
 	syncer.OnEventType("m.room.member", b.handleMemberChange)
+	syncer.OnEventType("m.reaction", b.handleReaction)
+	syncer.OnEventType("m.typing", b.handleTyping)
+	syncer.OnEventType("m.room.encrypted", b.handleEncrypted)
+	syncer.OnEventType("m.room.encryption", b.handleEncrypted)
+	syncer.OnEventType("m.call.invite", b.handleCallInvite)
+	syncer.OnEventType("org.matrix.msc3401.call.member", b.handleCallMember)
 	go func() {
 		for {
 			if b == nil {
@@ -444,11 +537,17 @@ func (b *Bmatrix) handleEdit(ev *matrix.Event, rmsg config.Message) bool {
 	rmsg.ID = relation.EventID
 	rmsg.Text = newContent.Body
 	rmsg.Text += b.GetString("EditSuffix")
-	b.Remote <- rmsg
+	b.relay(rmsg)
 
 	return true
 }
 
+// relay sends rmsg to the gateway after the messages of its room that were received before
+// it and are still downloading their attachments.
+func (b *Bmatrix) relay(rmsg config.Message) {
+	b.received.Relay(rmsg.Channel, func() { b.Remote <- rmsg })
+}
+
 func (b *Bmatrix) handleReply(ev *matrix.Event, rmsg config.Message) bool {
 	relationInterface, present := ev.Content["m.relates_to"]
 	if !present {
@@ -476,7 +575,7 @@ func (b *Bmatrix) handleReply(ev *matrix.Event, rmsg config.Message) bool {
 
 	rmsg.Text = body
 	rmsg.ParentID = relation.InReplyTo.EventID
-	b.Remote <- rmsg
+	b.relay(rmsg)
 
 	return true
 }
@@ -491,8 +590,14 @@ func (b *Bmatrix) handleMemberChange(ev *matrix.Event) {
 }
 
 func (b *Bmatrix) handleEvent(ev *matrix.Event) {
+	b.receiveEvent(ev, false)
+}
+
+// receiveEvent relays the event ev, received or fetched from the history of a room when
+// backfill is set.
+func (b *Bmatrix) receiveEvent(ev *matrix.Event, backfill bool) {
 	b.Log.Debugf("== Receiving event: %#v", ev)
-	if ev.Sender != b.UserID {
+	if ev.Sender != b.UserID && !b.isPuppet(ev.Sender) {
 		b.RLock()
 		channel, ok := b.RoomMap[ev.RoomID]
 		b.RUnlock()
@@ -503,12 +608,16 @@ func (b *Bmatrix) handleEvent(ev *matrix.Event) {
 
 		// Create our message
 		rmsg := config.Message{
-			Username: b.getDisplayName(ev.Sender),
-			Channel:  channel,
-			Account:  b.Account,
-			UserID:   ev.Sender,
-			ID:       ev.ID,
-			Avatar:   b.getAvatarURL(ev.Sender),
+			Username:  b.getDisplayName(ev.Sender),
+			Channel:   channel,
+			Account:   b.Account,
+			UserID:    ev.Sender,
+			ID:        ev.ID,
+			Avatar:    b.getAvatarURL(ev.Sender),
+			Timestamp: time.Unix(0, ev.Timestamp*int64(time.Millisecond)),
+		}
+		if backfill {
+			rmsg.Extra = map[string][]interface{}{config.ExtraBackfill: {true}}
 		}
 
 		// Remove homeserver suffix if configured
@@ -519,10 +628,14 @@ func (b *Bmatrix) handleEvent(ev *matrix.Event) {
 
 		// Delete event
 		if ev.Type == "m.room.redaction" {
+			if b.handleReactionRedaction(ev, &rmsg) {
+				b.relay(rmsg)
+				return
+			}
 			rmsg.Event = config.EventMsgDelete
 			rmsg.ID = ev.Redacts
 			rmsg.Text = config.EventMsgDelete
-			b.Remote <- rmsg
+			b.relay(rmsg)
 			return
 		}
 
@@ -548,17 +661,22 @@ func (b *Bmatrix) handleEvent(ev *matrix.Event) {
 			return
 		}
 
-		// Do we have attachments
+		// Do we have attachments, download them in the background so other messages aren't held up
 		if b.containsAttachment(ev.Content) {
-			err := b.handleDownloadFile(&rmsg, ev.Content)
-			if err != nil {
-				b.Log.Errorf("download failed: %#v", err)
-			}
+			b.received.Go(rmsg.Channel, func() {
+				err := b.handleDownloadFile(&rmsg, ev.Content)
+				if err != nil {
+					b.Log.Errorf("download failed: %#v", err)
+				}
+			}, func() {
+				b.Log.Debugf("<= Sending message from %s on %s to gateway", ev.Sender, b.Account)
+				b.Remote <- rmsg
+			})
+		} else {
+			b.Log.Debugf("<= Sending message from %s on %s to gateway", ev.Sender, b.Account)
+			b.relay(rmsg)
 		}
 
-		b.Log.Debugf("<= Sending message from %s on %s to gateway", ev.Sender, b.Account)
-		b.Remote <- rmsg
-
 		// not crucial, so no ratelimit check here
 		if err := b.mc.MarkRead(ev.RoomID, ev.ID); err != nil {
 			b.Log.Errorf("couldn't mark message as read %s", err.Error())
@@ -575,12 +693,14 @@ func (b *Bmatrix) handleDownloadFile(rmsg *config.Message, content map[string]in
 		size                      float64
 	)
 
-	rmsg.Extra = make(map[string][]interface{})
+	if rmsg.Extra == nil {
+		rmsg.Extra = make(map[string][]interface{})
+	}
 	if url, ok = content["url"].(string); !ok {
 		return fmt.Errorf("url isn't a %T", url)
 	}
 	// https://github.com/matrix-org/matrix-spec-proposals/blob/main/proposals/3916-authentication-for-media.md
-	url = strings.Replace(url, "mxc://", b.GetString("Server")+"/_matrix/client/v1/media/download/", -1)
+	url = strings.Replace(url, "mxc://", b.homeserver()+"/_matrix/client/v1/media/download/", -1)
 
 	if info, ok = content["info"].(map[string]interface{}); !ok {
 		return fmt.Errorf("info isn't a %T", info)
@@ -612,44 +732,66 @@ func (b *Bmatrix) handleDownloadFile(rmsg *config.Message, content map[string]in
 	}
 
 	// check if the size is ok
-	err := helper.HandleDownloadSize(b.Log, rmsg, name, int64(size), b.General)
+	err := helper.CheckDownload(b.Log, rmsg, helper.DownloadInfo{Name: name, Size: int64(size), URL: url}, b.General)
 	if err != nil {
 		return err
 	}
 	// actually download the file
-	data, err := helper.DownloadFileAuth(url, "Bearer "+b.mc.AccessToken)
+	media, err := b.DownloadMedia(url, http.Header{"Authorization": {"Bearer " + b.mc.AccessToken}})
 	if err != nil {
 		return fmt.Errorf("download %s failed %#v", url, err)
 	}
 	// add the downloaded data to the message
-	helper.HandleDownloadData(b.Log, rmsg, name, "", url, data, b.General)
+	helper.HandleDownloadMedia(b.Log, rmsg, name, "", "", url, media)
 	return nil
 }
 
 // handleUploadFiles handles native upload of files.
-func (b *Bmatrix) handleUploadFiles(msg *config.Message, channel string) (string, error) {
+func (b *Bmatrix) handleUploadFiles(mc *matrix.Client, msg *config.Message, channel string, puppeted bool) (string, error) {
 	for _, f := range msg.Extra["file"] {
 		if fi, ok := f.(config.FileInfo); ok {
-			b.handleUploadFile(msg, channel, &fi)
+			b.handleUploadFile(mc, msg, channel, &fi, puppeted)
 		}
 	}
 	return "", nil
 }
 
 // handleUploadFile handles native upload of a file.
-func (b *Bmatrix) handleUploadFile(msg *config.Message, channel string, fi *config.FileInfo) {
+func (b *Bmatrix) handleUploadFile(mc *matrix.Client, msg *config.Message, channel string, fi *config.FileInfo, puppeted bool) {
 	username := newMatrixUsername(msg.Username)
+	if puppeted {
+		username = newMatrixUsername("")
+	}
+	// a file without content can't be uploaded, send its link instead
+	if fi.Data == nil || len(*fi.Data) == 0 {
+		text := helper.FileText(*fi)
+		if text == "" {
+			return
+		}
+		err := b.retry(func() error {
+			_, err := mc.SendFormattedText(channel, username.plain+text, username.formatted+text)
+
+			return err
+		})
+		if err != nil {
+			b.Log.Errorf("file link failed: %#v", err)
+		}
+		return
+	}
 	content := bytes.NewReader(*fi.Data)
 	sp := strings.Split(fi.Name, ".")
 	mtype := mime.TypeByExtension("." + sp[len(sp)-1])
 	// image and video uploads send no username, we have to do this ourself here #715
-	err := b.retry(func() error {
-		_, err := b.mc.SendFormattedText(channel, username.plain+fi.Comment, username.formatted+fi.Comment)
+	var err error
+	if username.plain+fi.Comment != "" {
+		err = b.retry(func() error {
+			_, err := mc.SendFormattedText(channel, username.plain+fi.Comment, username.formatted+fi.Comment)
 
-		return err
-	})
-	if err != nil {
-		b.Log.Errorf("file comment failed: %#v", err)
+			return err
+		})
+		if err != nil {
+			b.Log.Errorf("file comment failed: %#v", err)
+		}
 	}
 
 	b.Log.Debugf("uploading file: %s %s", fi.Name, mtype)
@@ -657,7 +799,7 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, channel string, fi *conf
 	var res *matrix.RespMediaUpload
 
 	err = b.retry(func() error {
-		res, err = b.mc.UploadToContentRepo(content, mtype, int64(len(*fi.Data)))
+		res, err = mc.UploadToContentRepo(content, mtype, int64(len(*fi.Data)))
 
 		return err
 	})
@@ -671,7 +813,7 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, channel string, fi *conf
 	case strings.Contains(mtype, "video"):
 		b.Log.Debugf("sendVideo %s", res.ContentURI)
 		err = b.retry(func() error {
-			_, err = b.mc.SendVideo(channel, fi.Name, res.ContentURI)
+			_, err = mc.SendVideo(channel, fi.Name, res.ContentURI)
 
 			return err
 		})
@@ -696,7 +838,7 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, channel string, fi *conf
 			},
 		}
 		err = b.retry(func() error {
-			_, err = b.mc.SendMessageEvent(channel, "m.room.message", img)
+			_, err = mc.SendMessageEvent(channel, "m.room.message", img)
 			return err
 		})
 		if err != nil {
@@ -705,7 +847,7 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, channel string, fi *conf
 	case strings.Contains(mtype, "audio"):
 		b.Log.Debugf("sendAudio %s", res.ContentURI)
 		err = b.retry(func() error {
-			_, err = b.mc.SendMessageEvent(channel, "m.room.message", matrix.AudioMessage{
+			_, err = mc.SendMessageEvent(channel, "m.room.message", matrix.AudioMessage{
 				MsgType: "m.audio",
 				Body:    fi.Name,
 				URL:     res.ContentURI,
@@ -723,7 +865,7 @@ func (b *Bmatrix) handleUploadFile(msg *config.Message, channel string, fi *conf
 	default:
 		b.Log.Debugf("sendFile %s", res.ContentURI)
 		err = b.retry(func() error {
-			_, err = b.mc.SendMessageEvent(channel, "m.room.message", matrix.FileMessage{
+			_, err = mc.SendMessageEvent(channel, "m.room.message", matrix.FileMessage{
 				MsgType: "m.file",
 				Body:    fi.Name,
 				URL:     res.ContentURI,
@@ -0,0 +1,118 @@
+package bmatrix
+
+import (
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	lru "github.com/hashicorp/golang-lru"
+	matrix "github.com/matterbridge/gomatrix"
+)
+
+// reactionCacheSize is the number of reactions kept to relay their removal.
+const reactionCacheSize = 1000
+
+// ReactionRelation is the relation of a reaction to the message it annotates.
+type ReactionRelation struct {
+	EventID string `json:"event_id"`
+	Type    string `json:"rel_type"`
+	Key     string `json:"key"`
+}
+
+// ReactionMessage is the content of a m.reaction event.
+type ReactionMessage struct {
+	RelatedTo ReactionRelation `json:"m.relates_to"`
+}
+
+// reactions keeps the reaction events, a reaction is removed by redacting its event.
+type reactions struct {
+	// received are the relations of the reactions of the matrix users, by event ID
+	received *lru.Cache
+	// sent are the event IDs of the reactions relayed from the other bridges
+	sent *lru.Cache
+}
+
+func newReactions(general *config.Protocol) *reactions {
+	received, _ := lru.New(helper.CacheSize(general, reactionCacheSize))
+	sent, _ := lru.New(helper.CacheSize(general, reactionCacheSize))
+	return &reactions{received: received, sent: sent}
+}
+
+// sentKey identifies the reaction msg of a remote user.
+func sentKey(msg *config.Message) string {
+	return msg.ParentID + " " + msg.Text + " " + msg.Account + " " + msg.UserID
+}
+
+func (b *Bmatrix) handleReaction(ev *matrix.Event) {
+	b.Log.Debugf("== Receiving event: %#v", ev)
+	if ev.Sender == b.UserID || b.isPuppet(ev.Sender) {
+		return
+	}
+	b.RLock()
+	channel, ok := b.RoomMap[ev.RoomID]
+	b.RUnlock()
+	if !ok {
+		b.Log.Debugf("Unknown room %s", ev.RoomID)
+		return
+	}
+
+	var content ReactionMessage
+	if err := interface2Struct(ev.Content, &content); err != nil || content.RelatedTo.Type != "m.annotation" {
+		b.Log.Warnf("Couldn't parse reaction %#v", ev.Content)
+		return
+	}
+	b.reactions.received.Add(ev.ID, content.RelatedTo)
+
+	b.Log.Debugf("<= Sending reaction from %s on %s to gateway", ev.Sender, b.Account)
+	b.Remote <- config.Message{
+		Username: b.getDisplayName(ev.Sender),
+		Channel:  channel,
+		Account:  b.Account,
+		UserID:   ev.Sender,
+		Event:    config.EventReactionAdd,
+		Text:     content.RelatedTo.Key,
+		ParentID: content.RelatedTo.EventID,
+	}
+}
+
+// handleReactionRedaction changes the redaction rmsg of a reaction into its removal and
+// returns false when the redacted event isn't a known reaction.
+func (b *Bmatrix) handleReactionRedaction(ev *matrix.Event, rmsg *config.Message) bool {
+	v, ok := b.reactions.received.Get(ev.Redacts)
+	if !ok {
+		return false
+	}
+	b.reactions.received.Remove(ev.Redacts)
+	relation := v.(ReactionRelation)
+	rmsg.Event = config.EventReactionRemove
+	rmsg.ID = ""
+	rmsg.Text = relation.Key
+	rmsg.ParentID = relation.EventID
+	return true
+}
+
+// sendReaction adds the reaction msg to the message msg.ParentID or redacts it again.
+func (b *Bmatrix) sendReaction(mc *matrix.Client, msg *config.Message, channel string) error {
+	if !msg.ParentValid() {
+		return nil
+	}
+	key := sentKey(msg)
+	if msg.Event == config.EventReactionRemove {
+		v, ok := b.reactions.sent.Get(key)
+		if !ok {
+			return nil
+		}
+		b.reactions.sent.Remove(key)
+		return b.retry(func() error {
+			_, err := mc.RedactEvent(channel, v.(string), &matrix.ReqRedact{})
+			return err
+		})
+	}
+	content := ReactionMessage{RelatedTo: ReactionRelation{EventID: msg.ParentID, Type: "m.annotation", Key: msg.Text}}
+	return b.retry(func() error {
+		resp, err := mc.SendMessageEvent(channel, "m.reaction", content)
+		if err != nil {
+			return err
+		}
+		b.reactions.sent.Add(key, resp.EventID)
+		return nil
+	})
+}
@@ -0,0 +1,47 @@
+package bmatrix
+
+import (
+	"github.com/42wim/matterbridge/bridge/config"
+	matrix "github.com/matterbridge/gomatrix"
+)
+
+// typingTimeout is how long, in milliseconds, a typing indicator sent to matrix lasts.
+const typingTimeout = 10000
+
+// handleTyping sends a typing event for the users in the m.typing event ev. Matrix sends
+// it with all the users typing in the room each time that list changes.
+func (b *Bmatrix) handleTyping(ev *matrix.Event) {
+	if !b.GetBool("ShowUserTyping") {
+		return
+	}
+	b.RLock()
+	channel, ok := b.RoomMap[ev.RoomID]
+	b.RUnlock()
+	if !ok {
+		return
+	}
+	userIDs, _ := ev.Content["user_ids"].([]interface{})
+	for _, v := range userIDs {
+		userID, ok := v.(string)
+		if !ok || userID == b.UserID || b.isPuppet(userID) {
+			continue
+		}
+		b.Remote <- config.Message{
+			Username: b.getDisplayName(userID),
+			UserID:   userID,
+			Channel:  channel,
+			Account:  b.Account,
+			Event:    config.EventUserTyping,
+		}
+	}
+}
+
+// sendTyping shows that the sender of a typing event is typing in the room roomID, mc is
+// the puppet of the sender when there is one.
+func (b *Bmatrix) sendTyping(mc *matrix.Client, roomID string) error {
+	if !b.GetBool("ShowUserTyping") {
+		return nil
+	}
+	_, err := mc.UserTyping(roomID, true, typingTimeout)
+	return err
+}
@@ -2,6 +2,8 @@ package bmattermost
 
 import (
 	"context"
+	"encoding/json"
+	"time"
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
@@ -22,7 +24,7 @@ func (b *Bmattermost) handleDownloadAvatar(userid string, channel string) {
 		Event:    config.EventAvatarDownload,
 		Extra:    make(map[string][]interface{}),
 	}
-	if _, ok := b.avatarMap[userid]; !ok {
+	if !b.avatarMap.Contains(userid) {
 		var (
 			data []byte
 			err  error
@@ -50,7 +52,7 @@ func (b *Bmattermost) handleDownloadFile(rmsg *config.Message, id string) error
 	if err != nil {
 		return err
 	}
-	err = helper.HandleDownloadSize(b.Log, rmsg, finfo.Name, finfo.Size, b.General)
+	err = helper.CheckDownload(b.Log, rmsg, helper.DownloadInfo{Name: finfo.Name, Size: finfo.Size, URL: url}, b.General)
 	if err != nil {
 		return err
 	}
@@ -98,6 +100,13 @@ func (b *Bmattermost) handleMatterClient(messages chan *config.Message) {
 	for message := range b.mc.MessageChan {
 		b.Log.Debugf("%#v %#v", message.Raw.GetData(), message.Raw.EventType())
 
+		if isReactionEvent(message) {
+			if rmsg := b.handleReaction(message); rmsg != nil {
+				messages <- rmsg
+			}
+			continue
+		}
+
 		if b.skipMessage(message) {
 			b.Log.Debugf("Skipped message: %#v", message)
 			continue
@@ -109,24 +118,36 @@ func (b *Bmattermost) handleMatterClient(messages chan *config.Message) {
 		}
 
 		// only download avatars if we have a place to upload them (configured mediaserver)
-		if b.General.MediaServerUpload != "" || b.General.MediaDownloadPath != "" {
+		if helper.HasMediaServer(b.General) {
 			b.handleDownloadAvatar(message.UserID, channelName)
 		}
 
 		b.Log.Debugf("== Receiving event %#v", message)
 
 		rmsg := &config.Message{
-			Username: message.Username,
-			UserID:   message.UserID,
-			Channel:  channelName,
-			Text:     message.Text,
-			ID:       message.Post.Id,
-			ParentID: message.Post.RootId, // ParentID is obsolete with mattermost
-			Extra:    make(map[string][]interface{}),
+			Username:  message.Username,
+			UserID:    message.UserID,
+			Channel:   channelName,
+			Text:      message.Text,
+			ID:        message.Post.Id,
+			ParentID:  message.Post.RootId, // ParentID is obsolete with mattermost
+			ThreadID:  message.Post.RootId,
+			Extra:     make(map[string][]interface{}),
+			Timestamp: time.Unix(0, message.Post.CreateAt*int64(time.Millisecond)),
+		}
+
+		// edits are ordered by their time, see the gateway
+		if message.Post.EditAt > 0 {
+			rmsg.Timestamp = time.Unix(0, message.Post.EditAt*int64(time.Millisecond))
 		}
 
 		// handle mattermost post properties (override username and attachments)
 		b.handleProps(rmsg, message)
+		handlePriority(rmsg, message.Post)
+
+		if user := b.mc.GetUser(message.UserID); user != nil && user.CreateAt != 0 {
+			rmsg.Extra[config.ExtraUserCreated] = []interface{}{time.Unix(0, user.CreateAt*int64(time.Millisecond))}
+		}
 
 		// create a text for bridges that don't support native editing
 		if message.Raw.EventType() == model.WebsocketEventPostEdited && !b.GetBool("EditDisable") {
@@ -169,20 +190,29 @@ func (b *Bmattermost) handleMatterHook(messages chan *config.Message) {
 	}
 }
 
-func (b *Bmattermost) handleUploadFile(msg *config.Message) (string, error) {
+// handleUploadFile uploads the files of msg with the API, the nick is prepended to their comment
+// with prefixNick. The files without content are posted as a link.
+func (b *Bmattermost) handleUploadFile(msg *config.Message, prefixNick bool) (string, error) {
 	var err error
 	var res, id string
 	channelID := b.getChannelID(msg.Channel)
 	for _, f := range msg.Extra["file"] {
 		fi := f.(config.FileInfo)
+		msg.Text = fi.Comment
+		if fi.Data == nil || len(*fi.Data) == 0 {
+			msg.Text = helper.FileText(fi)
+		}
+		if prefixNick {
+			msg.Text = msg.Username + msg.Text
+		}
+		if fi.Data == nil || len(*fi.Data) == 0 {
+			res, err = b.mc.PostMessage(channelID, msg.Text, msg.ParentID)
+			continue
+		}
 		id, err = b.mc.UploadFile(*fi.Data, channelID, fi.Name)
 		if err != nil {
 			return "", err
 		}
-		msg.Text = fi.Comment
-		if b.GetBool("PrefixMessagesWithNick") {
-			msg.Text = msg.Username + msg.Text
-		}
 		res, err = b.mc.PostMessageWithFiles(channelID, msg.Text, msg.ParentID, []string{id})
 	}
 	return res, err
@@ -215,3 +245,80 @@ func (b *Bmattermost) handleProps(rmsg *config.Message, message *matterclient.Me
 		}
 	}
 }
+
+func isReactionEvent(message *matterclient.Message) bool {
+	return message.Raw.EventType() == model.WebsocketEventReactionAdded ||
+		message.Raw.EventType() == model.WebsocketEventReactionRemoved
+}
+
+// handleReaction returns the message of a reaction event, or nil when it's ignored.
+func (b *Bmattermost) handleReaction(message *matterclient.Message) *config.Message {
+	data, ok := message.Raw.GetData()["reaction"].(string)
+	if !ok {
+		return nil
+	}
+	var reaction model.Reaction
+	if err := json.Unmarshal([]byte(data), &reaction); err != nil {
+		b.Log.Errorf("reaction %s: %s", data, err)
+		return nil
+	}
+	// our own reactions are the ones relayed from the other bridges
+	if reaction.UserId == b.mc.User.Id {
+		return nil
+	}
+	broadcast := message.Raw.GetBroadcast()
+	if broadcast == nil {
+		return nil
+	}
+	channelName := b.getChannelName(broadcast.ChannelId)
+	if channelName == "" {
+		return nil
+	}
+	rmsg := &config.Message{
+		Username: b.mc.GetUserName(reaction.UserId),
+		UserID:   reaction.UserId,
+		Channel:  channelName,
+		Event:    config.EventReactionAdd,
+		Text:     helper.EmojiFromShortcode(reaction.EmojiName),
+		ParentID: reaction.PostId,
+	}
+	if message.Raw.EventType() == model.WebsocketEventReactionRemoved {
+		rmsg.Event = config.EventReactionRemove
+	}
+	if !b.GetBool("useusername") {
+		if nick := b.mc.GetNickName(reaction.UserId); nick != "" {
+			rmsg.Username = nick
+		}
+	}
+	return rmsg
+}
+
+// sendReaction adds or removes the reaction msg of the bot on the post msg.ParentID.
+func (b *Bmattermost) sendReaction(msg *config.Message) error {
+	name, ok := helper.EmojiShortcode(msg.Text)
+	if !ok || !msg.ParentValid() {
+		return nil
+	}
+	reaction := &model.Reaction{UserId: b.mc.User.Id, PostId: msg.ParentID, EmojiName: name}
+	if msg.Event == config.EventReactionRemove {
+		_, err := b.mc.Client.DeleteReaction(context.TODO(), reaction)
+		return err
+	}
+	_, _, err := b.mc.Client.SaveReaction(context.TODO(), reaction)
+	return err
+}
+
+// handlePriority adds the priority of post, and whether an acknowledgement was requested,
+// to rmsg.
+func handlePriority(rmsg *config.Message, post *model.Post) {
+	priority := post.GetPriority()
+	if priority == nil {
+		return
+	}
+	if priority.Priority != nil && *priority.Priority != "" {
+		rmsg.Extra[config.ExtraPriority] = []interface{}{*priority.Priority}
+	}
+	if priority.RequestedAck != nil && *priority.RequestedAck {
+		rmsg.Extra[config.ExtraRequestedAck] = []interface{}{true}
+	}
+}
@@ -1,11 +1,13 @@
 package bmattermost
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/hook/signature"
 	"github.com/42wim/matterbridge/matterhook"
 	"github.com/matterbridge/matterclient"
 	"github.com/mattermost/mattermost/server/public/model"
@@ -19,6 +21,7 @@ func (b *Bmattermost) doConnectWebhookBind() error {
 			matterhook.Config{
 				InsecureSkipVerify: b.GetBool("SkipTLSVerify"),
 				BindAddress:        b.GetString("WebhookBindAddress"),
+				Verifier:           b.WebhookVerifier(signature.ModeToken),
 			})
 	case b.GetString("Token") != "":
 		b.Log.Info("Connecting using token (sending)")
@@ -38,6 +41,7 @@ func (b *Bmattermost) doConnectWebhookBind() error {
 			matterhook.Config{
 				InsecureSkipVerify: b.GetBool("SkipTLSVerify"),
 				BindAddress:        b.GetString("WebhookBindAddress"),
+				Verifier:           b.WebhookVerifier(signature.ModeToken),
 			})
 	}
 	return nil
@@ -66,21 +70,33 @@ func (b *Bmattermost) doConnectWebhookURL() error {
 	return nil
 }
 
-//nolint:wrapcheck
+// apiLogin logs in on the first healthy server of Servers.
 func (b *Bmattermost) apiLogin() error {
+	scheme := "https://"
+	if b.GetBool("NoTLS") {
+		scheme = "http://"
+	}
+	check := func(server string) error {
+		return b.CheckHTTP("/api/v4/system/ping")(scheme + server)
+	}
+	return b.ConnectFailover(check, b.login)
+}
+
+//nolint:wrapcheck
+func (b *Bmattermost) login(server string) error {
 	password := b.GetString("Password")
 	if b.GetString("Token") != "" {
 		password = "token=" + b.GetString("Token")
 	}
 
-	b.mc = matterclient.New(b.GetString("Login"), password, b.GetString("Team"), b.GetString("Server"), "")
+	b.mc = matterclient.New(b.GetString("Login"), password, b.GetString("Team"), server, "")
 	if b.GetBool("debug") {
 		b.mc.SetLogLevel("debug")
 	}
 	b.mc.SkipTLSVerify = b.GetBool("SkipTLSVerify")
 	b.mc.SkipVersionCheck = b.GetBool("SkipVersionCheck")
 	b.mc.NoTLS = b.GetBool("NoTLS")
-	b.Log.Infof("Connecting %s (team: %s) on %s", b.GetString("Login"), b.GetString("Team"), b.GetString("Server"))
+	b.Log.Infof("Connecting %s (team: %s) on %s", b.GetString("Login"), b.GetString("Team"), server)
 
 	if err := b.mc.Login(); err != nil {
 		return err
@@ -105,7 +121,9 @@ func (b *Bmattermost) cacheAvatar(msg *config.Message) (string, error) {
 	so we can now cache the sha */
 	if fi.SHA != "" {
 		b.Log.Debugf("Added %s to %s in avatarMap", fi.SHA, msg.UserID)
-		b.avatarMap[msg.UserID] = fi.SHA
+		if err := b.avatarMap.SetString(msg.UserID, fi.SHA); err != nil {
+			return "", err
+		}
 	}
 	return "", nil
 }
@@ -117,6 +135,9 @@ func (b *Bmattermost) sendWebhook(msg config.Message) (string, error) {
 		return "", nil
 	}
 
+	// webhooks can't set the priority of the post
+	msg.Text = helper.PriorityMarker(helper.MessagePriority(&msg)) + msg.Text
+
 	if b.GetBool("PrefixMessagesWithNick") {
 		msg.Text = msg.Username + msg.Text
 	}
@@ -139,7 +160,13 @@ func (b *Bmattermost) sendWebhook(msg config.Message) (string, error) {
 			}
 		}
 
-		// webhook doesn't support file uploads, so we add the url manually
+		// webhook doesn't support file uploads, they're uploaded with the API when we're also
+		// logged in, with the nick in front as the username can't be overridden there
+		if len(msg.Extra["file"]) > 0 && b.mc != nil {
+			return b.handleUploadFile(&msg, true)
+		}
+
+		// otherwise we add the url manually
 		if len(msg.Extra["file"]) > 0 {
 			for _, f := range msg.Extra["file"] {
 				fi := f.(config.FileInfo)
@@ -175,19 +202,17 @@ func (b *Bmattermost) sendWebhook(msg config.Message) (string, error) {
 //nolint:gocyclo,cyclop
 func (b *Bmattermost) skipMessage(message *matterclient.Message) bool {
 	// Handle join/leave
-	skipJoinMessageTypes := map[string]struct{}{
-		"system_join_leave":          {}, // deprecated for system_add_to_channel
-		"system_leave_channel":       {}, // deprecated for system_remove_from_channel
-		"system_join_channel":        {},
-		"system_add_to_channel":      {},
-		"system_remove_from_channel": {},
-		"system_add_to_team":         {},
-		"system_remove_from_team":    {},
-	}
-
-	// dirty hack to efficiently check if this element is in the map without writing a contains func
-	// can be replaced with native slice.contains with go 1.21
-	if _, ok := skipJoinMessageTypes[message.Type]; ok {
+	skipJoinMessageTypes := map[string]string{
+		"system_join_leave":          config.EventJoinLeave, // deprecated for system_add_to_channel
+		"system_leave_channel":       config.EventPart,      // deprecated for system_remove_from_channel
+		"system_join_channel":        config.EventJoin,
+		"system_add_to_channel":      config.EventJoin,
+		"system_remove_from_channel": config.EventKick,
+		"system_add_to_team":         config.EventJoin,
+		"system_remove_from_team":    config.EventPart,
+	}
+
+	if joinEvent, ok := skipJoinMessageTypes[message.Type]; ok {
 		if b.GetBool("nosendjoinpart") {
 			return true
 		}
@@ -203,7 +228,7 @@ func (b *Bmattermost) skipMessage(message *matterclient.Message) bool {
 			Text:     message.Text,
 			Channel:  channelName,
 			Account:  b.Account,
-			Event:    config.EventJoinLeave,
+			Event:    joinEvent,
 		}
 		return true
 	}
@@ -292,3 +317,32 @@ func (b *Bmattermost) getChannelName(id string) string {
 
 	return ""
 }
+
+// postMessageWithPriority posts msg with priority and an acknowledgement request if ack.
+func (b *Bmattermost) postMessageWithPriority(msg *config.Message, priority string, ack bool) (string, error) {
+	post := &model.Post{
+		ChannelId: b.getChannelID(msg.Channel),
+		Message:   msg.Text,
+		RootId:    msg.ParentID,
+		Metadata: &model.PostMetadata{
+			Priority: &model.PostPriority{Priority: model.NewString(priority), RequestedAck: model.NewBool(ack)},
+		},
+	}
+	res, _, err := b.mc.Client.CreatePost(context.TODO(), post)
+	if err != nil {
+		return "", err
+	}
+	return res.Id, nil
+}
+
+// Permalink implements bridge.Permalinker.
+func (b *Bmattermost) Permalink(channel, id string) (string, bool) {
+	if b.mc == nil || b.mc.Credentials == nil || id == "" {
+		return "", false
+	}
+	scheme := "https://"
+	if b.GetBool("NoTLS") {
+		scheme = "http://"
+	}
+	return scheme + b.mc.Credentials.Server + "/" + b.GetString("Team") + "/pl/" + id, true
+}
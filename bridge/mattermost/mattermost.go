@@ -10,6 +10,7 @@ import (
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/store"
 	"github.com/42wim/matterbridge/matterhook"
 	"github.com/matterbridge/matterclient"
 	"github.com/rs/xid"
@@ -22,7 +23,7 @@ type Bmattermost struct {
 	uuid   string
 	TeamID string
 	*bridge.Config
-	avatarMap      map[string]string
+	avatarMap      *store.Bucket
 	channelsMutex  sync.RWMutex
 	channelInfoMap map[string]*config.ChannelInfo
 }
@@ -32,7 +33,7 @@ const mattermostPlugin = "mattermost.plugin"
 func New(cfg *bridge.Config) bridge.Bridger {
 	b := &Bmattermost{
 		Config:         cfg,
-		avatarMap:      make(map[string]string),
+		avatarMap:      cfg.NewBucket("avatar"),
 		channelInfoMap: make(map[string]*config.ChannelInfo),
 	}
 
@@ -150,6 +151,15 @@ func (b *Bmattermost) Send(msg config.Message) (string, error) {
 		return msg.ID, b.mc.DeleteMessage(msg.ID)
 	}
 
+	if msg.Event == config.EventReactionAdd || msg.Event == config.EventReactionRemove {
+		return "", b.sendReaction(&msg)
+	}
+
+	// Replies are posted as a reply to the root post of the thread.
+	if msg.ThreadID != "" {
+		msg.ParentID = msg.ThreadID
+	}
+
 	// Handle prefix hint for unthreaded messages.
 	if msg.ParentNotFound() {
 		msg.ParentID = ""
@@ -175,7 +185,7 @@ func (b *Bmattermost) Send(msg config.Message) (string, error) {
 			}
 		}
 		if len(msg.Extra["file"]) > 0 {
-			return b.handleUploadFile(&msg)
+			return b.handleUploadFile(&msg, b.GetBool("PrefixMessagesWithNick"))
 		}
 	}
 
@@ -190,5 +200,8 @@ func (b *Bmattermost) Send(msg config.Message) (string, error) {
 	}
 
 	// Post normal message
+	if priority, ack := helper.MessagePriority(&msg); priority != "" || ack {
+		return b.postMessageWithPriority(&msg, priority, ack)
+	}
 	return b.mc.PostMessage(b.getChannelID(msg.Channel), msg.Text, msg.ParentID)
 }
@@ -36,7 +36,7 @@ func (b *Bmsteams) handleDownloadFile(rmsg *config.Message, filename, weburl str
 		return err
 	}
 	// Actually download the file.
-	data, err := helper.DownloadFile(realURL)
+	media, err := b.DownloadMedia(realURL, nil)
 	if err != nil {
 		return fmt.Errorf("download %s failed %#v", weburl, err)
 	}
@@ -46,7 +46,7 @@ func (b *Bmsteams) handleDownloadFile(rmsg *config.Message, filename, weburl str
 	// that the comment is not duplicated.
 	comment := rmsg.Text
 	rmsg.Text = ""
-	helper.HandleDownloadData(b.Log, rmsg, filename, comment, weburl, data, b.General)
+	helper.HandleDownloadMedia(b.Log, rmsg, filename, "", comment, weburl, media)
 	return nil
 }
 
@@ -19,6 +19,10 @@ import (
 	"golang.org/x/oauth2"
 )
 
+// refreshTokenLifetime is how long Azure AD accepts a refresh token that isn't used, the
+// default of its maximum inactive time.
+const refreshTokenLifetime = 90 * 24 * time.Hour
+
 var (
 	defaultScopes = []string{"openid", "profile", "offline_access", "Group.Read.All", "Group.ReadWrite.All"}
 	attachRE      = regexp.MustCompile(`<attachment id=.*?attachment>`)
@@ -36,10 +40,7 @@ func New(cfg *bridge.Config) bridge.Bridger {
 }
 
 func (b *Bmsteams) Connect() error {
-	tokenCachePath := b.GetString("sessionFile")
-	if tokenCachePath == "" {
-		tokenCachePath = "msteams_session.json"
-	}
+	tokenCachePath := b.tokenCachePath()
 	ctx := context.Background()
 	m := msauth.NewManager()
 	m.LoadFile(tokenCachePath) //nolint:errcheck
@@ -69,6 +70,23 @@ func (b *Bmsteams) Connect() error {
 	return nil
 }
 
+func (b *Bmsteams) tokenCachePath() string {
+	if path := b.GetString("sessionFile"); path != "" {
+		return path
+	}
+	return "msteams_session.json"
+}
+
+// CredentialsExpiry returns when the refresh token of the session file, saved at Connect,
+// expires if it isn't used. The refreshed tokens of a running bridge aren't saved.
+func (b *Bmsteams) CredentialsExpiry() (time.Time, bool) {
+	fi, err := os.Stat(b.tokenCachePath())
+	if err != nil {
+		return time.Time{}, false
+	}
+	return fi.ModTime().Add(refreshTokenLifetime), true
+}
+
 func (b *Bmsteams) Disconnect() error {
 	return nil
 }
@@ -94,37 +94,41 @@ func (b *Bmumble) handleJoinLeave(event *gumble.UserChangeEvent) {
 	}
 	b.Log.Debugf("Received gumble user change event: %+v", event)
 
-	text := ""
+	text, joinEvent := "", ""
 	switch {
 	case event.Type&gumble.UserChangeKicked > 0:
-		text = " was kicked"
+		text, joinEvent = " was kicked", config.EventKick
 	case event.Type&gumble.UserChangeBanned > 0:
-		text = " was banned"
+		text, joinEvent = " was banned", config.EventBan
 	case event.Type&gumble.UserChangeDisconnected > 0:
 		if event.User.Channel != nil && event.User.Channel.ID == *b.Channel {
-			text = " left"
+			text, joinEvent = " left", config.EventQuit
 		}
 	case event.Type&gumble.UserChangeConnected > 0:
 		if event.User.Channel != nil && event.User.Channel.ID == *b.Channel {
-			text = " joined"
+			text, joinEvent = " joined", config.EventJoin
 		}
 	case event.Type&gumble.UserChangeChannel > 0:
 		// Treat Mumble channel changes the same as connects/disconnects; as far as matterbridge is concerned, they are identical
 		if event.User.Channel != nil && event.User.Channel.ID == *b.Channel {
-			text = " joined"
+			text, joinEvent = " joined", config.EventJoin
 		} else {
-			text = " left"
+			text, joinEvent = " left", config.EventPart
 		}
 	}
 
 	if text != "" {
-		b.Remote <- config.Message{
+		rmsg := config.Message{
 			Username: "system",
 			Text:     event.User.Name + text,
 			Channel:  strconv.FormatUint(uint64(*b.Channel), 10),
 			Account:  b.Account,
-			Event:    config.EventJoinLeave,
+			Event:    joinEvent,
 		}
+		if joinEvent == config.EventBan {
+			rmsg.Extra = map[string][]interface{}{config.ExtraBanned: {event.User.Name + "@" + b.Host, event.User.Name}}
+		}
+		b.Remote <- rmsg
 	}
 }
 
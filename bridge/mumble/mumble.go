@@ -93,7 +93,7 @@ func (b *Bmumble) JoinChannel(channel config.ChannelInfo) error {
 func (b *Bmumble) Send(msg config.Message) (string, error) {
 	// Only process text messages
 	b.Log.Debugf("=> Received local message %#v", msg)
-	if msg.Event != "" && msg.Event != config.EventUserAction && msg.Event != config.EventJoinLeave {
+	if msg.Event != "" && msg.Event != config.EventUserAction && !config.IsJoinLeave(msg.Event) {
 		return "", nil
 	}
 
@@ -186,7 +186,12 @@ func (b *Bmumble) doConnect() error {
 	}
 
 	registerNullCodecAsOpus()
-	client, err := gumble.DialWithDialer(new(net.Dialer), b.GetString("Server"), gumbleConfig, &b.tlsConfig)
+	// gumble dials "tcp" itself, IPVersion isn't applied
+	dialer, err := b.Dialer()
+	if err != nil {
+		return err
+	}
+	client, err := gumble.DialWithDialer(dialer, b.GetString("Server"), gumbleConfig, &b.tlsConfig)
 	if err != nil {
 		return err
 	}
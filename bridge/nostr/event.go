@@ -0,0 +1,149 @@
+package bnostr
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// The kinds of the events of the bridge, see NIP-01, NIP-09 and NIP-28.
+const (
+	kindMetadata       = 0
+	kindDeletion       = 5
+	kindChannelMessage = 42
+)
+
+// event is a nostr event, see https://github.com/nostr-protocol/nips/blob/master/01.md.
+type event struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// serialize returns the JSON array the ID of ev is the sha256 of, with the escaping of
+// NIP-01: encoding/json would also escape <, > and & and the line separators.
+func (ev *event) serialize() []byte {
+	var sb strings.Builder
+	sb.WriteString(`[0,`)
+	writeString(&sb, ev.PubKey)
+	sb.WriteString(",")
+	sb.WriteString(strconv.FormatInt(ev.CreatedAt, 10))
+	sb.WriteString(",")
+	sb.WriteString(strconv.Itoa(ev.Kind))
+	sb.WriteString(",[")
+	for i, tag := range ev.Tags {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("[")
+		for j, v := range tag {
+			if j > 0 {
+				sb.WriteString(",")
+			}
+			writeString(&sb, v)
+		}
+		sb.WriteString("]")
+	}
+	sb.WriteString("],")
+	writeString(&sb, ev.Content)
+	sb.WriteString("]")
+	return []byte(sb.String())
+}
+
+func writeString(sb *strings.Builder, s string) {
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		default:
+			if r < 0x20 {
+				sb.WriteString(`\u00`)
+				sb.WriteByte("0123456789abcdef"[r>>4])
+				sb.WriteByte("0123456789abcdef"[r&0xf])
+				continue
+			}
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+}
+
+func (ev *event) hash() []byte {
+	sum := sha256.Sum256(ev.serialize())
+	return sum[:]
+}
+
+// sign sets the public key, ID and signature of ev for the secret key seckey.
+func (ev *event) sign(seckey []byte) error {
+	pubkey, err := publicKey(seckey)
+	if err != nil {
+		return err
+	}
+	ev.PubKey = hex.EncodeToString(pubkey)
+	if ev.Tags == nil {
+		ev.Tags = [][]string{}
+	}
+	id := ev.hash()
+	aux := make([]byte, 32)
+	if _, err := rand.Read(aux); err != nil {
+		return err
+	}
+	sig, err := schnorrSign(seckey, id, aux)
+	if err != nil {
+		return err
+	}
+	ev.ID = hex.EncodeToString(id)
+	ev.Sig = hex.EncodeToString(sig)
+	return nil
+}
+
+// verify returns true when the ID of ev matches its content and it's signed by its
+// public key, relays could send anything.
+func (ev *event) verify() bool {
+	id := ev.hash()
+	if hex.EncodeToString(id) != ev.ID {
+		return false
+	}
+	pubkey, err := hex.DecodeString(ev.PubKey)
+	if err != nil {
+		return false
+	}
+	sig, err := hex.DecodeString(ev.Sig)
+	if err != nil {
+		return false
+	}
+	return schnorrVerify(pubkey, id, sig)
+}
+
+// tag returns the value of the first tag name with marker, the fourth element of the e
+// tags of NIP-10. With an empty marker the first tag name is returned.
+func (ev *event) tag(name, marker string) string {
+	for _, tag := range ev.Tags {
+		if len(tag) < 2 || tag[0] != name {
+			continue
+		}
+		if marker == "" || (len(tag) > 3 && tag[3] == marker) {
+			return tag[1]
+		}
+	}
+	return ""
+}
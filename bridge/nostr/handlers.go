@@ -0,0 +1,107 @@
+package bnostr
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// handleEvents handles the events of all relays, the same event usually comes from each.
+func (b *Bnostr) handleEvents() {
+	for ev := range b.events {
+		if ok, _ := b.seen.ContainsOrAdd(ev.ID, true); ok {
+			continue
+		}
+		if !ev.verify() {
+			b.Log.Debugf("dropping event %s with an invalid signature", ev.ID)
+			continue
+		}
+		switch ev.Kind {
+		case kindMetadata:
+			b.handleMetadata(ev)
+		case kindChannelMessage:
+			b.handleChannelMessage(ev)
+		}
+	}
+}
+
+func (b *Bnostr) handleMetadata(ev *event) {
+	var p profile
+	if err := json.Unmarshal([]byte(ev.Content), &p); err != nil {
+		b.Log.Debugf("invalid metadata of %s: %s", ev.PubKey, err)
+		return
+	}
+	p.createdAt = ev.CreatedAt
+	b.Lock()
+	defer b.Unlock()
+	if old, ok := b.profiles[ev.PubKey]; ok && old.createdAt > p.createdAt {
+		return
+	}
+	b.profiles[ev.PubKey] = &p
+}
+
+func (b *Bnostr) handleChannelMessage(ev *event) {
+	if ev.PubKey == b.pubkey {
+		return
+	}
+	channelID := ev.tag("e", "root")
+	if channelID == "" {
+		// the deprecated positional e tags of NIP-10, the first is the channel
+		channelID = ev.tag("e", "")
+	}
+	b.Lock()
+	channel, ok := b.channels[channelID]
+	if ok && ev.CreatedAt > b.since {
+		b.since = ev.CreatedAt
+	}
+	b.Unlock()
+	if !ok {
+		return
+	}
+
+	rmsg := config.Message{
+		Account:   b.Account,
+		Channel:   channel,
+		ID:        ev.ID,
+		ParentID:  ev.tag("e", "reply"),
+		Text:      ev.Content,
+		UserID:    ev.PubKey,
+		Timestamp: time.Unix(ev.CreatedAt, 0),
+	}
+	rmsg.Username, rmsg.Avatar = b.author(ev.PubKey)
+	b.Log.Debugf("<= Sending message from %s on %s to gateway", rmsg.Username, b.Account)
+	b.Log.Debugf("<= Message is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
+// author returns the name and picture of the profile of pubkey. Unknown profiles are
+// requested from the relays and the name is the start of the npub until they arrive.
+func (b *Bnostr) author(pubkey string) (string, string) {
+	b.Lock()
+	p, ok := b.profiles[pubkey]
+	requested := b.requested[pubkey]
+	b.requested[pubkey] = true
+	b.Unlock()
+	if ok {
+		switch {
+		case p.DisplayName != "":
+			return p.DisplayName, p.Picture
+		case p.Name != "":
+			return p.Name, p.Picture
+		}
+	}
+	if !requested {
+		f := filter{Authors: []string{pubkey}, Kinds: []int{kindMetadata}, Limit: 1}
+		for _, r := range b.relays {
+			if err := r.subscribe("meta-"+pubkey[:16], f); err != nil {
+				b.Log.Debugf("requesting the profile of %s from %s failed: %s", pubkey, r.url, err)
+			}
+		}
+	}
+	name := npub(pubkey)
+	if len(name) > 16 {
+		name = name[:16]
+	}
+	return name, ""
+}
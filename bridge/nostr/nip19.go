@@ -0,0 +1,141 @@
+package bnostr
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The bech32 keys and IDs of NIP-19 (nsec, npub and note), see
+// https://github.com/nostr-protocol/nips/blob/master/19.md.
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var errInvalidBech32 = errors.New("invalid bech32 string")
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	res := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		res = append(res, hrp[i]>>5)
+	}
+	res = append(res, 0)
+	for i := 0; i < len(hrp); i++ {
+		res = append(res, hrp[i]&31)
+	}
+	return res
+}
+
+// convertBits regroups the bits of data from groups of from bits to groups of to bits.
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var acc, bits uint
+	maxv := uint(1)<<to - 1
+	var res []byte
+	for _, v := range data {
+		if uint(v)>>from != 0 {
+			return nil, errInvalidBech32
+		}
+		acc = acc<<from | uint(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			res = append(res, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			res = append(res, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, errInvalidBech32
+	}
+	return res, nil
+}
+
+// bech32Decode returns the human readable part and the data of s.
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errInvalidBech32
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndexByte(s, '1')
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errInvalidBech32
+	}
+	hrp := s[:pos]
+	values := make([]byte, 0, len(s)-pos-1)
+	for _, c := range s[pos+1:] {
+		v := strings.IndexRune(bech32Charset, c)
+		if v < 0 {
+			return "", nil, errInvalidBech32
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errInvalidBech32
+	}
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
+
+func bech32Encode(hrp string, data []byte) string {
+	values, _ := convertBits(data, 8, 5, true)
+	polymod := bech32Polymod(append(append(bech32HRPExpand(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, v := range values {
+		sb.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(polymod>>uint(5*(5-i)))&31])
+	}
+	return sb.String()
+}
+
+// decodeKey returns the 32 bytes of s, in hex or bech32 with the prefix hrp.
+func decodeKey(s, hrp string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "nostr:")
+	if strings.HasPrefix(s, hrp+"1") {
+		prefix, data, err := bech32Decode(s)
+		if err != nil {
+			return nil, err
+		}
+		if prefix != hrp || len(data) != 32 {
+			return nil, fmt.Errorf("not a %s: %s", hrp, s)
+		}
+		return data, nil
+	}
+	data, err := hex.DecodeString(s)
+	if err != nil || len(data) != 32 {
+		return nil, fmt.Errorf("not a %s or 64 hex characters: %s", hrp, s)
+	}
+	return data, nil
+}
+
+// npub returns the bech32 form of the hex public key pubkey.
+func npub(pubkey string) string {
+	data, err := hex.DecodeString(pubkey)
+	if err != nil {
+		return pubkey
+	}
+	return bech32Encode("npub", data)
+}
@@ -0,0 +1,275 @@
+package bnostr
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// seenCacheSize is the number of event IDs kept to drop the events other relays sent already.
+const seenCacheSize = 1000
+
+// Bnostr bridges the public chat channels of NIP-28 on nostr relays. The channels are
+// the IDs of their kind 40 creation events, the messages are kind 42 events signed with
+// the key of the account, see https://github.com/nostr-protocol/nips/blob/master/28.md.
+type Bnostr struct {
+	*bridge.Config
+
+	seckey []byte
+	pubkey string
+	relays []*relay
+	events chan *event
+	seen   *lru.Cache
+
+	sync.RWMutex
+	// channels maps the IDs of the joined channels to their names in the config
+	channels  map[string]string
+	profiles  map[string]*profile
+	requested map[string]bool // pubkeys whose profile was requested
+	since     int64           // created_at of the newest message, to subscribe again after reconnecting
+}
+
+// profile is the content of the metadata event of a user, see NIP-01.
+type profile struct {
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Picture     string `json:"picture,omitempty"`
+	createdAt   int64
+}
+
+func New(cfg *bridge.Config) bridge.Bridger {
+	seen, _ := lru.New(seenCacheSize)
+	return &Bnostr{
+		Config:    cfg,
+		events:    make(chan *event, 100),
+		seen:      seen,
+		channels:  make(map[string]string),
+		profiles:  make(map[string]*profile),
+		requested: make(map[string]bool),
+	}
+}
+
+func (b *Bnostr) Connect() error {
+	seckey, err := decodeKey(b.GetString("PrivateKey"), "nsec")
+	if err != nil {
+		return err
+	}
+	pubkey, err := publicKey(seckey)
+	if err != nil {
+		return err
+	}
+	urls := b.GetStringSlice("Relays")
+	if len(urls) == 0 {
+		return errors.New("no Relays configured")
+	}
+	b.seckey, b.pubkey = seckey, hex.EncodeToString(pubkey)
+	b.Log.Infof("Connecting to %s as %s", strings.Join(urls, ", "), npub(b.pubkey))
+
+	go b.handleEvents()
+	dialer := b.WebsocketDialer()
+	for _, url := range urls {
+		r := newRelay(url, b.Log, dialer, b.events, b.onConnect)
+		b.relays = append(b.relays, r)
+		go r.run()
+	}
+	return nil
+}
+
+func (b *Bnostr) Disconnect() error {
+	for _, r := range b.relays {
+		r.close()
+	}
+	b.relays = nil
+	return nil
+}
+
+// JoinChannel subscribes to the messages of the channel, the hex or note ID of the event
+// that created it.
+func (b *Bnostr) JoinChannel(channel config.ChannelInfo) error {
+	id, err := decodeKey(channel.Name, "note")
+	if err != nil {
+		return err
+	}
+	b.Lock()
+	b.channels[hex.EncodeToString(id)] = channel.Name
+	if b.since == 0 {
+		b.since = time.Now().Unix()
+	}
+	b.Unlock()
+	for _, r := range b.relays {
+		if err := r.subscribe(subscriptionID, b.filter()); err != nil && !errors.Is(err, errNotConnected) {
+			b.Log.Errorf("subscribing to %s on %s failed: %s", channel.Name, r.url, err)
+		}
+	}
+	return nil
+}
+
+func (b *Bnostr) Send(msg config.Message) (string, error) {
+	b.Log.Debugf("=> Receiving %#v", msg)
+
+	switch msg.Event {
+	case config.EventMsgDelete:
+		if msg.ID == "" {
+			return "", nil
+		}
+		return "", b.publish(&event{Kind: kindDeletion, Tags: [][]string{{"e", msg.ID}}})
+	case "", config.EventUserAction:
+	default:
+		return "", nil
+	}
+
+	channelID := b.channelID(msg.Channel)
+	if channelID == "" {
+		return "", errors.New("unknown channel " + msg.Channel)
+	}
+
+	if msg.Extra != nil {
+		for _, rmsg := range helper.HandleExtra(&msg, b.General) {
+			if _, err := b.sendMessage(channelID, rmsg.Username+rmsg.Text, ""); err != nil {
+				b.Log.Errorf("Could not send extra message: %s", err)
+			}
+		}
+		if len(msg.Extra["file"]) > 0 {
+			return b.handleUploadFile(&msg, channelID)
+		}
+	}
+
+	// events can't be edited, the new text is sent as a reply to the message
+	if msg.ID != "" {
+		_, err := b.sendMessage(channelID, msg.Username+msg.Text, msg.ID)
+		return msg.ID, err
+	}
+	parentID := ""
+	if msg.ParentValid() {
+		parentID = msg.ParentID
+	}
+	return b.sendMessage(channelID, msg.Username+msg.Text, parentID)
+}
+
+// sendMessage sends text to the channel channelID, as a reply to parentID when it's set,
+// and returns the ID of the event.
+func (b *Bnostr) sendMessage(channelID, text, parentID string) (string, error) {
+	ev := &event{
+		Kind:    kindChannelMessage,
+		Content: text,
+		Tags:    [][]string{{"e", channelID, b.relayURL(), "root"}},
+	}
+	if parentID != "" {
+		ev.Tags = append(ev.Tags, []string{"e", parentID, b.relayURL(), "reply"})
+	}
+	if err := b.publish(ev); err != nil {
+		return "", err
+	}
+	return ev.ID, nil
+}
+
+// handleUploadFile sends the links of the files of msg, nostr events only have text. Files
+// without a link, when no mediaserver is configured, can't be sent.
+func (b *Bnostr) handleUploadFile(msg *config.Message, channelID string) (string, error) {
+	var msgID string
+	for _, f := range msg.Extra["file"] {
+		fi := f.(config.FileInfo)
+		if fi.URL == "" {
+			b.Log.Debugf("Not sending file %s without a link, configure a mediaserver", fi.Name)
+			continue
+		}
+		id, err := b.sendMessage(channelID, msg.Username+strings.TrimSpace(fi.Comment+" "+fi.URL), "")
+		if err != nil {
+			return msgID, err
+		}
+		msgID = id
+	}
+	return msgID, nil
+}
+
+// publish signs ev and sends it to all relays, it's sent when one of them took it.
+func (b *Bnostr) publish(ev *event) error {
+	ev.CreatedAt = time.Now().Unix()
+	if err := ev.sign(b.seckey); err != nil {
+		return err
+	}
+	b.seen.Add(ev.ID, true)
+	var lastErr error
+	sent := false
+	for _, r := range b.relays {
+		if err := r.publish(ev); err != nil {
+			b.Log.Debugf("publishing %s to %s failed: %s", ev.ID, r.url, err)
+			lastErr = err
+			continue
+		}
+		sent = true
+	}
+	if !sent {
+		if lastErr == nil {
+			lastErr = errNotConnected
+		}
+		return lastErr
+	}
+	return nil
+}
+
+// onConnect subscribes to the joined channels on r and publishes the profile of the bot.
+func (b *Bnostr) onConnect(r *relay) error {
+	if nick := b.GetString("Nick"); nick != "" {
+		content, err := json.Marshal(profile{Name: nick})
+		if err != nil {
+			return err
+		}
+		ev := &event{Kind: kindMetadata, Content: string(content), CreatedAt: time.Now().Unix()}
+		if err := ev.sign(b.seckey); err != nil {
+			return err
+		}
+		b.seen.Add(ev.ID, true)
+		if err := r.publish(ev); err != nil {
+			return err
+		}
+	}
+	b.RLock()
+	joined := len(b.channels) > 0
+	b.RUnlock()
+	if !joined {
+		return nil
+	}
+	return r.subscribe(subscriptionID, b.filter())
+}
+
+// filter returns the filter of the messages of the joined channels, since the newest
+// message received.
+func (b *Bnostr) filter() filter {
+	b.RLock()
+	defer b.RUnlock()
+	f := filter{Kinds: []int{kindChannelMessage}, Since: b.since}
+	for id := range b.channels {
+		f.E = append(f.E, id)
+	}
+	return f
+}
+
+// relayURL returns the relay the events of the bridge are sent to first, for the
+// recommended relay of the e tags.
+func (b *Bnostr) relayURL() string {
+	if len(b.relays) == 0 {
+		return ""
+	}
+	return b.relays[0].url
+}
+
+// channelID returns the ID of the channel joined as channel.
+func (b *Bnostr) channelID(channel string) string {
+	b.RLock()
+	defer b.RUnlock()
+	for id, name := range b.channels {
+		if name == channel {
+			return id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,156 @@
+package bnostr
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	data, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return data
+}
+
+// The first test vectors of BIP-340.
+func TestSchnorr(t *testing.T) {
+	for _, tc := range []struct {
+		seckey, pubkey, aux, msg, sig string
+	}{
+		{
+			seckey: "0000000000000000000000000000000000000000000000000000000000000003",
+			pubkey: "F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+			aux:    "0000000000000000000000000000000000000000000000000000000000000000",
+			msg:    "0000000000000000000000000000000000000000000000000000000000000000",
+			sig:    "E907831F80848D1069A5371B402410364BDF1C5F8307B0084C55F1CE2DCA821525F66A4A85EA8B71E482A74F382D2CE5EBEEE8FDB2172F477DF4900D310536C0",
+		},
+		{
+			seckey: "B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF",
+			pubkey: "DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+			aux:    "0000000000000000000000000000000000000000000000000000000000000001",
+			msg:    "243F6A8885A308D313198A2E03707344A4093822299F31D0082EFA98EC4E6C89",
+			sig:    "6896BD60EEAE296DB48A229FF71DFE071BDE413E6D43F917DC8DCF8C78DE33418906D11AC976ABCCB20B091292BFF4EA897EFCB639EA871CFA95F6DE339E4B0A",
+		},
+	} {
+		pubkey, err := publicKey(mustHex(t, tc.seckey))
+		require.NoError(t, err)
+		assert.Equal(t, mustHex(t, tc.pubkey), pubkey)
+		sig, err := schnorrSign(mustHex(t, tc.seckey), mustHex(t, tc.msg), mustHex(t, tc.aux))
+		require.NoError(t, err)
+		assert.Equal(t, mustHex(t, tc.sig), sig)
+		assert.True(t, schnorrVerify(pubkey, mustHex(t, tc.msg), sig))
+		sig[63] ^= 1
+		assert.False(t, schnorrVerify(pubkey, mustHex(t, tc.msg), sig))
+	}
+	_, err := publicKey(make([]byte, 32))
+	assert.Equal(t, errInvalidKey, err)
+}
+
+func TestDecodeKey(t *testing.T) {
+	key := mustHex(t, "7e7e9c42a91bfef19fa929e5fda1b72e0ebc1a4c1141673e2794234d86addf4e")
+	encoded := bech32Encode("npub", key)
+	assert.Equal(t, "npub10elfcs4fr0l0r8af98jlmgdh9c8tcxjvz9qkw038js35mp4dma8qzvjptg", encoded)
+
+	for _, s := range []string{encoded, "nostr:" + encoded, hex.EncodeToString(key)} {
+		data, err := decodeKey(s, "npub")
+		require.NoError(t, err, s)
+		assert.Equal(t, key, data)
+	}
+	_, err := decodeKey(strings.Replace(encoded, "q", "p", 1), "npub")
+	assert.Error(t, err)
+	_, err = decodeKey(encoded, "nsec")
+	assert.Error(t, err)
+	_, err = decodeKey("abcd", "nsec")
+	assert.Error(t, err)
+}
+
+func TestEvent(t *testing.T) {
+	seckey := mustHex(t, "0000000000000000000000000000000000000000000000000000000000000003")
+	ev := &event{CreatedAt: 1700000000, Kind: kindChannelMessage, Content: "a \"quote\"\n<tag> & é",
+		Tags: [][]string{{"e", "abc", "", "root"}}}
+	require.NoError(t, ev.sign(seckey))
+	assert.Equal(t, "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9", ev.PubKey)
+	assert.Equal(t, `[0,"f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9",1700000000,42,[["e","abc","","root"]],"a \"quote\"\n<tag> & é"]`,
+		string(ev.serialize()))
+	assert.True(t, ev.verify())
+	assert.Equal(t, "abc", ev.tag("e", "root"))
+	assert.Equal(t, "", ev.tag("e", "reply"))
+
+	ev.Content = "changed"
+	assert.False(t, ev.verify())
+}
+
+func newTestNostr(t *testing.T) *Bnostr {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	br := bridge.New(&config.Bridge{Account: "nostr.mybot"})
+	br.Log = logrus.NewEntry(logger)
+	b, ok := New(&bridge.Config{Bridge: br, Remote: make(chan config.Message, 10)}).(*Bnostr)
+	require.True(t, ok)
+	b.seckey = mustHex(t, "B7E151628AED2A6ABF7158809CF4F3C762E7160F38B4DA56A784D9045190CFEF")
+	b.pubkey = "dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659"
+	go b.handleEvents()
+	t.Cleanup(func() { close(b.events) })
+	return b
+}
+
+func TestReceive(t *testing.T) {
+	b := newTestNostr(t)
+	channelID := strings.Repeat("ab", 32)
+	require.NoError(t, b.JoinChannel(config.ChannelInfo{Name: channelID}))
+
+	alice := mustHex(t, "0000000000000000000000000000000000000000000000000000000000000003")
+	meta := &event{CreatedAt: 1700000000, Kind: kindMetadata, Content: `{"name":"alice","picture":"https://example.com/a.png"}`}
+	require.NoError(t, meta.sign(alice))
+	msg := &event{CreatedAt: 1700000001, Kind: kindChannelMessage, Content: "hi there",
+		Tags: [][]string{{"e", channelID, "", "root"}, {"e", "parent", "", "reply"}}}
+	require.NoError(t, msg.sign(alice))
+	b.events <- meta
+	b.events <- msg
+	// relays send the same events, they're relayed once
+	b.events <- msg
+
+	select {
+	case rmsg := <-b.Remote:
+		assert.Equal(t, channelID, rmsg.Channel)
+		assert.Equal(t, "alice", rmsg.Username)
+		assert.Equal(t, "https://example.com/a.png", rmsg.Avatar)
+		assert.Equal(t, msg.PubKey, rmsg.UserID)
+		assert.Equal(t, msg.ID, rmsg.ID)
+		assert.Equal(t, "parent", rmsg.ParentID)
+		assert.Equal(t, "hi there", rmsg.Text)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	// forged events, messages of other channels and of the bot are skipped
+	forged := *msg
+	forged.ID, forged.Content = "forged", "forged"
+	b.events <- &forged
+	other := &event{CreatedAt: 1700000002, Kind: kindChannelMessage, Content: "elsewhere", Tags: [][]string{{"e", "other", "", "root"}}}
+	require.NoError(t, other.sign(alice))
+	b.events <- other
+	own := &event{CreatedAt: 1700000003, Kind: kindChannelMessage, Content: "echo", Tags: [][]string{{"e", channelID, "", "root"}}}
+	require.NoError(t, own.sign(b.seckey))
+	b.events <- own
+
+	unknown := &event{CreatedAt: 1700000004, Kind: kindChannelMessage, Content: "who am i", Tags: [][]string{{"e", channelID}}}
+	require.NoError(t, unknown.sign(mustHex(t, strings.Repeat("11", 32))))
+	b.events <- unknown
+	select {
+	case rmsg := <-b.Remote:
+		assert.Equal(t, "who am i", rmsg.Text)
+		assert.Equal(t, npub(unknown.PubKey)[:16], rmsg.Username)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	assert.Equal(t, []string{channelID}, b.filter().E)
+}
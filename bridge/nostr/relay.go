@@ -0,0 +1,184 @@
+package bnostr
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	relayMinDelay = 2 * time.Second
+	relayMaxDelay = 5 * time.Minute
+	// subscriptionID is the ID of the subscription to the messages of the joined channels.
+	subscriptionID = "matterbridge"
+)
+
+var errNotConnected = errors.New("not connected to the relay")
+
+// filter is a filter of a subscription, see NIP-01.
+type filter struct {
+	IDs     []string `json:"ids,omitempty"`
+	Authors []string `json:"authors,omitempty"`
+	Kinds   []int    `json:"kinds,omitempty"`
+	E       []string `json:"#e,omitempty"`
+	Since   int64    `json:"since,omitempty"`
+	Limit   int      `json:"limit,omitempty"`
+}
+
+// relay is the connection to a nostr relay. It reconnects until close is called and sends
+// the events of the subscriptions to events.
+type relay struct {
+	url    string
+	log    *logrus.Entry
+	dialer *websocket.Dialer
+	events chan<- *event
+	// onConnect is called after every connect, to subscribe again
+	onConnect func(r *relay) error
+
+	mu   sync.Mutex
+	conn *websocket.Conn
+	stop chan struct{}
+}
+
+func newRelay(url string, log *logrus.Entry, dialer *websocket.Dialer, events chan<- *event, onConnect func(r *relay) error) *relay {
+	return &relay{
+		url:       url,
+		log:       log.WithField("relay", url),
+		dialer:    dialer,
+		events:    events,
+		onConnect: onConnect,
+		stop:      make(chan struct{}),
+	}
+}
+
+func (r *relay) run() {
+	delay := relayMinDelay
+	for {
+		connected, err := r.connect()
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+		if connected {
+			delay = relayMinDelay
+		}
+		r.log.Errorf("relay connection failed, reconnecting in %s: %s", delay, err)
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > relayMaxDelay {
+			delay = relayMaxDelay
+		}
+	}
+}
+
+// connect connects to the relay, subscribes and reads its messages until the connection
+// fails. Returns true when the connection was made.
+func (r *relay) connect() (bool, error) {
+	conn, _, err := r.dialer.Dial(r.url, nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	r.mu.Lock()
+	r.conn = conn
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.conn = nil
+		r.mu.Unlock()
+	}()
+	r.log.Info("Connected to relay")
+	if err := r.onConnect(r); err != nil {
+		return true, err
+	}
+	for {
+		var msg []json.RawMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return true, err
+		}
+		r.handle(msg)
+	}
+}
+
+// handle handles a message of the relay, a JSON array with its type first.
+func (r *relay) handle(msg []json.RawMessage) {
+	if len(msg) < 2 {
+		return
+	}
+	var typ string
+	if err := json.Unmarshal(msg[0], &typ); err != nil {
+		return
+	}
+	switch typ {
+	case "EVENT":
+		if len(msg) < 3 {
+			return
+		}
+		var ev event
+		if err := json.Unmarshal(msg[2], &ev); err != nil {
+			r.log.Debugf("invalid event: %s", err)
+			return
+		}
+		r.events <- &ev
+	case "OK":
+		var id, reason string
+		var ok bool
+		if len(msg) < 3 || json.Unmarshal(msg[1], &id) != nil || json.Unmarshal(msg[2], &ok) != nil {
+			return
+		}
+		if len(msg) > 3 {
+			_ = json.Unmarshal(msg[3], &reason)
+		}
+		if !ok {
+			r.log.Errorf("relay rejected event %s: %s", id, reason)
+		}
+	case "EOSE":
+		// the other subscriptions are one-off requests for the stored events
+		var id string
+		if json.Unmarshal(msg[1], &id) == nil && id != subscriptionID {
+			_ = r.write([]interface{}{"CLOSE", id})
+		}
+	case "NOTICE", "CLOSED":
+		r.log.Infof("relay: %s", msg[len(msg)-1])
+	}
+}
+
+// subscribe opens the subscription id with filters, or replaces it.
+func (r *relay) subscribe(id string, filters ...filter) error {
+	req := []interface{}{"REQ", id}
+	for _, f := range filters {
+		req = append(req, f)
+	}
+	return r.write(req)
+}
+
+// publish sends ev to the relay.
+func (r *relay) publish(ev *event) error {
+	return r.write([]interface{}{"EVENT", ev})
+}
+
+func (r *relay) write(v interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return errNotConnected
+	}
+	return r.conn.WriteJSON(v)
+}
+
+func (r *relay) close() {
+	close(r.stop)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn != nil {
+		r.conn.Close()
+	}
+}
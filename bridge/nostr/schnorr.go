@@ -0,0 +1,179 @@
+package bnostr
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// Schnorr signatures on secp256k1 as nostr signs its events, see
+// https://github.com/bitcoin/bips/blob/master/bip-0340.mediawiki. The arithmetic uses
+// math/big, it isn't constant time: keep the key of the bridge on a host others can't
+// time it on.
+
+var (
+	curveP, _  = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC2F", 16)
+	curveN, _  = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141", 16)
+	curveGx, _ = new(big.Int).SetString("79BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798", 16)
+	curveGy, _ = new(big.Int).SetString("483ADA7726A3C4655DA4FBFC0E1108A8FD17B448A68554199C47D08FFB10D4B8", 16)
+
+	curveG = &point{x: curveGx, y: curveGy}
+	// sqrtExp is (p+1)/4, the exponent of the square roots mod p
+	sqrtExp = new(big.Int).Rsh(new(big.Int).Add(curveP, big.NewInt(1)), 2)
+)
+
+var (
+	errInvalidKey       = errors.New("invalid secp256k1 key")
+	errInvalidSignature = errors.New("invalid signature")
+)
+
+// point is a point of the curve in affine coordinates, nil is the point at infinity.
+type point struct {
+	x, y *big.Int
+}
+
+func (p *point) evenY() bool {
+	return p.y.Bit(0) == 0
+}
+
+func mod(x *big.Int) *big.Int {
+	return x.Mod(x, curveP)
+}
+
+func addPoints(a, b *point) *point {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	var lambda *big.Int
+	if a.x.Cmp(b.x) == 0 {
+		if a.y.Cmp(b.y) != 0 || a.y.Sign() == 0 {
+			return nil
+		}
+		// 3x² / 2y
+		num := mod(new(big.Int).Mul(big.NewInt(3), new(big.Int).Mul(a.x, a.x)))
+		den := new(big.Int).ModInverse(mod(new(big.Int).Lsh(a.y, 1)), curveP)
+		lambda = mod(num.Mul(num, den))
+	} else {
+		num := mod(new(big.Int).Sub(b.y, a.y))
+		den := new(big.Int).ModInverse(mod(new(big.Int).Sub(b.x, a.x)), curveP)
+		lambda = mod(num.Mul(num, den))
+	}
+	x := mod(new(big.Int).Sub(new(big.Int).Sub(new(big.Int).Mul(lambda, lambda), a.x), b.x))
+	y := mod(new(big.Int).Sub(new(big.Int).Mul(lambda, new(big.Int).Sub(a.x, x)), a.y))
+	return &point{x: x, y: y}
+}
+
+func mulPoint(k *big.Int, p *point) *point {
+	var r *point
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		r = addPoints(r, r)
+		if k.Bit(i) == 1 {
+			r = addPoints(r, p)
+		}
+	}
+	return r
+}
+
+// liftX returns the point with the x coordinate x and an even y, see lift_x of BIP-340.
+func liftX(x *big.Int) (*point, error) {
+	if x.Cmp(curveP) >= 0 {
+		return nil, errInvalidKey
+	}
+	c := mod(new(big.Int).Add(new(big.Int).Exp(x, big.NewInt(3), curveP), big.NewInt(7)))
+	y := new(big.Int).Exp(c, sqrtExp, curveP)
+	if mod(new(big.Int).Mul(y, y)).Cmp(c) != 0 {
+		return nil, errInvalidKey
+	}
+	if y.Bit(0) == 1 {
+		y.Sub(curveP, y)
+	}
+	return &point{x: new(big.Int).Set(x), y: y}, nil
+}
+
+// bytes32 returns x as 32 bytes big endian.
+func bytes32(x *big.Int) []byte {
+	b := make([]byte, 32)
+	return x.FillBytes(b)
+}
+
+func taggedHash(tag string, data ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// publicKey returns the x-only public key of the secret key seckey.
+func publicKey(seckey []byte) ([]byte, error) {
+	d := new(big.Int).SetBytes(seckey)
+	if len(seckey) != 32 || d.Sign() == 0 || d.Cmp(curveN) >= 0 {
+		return nil, errInvalidKey
+	}
+	return bytes32(mulPoint(d, curveG).x), nil
+}
+
+// schnorrSign signs the 32 bytes msg with seckey, aux is 32 bytes of fresh randomness.
+func schnorrSign(seckey, msg, aux []byte) ([]byte, error) {
+	d := new(big.Int).SetBytes(seckey)
+	if len(seckey) != 32 || d.Sign() == 0 || d.Cmp(curveN) >= 0 {
+		return nil, errInvalidKey
+	}
+	p := mulPoint(d, curveG)
+	if !p.evenY() {
+		d.Sub(curveN, d)
+	}
+	px := bytes32(p.x)
+
+	t := bytes32(d)
+	for i, b := range taggedHash("BIP0340/aux", aux) {
+		t[i] ^= b
+	}
+	k := new(big.Int).SetBytes(taggedHash("BIP0340/nonce", t, px, msg))
+	k.Mod(k, curveN)
+	if k.Sign() == 0 {
+		return nil, errInvalidSignature
+	}
+	r := mulPoint(k, curveG)
+	if !r.evenY() {
+		k.Sub(curveN, k)
+	}
+	rx := bytes32(r.x)
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", rx, px, msg))
+	e.Mod(e, curveN)
+
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, curveN)
+	return append(rx, bytes32(s)...), nil
+}
+
+// schnorrVerify returns true if sig is the signature of the 32 bytes msg by the x-only
+// public key pubkey.
+func schnorrVerify(pubkey, msg, sig []byte) bool {
+	if len(pubkey) != 32 || len(sig) != 64 {
+		return false
+	}
+	p, err := liftX(new(big.Int).SetBytes(pubkey))
+	if err != nil {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if r.Cmp(curveP) >= 0 || s.Cmp(curveN) >= 0 {
+		return false
+	}
+	e := new(big.Int).SetBytes(taggedHash("BIP0340/challenge", sig[:32], pubkey, msg))
+	e.Mod(e, curveN)
+
+	// R = sG - eP
+	negE := new(big.Int).Sub(curveN, e)
+	rp := addPoints(mulPoint(s, curveG), mulPoint(negE, p))
+	return rp != nil && rp.evenY() && rp.x.Cmp(r) == 0
+}
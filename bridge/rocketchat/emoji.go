@@ -0,0 +1,63 @@
+package brocketchat
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/matterbridge/Rocket.Chat.Go.SDK/rest"
+)
+
+var emojiShortcodeRE = regexp.MustCompile(`:([\w+-]+):`)
+
+// customEmojiResponse is the response of emoji-custom.list.
+type customEmojiResponse struct {
+	rest.Status
+	Emojis struct {
+		Update []struct {
+			Name    string   `json:"name"`
+			Aliases []string `json:"aliases"`
+		} `json:"update"`
+	} `json:"emojis"`
+}
+
+// loadCustomEmoji maps the names and aliases of the custom emoji of the server and the
+// shortcodes of EmojiMap to the custom emoji they're shown as.
+func (b *Brocketchat) loadCustomEmoji() {
+	emoji := make(map[string]string)
+	var resp customEmojiResponse
+	if b.r != nil {
+		if err := b.r.Get("emoji-custom.list", nil, &resp); err != nil {
+			b.Log.Errorf("failed to get the custom emoji: %s", err)
+		}
+	}
+	for _, e := range resp.Emojis.Update {
+		emoji[e.Name] = e.Name
+		for _, alias := range e.Aliases {
+			emoji[alias] = e.Name
+		}
+	}
+	for _, m := range b.GetStringSlice2D("EmojiMap") {
+		if len(m) != 2 {
+			b.Log.Errorf("invalid EmojiMap entry %v, it must be [\"shortcode\", \"emoji\"]", m)
+			continue
+		}
+		emoji[strings.Trim(m[0], ":")] = strings.Trim(m[1], ":")
+	}
+	b.Lock()
+	b.customEmoji = emoji
+	b.Unlock()
+}
+
+// replaceEmoji replaces the :shortcode: emoji in text that are in emoji by the emoji they
+// map to, other shortcodes are left alone.
+func replaceEmoji(text string, emoji map[string]string) string {
+	if len(emoji) == 0 {
+		return text
+	}
+	return emojiShortcodeRE.ReplaceAllStringFunc(text, func(m string) string {
+		if name, ok := emoji[strings.Trim(m, ":")]; ok {
+			return ":" + name + ":"
+		}
+		return m
+	})
+}
@@ -0,0 +1,14 @@
+package brocketchat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceEmoji(t *testing.T) {
+	emoji := map[string]string{"partyparrot": "parrot", "parrot": "parrot", "lgtm": "shipit"}
+	assert.Equal(t, "party :parrot: :parrot: time", replaceEmoji("party :partyparrot: :parrot: time", emoji))
+	assert.Equal(t, ":shipit: :smile: 10:30:00", replaceEmoji(":lgtm: :smile: 10:30:00", emoji))
+	assert.Equal(t, ":lgtm:", replaceEmoji(":lgtm:", nil))
+}
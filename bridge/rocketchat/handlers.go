@@ -2,6 +2,8 @@ package brocketchat
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
@@ -48,8 +50,11 @@ func (b *Brocketchat) handleStatusEvent(ev models.Message, rmsg *config.Message)
 		// this is a normal message, no processing needed
 		// return true so the message is not dropped
 		return true
-	case sUserJoined, sUserLeft:
-		rmsg.Event = config.EventJoinLeave
+	case sUserJoined:
+		rmsg.Event = config.EventJoin
+		return true
+	case sUserLeft:
+		rmsg.Event = config.EventPart
 		return true
 	case sRoomChangedTopic:
 		rmsg.Event = config.EventTopicChange
@@ -63,10 +68,12 @@ func (b *Brocketchat) handleRocketClient(messages chan *config.Message) {
 	for message := range b.messageChan {
 		message := message
 		// skip messages with same ID, apparently messages get duplicated for an unknown reason
-		if _, ok := b.cache.Get(message.ID); ok {
+		if b.cache.Contains(message.ID) {
 			continue
 		}
-		b.cache.Add(message.ID, true)
+		if err := b.cache.SetStringTTL(message.ID, "", dedupWindow); err != nil {
+			b.Log.Errorf("dedup cache failed: %s", err)
+		}
 		b.Log.Debugf("message %#v", message)
 		m := message
 		if b.skipMessage(&m) {
@@ -117,20 +124,37 @@ func (b *Brocketchat) handleAttachments(message *models.Message, rmsg *config.Me
 
 func (b *Brocketchat) handleDownloadFile(rmsg *config.Message, file *models.Attachment) error {
 	downloadURL := b.GetString("server") + file.TitleLink
-	data, err := helper.DownloadFileAuthRocket(downloadURL, b.user.Token, b.user.ID)
+	media, err := b.DownloadMedia(downloadURL, http.Header{"X-Auth-Token": {b.user.Token}, "X-User-Id": {b.user.ID}})
 	if err != nil {
 		return fmt.Errorf("download %s failed %#v", downloadURL, err)
 	}
-	helper.HandleDownloadData(b.Log, rmsg, file.Title, rmsg.Text, downloadURL, data, b.General)
+	helper.HandleDownloadMedia(b.Log, rmsg, file.Title, "", rmsg.Text, downloadURL, media)
 	return nil
 }
 
-func (b *Brocketchat) handleUploadFile(msg *config.Message) error {
+// handleUploadFile uploads the files of msg and returns the message ID of the last upload.
+// Files that weren't downloaded are posted as a link.
+func (b *Brocketchat) handleUploadFile(msg *config.Message) (string, error) {
+	var msgID string
 	for _, f := range msg.Extra["file"] {
 		fi := f.(config.FileInfo)
-		if err := b.uploadFile(&fi, b.getChannelID(msg.Channel)); err != nil {
-			return err
+		text := msg.Username + fi.Comment
+		if fi.Data == nil && fi.Media == nil {
+			if fi.URL == "" {
+				continue
+			}
+			id, err := b.postMessage(&config.Message{Username: msg.Username, Avatar: msg.Avatar, Text: strings.TrimSpace(fi.Comment + " " + fi.URL)}, b.getChannelID(msg.Channel))
+			if err != nil {
+				return msgID, err
+			}
+			msgID = id
+			continue
+		}
+		id, err := b.uploadFile(&fi, b.getChannelID(msg.Channel), text)
+		if err != nil {
+			return msgID, err
 		}
+		msgID = id
 	}
-	return nil
+	return msgID, nil
 }
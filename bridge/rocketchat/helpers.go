@@ -2,16 +2,18 @@ package brocketchat
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"mime"
-	"net/http"
 	"net/url"
-	"strings"
+	"path/filepath"
 	"time"
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
 	"github.com/42wim/matterbridge/hook/rockethook"
+	"github.com/42wim/matterbridge/hook/signature"
 	"github.com/42wim/matterbridge/matterhook"
 	"github.com/matterbridge/Rocket.Chat.Go.SDK/models"
 	"github.com/matterbridge/Rocket.Chat.Go.SDK/realtime"
@@ -19,6 +21,9 @@ import (
 	"github.com/nelsonken/gomf"
 )
 
+// uploadTimeout is the timeout of a file upload.
+const uploadTimeout = time.Minute
+
 func (b *Brocketchat) doConnectWebhookBind() error {
 	switch {
 	case b.GetString("WebhookURL") != "":
@@ -26,7 +31,10 @@ func (b *Brocketchat) doConnectWebhookBind() error {
 		b.mh = matterhook.New(b.GetString("WebhookURL"),
 			matterhook.Config{InsecureSkipVerify: b.GetBool("SkipTLSVerify"),
 				DisableServer: true})
-		b.rh = rockethook.New(b.GetString("WebhookURL"), rockethook.Config{BindAddress: b.GetString("WebhookBindAddress")})
+		b.rh = rockethook.New(b.GetString("WebhookURL"), rockethook.Config{
+			BindAddress: b.GetString("WebhookBindAddress"),
+			Verifier:    b.WebhookVerifier(signature.ModeToken),
+		})
 	case b.GetString("Login") != "":
 		b.Log.Info("Connecting using login/password (sending)")
 		err := b.apiLogin()
@@ -35,7 +43,10 @@ func (b *Brocketchat) doConnectWebhookBind() error {
 		}
 	default:
 		b.Log.Info("Connecting using webhookbindaddress (receiving)")
-		b.rh = rockethook.New(b.GetString("WebhookURL"), rockethook.Config{BindAddress: b.GetString("WebhookBindAddress")})
+		b.rh = rockethook.New(b.GetString("WebhookURL"), rockethook.Config{
+			BindAddress: b.GetString("WebhookBindAddress"),
+			Verifier:    b.WebhookVerifier(signature.ModeToken),
+		})
 	}
 	return nil
 }
@@ -109,41 +120,54 @@ func (b *Brocketchat) skipMessage(message *models.Message) bool {
 	return message.User.ID == b.user.ID
 }
 
-func (b *Brocketchat) uploadFile(fi *config.FileInfo, channel string) error {
+// uploadResponse is the response of rooms.upload.
+type uploadResponse struct {
+	Message models.Message `json:"message"`
+	Success bool           `json:"success"`
+	Error   string         `json:"error"`
+}
+
+// uploadFile uploads fi to channel with rooms.upload with the text of the message as its
+// description and returns the ID of the message of the upload.
+func (b *Brocketchat) uploadFile(fi *config.FileInfo, channel string, text string) (string, error) {
+	data, err := fi.Bytes()
+	if err != nil {
+		return "", err
+	}
 	fb := gomf.New()
-	if err := fb.WriteField("description", fi.Comment); err != nil {
-		return err
+	if err := fb.WriteField("description", text); err != nil {
+		return "", err
 	}
-	sp := strings.Split(fi.Name, ".")
-	mtype := mime.TypeByExtension("." + sp[len(sp)-1])
-	if !strings.Contains(mtype, "image") && !strings.Contains(mtype, "video") {
-		return nil
+	mtype := fi.MimeType()
+	if mtype == "" {
+		mtype = mime.TypeByExtension(filepath.Ext(fi.Name))
 	}
-	if err := fb.WriteFile("file", fi.Name, mtype, *fi.Data); err != nil {
-		return err
+	if mtype == "" {
+		mtype = "application/octet-stream"
+	}
+	if err := fb.WriteFile("file", fi.Name, mtype, data); err != nil {
+		return "", err
 	}
 	req, err := fb.GetHTTPRequest(context.TODO(), b.GetString("server")+"/api/v1/rooms.upload/"+channel)
 	if err != nil {
-		return err
+		return "", err
 	}
 	req.Header.Add("X-Auth-Token", b.user.Token)
 	req.Header.Add("X-User-Id", b.user.ID)
-	client := &http.Client{
-		Timeout: time.Second * 5,
-	}
-	resp, err := client.Do(req)
+	resp, err := b.HTTPClient(uploadTimeout).Do(req)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return "", err
 	}
-	if resp.StatusCode != 200 {
-		b.Log.Errorf("failed: %#v", string(body))
+	var res uploadResponse
+	if err := json.Unmarshal(body, &res); err != nil || !res.Success {
+		return "", fmt.Errorf("upload of %s failed: %s %s", fi.Name, resp.Status, res.Error)
 	}
-	return nil
+	return res.Message.ID, nil
 }
 
 // sendWebhook uses the configured WebhookURL to send the message
@@ -4,13 +4,14 @@ import (
 	"errors"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/store"
 	"github.com/42wim/matterbridge/hook/rockethook"
 	"github.com/42wim/matterbridge/matterhook"
-	lru "github.com/hashicorp/golang-lru"
 	"github.com/matterbridge/Rocket.Chat.Go.SDK/models"
 	"github.com/matterbridge/Rocket.Chat.Go.SDK/realtime"
 	"github.com/matterbridge/Rocket.Chat.Go.SDK/rest"
@@ -21,10 +22,11 @@ type Brocketchat struct {
 	rh    *rockethook.Client
 	c     *realtime.Client
 	r     *rest.Client
-	cache *lru.Cache
+	cache *store.Bucket
 	*bridge.Config
 	messageChan chan models.Message
 	channelMap  map[string]string
+	customEmoji map[string]string
 	user        *models.User
 	sync.RWMutex
 }
@@ -35,16 +37,15 @@ const (
 	sRoomChangedTopic = "room_changed_topic"
 )
 
+// dedupWindow is how long we remember message IDs to skip duplicated messages.
+const dedupWindow = 10 * time.Minute
+
 func New(cfg *bridge.Config) bridge.Bridger {
-	newCache, err := lru.New(100)
-	if err != nil {
-		cfg.Log.Fatalf("Could not create LRU cache for rocketchat bridge: %v", err)
-	}
 	b := &Brocketchat{
 		Config:      cfg,
 		messageChan: make(chan models.Message),
 		channelMap:  make(map[string]string),
-		cache:       newCache,
+		cache:       cfg.NewBucket("dedup"),
 	}
 	b.Log.Debugf("enabling rocketchat")
 	return b
@@ -59,6 +60,7 @@ func (b *Brocketchat) Connect() error {
 		if err := b.doConnectWebhookBind(); err != nil {
 			return err
 		}
+		b.loadCustomEmoji()
 		go b.handleRocket()
 		return nil
 	}
@@ -67,6 +69,7 @@ func (b *Brocketchat) Connect() error {
 		if err := b.doConnectWebhookURL(); err != nil {
 			return err
 		}
+		b.loadCustomEmoji()
 		go b.handleRocket()
 		return nil
 	case b.GetString("Login") != "":
@@ -75,6 +78,7 @@ func (b *Brocketchat) Connect() error {
 		if err != nil {
 			return err
 		}
+		b.loadCustomEmoji()
 		go b.handleRocket()
 	}
 	if b.GetString("WebhookBindAddress") == "" && b.GetString("WebhookURL") == "" &&
@@ -127,6 +131,8 @@ func (b *Brocketchat) Send(msg config.Message) (string, error) {
 		return msg.ID, b.c.DeleteMessage(&models.Message{ID: msg.ID})
 	}
 
+	msg.Text = b.replaceEmoji(msg.Text)
+
 	// Use webhook to send the message
 	if b.GetString("WebhookURL") != "" {
 		return "", b.sendWebhook(&msg)
@@ -147,35 +153,37 @@ func (b *Brocketchat) Send(msg config.Message) (string, error) {
 		for _, rmsg := range helper.HandleExtra(&msg, b.General) {
 			// strip the # if people has set this
 			rmsg.Channel = strings.TrimPrefix(rmsg.Channel, "#")
-			smsg := &models.Message{
-				RoomID: b.getChannelID(rmsg.Channel),
-				Msg:    rmsg.Username + rmsg.Text,
-				PostMessage: models.PostMessage{
-					Avatar: rmsg.Avatar,
-					Alias:  rmsg.Username,
-				},
-			}
-			if _, err := b.c.SendMessage(smsg); err != nil {
-				b.Log.Errorf("SendMessage failed: %s", err)
+			rmsg.Text = rmsg.Username + rmsg.Text
+			if _, err := b.postMessage(&rmsg, b.getChannelID(rmsg.Channel)); err != nil {
+				b.Log.Errorf("postMessage failed: %s", err)
 			}
 		}
 		if len(msg.Extra["file"]) > 0 {
-			return "", b.handleUploadFile(&msg)
+			return b.handleUploadFile(&msg)
 		}
 	}
 
-	smsg := &models.Message{
-		RoomID: channel.ID,
-		Msg:    msg.Text,
-		PostMessage: models.PostMessage{
-			Avatar: msg.Avatar,
-			Alias:  msg.Username,
-		},
-	}
+	return b.postMessage(&msg, channel.ID)
+}
 
-	rmsg, err := b.c.SendMessage(smsg)
-	if rmsg == nil {
+// postMessage posts msg with the alias and avatar of the remote user, this needs the
+// message-impersonate permission for the bot.
+func (b *Brocketchat) postMessage(msg *config.Message, roomID string) (string, error) {
+	resp, err := b.r.PostMessage(&models.PostMessage{
+		RoomID: roomID,
+		Text:   msg.Text,
+		Alias:  msg.Username,
+		Avatar: msg.Avatar,
+	})
+	if err != nil {
 		return "", err
 	}
-	return rmsg.ID, err
+	return resp.Message.ID, nil
+}
+
+// replaceEmoji maps the emoji shortcodes of text to the custom emoji of the server.
+func (b *Brocketchat) replaceEmoji(text string) string {
+	b.RLock()
+	defer b.RUnlock()
+	return replaceEmoji(text, b.customEmoji)
 }
@@ -0,0 +1,231 @@
+package bsignal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+)
+
+type receiveParams struct {
+	Envelope envelope `json:"envelope"`
+	Account  string   `json:"account"`
+}
+
+type envelope struct {
+	Source       string       `json:"source"`
+	SourceNumber string       `json:"sourceNumber"`
+	SourceUUID   string       `json:"sourceUuid"`
+	SourceName   string       `json:"sourceName"`
+	Timestamp    int64        `json:"timestamp"`
+	DataMessage  *dataMessage `json:"dataMessage"`
+	EditMessage  *editMessage `json:"editMessage"`
+}
+
+type dataMessage struct {
+	Timestamp    int64         `json:"timestamp"`
+	Message      string        `json:"message"`
+	GroupInfo    *groupInfo    `json:"groupInfo"`
+	Attachments  []attachment  `json:"attachments"`
+	Quote        *quote        `json:"quote"`
+	RemoteDelete *remoteDelete `json:"remoteDelete"`
+}
+
+type editMessage struct {
+	TargetSentTimestamp int64       `json:"targetSentTimestamp"`
+	DataMessage         dataMessage `json:"dataMessage"`
+}
+
+type groupInfo struct {
+	GroupID string `json:"groupId"`
+}
+
+type attachment struct {
+	ContentType string `json:"contentType"`
+	Filename    string `json:"filename"`
+	ID          string `json:"id"`
+	Size        int64  `json:"size"`
+}
+
+type quote struct {
+	ID int64 `json:"id"`
+}
+
+type remoteDelete struct {
+	Timestamp int64 `json:"timestamp"`
+}
+
+type dataResult struct {
+	Data string `json:"data"`
+}
+
+// handleNotifications relays the messages signal-cli receives until the connection closes.
+func (b *Bsignal) handleNotifications(rpc *rpcClient) {
+	for n := range rpc.notifications {
+		if n.Method != "receive" {
+			continue
+		}
+		var params receiveParams
+		if err := json.Unmarshal(n.Params, &params); err != nil {
+			b.Log.Errorf("Couldn't parse received message: %s", err)
+			continue
+		}
+		if number := b.GetString("Number"); number != "" && params.Account != "" && params.Account != number {
+			continue
+		}
+		b.handleEnvelope(&params.Envelope)
+	}
+
+	b.RLock()
+	current := b.rpc == rpc
+	b.RUnlock()
+	if current {
+		b.Log.Error("Connection to signal-cli lost, reconnecting")
+		b.Remote <- config.Message{Username: "system", Text: "reconnect", Channel: "", Account: b.Account, Event: config.EventFailure}
+	}
+}
+
+// toMessage returns the message of the envelope env and its data message, or false if
+// it isn't a message of a joined group.
+func (b *Bsignal) toMessage(env *envelope) (config.Message, *dataMessage, bool) {
+	dm := env.DataMessage
+	id := ""
+	if env.EditMessage != nil {
+		dm = &env.EditMessage.DataMessage
+		id = strconv.FormatInt(env.EditMessage.TargetSentTimestamp, 10)
+	} else if dm != nil {
+		id = strconv.FormatInt(dm.Timestamp, 10)
+	}
+	if dm == nil || dm.GroupInfo == nil {
+		return config.Message{}, nil, false
+	}
+	channel, ok := b.channelName(dm.GroupInfo.GroupID)
+	if !ok {
+		return config.Message{}, nil, false
+	}
+
+	rmsg := config.Message{
+		Account:  b.Account,
+		Channel:  channel,
+		ID:       id,
+		Text:     dm.Message,
+		Username: env.SourceName,
+		UserID:   env.SourceNumber,
+		Extra:    make(map[string][]interface{}),
+	}
+	if rmsg.UserID == "" {
+		rmsg.UserID = env.SourceUUID
+	}
+	if rmsg.Username == "" {
+		rmsg.Username = rmsg.UserID
+	}
+	if dm.Quote != nil {
+		rmsg.ParentID = strconv.FormatInt(dm.Quote.ID, 10)
+	}
+	if dm.RemoteDelete != nil {
+		rmsg.Event = config.EventMsgDelete
+		rmsg.ID = strconv.FormatInt(dm.RemoteDelete.Timestamp, 10)
+		rmsg.Text = config.EventMsgDelete
+	}
+	return rmsg, dm, true
+}
+
+func (b *Bsignal) handleEnvelope(env *envelope) {
+	rmsg, dm, ok := b.toMessage(env)
+	if !ok {
+		return
+	}
+
+	// only download avatars if we have a place to upload them (configured mediaserver),
+	// downloads are requests to signal-cli which can't wait for its notifications
+	if helper.HasMediaServer(b.General) {
+		userid, channel := rmsg.UserID, rmsg.Channel
+		helper.Downloads.Go(b.Account, func() { b.handleDownloadAvatar(userid, channel) })
+	}
+	rmsg.Avatar = helper.GetAvatar(b.avatarMap, rmsg.UserID, b.General)
+
+	if len(dm.Attachments) > 0 && rmsg.Event == "" {
+		// download the attachments in the background so other messages aren't held up
+		b.received.Go(rmsg.Channel, func() { b.handleDownloadFiles(&rmsg, dm) }, func() { b.relay(&rmsg) })
+		return
+	}
+	b.received.Relay(rmsg.Channel, func() { b.relay(&rmsg) })
+}
+
+func (b *Bsignal) relay(rmsg *config.Message) {
+	if rmsg.Text == "" && len(rmsg.Extra) == 0 {
+		return
+	}
+	b.Log.Debugf("<= Sending message from %s on %s to gateway", rmsg.Username, b.Account)
+	b.Log.Debugf("<= Message is %#v", rmsg)
+	b.Remote <- *rmsg
+}
+
+// handleDownloadFiles adds the attachments of dm to rmsg.
+func (b *Bsignal) handleDownloadFiles(rmsg *config.Message, dm *dataMessage) {
+	for _, a := range dm.Attachments {
+		name := a.Filename
+		if name == "" {
+			name = a.ID
+		}
+		if err := helper.HandleDownloadSize(b.Log, rmsg, name, a.Size, b.General); err != nil {
+			b.Log.Error(err)
+			continue
+		}
+		data, err := b.download("getAttachment", b.params(map[string]interface{}{"id": a.ID, "groupId": dm.GroupInfo.GroupID}))
+		if err != nil {
+			b.Log.Errorf("download of attachment %s failed: %s", name, err)
+			continue
+		}
+		helper.HandleDownloadData(b.Log, rmsg, name, "", "", data, b.General)
+	}
+}
+
+// handleDownloadAvatar downloads the profile picture of userid from channel.
+func (b *Bsignal) handleDownloadAvatar(userid, channel string) {
+	if userid == "" || b.avatarMap.Contains(userid) {
+		return
+	}
+	rmsg := config.Message{
+		Username: "system",
+		Text:     "avatar",
+		Channel:  channel,
+		Account:  b.Account,
+		UserID:   userid,
+		Event:    config.EventAvatarDownload,
+		Extra:    make(map[string][]interface{}),
+	}
+	data, err := b.download("getAvatar", b.params(map[string]interface{}{"profile": userid}))
+	if err != nil {
+		b.Log.Debugf("Avatar download failed for %s: %s", userid, err)
+		return
+	}
+	name := userid + ".png"
+	if err := helper.HandleDownloadSize(b.Log, &rmsg, name, int64(len(*data)), b.General); err != nil {
+		b.Log.Error(err)
+		return
+	}
+	helper.HandleDownloadData(b.Log, &rmsg, name, rmsg.Text, "", data, b.General)
+	b.Remote <- rmsg
+}
+
+// download calls method, which returns the base64 encoded data of a file, through the
+// download pool.
+func (b *Bsignal) download(method string, params interface{}) (*[]byte, error) {
+	var data []byte
+	err := helper.Downloads.Fetch(func() error {
+		var res dataResult
+		if err := b.call(method, params, &res); err != nil {
+			return err
+		}
+		var err error
+		data, err = base64.StdEncoding.DecodeString(res.Data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
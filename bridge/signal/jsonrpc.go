@@ -0,0 +1,176 @@
+package bsignal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rpcTimeout is the time to wait for the response to a request to signal-cli.
+const rpcTimeout = 2 * time.Minute
+
+var errClosed = errors.New("connection to signal-cli closed")
+
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+	ID      string      `json:"id"`
+}
+
+// rpcMessage is a response or, without ID, a notification of signal-cli.
+type rpcMessage struct {
+	ID     string          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("signal-cli error %d: %s", e.Code, e.Message)
+}
+
+// rpcClient talks to signal-cli's JSON-RPC daemon, which sends one JSON object per line.
+type rpcClient struct {
+	conn io.ReadWriteCloser
+
+	sync.Mutex
+	lastID  int
+	pending map[string]chan *rpcMessage
+	closed  bool
+
+	// notifications gets the notifications of signal-cli, like received messages
+	notifications chan *rpcMessage
+}
+
+// dialRPC connects to the signal-cli daemon at address, a host:port started with
+// --tcp or unix:///path of a socket started with --socket.
+func dialRPC(address string) (*rpcClient, error) {
+	network := "tcp"
+	if strings.HasPrefix(address, "unix://") {
+		network, address = "unix", strings.TrimPrefix(address, "unix://")
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return newRPCClient(conn), nil
+}
+
+func newRPCClient(conn io.ReadWriteCloser) *rpcClient {
+	c := &rpcClient{
+		conn:          conn,
+		pending:       make(map[string]chan *rpcMessage),
+		notifications: make(chan *rpcMessage, 100),
+	}
+	go c.readLoop()
+	return c
+}
+
+func (c *rpcClient) readLoop() {
+	r := bufio.NewReader(c.conn)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			c.dispatch(line)
+		}
+		if err != nil {
+			break
+		}
+	}
+	c.Lock()
+	c.closed = true
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.Unlock()
+	close(c.notifications)
+}
+
+func (c *rpcClient) dispatch(line []byte) {
+	var msg rpcMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return
+	}
+	if msg.ID == "" {
+		if msg.Method != "" {
+			c.notifications <- &msg
+		}
+		return
+	}
+	c.Lock()
+	ch, ok := c.pending[msg.ID]
+	delete(c.pending, msg.ID)
+	c.Unlock()
+	if ok {
+		ch <- &msg
+	}
+}
+
+// call sends the request method with params and decodes its result in result, if not nil.
+func (c *rpcClient) call(method string, params interface{}, result interface{}) error {
+	ch := make(chan *rpcMessage, 1)
+	c.Lock()
+	if c.closed {
+		c.Unlock()
+		return errClosed
+	}
+	c.lastID++
+	id := strconv.Itoa(c.lastID)
+	c.pending[id] = ch
+	c.Unlock()
+
+	data, err := json.Marshal(rpcRequest{JSONRPC: "2.0", Method: method, Params: params, ID: id})
+	if err != nil {
+		c.forget(id)
+		return err
+	}
+	c.Lock()
+	_, err = c.conn.Write(append(data, '\n'))
+	c.Unlock()
+	if err != nil {
+		c.forget(id)
+		return err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return errClosed
+		}
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result == nil || len(resp.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-time.After(rpcTimeout):
+		c.forget(id)
+		return fmt.Errorf("%s: no response from signal-cli", method)
+	}
+}
+
+func (c *rpcClient) forget(id string) {
+	c.Lock()
+	delete(c.pending, id)
+	c.Unlock()
+}
+
+func (c *rpcClient) Close() error {
+	return c.conn.Close()
+}
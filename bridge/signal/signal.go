@@ -0,0 +1,277 @@
+package bsignal
+
+import (
+	"encoding/base64"
+	"errors"
+	"mime"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+// Bsignal bridges signal groups through the JSON-RPC daemon of signal-cli
+// (https://github.com/AsamK/signal-cli), which holds the registration of the account.
+type Bsignal struct {
+	*bridge.Config
+
+	sync.RWMutex
+	rpc *rpcClient
+	// groups maps the IDs of the joined groups to their channel names in the config
+	groups map[string]string
+
+	avatarMap *store.Bucket // keep cache of userid and avatar sha
+	received  *helper.ChannelQueue
+}
+
+type group struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	IsMember bool   `json:"isMember"`
+}
+
+type sendParams struct {
+	Account       string   `json:"account,omitempty"`
+	GroupID       string   `json:"groupId"`
+	Message       string   `json:"message"`
+	Attachments   []string `json:"attachments,omitempty"`
+	EditTimestamp int64    `json:"editTimestamp,omitempty"`
+}
+
+type sendResult struct {
+	Timestamp int64 `json:"timestamp"`
+}
+
+type remoteDeleteParams struct {
+	Account         string `json:"account,omitempty"`
+	GroupID         string `json:"groupId"`
+	TargetTimestamp int64  `json:"targetTimestamp"`
+}
+
+func New(cfg *bridge.Config) bridge.Bridger {
+	return &Bsignal{
+		Config:    cfg,
+		groups:    make(map[string]string),
+		avatarMap: cfg.NewBucket("avatar"),
+		received:  helper.NewChannelQueue(cfg.Account),
+	}
+}
+
+func (b *Bsignal) Connect() error {
+	b.Log.Infof("Connecting to signal-cli on %s", b.GetString("Server"))
+	rpc, err := dialRPC(b.GetString("Server"))
+	if err != nil {
+		return err
+	}
+	b.Lock()
+	b.rpc = rpc
+	b.Unlock()
+	go b.handleNotifications(rpc)
+	b.Log.Info("Connection succeeded")
+	return nil
+}
+
+func (b *Bsignal) Disconnect() error {
+	b.Lock()
+	rpc := b.rpc
+	b.rpc = nil
+	b.Unlock()
+	if rpc == nil {
+		return nil
+	}
+	return rpc.Close()
+}
+
+// JoinChannel maps the channel, the ID or the name of a group the account is a member of,
+// to the ID of that group.
+func (b *Bsignal) JoinChannel(channel config.ChannelInfo) error {
+	var groups []group
+	if err := b.call("listGroups", b.params(nil), &groups); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if g.IsMember && (g.ID == channel.Name || g.Name == channel.Name) {
+			b.Lock()
+			b.groups[g.ID] = channel.Name
+			b.Unlock()
+			return nil
+		}
+	}
+	return errors.New("the account isn't a member of a group with ID or name " + channel.Name)
+}
+
+func (b *Bsignal) Send(msg config.Message) (string, error) {
+	b.Log.Debugf("=> Receiving %#v", msg)
+
+	// map the file SHA to our user (caches the avatar)
+	if msg.Event == config.EventAvatarDownload {
+		return b.cacheAvatar(&msg)
+	}
+
+	groupID := b.groupID(msg.Channel)
+	if groupID == "" {
+		return "", errors.New("unknown channel " + msg.Channel)
+	}
+
+	switch msg.Event {
+	case config.EventMsgDelete:
+		if msg.ID == "" {
+			return "", nil
+		}
+		ts, err := strconv.ParseInt(msg.ID, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		return "", b.call("remoteDelete", &remoteDeleteParams{Account: b.GetString("Number"), GroupID: groupID, TargetTimestamp: ts}, nil)
+	case "", config.EventUserAction, config.EventTopicChange:
+	default:
+		if !config.IsJoinLeave(msg.Event) {
+			return "", nil
+		}
+	}
+
+	if msg.Extra != nil {
+		for _, rmsg := range helper.HandleExtra(&msg, b.General) {
+			if _, err := b.send(groupID, rmsg.Username+rmsg.Text, nil, ""); err != nil {
+				b.Log.Errorf("Could not send extra message: %s", err)
+			}
+		}
+		if len(msg.Extra["file"]) > 0 {
+			return b.handleUploadFile(&msg, groupID)
+		}
+	}
+
+	return b.send(groupID, msg.Username+msg.Text, nil, msg.ID)
+}
+
+// send sends text with attachments to the group groupID, or edits the message editID
+// when set, and returns the timestamp identifying the message.
+func (b *Bsignal) send(groupID, text string, attachments []string, editID string) (string, error) {
+	params := &sendParams{
+		Account:     b.GetString("Number"),
+		GroupID:     groupID,
+		Message:     text,
+		Attachments: attachments,
+	}
+	if editID != "" {
+		ts, err := strconv.ParseInt(editID, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		params.EditTimestamp = ts
+	}
+	var res sendResult
+	if err := b.call("send", params, &res); err != nil {
+		return "", err
+	}
+	if editID != "" {
+		return editID, nil
+	}
+	return strconv.FormatInt(res.Timestamp, 10), nil
+}
+
+// handleUploadFile sends the files of msg as attachments, files that weren't downloaded
+// are sent as a link.
+func (b *Bsignal) handleUploadFile(msg *config.Message, groupID string) (string, error) {
+	var msgID string
+	for _, f := range msg.Extra["file"] {
+		fi := f.(config.FileInfo)
+		if fi.Data == nil && fi.Media == nil {
+			if fi.URL == "" {
+				continue
+			}
+			id, err := b.send(groupID, msg.Username+strings.TrimSpace(fi.Comment+" "+fi.URL), nil, "")
+			if err != nil {
+				return msgID, err
+			}
+			msgID = id
+			continue
+		}
+		attachment, err := dataURI(&fi)
+		if err != nil {
+			return msgID, err
+		}
+		id, err := b.send(groupID, msg.Username+fi.Comment, []string{attachment}, "")
+		if err != nil {
+			return msgID, err
+		}
+		msgID = id
+	}
+	return msgID, nil
+}
+
+// dataURI returns the file as the data URI signal-cli accepts as attachment.
+func dataURI(fi *config.FileInfo) (string, error) {
+	data, err := fi.Bytes()
+	if err != nil {
+		return "", err
+	}
+	mtype := fi.MimeType()
+	if mtype == "" {
+		mtype = mime.TypeByExtension(filepath.Ext(fi.Name))
+	}
+	if mtype == "" {
+		mtype = "application/octet-stream"
+	}
+	return "data:" + mtype + ";filename=" + fi.Name + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func (b *Bsignal) cacheAvatar(msg *config.Message) (string, error) {
+	fi := msg.Extra["file"][0].(config.FileInfo)
+	/* if we have a sha we have successfully uploaded the file to the media server,
+	so we can now cache the sha */
+	if fi.SHA != "" {
+		b.Log.Debugf("Added %s to %s in avatarMap", fi.SHA, msg.UserID)
+		if err := b.avatarMap.SetString(msg.UserID, fi.SHA); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// params returns params with the account of the bridge, which is needed when signal-cli
+// runs for several accounts.
+func (b *Bsignal) params(params map[string]interface{}) map[string]interface{} {
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+	if number := b.GetString("Number"); number != "" {
+		params["account"] = number
+	}
+	return params
+}
+
+func (b *Bsignal) call(method string, params interface{}, result interface{}) error {
+	b.RLock()
+	rpc := b.rpc
+	b.RUnlock()
+	if rpc == nil {
+		return errClosed
+	}
+	return rpc.call(method, params, result)
+}
+
+// groupID returns the ID of the group joined as channel.
+func (b *Bsignal) groupID(channel string) string {
+	b.RLock()
+	defer b.RUnlock()
+	for id, name := range b.groups {
+		if name == channel {
+			return id
+		}
+	}
+	return ""
+}
+
+// channelName returns the channel name of the group groupID, if it's joined.
+func (b *Bsignal) channelName(groupID string) (string, bool) {
+	b.RLock()
+	defer b.RUnlock()
+	name, ok := b.groups[groupID]
+	return name, ok
+}
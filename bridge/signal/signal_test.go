@@ -0,0 +1,179 @@
+package bsignal
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfig = []byte(`
+[signal.mybot]
+Server="127.0.0.1:7583"
+Number="+15550001111"
+`)
+
+// fakeDaemon answers the requests of the bridge like signal-cli and records them.
+type fakeDaemon struct {
+	conn     net.Conn
+	requests chan map[string]interface{}
+}
+
+func (d *fakeDaemon) serve() {
+	r := bufio.NewReader(d.conn)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		var req map[string]interface{}
+		if err := json.Unmarshal(line, &req); err != nil {
+			return
+		}
+		var result interface{}
+		switch req["method"] {
+		case "listGroups":
+			result = []group{{ID: "Z3JvdXA=", Name: "friends", IsMember: true}}
+		case "send":
+			result = sendResult{Timestamp: 1700000000000}
+		case "getAttachment":
+			result = dataResult{Data: "aGVsbG8="}
+		case "getAvatar":
+			result = dataResult{Data: "YXZhdGFy"}
+		}
+		d.requests <- req
+		d.write(map[string]interface{}{"jsonrpc": "2.0", "id": req["id"], "result": result})
+	}
+}
+
+func (d *fakeDaemon) write(v interface{}) {
+	data, _ := json.Marshal(v)
+	_, _ = d.conn.Write(append(data, '\n'))
+}
+
+func newTestSignal(t *testing.T) (*Bsignal, *fakeDaemon) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	br := bridge.New(&config.Bridge{Account: "signal.mybot"})
+	br.Config = config.NewConfigFromString(logger, testconfig)
+	br.General = &config.Protocol{MediaDownloadSize: 1000000}
+	br.Log = logrus.NewEntry(logger)
+	b, ok := New(&bridge.Config{Bridge: br, Remote: make(chan config.Message, 10)}).(*Bsignal)
+	require.True(t, ok)
+
+	client, server := net.Pipe()
+	d := &fakeDaemon{conn: server, requests: make(chan map[string]interface{}, 10)}
+	go d.serve()
+	b.rpc = newRPCClient(client)
+	go b.handleNotifications(b.rpc)
+	t.Cleanup(func() { b.Disconnect() })
+
+	require.NoError(t, b.JoinChannel(config.ChannelInfo{Name: "friends"}))
+	req := <-d.requests
+	assert.Equal(t, "listGroups", req["method"])
+	assert.Equal(t, "+15550001111", req["params"].(map[string]interface{})["account"])
+	return b, d
+}
+
+func TestSend(t *testing.T) {
+	b, d := newTestSignal(t)
+
+	id, err := b.Send(config.Message{Username: "bob: ", Text: "hello", Channel: "friends"})
+	require.NoError(t, err)
+	assert.Equal(t, "1700000000000", id)
+	req := <-d.requests
+	assert.Equal(t, "send", req["method"])
+	assert.Equal(t, map[string]interface{}{"account": "+15550001111", "groupId": "Z3JvdXA=", "message": "bob: hello"}, req["params"])
+
+	data := []byte("hi")
+	_, err = b.Send(config.Message{Username: "bob: ", Channel: "friends", Extra: map[string][]interface{}{
+		"file": {config.FileInfo{Name: "a.txt", Data: &data, Comment: "a file"}},
+	}})
+	require.NoError(t, err)
+	req = <-d.requests
+	params := req["params"].(map[string]interface{})
+	assert.Equal(t, "bob: a file", params["message"])
+	assert.Equal(t, []interface{}{"data:text/plain; charset=utf-8;filename=a.txt;base64,aGk="}, params["attachments"])
+
+	_, err = b.Send(config.Message{Event: config.EventMsgDelete, ID: "1700000000000", Channel: "friends"})
+	require.NoError(t, err)
+	req = <-d.requests
+	assert.Equal(t, "remoteDelete", req["method"])
+	assert.Equal(t, 1700000000000.0, req["params"].(map[string]interface{})["targetTimestamp"])
+
+	_, err = b.Send(config.Message{Text: "hello", Channel: "unknown"})
+	assert.Error(t, err)
+}
+
+func TestReceive(t *testing.T) {
+	b, d := newTestSignal(t)
+
+	d.write(map[string]interface{}{"jsonrpc": "2.0", "method": "receive", "params": map[string]interface{}{
+		"account": "+15550001111",
+		"envelope": map[string]interface{}{
+			"sourceNumber": "+15552223333", "sourceName": "Alice",
+			"dataMessage": map[string]interface{}{
+				"timestamp": 1700000000001, "message": "hi there",
+				"groupInfo":   map[string]interface{}{"groupId": "Z3JvdXA="},
+				"attachments": []interface{}{map[string]interface{}{"id": "abc.txt", "filename": "note.txt", "size": 5}},
+			},
+		},
+	}})
+	req := <-d.requests
+	assert.Equal(t, "getAttachment", req["method"])
+	assert.Equal(t, "abc.txt", req["params"].(map[string]interface{})["id"])
+
+	select {
+	case rmsg := <-b.Remote:
+		assert.Equal(t, "friends", rmsg.Channel)
+		assert.Equal(t, "Alice", rmsg.Username)
+		assert.Equal(t, "+15552223333", rmsg.UserID)
+		assert.Equal(t, "1700000000001", rmsg.ID)
+		assert.Equal(t, "hi there", rmsg.Text)
+		if assert.Len(t, rmsg.Extra["file"], 1) {
+			fi := rmsg.Extra["file"][0].(config.FileInfo)
+			assert.Equal(t, "note.txt", fi.Name)
+			data, err := fi.Bytes()
+			assert.NoError(t, err)
+			assert.Equal(t, "hello", string(data))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	// messages of other groups and accounts are skipped
+	d.write(map[string]interface{}{"jsonrpc": "2.0", "method": "receive", "params": map[string]interface{}{
+		"envelope": map[string]interface{}{
+			"sourceNumber": "+15552223333",
+			"dataMessage": map[string]interface{}{
+				"timestamp": 1700000000002, "message": "elsewhere",
+				"groupInfo": map[string]interface{}{"groupId": "b3RoZXI="},
+			},
+		},
+	}})
+	d.write(map[string]interface{}{"jsonrpc": "2.0", "method": "receive", "params": map[string]interface{}{
+		"account": "+15550001111",
+		"envelope": map[string]interface{}{
+			"sourceNumber": "+15552223333",
+			"dataMessage": map[string]interface{}{
+				"timestamp": 1700000000001, "remoteDelete": map[string]interface{}{"timestamp": 1700000000001},
+				"groupInfo": map[string]interface{}{"groupId": "Z3JvdXA="},
+			},
+		},
+	}})
+	select {
+	case rmsg := <-b.Remote:
+		assert.Equal(t, config.EventMsgDelete, rmsg.Event)
+		assert.Equal(t, "1700000000001", rmsg.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+}
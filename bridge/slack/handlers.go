@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"html"
+	"net/http"
 	"time"
 
 	"github.com/42wim/matterbridge/bridge/config"
@@ -26,29 +27,43 @@ func (b *Bslack) handleSlack() {
 	time.Sleep(time.Second)
 	b.Log.Debug("Start listening for Slack messages")
 	for message := range messages {
-		// don't do any action on deleted/typing messages
-		if message.Event != config.EventUserTyping && message.Event != config.EventMsgDelete &&
-			message.Event != config.EventFileDelete {
-			b.Log.Debugf("<= Sending message from %s on %s to gateway", message.Username, b.Account)
-			// cleanup the message
-			message.Text = b.replaceMention(message.Text)
-			message.Text = b.replaceVariable(message.Text)
-			message.Text = b.replaceChannel(message.Text)
-			message.Text = b.replaceURL(message.Text)
-			message.Text = b.replaceb0rkedMarkDown(message.Text)
-			message.Text = html.UnescapeString(message.Text)
-
-			// Add the avatar
-			message.Avatar = b.users.getAvatar(message.UserID)
-		}
+		b.sendToGateway(message)
+	}
+}
+
+// sendToGateway cleans up message and sends it to the gateway.
+func (b *Bslack) sendToGateway(message *config.Message) {
+	// don't do any action on deleted/typing messages
+	if message.Event != config.EventUserTyping && message.Event != config.EventMsgDelete &&
+		message.Event != config.EventFileDelete && message.Event != config.EventReactionAdd &&
+		message.Event != config.EventReactionRemove {
+		b.Log.Debugf("<= Sending message from %s on %s to gateway", message.Username, b.Account)
+		// cleanup the message
+		message.Text = b.replaceMention(message.Text)
+		message.Text = b.replaceVariable(message.Text)
+		message.Text = b.replaceChannel(message.Text)
+		message.Text = b.replaceURL(message.Text)
+		message.Text = b.replaceb0rkedMarkDown(message.Text)
+		message.Text = html.UnescapeString(message.Text)
+
+		// Add the avatar
+		message.Avatar = b.users.getAvatar(message.UserID)
+	}
+
+	b.Log.Debugf("<= Message is %#v", message)
+	b.Remote <- *message
+}
 
-		b.Log.Debugf("<= Message is %#v", message)
-		b.Remote <- *message
+// incomingEvents returns the events of Socket Mode when it's used, otherwise those of RTM.
+func (b *Bslack) incomingEvents() chan slack.RTMEvent {
+	if b.socket != nil {
+		return b.socket.events
 	}
+	return b.rtm.IncomingEvents
 }
 
 func (b *Bslack) handleSlackClient(messages chan *config.Message) {
-	for msg := range b.rtm.IncomingEvents {
+	for msg := range b.incomingEvents() {
 		if msg.Type != sUserTyping && msg.Type != sHello && msg.Type != sLatencyReport {
 			b.Log.Debugf("== Receiving event %#v", msg.Data)
 		}
@@ -71,11 +86,30 @@ func (b *Bslack) handleSlackClient(messages chan *config.Message) {
 				b.Log.Debugf("Skipped message: %#v", ev)
 				continue
 			}
-			rmsg, err := b.handleMessageEvent(ev)
+			rmsg, download, err := b.handleMessageEvent(ev)
 			if err != nil {
 				b.Log.Errorf("%#v", err)
 				continue
 			}
+			if download {
+				// download the files in the background so other messages aren't held up
+				b.received.Go(rmsg.Channel, func() { b.handleDownloadFiles(ev, rmsg) }, func() { messages <- rmsg })
+				continue
+			}
+			b.received.Relay(rmsg.Channel, func() { messages <- rmsg })
+		case *slack.ReactionAddedEvent:
+			rmsg, err := b.handleReactionEvent(slack.ReactionEvent(*ev), config.EventReactionAdd)
+			if err != nil {
+				b.Log.Debugf("%#v", err)
+				continue
+			}
+			messages <- rmsg
+		case *slack.ReactionRemovedEvent:
+			rmsg, err := b.handleReactionEvent(slack.ReactionEvent(*ev), config.EventReactionRemove)
+			if err != nil {
+				b.Log.Debugf("%#v", err)
+				continue
+			}
 			messages <- rmsg
 		case *slack.FileDeletedEvent:
 			rmsg, err := b.handleFileDeletedEvent(ev)
@@ -167,7 +201,7 @@ func (b *Bslack) skipMessageEvent(ev *slack.MessageEvent) bool {
 
 	// Skip any messages that we made ourselves or from 'slackbot' (see #527).
 	if ev.Username == sSlackBotUser ||
-		(b.rtm != nil && ev.Username == b.si.User.Name) || hasOurCallbackID {
+		(b.si != nil && ev.Username == b.si.User.Name) || hasOurCallbackID {
 		return true
 	}
 
@@ -199,18 +233,19 @@ func (b *Bslack) filesCached(files []slack.File) bool {
 //    pre-populated message to the Matterbridge router.
 // 4. Handle the specific case of messages that edit existing messages depending on
 //    configuration.
-// 5. Handle any attachments of the received event.
+// 5. Handle any attachments of the received event. Files are downloaded afterwards,
+//    when download is true, with 'handleDownloadFiles()'.
 // 6. Check that the Matterbridge message that we end up with after at the end of the
 //    pipeline is valid before sending it to the Matterbridge router.
-func (b *Bslack) handleMessageEvent(ev *slack.MessageEvent) (*config.Message, error) {
-	rmsg, err := b.populateReceivedMessage(ev)
+func (b *Bslack) handleMessageEvent(ev *slack.MessageEvent) (rmsg *config.Message, download bool, err error) {
+	rmsg, err = b.populateReceivedMessage(ev)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
 	// Handle some message types early.
 	if b.handleStatusEvent(ev, rmsg) {
-		return rmsg, nil
+		return rmsg, false, nil
 	}
 
 	b.handleAttachments(ev, rmsg)
@@ -220,14 +255,14 @@ func (b *Bslack) handleMessageEvent(ev *slack.MessageEvent) (*config.Message, er
 	if len(ev.Files) == 0 && (rmsg.Text == "" || rmsg.Username == "") {
 		if ev.BotID != "" {
 			// This is probably a webhook we couldn't resolve.
-			return nil, fmt.Errorf("message handling resulted in an empty bot message (probably an incoming webhook we couldn't resolve): %#v", ev)
+			return nil, false, fmt.Errorf("message handling resulted in an empty bot message (probably an incoming webhook we couldn't resolve): %#v", ev)
 		}
 		if ev.SubMessage != nil {
-			return nil, fmt.Errorf("message handling resulted in an empty message: %#v with submessage %#v", ev, ev.SubMessage)
+			return nil, false, fmt.Errorf("message handling resulted in an empty message: %#v with submessage %#v", ev, ev.SubMessage)
 		}
-		return nil, fmt.Errorf("message handling resulted in an empty message: %#v", ev)
+		return nil, false, fmt.Errorf("message handling resulted in an empty message: %#v", ev)
 	}
-	return rmsg, nil
+	return rmsg, len(ev.Files) > 0, nil
 }
 
 func (b *Bslack) handleFileDeletedEvent(ev *slack.FileDeletedEvent) (*config.Message, error) {
@@ -250,15 +285,42 @@ func (b *Bslack) handleFileDeletedEvent(ev *slack.FileDeletedEvent) (*config.Mes
 	return nil, fmt.Errorf("channel ID for file ID %s not found", ev.FileID)
 }
 
+func (b *Bslack) handleReactionEvent(ev slack.ReactionEvent, event string) (*config.Message, error) {
+	if ev.Item.Type != "message" {
+		return nil, fmt.Errorf("ignoring reaction on %s", ev.Item.Type)
+	}
+	// our own reactions are the ones relayed from the other bridges
+	if b.si != nil && ev.User == b.si.User.ID {
+		return nil, fmt.Errorf("ignoring own reaction %s", ev.Reaction)
+	}
+	channel, err := b.channels.getChannelByID(ev.Item.Channel)
+	if err != nil {
+		return nil, err
+	}
+	return &config.Message{
+		Event:    event,
+		Text:     helper.EmojiFromShortcode(ev.Reaction),
+		Channel:  channel.Name,
+		Account:  b.Account,
+		Username: b.users.getUsername(ev.User),
+		UserID:   ev.User,
+		ParentID: ev.Item.Timestamp,
+		Protocol: b.Protocol,
+	}, nil
+}
+
 func (b *Bslack) handleStatusEvent(ev *slack.MessageEvent, rmsg *config.Message) bool {
 	switch ev.SubType {
 	case sChannelJoined, sMemberJoined:
 		// There's no further processing needed on channel events
 		// so we return 'true'.
 		return true
-	case sChannelJoin, sChannelLeave:
+	case sChannelJoin:
+		rmsg.Username = sSystemUser
+		rmsg.Event = config.EventJoin
+	case sChannelLeave:
 		rmsg.Username = sSystemUser
-		rmsg.Event = config.EventJoinLeave
+		rmsg.Event = config.EventPart
 	case sChannelTopic, sChannelPurpose:
 		b.channels.populateChannels(false)
 		rmsg.Event = config.EventTopicChange
@@ -316,8 +378,11 @@ func (b *Bslack) handleAttachments(ev *slack.MessageEvent, rmsg *config.Message)
 	if len(ev.Attachments) > 0 {
 		rmsg.Extra[sSlackAttachment] = append(rmsg.Extra[sSlackAttachment], ev.Attachments)
 	}
+}
 
-	// If we have files attached, download them (in memory) and put a pointer to it in msg.Extra.
+// handleDownloadFiles downloads the files attached to the message (in memory) and puts a
+// pointer to them in msg.Extra.
+func (b *Bslack) handleDownloadFiles(ev *slack.MessageEvent, rmsg *config.Message) {
 	for i := range ev.Files {
 		// keep reference in cache on which channel we added this file
 		b.cache.Add(cfileDownloadChannel+ev.Files[i].ID, ev.Channel)
@@ -354,13 +419,13 @@ func (b *Bslack) handleDownloadFile(rmsg *config.Message, file *slack.File, retr
 	}
 
 	// Actually download the file.
-	data, err := helper.DownloadFileAuth(file.URLPrivateDownload, "Bearer "+b.GetString(tokenConfig))
+	media, err := b.DownloadMedia(file.URLPrivateDownload, http.Header{"Authorization": {"Bearer " + b.GetString(tokenConfig)}})
 	if err != nil {
 		return fmt.Errorf("download %s failed %#v", file.URLPrivateDownload, err)
 	}
 
-	if len(*data) != file.Size && !retry {
-		b.Log.Debugf("Data size (%d) is not equal to size declared (%d)\n", len(*data), file.Size)
+	if media.Size() != int64(file.Size) && !retry {
+		b.Log.Debugf("Data size (%d) is not equal to size declared (%d)\n", media.Size(), file.Size)
 		time.Sleep(1 * time.Second)
 		return b.handleDownloadFile(rmsg, file, true)
 	}
@@ -370,7 +435,7 @@ func (b *Bslack) handleDownloadFile(rmsg *config.Message, file *slack.File, retr
 	// that the comment is not duplicated.
 	comment := rmsg.Text
 	rmsg.Text = ""
-	helper.HandleDownloadData2(b.Log, rmsg, file.Name, file.ID, comment, file.URLPrivateDownload, data, b.General)
+	helper.HandleDownloadMedia(b.Log, rmsg, file.Name, file.ID, comment, file.URLPrivateDownload, media)
 	return nil
 }
 
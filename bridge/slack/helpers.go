@@ -3,6 +3,7 @@ package bslack
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,17 +22,24 @@ func (b *Bslack) populateReceivedMessage(ev *slack.MessageEvent) (*config.Messag
 	}
 
 	rmsg := &config.Message{
-		Text:     ev.Text,
-		Channel:  channel.Name,
-		Account:  b.Account,
-		ID:       ev.Timestamp,
-		Extra:    make(map[string][]interface{}),
-		ParentID: ev.ThreadTimestamp,
-		Protocol: b.Protocol,
+		Text:      ev.Text,
+		Channel:   channel.Name,
+		Account:   b.Account,
+		ID:        ev.Timestamp,
+		Extra:     make(map[string][]interface{}),
+		ParentID:  ev.ThreadTimestamp,
+		Protocol:  b.Protocol,
+		Timestamp: parseSlackTimestamp(ev.Timestamp),
+	}
+	if ev.ThreadTimestamp != ev.Timestamp {
+		rmsg.ThreadID = ev.ThreadTimestamp
 	}
 	if b.useChannelID {
 		rmsg.Channel = "ID:" + channel.ID
 	}
+	if ev.BotID != "" || ev.SubType == sBotMessage {
+		rmsg.Extra[config.ExtraBot] = []interface{}{true}
+	}
 
 	// Handle 'edit' messages.
 	if ev.SubMessage != nil && !b.GetBool(editDisableConfig) {
@@ -47,6 +55,10 @@ func (b *Bslack) populateReceivedMessage(ev *slack.MessageEvent) (*config.Messag
 	// unthreaded end.
 	if ev.SubMessage != nil {
 		rmsg.ParentID = ev.SubMessage.ThreadTimestamp
+		rmsg.ThreadID = ""
+		if ev.SubMessage.ThreadTimestamp != ev.SubMessage.Timestamp {
+			rmsg.ThreadID = ev.SubMessage.ThreadTimestamp
+		}
 	}
 
 	if err = b.populateMessageWithUserInfo(ev, rmsg); err != nil {
@@ -55,6 +67,20 @@ func (b *Bslack) populateReceivedMessage(ev *slack.MessageEvent) (*config.Messag
 	return rmsg, err
 }
 
+// parseSlackTimestamp returns the time of a slack message timestamp, eg 1503435956.000247.
+func parseSlackTimestamp(ts string) time.Time {
+	parts := strings.SplitN(ts, ".", 2)
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	var usec int64
+	if len(parts) == 2 {
+		usec, _ = strconv.ParseInt(parts[1], 10, 64)
+	}
+	return time.Unix(sec, usec*int64(time.Microsecond))
+}
+
 func (b *Bslack) populateMessageWithUserInfo(ev *slack.MessageEvent, rmsg *config.Message) error {
 	if ev.SubType == sMessageDeleted || ev.SubType == sFileComment {
 		return nil
@@ -101,7 +127,7 @@ func (b *Bslack) populateMessageWithBotInfo(ev *slack.MessageEvent, rmsg *config
 	var err error
 	var bot *slack.Bot
 	for {
-		bot, err = b.rtm.GetBotInfo(slack.GetBotInfoParameters{
+		bot, err = b.sc.GetBotInfo(slack.GetBotInfoParameters{
 			Bot: ev.BotID,
 		})
 		if err == nil {
@@ -255,3 +281,15 @@ func handleRateLimit(log *logrus.Entry, err error) error {
 	time.Sleep(rateLimit.RetryAfter)
 	return nil
 }
+
+// Permalink implements bridge.Permalinker, id is the timestamp of the message.
+func (b *Bslack) Permalink(channel, id string) (string, bool) {
+	if b.si == nil || b.si.Team == nil || b.si.Team.Domain == "" || id == "" {
+		return "", false
+	}
+	ch, err := b.channels.getChannel(channel)
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("https://%s.slack.com/archives/%s/p%s", b.si.Team.Domain, ch.ID, strings.Replace(id, ".", "", 1)), true
+}
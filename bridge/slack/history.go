@@ -0,0 +1,72 @@
+package bslack
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/slack-go/slack"
+)
+
+// historyPageSize is the number of messages asked for at once, slack recommends at most 200.
+const historyPageSize = 200
+
+// slackTimestamp returns the slack timestamp of t.
+func slackTimestamp(t time.Time) string {
+	return fmt.Sprintf("%d.%06d", t.Unix(), t.Nanosecond()/1000)
+}
+
+// FetchHistory implements bridge.HistoryFetcher.
+func (b *Bslack) FetchHistory(channel string, since time.Time, limit int) error {
+	if b.sc == nil {
+		return errors.New("the history isn't available with webhooks")
+	}
+	ch, err := b.channels.getChannel(channel)
+	if err != nil {
+		return err
+	}
+	// slack returns the newest messages first
+	var history []slack.Message
+	params := &slack.GetConversationHistoryParameters{ChannelID: ch.ID, Oldest: slackTimestamp(since)}
+	for len(history) < limit {
+		params.Limit = limit - len(history)
+		if params.Limit > historyPageSize {
+			params.Limit = historyPageSize
+		}
+		resp, err := b.sc.GetConversationHistory(params)
+		if err != nil {
+			return err
+		}
+		history = append(history, resp.Messages...)
+		if !resp.HasMore || resp.ResponseMetaData.NextCursor == "" {
+			break
+		}
+		params.Cursor = resp.ResponseMetaData.NextCursor
+	}
+	if len(history) > limit {
+		history = history[:limit]
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		ev := slack.MessageEvent(history[i])
+		ev.Channel = ch.ID
+		if b.skipMessageEvent(&ev) {
+			continue
+		}
+		rmsg, download, err := b.handleMessageEvent(&ev)
+		if err != nil {
+			b.Log.Debugf("Skipped message of the history of %s: %s", channel, err)
+			continue
+		}
+		if download {
+			b.handleDownloadFiles(&ev, rmsg)
+		}
+		if rmsg.Extra == nil {
+			rmsg.Extra = make(map[string][]interface{})
+		}
+		rmsg.Extra[config.ExtraBackfill] = []interface{}{true}
+		b.sendToGateway(rmsg)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/hook/signature"
 	"github.com/42wim/matterbridge/matterhook"
 	"github.com/slack-go/slack"
 )
@@ -28,22 +29,25 @@ func (b *BLegacy) Connect() error {
 			b.mh = matterhook.New(b.GetString(outgoingWebhookConfig), matterhook.Config{
 				InsecureSkipVerify: b.GetBool(skipTLSConfig),
 				BindAddress:        b.GetString(incomingWebhookConfig),
+				Verifier:           b.WebhookVerifier(signature.ModeSlack),
 			})
 		case b.GetString(tokenConfig) != "":
 			b.Log.Info("Connecting using token (sending)")
-			b.sc = slack.New(b.GetString(tokenConfig))
-			b.rtm = b.sc.NewRTM()
+			b.sc = slack.New(b.GetString(tokenConfig), slack.OptionHTTPClient(b.HTTPClient(0)))
+			b.rtm = b.sc.NewRTM(slack.RTMOptionDialer(b.WebsocketDialer()))
 			go b.rtm.ManageConnection()
 			b.Log.Info("Connecting using webhookbindaddress (receiving)")
 			b.mh = matterhook.New(b.GetString(outgoingWebhookConfig), matterhook.Config{
 				InsecureSkipVerify: b.GetBool(skipTLSConfig),
 				BindAddress:        b.GetString(incomingWebhookConfig),
+				Verifier:           b.WebhookVerifier(signature.ModeSlack),
 			})
 		default:
 			b.Log.Info("Connecting using webhookbindaddress (receiving)")
 			b.mh = matterhook.New(b.GetString(outgoingWebhookConfig), matterhook.Config{
 				InsecureSkipVerify: b.GetBool(skipTLSConfig),
 				BindAddress:        b.GetString(incomingWebhookConfig),
+				Verifier:           b.WebhookVerifier(signature.ModeSlack),
 			})
 		}
 		go b.handleSlack()
@@ -57,19 +61,19 @@ func (b *BLegacy) Connect() error {
 		})
 		if b.GetString(tokenConfig) != "" {
 			b.Log.Info("Connecting using token (receiving)")
-			b.sc = slack.New(b.GetString(tokenConfig), slack.OptionDebug(b.GetBool("debug")))
+			b.sc = slack.New(b.GetString(tokenConfig), slack.OptionDebug(b.GetBool("debug")), slack.OptionHTTPClient(b.HTTPClient(0)))
 			b.channels = newChannelManager(b.Log, b.sc)
 			b.users = newUserManager(b.Log, b.sc)
-			b.rtm = b.sc.NewRTM()
+			b.rtm = b.sc.NewRTM(slack.RTMOptionDialer(b.WebsocketDialer()))
 			go b.rtm.ManageConnection()
 			go b.handleSlack()
 		}
 	} else if b.GetString(tokenConfig) != "" {
 		b.Log.Info("Connecting using token (sending and receiving)")
-		b.sc = slack.New(b.GetString(tokenConfig), slack.OptionDebug(b.GetBool("debug")))
+		b.sc = slack.New(b.GetString(tokenConfig), slack.OptionDebug(b.GetBool("debug")), slack.OptionHTTPClient(b.HTTPClient(0)))
 		b.channels = newChannelManager(b.Log, b.sc)
 		b.users = newUserManager(b.Log, b.sc)
-		b.rtm = b.sc.NewRTM()
+		b.rtm = b.sc.NewRTM(slack.RTMOptionDialer(b.WebsocketDialer()))
 		go b.rtm.ManageConnection()
 		go b.handleSlack()
 	}
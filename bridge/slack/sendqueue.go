@@ -0,0 +1,185 @@
+package bslack
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// postRate and postBurst are the rate and the short bursts Slack allows for
+	// chat.postMessage in a channel: about one message a second.
+	postRate  = rate.Limit(1)
+	postBurst = 3
+)
+
+// sendQueue paces the messages posted in a channel to the rate limits of Slack and waits
+// for the Retry-After of rate limited posts, which applies to all channels. Messages that
+// can't be posted right away are queued and posted in the background, consecutive queued
+// messages of the same user are combined into one. Queued messages are posted without
+// returning their ID, so edits and deletes of them aren't relayed.
+type sendQueue struct {
+	sync.Mutex
+
+	log   *logrus.Entry
+	post  func(channelID string, msg *config.Message) (string, error)
+	rate  rate.Limit
+	burst int
+
+	limiters map[string]*rate.Limiter
+	pending  map[string][]*config.Message
+	flushing map[string]bool
+	// until is the end of the Retry-After of the last rate limited post
+	until time.Time
+}
+
+func newSendQueue(log *logrus.Entry, post func(channelID string, msg *config.Message) (string, error)) *sendQueue {
+	return &sendQueue{
+		log:      log,
+		post:     post,
+		rate:     postRate,
+		burst:    postBurst,
+		limiters: make(map[string]*rate.Limiter),
+		pending:  make(map[string][]*config.Message),
+		flushing: make(map[string]bool),
+	}
+}
+
+// limiter returns the limiter of the channel channelID, q must be locked.
+func (q *sendQueue) limiter(channelID string) *rate.Limiter {
+	lim, ok := q.limiters[channelID]
+	if !ok {
+		lim = rate.NewLimiter(q.rate, q.burst)
+		q.limiters[channelID] = lim
+	}
+	return lim
+}
+
+// send posts msg in the channel channelID when the rate limits allow it, otherwise msg is
+// queued. Replies in threads and messages with files aren't queued but wait for their turn.
+func (q *sendQueue) send(channelID string, msg *config.Message) (string, error) {
+	if msg.ParentID != "" || len(msg.Extra) > 0 {
+		return q.postWait(channelID, msg)
+	}
+
+	q.Lock()
+	if !q.flushing[channelID] && time.Now().After(q.until) {
+		r := q.limiter(channelID).Reserve()
+		if r.Delay() == 0 {
+			q.Unlock()
+			id, err := q.post(channelID, msg)
+			if !q.rateLimited(err) {
+				return id, err
+			}
+			q.Lock()
+		} else {
+			r.Cancel()
+		}
+	}
+	q.enqueue(channelID, msg)
+	q.Unlock()
+	return "", nil
+}
+
+// postWait posts msg in the channel channelID after waiting for the rate limits.
+func (q *sendQueue) postWait(channelID string, msg *config.Message) (string, error) {
+	for {
+		q.wait(channelID)
+		id, err := q.post(channelID, msg)
+		if !q.rateLimited(err) {
+			return id, err
+		}
+	}
+}
+
+// enqueue adds a copy of msg to the queue of the channel channelID and starts posting the
+// queue when it isn't yet, q must be locked.
+func (q *sendQueue) enqueue(channelID string, msg *config.Message) {
+	m := *msg
+	q.pending[channelID] = append(q.pending[channelID], &m)
+	if !q.flushing[channelID] {
+		q.flushing[channelID] = true
+		go q.flush(channelID)
+	}
+}
+
+// flush posts the queue of the channel channelID until it's empty.
+func (q *sendQueue) flush(channelID string) {
+	for {
+		q.wait(channelID)
+		q.Lock()
+		msgs := q.pending[channelID]
+		if len(msgs) == 0 {
+			delete(q.pending, channelID)
+			q.flushing[channelID] = false
+			q.Unlock()
+			return
+		}
+		msg, n := coalesce(msgs)
+		q.pending[channelID] = msgs[n:]
+		q.Unlock()
+
+		if n > 1 {
+			q.log.Debugf("Combined %d queued messages to %s", n, channelID)
+		}
+		_, err := q.post(channelID, msg)
+		if q.rateLimited(err) {
+			q.Lock()
+			q.pending[channelID] = append([]*config.Message{msg}, q.pending[channelID]...)
+			q.Unlock()
+			continue
+		}
+		if err != nil {
+			q.log.Errorf("Failed to post queued message to Slack: %s", err)
+		}
+	}
+}
+
+// wait waits for the Retry-After of Slack and the rate limit of the channel channelID.
+func (q *sendQueue) wait(channelID string) {
+	q.Lock()
+	until := q.until
+	lim := q.limiter(channelID)
+	q.Unlock()
+	if d := time.Until(until); d > 0 {
+		time.Sleep(d)
+	}
+	_ = lim.Wait(context.Background())
+}
+
+// rateLimited returns true when err is a rate limit error of Slack, of which it keeps the
+// Retry-After.
+func (q *sendQueue) rateLimited(err error) bool {
+	rateLimit, ok := err.(*slack.RateLimitedError)
+	if !ok {
+		return false
+	}
+	q.log.Infof("Rate-limited by Slack. Waiting for %v", rateLimit.RetryAfter)
+	q.Lock()
+	if until := time.Now().Add(rateLimit.RetryAfter); until.After(q.until) {
+		q.until = until
+	}
+	q.Unlock()
+	return true
+}
+
+// coalesce returns the first message of msgs combined with the messages after it from the
+// same user, and the number of messages it combined.
+func coalesce(msgs []*config.Message) (*config.Message, int) {
+	msg := *msgs[0]
+	n := 1
+	for ; n < len(msgs); n++ {
+		next := msgs[n]
+		if next.Username != msg.Username || next.Avatar != msg.Avatar || next.Event != msg.Event ||
+			len(msg.Text)+len(next.Text)+1 > messageLength {
+			break
+		}
+		msg.Text += "\n" + next.Text
+	}
+	return &msg, n
+}
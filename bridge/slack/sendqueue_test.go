@@ -0,0 +1,122 @@
+package bslack
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+// testPoster records the messages posted by a send queue.
+type testPoster struct {
+	sync.Mutex
+	posted []string
+	// limited is the number of posts to fail with a rate limit error
+	limited int
+	done    chan struct{}
+}
+
+func (p *testPoster) post(channelID string, msg *config.Message) (string, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.limited > 0 {
+		p.limited--
+		return "", &slack.RateLimitedError{RetryAfter: 20 * time.Millisecond}
+	}
+	p.posted = append(p.posted, msg.Text)
+	p.done <- struct{}{}
+	return "id" + msg.Text, nil
+}
+
+func (p *testPoster) wait(t *testing.T) {
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not posted")
+	}
+}
+
+func newTestQueue(p *testPoster) *sendQueue {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	q := newSendQueue(logrus.NewEntry(logger), p.post)
+	q.rate = rate.Every(50 * time.Millisecond)
+	q.burst = 1
+	return q
+}
+
+func TestSendQueueCoalesce(t *testing.T) {
+	p := &testPoster{done: make(chan struct{}, 10)}
+	q := newTestQueue(p)
+
+	id, err := q.send("C1", &config.Message{Text: "a", Username: "user"})
+	require.NoError(t, err)
+	assert.Equal(t, "ida", id)
+	p.wait(t)
+
+	// the burst is used, the next messages are queued and combined
+	for _, text := range []string{"b", "c"} {
+		id, err = q.send("C1", &config.Message{Text: text, Username: "user"})
+		require.NoError(t, err)
+		assert.Empty(t, id)
+	}
+	_, err = q.send("C1", &config.Message{Text: "d", Username: "other"})
+	require.NoError(t, err)
+
+	// other channels have their own limit
+	id, err = q.send("C2", &config.Message{Text: "e", Username: "user"})
+	require.NoError(t, err)
+	assert.Equal(t, "ide", id)
+
+	for i := 0; i < 3; i++ {
+		p.wait(t)
+	}
+	p.Lock()
+	defer p.Unlock()
+	assert.Equal(t, []string{"a", "e", "b\nc", "d"}, p.posted)
+}
+
+func TestSendQueueRetryAfter(t *testing.T) {
+	p := &testPoster{done: make(chan struct{}, 10), limited: 1}
+	q := newTestQueue(p)
+
+	// a rate limited message is queued and posted after the Retry-After
+	id, err := q.send("C1", &config.Message{Text: "a"})
+	require.NoError(t, err)
+	assert.Empty(t, id)
+	p.wait(t)
+
+	// replies wait for their turn instead of being queued
+	p.Lock()
+	p.limited = 1
+	p.Unlock()
+	id, err = q.send("C1", &config.Message{Text: "b", ParentID: "1234"})
+	require.NoError(t, err)
+	assert.Equal(t, "idb", id)
+	p.wait(t)
+	assert.Equal(t, []string{"a", "b"}, p.posted)
+}
+
+func TestCoalesce(t *testing.T) {
+	msgs := []*config.Message{
+		{Text: "a", Username: "user"},
+		{Text: "b", Username: "user"},
+		{Text: "c", Username: "user", Event: config.EventUserAction},
+	}
+	msg, n := coalesce(msgs)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "a\nb", msg.Text)
+	assert.Equal(t, "a", msgs[0].Text)
+
+	long := make([]byte, messageLength)
+	msg, n = coalesce([]*config.Message{{Text: "a"}, {Text: string(long)}})
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "a", msg.Text)
+}
@@ -1,7 +1,6 @@
 package bslack
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"strings"
@@ -11,6 +10,7 @@ import (
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/hook/signature"
 	"github.com/42wim/matterbridge/matterhook"
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/rs/xid"
@@ -21,10 +21,11 @@ type Bslack struct {
 	sync.RWMutex
 	*bridge.Config
 
-	mh  *matterhook.Client
-	sc  *slack.Client
-	rtm *slack.RTM
-	si  *slack.Info
+	mh     *matterhook.Client
+	sc     *slack.Client
+	rtm    *slack.RTM
+	socket *socketMode // receives the events instead of rtm when AppToken is set
+	si     *slack.Info
 
 	cache        *lru.Cache
 	uuid         string
@@ -33,6 +34,10 @@ type Bslack struct {
 	channels *channels
 	users    *users
 	legacy   bool
+	queue    *sendQueue
+	received *helper.ChannelQueue
+
+	connectedAt time.Time // when Connect was called with Token
 }
 
 const (
@@ -53,10 +58,12 @@ const (
 	sUserTyping          = "user_typing"
 	sLatencyReport       = "latency_report"
 	sSystemUser          = "system"
+	sBotMessage          = "bot_message"
 	sSlackBotUser        = "slackbot"
 	cfileDownloadChannel = "file_download_channel"
 
 	tokenConfig           = "Token"
+	appTokenConfig        = "AppToken"
 	incomingWebhookConfig = "WebhookBindAddress"
 	outgoingWebhookConfig = "WebhookURL"
 	skipTLSConfig         = "SkipTLSVerify"
@@ -66,12 +73,17 @@ const (
 	iconURLConfig         = "iconurl"
 	noSendJoinConfig      = "nosendjoinpart"
 	messageLength         = 3000
+
+	// rotatingTokenPrefix is the prefix of the tokens of apps with token rotation, which
+	// expire after rotatingTokenLifetime. The bridge doesn't refresh them.
+	rotatingTokenPrefix   = "xoxe."
+	rotatingTokenLifetime = 12 * time.Hour
 )
 
 func New(cfg *bridge.Config) bridge.Bridger {
 	// Print a deprecation warning for legacy non-bot tokens (#527).
 	token := cfg.GetString(tokenConfig)
-	if token != "" && !strings.HasPrefix(token, "xoxb") {
+	if token != "" && !strings.HasPrefix(strings.TrimPrefix(token, rotatingTokenPrefix), "xoxb") {
 		cfg.Log.Warn("Non-bot token detected. It is STRONGLY recommended to use a proper bot-token instead.")
 		cfg.Log.Warn("Legacy tokens may be deprecated by Slack at short notice. See the Matterbridge GitHub wiki for a migration guide.")
 		cfg.Log.Warn("See https://github.com/42wim/matterbridge/wiki/Slack-bot-setup")
@@ -81,7 +93,7 @@ func New(cfg *bridge.Config) bridge.Bridger {
 }
 
 func newBridge(cfg *bridge.Config) *Bslack {
-	newCache, err := lru.New(5000)
+	newCache, err := lru.New(helper.CacheSize(cfg.General, 5000))
 	if err != nil {
 		cfg.Log.Fatalf("Could not create LRU cache for Slack bridge: %v", err)
 	}
@@ -90,9 +102,20 @@ func newBridge(cfg *bridge.Config) *Bslack {
 		uuid:   xid.New().String(),
 		cache:  newCache,
 	}
+	b.queue = newSendQueue(cfg.Log, b.post)
+	b.received = helper.NewChannelQueue(cfg.Account)
 	return b
 }
 
+// CredentialsExpiry returns when a rotating token expires. The time it was issued isn't
+// known, it's counted from Connect.
+func (b *Bslack) CredentialsExpiry() (time.Time, bool) {
+	if !strings.HasPrefix(b.GetString(tokenConfig), rotatingTokenPrefix) || b.connectedAt.IsZero() {
+		return time.Time{}, false
+	}
+	return b.connectedAt.Add(rotatingTokenLifetime), true
+}
+
 func (b *Bslack) Command(cmd string) string {
 	return ""
 }
@@ -105,17 +128,31 @@ func (b *Bslack) Connect() error {
 		return errors.New("no connection method found: WebhookBindAddress, WebhookURL or Token need to be configured")
 	}
 
-	// If we have a token we use the Slack websocket-based RTM for both sending and receiving.
+	// If we have a token we use the Slack websocket-based RTM for both sending and receiving,
+	// or Socket Mode for receiving with the app-level token of apps that can't use RTM.
 	if token := b.GetString(tokenConfig); token != "" {
-		b.Log.Info("Connecting using token")
+		opts := []slack.Option{slack.OptionDebug(b.GetBool("Debug")), slack.OptionHTTPClient(b.HTTPClient(0))}
+		appToken := b.GetString(appTokenConfig)
+		if appToken != "" {
+			b.Log.Info("Connecting using token and Socket Mode")
+			opts = append(opts, slack.OptionAppLevelToken(appToken))
+		} else {
+			b.Log.Info("Connecting using token")
+		}
 
-		b.sc = slack.New(token, slack.OptionDebug(b.GetBool("Debug")))
+		b.sc = slack.New(token, opts...)
+		b.connectedAt = time.Now()
 
 		b.channels = newChannelManager(b.Log, b.sc)
 		b.users = newUserManager(b.Log, b.sc)
 
-		b.rtm = b.sc.NewRTM()
-		go b.rtm.ManageConnection()
+		if appToken != "" {
+			b.socket = newSocketMode(b.Log, b.sc, b.WebsocketDialer())
+			go b.socket.run()
+		} else {
+			b.rtm = b.sc.NewRTM(slack.RTMOptionDialer(b.WebsocketDialer()))
+			go b.rtm.ManageConnection()
+		}
 		go b.handleSlack()
 		return nil
 	}
@@ -136,12 +173,16 @@ func (b *Bslack) Connect() error {
 		b.Log.Info("Setting up local webhook for incoming messages.")
 		b.mh.BindAddress = b.GetString(incomingWebhookConfig)
 		b.mh.DisableServer = false
+		b.mh.Verifier = b.WebhookVerifier(signature.ModeSlack)
 		go b.handleSlack()
 	}
 	return nil
 }
 
 func (b *Bslack) Disconnect() error {
+	if b.socket != nil {
+		return b.socket.disconnect()
+	}
 	return b.rtm.Disconnect()
 }
 
@@ -186,8 +227,17 @@ func (b *Bslack) JoinChannel(channel config.ChannelInfo) error {
 	return nil
 }
 
-func (b *Bslack) Reload(cfg *bridge.Config) (string, error) {
-	return "", nil
+// SendDirect sends text as a private message to the user with userID.
+func (b *Bslack) SendDirect(userID, text string) error {
+	if b.sc == nil {
+		return fmt.Errorf("private messages need a slack token")
+	}
+	channel, _, _, err := b.sc.OpenConversation(&slack.OpenConversationParameters{Users: []string{userID}})
+	if err != nil {
+		return err
+	}
+	_, _, err = b.sc.PostMessage(channel.ID, slack.MsgOptionText(text, false))
+	return err
 }
 
 func (b *Bslack) Send(msg config.Message) (string, error) {
@@ -286,7 +336,8 @@ func (b *Bslack) sendRTM(msg config.Message) (string, error) {
 		return "", fmt.Errorf("could not send message: %v", err)
 	}
 	if msg.Event == config.EventUserTyping {
-		if b.GetBool("ShowUserTyping") {
+		// typing can only be sent with RTM
+		if b.GetBool("ShowUserTyping") && b.rtm != nil {
 			b.rtm.SendMessage(b.rtm.NewTypingMessage(channelInfo.ID))
 		}
 		return "", nil
@@ -299,6 +350,16 @@ func (b *Bslack) sendRTM(msg config.Message) (string, error) {
 		return "", err
 	}
 
+	// Handle reactions.
+	if handled, err = b.sendReaction(&msg, channelInfo); handled {
+		return "", err
+	}
+
+	// Replies are posted in the thread of the root message.
+	if msg.ThreadID != "" {
+		msg.ParentID = msg.ThreadID
+	}
+
 	// Handle prefix hint for unthreaded messages.
 	if msg.ParentNotFound() {
 		msg.ParentID = ""
@@ -345,9 +406,9 @@ func (b *Bslack) updateTopicOrPurpose(msg *config.Message, channelInfo *slack.Ch
 	incomingChangeType, text := b.extractTopicOrPurpose(msg.Text)
 	switch incomingChangeType {
 	case "topic":
-		updateFunc = b.rtm.SetTopicOfConversation
+		updateFunc = b.sc.SetTopicOfConversation
 	case "purpose":
-		updateFunc = b.rtm.SetPurposeOfConversation
+		updateFunc = b.sc.SetPurposeOfConversation
 	default:
 		b.Log.Errorf("Unhandled type received from extractTopicOrPurpose: %s", incomingChangeType)
 		return nil
@@ -382,6 +443,34 @@ func (b *Bslack) handleTopicOrPurpose(msg *config.Message, channelInfo *slack.Ch
 	return true, nil
 }
 
+// sendReaction adds or removes the reaction msg on the message msg.ParentID.
+func (b *Bslack) sendReaction(msg *config.Message, channelInfo *slack.Channel) (bool, error) {
+	if msg.Event != config.EventReactionAdd && msg.Event != config.EventReactionRemove {
+		return false, nil
+	}
+	name, ok := helper.EmojiShortcode(msg.Text)
+	if !ok || !msg.ParentValid() {
+		return true, nil
+	}
+	item := slack.NewRefToMessage(channelInfo.ID, msg.ParentID)
+	for {
+		var err error
+		if msg.Event == config.EventReactionRemove {
+			err = b.sc.RemoveReaction(name, item)
+		} else {
+			err = b.sc.AddReaction(name, item)
+		}
+		if err == nil {
+			return true, nil
+		}
+
+		if err = handleRateLimit(b.Log, err); err != nil {
+			b.Log.Errorf("Failed to send reaction %s to Slack: %#v", name, err)
+			return true, nil
+		}
+	}
+}
+
 func (b *Bslack) deleteMessage(msg *config.Message, channelInfo *slack.Channel) (bool, error) {
 	if msg.Event != config.EventMsgDelete {
 		return false, nil
@@ -393,7 +482,7 @@ func (b *Bslack) deleteMessage(msg *config.Message, channelInfo *slack.Channel)
 	}
 
 	for {
-		_, _, err := b.rtm.DeleteMessage(channelInfo.ID, msg.ID)
+		_, _, err := b.sc.DeleteMessage(channelInfo.ID, msg.ID)
 		if err == nil {
 			return true, nil
 		}
@@ -411,7 +500,7 @@ func (b *Bslack) editMessage(msg *config.Message, channelInfo *slack.Channel) (b
 	}
 	messageOptions := b.prepareMessageOptions(msg)
 	for {
-		_, _, _, err := b.rtm.UpdateMessage(channelInfo.ID, msg.ID, messageOptions...)
+		_, _, _, err := b.sc.UpdateMessage(channelInfo.ID, msg.ID, messageOptions...)
 		if err == nil {
 			return true, nil
 		}
@@ -428,18 +517,17 @@ func (b *Bslack) postMessage(msg *config.Message, channelInfo *slack.Channel) (s
 	if msg.Text == "" {
 		return "", nil
 	}
-	messageOptions := b.prepareMessageOptions(msg)
-	for {
-		_, id, err := b.rtm.PostMessage(channelInfo.ID, messageOptions...)
-		if err == nil {
-			return id, nil
-		}
-
-		if err = handleRateLimit(b.Log, err); err != nil {
-			b.Log.Errorf("Failed to sent user message to Slack: %#v", err)
-			return "", err
-		}
+	id, err := b.queue.send(channelInfo.ID, msg)
+	if err != nil {
+		b.Log.Errorf("Failed to sent user message to Slack: %#v", err)
 	}
+	return id, err
+}
+
+// post posts msg in the channel channelID, it's called by the send queue.
+func (b *Bslack) post(channelID string, msg *config.Message) (string, error) {
+	_, id, err := b.sc.PostMessage(channelID, b.prepareMessageOptions(msg)...)
+	return id, err
 }
 
 // uploadFile handles native upload of files
@@ -463,13 +551,22 @@ func (b *Bslack) uploadFile(msg *config.Message, channelID string) (string, erro
 		if fi.Comment != "" {
 			initialComment += fmt.Sprintf(" with comment: %s", fi.Comment)
 		}
-		res, err := b.sc.UploadFile(slack.FileUploadParameters{
-			Reader:          bytes.NewReader(*fi.Data),
+		r, err := fi.Open()
+		if err != nil {
+			b.Log.Errorf("uploadfile %#v", err)
+			return "", err
+		}
+		// files.upload is retired, v2 uploads the file and shares it in channelID afterwards
+		res, err := b.sc.UploadFileV2(slack.UploadFileV2Parameters{
+			Reader:          r,
+			FileSize:        int(fi.DataSize()),
 			Filename:        fi.Name,
-			Channels:        []string{channelID},
+			Title:           fi.Name,
+			Channel:         channelID,
 			InitialComment:  initialComment,
 			ThreadTimestamp: msg.ParentID,
 		})
+		r.Close()
 		if err != nil {
 			b.Log.Errorf("uploadfile %#v", err)
 			return "", err
@@ -477,19 +574,32 @@ func (b *Bslack) uploadFile(msg *config.Message, channelID string) (string, erro
 		if res.ID != "" {
 			b.Log.Debugf("Adding file ID %s to cache with timestamp %s", res.ID, ts.String())
 			b.cache.Add("file"+res.ID, ts)
-
-			// search for message id by uploaded file in private/public channels, get thread timestamp from uploaded file
-			if v, ok := res.Shares.Private[channelID]; ok && len(v) > 0 {
-				messageID = v[0].Ts
-			}
-			if v, ok := res.Shares.Public[channelID]; ok && len(v) > 0 {
-				messageID = v[0].Ts
+			if id := b.fileMessageID(res.ID, channelID); id != "" {
+				messageID = id
 			}
 		}
 	}
 	return messageID, nil
 }
 
+// fileMessageID returns the ID of the message that shares the uploaded file fileID in
+// channelID. The file may not be shared yet, then it's empty.
+func (b *Bslack) fileMessageID(fileID, channelID string) string {
+	file, _, _, err := b.sc.GetFileInfo(fileID, 0, 0)
+	if err != nil {
+		b.Log.Debugf("Could not get the shares of file %s: %s", fileID, err)
+		return ""
+	}
+	// search for message id by uploaded file in private/public channels, get thread timestamp from uploaded file
+	if v, ok := file.Shares.Public[channelID]; ok && len(v) > 0 {
+		return v[0].Ts
+	}
+	if v, ok := file.Shares.Private[channelID]; ok && len(v) > 0 {
+		return v[0].Ts
+	}
+	return ""
+}
+
 func (b *Bslack) prepareMessageOptions(msg *config.Message) []slack.MsgOption {
 	params := slack.NewPostMessageParameters()
 	if b.GetBool(useNickPrefixConfig) {
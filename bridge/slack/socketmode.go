@@ -0,0 +1,203 @@
+package bslack
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+const (
+	socketMinDelay = 2 * time.Second
+	socketMaxDelay = 2 * time.Minute
+)
+
+// socketMode receives the events of a slack app with Socket Mode, for the apps that can't
+// use RTM. The events are passed on the same way as the RTM events, see
+// https://api.slack.com/apis/connections/socket.
+type socketMode struct {
+	log    *logrus.Entry
+	sc     *slack.Client
+	dialer *websocket.Dialer
+	events chan slack.RTMEvent
+	stop   chan struct{}
+
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+// socketEnvelope is a message of the Socket Mode connection, envelopes with an ID have to be
+// acknowledged.
+type socketEnvelope struct {
+	Type       string          `json:"type"`
+	EnvelopeID string          `json:"envelope_id"`
+	Reason     string          `json:"reason"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+type socketAck struct {
+	EnvelopeID string `json:"envelope_id"`
+}
+
+// socketEventCallback is the payload of an events_api envelope.
+type socketEventCallback struct {
+	Type  string          `json:"type"`
+	Event json.RawMessage `json:"event"`
+}
+
+func newSocketMode(log *logrus.Entry, sc *slack.Client, dialer *websocket.Dialer) *socketMode {
+	return &socketMode{
+		log:    log,
+		sc:     sc,
+		dialer: dialer,
+		events: make(chan slack.RTMEvent, 50),
+		stop:   make(chan struct{}),
+	}
+}
+
+// run keeps a connection open until disconnect is called, slack asks apps to reconnect now
+// and then.
+func (s *socketMode) run() {
+	delay := socketMinDelay
+	for {
+		err := s.connect()
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		if err == nil {
+			delay = socketMinDelay
+			continue
+		}
+		s.log.Errorf("Socket Mode connection failed, reconnecting in %s: %s", delay, err)
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > socketMaxDelay {
+			delay = socketMaxDelay
+		}
+	}
+}
+
+// connect opens a connection and passes its events on until slack closes it.
+func (s *socketMode) connect() error {
+	_, wsURL, err := s.sc.StartSocketModeContext(context.Background())
+	if err != nil {
+		return err
+	}
+	conn, _, err := s.dialer.Dial(wsURL, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	// disconnect may have been called before s.conn was set
+	select {
+	case <-s.stop:
+		return nil
+	default:
+	}
+
+	for {
+		var env socketEnvelope
+		if err := conn.ReadJSON(&env); err != nil {
+			return err
+		}
+		if env.EnvelopeID != "" {
+			if err := conn.WriteJSON(socketAck{EnvelopeID: env.EnvelopeID}); err != nil {
+				return err
+			}
+		}
+		switch env.Type {
+		case "hello":
+			info, err := s.info()
+			if err != nil {
+				return err
+			}
+			if !s.send(slack.RTMEvent{Type: "connected", Data: &slack.ConnectedEvent{Info: info}}) {
+				return nil
+			}
+		case "disconnect":
+			s.log.Debugf("Socket Mode connection closed by slack: %s", env.Reason)
+			return nil
+		case "events_api":
+			if ev, ok := parseSocketEvent(env.Payload); ok && !s.send(ev) {
+				return nil
+			}
+		}
+	}
+}
+
+// send passes ev on, it returns false when disconnect was called before ev could be passed.
+func (s *socketMode) send(ev slack.RTMEvent) bool {
+	select {
+	case s.events <- ev:
+		return true
+	case <-s.stop:
+		return false
+	}
+}
+
+// info returns the user and team of the app, RTM sends them when it connects.
+func (s *socketMode) info() (*slack.Info, error) {
+	auth, err := s.sc.AuthTest()
+	if err != nil {
+		return nil, err
+	}
+	info := &slack.Info{
+		URL:  auth.URL,
+		User: &slack.UserDetails{ID: auth.UserID, Name: auth.User},
+		Team: &slack.Team{ID: auth.TeamID, Name: auth.Team},
+	}
+	if u, err := url.Parse(auth.URL); err == nil {
+		info.Team.Domain = strings.SplitN(u.Hostname(), ".", 2)[0]
+	}
+	return info, nil
+}
+
+// disconnect closes the connection and stops reconnecting.
+func (s *socketMode) disconnect() error {
+	close(s.stop)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// parseSocketEvent returns the event of the events_api payload as the RTM event of its type,
+// the events API uses the same events.
+func parseSocketEvent(payload json.RawMessage) (slack.RTMEvent, bool) {
+	var callback socketEventCallback
+	if err := json.Unmarshal(payload, &callback); err != nil || callback.Type != "event_callback" {
+		return slack.RTMEvent{}, false
+	}
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(callback.Event, &event); err != nil {
+		return slack.RTMEvent{}, false
+	}
+	v, ok := slack.EventMapping[event.Type]
+	if !ok {
+		return slack.RTMEvent{}, false
+	}
+	data := reflect.New(reflect.TypeOf(v)).Interface()
+	if err := json.Unmarshal(callback.Event, data); err != nil {
+		return slack.RTMEvent{}, false
+	}
+	return slack.RTMEvent{Type: event.Type, Data: data}, true
+}
@@ -0,0 +1,98 @@
+package bslack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSocketEvent(t *testing.T) {
+	ev, ok := parseSocketEvent(json.RawMessage(`{"type":"event_callback","event":{"type":"message","channel":"C1","user":"U1","text":"hello","ts":"1.2"}}`))
+	require.True(t, ok)
+	assert.Equal(t, "message", ev.Type)
+	msg, ok := ev.Data.(*slack.MessageEvent)
+	require.True(t, ok)
+	assert.Equal(t, "C1", msg.Channel)
+	assert.Equal(t, "U1", msg.User)
+	assert.Equal(t, "hello", msg.Text)
+	assert.Equal(t, "1.2", msg.Timestamp)
+
+	ev, ok = parseSocketEvent(json.RawMessage(`{"type":"event_callback","event":{"type":"message","subtype":"message_changed","channel":"C1","message":{"user":"U1","text":"edited","ts":"1.2"}}}`))
+	require.True(t, ok)
+	msg = ev.Data.(*slack.MessageEvent)
+	assert.Equal(t, "message_changed", msg.SubType)
+	require.NotNil(t, msg.SubMessage)
+	assert.Equal(t, "edited", msg.SubMessage.Text)
+
+	ev, ok = parseSocketEvent(json.RawMessage(`{"type":"event_callback","event":{"type":"reaction_added","user":"U1","reaction":"smile","item":{"type":"message","channel":"C1","ts":"1.2"}}}`))
+	require.True(t, ok)
+	reaction, ok := ev.Data.(*slack.ReactionAddedEvent)
+	require.True(t, ok)
+	assert.Equal(t, "smile", reaction.Reaction)
+	assert.Equal(t, "1.2", reaction.Item.Timestamp)
+
+	_, ok = parseSocketEvent(json.RawMessage(`{"type":"url_verification","challenge":"abc"}`))
+	assert.False(t, ok)
+	_, ok = parseSocketEvent(json.RawMessage(`{"type":"event_callback","event":{"type":"no_such_event"}}`))
+	assert.False(t, ok)
+	_, ok = parseSocketEvent(json.RawMessage(`not json`))
+	assert.False(t, ok)
+}
+
+func TestSocketModeDisconnect(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/apps.connections.open" {
+			fmt.Fprintf(w, `{"ok":true,"url":"ws%s/ws"}`, strings.TrimPrefix(srv.URL, "http"))
+			return
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// more events than fit in the channel, nobody reads them
+		for i := 0; i < 100; i++ {
+			err := conn.WriteJSON(map[string]interface{}{
+				"type":    "events_api",
+				"payload": json.RawMessage(`{"type":"event_callback","event":{"type":"message","channel":"C1","text":"hello"}}`),
+			})
+			if err != nil {
+				return
+			}
+		}
+		// keep the connection open until the client closes it
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	sc := slack.New("xapp-token", slack.OptionAPIURL(srv.URL+"/"))
+	s := newSocketMode(logrus.NewEntry(logrus.New()), sc, websocket.DefaultDialer)
+	done := make(chan struct{})
+	go func() {
+		s.run()
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return len(s.events) == cap(s.events) }, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, s.disconnect())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't return after disconnect")
+	}
+}
@@ -151,15 +151,8 @@ func (b *Bsshchat) handleSSHChat() error {
 
 func (b *Bsshchat) handleUploadFile(msg *config.Message) (string, error) {
 	for _, f := range msg.Extra["file"] {
-		fi := f.(config.FileInfo)
-		if fi.Comment != "" {
-			msg.Text += fi.Comment + ": "
-		}
-		if fi.URL != "" {
-			msg.Text = fi.URL
-			if fi.Comment != "" {
-				msg.Text = fi.Comment + ": " + fi.URL
-			}
+		if text := helper.FileText(f.(config.FileInfo)); text != "" {
+			msg.Text = text
 		}
 		if _, err := b.w.Write([]byte(msg.Username + msg.Text + "\r\n")); err != nil {
 			b.Log.Errorf("Could not send file message: %#v", err)
@@ -1,10 +1,14 @@
 package bsteam
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
+	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
 	"github.com/Philipp15b/go-steam"
 	"github.com/Philipp15b/go-steam/protocol/steamlang"
 )
@@ -86,17 +90,11 @@ func (b *Bsteam) handleEvents() {
 func (b *Bsteam) handleLogOnFailed(e *steam.LogOnFailedEvent, myLoginInfo *steam.LogOnDetails) error {
 	switch e.Result {
 	case steamlang.EResult_AccountLoginDeniedNeedTwoFactor:
-		b.Log.Info("Steam guard isn't letting me in! Enter 2FA code:")
-		var code string
-		fmt.Scanf("%s", &code)
 		// TODO https://github.com/42wim/matterbridge/pull/630#discussion_r238103978
-		myLoginInfo.TwoFactorCode = code
+		myLoginInfo.TwoFactorCode = b.loginCode("Steam guard isn't letting me in! Enter 2FA code:")
 	case steamlang.EResult_AccountLogonDenied:
-		b.Log.Info("Steam guard isn't letting me in! Enter auth code:")
-		var code string
-		fmt.Scanf("%s", &code)
 		// TODO https://github.com/42wim/matterbridge/pull/630#discussion_r238103978
-		myLoginInfo.AuthCode = code
+		myLoginInfo.AuthCode = b.loginCode("Steam guard isn't letting me in! Enter auth code:")
 	case steamlang.EResult_InvalidLoginAuthCode:
 		return fmt.Errorf("Steam guard: invalid login auth code: %#v ", e.Result)
 	default:
@@ -106,21 +104,37 @@ func (b *Bsteam) handleLogOnFailed(e *steam.LogOnFailedEvent, myLoginInfo *steam
 	return nil
 }
 
+// loginCode asks for a steam guard code in the login channel, or on the console when
+// there's none.
+func (b *Bsteam) loginCode(prompt string) string {
+	if b.Login != nil {
+		// Connect waits for the answer
+		select {
+		case b.prompting <- struct{}{}:
+		default:
+		}
+		code, err := b.Login.LoginPrompt(b.Account, prompt, loginTimeout)
+		if err == nil {
+			return strings.TrimSpace(code)
+		}
+		if !errors.Is(err, bridge.ErrNoLoginChannel) {
+			b.Log.Errorf("login prompt failed, enter the code on the console: %s", err)
+		}
+	}
+	b.Log.Info(prompt)
+	var code string
+	fmt.Scanf("%s", &code)
+	return code
+}
+
 // handleFileInfo handles config.FileInfo and adds correct file comment or URL to msg.Text.
 // Returns error if cast fails.
 func (b *Bsteam) handleFileInfo(msg *config.Message, f interface{}) error {
 	if _, ok := f.(config.FileInfo); !ok {
 		return fmt.Errorf("handleFileInfo cast failed %#v", f)
 	}
-	fi := f.(config.FileInfo)
-	if fi.Comment != "" {
-		msg.Text += fi.Comment + ": "
-	}
-	if fi.URL != "" {
-		msg.Text = fi.URL
-		if fi.Comment != "" {
-			msg.Text = fi.Comment + ": " + fi.URL
-		}
+	if text := helper.FileText(f.(config.FileInfo)); text != "" {
+		msg.Text = text
 	}
 	return nil
 }
@@ -13,9 +13,13 @@ import (
 	"github.com/Philipp15b/go-steam/steamid"
 )
 
+// loginTimeout is how long Connect waits for the answer to a steam guard prompt.
+const loginTimeout = 10 * time.Minute
+
 type Bsteam struct {
 	c         *steam.Client
 	connected chan struct{}
+	prompting chan struct{} // a steam guard code is asked in the login channel
 	userMap   map[steamid.SteamId]string
 	sync.RWMutex
 	*bridge.Config
@@ -25,6 +29,7 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	b := &Bsteam{Config: cfg}
 	b.userMap = make(map[steamid.SteamId]string)
 	b.connected = make(chan struct{})
+	b.prompting = make(chan struct{}, 1)
 	return b
 }
 
@@ -33,13 +38,18 @@ func (b *Bsteam) Connect() error {
 	b.c = steam.NewClient()
 	go b.handleEvents()
 	go b.c.Connect()
-	select {
-	case <-b.connected:
-		b.Log.Info("Connection succeeded")
-	case <-time.After(time.Second * 30):
-		return fmt.Errorf("connection timed out")
+	timeout := time.After(time.Second * 30)
+	for {
+		select {
+		case <-b.connected:
+			b.Log.Info("Connection succeeded")
+			return nil
+		case <-b.prompting:
+			timeout = time.After(loginTimeout)
+		case <-timeout:
+			return fmt.Errorf("connection timed out")
+		}
 	}
-	return nil
 }
 
 func (b *Bsteam) Disconnect() error {
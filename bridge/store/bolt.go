@@ -0,0 +1,157 @@
+package store
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltOpenTimeout is how long NewBolt waits for the lock of a database used by another
+// matterbridge.
+const boltOpenTimeout = 5 * time.Second
+
+// Bolt is an embedded Store backed by a bbolt database, the data is kept on disk and only
+// read when it's used. Every bucket of the Store is a bbolt bucket, the values are prefixed
+// with their expiry.
+type Bolt struct {
+	db *bolt.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewBolt opens (or creates) the bbolt database at path.
+func NewBolt(path string) (*Bolt, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	s := &Bolt{db: db, lastSweep: time.Now()}
+	if err := s.sweep(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// boltValue returns value prefixed with its expiry.
+func boltValue(value []byte, expires int64) []byte {
+	data := make([]byte, 8+len(value))
+	binary.BigEndian.PutUint64(data, uint64(expires))
+	copy(data[8:], value)
+	return data
+}
+
+// boltExpires returns the expiry of data, a value written by boltValue.
+func boltExpires(data []byte) int64 {
+	if len(data) < 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(data))
+}
+
+func (s *Bolt) Get(bucket, key string) ([]byte, error) {
+	var res []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return ErrNotFound
+		}
+		data := b.Get([]byte(key))
+		if len(data) < 8 || expired(boltExpires(data), time.Now().UnixNano()) {
+			return ErrNotFound
+		}
+		// data is only valid during the transaction
+		res = append([]byte{}, data[8:]...)
+		return nil
+	})
+	return res, err
+}
+
+func (s *Bolt) Set(bucket, key string, value []byte, ttl time.Duration) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), boltValue(value, expiry(ttl)))
+	})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	due := time.Since(s.lastSweep) >= sweepInterval
+	if due {
+		s.lastSweep = time.Now()
+	}
+	s.mu.Unlock()
+	if due {
+		return s.sweep()
+	}
+	return nil
+}
+
+func (s *Bolt) Delete(bucket, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (s *Bolt) Keys(bucket string) ([]string, error) {
+	keys := []string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		now := time.Now().UnixNano()
+		// the keys are sorted by bbolt
+		return b.ForEach(func(k, v []byte) error {
+			if !expired(boltExpires(v), now) {
+				keys = append(keys, string(k))
+			}
+			return nil
+		})
+	})
+	return keys, err
+}
+
+func (s *Bolt) Close() error {
+	return s.db.Close()
+}
+
+// sweep removes the expired keys.
+func (s *Bolt) sweep() error {
+	now := time.Now().UnixNano()
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			var keys [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				if expired(boltExpires(v), now) {
+					keys = append(keys, append([]byte{}, k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range keys {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
@@ -0,0 +1,210 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// File is an embedded Store that keeps its data in memory and persists every
+// change to an append-only log on disk. The log is compacted when it's opened and
+// when it has grown to twice the live keys, dropping the expired ones. Unlike Bolt
+// all the data is in memory, it's faster for small stores.
+type File struct {
+	*Memory
+
+	mu        sync.Mutex // held across the change of Memory and its record, so they're in order
+	path      string
+	f         *os.File
+	w         *bufio.Writer
+	records   int // in the log
+	compacted int // records written by the last compaction
+}
+
+type fileRecord struct {
+	Op      string `json:"op"`
+	Bucket  string `json:"b"`
+	Key     string `json:"k"`
+	Value   []byte `json:"v,omitempty"`
+	Expires int64  `json:"e,omitempty"`
+}
+
+const (
+	fileOpSet    = "set"
+	fileOpDelete = "del"
+
+	// compactMinRecords are appended at least before the log is compacted again
+	compactMinRecords = 1000
+)
+
+// NewFile opens (or creates) the store at path.
+func NewFile(path string) (*File, error) {
+	s := &File{
+		Memory: NewMemory(),
+		path:   path,
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *File) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := time.Now().UnixNano()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var rec fileRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// a partially written last line after a crash, skip it
+			continue
+		}
+		switch rec.Op {
+		case fileOpSet:
+			if expired(rec.Expires, now) {
+				_ = s.Memory.Delete(rec.Bucket, rec.Key)
+				continue
+			}
+			s.Memory.setExpires(rec.Bucket, rec.Key, rec.Value, rec.Expires)
+		case fileOpDelete:
+			_ = s.Memory.Delete(rec.Bucket, rec.Key)
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the log with only the live keys and opens it for appending, the caller
+// must hold s.mu.
+func (s *File) compact() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	records := 0
+	now := time.Now().UnixNano()
+	s.Memory.RLock()
+	for bucket, entries := range s.Memory.buckets {
+		for key, e := range entries {
+			if expired(e.expires, now) {
+				continue
+			}
+			records++
+			if err := enc.Encode(fileRecord{Op: fileOpSet, Bucket: bucket, Key: key, Value: e.value, Expires: e.expires}); err != nil {
+				s.Memory.RUnlock()
+				f.Close()
+				return err
+			}
+		}
+	}
+	s.Memory.RUnlock()
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	if s.f != nil {
+		s.f.Close()
+	}
+	s.f, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		s.w = nil
+		return err
+	}
+	s.w = bufio.NewWriter(s.f)
+	s.records, s.compacted = records, records
+	return nil
+}
+
+// append writes rec to the log, the caller must hold s.mu.
+func (s *File) append(rec fileRecord) error {
+	if s.w == nil {
+		return fmt.Errorf("store %s is closed", s.path)
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.w.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	s.records++
+	return nil
+}
+
+// maybeCompact compacts the log when it has grown to twice the live keys, the caller must
+// hold s.mu.
+func (s *File) maybeCompact() error {
+	if s.records >= 2*s.compacted+compactMinRecords {
+		return s.compact()
+	}
+	return nil
+}
+
+// Set changes Memory once the change is in the log, so a failed write doesn't leave a value
+// that isn't on disk.
+func (s *File) Set(bucket, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expires := expiry(ttl)
+	if err := s.append(fileRecord{Op: fileOpSet, Bucket: bucket, Key: key, Value: value, Expires: expires}); err != nil {
+		return err
+	}
+	s.Memory.setExpires(bucket, key, value, expires)
+	return s.maybeCompact()
+}
+
+func (s *File) Delete(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(fileRecord{Op: fileOpDelete, Bucket: bucket, Key: key}); err != nil {
+		return err
+	}
+	_ = s.Memory.Delete(bucket, key)
+	return s.maybeCompact()
+}
+
+func (s *File) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.w == nil {
+		return nil
+	}
+	err := s.w.Flush()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.w = nil
+	return err
+}
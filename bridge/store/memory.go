@@ -0,0 +1,98 @@
+package store
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often expired keys get removed from memory.
+const sweepInterval = time.Minute
+
+type entry struct {
+	value   []byte
+	expires int64
+}
+
+// Memory is a Store that keeps everything in memory, it's the default backend.
+type Memory struct {
+	sync.RWMutex
+
+	buckets   map[string]map[string]entry
+	lastSweep time.Time
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		buckets:   make(map[string]map[string]entry),
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *Memory) Get(bucket, key string) ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+	e, ok := m.buckets[bucket][key]
+	if !ok || expired(e.expires, time.Now().UnixNano()) {
+		return nil, ErrNotFound
+	}
+	// the caller may change it
+	return append([]byte{}, e.value...), nil
+}
+
+func (m *Memory) Set(bucket, key string, value []byte, ttl time.Duration) error {
+	m.setExpires(bucket, key, value, expiry(ttl))
+	return nil
+}
+
+func (m *Memory) setExpires(bucket, key string, value []byte, expires int64) {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.buckets[bucket]; !ok {
+		m.buckets[bucket] = make(map[string]entry)
+	}
+	m.buckets[bucket][key] = entry{value: append([]byte{}, value...), expires: expires}
+	m.sweep()
+}
+
+func (m *Memory) Delete(bucket, key string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.buckets[bucket], key)
+	return nil
+}
+
+func (m *Memory) Keys(bucket string) ([]string, error) {
+	m.RLock()
+	defer m.RUnlock()
+	now := time.Now().UnixNano()
+	keys := make([]string, 0, len(m.buckets[bucket]))
+	for k, e := range m.buckets[bucket] {
+		if !expired(e.expires, now) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+// sweep removes expired keys, the caller must hold the lock.
+func (m *Memory) sweep() {
+	if time.Since(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = time.Now()
+	now := m.lastSweep.UnixNano()
+	for _, b := range m.buckets {
+		for k, e := range b {
+			if expired(e.expires, now) {
+				delete(b, k)
+			}
+		}
+	}
+}
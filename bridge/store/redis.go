@@ -0,0 +1,238 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redisKeyPrefix   = "matterbridge:"
+	redisDialTimeout = 5 * time.Second
+	redisIOTimeout   = 5 * time.Second
+)
+
+// Redis is a Store backed by a redis server, useful when running multiple
+// matterbridge instances or when the data must outlive the host.
+//
+// It speaks just enough RESP to do GET/SET/DEL/SCAN and reconnects on errors.
+type Redis struct {
+	sync.Mutex
+
+	address  string
+	password string
+	db       int
+
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewRedis connects to the redis server at address (host:port).
+func NewRedis(address, password string, db int) (*Redis, error) {
+	s := &Redis{
+		address:  address,
+		password: password,
+		db:       db,
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Redis) connect() error {
+	conn, err := net.DialTimeout("tcp", s.address, redisDialTimeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.r = bufio.NewReader(conn)
+	if s.password != "" {
+		if _, err := s.do("AUTH", s.password); err != nil {
+			s.closeConn()
+			return err
+		}
+	}
+	if s.db != 0 {
+		if _, err := s.do("SELECT", strconv.Itoa(s.db)); err != nil {
+			s.closeConn()
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Redis) closeConn() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn = nil
+	s.r = nil
+}
+
+// cmd runs a command, reconnecting once if the connection went away.
+func (s *Redis) cmd(args ...string) (interface{}, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+	res, err := s.do(args...)
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		s.closeConn()
+		if err = s.connect(); err != nil {
+			return nil, err
+		}
+		res, err = s.do(args...)
+	}
+	return res, err
+}
+
+func (s *Redis) do(args ...string) (interface{}, error) {
+	_ = s.conn.SetDeadline(time.Now().Add(redisIOTimeout))
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(s.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return s.readReply()
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func (s *Redis) readLine() (string, error) {
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+func (s *Redis) readReply() (interface{}, error) {
+	line, err := s.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(s.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		res := make([]interface{}, n)
+		for i := range res {
+			if res[i], err = s.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply %q", line)
+}
+
+func redisKey(bucket, key string) string {
+	return redisKeyPrefix + bucket + ":" + key
+}
+
+func (s *Redis) Get(bucket, key string) ([]byte, error) {
+	res, err := s.cmd("GET", redisKey(bucket, key))
+	if err != nil {
+		return nil, err
+	}
+	data, ok := res.([]byte)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
+
+func (s *Redis) Set(bucket, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", redisKey(bucket, key), string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := s.cmd(args...)
+	return err
+}
+
+func (s *Redis) Delete(bucket, key string) error {
+	_, err := s.cmd("DEL", redisKey(bucket, key))
+	return err
+}
+
+func (s *Redis) Keys(bucket string) ([]string, error) {
+	prefix := redisKey(bucket, "")
+	var keys []string
+	cursor := "0"
+	for {
+		res, err := s.cmd("SCAN", cursor, "MATCH", prefix+"*", "COUNT", "1000")
+		if err != nil {
+			return nil, err
+		}
+		reply, ok := res.([]interface{})
+		if !ok || len(reply) != 2 {
+			return nil, fmt.Errorf("redis: unexpected SCAN reply %#v", res)
+		}
+		next, _ := reply[0].([]byte)
+		cursor = string(next)
+		entries, _ := reply[1].([]interface{})
+		for _, e := range entries {
+			if k, ok := e.([]byte); ok {
+				keys = append(keys, strings.TrimPrefix(string(k), prefix))
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			return keys, nil
+		}
+	}
+}
+
+func (s *Redis) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.closeConn()
+	return nil
+}
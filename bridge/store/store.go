@@ -0,0 +1,145 @@
+// Package store provides the key-value storage shared by the persistence
+// features of matterbridge (avatar cache, message map, dedup window, ...).
+//
+// All keys live in a bucket, which is a simple namespace so that different
+// features (and different bridges) don't step on each others keys.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const (
+	BackendMemory = "memory"
+	BackendBolt   = "bbolt"
+	BackendFile   = "file"
+	BackendRedis  = "redis"
+)
+
+// ErrNotFound is returned by Get when the key doesn't exist (or has expired).
+var ErrNotFound = errors.New("store: key not found")
+
+// Store is the interface every storage backend implements.
+type Store interface {
+	// Get returns the value of key in bucket or ErrNotFound.
+	Get(bucket, key string) ([]byte, error)
+	// Set stores value under key in bucket. A ttl of 0 means the key never expires.
+	Set(bucket, key string, value []byte, ttl time.Duration) error
+	// Delete removes key from bucket, deleting a non-existing key is not an error.
+	Delete(bucket, key string) error
+	// Keys returns all the (non-expired) keys of bucket.
+	Keys(bucket string) ([]string, error)
+	// Close flushes and releases the resources held by the backend.
+	Close() error
+}
+
+// New creates the store configured with the Store* keys of the [general] section.
+func New(general *config.Protocol) (Store, error) {
+	switch strings.ToLower(general.StoreBackend) {
+	case "", BackendMemory:
+		return NewMemory(), nil
+	case BackendBolt:
+		if general.StorePath == "" {
+			return nil, fmt.Errorf("StoreBackend %s needs a StorePath", BackendBolt)
+		}
+		return NewBolt(general.StorePath)
+	case BackendFile:
+		if general.StorePath == "" {
+			return nil, fmt.Errorf("StoreBackend %s needs a StorePath", BackendFile)
+		}
+		return NewFile(general.StorePath)
+	case BackendRedis:
+		if general.StoreRedisAddress == "" {
+			return nil, fmt.Errorf("StoreBackend %s needs a StoreRedisAddress", BackendRedis)
+		}
+		return NewRedis(general.StoreRedisAddress, general.StoreRedisPassword, general.StoreRedisDB)
+	}
+	return nil, fmt.Errorf("unknown StoreBackend %s", general.StoreBackend)
+}
+
+// IsPersistent returns true if the configured backend survives a restart.
+func IsPersistent(general *config.Protocol) bool {
+	backend := strings.ToLower(general.StoreBackend)
+	return backend != "" && backend != BackendMemory
+}
+
+// Bucket is a convenience wrapper around a single bucket of a Store,
+// mostly used for simple string caches.
+type Bucket struct {
+	store Store
+	name  string
+}
+
+// NewBucket returns a Bucket named name backed by s.
+func NewBucket(s Store, name string) *Bucket {
+	return &Bucket{store: s, name: name}
+}
+
+// Name returns the name of the bucket.
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+// GetString returns the value for key and if it was found.
+func (b *Bucket) GetString(key string) (string, bool) {
+	if b == nil || b.store == nil {
+		return "", false
+	}
+	res, err := b.store.Get(b.name, key)
+	if err != nil {
+		return "", false
+	}
+	return string(res), true
+}
+
+// SetString sets key to value without expiry.
+func (b *Bucket) SetString(key, value string) error {
+	return b.SetStringTTL(key, value, 0)
+}
+
+// SetStringTTL sets key to value, expiring after ttl.
+func (b *Bucket) SetStringTTL(key, value string, ttl time.Duration) error {
+	if b == nil || b.store == nil {
+		return nil
+	}
+	return b.store.Set(b.name, key, []byte(value), ttl)
+}
+
+// Contains returns true if key exists in the bucket.
+func (b *Bucket) Contains(key string) bool {
+	_, ok := b.GetString(key)
+	return ok
+}
+
+// Delete removes key from the bucket.
+func (b *Bucket) Delete(key string) error {
+	if b == nil || b.store == nil {
+		return nil
+	}
+	return b.store.Delete(b.name, key)
+}
+
+// Keys returns all keys in the bucket.
+func (b *Bucket) Keys() []string {
+	if b == nil || b.store == nil {
+		return nil
+	}
+	keys, _ := b.store.Keys(b.name)
+	return keys
+}
+
+func expiry(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixNano()
+}
+
+func expired(expires int64, now int64) bool {
+	return expires != 0 && expires <= now
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T, s Store) {
+	_, err := s.Get("b", "missing")
+	assert.Equal(t, ErrNotFound, err)
+
+	require.NoError(t, s.Set("b", "k1", []byte("v1"), 0))
+	require.NoError(t, s.Set("b", "k2", []byte("v2"), 0))
+	require.NoError(t, s.Set("other", "k1", []byte("o1"), 0))
+
+	res, err := s.Get("b", "k1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v1"), res)
+
+	keys, err := s.Keys("b")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"k1", "k2"}, keys)
+
+	require.NoError(t, s.Delete("b", "k1"))
+	_, err = s.Get("b", "k1")
+	assert.Equal(t, ErrNotFound, err)
+
+	require.NoError(t, s.Set("b", "ttl", []byte("gone"), time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	_, err = s.Get("b", "ttl")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	s, err := NewFile(path)
+	require.NoError(t, err)
+	testStore(t, s)
+	require.NoError(t, s.Close())
+
+	// reopen and check that the data survived
+	s, err = NewFile(path)
+	require.NoError(t, err)
+	defer s.Close()
+	res, err := s.Get("b", "k2")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), res)
+	_, err = s.Get("b", "k1")
+	assert.Equal(t, ErrNotFound, err)
+	res, err = s.Get("other", "k1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("o1"), res)
+}
+
+func TestMemoryCopies(t *testing.T) {
+	s := NewMemory()
+	value := []byte("v1")
+	require.NoError(t, s.Set("b", "k", value, 0))
+	value[0] = 'x'
+	res, err := s.Get("b", "k")
+	require.NoError(t, err)
+	res[1] = 'x'
+	res, err = s.Get("b", "k")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v1"), res)
+}
+
+func TestBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	s, err := NewBolt(path)
+	require.NoError(t, err)
+	testStore(t, s)
+	require.NoError(t, s.Set("ttl", "k", []byte("gone"), time.Nanosecond))
+	require.NoError(t, s.Close())
+
+	// reopen and check that the data survived, without the expired keys
+	s, err = NewBolt(path)
+	require.NoError(t, err)
+	defer s.Close()
+	res, err := s.Get("b", "k2")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v2"), res)
+	_, err = s.Get("b", "k1")
+	assert.Equal(t, ErrNotFound, err)
+	keys, err := s.Keys("ttl")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	keys, err = s.Keys("missing")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestFileFailedWrite(t *testing.T) {
+	s, err := NewFile(filepath.Join(t.TempDir(), "store.db"))
+	require.NoError(t, err)
+	require.NoError(t, s.Set("b", "kept", []byte("v"), 0))
+	require.NoError(t, s.Close())
+
+	// the changes that aren't written aren't visible either
+	assert.Error(t, s.Set("b", "k", []byte("v"), 0))
+	_, err = s.Get("b", "k")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Error(t, s.Delete("b", "kept"))
+	res, err := s.Get("b", "kept")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v"), res)
+}
+
+func TestFileCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	s, err := NewFile(path)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Set("b", "kept", []byte("v"), 0))
+	for i := 0; i < 3*compactMinRecords; i++ {
+		require.NoError(t, s.Set("b", "k", []byte(strconv.Itoa(i)), 0))
+		require.NoError(t, s.Set("ttl", strconv.Itoa(i), []byte("gone"), time.Nanosecond))
+	}
+	// compacted while running, without the expired keys
+	assert.Less(t, s.records, compactMinRecords+10)
+	data, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, s.records, bytes.Count(data, []byte("\n")))
+	require.NoError(t, s.Close())
+
+	s, err = NewFile(path)
+	require.NoError(t, err)
+	res, err := s.Get("b", "k")
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(3*compactMinRecords-1), string(res))
+	_, err = s.Get("b", "kept")
+	assert.NoError(t, err)
+}
+
+// TestFileOrder changes the same key concurrently, the log must end with the value kept in
+// memory.
+func TestFileOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.db")
+	s, err := NewFile(path)
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if j%3 == 0 {
+					assert.NoError(t, s.Delete("b", "k"))
+					continue
+				}
+				assert.NoError(t, s.Set("b", "k", []byte(strconv.Itoa(i*100+j)), 0))
+			}
+		}(i)
+	}
+	wg.Wait()
+	want, wantErr := s.Get("b", "k")
+	require.NoError(t, s.Close())
+
+	s, err = NewFile(path)
+	require.NoError(t, err)
+	defer s.Close()
+	got, err := s.Get("b", "k")
+	assert.Equal(t, wantErr, err)
+	assert.Equal(t, want, got)
+}
+
+func TestBucket(t *testing.T) {
+	b := NewBucket(NewMemory(), "avatar")
+	assert.False(t, b.Contains("user"))
+	require.NoError(t, b.SetString("user", "sha"))
+	res, ok := b.GetString("user")
+	assert.True(t, ok)
+	assert.Equal(t, "sha", res)
+
+	var nilBucket *Bucket
+	assert.False(t, nilBucket.Contains("user"))
+}
+
+func TestNew(t *testing.T) {
+	s, err := New(&config.Protocol{})
+	require.NoError(t, err)
+	assert.IsType(t, &Memory{}, s)
+
+	_, err = New(&config.Protocol{StoreBackend: "file"})
+	assert.Error(t, err)
+
+	_, err = New(&config.Protocol{StoreBackend: "bbolt"})
+	assert.Error(t, err)
+	s, err = New(&config.Protocol{StoreBackend: "bbolt", StorePath: filepath.Join(t.TempDir(), "store.db")})
+	require.NoError(t, err)
+	assert.IsType(t, &Bolt{}, s)
+	require.NoError(t, s.Close())
+
+	_, err = New(&config.Protocol{StoreBackend: "unknown"})
+	assert.Error(t, err)
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf16"
 
 	"github.com/42wim/matterbridge/bridge/config"
@@ -56,19 +57,26 @@ func (b *Btelegram) handleGroups(rmsg *config.Message, message *tgbotapi.Message
 	return b.handleUpdate(rmsg, message, update.Message, update.EditedMessage)
 }
 
-// handleForwarded handles forwarded messages
+// handleForwarded sets the ForwardedFrom of forwarded messages, the gateway adds it to the text
 func (b *Btelegram) handleForwarded(rmsg *config.Message, message *tgbotapi.Message) {
 	if message.ForwardDate == 0 {
 		return
 	}
+	if rmsg.Extra != nil {
+		rmsg.Extra[config.ExtraForwarded] = []interface{}{true}
+	}
 
 	if message.ForwardFromChat != nil && message.ForwardFrom == nil {
-		rmsg.Text = "Forwarded from " + message.ForwardFromChat.Title + ": " + rmsg.Text
+		rmsg.ForwardedFrom = message.ForwardFromChat.Title
 		return
 	}
 
+	// users who don't allow linking to their account only send their name
 	if message.ForwardFrom == nil {
-		rmsg.Text = "Forwarded from " + unknownUser + ": " + rmsg.Text
+		rmsg.ForwardedFrom = message.ForwardSenderName
+		if rmsg.ForwardedFrom == "" {
+			rmsg.ForwardedFrom = config.ForwardedUnknown
+		}
 		return
 	}
 
@@ -88,10 +96,10 @@ func (b *Btelegram) handleForwarded(rmsg *config.Message, message *tgbotapi.Mess
 	}
 
 	if usernameForward == "" {
-		usernameForward = unknownUser
+		usernameForward = config.ForwardedUnknown
 	}
 
-	rmsg.Text = "Forwarded from " + usernameForward + ": " + rmsg.Text
+	rmsg.ForwardedFrom = usernameForward
 }
 
 // handleQuoting handles quoting of previous messages
@@ -121,7 +129,7 @@ func (b *Btelegram) handleQuoting(rmsg *config.Message, message *tgbotapi.Messag
 			if quote == "" {
 				quote = message.ReplyToMessage.Caption
 			}
-			rmsg.Text = b.handleQuote(rmsg.Text, usernameReply, quote)
+			rmsg.Extra[config.ExtraQuote] = []interface{}{config.Quote{Username: usernameReply, Text: quote}}
 		}
 	}
 }
@@ -145,7 +153,7 @@ func (b *Btelegram) handleUsername(rmsg *config.Message, message *tgbotapi.Messa
 			}
 		}
 		// only download avatars if we have a place to upload them (configured mediaserver)
-		if b.General.MediaServerUpload != "" || (b.General.MediaServerDownload != "" && b.General.MediaDownloadPath != "") {
+		if helper.HasMediaServer(b.General) {
 			b.handleDownloadAvatar(message.From.ID, rmsg.Channel)
 		}
 	}
@@ -169,7 +177,7 @@ func (b *Btelegram) handleUsername(rmsg *config.Message, message *tgbotapi.Messa
 			}
 		}
 		// only download avatars if we have a place to upload them (configured mediaserver)
-		if b.General.MediaServerUpload != "" || (b.General.MediaServerDownload != "" && b.General.MediaDownloadPath != "") {
+		if helper.HasMediaServer(b.General) {
 			b.handleDownloadAvatar(message.SenderChat.ID, rmsg.Channel)
 		}
 	}
@@ -218,9 +226,11 @@ func (b *Btelegram) handleRecv(updates <-chan tgbotapi.Update) {
 
 		// set the ID's from the channel or group message
 		rmsg.ID = strconv.Itoa(message.MessageID)
-		rmsg.Channel = strconv.FormatInt(message.Chat.ID, 10)
-		if message.IsTopicMessage {
-			rmsg.Channel += "/" + strconv.Itoa(message.MessageThreadID)
+		rmsg.Channel = b.channelName(message)
+		rmsg.Timestamp = time.Unix(int64(message.Date), 0)
+		// edits are ordered by their time, see the gateway
+		if message.EditDate != 0 {
+			rmsg.Timestamp = time.Unix(int64(message.EditDate), 0)
 		}
 
 		// preserve threading from telegram reply
@@ -236,30 +246,52 @@ func (b *Btelegram) handleRecv(updates <-chan tgbotapi.Update) {
 		// handle username
 		b.handleUsername(&rmsg, message)
 
-		// handle any downloads
-		err := b.handleDownload(&rmsg, message)
-		if err != nil {
-			b.Log.Errorf("download failed: %s", err)
+		// download media in the background, so other messages aren't held up
+		if hasDownload(message) && (!b.GetBool("UseInsecureURL") || b.GetString("BotAPIURL") != "") {
+			b.received.Go(rmsg.Channel, func() { b.downloadUpdate(&rmsg, message) }, func() { b.relayUpdate(&rmsg, message) })
+			continue
 		}
+		b.downloadUpdate(&rmsg, message)
+		b.received.Relay(rmsg.Channel, func() { b.relayUpdate(&rmsg, message) })
+	}
+}
 
-		// handle forwarded messages
-		b.handleForwarded(&rmsg, message)
+// hasDownload returns true if message has media that's downloaded by handleDownload.
+func hasDownload(message *tgbotapi.Message) bool {
+	return message.Sticker != nil || message.Voice != nil || message.Video != nil ||
+		message.Audio != nil || message.Document != nil || message.Photo != nil
+}
 
-		// quote the previous message
-		b.handleQuoting(&rmsg, message)
+// downloadUpdate downloads the media of message into rmsg.
+func (b *Btelegram) downloadUpdate(rmsg *config.Message, message *tgbotapi.Message) {
+	err := b.handleDownload(rmsg, message)
+	if err != nil {
+		b.Log.Errorf("download failed: %s", err)
+	}
+}
 
-		if rmsg.Text != "" || len(rmsg.Extra) > 0 {
-			// Comment the next line out due to avoid removing empty lines in Telegram
-			// rmsg.Text = helper.RemoveEmptyNewLines(rmsg.Text)
-			// channels don't have (always?) user information. see #410
-			if message.From != nil {
-				rmsg.Avatar = helper.GetAvatar(b.avatarMap, strconv.FormatInt(message.From.ID, 10), b.General)
+// relayUpdate sends rmsg, of which the media are downloaded, to the gateway.
+func (b *Btelegram) relayUpdate(rmsg *config.Message, message *tgbotapi.Message) {
+	// handle forwarded messages
+	b.handleForwarded(rmsg, message)
+
+	// quote the previous message
+	b.handleQuoting(rmsg, message)
+
+	if rmsg.Text != "" || len(rmsg.Extra) > 0 {
+		// Comment the next line out due to avoid removing empty lines in Telegram
+		// rmsg.Text = helper.RemoveEmptyNewLines(rmsg.Text)
+		// channels don't have (always?) user information. see #410
+		if message.From != nil {
+			rmsg.Avatar = helper.GetAvatar(b.avatarMap, strconv.FormatInt(message.From.ID, 10), b.General)
+			if message.From.IsBot {
+				rmsg.Extra[config.ExtraBot] = []interface{}{true}
 			}
-
-			b.Log.Debugf("<= Sending message from %s on %s to gateway", rmsg.Username, b.Account)
-			b.Log.Debugf("<= Message is %#v", rmsg)
-			b.Remote <- rmsg
 		}
+
+		b.Log.Debugf("<= Sending message from %s on %s to gateway", rmsg.Username, b.Account)
+		b.Log.Debugf("<= Message is %#v", rmsg)
+		b.Remote <- *rmsg
 	}
 }
 
@@ -280,10 +312,10 @@ func (b *Btelegram) handleUserJoin(update tgbotapi.Update) {
 		rmsg := config.Message{
 			UserID:   strconv.FormatInt(user.ID, 10),
 			Username: user.FirstName, // for some reason all the other name felids are empty on this event (at least for me)
-			Channel:  strconv.FormatInt(msg.Chat.ID, 10),
+			Channel:  b.channelName(msg),
 			Account:  b.Account,
 			Protocol: b.Protocol,
-			Event:    config.EventJoinLeave,
+			Event:    config.EventJoin,
 			Text:     "joined chat",
 		}
 		b.Remote <- rmsg
@@ -297,12 +329,17 @@ func (b *Btelegram) handleUserLeave(update tgbotapi.Update) {
 	rmsg := config.Message{
 		UserID:   strconv.FormatInt(user.ID, 10),
 		Username: user.FirstName, // for some reason all the other name felids are empty on this event (at least for me)
-		Channel:  strconv.FormatInt(msg.Chat.ID, 10),
+		Channel:  b.channelName(msg),
 		Account:  b.Account,
 		Protocol: b.Protocol,
-		Event:    config.EventJoinLeave,
+		Event:    config.EventPart,
 		Text:     "left chat",
 	}
+	// removed by an admin
+	if msg.From != nil && msg.From.ID != user.ID {
+		rmsg.Event = config.EventKick
+		rmsg.Text = "was removed from chat"
+	}
 
 	b.Remote <- rmsg
 }
@@ -321,7 +358,7 @@ func (b *Btelegram) handleDownloadAvatar(userid int64, channel string) {
 		Extra:    make(map[string][]interface{}),
 	}
 
-	if _, ok := b.avatarMap[strconv.FormatInt(userid, 10)]; ok {
+	if b.avatarMap.Contains(strconv.FormatInt(userid, 10)) {
 		return
 	}
 
@@ -341,7 +378,7 @@ func (b *Btelegram) handleDownloadAvatar(userid int64, channel string) {
 			b.Log.Error(err)
 			return
 		}
-		data, err := helper.DownloadFile(url)
+		data, err := b.downloadFile(url)
 		if err != nil {
 			b.Log.Errorf("download %s failed %#v", url, err)
 			return
@@ -413,8 +450,9 @@ func (b *Btelegram) handleDownload(rmsg *config.Message, message *tgbotapi.Messa
 	if name == "" {
 		return nil
 	}
-	// use the URL instead of native upload
-	if b.GetBool("UseInsecureURL") {
+	// use the URL instead of native upload, the files of a local bot API server have no URL
+	// that can be relayed and are uploaded instead
+	if b.GetBool("UseInsecureURL") && !isLocalFile(url) {
 		b.Log.Debugf("Setting message text to :%s", text)
 		rmsg.Text += text
 		return nil
@@ -424,7 +462,7 @@ func (b *Btelegram) handleDownload(rmsg *config.Message, message *tgbotapi.Messa
 	if err != nil {
 		return err
 	}
-	data, err := helper.DownloadFile(url)
+	data, err := b.downloadFile(url)
 	if err != nil {
 		return err
 	}
@@ -470,7 +508,10 @@ func (b *Btelegram) handleDelete(msg *config.Message, chatid int64) (string, err
 	}
 
 	cfg := tgbotapi.NewDeleteMessage(chatid, msgid)
-	_, err = b.c.Request(cfg)
+	err = b.pacer.do(chatid, true, func() error {
+		_, err := b.c.Request(cfg)
+		return err
+	})
 
 	return "", err
 }
@@ -501,7 +542,10 @@ func (b *Btelegram) handleEdit(msg *config.Message, chatid int64) (string, error
 		b.Log.Debug("Using mode HTML - nick only")
 		m.ParseMode = tgbotapi.ModeHTML
 	}
-	_, err = b.c.Send(m)
+	err = b.pacer.do(chatid, true, func() error {
+		_, err := b.c.Send(m)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
@@ -513,9 +557,14 @@ func (b *Btelegram) handleUploadFile(msg *config.Message, chatid int64, threadid
 	var media []interface{}
 	for _, f := range msg.Extra["file"] {
 		fi := f.(config.FileInfo)
-		file := tgbotapi.FileBytes{
-			Name:  fi.Name,
-			Bytes: *fi.Data,
+		r, err := fi.Open()
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		file := tgbotapi.FileReader{
+			Name:   fi.Name,
+			Reader: r,
 		}
 
 		if b.GetString("MessageFormat") == HTMLFormat {
@@ -545,7 +594,12 @@ func (b *Btelegram) handleUploadFile(msg *config.Message, chatid int64, threadid
 			voc := tgbotapi.NewVoice(chatid, file)
 			voc.Caption, voc.ParseMode = TGGetParseMode(b, msg.Username, fi.Comment)
 			voc.ReplyToMessageID = parentID
-			res, err := b.c.Send(voc)
+			voc.MessageThreadID = threadid
+			var res tgbotapi.Message
+			err := b.pacer.do(chatid, false, func() (err error) {
+				res, err = b.c.Send(voc)
+				return err
+			})
 			if err != nil {
 				return "", err
 			}
@@ -562,25 +616,6 @@ func (b *Btelegram) handleUploadFile(msg *config.Message, chatid int64, threadid
 	return b.sendMediaFiles(msg, chatid, threadid, parentID, media)
 }
 
-func (b *Btelegram) handleQuote(message, quoteNick, quoteMessage string) string {
-	format := b.GetString("quoteformat")
-	if format == "" {
-		format = "{MESSAGE} (re @{QUOTENICK}: {QUOTEMESSAGE})"
-	}
-	quoteMessagelength := len([]rune(quoteMessage))
-	if b.GetInt("QuoteLengthLimit") != 0 && quoteMessagelength >= b.GetInt("QuoteLengthLimit") {
-		runes := []rune(quoteMessage)
-		quoteMessage = string(runes[0:b.GetInt("QuoteLengthLimit")])
-		if quoteMessagelength > b.GetInt("QuoteLengthLimit") {
-			quoteMessage += "..."
-		}
-	}
-	format = strings.Replace(format, "{MESSAGE}", message, -1)
-	format = strings.Replace(format, "{QUOTENICK}", quoteNick, -1)
-	format = strings.Replace(format, "{QUOTEMESSAGE}", quoteMessage, -1)
-	return format
-}
-
 // handleEntities handles messageEntities
 func (b *Btelegram) handleEntities(rmsg *config.Message, message *tgbotapi.Message) {
 	if message.Entities == nil {
@@ -0,0 +1,93 @@
+package btelegram
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// globalRate is the number of messages a second Telegram allows a bot in all chats.
+	globalRate = rate.Limit(30)
+	// defaultMessagesPerMinute is the number of messages a minute Telegram allows a bot in a group.
+	defaultMessagesPerMinute = 20
+	chatBurst                = 3
+	// maxFloodWaits is the number of times a request is retried after a flood wait.
+	maxFloodWaits = 3
+)
+
+// pacer paces the requests of a bot to the rate limits of Telegram, a chat is paused for
+// the retry_after of a flood wait (429) Telegram returns for it.
+type pacer struct {
+	sync.Mutex
+
+	log      *logrus.Entry
+	global   *rate.Limiter
+	chatRate rate.Limit
+	chats    map[int64]*rate.Limiter
+	paused   map[int64]time.Time
+}
+
+func newPacer(log *logrus.Entry, perMinute int) *pacer {
+	if perMinute <= 0 {
+		perMinute = defaultMessagesPerMinute
+	}
+	return &pacer{
+		log:      log,
+		global:   rate.NewLimiter(globalRate, int(globalRate)),
+		chatRate: rate.Limit(float64(perMinute) / 60),
+		chats:    make(map[int64]*rate.Limiter),
+		paused:   make(map[int64]time.Time),
+	}
+}
+
+// wait waits until a request can be sent to the chat chatid.
+func (p *pacer) wait(chatid int64) {
+	p.Lock()
+	lim, ok := p.chats[chatid]
+	if !ok {
+		lim = rate.NewLimiter(p.chatRate, chatBurst)
+		p.chats[chatid] = lim
+	}
+	until := p.paused[chatid]
+	p.Unlock()
+
+	if d := time.Until(until); d > 0 {
+		time.Sleep(d)
+	}
+	_ = lim.Wait(context.Background())
+	_ = p.global.Wait(context.Background())
+}
+
+// floodWait pauses the chat chatid when err is a flood wait and returns true.
+func (p *pacer) floodWait(chatid int64, err error) bool {
+	var tgErr *tgbotapi.Error
+	if !errors.As(err, &tgErr) || tgErr.RetryAfter <= 0 {
+		return false
+	}
+	retry := time.Duration(tgErr.RetryAfter) * time.Second
+	p.log.Warnf("Flood wait on chat %d, pausing it for %s", chatid, retry)
+	p.Lock()
+	if until := time.Now().Add(retry); until.After(p.paused[chatid]) {
+		p.paused[chatid] = until
+	}
+	p.Unlock()
+	return true
+}
+
+// do sends the request fn to the chat chatid when the rate limits allow it. When retry is
+// set fn is sent again after a flood wait, requests that upload files can't be retried.
+func (p *pacer) do(chatid int64, retry bool, fn func() error) error {
+	for i := 0; ; i++ {
+		p.wait(chatid)
+		err := fn()
+		if !p.floodWait(chatid, err) || !retry || i >= maxFloodWaits {
+			return err
+		}
+	}
+}
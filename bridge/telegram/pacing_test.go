@@ -0,0 +1,44 @@
+package btelegram
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacerFloodWait(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	p := newPacer(logrus.NewEntry(logger), 6000)
+
+	floodWait := &tgbotapi.Error{Code: 429, ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 1}}
+	calls := 0
+	start := time.Now()
+	err := p.do(1, true, func() error {
+		calls++
+		if calls == 1 {
+			return floodWait
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.True(t, time.Since(start) >= time.Second)
+
+	// other chats aren't paused
+	start = time.Now()
+	assert.NoError(t, p.do(2, true, func() error { return nil }))
+	assert.True(t, time.Since(start) < time.Second)
+
+	// uploads aren't retried and other errors are returned as they are
+	p.paused[1] = time.Time{}
+	assert.Equal(t, floodWait, p.do(1, false, func() error { return floodWait }))
+	other := errors.New("bad request")
+	p.paused[1] = time.Time{}
+	assert.Equal(t, other, p.do(1, true, func() error { return other }))
+}
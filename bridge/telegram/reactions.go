@@ -0,0 +1,140 @@
+package btelegram
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
+)
+
+// allowedUpdates are the updates requested from telegram, message_reaction updates are only
+// sent when they're asked for.
+var allowedUpdates = []string{"message", "edited_message", "channel_post", "edited_channel_post", "message_reaction"}
+
+// reactionUpdate is the part of an update the bot API library doesn't know about yet.
+type reactionUpdate struct {
+	MessageReaction *messageReactionUpdated `json:"message_reaction"`
+}
+
+// messageReactionUpdated is a change of the reactions of a user on a message.
+type messageReactionUpdated struct {
+	Chat        tgbotapi.Chat  `json:"chat"`
+	MessageID   int            `json:"message_id"`
+	User        *tgbotapi.User `json:"user"`
+	ActorChat   *tgbotapi.Chat `json:"actor_chat"`
+	OldReaction []reactionType `json:"old_reaction"`
+	NewReaction []reactionType `json:"new_reaction"`
+}
+
+type reactionType struct {
+	Type  string `json:"type"`
+	Emoji string `json:"emoji,omitempty"`
+}
+
+// getUpdatesChan is GetUpdatesChan of the bot API library, which also passes the
+// message_reaction updates to handleReaction.
+func (b *Btelegram) getUpdatesChan(u tgbotapi.UpdateConfig) <-chan tgbotapi.Update {
+	ch := make(chan tgbotapi.Update, b.c.Buffer)
+	u.AllowedUpdates = allowedUpdates
+	go func() {
+		for {
+			resp, err := b.c.Request(u)
+			if err != nil {
+				b.Log.Errorf("Failed to get updates, retrying in 3 seconds: %s", err)
+				time.Sleep(3 * time.Second)
+				continue
+			}
+			var updates []tgbotapi.Update
+			if err := json.Unmarshal(resp.Result, &updates); err != nil {
+				b.Log.Errorf("Failed to decode updates: %s", err)
+				continue
+			}
+			var reactions []reactionUpdate
+			_ = json.Unmarshal(resp.Result, &reactions)
+			for i, update := range updates {
+				if update.UpdateID < u.Offset {
+					continue
+				}
+				u.Offset = update.UpdateID + 1
+				if i < len(reactions) && reactions[i].MessageReaction != nil {
+					b.handleReaction(reactions[i].MessageReaction)
+					continue
+				}
+				ch <- update
+			}
+		}
+	}()
+	return ch
+}
+
+// emojiReactions returns the emoji of reactions, custom emoji reactions are skipped.
+func emojiReactions(reactions []reactionType) map[string]bool {
+	res := make(map[string]bool)
+	for _, r := range reactions {
+		if r.Type == "emoji" {
+			res[r.Emoji] = true
+		}
+	}
+	return res
+}
+
+// handleReaction sends the reactions added to and removed from a message to the gateway.
+func (b *Btelegram) handleReaction(r *messageReactionUpdated) {
+	// our own reactions are the ones relayed from the other bridges
+	if r.User != nil && r.User.ID == b.c.Self.ID {
+		return
+	}
+	rmsg := config.Message{
+		Account:  b.Account,
+		Channel:  strconv.FormatInt(r.Chat.ID, 10),
+		ParentID: strconv.Itoa(r.MessageID),
+	}
+	b.handleUsername(&rmsg, &tgbotapi.Message{From: r.User, SenderChat: r.ActorChat})
+	if rmsg.Username == "" {
+		rmsg.Username = unknownUser
+	}
+
+	oldReactions, newReactions := emojiReactions(r.OldReaction), emojiReactions(r.NewReaction)
+	for emoji := range newReactions {
+		if !oldReactions[emoji] {
+			rmsg.Event, rmsg.Text = config.EventReactionAdd, emoji
+			b.Log.Debugf("<= Message is %#v", rmsg)
+			b.Remote <- rmsg
+		}
+	}
+	for emoji := range oldReactions {
+		if !newReactions[emoji] {
+			rmsg.Event, rmsg.Text = config.EventReactionRemove, emoji
+			b.Log.Debugf("<= Message is %#v", rmsg)
+			b.Remote <- rmsg
+		}
+	}
+}
+
+// sendReaction sets the reaction of the bot on the message msg.ParentID, telegram bots
+// only have one reaction per message so a removal removes it.
+func (b *Btelegram) sendReaction(msg *config.Message, chatid int64) error {
+	if !msg.ParentValid() {
+		return nil
+	}
+	msgid, err := b.intParentID(msg.ParentID)
+	if err != nil {
+		return err
+	}
+	reaction := []reactionType{}
+	if msg.Event == config.EventReactionAdd {
+		reaction = append(reaction, reactionType{Type: "emoji", Emoji: msg.Text})
+	}
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", chatid)
+	params.AddNonZero("message_id", msgid)
+	if err := params.AddInterface("reaction", reaction); err != nil {
+		return err
+	}
+	return b.pacer.do(chatid, true, func() error {
+		_, err := b.c.MakeRequest("setMessageReaction", params)
+		return err
+	})
+}
@@ -0,0 +1,39 @@
+package btelegram
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
+)
+
+// slowmodeInterval is how often the slowmode of the chats is checked, bots get no updates
+// when it changes.
+const slowmodeInterval = 5 * time.Minute
+
+// watchSlowmode sends the slowmode of the chat of channel to the gateway when it changes.
+func (b *Btelegram) watchSlowmode(channel string) {
+	chatid, _, err := b.getIds(channel)
+	if err != nil || chatid >= 0 {
+		// only groups have a slowmode
+		return
+	}
+	prev := 0
+	for {
+		chat, err := b.c.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: chatid}})
+		if err != nil {
+			b.Log.Debugf("getting the slowmode of %s failed: %s", channel, err)
+		} else if chat.SlowModeDelay != prev {
+			prev = chat.SlowModeDelay
+			b.Log.Debugf("<= Sending slowmode %ds of %s to gateway", prev, channel)
+			b.Remote <- config.Message{
+				Account: b.Account,
+				Event:   config.EventSlowmode,
+				Text:    strconv.Itoa(prev),
+				Channel: channel,
+			}
+		}
+		time.Sleep(slowmodeInterval)
+	}
+}
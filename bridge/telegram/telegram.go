@@ -1,15 +1,21 @@
 package btelegram
 
 import (
+	"errors"
 	"fmt"
 	"html"
 	"log"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/store"
 	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
 )
 
@@ -18,12 +24,27 @@ const (
 	HTMLFormat  = "HTML"
 	HTMLNick    = "htmlnick"
 	MarkdownV2  = "MarkdownV2"
+
+	downloadTimeout = 30 * time.Minute
+	// officialDownloadTimeout is the timeout of the downloads of the official bot API,
+	// like helper.DownloadFile, its files are 20MB at most.
+	officialDownloadTimeout = 5 * time.Second
+
+	// generalTopic is the thread ID of the General topic of a forum, messages in it have no
+	// message_thread_id and can't be sent with one.
+	generalTopic = 1
 )
 
 type Btelegram struct {
 	c *tgbotapi.BotAPI
 	*bridge.Config
-	avatarMap map[string]string // keep cache of userid and avatar sha
+	avatarMap *store.Bucket // keep cache of userid and avatar sha
+	pacer     *pacer
+
+	sync.RWMutex
+	joined map[string]bool // the channels of the gateways
+
+	received *helper.ChannelQueue
 }
 
 func New(cfg *bridge.Config) bridge.Bridger {
@@ -37,20 +58,29 @@ func New(cfg *bridge.Config) bridge.Bridger {
 			log.Fatalf("Telegram bridge configured to convert .tgs files to '%s', but %s doesn't support it.", tgsConvertFormat, helper.LottieBackend())
 		}
 	}
-	return &Btelegram{Config: cfg, avatarMap: make(map[string]string)}
+	return &Btelegram{
+		Config:    cfg,
+		avatarMap: cfg.NewBucket("avatar"),
+		pacer:     newPacer(cfg.Log, cfg.GetInt("MessagesPerMinute")),
+		joined:    make(map[string]bool),
+		received:  helper.NewChannelQueue(cfg.Account),
+	}
 }
 
 func (b *Btelegram) Connect() error {
 	var err error
 	b.Log.Info("Connecting")
-	b.c, err = tgbotapi.NewBotAPI(b.GetString("Token"))
+	if b.GetString("BotAPIURL") != "" {
+		b.Log.Infof("Using bot API server %s", b.GetString("BotAPIURL"))
+	}
+	b.c, err = tgbotapi.NewBotAPIWithClient(b.GetString("Token"), b.apiEndpoint(), b.HTTPClient(0))
 	if err != nil {
 		b.Log.Debugf("%#v", err)
 		return err
 	}
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
-	updates := b.c.GetUpdatesChan(u)
+	updates := b.getUpdatesChan(u)
 	b.Log.Info("Connection succeeded")
 	go b.handleRecv(updates)
 	return nil
@@ -61,9 +91,35 @@ func (b *Btelegram) Disconnect() error {
 }
 
 func (b *Btelegram) JoinChannel(channel config.ChannelInfo) error {
+	b.Lock()
+	joined := b.joined[channel.Name]
+	b.joined[channel.Name] = true
+	b.Unlock()
+	if !joined && b.c != nil {
+		go b.watchSlowmode(channel.Name)
+	}
 	return nil
 }
 
+// channelName returns the channel of message, which is chatid/topicid for the topics of a
+// forum. A topic that isn't in a gateway is part of the channel of the whole chat when that
+// is, and the General topic is chatid/1 when that is in a gateway.
+func (b *Btelegram) channelName(message *tgbotapi.Message) string {
+	chat := strconv.FormatInt(message.Chat.ID, 10)
+	topic := chat + "/" + strconv.Itoa(generalTopic)
+	if message.IsTopicMessage {
+		topic = chat + "/" + strconv.Itoa(message.MessageThreadID)
+	} else if !message.Chat.IsForum {
+		return chat
+	}
+	b.RLock()
+	defer b.RUnlock()
+	if b.joined[topic] || (message.IsTopicMessage && !b.joined[chat]) {
+		return topic
+	}
+	return chat
+}
+
 func TGGetParseMode(b *Btelegram, username string, text string) (textout string, parsemode string) {
 	textout = username + text
 	if b.GetString("MessageFormat") == HTMLFormat {
@@ -106,7 +162,9 @@ func (b *Btelegram) getIds(channel string) (int64, int, error) {
 		if err != nil {
 			return 0, 0, err
 		}
-		topicid = tid
+		if tid != generalTopic {
+			topicid = tid
+		}
 	} else {
 		id, err := strconv.ParseInt(channel, 10, 64)
 		if err != nil {
@@ -117,6 +175,29 @@ func (b *Btelegram) getIds(channel string) (int64, int, error) {
 	return chatid, topicid, nil
 }
 
+// supergroupPrefix is the prefix of the chat IDs of supergroups, which are linked to
+// without it.
+const supergroupPrefix = -1000000000000
+
+// Permalink implements bridge.Permalinker, only the messages of supergroups have links.
+func (b *Btelegram) Permalink(channel, id string) (string, bool) {
+	chatid, topicid, err := b.getIds(channel)
+	if err != nil || chatid > supergroupPrefix || id == "" {
+		return "", false
+	}
+	if topicid != 0 {
+		return fmt.Sprintf("https://t.me/c/%d/%d/%s", supergroupPrefix-chatid, topicid, id), true
+	}
+	return fmt.Sprintf("https://t.me/c/%d/%s", supergroupPrefix-chatid, id), true
+}
+
+// PermanentError implements bridge.ErrorClassifier, the requests the bot API rejects, like
+// a message that's too long or a chat the bot was removed from.
+func (b *Btelegram) PermanentError(err error) bool {
+	var apiErr *tgbotapi.Error
+	return errors.As(err, &apiErr) && bridge.PermanentStatus(apiErr.Code)
+}
+
 func (b *Btelegram) Send(msg config.Message) (string, error) {
 	b.Log.Debugf("=> Receiving %#v", msg)
 
@@ -130,6 +211,14 @@ func (b *Btelegram) Send(msg config.Message) (string, error) {
 		return b.cacheAvatar(&msg)
 	}
 
+	if msg.Event == config.EventReactionAdd || msg.Event == config.EventReactionRemove {
+		return "", b.sendReaction(&msg, chatid)
+	}
+
+	if msg.Event == config.EventUserTyping {
+		return "", b.sendTyping(chatid, topicid)
+	}
+
 	if b.GetString("MessageFormat") == HTMLFormat {
 		msg.Text = makeHTML(html.EscapeString(msg.Text))
 	}
@@ -179,12 +268,67 @@ func (b *Btelegram) Send(msg config.Message) (string, error) {
 	return "", nil
 }
 
+// apiEndpoint returns the endpoint for the bot API methods, api.telegram.org
+// unless a self-hosted bot API server is configured with BotAPIURL.
+func (b *Btelegram) apiEndpoint() string {
+	if u := b.GetString("BotAPIURL"); u != "" {
+		return strings.TrimSuffix(u, "/") + "/bot%s/%s"
+	}
+	return tgbotapi.APIEndpoint
+}
+
+// fileEndpoint returns the endpoint for downloading files, see apiEndpoint.
+func (b *Btelegram) fileEndpoint() string {
+	if u := b.GetString("BotAPIURL"); u != "" {
+		return strings.TrimSuffix(u, "/") + "/file/bot%s/%s"
+	}
+	return tgbotapi.FileEndpoint
+}
+
 func (b *Btelegram) getFileDirectURL(id string) string {
-	res, err := b.c.GetFileDirectURL(id)
+	file, err := b.c.GetFile(tgbotapi.FileConfig{FileID: id})
 	if err != nil {
 		return ""
 	}
-	return res
+	// a bot API server running with --local returns the absolute path of the file
+	// on its disk instead of a path relative to the file endpoint.
+	if filepath.IsAbs(file.FilePath) {
+		return "file://" + file.FilePath
+	}
+	return fmt.Sprintf(b.fileEndpoint(), b.c.Token, file.FilePath)
+}
+
+// isLocalFile returns true if url, which is returned by getFileDirectURL, is a file on the
+// disk of a local bot API server. These URLs are never relayed.
+func isLocalFile(url string) bool {
+	return strings.HasPrefix(url, "file://")
+}
+
+// downloadFile downloads url, which is returned by getFileDirectURL.
+// Files of a local bot API server are read from disk. Files bigger than MediaDownloadSize
+// return helper.ErrFileTooBig before they're read.
+func (b *Btelegram) downloadFile(url string) (*[]byte, error) {
+	limit := int64(b.General.MediaDownloadSize)
+	if isLocalFile(url) {
+		path := strings.TrimPrefix(url, "file://")
+		st, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if limit > 0 && st.Size() > limit {
+			return nil, helper.ErrFileTooBig
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return &data, nil
+	}
+	if b.GetString("BotAPIURL") == "" {
+		return helper.DownloadFileLimit(b.HTTPClient(officialDownloadTimeout), url, nil, limit)
+	}
+	// files of a self-hosted server can be up to 2GB, don't use the short timeout of helper.DownloadFile
+	return helper.DownloadFileLimit(b.HTTPClient(downloadTimeout), url, nil, limit)
 }
 
 func (b *Btelegram) sendMessage(chatid int64, topicid int, username, text string, parentID int) (string, error) {
@@ -196,7 +340,11 @@ func (b *Btelegram) sendMessage(chatid int64, topicid int, username, text string
 	m.ReplyToMessageID = parentID
 	m.DisableWebPagePreview = b.GetBool("DisableWebPagePreview")
 
-	res, err := b.c.Send(m)
+	var res tgbotapi.Message
+	err := b.pacer.do(chatid, true, func() (err error) {
+		res, err = b.c.Send(m)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
@@ -217,7 +365,11 @@ func (b *Btelegram) sendMediaFiles(msg *config.Message, chatid int64, threadid i
 		},
 		Media: media,
 	}
-	messages, err := b.c.SendMediaGroup(mg)
+	var messages []tgbotapi.Message
+	err := b.pacer.do(chatid, false, func() (err error) {
+		messages, err = b.c.SendMediaGroup(mg)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
@@ -240,7 +392,9 @@ func (b *Btelegram) cacheAvatar(msg *config.Message) (string, error) {
 	so we can now cache the sha */
 	if fi.SHA != "" {
 		b.Log.Debugf("Added %s to %s in avatarMap", fi.SHA, msg.UserID)
-		b.avatarMap[msg.UserID] = fi.SHA
+		if err := b.avatarMap.SetString(msg.UserID, fi.SHA); err != nil {
+			return "", err
+		}
 	}
 	return "", nil
 }
@@ -0,0 +1,109 @@
+package btelegram
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelName(t *testing.T) {
+	b := &Btelegram{joined: make(map[string]bool)}
+	forum := &tgbotapi.Chat{ID: -100123, IsForum: true}
+	topic := &tgbotapi.Message{Chat: forum, IsTopicMessage: true, MessageThreadID: 45}
+	general := &tgbotapi.Message{Chat: forum}
+
+	assert.Equal(t, "-100123/45", b.channelName(topic))
+	assert.Equal(t, "-100123", b.channelName(general))
+	assert.Equal(t, "-42", b.channelName(&tgbotapi.Message{Chat: &tgbotapi.Chat{ID: -42}}))
+
+	// topics that aren't bridged are part of the bridged chat
+	require.NoError(t, b.JoinChannel(config.ChannelInfo{Name: "-100123"}))
+	assert.Equal(t, "-100123", b.channelName(topic))
+	require.NoError(t, b.JoinChannel(config.ChannelInfo{Name: "-100123/45"}))
+	assert.Equal(t, "-100123/45", b.channelName(topic))
+
+	require.NoError(t, b.JoinChannel(config.ChannelInfo{Name: "-100123/1"}))
+	assert.Equal(t, "-100123/1", b.channelName(general))
+}
+
+func TestGetIds(t *testing.T) {
+	b := &Btelegram{}
+	chatid, topicid, err := b.getIds("-100123/45")
+	require.NoError(t, err)
+	assert.Equal(t, int64(-100123), chatid)
+	assert.Equal(t, 45, topicid)
+
+	// the General topic is sent to without a thread ID
+	_, topicid, err = b.getIds("-100123/1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, topicid)
+
+	_, _, err = b.getIds("-100123/general")
+	assert.Error(t, err)
+}
+
+func TestDownloadLocalFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "big.mp4")
+	require.NoError(t, os.WriteFile(path, []byte("more than ten bytes"), 0o600))
+	b := &Btelegram{Config: &bridge.Config{Bridge: &bridge.Bridge{General: &config.Protocol{MediaDownloadSize: 10}}}}
+
+	// the size is checked before the file is read
+	_, err := b.downloadFile("file://" + path)
+	assert.Equal(t, helper.ErrFileTooBig, err)
+
+	b.General.MediaDownloadSize = 100
+	data, err := b.downloadFile("file://" + path)
+	require.NoError(t, err)
+	assert.Equal(t, "more than ten bytes", string(*data))
+
+	assert.True(t, isLocalFile("file://"+path))
+	assert.False(t, isLocalFile("https://api.telegram.org/file/bot123/videos/file_1.mp4"))
+}
+
+func TestLocalFileInsecureURL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file_1.mp4")
+	require.NoError(t, os.WriteFile(path, []byte("video"), 0o600))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/getMe"):
+			w.Write([]byte(`{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"bot","username":"bot"}}`)) //nolint:errcheck
+		case strings.HasSuffix(r.URL.Path, "/getFile"):
+			// a bot API server running with --local
+			fmt.Fprintf(w, `{"ok":true,"result":{"file_id":"1","file_size":5,"file_path":%q}}`, path)
+		}
+	}))
+	defer srv.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	br := bridge.New(&config.Bridge{Account: "telegram.local"})
+	br.Config = config.NewConfigFromString(logger, []byte("[telegram.local]\nBotAPIURL=\""+srv.URL+"\"\nUseInsecureURL=true\n"))
+	br.General = &config.Protocol{MediaDownloadSize: 1000000}
+	br.Log = logrus.NewEntry(logger)
+	b := &Btelegram{Config: &bridge.Config{Bridge: br}}
+	var err error
+	b.c, err = tgbotapi.NewBotAPIWithClient("token", b.apiEndpoint(), srv.Client())
+	require.NoError(t, err)
+
+	// the video is uploaded instead of relaying its path
+	rmsg := &config.Message{Extra: make(map[string][]interface{})}
+	require.NoError(t, b.handleDownload(rmsg, &tgbotapi.Message{Video: &tgbotapi.Video{FileID: "1", FileSize: 5}}))
+	assert.NotContains(t, rmsg.Text, "file://")
+	require.Len(t, rmsg.Extra["file"], 1)
+	data, err := rmsg.Extra["file"][0].(config.FileInfo).Bytes()
+	require.NoError(t, err)
+	assert.Equal(t, "video", string(data))
+}
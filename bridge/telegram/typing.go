@@ -0,0 +1,18 @@
+package btelegram
+
+import (
+	tgbotapi "github.com/matterbridge/telegram-bot-api/v6"
+)
+
+// sendTyping shows the bot as typing in the chat chatid, or its topic topicid.
+func (b *Btelegram) sendTyping(chatid int64, topicid int) error {
+	if !b.GetBool("ShowUserTyping") {
+		return nil
+	}
+	action := tgbotapi.NewChatAction(chatid, tgbotapi.ChatTyping)
+	action.MessageThreadID = topicid
+	return b.pacer.do(chatid, false, func() error {
+		_, err := b.c.Request(action)
+		return err
+	})
+}
@@ -183,6 +183,7 @@ func (b *Bvk) handleMessage(msg object.MessagesMessage, isFwd bool) {
 
 	if isFwd {
 		rmsg.Username = "Fwd: " + rmsg.Username
+		rmsg.Extra[config.ExtraForwarded] = []interface{}{true}
 	}
 
 	if len(msg.Attachments) > 0 {
@@ -323,11 +324,11 @@ func (b *Bvk) getFiles(attachments []object.MessagesMessageAttachment) ([]string
 
 func (b *Bvk) downloadFiles(rmsg *config.Message, urls []string) {
 	for _, url := range urls {
-		data, err := helper.DownloadFile(url)
+		media, err := b.DownloadMedia(url, nil)
 		if err == nil {
 			urlPart := strings.Split(url, "/")
 			name := strings.Split(urlPart[len(urlPart)-1], "?")[0]
-			helper.HandleDownloadData(b.Log, rmsg, name, "", url, data, b.General)
+			helper.HandleDownloadMedia(b.Log, rmsg, name, "", "", url, media)
 		}
 	}
 }
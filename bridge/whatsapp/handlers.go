@@ -1,130 +1,199 @@
-// nolint:goconst
+//go:build whatsappmulti
+// +build whatsappmulti
+
 package bwhatsapp
 
 import (
 	"fmt"
 	"mime"
 	"strings"
-	"time"
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
-	"github.com/Rhymen/go-whatsapp"
-	"github.com/jpillora/backoff"
-)
 
-/*
-Implement handling messages coming from WhatsApp
-Check:
-- https://github.com/Rhymen/go-whatsapp#add-message-handlers
-- https://github.com/Rhymen/go-whatsapp/blob/master/handler.go
-- https://github.com/tulir/mautrix-whatsapp/tree/master/whatsapp-ext for more advanced command handling
-*/
-
-// HandleError received from WhatsApp
-func (b *Bwhatsapp) HandleError(err error) {
-	// ignore received invalid data errors. https://github.com/42wim/matterbridge/issues/843
-	// ignore tag 174 errors. https://github.com/42wim/matterbridge/issues/1094
-	if strings.Contains(err.Error(), "error processing data: received invalid data") ||
-		strings.Contains(err.Error(), "invalid string with tag 174") {
-		return
-	}
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
 
-	switch err.(type) {
-	case *whatsapp.ErrConnectionClosed, *whatsapp.ErrConnectionFailed:
-		b.reconnect(err)
-	default:
-		switch err {
-		case whatsapp.ErrConnectionTimeout:
-			b.reconnect(err)
-		default:
-			b.Log.Errorf("%v", err)
+// nolint:gocritic
+func (b *Bwhatsapp) eventHandler(evt interface{}) {
+	switch e := evt.(type) {
+	case *events.Message:
+		// messages of the account sent from the phone, not from a linked device
+		if e.Info.IsFromMe && e.Info.Sender.Device == 0 {
+			b.phone.update(e.Info.Timestamp)
 		}
+		b.handleMessage(e)
+	case *events.GroupInfo:
+		b.handleGroupInfo(e)
 	}
 }
 
-func (b *Bwhatsapp) reconnect(err error) {
-	bf := &backoff.Backoff{
-		Min:    time.Second,
-		Max:    5 * time.Minute,
-		Jitter: true,
+func (b *Bwhatsapp) handleGroupInfo(event *events.GroupInfo) {
+	b.Log.Debugf("Receiving event %#v", event)
+
+	switch {
+	case event.Join != nil:
+		b.handleUserJoin(event)
+	case event.Leave != nil:
+		b.handleUserLeave(event)
+	case event.Topic != nil:
+		b.handleTopicChange(event)
 	}
+}
 
-	for {
-		d := bf.Duration()
+func (b *Bwhatsapp) handleUserJoin(event *events.GroupInfo) {
+	for _, joinedJid := range event.Join {
+		senderName := b.getSenderNameFromJID(joinedJid)
+
+		rmsg := config.Message{
+			UserID:   joinedJid.String(),
+			Username: senderName,
+			Channel:  event.JID.String(),
+			Account:  b.Account,
+			Protocol: b.Protocol,
+			Event:    config.EventJoin,
+			Text:     "joined chat",
+		}
 
-		b.Log.Errorf("Connection failed, underlying error: %v", err)
-		b.Log.Infof("Waiting %s...", d)
+		b.Remote <- rmsg
+	}
+}
 
-		time.Sleep(d)
+func (b *Bwhatsapp) handleUserLeave(event *events.GroupInfo) {
+	for _, leftJid := range event.Leave {
+		senderName := b.getSenderNameFromJID(leftJid)
+
+		rmsg := config.Message{
+			UserID:   leftJid.String(),
+			Username: senderName,
+			Channel:  event.JID.String(),
+			Account:  b.Account,
+			Protocol: b.Protocol,
+			Event:    config.EventPart,
+			Text:     "left chat",
+		}
 
-		b.Log.Info("Reconnecting...")
+		b.Remote <- rmsg
+	}
+}
 
-		err := b.conn.Restore()
-		if err == nil {
-			bf.Reset()
-			b.startedAt = uint64(time.Now().Unix())
+func (b *Bwhatsapp) handleTopicChange(event *events.GroupInfo) {
+	msg := event.Topic
+	senderJid := msg.TopicSetBy
+	senderName := b.getSenderNameFromJID(senderJid)
 
-			return
-		}
+	text := msg.Topic
+	if text == "" {
+		text = "removed topic"
 	}
+
+	rmsg := config.Message{
+		UserID:   senderJid.String(),
+		Username: senderName,
+		Channel:  event.JID.String(),
+		Account:  b.Account,
+		Protocol: b.Protocol,
+		Event:    config.EventTopicChange,
+		Text:     "Topic changed: " + text,
+	}
+
+	b.Remote <- rmsg
 }
 
-// HandleTextMessage sent from WhatsApp, relay it to the brige
-func (b *Bwhatsapp) HandleTextMessage(message whatsapp.TextMessage) {
-	if message.Info.FromMe {
+func (b *Bwhatsapp) handleMessage(message *events.Message) {
+	msg := message.Message
+	switch {
+	case msg == nil, message.Info.IsFromMe, message.Info.Timestamp.Before(b.startedAt):
 		return
 	}
-	// whatsapp sends last messages to show context , cut them
-	if message.Info.Timestamp < b.startedAt {
+
+	b.Log.Debugf("Receiving message %#v", msg)
+
+	switch {
+	case msg.Conversation != nil || msg.ExtendedTextMessage != nil:
+		b.handleTextMessage(message.Info, msg)
+	case msg.VideoMessage != nil:
+		b.handleVideoMessage(message)
+	case msg.AudioMessage != nil:
+		b.handleAudioMessage(message)
+	case msg.DocumentMessage != nil:
+		b.handleDocumentMessage(message)
+	case msg.ImageMessage != nil:
+		b.handleImageMessage(message)
+	case msg.ProtocolMessage != nil && msg.ProtocolMessage.GetType() == proto.ProtocolMessage_REVOKE:
+		b.handleDelete(message.Info, msg.ProtocolMessage)
+	case msg.ProtocolMessage != nil && msg.ProtocolMessage.GetType() == proto.ProtocolMessage_MESSAGE_EDIT:
+		b.handleEdit(message.Info, msg.ProtocolMessage)
+	}
+}
+
+// nolint:funlen
+func (b *Bwhatsapp) handleTextMessage(messageInfo types.MessageInfo, msg *proto.Message) {
+	senderJID := messageInfo.Sender
+	channel := messageInfo.Chat
+
+	senderName := b.getSenderName(messageInfo)
+
+	if msg.GetExtendedTextMessage() == nil && msg.GetConversation() == "" {
+		b.Log.Debugf("message without text content? %#v", msg)
 		return
 	}
 
-	groupJID := message.Info.RemoteJid
-	senderJID := message.Info.SenderJid
+	var text string
 
-	if len(senderJID) == 0 {
-		if message.Info.Source != nil && message.Info.Source.Participant != nil {
-			senderJID = *message.Info.Source.Participant
+	// nolint:nestif
+	if msg.GetExtendedTextMessage() == nil {
+		text = msg.GetConversation()
+	} else if msg.GetExtendedTextMessage().GetContextInfo() == nil {
+		// Handle pure text message with a link preview
+		// A pure text message with a link preview acts as an extended text message but will not contain any context info
+		text = msg.GetExtendedTextMessage().GetText()
+	} else {
+		text = msg.GetExtendedTextMessage().GetText()
+		ci := msg.GetExtendedTextMessage().GetContextInfo()
+
+		if senderJID == (types.JID{}) && ci.Participant != nil {
+			senderJID = types.NewJID(ci.GetParticipant(), types.DefaultUserServer)
 		}
-	}
 
-	// translate sender's JID to the nicest username we can get
-	senderName := b.getSenderName(senderJID)
-	if senderName == "" {
-		senderName = "Someone" // don't expose telephone number
-	}
+		if ci.MentionedJID != nil {
+			// handle user mentions
+			for _, mentionedJID := range ci.MentionedJID {
+				numberAndSuffix := strings.SplitN(mentionedJID, "@", 2)
 
-	extText := message.Info.Source.Message.ExtendedTextMessage
-	if extText != nil && extText.ContextInfo != nil && extText.ContextInfo.MentionedJid != nil {
-		// handle user mentions
-		for _, mentionedJID := range extText.ContextInfo.MentionedJid {
-			numberAndSuffix := strings.SplitN(mentionedJID, "@", 2)
+				// mentions comes as telephone numbers and we don't want to expose it to other bridges
+				// replace it with something more meaninful to others
+				mention := b.getSenderNotify(types.NewJID(numberAndSuffix[0], types.DefaultUserServer))
 
-			// mentions comes as telephone numbers and we don't want to expose it to other bridges
-			// replace it with something more meaninful to others
-			mention := b.getSenderNotify(numberAndSuffix[0] + "@s.whatsapp.net")
-			if mention == "" {
-				mention = "someone"
+				text = strings.Replace(text, "@"+numberAndSuffix[0], "@"+mention, 1)
 			}
-
-			message.Text = strings.Replace(message.Text, "@"+numberAndSuffix[0], "@"+mention, 1)
 		}
 	}
 
+	parentID := ""
+	if msg.GetExtendedTextMessage() != nil {
+		ci := msg.GetExtendedTextMessage().GetContextInfo()
+		parentID = getParentIdFromCtx(ci)
+	}
+
 	rmsg := config.Message{
-		UserID:   senderJID,
+		UserID:   senderJID.String(),
 		Username: senderName,
-		Text:     message.Text,
-		Channel:  groupJID,
+		Text:     text,
+		Channel:  channel.String(),
 		Account:  b.Account,
 		Protocol: b.Protocol,
 		Extra:    make(map[string][]interface{}),
-		//	ParentID: TODO, // TODO handle thread replies  // map from Info.QuotedMessageID string
-		ID: message.Info.Id,
+		ID:       getMessageIdFormat(senderJID, messageInfo.ID),
+		ParentID: parentID,
 	}
 
-	if avatarURL, exists := b.userAvatars[senderJID]; exists {
+	setForwarded(&rmsg, msg.GetExtendedTextMessage().GetContextInfo())
+
+	if avatarURL, exists := b.userAvatars[senderJID.String()]; exists {
 		rmsg.Avatar = avatarURL
 	}
 
@@ -135,37 +204,35 @@ func (b *Bwhatsapp) HandleTextMessage(message whatsapp.TextMessage) {
 }
 
 // HandleImageMessage sent from WhatsApp, relay it to the brige
-// nolint:funlen
-func (b *Bwhatsapp) HandleImageMessage(message whatsapp.ImageMessage) {
-	if message.Info.FromMe || message.Info.Timestamp < b.startedAt {
-		return
-	}
+func (b *Bwhatsapp) handleImageMessage(msg *events.Message) {
+	imsg := msg.Message.GetImageMessage()
 
-	senderJID := message.Info.SenderJid
-	if len(message.Info.SenderJid) == 0 && message.Info.Source != nil && message.Info.Source.Participant != nil {
-		senderJID = *message.Info.Source.Participant
-	}
+	senderJID := msg.Info.Sender
+	senderName := b.getSenderName(msg.Info)
+	ci := imsg.GetContextInfo()
 
-	senderName := b.getSenderName(message.Info.SenderJid)
-	if senderName == "" {
-		senderName = "Someone" // don't expose telephone number
+	if senderJID == (types.JID{}) && ci.Participant != nil {
+		senderJID = types.NewJID(ci.GetParticipant(), types.DefaultUserServer)
 	}
 
 	rmsg := config.Message{
-		UserID:   senderJID,
+		UserID:   senderJID.String(),
 		Username: senderName,
-		Channel:  message.Info.RemoteJid,
+		Channel:  msg.Info.Chat.String(),
 		Account:  b.Account,
 		Protocol: b.Protocol,
 		Extra:    make(map[string][]interface{}),
-		ID:       message.Info.Id,
+		ID:       getMessageIdFormat(senderJID, msg.Info.ID),
+		ParentID: getParentIdFromCtx(ci),
 	}
 
-	if avatarURL, exists := b.userAvatars[senderJID]; exists {
+	setForwarded(&rmsg, ci)
+
+	if avatarURL, exists := b.userAvatars[senderJID.String()]; exists {
 		rmsg.Avatar = avatarURL
 	}
 
-	fileExt, err := mime.ExtensionsByType(message.Type)
+	fileExt, err := mime.ExtensionsByType(imsg.GetMimetype())
 	if err != nil {
 		b.Log.Errorf("Mimetype detection error: %s", err)
 
@@ -182,20 +249,19 @@ func (b *Bwhatsapp) HandleImageMessage(message whatsapp.ImageMessage) {
 		fileExt[0] = ".jpg"
 	}
 
-	filename := fmt.Sprintf("%v%v", message.Info.Id, fileExt[0])
+	filename := fmt.Sprintf("%v%v", msg.Info.ID, fileExt[0])
 
-	b.Log.Debugf("Trying to download %s with type %s", filename, message.Type)
+	b.Log.Debugf("Trying to download %s with type %s", filename, imsg.GetMimetype())
 
-	data, err := message.Download()
-	if err != nil {
+	if err := b.downloadMedia(&rmsg, imsg, filename, imsg.GetCaption(), imsg.GetFileLength()); err != nil {
 		b.Log.Errorf("Download image failed: %s", err)
 
-		return
+		// the gateway relays the failure of files that are too big
+		if rmsg.Event != config.EventFileFailureSize {
+			return
+		}
 	}
 
-	// Move file to bridge storage
-	helper.HandleDownloadData(b.Log, &rmsg, filename, message.Caption, "", &data, b.General)
-
 	b.Log.Debugf("<= Sending message from %s on %s to gateway", senderJID, b.Account)
 	b.Log.Debugf("<= Message is %#v", rmsg)
 
@@ -203,36 +269,35 @@ func (b *Bwhatsapp) HandleImageMessage(message whatsapp.ImageMessage) {
 }
 
 // HandleVideoMessage downloads video messages
-func (b *Bwhatsapp) HandleVideoMessage(message whatsapp.VideoMessage) {
-	if message.Info.FromMe || message.Info.Timestamp < b.startedAt {
-		return
-	}
+func (b *Bwhatsapp) handleVideoMessage(msg *events.Message) {
+	imsg := msg.Message.GetVideoMessage()
 
-	senderJID := message.Info.SenderJid
-	if len(message.Info.SenderJid) == 0 && message.Info.Source != nil && message.Info.Source.Participant != nil {
-		senderJID = *message.Info.Source.Participant
-	}
+	senderJID := msg.Info.Sender
+	senderName := b.getSenderName(msg.Info)
+	ci := imsg.GetContextInfo()
 
-	senderName := b.getSenderName(message.Info.SenderJid)
-	if senderName == "" {
-		senderName = "Someone" // don't expose telephone number
+	if senderJID == (types.JID{}) && ci.Participant != nil {
+		senderJID = types.NewJID(ci.GetParticipant(), types.DefaultUserServer)
 	}
 
 	rmsg := config.Message{
-		UserID:   senderJID,
+		UserID:   senderJID.String(),
 		Username: senderName,
-		Channel:  message.Info.RemoteJid,
+		Channel:  msg.Info.Chat.String(),
 		Account:  b.Account,
 		Protocol: b.Protocol,
 		Extra:    make(map[string][]interface{}),
-		ID:       message.Info.Id,
+		ID:       getMessageIdFormat(senderJID, msg.Info.ID),
+		ParentID: getParentIdFromCtx(ci),
 	}
 
-	if avatarURL, exists := b.userAvatars[senderJID]; exists {
+	setForwarded(&rmsg, ci)
+
+	if avatarURL, exists := b.userAvatars[senderJID.String()]; exists {
 		rmsg.Avatar = avatarURL
 	}
 
-	fileExt, err := mime.ExtensionsByType(message.Type)
+	fileExt, err := mime.ExtensionsByType(imsg.GetMimetype())
 	if err != nil {
 		b.Log.Errorf("Mimetype detection error: %s", err)
 
@@ -243,20 +308,28 @@ func (b *Bwhatsapp) HandleVideoMessage(message whatsapp.VideoMessage) {
 		fileExt = append(fileExt, ".mp4")
 	}
 
-	filename := fmt.Sprintf("%v%v", message.Info.Id, fileExt[0])
+	// Prefer .mp4 extension, otherwise fallback to first index
+	fileExtIndex := 0
+	for i, n := range fileExt {
+		if ".mp4" == n {
+			fileExtIndex = i
+			break
+		}
+	}
 
-	b.Log.Debugf("Trying to download %s with size %#v and type %s", filename, message.Length, message.Type)
+	filename := fmt.Sprintf("%v%v", msg.Info.ID, fileExt[fileExtIndex])
 
-	data, err := message.Download()
-	if err != nil {
+	b.Log.Debugf("Trying to download %s with size %#v and type %s", filename, imsg.GetFileLength(), imsg.GetMimetype())
+
+	if err := b.downloadMedia(&rmsg, imsg, filename, imsg.GetCaption(), imsg.GetFileLength()); err != nil {
 		b.Log.Errorf("Download video failed: %s", err)
 
-		return
+		// the gateway relays the failure of files that are too big
+		if rmsg.Event != config.EventFileFailureSize {
+			return
+		}
 	}
 
-	// Move file to bridge storage
-	helper.HandleDownloadData(b.Log, &rmsg, filename, message.Caption, "", &data, b.General)
-
 	b.Log.Debugf("<= Sending message from %s on %s to gateway", senderJID, b.Account)
 	b.Log.Debugf("<= Message is %#v", rmsg)
 
@@ -264,36 +337,34 @@ func (b *Bwhatsapp) HandleVideoMessage(message whatsapp.VideoMessage) {
 }
 
 // HandleAudioMessage downloads audio messages
-func (b *Bwhatsapp) HandleAudioMessage(message whatsapp.AudioMessage) {
-	if message.Info.FromMe || message.Info.Timestamp < b.startedAt {
-		return
-	}
+func (b *Bwhatsapp) handleAudioMessage(msg *events.Message) {
+	imsg := msg.Message.GetAudioMessage()
 
-	senderJID := message.Info.SenderJid
-	if len(message.Info.SenderJid) == 0 && message.Info.Source != nil && message.Info.Source.Participant != nil {
-		senderJID = *message.Info.Source.Participant
-	}
+	senderJID := msg.Info.Sender
+	senderName := b.getSenderName(msg.Info)
+	ci := imsg.GetContextInfo()
 
-	senderName := b.getSenderName(message.Info.SenderJid)
-	if senderName == "" {
-		senderName = "Someone" // don't expose telephone number
+	if senderJID == (types.JID{}) && ci.Participant != nil {
+		senderJID = types.NewJID(ci.GetParticipant(), types.DefaultUserServer)
 	}
-
 	rmsg := config.Message{
-		UserID:   senderJID,
+		UserID:   senderJID.String(),
 		Username: senderName,
-		Channel:  message.Info.RemoteJid,
+		Channel:  msg.Info.Chat.String(),
 		Account:  b.Account,
 		Protocol: b.Protocol,
 		Extra:    make(map[string][]interface{}),
-		ID:       message.Info.Id,
+		ID:       getMessageIdFormat(senderJID, msg.Info.ID),
+		ParentID: getParentIdFromCtx(ci),
 	}
 
-	if avatarURL, exists := b.userAvatars[senderJID]; exists {
+	setForwarded(&rmsg, ci)
+
+	if avatarURL, exists := b.userAvatars[senderJID.String()]; exists {
 		rmsg.Avatar = avatarURL
 	}
 
-	fileExt, err := mime.ExtensionsByType(message.Type)
+	fileExt, err := mime.ExtensionsByType(imsg.GetMimetype())
 	if err != nil {
 		b.Log.Errorf("Mimetype detection error: %s", err)
 
@@ -304,20 +375,19 @@ func (b *Bwhatsapp) HandleAudioMessage(message whatsapp.AudioMessage) {
 		fileExt = append(fileExt, ".ogg")
 	}
 
-	filename := fmt.Sprintf("%v%v", message.Info.Id, fileExt[0])
+	filename := fmt.Sprintf("%v%v", msg.Info.ID, fileExt[0])
 
-	b.Log.Debugf("Trying to download %s with size %#v and type %s", filename, message.Length, message.Type)
+	b.Log.Debugf("Trying to download %s with size %#v and type %s", filename, imsg.GetFileLength(), imsg.GetMimetype())
 
-	data, err := message.Download()
-	if err != nil {
-		b.Log.Errorf("Download audio failed: %s", err)
+	if err := b.downloadMedia(&rmsg, imsg, filename, "audio message", imsg.GetFileLength()); err != nil {
+		b.Log.Errorf("Download video failed: %s", err)
 
-		return
+		// the gateway relays the failure of files that are too big
+		if rmsg.Event != config.EventFileFailureSize {
+			return
+		}
 	}
 
-	// Move file to bridge storage
-	helper.HandleDownloadData(b.Log, &rmsg, filename, "audio message", "", &data, b.General)
-
 	b.Log.Debugf("<= Sending message from %s on %s to gateway", senderJID, b.Account)
 	b.Log.Debugf("<= Message is %#v", rmsg)
 
@@ -325,58 +395,142 @@ func (b *Bwhatsapp) HandleAudioMessage(message whatsapp.AudioMessage) {
 }
 
 // HandleDocumentMessage downloads documents
-func (b *Bwhatsapp) HandleDocumentMessage(message whatsapp.DocumentMessage) {
-	if message.Info.FromMe || message.Info.Timestamp < b.startedAt {
-		return
-	}
+func (b *Bwhatsapp) handleDocumentMessage(msg *events.Message) {
+	imsg := msg.Message.GetDocumentMessage()
 
-	senderJID := message.Info.SenderJid
-	if len(message.Info.SenderJid) == 0 && message.Info.Source != nil && message.Info.Source.Participant != nil {
-		senderJID = *message.Info.Source.Participant
-	}
+	senderJID := msg.Info.Sender
+	senderName := b.getSenderName(msg.Info)
+	ci := imsg.GetContextInfo()
 
-	senderName := b.getSenderName(message.Info.SenderJid)
-	if senderName == "" {
-		senderName = "Someone" // don't expose telephone number
+	if senderJID == (types.JID{}) && ci.Participant != nil {
+		senderJID = types.NewJID(ci.GetParticipant(), types.DefaultUserServer)
 	}
 
 	rmsg := config.Message{
-		UserID:   senderJID,
+		UserID:   senderJID.String(),
 		Username: senderName,
-		Channel:  message.Info.RemoteJid,
+		Channel:  msg.Info.Chat.String(),
 		Account:  b.Account,
 		Protocol: b.Protocol,
 		Extra:    make(map[string][]interface{}),
-		ID:       message.Info.Id,
+		ID:       getMessageIdFormat(senderJID, msg.Info.ID),
+		ParentID: getParentIdFromCtx(ci),
 	}
 
-	if avatarURL, exists := b.userAvatars[senderJID]; exists {
+	setForwarded(&rmsg, ci)
+
+	if avatarURL, exists := b.userAvatars[senderJID.String()]; exists {
 		rmsg.Avatar = avatarURL
 	}
 
-	fileExt, err := mime.ExtensionsByType(message.Type)
+	fileExt, err := mime.ExtensionsByType(imsg.GetMimetype())
 	if err != nil {
 		b.Log.Errorf("Mimetype detection error: %s", err)
 
 		return
 	}
 
-	filename := fmt.Sprintf("%v", message.FileName)
+	filename := fmt.Sprintf("%v", imsg.GetFileName())
 
-	b.Log.Debugf("Trying to download %s with extension %s and type %s", filename, fileExt, message.Type)
+	b.Log.Debugf("Trying to download %s with extension %s and type %s", filename, fileExt, imsg.GetMimetype())
 
-	data, err := message.Download()
-	if err != nil {
+	if err := b.downloadMedia(&rmsg, imsg, filename, imsg.GetCaption(), imsg.GetFileLength()); err != nil {
 		b.Log.Errorf("Download document message failed: %s", err)
 
-		return
+		// the gateway relays the failure of files that are too big
+		if rmsg.Event != config.EventFileFailureSize {
+			return
+		}
 	}
 
-	// Move file to bridge storage
-	helper.HandleDownloadData(b.Log, &rmsg, filename, "document", "", &data, b.General)
-
 	b.Log.Debugf("<= Sending message from %s on %s to gateway", senderJID, b.Account)
 	b.Log.Debugf("<= Message is %#v", rmsg)
 
 	b.Remote <- rmsg
 }
+
+func (b *Bwhatsapp) handleDelete(info types.MessageInfo, messageInfo *proto.ProtocolMessage) {
+	sender := info.Sender
+	if participant := messageInfo.GetKey().GetParticipant(); participant != "" {
+		sender, _ = types.ParseJID(participant)
+	}
+
+	rmsg := config.Message{
+		Account:  b.Account,
+		Protocol: b.Protocol,
+		ID:       getMessageIdFormat(sender, messageInfo.GetKey().GetID()),
+		Event:    config.EventMsgDelete,
+		Text:     config.EventMsgDelete,
+		Channel:  info.Chat.String(),
+	}
+
+	b.Log.Debugf("<= Sending message from %s to gateway", b.Account)
+	b.Log.Debugf("<= Message is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
+// handleEdit relays the new text of an edited message, with the EditSuffix for the bridges
+// that don't edit natively.
+func (b *Bwhatsapp) handleEdit(info types.MessageInfo, messageInfo *proto.ProtocolMessage) {
+	if b.GetBool("EditDisable") {
+		return
+	}
+
+	edited := messageInfo.GetEditedMessage()
+	text := edited.GetConversation()
+	for _, t := range []string{
+		edited.GetExtendedTextMessage().GetText(),
+		edited.GetImageMessage().GetCaption(),
+		edited.GetVideoMessage().GetCaption(),
+		edited.GetDocumentMessage().GetCaption(),
+	} {
+		if text == "" {
+			text = t
+		}
+	}
+	if text == "" {
+		b.Log.Debugf("edit without text content? %#v", messageInfo)
+		return
+	}
+
+	rmsg := config.Message{
+		UserID:   info.Sender.String(),
+		Username: b.getSenderName(info),
+		Text:     text + b.GetString("EditSuffix"),
+		Channel:  info.Chat.String(),
+		Account:  b.Account,
+		Protocol: b.Protocol,
+		Extra:    make(map[string][]interface{}),
+		ID:       getMessageIdFormat(info.Sender, messageInfo.GetKey().GetID()),
+	}
+
+	if avatarURL, exists := b.userAvatars[info.Sender.String()]; exists {
+		rmsg.Avatar = avatarURL
+	}
+
+	b.Log.Debugf("<= Sending edit from %s on %s to gateway", info.Sender, b.Account)
+	b.Log.Debugf("<= Message is %#v", rmsg)
+	b.Remote <- rmsg
+}
+
+// downloadMedia adds the media of a message to rmsg, after checking its name and size
+// against the MediaDownload settings.
+func (b *Bwhatsapp) downloadMedia(rmsg *config.Message, media whatsmeow.DownloadableMessage, filename, comment string, size uint64) error {
+	if err := helper.HandleDownloadSize(b.Log, rmsg, filename, int64(size), b.General); err != nil {
+		return err
+	}
+
+	var data []byte
+	err := helper.Downloads.Fetch(func() error {
+		var err error
+		data, err = b.wc.Download(media)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	helper.HandleDownloadData(b.Log, rmsg, filename, comment, "", &data, b.General)
+
+	return nil
+}
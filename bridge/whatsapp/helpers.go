@@ -1,15 +1,21 @@
+//go:build whatsappmulti
+// +build whatsappmulti
+
 package bwhatsapp
 
 import (
-	"encoding/gob"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"os"
 	"strings"
 
-	qrcodeTerminal "github.com/Baozisoftware/qrcode-terminal-go"
-	"github.com/Rhymen/go-whatsapp"
+	"github.com/42wim/matterbridge/bridge/config"
+
+	goproto "google.golang.org/protobuf/proto"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
 )
 
 type ProfilePicInfo struct {
@@ -18,145 +24,219 @@ type ProfilePicInfo struct {
 	Status int16  `json:"status"`
 }
 
-func qrFromTerminal(invert bool) chan string {
-	qr := make(chan string)
+func (b *Bwhatsapp) reloadContacts() {
+	if _, err := b.wc.Store.Contacts.GetAllContacts(); err != nil {
+		b.Log.Errorf("error on update of contacts: %v", err)
+	}
 
-	go func() {
-		terminal := qrcodeTerminal.New()
+	allcontacts, err := b.wc.Store.Contacts.GetAllContacts()
+	if err != nil {
+		b.Log.Errorf("error on update of contacts: %v", err)
+	}
 
-		if invert {
-			terminal = qrcodeTerminal.New2(qrcodeTerminal.ConsoleColors.BrightWhite, qrcodeTerminal.ConsoleColors.BrightBlack, qrcodeTerminal.QRCodeRecoveryLevels.Medium)
-		}
+	if len(allcontacts) > 0 {
+		b.contacts = allcontacts
+	}
+}
+
+func (b *Bwhatsapp) getSenderName(info types.MessageInfo) string {
+	// Parse AD JID
+	var senderJid types.JID
+	senderJid.User, senderJid.Server = info.Sender.User, info.Sender.Server
+
+	sender, exists := b.contacts[senderJid]
+
+	if !exists || (sender.FullName == "" && sender.FirstName == "") {
+		b.reloadContacts() // Contacts may need to be reloaded
+		sender, exists = b.contacts[senderJid]
+	}
+
+	if exists && sender.FullName != "" {
+		return sender.FullName
+	}
+
+	if info.PushName != "" {
+		return info.PushName
+	}
 
-		terminal.Get(<-qr).Print()
-	}()
+	if exists && sender.FirstName != "" {
+		return sender.FirstName
+	}
 
-	return qr
+	return "Someone"
 }
 
-func (b *Bwhatsapp) readSession() (whatsapp.Session, error) {
-	session := whatsapp.Session{}
-	sessionFile := b.Config.GetString(sessionFile)
+func (b *Bwhatsapp) getSenderNameFromJID(senderJid types.JID) string {
+	sender, exists := b.contacts[senderJid]
 
-	if sessionFile == "" {
-		return session, errors.New("if you won't set SessionFile then you will need to scan QR code on every restart")
+	if !exists || (sender.FullName == "" && sender.FirstName == "") {
+		b.reloadContacts() // Contacts may need to be reloaded
+		sender, exists = b.contacts[senderJid]
 	}
 
-	file, err := os.Open(sessionFile)
-	if err != nil {
-		return session, err
+	if exists && sender.FullName != "" {
+		return sender.FullName
 	}
 
-	defer file.Close()
+	if exists && sender.FirstName != "" {
+		return sender.FirstName
+	}
 
-	decoder := gob.NewDecoder(file)
+	if sender.PushName != "" {
+		return sender.PushName
+	}
 
-	return session, decoder.Decode(&session)
+	return "Someone"
 }
 
-func (b *Bwhatsapp) writeSession(session whatsapp.Session) error {
-	sessionFile := b.Config.GetString(sessionFile)
+func (b *Bwhatsapp) getSenderNotify(senderJid types.JID) string {
+	sender, exists := b.contacts[senderJid]
 
-	if sessionFile == "" {
-		// we already sent a warning while starting the bridge, so let's be quiet here
-		return nil
+	if !exists || (sender.FullName == "" && sender.PushName == "" && sender.FirstName == "") {
+		b.reloadContacts() // Contacts may need to be reloaded
+		sender, exists = b.contacts[senderJid]
 	}
 
-	file, err := os.Create(sessionFile)
-	if err != nil {
-		return err
+	if !exists {
+		return "someone"
+	}
+
+	if exists && sender.FullName != "" {
+		return sender.FullName
 	}
 
-	defer file.Close()
+	if exists && sender.PushName != "" {
+		return sender.PushName
+	}
 
-	encoder := gob.NewEncoder(file)
+	if exists && sender.FirstName != "" {
+		return sender.FirstName
+	}
 
-	return encoder.Encode(session)
+	return "someone"
 }
 
-func (b *Bwhatsapp) restoreSession() (*whatsapp.Session, error) {
-	session, err := b.readSession()
+func (b *Bwhatsapp) GetProfilePicThumb(jid string) (*types.ProfilePictureInfo, error) {
+	pjid, _ := types.ParseJID(jid)
+
+	info, err := b.wc.GetProfilePictureInfo(pjid, &whatsmeow.GetProfilePictureParams{
+		Preview: true,
+	})
 	if err != nil {
-		b.Log.Warn(err.Error())
+		return nil, fmt.Errorf("failed to get avatar: %v", err)
+	}
+
+	return info, nil
+}
+
+func isGroupJid(identifier string) bool {
+	return strings.HasSuffix(identifier, "@g.us") ||
+		strings.HasSuffix(identifier, "@temp") ||
+		strings.HasSuffix(identifier, "@broadcast")
+}
+
+// sessionDatabase returns the sqlite database of the device for SessionFile, SessionFile
+// with .db appended. The .gob session files of the legacy bridge are replaced by a database
+// next to them, legacy is then true.
+func sessionDatabase(sessionFile string) (path string, legacy bool) {
+	if strings.HasSuffix(sessionFile, ".db") {
+		return sessionFile, false
 	}
+	if strings.HasSuffix(sessionFile, ".gob") {
+		return strings.TrimSuffix(sessionFile, ".gob") + ".db", true
+	}
+	return sessionFile + ".db", false
+}
 
-	b.Log.Debugln("Restoring WhatsApp session..")
+func (b *Bwhatsapp) getDevice() (*store.Device, error) {
+	device := &store.Device{}
 
-	session, err = b.conn.RestoreWithSession(session)
+	path, legacy := sessionDatabase(b.GetString("SessionFile"))
+	if legacy {
+		b.Log.Warnf("SessionFile %s is a session of the legacy whatsapp bridge, it can't be used anymore: link the device again, it's stored in %s", b.GetString("SessionFile"), path)
+	}
+	storeContainer, err := sqlstore.New("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout=10000", nil)
 	if err != nil {
-		// restore session connection timed out (I couldn't get over it without logging in again)
-		return nil, errors.New("failed to restore session: " + err.Error())
+		return device, fmt.Errorf("failed to connect to database: %v", err)
 	}
 
-	b.Log.Debugln("Session restored successfully!")
+	device, err = storeContainer.GetFirstDevice()
+	if err != nil {
+		return device, fmt.Errorf("failed to get device: %v", err)
+	}
 
-	return &session, nil
+	return device, nil
 }
 
-func (b *Bwhatsapp) getSenderName(senderJid string) string {
-	if sender, exists := b.users[senderJid]; exists {
-		if sender.Name != "" {
-			return sender.Name
-		}
-		// if user is not in phone contacts
-		// it is the most obvious scenario unless you sync your phone contacts with some remote updated source
-		// users can change it in their WhatsApp settings -> profile -> click on Avatar
-		if sender.Notify != "" {
-			return sender.Notify
-		}
-
-		if sender.Short != "" {
-			return sender.Short
-		}
+func (b *Bwhatsapp) getNewReplyContext(parentID string) (*proto.ContextInfo, error) {
+	replyInfo, err := b.parseMessageID(parentID)
+	if err != nil {
+		return nil, err
 	}
 
-	// try to reload this contact
-	if _, err := b.conn.Contacts(); err != nil {
-		b.Log.Errorf("error on update of contacts: %v", err)
+	sender := fmt.Sprintf("%s@%s", replyInfo.Sender.User, replyInfo.Sender.Server)
+	ctx := &proto.ContextInfo{
+		StanzaID:      &replyInfo.MessageID,
+		Participant:   &sender,
+		QuotedMessage: &proto.Message{Conversation: goproto.String("")},
 	}
 
-	if contact, exists := b.conn.Store.Contacts[senderJid]; exists {
-		// Add it to the user map
-		b.users[senderJid] = contact
+	return ctx, nil
+}
 
-		if contact.Name != "" {
-			return contact.Name
-		}
-		// if user is not in phone contacts
-		// same as above
-		return contact.Notify
+func (b *Bwhatsapp) parseMessageID(id string) (*Replyable, error) {
+	// No message ID in case action is executed on a message sent before the bridge was started
+	// and then the bridge cache doesn't have this message ID mapped
+	if id == "" {
+		return &Replyable{MessageID: id}, nil
 	}
 
-	return ""
-}
+	replyInfo := strings.Split(id, "/")
+
+	if len(replyInfo) == 2 {
+		sender, err := types.ParseJID(replyInfo[0])
 
-func (b *Bwhatsapp) getSenderNotify(senderJid string) string {
-	if sender, exists := b.users[senderJid]; exists {
-		return sender.Notify
+		if err == nil {
+			return &Replyable{
+				MessageID: types.MessageID(replyInfo[1]),
+				Sender:    sender,
+			}, nil
+		}
 	}
 
-	return ""
+	err := fmt.Errorf("MessageID does not match format of {senderJID}:{messageID} : \"%s\"", id)
+
+	return &Replyable{MessageID: id}, err
 }
 
-func (b *Bwhatsapp) GetProfilePicThumb(jid string) (*ProfilePicInfo, error) {
-	data, err := b.conn.GetProfilePicThumb(jid)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get avatar: %v", err)
+// setForwarded sets the ForwardedFrom of rmsg when its context ci is of a forwarded message.
+// WhatsApp doesn't tell who sent a forwarded message, only the channels (newsletters) it was
+// forwarded from.
+func setForwarded(rmsg *config.Message, ci *proto.ContextInfo) {
+	if !ci.GetIsForwarded() {
+		return
+	}
+	rmsg.Extra[config.ExtraForwarded] = []interface{}{true}
+	rmsg.ForwardedFrom = ci.GetForwardedNewsletterMessageInfo().GetNewsletterName()
+	if rmsg.ForwardedFrom == "" {
+		rmsg.ForwardedFrom = config.ForwardedUnknown
 	}
+}
 
-	content := <-data
-	info := &ProfilePicInfo{}
+func getParentIdFromCtx(ci *proto.ContextInfo) string {
+	if ci != nil && ci.StanzaID != nil {
+		senderJid, err := types.ParseJID(*ci.Participant)
 
-	err = json.Unmarshal([]byte(content), info)
-	if err != nil {
-		return info, fmt.Errorf("failed to unmarshal avatar info: %v", err)
+		if err == nil {
+			return getMessageIdFormat(senderJid, *ci.StanzaID)
+		}
 	}
 
-	return info, nil
+	return ""
 }
 
-func isGroupJid(identifier string) bool {
-	return strings.HasSuffix(identifier, "@g.us") ||
-		strings.HasSuffix(identifier, "@temp") ||
-		strings.HasSuffix(identifier, "@broadcast")
+func getMessageIdFormat(jid types.JID, messageID string) string {
+	// we're crafting our own JID str as AD JID format messes with how stuff looks on a webclient
+	jidStr := fmt.Sprintf("%s@%s", jid.User, jid.Server)
+	return fmt.Sprintf("%s/%s", jidStr, messageID)
 }
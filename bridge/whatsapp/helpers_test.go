@@ -0,0 +1,44 @@
+//go:build whatsappmulti
+// +build whatsappmulti
+
+package bwhatsapp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestSessionDatabase(t *testing.T) {
+	for _, tc := range []struct {
+		sessionFile, path string
+		legacy            bool
+	}{
+		{"session-48111222333", "session-48111222333.db", false},
+		{"session-48111222333.db", "session-48111222333.db", false},
+		{"session-48111222333.gob", "session-48111222333.db", true},
+	} {
+		path, legacy := sessionDatabase(tc.sessionFile)
+		assert.Equal(t, tc.path, path, tc.sessionFile)
+		assert.Equal(t, tc.legacy, legacy, tc.sessionFile)
+	}
+}
+
+func TestMessageID(t *testing.T) {
+	jid := types.NewJID("48111222333", types.DefaultUserServer)
+	id := getMessageIdFormat(jid, "3EB0ABC")
+	assert.Equal(t, "48111222333@s.whatsapp.net/3EB0ABC", id)
+
+	reply, err := (&Bwhatsapp{}).parseMessageID(id)
+	require.NoError(t, err)
+	assert.Equal(t, jid, reply.Sender)
+	assert.Equal(t, types.MessageID("3EB0ABC"), reply.MessageID)
+
+	_, err = (&Bwhatsapp{}).parseMessageID("3EB0ABC")
+	assert.Error(t, err)
+
+	assert.True(t, isGroupJid("123-456@g.us"))
+	assert.False(t, isGroupJid("48111222333@s.whatsapp.net"))
+}
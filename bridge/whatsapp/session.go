@@ -0,0 +1,69 @@
+//go:build whatsappmulti
+// +build whatsappmulti
+
+package bwhatsapp
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+const (
+	// phoneInactiveLimit is how long WhatsApp keeps linked devices when the phone of the
+	// account isn't used, as documented in its FAQ about linked devices
+	phoneInactiveLimit = 14 * 24 * time.Hour
+	phoneSeenKey       = "phone_seen"
+)
+
+// phoneActivity is when the phone of the account was last used, it's kept in the store so
+// restarts don't forget it.
+type phoneActivity struct {
+	sync.Mutex
+	seen   time.Time
+	bucket *store.Bucket
+}
+
+// update records that the phone was used at t. The store is written at most once an hour.
+func (p *phoneActivity) update(t time.Time) {
+	p.Lock()
+	defer p.Unlock()
+	if !t.After(p.seen) {
+		return
+	}
+	write := t.Sub(p.seen) > time.Hour
+	p.seen = t
+	if write {
+		_ = p.bucket.SetString(phoneSeenKey, strconv.FormatInt(t.Unix(), 10))
+	}
+}
+
+func (p *phoneActivity) last() (time.Time, bool) {
+	p.Lock()
+	defer p.Unlock()
+	if p.seen.IsZero() {
+		v, ok := p.bucket.GetString(phoneSeenKey)
+		if !ok {
+			return time.Time{}, false
+		}
+		unix, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		p.seen = time.Unix(unix, 0)
+	}
+	return p.seen, true
+}
+
+// CredentialsExpiry returns when WhatsApp unlinks the bridge if the phone isn't used. It's
+// known after pairing or after the phone sent a message, with a persistent StoreBackend
+// also after restarts.
+func (b *Bwhatsapp) CredentialsExpiry() (time.Time, bool) {
+	seen, ok := b.phone.last()
+	if !ok {
+		return time.Time{}, false
+	}
+	return seen.Add(phoneInactiveLimit), true
+}
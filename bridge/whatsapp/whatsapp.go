@@ -1,9 +1,10 @@
+//go:build whatsappmulti
+// +build whatsappmulti
+
 package bwhatsapp
 
 import (
-	"bytes"
-	"crypto/rand"
-	"encoding/hex"
+	"context"
 	"errors"
 	"fmt"
 	"mime"
@@ -14,37 +15,48 @@ import (
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
-	"github.com/Rhymen/go-whatsapp"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/mdp/qrterminal"
+	"rsc.io/qr"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+	waLog "go.mau.fi/whatsmeow/util/log"
+
+	goproto "google.golang.org/protobuf/proto"
+
+	_ "modernc.org/sqlite" // needed for sqlite
 )
 
 const (
 	// Account config parameters
 	cfgNumber         = "Number"
 	qrOnWhiteTerminal = "QrOnWhiteTerminal"
-	sessionFile       = "SessionFile"
 )
 
 // Bwhatsapp Bridge structure keeping all the information needed for relying
 type Bwhatsapp struct {
 	*bridge.Config
 
-	session   *whatsapp.Session
-	conn      *whatsapp.Conn
-	startedAt uint64
+	startedAt    time.Time
+	wc           *whatsmeow.Client
+	contacts     map[types.JID]types.ContactInfo
+	users        map[string]types.ContactInfo
+	userAvatars  map[string]string
+	joinedGroups []*types.GroupInfo
+	phone        *phoneActivity
+}
 
-	users       map[string]whatsapp.Contact
-	userAvatars map[string]string
+type Replyable struct {
+	MessageID types.MessageID
+	Sender    types.JID
 }
 
 // New Create a new WhatsApp bridge. This will be called for each [whatsapp.<server>] entry you have in the config file
 func New(cfg *bridge.Config) bridge.Bridger {
 	number := cfg.GetString(cfgNumber)
 
-	cfg.Log.Warn("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
-	cfg.Log.Warn("This bridge is deprecated and not supported anymore. Use the new multidevice whatsapp bridge")
-	cfg.Log.Warn("See https://github.com/42wim/matterbridge#building-with-whatsapp-beta-multidevice-support for more info")
-	cfg.Log.Warn("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
-
 	if number == "" {
 		cfg.Log.Fatalf("Missing configuration for WhatsApp bridge: Number")
 	}
@@ -52,8 +64,9 @@ func New(cfg *bridge.Config) bridge.Bridger {
 	b := &Bwhatsapp{
 		Config: cfg,
 
-		users:       make(map[string]whatsapp.Contact),
+		users:       make(map[string]types.ContactInfo),
 		userAvatars: make(map[string]string),
+		phone:       &phoneActivity{bucket: cfg.NewBucket("session")},
 	}
 
 	return b
@@ -61,101 +74,99 @@ func New(cfg *bridge.Config) bridge.Bridger {
 
 // Connect to WhatsApp. Required implementation of the Bridger interface
 func (b *Bwhatsapp) Connect() error {
+	device, err := b.getDevice()
+	if err != nil {
+		return err
+	}
+
 	number := b.GetString(cfgNumber)
 	if number == "" {
 		return errors.New("whatsapp's telephone number need to be configured")
 	}
 
 	b.Log.Debugln("Connecting to WhatsApp..")
-	conn, err := whatsapp.NewConn(20 * time.Second)
-	if err != nil {
-		return errors.New("failed to connect to WhatsApp: " + err.Error())
-	}
 
-	b.conn = conn
+	b.wc = whatsmeow.NewClient(device, waLog.Stdout("Client", "INFO", true))
+	b.wc.AddEventHandler(b.eventHandler)
 
-	b.conn.AddHandler(b)
-	b.Log.Debugln("WhatsApp connection successful")
+	firstlogin := false
+	var qrChan <-chan whatsmeow.QRChannelItem
+	if b.wc.Store.ID == nil {
+		firstlogin = true
+		qrChan, err = b.wc.GetQRChannel(context.Background())
+		if err != nil && !errors.Is(err, whatsmeow.ErrQRStoreContainsID) {
+			return errors.New("failed to to get QR channel:" + err.Error())
+		}
+	}
 
-	// load existing session in order to keep it between restarts
-	b.session, err = b.restoreSession()
+	err = b.wc.Connect()
 	if err != nil {
-		b.Log.Warn(err.Error())
+		return errors.New("failed to connect to WhatsApp: " + err.Error())
 	}
 
-	// login to a new session
-	if b.session == nil {
-		if err = b.Login(); err != nil {
-			return err
+	if b.wc.Store.ID == nil {
+		for evt := range qrChan {
+			if evt.Event == "code" {
+				b.printQR(evt.Code)
+				b.postQR(evt.Code)
+			} else {
+				b.Log.Infof("QR channel result: %s", evt.Event)
+			}
 		}
 	}
 
-	b.startedAt = uint64(time.Now().Unix())
+	// disconnect and reconnect on our first login/pairing
+	// for some reason the GetJoinedGroups in JoinChannel doesn't work on first login
+	if firstlogin {
+		b.phone.update(time.Now())
+		b.wc.Disconnect()
+		time.Sleep(time.Second)
 
-	_, err = b.conn.Contacts()
-	if err != nil {
-		return fmt.Errorf("error on update of contacts: %v", err)
+		err = b.wc.Connect()
+		if err != nil {
+			return errors.New("failed to connect to WhatsApp: " + err.Error())
+		}
 	}
 
-	// see https://github.com/Rhymen/go-whatsapp/issues/137#issuecomment-480316013
-	for len(b.conn.Store.Contacts) == 0 {
-		b.conn.Contacts() // nolint:errcheck
+	b.Log.Infoln("WhatsApp connection successful")
 
-		<-time.After(1 * time.Second)
+	b.contacts, err = b.wc.Store.Contacts.GetAllContacts()
+	if err != nil {
+		return errors.New("failed to get contacts: " + err.Error())
 	}
 
+	b.joinedGroups, err = b.wc.GetJoinedGroups()
+	if err != nil {
+		return errors.New("failed to get list of joined groups: " + err.Error())
+	}
+
+	b.startedAt = time.Now()
+
 	// map all the users
-	for id, contact := range b.conn.Store.Contacts {
-		if !isGroupJid(id) && id != "status@broadcast" {
+	for id, contact := range b.contacts {
+		if !isGroupJid(id.String()) && id.String() != "status@broadcast" {
 			// it is user
-			b.users[id] = contact
+			b.users[id.String()] = contact
 		}
 	}
 
 	// get user avatar asynchronously
-	go func() {
-		b.Log.Debug("Getting user avatars..")
+	b.Log.Info("Getting user avatars..")
 
-		for jid := range b.users {
-			info, err := b.GetProfilePicThumb(jid)
-			if err != nil {
-				b.Log.Warnf("Could not get profile photo of %s: %v", jid, err)
-			} else {
-				b.Lock()
+	for jid := range b.users {
+		info, err := b.GetProfilePicThumb(jid)
+		if err != nil {
+			b.Log.Warnf("Could not get profile photo of %s: %v", jid, err)
+		} else {
+			b.Lock()
+			if info != nil {
 				b.userAvatars[jid] = info.URL
-				b.Unlock()
 			}
+			b.Unlock()
 		}
-
-		b.Log.Debug("Finished getting avatars..")
-	}()
-
-	return nil
-}
-
-// Login to WhatsApp creating a new session. This will require to scan a QR code on your mobile device
-func (b *Bwhatsapp) Login() error {
-	b.Log.Debugln("Logging in..")
-
-	invert := b.GetBool(qrOnWhiteTerminal) // false is the default
-	qrChan := qrFromTerminal(invert)
-
-	session, err := b.conn.Login(qrChan)
-	if err != nil {
-		b.Log.Warnln("Failed to log in:", err)
-
-		return err
 	}
 
-	b.session = &session
-
-	b.Log.Infof("Logged into session: %#v", session)
-	b.Log.Infof("Connection: %#v", b.conn)
-
-	err = b.writeSession(session)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error saving session: %v\n", err)
-	}
+	b.Log.Info("Finished getting avatars..")
 
 	return nil
 }
@@ -163,8 +174,8 @@ func (b *Bwhatsapp) Login() error {
 // Disconnect is called while reconnecting to the bridge
 // Required implementation of the Bridger interface
 func (b *Bwhatsapp) Disconnect() error {
-	// We could Logout, but that would close the session completely and would require a new QR code scan
-	// https://github.com/Rhymen/go-whatsapp/blob/c31092027237441cffba1b9cb148eadf7c83c3d2/session.go#L377-L381
+	b.wc.Disconnect()
+
 	return nil
 }
 
@@ -174,75 +185,82 @@ func (b *Bwhatsapp) Disconnect() error {
 func (b *Bwhatsapp) JoinChannel(channel config.ChannelInfo) error {
 	byJid := isGroupJid(channel.Name)
 
-	// see https://github.com/Rhymen/go-whatsapp/issues/137#issuecomment-480316013
-	for len(b.conn.Store.Contacts) == 0 {
-		b.conn.Contacts() // nolint:errcheck
-		<-time.After(1 * time.Second)
-	}
-
 	// verify if we are member of the given group
 	if byJid {
-		// channel.Name specifies static group jID, not the name
-		if _, exists := b.conn.Store.Contacts[channel.Name]; !exists {
-			return fmt.Errorf("account doesn't belong to group with jid %s", channel.Name)
+		gJID, err := types.ParseJID(channel.Name)
+		if err != nil {
+			return err
 		}
 
-		return nil
+		for _, group := range b.joinedGroups {
+			if group.JID == gJID {
+				return nil
+			}
+		}
 	}
 
-	// channel.Name specifies group name that might change, warn about it
-	var jids []string
-	for id, contact := range b.conn.Store.Contacts {
-		if isGroupJid(id) && contact.Name == channel.Name {
-			jids = append(jids, id)
+	foundGroups := []string{}
+
+	for _, group := range b.joinedGroups {
+		if group.Name == channel.Name {
+			foundGroups = append(foundGroups, group.Name)
 		}
 	}
 
-	switch len(jids) {
+	switch len(foundGroups) {
 	case 0:
 		// didn't match any group - print out possibilites
-		for id, contact := range b.conn.Store.Contacts {
-			if isGroupJid(id) {
-				b.Log.Infof("%s %s", contact.Jid, contact.Name)
-			}
+		for _, group := range b.joinedGroups {
+			b.Log.Infof("%s %s", group.JID, group.Name)
 		}
-
 		return fmt.Errorf("please specify group's JID from the list above instead of the name '%s'", channel.Name)
 	case 1:
-		return fmt.Errorf("group name might change. Please configure gateway with channel=\"%v\" instead of channel=\"%v\"", jids[0], channel.Name)
+		return fmt.Errorf("group name might change. Please configure gateway with channel=\"%v\" instead of channel=\"%v\"", foundGroups[0], channel.Name)
 	default:
-		return fmt.Errorf("there is more than one group with name '%s'. Please specify one of JIDs as channel name: %v", channel.Name, jids)
+		return fmt.Errorf("there is more than one group with name '%s'. Please specify one of JIDs as channel name: %v", channel.Name, foundGroups)
 	}
 }
 
 // Post a document message from the bridge to WhatsApp
 func (b *Bwhatsapp) PostDocumentMessage(msg config.Message, filetype string) (string, error) {
+	groupJID, _ := types.ParseJID(msg.Channel)
+
 	fi := msg.Extra["file"][0].(config.FileInfo)
 
-	// Post document message
-	message := whatsapp.DocumentMessage{
-		Info: whatsapp.MessageInfo{
-			RemoteJid: msg.Channel,
-		},
-		Title:    fi.Name,
-		FileName: fi.Name,
-		Type:     filetype,
-		Content:  bytes.NewReader(*fi.Data),
+	caption := msg.Username + fi.Comment
+
+	resp, err := b.upload(&fi, whatsmeow.MediaDocument)
+	if err != nil {
+		return "", err
 	}
 
-	b.Log.Debugf("=> Sending %#v", msg)
+	// Post document message
+	var message proto.Message
+	var ctx *proto.ContextInfo
+	if msg.ParentID != "" {
+		ctx, _ = b.getNewReplyContext(msg.ParentID)
+	}
 
-	// create message ID
-	// TODO follow and act if https://github.com/Rhymen/go-whatsapp/issues/101 implemented
-	idBytes := make([]byte, 10)
-	if _, err := rand.Read(idBytes); err != nil {
-		b.Log.Warn(err.Error())
+	message.DocumentMessage = &proto.DocumentMessage{
+		Title:         &fi.Name,
+		FileName:      &fi.Name,
+		Mimetype:      &filetype,
+		Caption:       &caption,
+		MediaKey:      resp.MediaKey,
+		FileEncSHA256: resp.FileEncSHA256,
+		FileSHA256:    resp.FileSHA256,
+		FileLength:    goproto.Uint64(resp.FileLength),
+		URL:           &resp.URL,
+		DirectPath:    &resp.DirectPath,
+		ContextInfo:   ctx,
 	}
 
-	message.Info.Id = strings.ToUpper(hex.EncodeToString(idBytes))
-	_, err := b.conn.Send(message)
+	b.Log.Debugf("=> Sending %#v as a document", msg)
 
-	return message.Info.Id, err
+	ID := whatsmeow.GenerateMessageID()
+	_, err = b.wc.SendMessage(context.TODO(), groupJID, &message, whatsmeow.SendRequestExtra{ID: ID})
+
+	return ID, err
 }
 
 // Post an image message from the bridge to WhatsApp
@@ -250,35 +268,119 @@ func (b *Bwhatsapp) PostDocumentMessage(msg config.Message, filetype string) (st
 func (b *Bwhatsapp) PostImageMessage(msg config.Message, filetype string) (string, error) {
 	fi := msg.Extra["file"][0].(config.FileInfo)
 
-	// Post image message
-	message := whatsapp.ImageMessage{
-		Info: whatsapp.MessageInfo{
-			RemoteJid: msg.Channel,
-		},
-		Type:    filetype,
-		Caption: msg.Username + fi.Comment,
-		Content: bytes.NewReader(*fi.Data),
+	caption := msg.Username + fi.Comment
+
+	resp, err := b.upload(&fi, whatsmeow.MediaImage)
+	if err != nil {
+		return "", err
 	}
 
-	b.Log.Debugf("=> Sending %#v", msg)
+	var message proto.Message
+	var ctx *proto.ContextInfo
+	if msg.ParentID != "" {
+		ctx, _ = b.getNewReplyContext(msg.ParentID)
+	}
 
-	// create message ID
-	// TODO follow and act if https://github.com/Rhymen/go-whatsapp/issues/101 implemented
-	idBytes := make([]byte, 10)
-	if _, err := rand.Read(idBytes); err != nil {
-		b.Log.Warn(err.Error())
+	message.ImageMessage = &proto.ImageMessage{
+		Mimetype:      &filetype,
+		Caption:       &caption,
+		MediaKey:      resp.MediaKey,
+		FileEncSHA256: resp.FileEncSHA256,
+		FileSHA256:    resp.FileSHA256,
+		FileLength:    goproto.Uint64(resp.FileLength),
+		URL:           &resp.URL,
+		DirectPath:    &resp.DirectPath,
+		ContextInfo:   ctx,
 	}
 
-	message.Info.Id = strings.ToUpper(hex.EncodeToString(idBytes))
-	_, err := b.conn.Send(message)
+	b.Log.Debugf("=> Sending %#v as an image", msg)
 
-	return message.Info.Id, err
+	return b.sendMessage(msg, &message)
+}
+
+// Post a video message from the bridge to WhatsApp
+func (b *Bwhatsapp) PostVideoMessage(msg config.Message, filetype string) (string, error) {
+	fi := msg.Extra["file"][0].(config.FileInfo)
+
+	caption := msg.Username + fi.Comment
+
+	resp, err := b.upload(&fi, whatsmeow.MediaVideo)
+	if err != nil {
+		return "", err
+	}
+
+	var message proto.Message
+	var ctx *proto.ContextInfo
+	if msg.ParentID != "" {
+		ctx, _ = b.getNewReplyContext(msg.ParentID)
+	}
+
+	message.VideoMessage = &proto.VideoMessage{
+		Mimetype:      &filetype,
+		Caption:       &caption,
+		MediaKey:      resp.MediaKey,
+		FileEncSHA256: resp.FileEncSHA256,
+		FileSHA256:    resp.FileSHA256,
+		FileLength:    goproto.Uint64(resp.FileLength),
+		URL:           &resp.URL,
+		DirectPath:    &resp.DirectPath,
+		ContextInfo:   ctx,
+	}
+
+	b.Log.Debugf("=> Sending %#v as a video", msg)
+
+	return b.sendMessage(msg, &message)
+}
+
+// Post audio inline
+func (b *Bwhatsapp) PostAudioMessage(msg config.Message, filetype string) (string, error) {
+	groupJID, _ := types.ParseJID(msg.Channel)
+
+	fi := msg.Extra["file"][0].(config.FileInfo)
+
+	resp, err := b.upload(&fi, whatsmeow.MediaAudio)
+	if err != nil {
+		return "", err
+	}
+
+	var message proto.Message
+	var ctx *proto.ContextInfo
+	if msg.ParentID != "" {
+		ctx, _ = b.getNewReplyContext(msg.ParentID)
+	}
+
+	message.AudioMessage = &proto.AudioMessage{
+		Mimetype:      &filetype,
+		MediaKey:      resp.MediaKey,
+		FileEncSHA256: resp.FileEncSHA256,
+		FileSHA256:    resp.FileSHA256,
+		FileLength:    goproto.Uint64(resp.FileLength),
+		URL:           &resp.URL,
+		DirectPath:    &resp.DirectPath,
+		ContextInfo:   ctx,
+	}
+
+	b.Log.Debugf("=> Sending %#v as audio", msg)
+
+	ID, err := b.sendMessage(msg, &message)
+
+	var captionMessage proto.Message
+	caption := msg.Username + fi.Comment + "\u2B06" // the char on the end is upwards arrow emoji
+	captionMessage.Conversation = &caption
+
+	captionID := whatsmeow.GenerateMessageID()
+	_, err = b.wc.SendMessage(context.TODO(), groupJID, &captionMessage, whatsmeow.SendRequestExtra{ID: captionID})
+
+	return ID, err
 }
 
 // Send a message from the bridge to WhatsApp
-// Required implementation of the Bridger interface
-// https://github.com/42wim/matterbridge/blob/2cfd880cdb0df29771bf8f31df8d990ab897889d/bridge/bridge.go#L11-L16
 func (b *Bwhatsapp) Send(msg config.Message) (string, error) {
+	groupJID, _ := types.ParseJID(msg.Channel)
+
+	extendedMsgID, _ := b.parseMessageID(msg.ID)
+	msg.ID = extendedMsgID.MessageID
+
 	b.Log.Debugf("=> Receiving %#v", msg)
 
 	// Delete message
@@ -289,53 +391,169 @@ func (b *Bwhatsapp) Send(msg config.Message) (string, error) {
 			return "", nil
 		}
 
-		_, err := b.conn.RevokeMessage(msg.Channel, msg.ID, true)
+		_, err := b.wc.RevokeMessage(groupJID, msg.ID)
 
 		return "", err
 	}
 
-	// Edit message
+	// Edit message, only our own messages can be edited
 	if msg.ID != "" {
 		b.Log.Debugf("updating message with id %s", msg.ID)
 
-		if b.GetString("editsuffix") != "" {
-			msg.Text += b.GetString("EditSuffix")
-		} else {
-			msg.Text += " (edited)"
+		text := msg.Username + msg.Text
+		_, err := b.wc.SendMessage(context.TODO(), groupJID, b.wc.BuildEdit(groupJID, msg.ID, &proto.Message{Conversation: &text}))
+
+		return getMessageIdFormat(*b.wc.Store.ID, msg.ID), err
+	}
+
+	if msg.Extra != nil {
+		for _, rmsg := range helper.HandleExtra(&msg, b.General) {
+			if _, err := b.Send(rmsg); err != nil {
+				b.Log.Errorf("Could not send extra message: %s", err)
+			}
 		}
 	}
 
 	// Handle Upload a file
-	if msg.Extra["file"] != nil {
-		fi := msg.Extra["file"][0].(config.FileInfo)
-		filetype := mime.TypeByExtension(filepath.Ext(fi.Name))
+	if len(msg.Extra["file"]) > 0 {
+		return b.handleUploadFiles(msg)
+	}
+
+	var message proto.Message
+	text := msg.Username + msg.Text
+
+	// If we have a parent ID send an extended message
+	if msg.ParentID != "" {
+		replyContext, err := b.getNewReplyContext(msg.ParentID)
+
+		if err == nil {
+			message = proto.Message{
+				ExtendedTextMessage: &proto.ExtendedTextMessage{
+					Text:        &text,
+					ContextInfo: replyContext,
+				},
+			}
+
+			return b.sendMessage(msg, &message)
+		}
+	}
+
+	message.Conversation = &text
+
+	return b.sendMessage(msg, &message)
+}
+
+// handleUploadFiles posts the files of msg, files that weren't downloaded are posted as a link.
+func (b *Bwhatsapp) handleUploadFiles(msg config.Message) (string, error) {
+	var msgID string
+
+	for _, f := range msg.Extra["file"] {
+		fi := f.(config.FileInfo)
+		fmsg := msg
+		fmsg.Extra = map[string][]interface{}{"file": {fi}}
+
+		if fi.Data == nil && fi.Media == nil {
+			if fi.URL == "" {
+				continue
+			}
+
+			text := msg.Username + strings.TrimSpace(fi.Comment+" "+fi.URL)
+			id, err := b.sendMessage(msg, &proto.Message{Conversation: &text})
+			if err != nil {
+				return msgID, err
+			}
+			msgID = id
+
+			continue
+		}
+
+		filetype := fi.MimeType()
+		if filetype == "" {
+			filetype = mime.TypeByExtension(filepath.Ext(fi.Name))
+		}
 
 		b.Log.Debugf("Extra file is %#v", filetype)
 
-		// TODO: add different types
+		var (
+			id  string
+			err error
+		)
 		// TODO: add webp conversion
 		switch filetype {
 		case "image/jpeg", "image/png", "image/gif":
-			return b.PostImageMessage(msg, filetype)
+			id, err = b.PostImageMessage(fmsg, filetype)
+		case "video/mp4", "video/3gpp": // TODO: Check if codecs are supported by WA
+			id, err = b.PostVideoMessage(fmsg, filetype)
+		case "audio/ogg":
+			id, err = b.PostAudioMessage(fmsg, "audio/ogg; codecs=opus") // TODO: Detect if it is actually OPUS
+		case "audio/aac", "audio/mp4", "audio/amr", "audio/mpeg":
+			id, err = b.PostAudioMessage(fmsg, filetype)
 		default:
-			return b.PostDocumentMessage(msg, filetype)
+			id, err = b.PostDocumentMessage(fmsg, filetype)
 		}
+		if err != nil {
+			return msgID, err
+		}
+		msgID = id
 	}
 
-	// Post text message
-	message := whatsapp.TextMessage{
-		Info: whatsapp.MessageInfo{
-			RemoteJid: msg.Channel, // which equals to group id
-		},
-		Text: msg.Username + msg.Text,
+	return msgID, nil
+}
+
+// upload uploads the content of the file fi as mediaType.
+func (b *Bwhatsapp) upload(fi *config.FileInfo, mediaType whatsmeow.MediaType) (whatsmeow.UploadResponse, error) {
+	data, err := fi.Bytes()
+	if err != nil {
+		return whatsmeow.UploadResponse{}, err
 	}
 
-	b.Log.Debugf("=> Sending %#v", msg)
+	return b.wc.Upload(context.Background(), data, mediaType)
+}
 
-	return b.conn.Send(message)
+// printQR prints the QR code to pair the bridge as a linked device.
+func (b *Bwhatsapp) printQR(code string) {
+	cfg := qrterminal.Config{
+		Level:          qrterminal.L,
+		Writer:         os.Stdout,
+		HalfBlocks:     true,
+		BlackChar:      qrterminal.BLACK_BLACK,
+		WhiteBlackChar: qrterminal.WHITE_BLACK,
+		WhiteChar:      qrterminal.WHITE_WHITE,
+		BlackWhiteChar: qrterminal.BLACK_WHITE,
+		QuietZone:      qrterminal.QUIET_ZONE,
+	}
+	// invert the code so it can be scanned on a white terminal
+	if b.GetBool(qrOnWhiteTerminal) {
+		cfg.BlackChar, cfg.WhiteChar = qrterminal.WHITE_WHITE, qrterminal.BLACK_BLACK
+		cfg.WhiteBlackChar, cfg.BlackWhiteChar = qrterminal.BLACK_WHITE, qrterminal.WHITE_BLACK
+	}
+
+	qrterminal.GenerateWithConfig(code, cfg)
 }
 
-// TODO do we want that? to allow login with QR code from a bridged channel? https://github.com/tulir/mautrix-whatsapp/blob/513eb18e2d59bada0dd515ee1abaaf38a3bfe3d5/commands.go#L76
-//func (b *Bwhatsapp) Command(cmd string) string {
-//	return ""
-//}
+// postQR posts the QR code to pair the bridge in the login channel, when it's configured.
+func (b *Bwhatsapp) postQR(code string) {
+	if b.Login == nil {
+		return
+	}
+	qrcode, err := qr.Encode(code, qr.L)
+	if err != nil {
+		b.Log.Errorf("encoding the QR code failed: %s", err)
+		return
+	}
+	data := qrcode.PNG()
+	err = b.Login.LoginNotice(b.Account, "Scan this QR code with Linked devices in WhatsApp, it's replaced after a while",
+		&config.FileInfo{Name: "whatsapp-qr.png", Data: &data, Size: int64(len(data)), ContentType: "image/png"})
+	if err != nil && !errors.Is(err, bridge.ErrNoLoginChannel) {
+		b.Log.Errorf("posting the QR code in the login channel failed: %s", err)
+	}
+}
+
+func (b *Bwhatsapp) sendMessage(rmsg config.Message, message *proto.Message) (string, error) {
+	groupJID, _ := types.ParseJID(rmsg.Channel)
+	ID := whatsmeow.GenerateMessageID()
+
+	_, err := b.wc.SendMessage(context.Background(), groupJID, message, whatsmeow.SendRequestExtra{ID: ID})
+
+	return getMessageIdFormat(*b.wc.Store.ID, ID), err
+}
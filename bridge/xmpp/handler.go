@@ -19,7 +19,7 @@ func (b *Bxmpp) handleDownloadAvatar(avatar xmpp.AvatarData) {
 		Event:    config.EventAvatarDownload,
 		Extra:    make(map[string][]interface{}),
 	}
-	if _, ok := b.avatarMap[avatar.From]; !ok {
+	if !b.avatarMap.Contains(avatar.From) {
 		b.Log.Debugf("Avatar.From: %s", avatar.From)
 
 		err := helper.HandleDownloadSize(b.Log, &rmsg, avatar.From+".png", int64(len(avatar.Data)), b.General)
@@ -4,13 +4,14 @@ import (
 	"regexp"
 
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
 )
 
 var pathRegex = regexp.MustCompile("[^a-zA-Z0-9]+")
 
 // GetAvatar constructs a URL for a given user-avatar if it is available in the cache.
-func getAvatar(av map[string]string, userid string, general *config.Protocol) string {
-	if hash, ok := av[userid]; ok {
+func getAvatar(av *store.Bucket, userid string, general *config.Protocol) string {
+	if hash, ok := av.GetString(userid); ok {
 		// NOTE: This does not happen in bridge/helper/helper.go but messes up XMPP
 		id := pathRegex.ReplaceAllString(userid, "_")
 		return general.MediaServerDownload + "/" + hash + "/" + id + ".png"
@@ -24,7 +25,9 @@ func (b *Bxmpp) cacheAvatar(msg *config.Message) string {
 	so we can now cache the sha */
 	if fi.SHA != "" {
 		b.Log.Debugf("Added %s to %s in avatarMap", fi.SHA, msg.UserID)
-		b.avatarMap[msg.UserID] = fi.SHA
+		if err := b.avatarMap.SetString(msg.UserID, fi.SHA); err != nil {
+			b.Log.Errorf("Caching avatar of %s failed: %s", msg.UserID, err)
+		}
 	}
 	return ""
 }
@@ -0,0 +1,82 @@
+package bxmpp
+
+import (
+	"fmt"
+	"html"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/matterbridge/go-xmpp"
+)
+
+// chatStatesNS is the namespace of chat state notifications, https://xmpp.org/extensions/xep-0085.html
+const chatStatesNS = "http://jabber.org/protocol/chatstates"
+
+// isComposing returns true if message is a composing chat state without text.
+func isComposing(message xmpp.Chat) bool {
+	if message.Text != "" {
+		return false
+	}
+	for _, elem := range message.OtherElem {
+		if elem.XMLName.Space == chatStatesNS && elem.XMLName.Local == "composing" {
+			return true
+		}
+	}
+	return false
+}
+
+// handleTyping sends a typing event for the composing chat state message.
+func (b *Bxmpp) handleTyping(message xmpp.Chat) {
+	nick := b.parseNick(message.Remote)
+	if !b.GetBool("ShowUserTyping") || nick == "" || nick == b.GetString("Nick") {
+		return
+	}
+	b.Remote <- config.Message{
+		Username: nick,
+		UserID:   message.Remote,
+		Channel:  b.parseChannel(message.Remote),
+		Account:  b.Account,
+		Event:    config.EventUserTyping,
+	}
+}
+
+// handlePresence sends a presence event for the occupant of a MUC in presence. The
+// presences the MUC sends when it's joined are skipped.
+func (b *Bxmpp) handlePresence(presence xmpp.Presence) {
+	nick := b.parseNick(presence.From)
+	if !b.GetBool("ShowPresence") || nick == "" || nick == b.GetString("Nick") ||
+		time.Since(b.startTime) < time.Second*5 {
+		return
+	}
+	var status string
+	switch {
+	case presence.Type == "unavailable":
+		status = "offline"
+	case presence.Type != "":
+		return
+	case presence.Show == "away" || presence.Show == "xa":
+		status = "away"
+	case presence.Show == "dnd":
+		status = "dnd"
+	default:
+		status = "online"
+	}
+	b.Remote <- config.Message{
+		Username: nick,
+		UserID:   presence.From,
+		Text:     status,
+		Channel:  b.parseChannel(presence.From),
+		Account:  b.Account,
+		Event:    config.EventPresence,
+	}
+}
+
+// sendTyping sends a composing chat state to channel.
+func (b *Bxmpp) sendTyping(channel string) error {
+	if !b.GetBool("ShowUserTyping") {
+		return nil
+	}
+	_, err := b.xc.SendOrg(fmt.Sprintf("<message to='%s' type='groupchat'><composing xmlns='%s'/></message>",
+		html.EscapeString(channel+"@"+b.GetString("Muc")), chatStatesNS))
+	return err
+}
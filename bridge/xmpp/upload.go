@@ -0,0 +1,272 @@
+package bxmpp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/matterbridge/go-xmpp"
+	"github.com/rs/xid"
+)
+
+// XEP-0363 HTTP File Upload
+const nsHTTPUpload = "urn:xmpp:http:upload:0"
+
+const iqTimeout = 30 * time.Second
+
+var uploadClient = &http.Client{Timeout: 5 * time.Minute}
+
+// uploadService is the HTTP upload service of the server.
+type uploadService struct {
+	jid     string
+	maxSize int64 // 0 when the service has no limit
+}
+
+type discoInfo struct {
+	Features []struct {
+		Var string `xml:"var,attr"`
+	} `xml:"feature"`
+	Forms []struct {
+		Fields []struct {
+			Var    string   `xml:"var,attr"`
+			Values []string `xml:"value"`
+		} `xml:"field"`
+	} `xml:"x"`
+}
+
+type discoItems struct {
+	Items []struct {
+		JID string `xml:"jid,attr"`
+	} `xml:"item"`
+}
+
+type uploadRequest struct {
+	XMLName     xml.Name `xml:"urn:xmpp:http:upload:0 request"`
+	Filename    string   `xml:"filename,attr"`
+	Size        int      `xml:"size,attr"`
+	ContentType string   `xml:"content-type,attr"`
+}
+
+type uploadSlot struct {
+	Put struct {
+		URL     string `xml:"url,attr"`
+		Headers []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"header"`
+	} `xml:"put"`
+	Get struct {
+		URL string `xml:"url,attr"`
+	} `xml:"get"`
+}
+
+// oobMessage shares an uploaded file, clients show the file inline when the body is the url.
+type oobMessage struct {
+	XMLName xml.Name `xml:"message"`
+	To      string   `xml:"to,attr"`
+	Type    string   `xml:"type,attr"`
+	ID      string   `xml:"id,attr"`
+	Body    string   `xml:"body"`
+	OOB     struct {
+		URL string `xml:"url"`
+	} `xml:"jabber:x:oob x"`
+}
+
+// iq sends an IQ of iqType with body to the entity to and waits for the response, which
+// handleXMPP passes on with handleIQ.
+func (b *Bxmpp) iq(to, iqType, body string) (xmpp.IQ, error) {
+	id := "mb" + xid.New().String()
+	ch := make(chan xmpp.IQ, 1)
+	b.iqMu.Lock()
+	b.iqs[id] = ch
+	b.iqMu.Unlock()
+	defer func() {
+		b.iqMu.Lock()
+		delete(b.iqs, id)
+		b.iqMu.Unlock()
+	}()
+	if _, err := b.xc.RawInformation(b.xc.JID(), to, id, iqType, body); err != nil {
+		return xmpp.IQ{}, err
+	}
+	select {
+	case res := <-ch:
+		if res.Type == "error" {
+			return res, fmt.Errorf("%s returned an error: %s", to, res.Query)
+		}
+		return res, nil
+	case <-time.After(iqTimeout):
+		return xmpp.IQ{}, fmt.Errorf("no response from %s", to)
+	}
+}
+
+func (b *Bxmpp) handleIQ(v xmpp.IQ) {
+	b.iqMu.Lock()
+	ch, ok := b.iqs[v.ID]
+	b.iqMu.Unlock()
+	if ok {
+		ch <- v
+	}
+}
+
+// jidDomain returns the domain of jid.
+func jidDomain(jid string) string {
+	jid = strings.SplitN(jid, "/", 2)[0]
+	if i := strings.Index(jid, "@"); i >= 0 {
+		return jid[i+1:]
+	}
+	return jid
+}
+
+// discoverUpload looks for the HTTP upload service of the server, on the server itself and
+// on the entities it exposes.
+func (b *Bxmpp) discoverUpload() {
+	b.setUploadService(nil)
+	domain := jidDomain(b.xc.JID())
+	candidates := []string{domain}
+	res, err := b.iq(domain, xmpp.IQTypeGet, "<query xmlns='"+xmpp.XMPPNS_DISCO_ITEMS+"'/>")
+	if err != nil {
+		b.Log.Debugf("Discovering the items of %s failed: %s", domain, err)
+	} else {
+		var items discoItems
+		if err := xml.Unmarshal(res.Query, &items); err == nil {
+			for _, item := range items.Items {
+				candidates = append(candidates, item.JID)
+			}
+		}
+	}
+	for _, jid := range candidates {
+		res, err := b.iq(jid, xmpp.IQTypeGet, "<query xmlns='"+xmpp.XMPPNS_DISCO_INFO+"'/>")
+		if err != nil {
+			b.Log.Debugf("Discovering the features of %s failed: %s", jid, err)
+			continue
+		}
+		if service := parseUploadService(jid, res.Query); service != nil {
+			b.Log.Infof("Uploading files to %s", jid)
+			b.setUploadService(service)
+			return
+		}
+	}
+	b.Log.Debugf("%s has no HTTP upload service, files are shared as links", domain)
+}
+
+// parseUploadService returns the upload service in the disco#info response of jid, or
+// nil when jid isn't an upload service.
+func parseUploadService(jid string, query []byte) *uploadService {
+	var info discoInfo
+	if err := xml.Unmarshal(query, &info); err != nil {
+		return nil
+	}
+	for _, feature := range info.Features {
+		if feature.Var != nsHTTPUpload {
+			continue
+		}
+		service := &uploadService{jid: jid}
+		for _, form := range info.Forms {
+			for _, field := range form.Fields {
+				if field.Var == "max-file-size" && len(field.Values) > 0 {
+					service.maxSize, _ = strconv.ParseInt(field.Values[0], 10, 64)
+				}
+			}
+		}
+		return service
+	}
+	return nil
+}
+
+func (b *Bxmpp) setUploadService(service *uploadService) {
+	b.Lock()
+	b.upload = service
+	b.Unlock()
+}
+
+func (b *Bxmpp) uploadService() *uploadService {
+	b.RLock()
+	defer b.RUnlock()
+	return b.upload
+}
+
+// uploadFile uploads the downloaded data of the file to the upload service and returns
+// the URL it's shared with. It's empty when the file can't be uploaded.
+func (b *Bxmpp) uploadFile(fi *config.FileInfo) (string, error) {
+	service := b.uploadService()
+	if service == nil || fi.Data == nil {
+		return "", nil
+	}
+	size := len(*fi.Data)
+	if service.maxSize > 0 && int64(size) > service.maxSize {
+		b.Log.Debugf("%s is too large for %s (%d > %d bytes)", fi.Name, service.jid, size, service.maxSize)
+		return "", nil
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(fi.Name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	request, err := xml.Marshal(uploadRequest{Filename: fi.Name, Size: size, ContentType: contentType})
+	if err != nil {
+		return "", err
+	}
+	res, err := b.iq(service.jid, xmpp.IQTypeGet, string(request))
+	if err != nil {
+		return "", err
+	}
+	var slot uploadSlot
+	if err := xml.Unmarshal(res.Query, &slot); err != nil {
+		return "", err
+	}
+	if err := putFile(&slot, *fi.Data, contentType); err != nil {
+		return "", err
+	}
+	return slot.Get.URL, nil
+}
+
+// putFile uploads data to the put URL of slot.
+func putFile(slot *uploadSlot, data []byte, contentType string) error {
+	if slot.Put.URL == "" || slot.Get.URL == "" {
+		return fmt.Errorf("upload slot without urls")
+	}
+	req, err := http.NewRequest(http.MethodPut, slot.Put.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for _, header := range slot.Put.Headers {
+		// the only headers a service may set
+		switch header.Name {
+		case "Authorization", "Cookie", "Expires":
+			req.Header.Set(header.Name, strings.NewReplacer("\r", "", "\n", "").Replace(header.Value))
+		}
+	}
+	resp, err := uploadClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("uploading to %s failed: %s", slot.Put.URL, resp.Status)
+	}
+	return nil
+}
+
+// sendUploaded shares the uploaded file at url in channel.
+func (b *Bxmpp) sendUploaded(channel, url string) error {
+	msg := oobMessage{
+		To:   channel + "@" + b.GetString("Muc"),
+		Type: "groupchat",
+		ID:   xid.New().String(),
+		Body: url,
+	}
+	msg.OOB.URL = url
+	out, err := xml.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = b.xc.SendOrg(string(out))
+	return err
+}
@@ -0,0 +1,68 @@
+package bxmpp
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJidDomain(t *testing.T) {
+	assert.Equal(t, "example.com", jidDomain("bot@example.com/matterbridge"))
+	assert.Equal(t, "example.com", jidDomain("bot@example.com"))
+	assert.Equal(t, "anon.example.com", jidDomain("anon.example.com/123"))
+}
+
+func TestParseUploadService(t *testing.T) {
+	query := []byte(`<query xmlns="http://jabber.org/protocol/disco#info">
+		<identity category="store" type="file" name="HTTP File Upload"/>
+		<feature var="urn:xmpp:http:upload:0"/>
+		<x type="result" xmlns="jabber:x:data">
+			<field var="FORM_TYPE" type="hidden"><value>urn:xmpp:http:upload:0</value></field>
+			<field var="max-file-size"><value>5242880</value></field>
+		</x>
+	</query>`)
+	assert.Equal(t, &uploadService{jid: "upload.example.com", maxSize: 5242880}, parseUploadService("upload.example.com", query))
+
+	query = []byte(`<query xmlns="http://jabber.org/protocol/disco#info"><feature var="http://jabber.org/protocol/muc"/></query>`)
+	assert.Nil(t, parseUploadService("conference.example.com", query))
+}
+
+func TestPutFile(t *testing.T) {
+	var got []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "image/png", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Basic Zm9vOmJhcg==", r.Header.Get("Authorization"))
+		assert.Empty(t, r.Header.Get("X-Other"))
+		got, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	var slot uploadSlot
+	require.NoError(t, xml.Unmarshal([]byte(`<slot xmlns="urn:xmpp:http:upload:0">
+		<put url="`+ts.URL+`/cat.png"><header name="Authorization">Basic Zm9vOmJhcg==</header><header name="X-Other">no</header></put>
+		<get url="https://download.example.com/cat.png"/>
+	</slot>`), &slot))
+	assert.Equal(t, "https://download.example.com/cat.png", slot.Get.URL)
+	require.NoError(t, putFile(&slot, []byte("png"), "image/png"))
+	assert.Equal(t, []byte("png"), got)
+
+	slot.Put.URL = ts.URL + "/missing"
+	ts.Config.Handler = http.NotFoundHandler()
+	assert.Error(t, putFile(&slot, []byte("png"), "image/png"))
+}
+
+func TestOOBMessage(t *testing.T) {
+	msg := oobMessage{To: "room@muc.example.com", Type: "groupchat", ID: "1", Body: "https://download.example.com/a&b.png"}
+	msg.OOB.URL = msg.Body
+	out, err := xml.Marshal(msg)
+	require.NoError(t, err)
+	assert.Equal(t, `<message to="room@muc.example.com" type="groupchat" id="1"><body>https://download.example.com/a&amp;b.png</body>`+
+		`<x xmlns="jabber:x:oob"><url>https://download.example.com/a&amp;b.png</url></x></message>`, string(out))
+}
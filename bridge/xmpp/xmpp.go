@@ -14,6 +14,7 @@ import (
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/store"
 	"github.com/jpillora/backoff"
 	"github.com/matterbridge/go-xmpp"
 	"github.com/rs/xid"
@@ -29,7 +30,11 @@ type Bxmpp struct {
 	sync.RWMutex
 
 	avatarAvailability map[string]bool
-	avatarMap          map[string]string
+	avatarMap          *store.Bucket
+
+	upload *uploadService
+	iqMu   sync.Mutex
+	iqs    map[string]chan xmpp.IQ
 }
 
 func New(cfg *bridge.Config) bridge.Bridger {
@@ -37,12 +42,12 @@ func New(cfg *bridge.Config) bridge.Bridger {
 		Config:             cfg,
 		xmppMap:            make(map[string]string),
 		avatarAvailability: make(map[string]bool),
-		avatarMap:          make(map[string]string),
+		avatarMap:          cfg.NewBucket("avatar"),
+		iqs:                make(map[string]chan xmpp.IQ),
 	}
 }
 
 func (b *Bxmpp) Connect() error {
-	b.Log.Infof("Connecting %s", b.GetString("Server"))
 	if err := b.createXMPP(); err != nil {
 		b.Log.Debugf("%#v", err)
 		return err
@@ -83,13 +88,17 @@ func (b *Bxmpp) Send(msg config.Message) (string, error) {
 		return b.cacheAvatar(&msg), nil
 	}
 
+	if msg.Event == config.EventUserTyping {
+		return "", b.sendTyping(msg.Channel)
+	}
+
 	// Make a action /me of the message, prepend the username with it.
 	// https://xmpp.org/extensions/xep-0245.html
 	if msg.Event == config.EventUserAction {
 		msg.Username = "/me " + msg.Username
 	}
 
-	// Upload a file (in XMPP case send the upload URL because XMPP has no native upload support).
+	// Upload a file (with XEP-0363 HTTP upload, or send the URL when the server doesn't support it).
 	var err error
 	if msg.Extra != nil {
 		for _, rmsg := range helper.HandleExtra(&msg, b.General) {
@@ -167,7 +176,13 @@ func (b *Bxmpp) postSlackCompatibleWebhook(msg config.Message) error {
 	return nil
 }
 
+// createXMPP connects to the first healthy server of Servers.
 func (b *Bxmpp) createXMPP() error {
+	return b.ConnectFailover(nil, b.connectXMPP)
+}
+
+func (b *Bxmpp) connectXMPP(server string) error {
+	b.Log.Infof("Connecting %s", server)
 	var serverName string
 	switch {
 	case !b.GetBool("Anonymous"):
@@ -175,10 +190,10 @@ func (b *Bxmpp) createXMPP() error {
 			return fmt.Errorf("the Jid %s doesn't contain an @", b.GetString("Jid"))
 		}
 		serverName = strings.Split(b.GetString("Jid"), "@")[1]
-	case !strings.Contains(b.GetString("Server"), ":"):
-		serverName = strings.Split(b.GetString("Server"), ":")[0]
+	case !strings.Contains(server, ":"):
+		serverName = strings.Split(server, ":")[0]
 	default:
-		serverName = b.GetString("Server")
+		serverName = server
 	}
 
 	tc := &tls.Config{
@@ -189,7 +204,7 @@ func (b *Bxmpp) createXMPP() error {
 	xmpp.DebugWriter = b.Log.Writer()
 
 	options := xmpp.Options{
-		Host:                         b.GetString("Server"),
+		Host:                         server,
 		User:                         b.GetString("Jid"),
 		Password:                     b.GetString("Password"),
 		NoTLS:                        true,
@@ -279,6 +294,7 @@ func (b *Bxmpp) handleXMPP() error {
 
 	done := b.xmppKeepAlive()
 	defer close(done)
+	go b.discoverUpload()
 
 	for {
 		m, err := b.xc.Recv()
@@ -297,6 +313,11 @@ func (b *Bxmpp) handleXMPP() error {
 			if v.Type == "groupchat" {
 				b.Log.Debugf("== Receiving %#v", v)
 
+				if isComposing(v) {
+					b.handleTyping(v)
+					continue
+				}
+
 				// Skip invalid messages.
 				if b.skipMessage(v) {
 					continue
@@ -322,14 +343,15 @@ func (b *Bxmpp) handleXMPP() error {
 					msgID = v.ReplaceID
 				}
 				rmsg := config.Message{
-					Username: b.parseNick(v.Remote),
-					Text:     v.Text,
-					Channel:  b.parseChannel(v.Remote),
-					Account:  b.Account,
-					Avatar:   avatar,
-					UserID:   v.Remote,
-					ID:       msgID,
-					Event:    event,
+					Username:  b.parseNick(v.Remote),
+					Text:      v.Text,
+					Channel:   b.parseChannel(v.Remote),
+					Account:   b.Account,
+					Avatar:    avatar,
+					UserID:    v.Remote,
+					ID:        msgID,
+					Event:     event,
+					Timestamp: v.Stamp, // only set for delayed messages
 				}
 
 				// Check if we have an action event.
@@ -348,7 +370,9 @@ func (b *Bxmpp) handleXMPP() error {
 			b.avatarAvailability[v.From] = true
 			b.Log.Debugf("Avatar for %s is now available", v.From)
 		case xmpp.Presence:
-			// Do nothing.
+			b.handlePresence(v)
+		case xmpp.IQ:
+			b.handleIQ(v)
 		}
 	}
 }
@@ -360,21 +384,35 @@ func (b *Bxmpp) replaceAction(text string) (string, bool) {
 	return text, false
 }
 
-// handleUploadFile handles native upload of files
+// handleUploadFile uploads the files to the HTTP upload service of the server, the files
+// that can't be uploaded are shared as links.
 func (b *Bxmpp) handleUploadFile(msg *config.Message) error {
 	var urlDesc string
 
 	for _, file := range msg.Extra["file"] {
 		fileInfo := file.(config.FileInfo)
-		if fileInfo.Comment != "" {
-			msg.Text += fileInfo.Comment + ": "
+		uploaded, err := b.uploadFile(&fileInfo)
+		if err != nil {
+			b.Log.WithError(err).Warnf("Uploading %s failed, sharing its link", fileInfo.Name)
 		}
-		if fileInfo.URL != "" {
-			msg.Text = fileInfo.URL
-			if fileInfo.Comment != "" {
-				msg.Text = fileInfo.Comment + ": " + fileInfo.URL
-				urlDesc = fileInfo.Comment
+		if uploaded != "" {
+			if _, err := b.xc.Send(xmpp.Chat{
+				Type:   "groupchat",
+				Remote: msg.Channel + "@" + b.GetString("Muc"),
+				Text:   msg.Username + fileInfo.Comment,
+			}); err != nil {
+				return err
+			}
+			if err := b.sendUploaded(msg.Channel, uploaded); err != nil {
+				return err
 			}
+			continue
+		}
+		if text := helper.FileText(fileInfo); text != "" {
+			msg.Text = text
+		}
+		if fileInfo.URL != "" {
+			urlDesc = fileInfo.Comment
 		}
 		if _, err := b.xc.Send(xmpp.Chat{
 			Type:   "groupchat",
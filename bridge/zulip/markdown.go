@@ -0,0 +1,157 @@
+package bzulip
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var (
+	spoilerRE      = regexp.MustCompile(`\|\|(.+?)\|\|`)
+	blockQuoteRE   = regexp.MustCompile(`(?s)(?:^|\n)>>> (.*)$`)
+	displayMathRE  = regexp.MustCompile(`(?s)\\\[(.+?)\\\]`)
+	inlineMathRE   = regexp.MustCompile(`\\\((.+?)\\\)`)
+	userMentionRE  = regexp.MustCompile(`@\*\*([^*]+)\*\*`)
+	groupMentionRE = regexp.MustCompile(`@\*([^*]+)\*`)
+	urlTemplateRE  = regexp.MustCompile(`\{(\w+)\}|%\((\w+)\)s`)
+)
+
+// wildcardMentions are the mentions of all the users of a stream or topic.
+var wildcardMentions = map[string]bool{"all": true, "everyone": true, "stream": true, "channel": true, "topic": true}
+
+// zulipMarkdown converts the markup other chats use for spoilers, block quotes and math
+// to the blocks of zulip markdown.
+func zulipMarkdown(text string) string {
+	text = blockQuoteRE.ReplaceAllStringFunc(text, func(m string) string {
+		prefix := ""
+		if strings.HasPrefix(m, "\n") {
+			prefix = "\n"
+		}
+		return prefix + "```quote\n" + blockQuoteRE.FindStringSubmatch(m)[1] + "\n```"
+	})
+	text = spoilerRE.ReplaceAllStringFunc(text, func(m string) string {
+		return "\n```spoiler\n" + strings.TrimSpace(spoilerRE.FindStringSubmatch(m)[1]) + "\n```\n"
+	})
+	text = displayMathRE.ReplaceAllString(text, "\n```math\n$1\n```\n")
+	text = inlineMathRE.ReplaceAllString(text, "$$$$$1$$$$")
+	return strings.Trim(text, "\n")
+}
+
+// silenceMentions changes the user, group and wildcard mentions in text into silent
+// mentions, which don't notify, except for the kinds of mentions in allowed.
+func silenceMentions(text string, allowed []string) string {
+	allow := make(map[string]bool)
+	for _, m := range allowed {
+		allow[m] = true
+	}
+	text = userMentionRE.ReplaceAllStringFunc(text, func(m string) string {
+		name := userMentionRE.FindStringSubmatch(m)[1]
+		if (wildcardMentions[name] && allow["everyone"]) || (!wildcardMentions[name] && allow["users"]) {
+			return m
+		}
+		return "@_**" + name + "**"
+	})
+	if allow["roles"] {
+		return text
+	}
+	return groupMentionRE.ReplaceAllString(text, "@_*$1*")
+}
+
+// linkifier turns text matching pattern into a link by filling template with the named
+// groups of the match.
+type linkifier struct {
+	pattern  *regexp.Regexp
+	template string
+}
+
+type linkifiersResponse struct {
+	Result     string `json:"result"`
+	Msg        string `json:"msg"`
+	Linkifiers []struct {
+		Pattern     string `json:"pattern"`
+		URLTemplate string `json:"url_template"`
+		// URLFormat is the template of servers before zulip 7
+		URLFormat string `json:"url_format"`
+	} `json:"linkifiers"`
+}
+
+// loadLinkifiers gets the linkifiers of the zulip organization.
+func (b *Bzulip) loadLinkifiers() error {
+	req, err := http.NewRequest("GET", b.GetString("server")+"/api/v1/realm/linkifiers", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(b.GetString("login"), b.GetString("token"))
+	resp, err := b.bot.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var res linkifiersResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return err
+	}
+	if res.Result != "success" {
+		return fmt.Errorf("getting linkifiers failed: %s %s", resp.Status, res.Msg)
+	}
+	linkifiers := make([]linkifier, 0, len(res.Linkifiers))
+	for _, l := range res.Linkifiers {
+		re, err := regexp.Compile(l.Pattern)
+		if err != nil {
+			b.Log.Warnf("skipping linkifier %s: %s", l.Pattern, err)
+			continue
+		}
+		template := l.URLTemplate
+		if template == "" {
+			template = l.URLFormat
+		}
+		linkifiers = append(linkifiers, linkifier{pattern: re, template: template})
+	}
+	b.Lock()
+	b.linkifiers = linkifiers
+	b.Unlock()
+	return nil
+}
+
+// expandLinkifiers replaces the text in text zulip shows as a link by the linkifiers by
+// the URL it links to. Like zulip, only matches that are separate words are replaced.
+func expandLinkifiers(text string, linkifiers []linkifier) string {
+	for _, l := range linkifiers {
+		var sb strings.Builder
+		last := 0
+		for _, m := range l.pattern.FindAllStringSubmatchIndex(text, -1) {
+			start, end := m[0], m[1]
+			if (start > 0 && !strings.ContainsRune(" \t\n'\"(,:<", rune(text[start-1]))) ||
+				(end < len(text) && isWordByte(text[end])) {
+				continue
+			}
+			groups := make(map[string]string)
+			for i, name := range l.pattern.SubexpNames() {
+				if name != "" && m[2*i] >= 0 {
+					groups[name] = text[m[2*i]:m[2*i+1]]
+				}
+			}
+			url := urlTemplateRE.ReplaceAllStringFunc(l.template, func(v string) string {
+				sub := urlTemplateRE.FindStringSubmatch(v)
+				return groups[sub[1]+sub[2]]
+			})
+			sb.WriteString(text[last:start])
+			sb.WriteString(url)
+			last = end
+		}
+		sb.WriteString(text[last:])
+		text = sb.String()
+	}
+	return text
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -0,0 +1,43 @@
+package bzulip
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZulipMarkdown(t *testing.T) {
+	for input, expected := range map[string]string{
+		"plain *text*":              "plain *text*",
+		"look ||the end|| here":     "look \n```spoiler\nthe end\n```\n here",
+		"||secret||":                "```spoiler\nsecret\n```",
+		">>> quoted\nlines":         "```quote\nquoted\nlines\n```",
+		"intro\n>>> quoted":         "intro\n```quote\nquoted\n```",
+		"> single quote":            "> single quote",
+		`\[e^{i\pi} + 1 = 0\]`:      "```math\ne^{i\\pi} + 1 = 0\n```",
+		`inline \(x^2\) and $5 $10`: "inline $$x^2$$ and $5 $10",
+		"no || spoiler":             "no || spoiler",
+	} {
+		assert.Equal(t, expected, zulipMarkdown(input), input)
+	}
+}
+
+func TestSilenceMentions(t *testing.T) {
+	text := "hi @**Bob Smith** and @*admins*, @**all** @_**Alice**"
+	assert.Equal(t, "hi @_**Bob Smith** and @_*admins*, @_**all** @_**Alice**", silenceMentions(text, nil))
+	assert.Equal(t, "hi @**Bob Smith** and @_*admins*, @_**all** @_**Alice**", silenceMentions(text, []string{"users"}))
+	assert.Equal(t, "hi @_**Bob Smith** and @*admins*, @**all** @_**Alice**", silenceMentions(text, []string{"everyone", "roles"}))
+}
+
+func TestExpandLinkifiers(t *testing.T) {
+	linkifiers := []linkifier{
+		{pattern: regexp.MustCompile(`#(?P<id>[0-9]+)`), template: "https://github.com/zulip/zulip/issues/{id}"},
+		{pattern: regexp.MustCompile(`(?P<repo>[a-z]+)!(?P<id>[0-9]+)`), template: "https://gitlab.example.com/%(repo)s/merge_requests/%(id)s"},
+	}
+	assert.Equal(t,
+		"see https://github.com/zulip/zulip/issues/123 and (https://gitlab.example.com/web/merge_requests/7)",
+		expandLinkifiers("see #123 and (web!7)", linkifiers))
+	// matches inside words or URLs aren't links
+	assert.Equal(t, "x#123 #12a https://example.com/#12", expandLinkifiers("x#123 #12a https://example.com/#12", linkifiers))
+}
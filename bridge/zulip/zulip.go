@@ -20,6 +20,8 @@ type Bzulip struct {
 	q       *gzb.Queue
 	bot     *gzb.Bot
 	streams map[int]string
+	// linkifiers are the linkifiers of the organization, expanded in received messages
+	linkifiers []linkifier
 	*bridge.Config
 	sync.RWMutex
 }
@@ -31,6 +33,7 @@ func New(cfg *bridge.Config) bridge.Bridger {
 func (b *Bzulip) Connect() error {
 	bot := gzb.Bot{APIKey: b.GetString("token"), APIURL: b.GetString("server") + "/api/v1/", Email: b.GetString("login"), UserAgent: fmt.Sprintf("matterbridge/%s", version.Release)}
 	bot.Init()
+	bot.Client = b.HTTPClient(0)
 	q, err := bot.RegisterAll()
 	b.q = q
 	b.bot = &bot
@@ -40,6 +43,9 @@ func (b *Bzulip) Connect() error {
 	}
 	// init stream
 	b.getChannel(0)
+	if err := b.loadLinkifiers(); err != nil {
+		b.Log.Errorf("Couldn't load the linkifiers: %s", err)
+	}
 	b.Log.Info("Connection succeeded")
 	go b.handleQueue()
 	return nil
@@ -65,6 +71,9 @@ func (b *Bzulip) Send(msg config.Message) (string, error) {
 		return "", err
 	}
 
+	msg.Text = zulipMarkdown(msg.Text)
+	msg.Text = silenceMentions(msg.Text, b.GetStringSlice("AllowMention"))
+
 	// Upload a file if it exists
 	if msg.Extra != nil {
 		for _, rmsg := range helper.HandleExtra(&msg, b.General) {
@@ -145,9 +154,13 @@ func (b *Bzulip) handleQueue() error {
 				avatarURL = b.GetString("server") + avatarURL
 			}
 
+			b.RLock()
+			text := expandLinkifiers(m.Content, b.linkifiers)
+			b.RUnlock()
+
 			rmsg := config.Message{
 				Username: m.SenderFullName,
-				Text:     m.Content,
+				Text:     text,
 				Channel:  b.getChannel(m.StreamID) + "/topic:" + m.Subject,
 				Account:  b.Account,
 				UserID:   strconv.Itoa(m.SenderID),
@@ -198,15 +211,8 @@ func (b *Bzulip) sendMessage(msg config.Message) (string, error) {
 
 func (b *Bzulip) handleUploadFile(msg *config.Message) (string, error) {
 	for _, f := range msg.Extra["file"] {
-		fi := f.(config.FileInfo)
-		if fi.Comment != "" {
-			msg.Text += fi.Comment + ": "
-		}
-		if fi.URL != "" {
-			msg.Text = fi.URL
-			if fi.Comment != "" {
-				msg.Text = fi.Comment + ": " + fi.URL
-			}
+		if text := helper.FileText(f.(config.FileInfo)); text != "" {
+			msg.Text = text
 		}
 		_, err := b.sendMessage(*msg)
 		if err != nil {
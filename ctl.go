@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+)
+
+const ctlUsage = `usage: matterbridge ctl [flags] <command> [account]
+
+commands:
+  status            list the gateways and the status of their bridges
+  disable <account> disconnect a bridge, nothing is relayed to or from it
+  enable <account>  connect a disabled bridge again
+  flush [account]   replay the offline queue of a bridge now, of all bridges without account
+  reload            reload the configuration file
+
+flags:
+`
+
+// runCtl runs "matterbridge ctl", which controls a running matterbridge through the admin
+// listener (AdminBindAddress and AdminToken of the configuration file).
+func runCtl(args []string) error {
+	flags := flag.NewFlagSet("ctl", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), ctlUsage)
+		flags.PrintDefaults()
+	}
+	conf := flags.String("conf", "matterbridge.toml", "config file, for AdminBindAddress and AdminToken")
+	addr := flags.String("addr", "", "address of the admin listener, AdminBindAddress when empty")
+	token := flags.String("token", "", "admin token, AdminToken when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no command given")
+	}
+
+	if *addr == "" || *token == "" {
+		logger := setupLogger()
+		logger.SetOutput(os.Stderr)
+		logger.SetLevel(logrus.WarnLevel)
+		general := config.NewConfig(logger, *conf).BridgeValues().General
+		if *addr == "" {
+			*addr = general.AdminBindAddress
+		}
+		if *token == "" {
+			*token = general.AdminToken
+		}
+	}
+	if *addr == "" {
+		return fmt.Errorf("no admin listener, set AdminBindAddress in %s or use -addr", *conf)
+	}
+	c := newCtlClient(*addr, *token)
+
+	cmd, account := flags.Arg(0), flags.Arg(1)
+	switch cmd {
+	case "status":
+		return c.status(os.Stdout)
+	case "disable", "enable":
+		if account == "" {
+			return fmt.Errorf("%s needs an account", cmd)
+		}
+		if _, err := c.post("/api/bridges/"+cmd, account); err != nil {
+			return err
+		}
+		fmt.Printf("%sing %s\n", strings.TrimSuffix(cmd, "e"), account)
+		return nil
+	case "flush":
+		data, err := c.post("/api/queues/flush", account)
+		if err != nil {
+			return err
+		}
+		queued := make(map[string]int)
+		if err := json.Unmarshal(data, &queued); err != nil {
+			return err
+		}
+		accounts := make([]string, 0, len(queued))
+		for account := range queued {
+			accounts = append(accounts, account)
+		}
+		sort.Strings(accounts)
+		for _, account := range accounts {
+			fmt.Printf("%s: replaying %d queued messages\n", account, queued[account])
+		}
+		return nil
+	case "reload":
+		if _, err := c.post("/api/reload", ""); err != nil {
+			return err
+		}
+		fmt.Println("reloading the configuration")
+		return nil
+	}
+	flags.Usage()
+	return fmt.Errorf("unknown command %s", cmd)
+}
+
+// ctlClient makes the requests of "matterbridge ctl" to the admin listener.
+type ctlClient struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+func newCtlClient(addr, token string) *ctlClient {
+	c := &ctlClient{base: "http://" + addr, token: token, http: &http.Client{Timeout: 30 * time.Second}}
+	if path := strings.TrimPrefix(addr, "unix:"); path != addr {
+		c.base = "http://admin"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}
+	}
+	return c
+}
+
+func (c *ctlClient) do(method, path, account string) ([]byte, error) {
+	u := c.base + path
+	if account != "" {
+		u += "?account=" + url.QueryEscape(account)
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+func (c *ctlClient) post(path, account string) ([]byte, error) {
+	return c.do(http.MethodPost, path, account)
+}
+
+// ctlGateway is a gateway as served on /api/gateways.
+type ctlGateway struct {
+	Name    string `json:"name"`
+	Bridges []struct {
+		Account   string   `json:"account"`
+		Connected bool     `json:"connected"`
+		Disabled  bool     `json:"disabled"`
+		Channels  []string `json:"channels"`
+		Queued    int      `json:"queued"`
+	} `json:"bridges"`
+}
+
+func (c *ctlClient) status(w io.Writer) error {
+	data, err := c.do(http.MethodGet, "/api/gateways", "")
+	if err != nil {
+		return err
+	}
+	var gateways []ctlGateway
+	if err := json.Unmarshal(data, &gateways); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "GATEWAY\tACCOUNT\tSTATUS\tQUEUED\tCHANNELS")
+	for _, gw := range gateways {
+		for _, br := range gw.Bridges {
+			status := "disconnected"
+			switch {
+			case br.Disabled:
+				status = "disabled"
+			case br.Connected:
+				status = "connected"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", gw.Name, br.Account, status, br.Queued, strings.Join(br.Channels, ","))
+		}
+	}
+	return tw.Flush()
+}
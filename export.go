@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/gateway"
+	"github.com/sirupsen/logrus"
+)
+
+// runExport runs "matterbridge export", which writes the history of a gateway in the
+// archive (ArchivePath) to a file.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	conf := flags.String("conf", "matterbridge.toml", "config file")
+	gw := flags.String("gateway", "", "gateway to export")
+	channel := flags.String("channel", "", "channel to export, all the channels of the gateway when empty")
+	format := flags.String("format", "txt", "format of the export: html, json or txt")
+	since := flags.String("since", "", "export the messages since this date (2006-01-02 or RFC3339)")
+	output := flags.String("output", "", "file to write the export to, stdout when empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	opts := gateway.ExportOptions{Gateway: *gw, Channel: *channel, Format: *format}
+	if *since != "" {
+		t, err := parseSince(*since)
+		if err != nil {
+			return err
+		}
+		opts.Since = t
+	}
+
+	// stdout can be the export
+	logger := setupLogger()
+	logger.SetOutput(os.Stderr)
+	logger.SetLevel(logrus.WarnLevel)
+	cfg := config.NewConfig(logger, *conf)
+	path := cfg.BridgeValues().General.ArchivePath
+	if path == "" {
+		return fmt.Errorf("there's no archive to export, ArchivePath isn't set in %s", *conf)
+	}
+
+	if *output == "" {
+		return gateway.ExportArchive(path, opts, os.Stdout)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := gateway.ExportArchive(path, opts, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func parseSince(since string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", since, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %s, use 2006-01-02 or RFC3339", since)
+	}
+	return t, nil
+}
@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge/listener"
+)
+
+// adminHandler returns the handler of the admin listener. Every request needs
+// "Authorization: Bearer <AdminToken>", the pprof endpoints are only added with AdminProfiling.
+// The search of the archive is served at /api/search, the expiry of the credentials of the
+// bridges at /api/credentials and the runtime control of the gateways (used by
+// "matterbridge ctl") at /api/gateways, /api/bridges, /api/queues and /api/reload. With
+// AdminDashboard the web dashboard is served at /dashboard/, its assets don't need the token.
+func (r *Router) adminHandler() http.Handler {
+	general := r.BridgeValues().General
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/selfreport", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(newSelfReport()); err != nil {
+			r.logger.Errorf("admin: failed to write selfreport: %s", err)
+		}
+	})
+	mux.HandleFunc("/api/search", r.handleSearch)
+	mux.HandleFunc("/api/credentials", r.handleCredentials)
+	mux.HandleFunc("/api/gateways", r.handleGateways)
+	mux.HandleFunc("/api/bridges/disable", r.handleBridgeControl(true))
+	mux.HandleFunc("/api/bridges/enable", r.handleBridgeControl(false))
+	mux.HandleFunc("/api/queues/flush", r.handleFlushQueues)
+	mux.HandleFunc("/api/reload", r.handleReload)
+	if r.dashboard != nil {
+		mux.HandleFunc("/api/dashboard", r.handleDashboard)
+		mux.HandleFunc("/api/dashboard/events", r.handleDashboardEvents)
+	}
+	if general.AdminProfiling {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	token := []byte(general.AdminToken)
+	var assets http.Handler
+	if r.dashboard != nil {
+		assets = dashboardHandler()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if assets != nil && req.URL.Path == "/dashboard" {
+			http.Redirect(w, req, "/dashboard/", http.StatusMovedPermanently)
+			return
+		}
+		if assets != nil && strings.HasPrefix(req.URL.Path, "/dashboard/") {
+			assets.ServeHTTP(w, req)
+			return
+		}
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), token) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+// startAdmin starts the admin listener on AdminBindAddress. It's not started without an
+// AdminToken, the profiles show the contents of the memory and must not be public.
+func (r *Router) startAdmin() {
+	general := r.BridgeValues().General
+	if general.AdminBindAddress == "" {
+		return
+	}
+	if general.AdminToken == "" {
+		r.logger.Errorf("admin: AdminBindAddress is set without AdminToken, not starting the admin listener")
+		return
+	}
+	r.startDashboard()
+	go func() {
+		cfg := listener.NewConfig(general.AdminBindAddress, &general)
+		if err := listener.Serve(r.logger, cfg, r.adminHandler()); err != nil {
+			r.logger.Errorf("admin: listener on %s failed: %s", general.AdminBindAddress, err)
+		}
+	}()
+}
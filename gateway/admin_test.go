@@ -0,0 +1,72 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigAdmin = []byte(`
+[general]
+AdminToken="secret"
+
+[irc.freenode]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+	`)
+
+func adminRequest(h http.Handler, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminHandler(t *testing.T) {
+	r := maketestRouter(testconfigAdmin)
+	h := r.adminHandler()
+
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(h, "/debug/selfreport", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(h, "/debug/selfreport", "wrong").Code)
+
+	rec := adminRequest(h, "/debug/selfreport", "secret")
+	require.Equal(t, http.StatusOK, rec.Code)
+	report := &selfReport{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), report))
+	assert.NotZero(t, report.Goroutines)
+
+	// profiling is off by default
+	assert.Equal(t, http.StatusNotFound, adminRequest(h, "/debug/pprof/", "secret").Code)
+	r.BridgeValues().General.AdminProfiling = true
+	assert.Equal(t, http.StatusOK, adminRequest(r.adminHandler(), "/debug/pprof/", "secret").Code)
+}
+
+func TestGoroutinesPerBridge(t *testing.T) {
+	br := &bridge.Bridge{Account: "irc.labeled"}
+	stop := make(chan struct{})
+	defer close(stop)
+	_ = withBridgeLabel(br, func() error {
+		for i := 0; i < 3; i++ {
+			go func() { <-stop }()
+		}
+		return nil
+	})
+	counts := goroutinesPerBridge()
+	assert.Equal(t, 3, counts["irc.labeled"])
+	assert.NotZero(t, counts[otherGoroutines])
+}
@@ -0,0 +1,197 @@
+package gateway
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+)
+
+const (
+	defaultAlertWindow = time.Minute
+	// alertTextLength is the length the text of a message is clipped to in its alert.
+	alertTextLength = 300
+)
+
+// alerts keeps the rules of the alerts of the gateway and the messages that matched them.
+type alerts struct {
+	sync.Mutex
+	rules []*alertRule
+}
+
+type alertRule struct {
+	config.AlertRule
+	re     *regexp.Regexp // nil for a rule without keywords
+	window time.Duration
+	hits   map[string][]time.Time // by account and user
+}
+
+// addAlerts sets up the alerts of the gateway, if configured. Like the moderation channel
+// the alert channel isn't part of gw.Channels.
+func (gw *Gateway) addAlerts() error {
+	cfg := gw.MyConfig.Alerts
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+	if cfg.Account == "" || cfg.Channel == "" {
+		return fmt.Errorf("alerts of gateway %s need an Account and a Channel", gw.Name)
+	}
+	a := &alerts{}
+	for _, rule := range cfg.Rules {
+		r, err := newAlertRule(rule)
+		if err != nil {
+			return fmt.Errorf("alert rule %s of gateway %s: %w", rule.Name, gw.Name, err)
+		}
+		a.rules = append(a.rules, r)
+	}
+	if err := gw.AddBridge(&config.Bridge{Account: cfg.Account, Channel: cfg.Channel}); err != nil {
+		return err
+	}
+	channel := sideChannel(cfg.Account, cfg.Channel)
+	gw.Bridges[cfg.Account].SetChannel(*channel)
+	gw.alerts = a
+	return nil
+}
+
+func newAlertRule(cfg config.AlertRule) (*alertRule, error) {
+	keywords := cfg.Keywords
+	if cfg.KeywordsFile != "" {
+		lines, err := readKeywords(cfg.KeywordsFile)
+		if err != nil {
+			return nil, err
+		}
+		keywords = append(append([]string{}, keywords...), lines...)
+	}
+	r := &alertRule{AlertRule: cfg, window: defaultAlertWindow, hits: make(map[string][]time.Time)}
+	if cfg.Window > 0 {
+		r.window = time.Duration(cfg.Window) * time.Second
+	}
+	if r.Threshold <= 0 {
+		r.Threshold = 1
+	}
+	if len(keywords) == 0 {
+		return r, nil
+	}
+	re, err := keywordsRE(keywords)
+	if err != nil {
+		return nil, err
+	}
+	r.re = re
+	return r, nil
+}
+
+// keywordsRE returns the case-insensitive regular expression matching the keywords as
+// words, the first group is the keyword.
+func keywordsRE(keywords []string) (*regexp.Regexp, error) {
+	quoted := make([]string, 0, len(keywords))
+	for _, keyword := range keywords {
+		quoted = append(quoted, regexp.QuoteMeta(keyword))
+	}
+	// \b only knows ascii words
+	return regexp.Compile(`(?i)(?:^|[^\pL\pN])(` + strings.Join(quoted, "|") + `)(?:$|[^\pL\pN])`)
+}
+
+// readKeywords returns the keywords in file, one a line. Empty lines and lines starting
+// with # are skipped.
+func readKeywords(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keywords []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			keywords = append(keywords, line)
+		}
+	}
+	return keywords, scanner.Err()
+}
+
+// matches returns true when msg, sent at now, makes its sender reach the threshold of r.
+func (r *alertRule) matches(msg *config.Message, now time.Time) bool {
+	if r.re != nil && !r.re.MatchString(msg.Text) {
+		return false
+	}
+	user := msg.Account + " " + msg.UserID + " " + msg.Username
+	hits := r.hits[user][:0]
+	for _, hit := range r.hits[user] {
+		if now.Sub(hit) < r.window {
+			hits = append(hits, hit)
+		}
+	}
+	hits = append(hits, now)
+	if len(hits) < r.Threshold {
+		r.hits[user] = hits
+		return false
+	}
+	delete(r.hits, user)
+	return true
+}
+
+// checkAlerts posts an alert in the alert channel for every rule msg matches, with links
+// to msg and to its copies msgIDs on the other bridges.
+func (gw *Gateway) checkAlerts(msg *config.Message, msgIDs []*BrMsgID) {
+	a := gw.alerts
+	if a == nil || msg.Text == "" || (msg.Event != "" && msg.Event != config.EventUserAction) {
+		return
+	}
+	now := time.Now()
+	var matched []string
+	a.Lock()
+	for _, rule := range a.rules {
+		if rule.matches(msg, now) {
+			matched = append(matched, rule.Name)
+		}
+	}
+	a.Unlock()
+
+	for _, name := range matched {
+		gw.postAlert(name, msg, msgIDs)
+	}
+}
+
+func (gw *Gateway) postAlert(rule string, msg *config.Message, msgIDs []*BrMsgID) {
+	cfg := gw.MyConfig.Alerts
+	dest, ok := gw.Bridges[cfg.Account]
+	if !ok {
+		return
+	}
+	var sb strings.Builder
+	if cfg.Mention != "" {
+		sb.WriteString(cfg.Mention + " ")
+	}
+	fmt.Fprintf(&sb, "alert %s: %s in %s on %s: %s", rule, msg.Username, msg.Channel, msg.Account,
+		helper.ClipMessage(msg.Text, alertTextLength, "..."))
+	if link, ok := permalink(gw.Bridges[msg.Account], msg.Channel, msg.ID); ok {
+		sb.WriteString("\n" + link)
+	}
+	for _, id := range msgIDs {
+		channel, ok := gw.Channels[id.ChannelID]
+		if !ok {
+			continue
+		}
+		if link, ok := permalink(id.br, channel.Name, strings.TrimPrefix(id.ID, id.br.Protocol+" ")); ok {
+			sb.WriteString("\n" + link)
+		}
+	}
+	alert := config.Message{
+		Text:     sb.String(),
+		Channel:  sideChannel(cfg.Account, cfg.Channel).Name,
+		Account:  cfg.Account,
+		Username: "alert",
+		Gateway:  gw.Name,
+	}
+	gw.logger.Infof("alert: %s matched a message from %s on %s", rule, msg.Username, msg.Account)
+	if _, err := gw.Router.send(dest, alert); err != nil {
+		gw.logger.Errorf("alert: failed to post alert in %s on %s: %s", cfg.Channel, cfg.Account, err)
+	}
+}
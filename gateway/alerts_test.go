@@ -0,0 +1,99 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigAlerts = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [gateway.alerts]
+    account="slack.test"
+    channel="mods"
+    mention="@here"
+
+    [[gateway.alerts.rules]]
+    name="crisis"
+    keywords=["help me", "hurt"]
+
+    [[gateway.alerts.rules]]
+    name="flood"
+    threshold=3
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+`)
+
+// linkBridger is a recordBridger that has links to its messages.
+type linkBridger struct {
+	recordBridger
+}
+
+func (l *linkBridger) Permalink(channel, id string) (string, bool) {
+	return "https://chat.example.com/" + channel + "/" + id, true
+}
+
+func TestAlertRule(t *testing.T) {
+	rule, err := newAlertRule(config.AlertRule{Keywords: []string{"help me", "hurt"}})
+	require.NoError(t, err)
+	now := time.Now()
+	assert.True(t, rule.matches(&config.Message{Text: "please HELP me"}, now))
+	assert.True(t, rule.matches(&config.Message{Text: "hurt!"}, now))
+	assert.False(t, rule.matches(&config.Message{Text: "unhurt"}, now))
+
+	flood, err := newAlertRule(config.AlertRule{Threshold: 2, Window: 10})
+	require.NoError(t, err)
+	msg := &config.Message{Text: "spam", Username: "wim"}
+	assert.False(t, flood.matches(msg, now))
+	assert.False(t, flood.matches(msg, now.Add(flood.window)))
+	assert.True(t, flood.matches(msg, now.Add(flood.window+1)))
+	// the count starts again after an alert
+	assert.False(t, flood.matches(msg, now.Add(flood.window+2)))
+}
+
+func TestCheckAlerts(t *testing.T) {
+	r := maketestRouter(testconfigAlerts)
+	gw := r.Gateways["bridge1"]
+	require.NotNil(t, gw.alerts)
+	assert.Len(t, gw.Channels, 2)
+	assert.Contains(t, gw.Bridges["slack.test"].Channels, "modsslack.test")
+
+	mods := &recordBridger{}
+	gw.Bridges["slack.test"].Bridger = mods
+	discord := &linkBridger{}
+	gw.Bridges["discord.test"].Bridger = discord
+	gw.Bridges["irc.freenode"].Bridger = &recordBridger{}
+
+	msg := config.Message{Text: "can someone help me", Username: "wim", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Gateway: "bridge1", ID: "1"}
+	gw.relayMessage(&msg)
+	require.Len(t, discord.sent, 1)
+	require.Len(t, mods.sent, 1)
+	alert := mods.sent[0]
+	assert.Equal(t, "mods", alert.Channel)
+	assert.Equal(t, "@here alert crisis: wim in #wimtesting on irc.freenode: can someone help me\nhttps://chat.example.com/general/1", alert.Text)
+
+	msg.Text = "hello"
+	gw.relayMessage(&msg)
+	gw.relayMessage(&msg)
+	require.Len(t, mods.sent, 2)
+	assert.Contains(t, mods.sent[1].Text, "alert flood: wim")
+}
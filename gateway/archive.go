@@ -0,0 +1,292 @@
+package gateway
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+)
+
+// archive keeps the messages relayed by the gateways in a sqlite database with a full-text
+// index, searched at /api/search of the admin listener.
+type archive struct {
+	db  *sql.DB
+	ttl time.Duration // 0 keeps the messages forever
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// archivedMessage is a message in the archive, as returned by /api/search and exported.
+type archivedMessage struct {
+	Gateway   string         `json:"gateway"`
+	Account   string         `json:"account"`
+	Protocol  string         `json:"protocol"`
+	Channel   string         `json:"channel"`
+	Username  string         `json:"username"`
+	UserID    string         `json:"userid"`
+	ID        string         `json:"id"`
+	Text      string         `json:"text"`
+	Timestamp time.Time      `json:"timestamp"`
+	Files     []archivedFile `json:"files,omitempty"`
+}
+
+// archivedFile is a file of an archived message, the URL is empty for the files that were
+// relayed without a MediaServerUpload or a URL of their bridge.
+type archivedFile struct {
+	Name string `json:"name"`
+	URL  string `json:"url,omitempty"`
+	Size int64  `json:"size"`
+}
+
+// the fts table is an index of the text of the messages, kept up to date by the triggers
+const archiveSchema = `
+CREATE TABLE IF NOT EXISTS messages (
+	gateway   TEXT NOT NULL,
+	account   TEXT NOT NULL,
+	protocol  TEXT NOT NULL,
+	channel   TEXT NOT NULL,
+	username  TEXT NOT NULL,
+	userid    TEXT NOT NULL,
+	id        TEXT NOT NULL,
+	text      TEXT NOT NULL,
+	timestamp INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS messages_id ON messages (gateway, account, id);
+CREATE INDEX IF NOT EXISTS messages_timestamp ON messages (timestamp);
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(text, content='messages', content_rowid='rowid');
+CREATE TRIGGER IF NOT EXISTS messages_ai AFTER INSERT ON messages BEGIN
+	INSERT INTO messages_fts (rowid, text) VALUES (new.rowid, new.text);
+END;
+CREATE TRIGGER IF NOT EXISTS messages_ad AFTER DELETE ON messages BEGIN
+	INSERT INTO messages_fts (messages_fts, rowid, text) VALUES ('delete', old.rowid, old.text);
+END;
+CREATE TRIGGER IF NOT EXISTS messages_au AFTER UPDATE OF text ON messages BEGIN
+	INSERT INTO messages_fts (messages_fts, rowid, text) VALUES ('delete', old.rowid, old.text);
+	INSERT INTO messages_fts (rowid, text) VALUES (new.rowid, new.text);
+END;
+CREATE TABLE IF NOT EXISTS files (
+	message INTEGER NOT NULL,
+	name    TEXT NOT NULL,
+	url     TEXT NOT NULL,
+	size    INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS files_message ON files (message);
+CREATE TRIGGER IF NOT EXISTS messages_files_ad AFTER DELETE ON messages BEGIN
+	DELETE FROM files WHERE message = old.rowid;
+END;
+`
+
+func newArchive(path string, days int) (*archive, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only has one writer, a single connection avoids "database is locked"
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(archiveSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	a := &archive{db: db, ttl: time.Duration(days) * 24 * time.Hour}
+	if err := a.prune(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// prune removes the messages older than ttl.
+func (a *archive) prune() error {
+	a.lastPrune = time.Now()
+	if a.ttl == 0 {
+		return nil
+	}
+	_, err := a.db.Exec("DELETE FROM messages WHERE timestamp < ?", time.Now().Add(-a.ttl).UnixMilli())
+	return err
+}
+
+// add archives the message msg relayed by gateway with its files. Edits replace the text of
+// the message they edit and deleted messages are removed, for the bridges with message IDs.
+func (a *archive) add(gateway string, msg *config.Message) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if time.Since(a.lastPrune) > time.Hour {
+		if err := a.prune(); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case msg.Event == config.EventMsgDelete:
+		if msg.ID == "" {
+			return nil
+		}
+		_, err := a.db.Exec("DELETE FROM messages WHERE gateway = ? AND account = ? AND id = ?", gateway, msg.Account, msg.ID)
+		return err
+	case msg.Event != "" && msg.Event != config.EventUserAction:
+		return nil
+	case msg.Text == "" && len(msg.Extra["file"]) == 0:
+		return nil
+	}
+
+	if msg.ID != "" {
+		res, err := a.db.Exec("UPDATE messages SET text = ? WHERE gateway = ? AND account = ? AND id = ?",
+			msg.Text, gateway, msg.Account, msg.ID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			return nil
+		}
+	}
+	timestamp := msg.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	res, err := tx.Exec("INSERT INTO messages (gateway, account, protocol, channel, username, userid, id, text, timestamp) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		gateway, msg.Account, msg.Protocol, msg.Channel, msg.Username, msg.UserID, msg.ID, msg.Text, timestamp.UnixMilli())
+	if err != nil {
+		return err
+	}
+	rowid, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, f := range msg.Extra["file"] {
+		fi, ok := f.(config.FileInfo)
+		if !ok || fi.Avatar {
+			continue
+		}
+		if _, err := tx.Exec("INSERT INTO files (message, name, url, size) VALUES (?, ?, ?, ?)", rowid, fi.Name, fi.URL, fi.Size); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// searchQuery returns the fts5 query of the words of q, every word has to be in a message.
+// The words are quoted so q can't be an invalid query.
+func searchQuery(q string) string {
+	words := strings.Fields(q)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// search returns the messages with all the words of q, newest first. When channel or from
+// aren't empty only the messages in that channel and by that user are returned.
+func (a *archive) search(q, channel, from string, limit int) ([]archivedMessage, error) {
+	query := `SELECT m.gateway, m.account, m.protocol, m.channel, m.username, m.userid, m.id, m.text, m.timestamp
+		FROM messages_fts JOIN messages m ON m.rowid = messages_fts.rowid
+		WHERE messages_fts MATCH ?`
+	args := []interface{}{searchQuery(q)}
+	if channel != "" {
+		query += " AND m.channel = ?"
+		args = append(args, channel)
+	}
+	if from != "" {
+		query += " AND (m.username = ? COLLATE NOCASE OR m.userid = ?)"
+		args = append(args, from, from)
+	}
+	query += " ORDER BY m.timestamp DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := a.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	msgs := []archivedMessage{}
+	for rows.Next() {
+		var m archivedMessage
+		var timestamp int64
+		if err := rows.Scan(&m.Gateway, &m.Account, &m.Protocol, &m.Channel, &m.Username, &m.UserID, &m.ID, &m.Text, &timestamp); err != nil {
+			return nil, err
+		}
+		m.Timestamp = time.UnixMilli(timestamp)
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// quote returns the nick and text of the message id sent on protocol in gateway.
+func (a *archive) quote(gateway, protocol, id string) (config.Quote, bool, error) {
+	var quote config.Quote
+	err := a.db.QueryRow("SELECT username, text FROM messages WHERE gateway = ? AND protocol = ? AND id = ? ORDER BY timestamp DESC LIMIT 1",
+		gateway, protocol, id).Scan(&quote.Username, &quote.Text)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return config.Quote{}, false, nil
+	case err != nil:
+		return config.Quote{}, false, err
+	}
+	return quote, true, nil
+}
+
+func (a *archive) Close() error {
+	return a.db.Close()
+}
+
+// archiveMessage archives msg when ArchivePath is set.
+func (gw *Gateway) archiveMessage(msg *config.Message) {
+	if gw.Router == nil || gw.Router.archive == nil {
+		return
+	}
+	if err := gw.Router.archive.add(gw.Name, msg); err != nil {
+		gw.logger.Errorf("archive: failed to archive message %s of %s: %s", msg.ID, msg.Account, err)
+	}
+}
+
+// handleSearch serves /api/search?q=...&channel=...&from=...&limit=... of the admin listener.
+func (r *Router) handleSearch(w http.ResponseWriter, req *http.Request) {
+	if r.archive == nil {
+		http.Error(w, "archiving is disabled, see ArchivePath", http.StatusNotFound)
+		return
+	}
+	params := req.URL.Query()
+	q := params.Get("q")
+	if strings.TrimSpace(q) == "" {
+		http.Error(w, "q is missing", http.StatusBadRequest)
+		return
+	}
+	limit := defaultSearchLimit
+	if l := params.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	msgs, err := r.archive.search(q, params.Get("channel"), params.Get("from"), limit)
+	if err != nil {
+		r.logger.Errorf("admin: search %q failed: %s", q, err)
+		http.Error(w, "search failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(msgs); err != nil {
+		r.logger.Errorf("admin: failed to write search results: %s", err)
+	}
+}
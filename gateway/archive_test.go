@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchive(t *testing.T) {
+	a, err := newArchive(filepath.Join(t.TempDir(), "archive.db"), 0)
+	require.NoError(t, err)
+	defer a.Close()
+
+	now := time.Now()
+	msgs := []config.Message{
+		{Account: "irc.freenode", Protocol: "irc", Channel: "#wimtesting", Username: "wim", Text: "the bridge is down", Timestamp: now.Add(-time.Hour)},
+		{Account: "discord.test", Protocol: "discord", Channel: "general", Username: "Alice", UserID: "42", ID: "1", Text: "is the bridge down?", Timestamp: now},
+		{Account: "discord.test", Protocol: "discord", Channel: "general", Username: "Bob", ID: "2", Text: "no idea"},
+		{Account: "discord.test", Protocol: "discord", Channel: "general", Username: "Bob", Event: config.EventUserTyping},
+	}
+	for i := range msgs {
+		require.NoError(t, a.add("bridge1", &msgs[i]))
+	}
+
+	found, err := a.search("bridge DOWN", "", "", 10)
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, "Alice", found[0].Username)
+	assert.Equal(t, "wim", found[1].Username)
+
+	found, err = a.search("bridge", "#wimtesting", "", 10)
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+	found, err = a.search("bridge", "", "alice", 10)
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+	found, err = a.search("bridge", "", "42", 10)
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+
+	// quotes and operators are searched as words
+	found, err = a.search(`"bridge AND`, "", "", 10)
+	require.NoError(t, err)
+	assert.Len(t, found, 0)
+
+	// edits replace the text, deleted messages are removed
+	require.NoError(t, a.add("bridge1", &config.Message{Account: "discord.test", ID: "2", Text: "the bridge works"}))
+	found, err = a.search("works", "", "", 10)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "Bob", found[0].Username)
+	found, err = a.search("idea", "", "", 10)
+	require.NoError(t, err)
+	assert.Len(t, found, 0)
+
+	require.NoError(t, a.add("bridge1", &config.Message{Account: "discord.test", ID: "1", Event: config.EventMsgDelete}))
+	found, err = a.search("bridge", "general", "", 10)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "2", found[0].ID)
+}
+
+func TestArchivePrune(t *testing.T) {
+	a, err := newArchive(filepath.Join(t.TempDir(), "archive.db"), 1)
+	require.NoError(t, err)
+	defer a.Close()
+	require.NoError(t, a.add("bridge1", &config.Message{Account: "irc.freenode", Text: "old news", Timestamp: time.Now().Add(-48 * time.Hour)}))
+	require.NoError(t, a.add("bridge1", &config.Message{Account: "irc.freenode", Text: "fresh news"}))
+	require.NoError(t, a.prune())
+	found, err := a.search("news", "", "", 10)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "fresh news", found[0].Text)
+}
+
+func TestHandleSearch(t *testing.T) {
+	r := maketestRouter(testconfigAdmin)
+	h := r.adminHandler()
+	assert.Equal(t, http.StatusNotFound, adminRequest(h, "/api/search?q=bridge", "secret").Code)
+
+	a, err := newArchive(filepath.Join(t.TempDir(), "archive.db"), 0)
+	require.NoError(t, err)
+	defer a.Close()
+	r.archive = a
+	r.Gateways["bridge1"].archiveMessage(&config.Message{Account: "irc.freenode", Channel: "#wimtesting", Username: "wim", Text: "the bridge is down"})
+
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(h, "/api/search?q=bridge", "").Code)
+	assert.Equal(t, http.StatusBadRequest, adminRequest(h, "/api/search", "secret").Code)
+	assert.Equal(t, http.StatusBadRequest, adminRequest(h, "/api/search?q=bridge&limit=x", "secret").Code)
+
+	rec := adminRequest(h, "/api/search?q=bridge&channel=%23wimtesting&from=wim", "secret")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var found []archivedMessage
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &found))
+	require.Len(t, found, 1)
+	assert.Equal(t, "bridge1", found[0].Gateway)
+	assert.Equal(t, "the bridge is down", found[0].Text)
+}
@@ -0,0 +1,62 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+const (
+	avatarCacheTTL = 24 * time.Hour
+	// avatarFailedTTL is how long an avatar that couldn't be copied is sent as it is.
+	avatarFailedTTL = time.Hour
+)
+
+// avatarExts are the extensions of the avatars on the mediaserver, other images are named .png.
+var avatarExts = map[string]string{
+	"image/gif":  ".gif",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+}
+
+func (gw *Gateway) avatarBucket() *store.Bucket {
+	return store.NewBucket(gw.Router.Store, "avatars")
+}
+
+// mediaAvatar returns the copy on the mediaserver of the avatar URL for destinations with
+// MediaServerAvatars, eg discord webhooks can't fetch avatars that need authentication or
+// expire. The copies are kept for a day, the avatar is returned as it is when it can't be
+// copied.
+func (gw *Gateway) mediaAvatar(avatar string, dest *bridge.Bridge) string {
+	general := &gw.BridgeValues().General
+	if avatar == "" || !dest.GetBool("MediaServerAvatars") || !helper.HasMediaServer(general) ||
+		general.MediaServerDownload == "" || strings.HasPrefix(avatar, general.MediaServerDownload+"/") {
+		return avatar
+	}
+	bucket := gw.avatarBucket()
+	if cached, ok := bucket.GetString(avatar); ok {
+		return cached
+	}
+	cached, ttl := avatar, avatarCacheTTL
+	data, err := helper.DownloadFile(avatar)
+	if err == nil {
+		ext, ok := avatarExts[http.DetectContentType(*data)]
+		if !ok {
+			ext = ".png"
+		}
+		cached, _, err = gw.uploadMedia(config.FileInfo{Name: "avatar" + ext, Data: data, Size: int64(len(*data))})
+	}
+	if err != nil {
+		gw.logger.Errorf("copying avatar %s to the mediaserver failed: %s", avatar, err)
+		cached, ttl = avatar, avatarFailedTTL
+	}
+	if err := bucket.SetStringTTL(avatar, cached, ttl); err != nil {
+		gw.logger.Errorf("failed to cache avatar %s: %s", avatar, err)
+	}
+	return cached
+}
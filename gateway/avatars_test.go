@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMediaAvatar(t *testing.T) {
+	gif := []byte("GIF89a avatar")
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/missing.png" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(gif)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	r := maketestRouter([]byte(fmt.Sprintf(`
+[general]
+MediaDownloadPath=%q
+MediaServerDownload="https://media.example.com"
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+MediaServerAvatars=true
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+`, dir)))
+	gw := r.Gateways["bridge1"]
+	discord, irc := gw.Bridges["discord.test"], gw.Bridges["irc.freenode"]
+
+	avatar := gw.mediaAvatar(ts.URL+"/u/1", discord)
+	assert.Regexp(t, `^https://media\.example\.com/[0-9a-f]{8}/avatar\.gif$`, avatar)
+	data, err := os.ReadFile(filepath.Join(dir, filepath.Base(filepath.Dir(avatar)), "avatar.gif"))
+	require.NoError(t, err)
+	assert.Equal(t, gif, data)
+
+	// copies are cached and only made for bridges with MediaServerAvatars
+	assert.Equal(t, avatar, gw.mediaAvatar(ts.URL+"/u/1", discord))
+	assert.Equal(t, ts.URL+"/u/1", gw.mediaAvatar(ts.URL+"/u/1", irc))
+	assert.Equal(t, avatar, gw.mediaAvatar(avatar, discord))
+	assert.Equal(t, 1, requests)
+
+	// avatars that can't be downloaded are sent as they are
+	assert.Equal(t, ts.URL+"/missing.png", gw.mediaAvatar(ts.URL+"/missing.png", discord))
+	assert.Equal(t, ts.URL+"/missing.png", gw.mediaAvatar(ts.URL+"/missing.png", discord))
+	assert.Equal(t, 2, requests)
+}
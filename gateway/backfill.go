@@ -0,0 +1,181 @@
+package gateway
+
+import (
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+const (
+	defaultBackfillLimit  = 100
+	defaultBackfillMaxAge = 24 * time.Hour
+	// historySaveInterval is how often the last seen time of a channel is written to the store.
+	historySaveInterval = time.Minute
+)
+
+// history keeps the time of the last message seen in the channels of the gateways, keyed
+// by channel ID, from which their history is fetched after a reconnect.
+type history struct {
+	sync.Mutex
+	lastSeen map[string]time.Time
+	saved    map[string]time.Time
+	fetching map[string]bool // accounts of which the history is being fetched
+}
+
+func newHistory() *history {
+	return &history{lastSeen: make(map[string]time.Time), saved: make(map[string]time.Time), fetching: make(map[string]bool)}
+}
+
+func historyBucket(s store.Store) *store.Bucket {
+	return store.NewBucket(s, "history")
+}
+
+// isBridged returns true if channelID is a channel of one of the gateways.
+func (r *Router) isBridged(channelID string) bool {
+	for _, gw := range r.gateways() {
+		if _, ok := gw.Channels[channelID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// recordSeen remembers the time of msg for its channel. It's written to the store at most
+// once every historySaveInterval, so a restart backfills a bit more than needed and the
+// duplicates are dropped.
+func (r *Router) recordSeen(msg *config.Message) {
+	if msg.ID == "" || msg.Channel == "" || (msg.Event != "" && msg.Event != config.EventUserAction) {
+		return
+	}
+	channelID := gatewayChannelID(msg.Account, msg.Channel)
+	if !r.isBridged(channelID) {
+		return
+	}
+	seen := msg.Timestamp
+	if seen.IsZero() {
+		seen = time.Now()
+	}
+
+	h := r.history
+	h.Lock()
+	defer h.Unlock()
+	if !seen.After(h.lastSeen[channelID]) {
+		return
+	}
+	h.lastSeen[channelID] = seen
+	if seen.Sub(h.saved[channelID]) < historySaveInterval {
+		return
+	}
+	if err := historyBucket(r.Store).SetString(channelID, seen.UTC().Format(time.RFC3339Nano)); err != nil {
+		r.logger.Errorf("failed to save the last seen message of %s: %s", channelID, err)
+		return
+	}
+	h.saved[channelID] = seen
+}
+
+// seenSince returns the time of the last message seen in channelID, zero if none was seen.
+func (r *Router) seenSince(channelID string) time.Time {
+	r.history.Lock()
+	seen, ok := r.history.lastSeen[channelID]
+	r.history.Unlock()
+	if ok {
+		return seen
+	}
+	value, ok := historyBucket(r.Store).GetString(channelID)
+	if !ok {
+		return time.Time{}
+	}
+	seen, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		r.logger.Errorf("invalid last seen time %q of %s: %s", value, channelID, err)
+		return time.Time{}
+	}
+	return seen
+}
+
+// isBackfilled returns true for a message fetched from the history that was already seen,
+// it's either relayed or a copy sent by us.
+func (r *Router) isBackfilled(msg *config.Message) bool {
+	if _, ok := msg.Extra[config.ExtraBackfill]; !ok || msg.ID == "" {
+		return false
+	}
+	for _, gw := range r.gateways() {
+		if gw.FindCanonicalMsgID(msg.Protocol, msg.ID) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// backfill fetches the messages sent in the channels of br since the last message that was
+// seen in them, for bridges with Backfill that can fetch their history. Channels without a
+// last seen message aren't backfilled, at most BackfillMaxAge seconds are.
+func (r *Router) backfill(br *bridge.Bridge) {
+	if br.Bridger == nil || !br.GetBool("Backfill") {
+		return
+	}
+	fetcher, ok := br.Bridger.(bridge.HistoryFetcher)
+	if !ok {
+		return
+	}
+	r.history.Lock()
+	if r.history.fetching[br.Account] {
+		r.history.Unlock()
+		return
+	}
+	r.history.fetching[br.Account] = true
+	r.history.Unlock()
+	defer func() {
+		r.history.Lock()
+		delete(r.history.fetching, br.Account)
+		r.history.Unlock()
+	}()
+
+	limit := br.GetInt("BackfillLimit")
+	if limit <= 0 {
+		limit = defaultBackfillLimit
+	}
+	maxAge := defaultBackfillMaxAge
+	if age := br.GetInt("BackfillMaxAge"); age > 0 {
+		maxAge = time.Duration(age) * time.Second
+	}
+	oldest := time.Now().Add(-maxAge)
+
+	seen := make(map[string]bool)
+	for _, gw := range r.gateways() {
+		for _, channel := range gw.Channels {
+			if channel.Account != br.Account || channel.Direction == "out" || seen[channel.ID] {
+				continue
+			}
+			seen[channel.ID] = true
+			since := r.seenSince(channel.ID)
+			if since.IsZero() {
+				continue
+			}
+			if since.Before(oldest) {
+				since = oldest
+			}
+			r.logger.Infof("backfilling %s on %s since %s", channel.Name, br.Account, since.Format(time.RFC3339))
+			if err := fetcher.FetchHistory(channel.Name, since, limit); err != nil {
+				r.logger.Errorf("backfilling %s on %s failed: %s", channel.Name, br.Account, err)
+			}
+		}
+	}
+}
+
+// backfills backfills the channels of the bridges after a restart.
+func (r *Router) backfills() {
+	seen := make(map[string]bool)
+	for _, gw := range r.gateways() {
+		for _, br := range gw.Bridges {
+			if seen[br.Account] {
+				continue
+			}
+			seen[br.Account] = true
+			r.goBridge(r.backfill, br)
+		}
+	}
+}
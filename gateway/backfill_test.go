@@ -0,0 +1,91 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigBackfill = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+Backfill=true
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "quiet"
+`)
+
+// historyBridger is a recordBridger that records the history it's asked for.
+type historyBridger struct {
+	recordBridger
+	fetched map[string]time.Time
+}
+
+func (h *historyBridger) FetchHistory(channel string, since time.Time, limit int) error {
+	h.fetched[channel] = since
+	return nil
+}
+
+func TestRecordSeen(t *testing.T) {
+	r := maketestRouter(testconfigBackfill)
+	seen := time.Now().Add(-time.Hour)
+	r.recordSeen(&config.Message{Account: "discord.test", Channel: "general", ID: "1", Timestamp: seen})
+	r.recordSeen(&config.Message{Account: "discord.test", Channel: "general", ID: "2", Timestamp: seen.Add(time.Second)})
+	// older messages, events and unbridged channels are ignored
+	r.recordSeen(&config.Message{Account: "discord.test", Channel: "general", ID: "0", Timestamp: seen.Add(-time.Second)})
+	r.recordSeen(&config.Message{Account: "discord.test", Channel: "general", ID: "3", Timestamp: seen.Add(time.Hour), Event: config.EventUserTyping})
+	r.recordSeen(&config.Message{Account: "discord.test", Channel: "random", ID: "4", Timestamp: seen})
+
+	assert.True(t, r.seenSince("generaldiscord.test").Equal(seen.Add(time.Second)))
+	assert.True(t, r.seenSince("randomdiscord.test").IsZero())
+
+	// the store has the first time, the next one is saved a minute later
+	r.history = newHistory()
+	assert.True(t, r.seenSince("generaldiscord.test").Equal(seen))
+}
+
+func TestBackfill(t *testing.T) {
+	r := maketestRouter(testconfigBackfill)
+	gw := r.Gateways["bridge1"]
+	h := &historyBridger{fetched: make(map[string]time.Time)}
+	gw.Bridges["discord.test"].Bridger = h
+
+	seen := time.Now().Add(-time.Hour)
+	r.recordSeen(&config.Message{Account: "discord.test", Channel: "general", ID: "1", Timestamp: seen})
+	r.backfill(gw.Bridges["discord.test"])
+	assert.Len(t, h.fetched, 1)
+	assert.True(t, h.fetched["general"].Equal(seen))
+
+	// at most BackfillMaxAge is backfilled
+	r.recordSeen(&config.Message{Account: "discord.test", Channel: "quiet", ID: "2", Timestamp: time.Now().Add(-48 * time.Hour)})
+	r.backfill(gw.Bridges["discord.test"])
+	assert.WithinDuration(t, time.Now().Add(-defaultBackfillMaxAge), h.fetched["quiet"], time.Minute)
+}
+
+func TestIsBackfilled(t *testing.T) {
+	r := maketestRouter(testconfigBackfill)
+	gw := r.Gateways["bridge1"]
+	gw.addMsgIDs("discord 1", []*BrMsgID{{gw.Bridges["irc.freenode"], "irc 5", "#wimtestingirc.freenode"}})
+
+	backfill := map[string][]interface{}{config.ExtraBackfill: {true}}
+	assert.True(t, r.isBackfilled(&config.Message{Protocol: "discord", ID: "1", Extra: backfill}))
+	assert.False(t, r.isBackfilled(&config.Message{Protocol: "discord", ID: "2", Extra: backfill}))
+	assert.False(t, r.isBackfilled(&config.Message{Protocol: "discord", ID: "1"}))
+}
@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const (
+	defaultBreakerCooldown = time.Minute
+
+	breakerOpen   = "circuit_open"
+	breakerClosed = "circuit_closed"
+)
+
+// errBreakerOpen is returned for messages to a bridge while its circuit breaker is open.
+var errBreakerOpen = errors.New("circuit breaker open")
+
+// breaker stops sending to a bridge after BreakerThreshold consecutive failures,
+// for BreakerCooldown seconds. After the cooldown one message is tried again,
+// which closes the breaker when it succeeds.
+type breaker struct {
+	sync.Mutex
+	failures  int
+	openUntil time.Time
+	trying    bool
+}
+
+func (r *Router) breaker(account string) *breaker {
+	r.breakersMu.Lock()
+	defer r.breakersMu.Unlock()
+	b, ok := r.breakers[account]
+	if !ok {
+		b = &breaker{}
+		r.breakers[account] = b
+	}
+	return b
+}
+
+func breakerCooldown(dest *bridge.Bridge) time.Duration {
+	if cooldown := dest.GetInt("BreakerCooldown"); cooldown > 0 {
+		return time.Duration(cooldown) * time.Second
+	}
+	return defaultBreakerCooldown
+}
+
+// send sends msg to dest through the circuit breaker of dest.
+func (r *Router) send(dest *bridge.Bridge, msg config.Message) (string, error) {
+	if r.isDisabled(dest.Account) {
+		return "", errBridgeDisabled
+	}
+	threshold := dest.GetInt("BreakerThreshold")
+	if threshold <= 0 {
+		return r.sendChaos(dest, msg)
+	}
+	b := r.breaker(dest.Account)
+	b.Lock()
+	if !b.openUntil.IsZero() && (time.Now().Before(b.openUntil) || b.trying) {
+		b.Unlock()
+		return "", errBreakerOpen
+	}
+	halfOpen := !b.openUntil.IsZero()
+	b.trying = halfOpen
+	b.Unlock()
+
+	mID, err := r.sendChaos(dest, msg)
+
+	b.Lock()
+	defer b.Unlock()
+	b.trying = false
+	if err == nil {
+		b.failures = 0
+		if halfOpen {
+			b.openUntil = time.Time{}
+			r.logger.Infof("circuit breaker of %s closed", dest.Account)
+			r.emitBridgeStatus(dest, breakerClosed)
+			r.requestReplay(dest)
+		}
+		return mID, nil
+	}
+	b.failures++
+	if halfOpen || b.failures >= threshold {
+		cooldown := breakerCooldown(dest)
+		b.openUntil = time.Now().Add(cooldown)
+		if !halfOpen {
+			r.logger.Warnf("circuit breaker of %s opened after %d failures, not sending for %s", dest.Account, b.failures, cooldown)
+			r.emitBridgeStatus(dest, breakerOpen)
+		}
+	}
+	return mID, err
+}
+
+// emitBridgeStatus sends a bridge_status event for dest to the router, status is the text of the event.
+func (r *Router) emitBridgeStatus(dest *bridge.Bridge, status string) {
+	msg := config.Message{
+		Username: "system",
+		Text:     status,
+		Account:  dest.Account,
+		Event:    config.EventBridgeStatus,
+		Protocol: dest.Protocol,
+	}
+	// send is called from the router itself, don't block it
+	go func() { r.Message <- msg }()
+}
+
+// handleEventBridgeStatus passes bridge status events on to the API bridges.
+func (r *Router) handleEventBridgeStatus(msg *config.Message) {
+	if msg.Event != config.EventBridgeStatus {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, gw := range r.gateways() {
+		for _, br := range gw.Bridges {
+			if br.Protocol != apiProtocol || seen[br.Account] || br.Bridger == nil {
+				continue
+			}
+			seen[br.Account] = true
+			status := *msg
+			status.Channel = "api"
+			if _, err := br.Send(status); err != nil {
+				r.logger.Errorf("failed to send status of %s to %s: %s", msg.Account, br.Account, err)
+			}
+		}
+	}
+}
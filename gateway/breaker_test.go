@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/gateway/events"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigBreaker = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+BreakerThreshold=2
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+	`)
+
+func TestBreaker(t *testing.T) {
+	r := maketestRouter(testconfigBreaker)
+	gw := r.Gateways["bridge1"]
+	flaky := &flakyBridger{down: true}
+	dest := gw.Bridges["discord.test"]
+	dest.Bridger = flaky
+	msg := config.Message{Text: "hi", Channel: "general"}
+
+	_, err := r.send(dest, msg)
+	assert.EqualError(t, err, "connection lost")
+	_, err = r.send(dest, msg)
+	assert.EqualError(t, err, "connection lost")
+	// the breaker is open now
+	status := <-r.Message
+	assert.Equal(t, config.EventBridgeStatus, status.Event)
+	assert.Equal(t, breakerOpen, status.Text)
+	flaky.Lock()
+	flaky.down = false
+	flaky.Unlock()
+	_, err = r.send(dest, msg)
+	assert.Equal(t, errBreakerOpen, err)
+	assert.Empty(t, flaky.texts())
+
+	// after the cooldown a message is tried again
+	r.breaker("discord.test").openUntil = time.Now().Add(-time.Second)
+	_, err = r.send(dest, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hi"}, flaky.texts())
+	status = <-r.Message
+	assert.Equal(t, breakerClosed, status.Text)
+
+	// bridges without a threshold don't have a breaker
+	irc := &recordBridger{}
+	gw.Bridges["irc.freenode"].Bridger = irc
+	_, err = r.send(gw.Bridges["irc.freenode"], msg)
+	assert.NoError(t, err)
+}
+
+func TestSendEvents(t *testing.T) {
+	r := maketestRouter(testconfigBreaker)
+	gw := r.Gateways["bridge1"]
+	flaky := &flakyBridger{}
+	dest := gw.Bridges["discord.test"]
+	dest.Bridger = flaky
+
+	got := make(chan events.Event, 2)
+	defer r.Events.Subscribe(func(ev events.Event) { got <- ev }, events.MessageRelayed, events.SendFailed)()
+
+	msg := &config.Message{Text: "hi", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc"}
+	_, err := gw.SendMessage(msg, dest, gw.Channels["generaldiscord.test"], "")
+	assert.NoError(t, err)
+	ev := <-got
+	assert.Equal(t, events.MessageRelayed, ev.Kind)
+	assert.Equal(t, "discord.test", ev.Account)
+	assert.Equal(t, "general", ev.Channel)
+
+	flaky.Lock()
+	flaky.down = true
+	flaky.Unlock()
+	_, err = gw.SendMessage(msg, dest, gw.Channels["generaldiscord.test"], "")
+	assert.Error(t, err)
+	ev = <-got
+	assert.Equal(t, events.SendFailed, ev.Kind)
+	assert.EqualError(t, ev.Err, "connection lost")
+}
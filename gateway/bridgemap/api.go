@@ -7,5 +7,6 @@ import (
 )
 
 func init() {
-	FullMap["api"] = api.New
+	Register("api", api.New)
+	PresenceSupport["api"] = struct{}{}
 }
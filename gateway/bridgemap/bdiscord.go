@@ -7,6 +7,10 @@ import (
 )
 
 func init() {
-	FullMap["discord"] = bdiscord.New
+	Register("discord", bdiscord.New)
 	UserTypingSupport["discord"] = struct{}{}
+	MediaReaderSupport["discord"] = struct{}{}
+	ReplySupport["discord"] = struct{}{}
+	ReactionSupport["discord"] = struct{}{}
+	FileUploadSupport["discord"] = struct{}{}
 }
@@ -1,5 +1,5 @@
-//go:build !noharmony
-// +build !noharmony
+//go:build !noharmony && !minimal
+// +build !noharmony,!minimal
 
 package bridgemap
 
@@ -8,5 +8,6 @@ import (
 )
 
 func init() {
-	FullMap["harmony"] = bharmony.New
+	Register("harmony", bharmony.New)
+	Deprecate("harmony", "the harmony chat service has shut down")
 }
@@ -7,5 +7,5 @@ import (
 )
 
 func init() {
-	FullMap["irc"] = birc.New
+	Register("irc", birc.New)
 }
@@ -1,4 +1,4 @@
-// +build !nokeybase
+// +build !nokeybase,!minimal
 
 package bridgemap
 
@@ -7,5 +7,6 @@ import (
 )
 
 func init() {
-	FullMap["keybase"] = bkeybase.New
+	Register("keybase", bkeybase.New)
+	FileUploadSupport["keybase"] = struct{}{}
 }
@@ -7,5 +7,11 @@ import (
 )
 
 func init() {
-	FullMap["matrix"] = bmatrix.New
+	Register("matrix", bmatrix.New)
+	UserTypingSupport["matrix"] = struct{}{}
+	ReplySupport["matrix"] = struct{}{}
+	ReactionSupport["matrix"] = struct{}{}
+	UserBanSupport["matrix"] = struct{}{}
+	FileUploadSupport["matrix"] = struct{}{}
+	Registrations["matrix"] = bmatrix.Registration
 }
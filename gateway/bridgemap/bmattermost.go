@@ -7,5 +7,9 @@ import (
 )
 
 func init() {
-	FullMap["mattermost"] = bmattermost.New
+	Register("mattermost", bmattermost.New)
+	ReplySupport["mattermost"] = struct{}{}
+	PrioritySupport["mattermost"] = struct{}{}
+	ReactionSupport["mattermost"] = struct{}{}
+	FileUploadSupport["mattermost"] = struct{}{}
 }
@@ -1,4 +1,4 @@
-// +build !nomsteams
+// +build !nomsteams,!minimal
 
 package bridgemap
 
@@ -7,5 +7,5 @@ import (
 )
 
 func init() {
-	FullMap["msteams"] = bmsteams.New
+	Register("msteams", bmsteams.New)
 }
@@ -1,4 +1,4 @@
-// +build !nomumble
+// +build !nomumble,!minimal
 
 package bridgemap
 
@@ -7,5 +7,6 @@ import (
 )
 
 func init() {
-	FullMap["mumble"] = bmumble.New
+	Register("mumble", bmumble.New)
+	FileUploadSupport["mumble"] = struct{}{}
 }
@@ -1,4 +1,4 @@
-// +build !nonctalk
+// +build !nonctalk,!minimal
 
 package bridgemap
 
@@ -7,5 +7,5 @@ import (
 )
 
 func init() {
-	FullMap["nctalk"] = btalk.New
+	Register("nctalk", btalk.New)
 }
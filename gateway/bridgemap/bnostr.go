@@ -0,0 +1,11 @@
+// +build !nonostr,!minimal
+
+package bridgemap
+
+import (
+	bnostr "github.com/42wim/matterbridge/bridge/nostr"
+)
+
+func init() {
+	Register("nostr", bnostr.New)
+}
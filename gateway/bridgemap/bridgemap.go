@@ -2,9 +2,28 @@ package bridgemap
 
 import (
 	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
 )
 
 var (
 	FullMap           = map[string]bridge.Factory{}
 	UserTypingSupport = map[string]struct{}{}
+	// PresenceSupport are the protocols that get the presence events
+	PresenceSupport = map[string]struct{}{}
+	// MediaReaderSupport are the protocols that read files with FileInfo.Open instead of Data
+	MediaReaderSupport = map[string]struct{}{}
+	// FileUploadSupport are the protocols that upload the files natively instead of sending their URL
+	FileUploadSupport = map[string]struct{}{}
+	// ReplySupport are the protocols that send a message with ParentID as a native reply to that message
+	ReplySupport = map[string]struct{}{}
+	// ReactionSupport are the protocols that add and remove the reactions to the message with ParentID
+	ReactionSupport = map[string]struct{}{}
+	// PrioritySupport are the protocols that send the priority of a message natively
+	PrioritySupport = map[string]struct{}{}
+	// UserBanSupport are the protocols that ban the users of an EventUserBan
+	UserBanSupport = map[string]struct{}{}
+	// Deprecated are the protocols that will be removed in a next release, with the reason
+	Deprecated = map[string]string{}
+	// Registrations generate the registration file of an account for the protocols running as appservice
+	Registrations = map[string]func(account string, cfg config.Config) (string, error){}
 )
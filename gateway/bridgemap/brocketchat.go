@@ -1,4 +1,4 @@
-// +build !norocketchat
+// +build !norocketchat,!minimal
 
 package bridgemap
 
@@ -7,5 +7,7 @@ import (
 )
 
 func init() {
-	FullMap["rocketchat"] = brocketchat.New
+	Register("rocketchat", brocketchat.New)
+	MediaReaderSupport["rocketchat"] = struct{}{}
+	FileUploadSupport["rocketchat"] = struct{}{}
 }
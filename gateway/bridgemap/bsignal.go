@@ -0,0 +1,12 @@
+// +build !nosignal,!minimal
+
+package bridgemap
+
+import (
+	bsignal "github.com/42wim/matterbridge/bridge/signal"
+)
+
+func init() {
+	Register("signal", bsignal.New)
+	FileUploadSupport["signal"] = struct{}{}
+}
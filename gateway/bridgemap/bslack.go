@@ -7,7 +7,10 @@ import (
 )
 
 func init() {
-	FullMap["slack-legacy"] = bslack.NewLegacy
-	FullMap["slack"] = bslack.New
+	Register("slack-legacy", bslack.NewLegacy)
+	Register("slack", bslack.New)
 	UserTypingSupport["slack"] = struct{}{}
+	MediaReaderSupport["slack"] = struct{}{}
+	ReactionSupport["slack"] = struct{}{}
+	FileUploadSupport["slack"] = struct{}{}
 }
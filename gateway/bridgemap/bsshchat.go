@@ -1,4 +1,4 @@
-// +build !nosshchat
+// +build !nosshchat,!minimal
 
 package bridgemap
 
@@ -7,5 +7,5 @@ import (
 )
 
 func init() {
-	FullMap["sshchat"] = bsshchat.New
+	Register("sshchat", bsshchat.New)
 }
@@ -1,4 +1,4 @@
-// +build !nosteam
+// +build !nosteam,!minimal
 
 package bridgemap
 
@@ -7,5 +7,6 @@ import (
 )
 
 func init() {
-	FullMap["steam"] = bsteam.New
+	Register("steam", bsteam.New)
+	Deprecate("steam", "the bridge only supports the legacy steam chat")
 }
@@ -7,5 +7,10 @@ import (
 )
 
 func init() {
-	FullMap["telegram"] = btelegram.New
+	Register("telegram", btelegram.New)
+	UserTypingSupport["telegram"] = struct{}{}
+	ReplySupport["telegram"] = struct{}{}
+	ReactionSupport["telegram"] = struct{}{}
+	MediaReaderSupport["telegram"] = struct{}{}
+	FileUploadSupport["telegram"] = struct{}{}
 }
@@ -1,4 +1,4 @@
-// +build !novk
+// +build !novk,!minimal
 
 package bridgemap
 
@@ -7,5 +7,6 @@ import (
 )
 
 func init() {
-	FullMap["vk"] = bvk.New
+	Register("vk", bvk.New)
+	FileUploadSupport["vk"] = struct{}{}
 }
@@ -1,5 +1,4 @@
-// +build !nowhatsapp
-// +build !whatsappmulti
+// +build whatsappmulti,!nowhatsapp
 
 package bridgemap
 
@@ -8,5 +7,7 @@ import (
 )
 
 func init() {
-	FullMap["whatsapp"] = bwhatsapp.New
+	Register("whatsapp", bwhatsapp.New)
+	MediaReaderSupport["whatsapp"] = struct{}{}
+	FileUploadSupport["whatsapp"] = struct{}{}
 }
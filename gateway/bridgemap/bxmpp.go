@@ -7,5 +7,7 @@ import (
 )
 
 func init() {
-	FullMap["xmpp"] = bxmpp.New
+	Register("xmpp", bxmpp.New)
+	UserTypingSupport["xmpp"] = struct{}{}
+	FileUploadSupport["xmpp"] = struct{}{}
 }
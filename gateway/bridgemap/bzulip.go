@@ -1,4 +1,4 @@
-// +build !nozulip
+// +build !nozulip,!minimal
 
 package bridgemap
 
@@ -7,5 +7,5 @@ import (
 )
 
 func init() {
-	FullMap["zulip"] = bzulip.New
+	Register("zulip", bzulip.New)
 }
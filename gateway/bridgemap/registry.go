@@ -0,0 +1,35 @@
+package bridgemap
+
+import (
+	"sort"
+
+	"github.com/42wim/matterbridge/bridge"
+)
+
+// Register adds the factory of the bridges of protocol to FullMap.
+//
+// The bridges of matterbridge register themselves in an init function of a file behind the
+// no<protocol> build tag, the niche ones also behind the minimal tag, so builds can leave out
+// the bridges they don't need. Programs embedding matterbridge can register their own bridges
+// the same way before creating the router.
+func Register(protocol string, factory bridge.Factory) {
+	if _, ok := FullMap[protocol]; ok {
+		panic("bridgemap: protocol " + protocol + " registered twice")
+	}
+	FullMap[protocol] = factory
+}
+
+// Deprecate marks protocol as deprecated, the gateway warns with reason when it's used.
+func Deprecate(protocol, reason string) {
+	Deprecated[protocol] = reason
+}
+
+// Protocols returns the sorted protocols of the registered bridges.
+func Protocols() []string {
+	protocols := make([]string, 0, len(FullMap))
+	for protocol := range FullMap {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+	return protocols
+}
@@ -0,0 +1,18 @@
+package bridgemap
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister(t *testing.T) {
+	factory := func(cfg *bridge.Config) bridge.Bridger { return nil }
+	Register("testproto", factory)
+	defer delete(FullMap, "testproto")
+
+	assert.Contains(t, Protocols(), "testproto")
+	assert.Panics(t, func() { Register("testproto", factory) })
+	assert.IsIncreasing(t, Protocols())
+}
@@ -0,0 +1,37 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const defaultCallFormat = "started a {KIND} call in {CALL}: {URL}"
+
+// formatCall turns the EventCallStarted msg into an action announcing the call, formatted
+// with the CallFormat of dest, for the bridges that have no calls of their own.
+func formatCall(msg *config.Message, dest *bridge.Bridge) {
+	if msg.Event != config.EventCallStarted {
+		return
+	}
+	format := dest.GetString("CallFormat")
+	if format == "" {
+		format = defaultCallFormat
+	}
+	kind, url := "voice", ""
+	if msg.Extra != nil {
+		if len(msg.Extra[config.ExtraCallVideo]) > 0 {
+			kind = "video"
+		}
+		if v := msg.Extra[config.ExtraCallURL]; len(v) > 0 {
+			url, _ = v[0].(string)
+		}
+	}
+	text := strings.NewReplacer("{KIND}", kind, "{CALL}", msg.Text, "{URL}", url).Replace(format)
+	if url == "" {
+		text = strings.TrimSuffix(strings.TrimSpace(text), ":")
+	}
+	msg.Text = text
+	msg.Event = config.EventUserAction
+}
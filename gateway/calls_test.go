@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigCalls = []byte(`
+[discord.test]
+server=""
+[matrix.test]
+server=""
+ShowCalls=true
+[irc.freenode]
+server=""
+ShowCalls=true
+CallFormat="is in {CALL} ({KIND}) {URL}"
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account = "matrix.test"
+    channel = "#room"
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "general"
+`)
+
+func TestCalls(t *testing.T) {
+	gw := maketestRouter(testconfigCalls).Gateways["bridge1"]
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+
+	// the discord voice channels are for the whole bridge
+	gw.relayMessage(&config.Message{
+		Event: config.EventCallStarted, Text: "Voice", Username: "wim", UserID: "1", Account: "discord.test", Protocol: "discord",
+		Gateway: "bridge1", Extra: map[string][]interface{}{config.ExtraCallURL: {"https://discord.com/channels/1/2"}},
+	})
+	if assert.Len(t, recorders["matrix.test"].sent, 1) {
+		msg := recorders["matrix.test"].sent[0]
+		assert.Equal(t, config.EventUserAction, msg.Event)
+		assert.Equal(t, "started a voice call in Voice: https://discord.com/channels/1/2", msg.Text)
+		assert.Equal(t, "#room", msg.Channel)
+	}
+	if assert.Len(t, recorders["irc.freenode"].sent, 1) {
+		assert.Equal(t, "is in Voice (voice) https://discord.com/channels/1/2", recorders["irc.freenode"].sent[0].Text)
+	}
+	// only to the bridges with ShowCalls
+	assert.Empty(t, recorders["slack.test"].sent)
+	assert.Empty(t, recorders["discord.test"].sent)
+
+	gw.relayMessage(&config.Message{
+		Event: config.EventCallStarted, Text: "#room", Username: "alice", Channel: "#room", Account: "matrix.test", Protocol: "matrix",
+		Gateway: "bridge1", Extra: map[string][]interface{}{config.ExtraCallVideo: {true}},
+	})
+	if assert.Len(t, recorders["irc.freenode"].sent, 2) {
+		assert.Equal(t, "is in #room (video)", recorders["irc.freenode"].sent[1].Text)
+	}
+	assert.Empty(t, recorders["discord.test"].sent)
+}
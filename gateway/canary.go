@@ -0,0 +1,243 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const (
+	canaryText = "matterbridge canary"
+
+	defaultTestCommandTimeout = 10 * time.Second
+)
+
+// canaryRe matches the canaries, also the echoes received after the report.
+var canaryRe = regexp.MustCompile(canaryText + ` ([0-9a-f]{8})\b`)
+
+// canaries are the "!mb test" messages waiting for their echo, by token.
+type canaries struct {
+	sync.Mutex
+	pending map[string]*canary
+}
+
+func newCanaries() *canaries {
+	return &canaries{pending: make(map[string]*canary)}
+}
+
+// canary is a test message sent to the destinations of a channel.
+type canary struct {
+	token   string
+	results map[string]*canaryResult // by channel ID of the destination
+	sent    bool                     // all the destinations were sent to
+	echoed  chan struct{}            // closed when all the destinations echoed it
+}
+
+type canaryResult struct {
+	account, channel string
+	sent             time.Time
+	took             time.Duration // until the bridge returned
+	id               string        // returned by the bridge
+	err              error
+	roundTrip        time.Duration // until it was received back, zero without echo
+}
+
+func (r *Router) testCommandTimeout() time.Duration {
+	if timeout := r.BridgeValues().General.TestCommandTimeout; timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	return defaultTestCommandTimeout
+}
+
+// isTestCommandUser returns true if the sender of msg may use the test command.
+// TestCommandUsers entries are account:userid or account:username.
+func (r *Router) isTestCommandUser(msg *config.Message) bool {
+	for _, user := range r.BridgeValues().General.TestCommandUsers {
+		if user == msg.Account+":"+msg.UserID || user == msg.Account+":"+msg.Username {
+			return true
+		}
+	}
+	return false
+}
+
+// handleTestCommand handles "!mb test", which sends a canary message to the destinations
+// of the channel and replies which of them confirmed it. Returns true if msg was the
+// command, it isn't relayed.
+func (r *Router) handleTestCommand(msg *config.Message) bool {
+	if msg.Event != "" || len(r.BridgeValues().General.TestCommandUsers) == 0 {
+		return false
+	}
+	fields := strings.Fields(msg.Text)
+	if len(fields) != 2 || fields[0] != r.linkCommandPrefix() || fields[1] != "test" {
+		return false
+	}
+	if !r.isTestCommandUser(msg) {
+		r.logger.Warnf("test command from unauthorized user %s (%s)", msg.Username, msg.Account)
+		return true
+	}
+	var gws []*Gateway
+	for _, gw := range r.gateways() {
+		if _, ok := gw.Channels[getChannelID(msg)]; ok {
+			gws = append(gws, gw)
+		}
+	}
+	if len(gws) == 0 {
+		r.reply(msg, fmt.Sprintf("channel %s isn't bridged", msg.Channel))
+		return true
+	}
+	sort.Slice(gws, func(i, j int) bool { return gws[i].Name < gws[j].Name })
+	token, err := newCanaryToken()
+	if err != nil {
+		r.logger.Errorf("test command: %s", err)
+		return true
+	}
+	// sending takes as long as the slowest bridge, don't hold up handleReceive
+	go r.runCanary(*msg, gws, token)
+	return true
+}
+
+// runCanary sends a canary with token from the channel of msg through gws and replies with
+// the results once all destinations echoed it or after TestCommandTimeout.
+func (r *Router) runCanary(msg config.Message, gws []*Gateway, token string) {
+	c := &canary{token: token, results: make(map[string]*canaryResult), echoed: make(chan struct{})}
+	r.canaries.Lock()
+	r.canaries.pending[token] = c
+	r.canaries.Unlock()
+	defer func() {
+		r.canaries.Lock()
+		delete(r.canaries.pending, token)
+		r.canaries.Unlock()
+	}()
+
+	cmsg := config.Message{
+		Text:      canaryText + " " + token,
+		Channel:   msg.Channel,
+		Account:   msg.Account,
+		Username:  msg.Username,
+		UserID:    msg.UserID,
+		Protocol:  r.getBridge(msg.Account).Protocol,
+		Timestamp: time.Now(),
+	}
+	for _, gw := range gws {
+		cmsg.Gateway = gw.Name
+		for _, dest := range gw.Bridges {
+			if dest.Account == msg.Account || gw.loadBalancer(dest.Account) != nil {
+				continue
+			}
+			for _, channel := range gw.getDestChannel(&cmsg, *dest) {
+				channel := channel
+				res := &canaryResult{account: dest.Account, channel: channel.Name, sent: time.Now()}
+				r.canaries.Lock()
+				c.results[channel.ID] = res
+				r.canaries.Unlock()
+				id, err := gw.SendMessage(&cmsg, dest, &channel, "")
+				r.canaries.Lock()
+				res.took, res.id, res.err = time.Since(res.sent), id, err
+				r.canaries.Unlock()
+			}
+		}
+	}
+
+	r.canaries.Lock()
+	c.sent = true
+	waiting := len(c.results) > 0 && !c.allEchoed()
+	r.canaries.Unlock()
+	if waiting {
+		select {
+		case <-c.echoed:
+		case <-time.After(r.testCommandTimeout()):
+		}
+	}
+	r.canaries.Lock()
+	report := c.report()
+	r.canaries.Unlock()
+	r.reply(&msg, report)
+}
+
+// handleCanaryEcho records that a canary was received back on the bridge it was sent to.
+// Returns true if msg is a canary, these aren't relayed.
+func (r *Router) handleCanaryEcho(msg *config.Message) bool {
+	if msg.Event != "" {
+		return false
+	}
+	m := canaryRe.FindStringSubmatch(msg.Text)
+	if m == nil {
+		return false
+	}
+	r.canaries.Lock()
+	defer r.canaries.Unlock()
+	c, ok := r.canaries.pending[m[1]]
+	if !ok {
+		r.logger.Debugf("ignoring canary %s from %s, it isn't pending", m[1], msg.Account)
+		return true
+	}
+	if res, ok := c.results[getChannelID(msg)]; ok && res.roundTrip == 0 && res.err == nil {
+		res.roundTrip = time.Since(res.sent)
+		if c.sent && c.allEchoed() {
+			close(c.echoed)
+		}
+	}
+	return true
+}
+
+// allEchoed returns true when all the destinations the canary was sent to echoed it, the
+// canaries must be locked.
+func (c *canary) allEchoed() bool {
+	for _, res := range c.results {
+		if res.roundTrip == 0 && res.err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// report returns the results of the canary, the canaries must be locked.
+func (c *canary) report() string {
+	if len(c.results) == 0 {
+		return "this channel doesn't send to any other channel"
+	}
+	results := make([]*canaryResult, 0, len(c.results))
+	for _, res := range c.results {
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].account != results[j].account {
+			return results[i].account < results[j].account
+		}
+		return results[i].channel < results[j].channel
+	})
+	var sb strings.Builder
+	confirmed := 0
+	for _, res := range results {
+		fmt.Fprintf(&sb, "- %s %s: ", res.account, res.channel)
+		switch {
+		case res.err != nil:
+			fmt.Fprintf(&sb, "failed: %s\n", res.err)
+			continue
+		case res.roundTrip > 0:
+			fmt.Fprintf(&sb, "delivered, echoed after %s\n", res.roundTrip.Round(time.Millisecond))
+		case res.id != "":
+			fmt.Fprintf(&sb, "delivered in %s, confirmed by the API (message %s)\n", res.took.Round(time.Millisecond), res.id)
+		default:
+			fmt.Fprintf(&sb, "sent in %s, not confirmed\n", res.took.Round(time.Millisecond))
+			continue
+		}
+		confirmed++
+	}
+	return fmt.Sprintf("canary %s: %d of %d destinations confirmed delivery\n%s", c.token, confirmed, len(results), strings.TrimSuffix(sb.String(), "\n"))
+}
+
+func newCanaryToken() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigCanary = []byte(`
+[general]
+TestCommandUsers=["discord.test:42"]
+TestCommandTimeout=1
+
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "general"
+	`)
+
+func TestTestCommand(t *testing.T) {
+	r := maketestRouter(testconfigCanary)
+	bridgers := make(map[string]*chanBridger)
+	for account, br := range r.Gateways["bridge1"].Bridges {
+		bridgers[account] = &chanBridger{sent: make(chan config.Message, 10)}
+		br.Bridger = bridgers[account]
+	}
+	receive := func(account string) config.Message {
+		select {
+		case msg := <-bridgers[account].sent:
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatalf("nothing sent to %s", account)
+		}
+		return config.Message{}
+	}
+
+	assert.False(t, r.handleTestCommand(&config.Message{Text: "!mb testing", UserID: "42", Channel: "general", Account: "discord.test"}))
+	// unauthorized users are ignored
+	assert.True(t, r.handleTestCommand(&config.Message{Text: "!mb test", UserID: "1", Channel: "general", Account: "discord.test"}))
+	assert.Empty(t, bridgers["irc.freenode"].sent)
+
+	assert.True(t, r.handleTestCommand(&config.Message{Text: "!mb test", UserID: "42", Channel: "general", Account: "discord.test"}))
+	canary := receive("irc.freenode")
+	require.Regexp(t, canaryRe, canary.Text)
+	assert.Equal(t, "#wimtesting", canary.Channel)
+	assert.Equal(t, canary.Text, receive("slack.test").Text)
+
+	// irc echoes the canary, it isn't relayed
+	echo := config.Message{Text: canary.Text, Channel: "#wimtesting", Account: "irc.freenode"}
+	assert.True(t, r.handleCanaryEcho(&echo))
+	assert.False(t, r.handleCanaryEcho(&config.Message{Text: "hello", Channel: "#wimtesting", Account: "irc.freenode"}))
+
+	report := receive("discord.test")
+	assert.Equal(t, "general", report.Channel)
+	assert.Contains(t, report.Text, ": 2 of 2 destinations confirmed delivery\n")
+	assert.Regexp(t, `- irc.freenode #wimtesting: delivered, echoed after \d+m?s`, report.Text)
+	assert.Regexp(t, `- slack.test general: delivered in \d+m?s, confirmed by the API \(message 1\)`, report.Text)
+
+	// echoes received after the report aren't relayed either
+	assert.True(t, r.handleCanaryEcho(&echo))
+}
@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const (
+	// captionEmbed sends the caption with the file, as the bridge does natively: the caption
+	// of the upload, the content of the embed or "caption: url".
+	captionEmbed = "embed"
+	// captionMessage sends the captions as a message of their own before the files.
+	captionMessage = "message"
+	// captionDrop doesn't send the captions, the files get captionDropped instead.
+	captionDrop = "drop"
+
+	captionDropped = "(caption not relayed)"
+)
+
+// setCaptions sets the Caption of the files of msg to their Comment, for the bridges that
+// only set Comment. The gateway uses Caption until the message is sent.
+func setCaptions(msg *config.Message) {
+	if msg.Extra == nil {
+		return
+	}
+	for i, f := range msg.Extra["file"] {
+		if fi, ok := f.(config.FileInfo); ok && fi.Caption == "" && fi.Comment != "" {
+			fi.Caption = fi.Comment
+			msg.Extra["file"][i] = fi
+		}
+	}
+}
+
+func captionMode(dest *bridge.Bridge) string {
+	switch mode := strings.ToLower(dest.GetString("CaptionMode")); mode {
+	case captionMessage, captionDrop:
+		return mode
+	}
+	return captionEmbed
+}
+
+// renderCaptions sets the Comment of the files of msg, as sent to dest, from their Caption
+// according to the CaptionMode of dest. Returns the text to send before the files with
+// CaptionMode "message".
+func (gw *Gateway) renderCaptions(msg *config.Message, dest *bridge.Bridge) string {
+	if msg.Extra == nil || len(msg.Extra["file"]) == 0 {
+		return ""
+	}
+	mode := captionMode(dest)
+	cloneFiles(msg)
+	var captions []string
+	for i, f := range msg.Extra["file"] {
+		if fi, ok := f.(config.FileInfo); ok && !fi.Avatar {
+			caption := fi.CaptionText()
+			switch {
+			case caption == "":
+				fi.Comment = ""
+			case mode == captionMessage:
+				fi.Comment = ""
+				if len(captions) == 0 || captions[len(captions)-1] != caption {
+					captions = append(captions, caption)
+				}
+			case mode == captionDrop:
+				fi.Comment = captionDropped
+			default:
+				fi.Comment = caption
+			}
+			msg.Extra["file"][i] = fi
+		}
+	}
+	return strings.Join(captions, "\n")
+}
+
+// sendCaptions sends the captions of msg, rendered by renderCaptions, to the channel of msg
+// on dest before its files.
+func (gw *Gateway) sendCaptions(msg *config.Message, dest *bridge.Bridge, captions string) {
+	cmsg := *msg
+	cmsg.Text = captions
+	cmsg.Extra = nil
+	cmsg.ID = ""
+	if _, err := gw.Router.send(dest, cmsg); err != nil {
+		gw.logger.Errorf("failed to send the caption of a file to %s: %s", dest.Account, err)
+	}
+}
@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigCaptions = []byte(`
+[irc.freenode]
+server=""
+CaptionMode="message"
+[discord.test]
+server=""
+[slack.test]
+server=""
+CaptionMode="drop"
+[telegram.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "telegram.test"
+    channel = "-100"
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "general"
+	`)
+
+func TestCaptions(t *testing.T) {
+	r := maketestRouter(testconfigCaptions)
+	gw := r.Gateways["bridge1"]
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+	data := []byte("png")
+	msg := &config.Message{
+		Text: "look", Username: "wim", Channel: "-100", Account: "telegram.test", Protocol: "telegram", Gateway: "bridge1",
+		Extra: map[string][]interface{}{"file": {
+			config.FileInfo{Name: "a.png", Data: &data, Comment: "look", URL: "https://media/a.png"},
+			config.FileInfo{Name: "b.png", Data: &data},
+		}},
+	}
+	setCaptions(msg)
+	assert.Equal(t, "look", msg.Extra["file"][0].(config.FileInfo).Caption)
+	gw.handleMessage(msg, gw.Bridges["irc.freenode"])
+	gw.handleMessage(msg, gw.Bridges["discord.test"])
+	gw.handleMessage(msg, gw.Bridges["slack.test"])
+
+	comments := func(m config.Message) []string {
+		var res []string
+		for _, f := range m.Extra["file"] {
+			res = append(res, f.(config.FileInfo).Comment)
+		}
+		return res
+	}
+	// the caption is sent before the files
+	irc := recorders["irc.freenode"].sent
+	require.Len(t, irc, 2)
+	assert.Equal(t, "look", irc[0].Text)
+	assert.Empty(t, irc[0].Extra)
+	assert.Equal(t, []string{"", ""}, comments(irc[1]))
+
+	discord := recorders["discord.test"].sent
+	require.Len(t, discord, 1)
+	assert.Equal(t, []string{"look", ""}, comments(discord[0]))
+
+	slack := recorders["slack.test"].sent
+	require.Len(t, slack, 1)
+	assert.Equal(t, []string{captionDropped, ""}, comments(slack[0]))
+
+	// the files of the message are shared, they're not changed
+	assert.Equal(t, "look", msg.Extra["file"][0].(config.FileInfo).Comment)
+}
@@ -0,0 +1,42 @@
+package gateway
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// errChaos is returned for the sends failed on purpose in ChaosMode.
+var errChaos = errors.New("chaos: injected send error")
+
+// sendChaos sends msg with dest. In ChaosMode the send is first delayed by up to ChaosDelay
+// milliseconds, then dropped (it seems sent but isn't) for ChaosDropPercent of the messages
+// and failed with errChaos for ChaosErrorPercent of them, to test the retries, the offline
+// queue and the circuit breakers without a platform that's actually down.
+func (r *Router) sendChaos(dest *bridge.Bridge, msg config.Message) (string, error) {
+	if !r.BridgeValues().General.ChaosMode {
+		return dest.Send(msg)
+	}
+	if delay := dest.GetInt("ChaosDelay"); delay > 0 {
+		time.Sleep(time.Duration(rand.Intn(delay+1)) * time.Millisecond) //nolint:gosec
+	}
+	n := rand.Intn(100) //nolint:gosec
+	if drop := dest.GetInt("ChaosDropPercent"); n < drop {
+		r.logger.Debugf("chaos: dropping message to %s", dest.Account)
+		return "", nil
+	} else if n < drop+dest.GetInt("ChaosErrorPercent") {
+		r.logger.Debugf("chaos: failing message to %s", dest.Account)
+		return "", errChaos
+	}
+	return dest.Send(msg)
+}
+
+// warnChaos warns that ChaosMode is on, it's for testing matterbridge only.
+func (r *Router) warnChaos() {
+	if r.BridgeValues().General.ChaosMode {
+		r.logger.Warnf("ChaosMode is on, messages to the bridges are delayed, dropped and failed on purpose")
+	}
+}
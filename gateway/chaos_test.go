@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigChaos = []byte(`
+[general]
+ChaosMode=true
+
+[irc.freenode]
+server=""
+ChaosDropPercent=100
+[discord.test]
+server=""
+ChaosErrorPercent=100
+BreakerThreshold=2
+[slack.test]
+server=""
+ChaosDelay=50
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "general"
+	`)
+
+func TestChaos(t *testing.T) {
+	r := maketestRouter(testconfigChaos)
+	gw := r.Gateways["bridge1"]
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+	msg := config.Message{Text: "hi"}
+
+	// dropped messages seem sent
+	mID, err := r.send(gw.Bridges["irc.freenode"], msg)
+	assert.NoError(t, err)
+	assert.Empty(t, mID)
+	assert.Empty(t, recorders["irc.freenode"].sent)
+
+	// injected errors open the circuit breaker like real ones
+	_, err = r.send(gw.Bridges["discord.test"], msg)
+	assert.Equal(t, errChaos, err)
+	_, err = r.send(gw.Bridges["discord.test"], msg)
+	assert.Equal(t, errChaos, err)
+	<-r.Message
+	assert.True(t, r.breakerOpen("discord.test"))
+	assert.Empty(t, recorders["discord.test"].sent)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		mID, err = r.send(gw.Bridges["slack.test"], msg)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, mID)
+	}
+	assert.Less(t, time.Since(start), 5*60*time.Millisecond)
+	assert.Len(t, recorders["slack.test"].sent, 5)
+
+	// without ChaosMode the settings aren't used
+	r.BridgeValues().General.ChaosMode = false
+	_, err = r.send(gw.Bridges["irc.freenode"], msg)
+	assert.NoError(t, err)
+	assert.Len(t, recorders["irc.freenode"].sent, 1)
+}
@@ -0,0 +1,89 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// Message classes, used to pick a per class RemoteNickFormat or MessageTemplate
+// from the Format.<class> table of the destination.
+const (
+	classNormal = "normal"
+	classAction = "action"
+	classSystem = "system"
+	classBot    = "bot"
+	classNotice = "notice"
+	classMedia  = "media"
+)
+
+// classify returns the class of msg.
+func classify(msg *config.Message) string {
+	switch {
+	case msg.Event == config.EventUserAction:
+		return classAction
+	case msg.Event == config.EventNoticeIRC:
+		return classNotice
+	case config.IsJoinLeave(msg.Event) || msg.Event == config.EventTopicChange || msg.Username == "system":
+		return classSystem
+	case msg.Extra != nil && len(msg.Extra[config.ExtraBot]) > 0:
+		return classBot
+	case strings.TrimSpace(msg.Text) == "" && msg.Extra != nil && len(msg.Extra["file"]) > 0:
+		return classMedia
+	}
+	return classNormal
+}
+
+// classSetting returns the setting key of dest for the class of msg,
+// falling back to the generic setting when there's none for the class.
+func (gw *Gateway) classSetting(msg *config.Message, dest *bridge.Bridge, key string) string {
+	if class := classify(msg); class != classNormal {
+		// an empty setting for the class is valid, eg to send actions without a nick
+		classKey := "Format." + class + "." + key
+		if val, ok := dest.Config.GetString(dest.GetConfigKey(classKey)); ok {
+			return val
+		}
+		if val, ok := dest.Config.GetString("general." + classKey); ok {
+			return val
+		}
+	}
+	return dest.GetString(key)
+}
+
+// templatedEvents are the events with a text MessageTemplate applies to.
+var templatedEvents = map[string]bool{
+	"":                      true,
+	config.EventUserAction:  true,
+	config.EventNoticeIRC:   true,
+	config.EventJoinLeave:   true,
+	config.EventJoin:        true,
+	config.EventPart:        true,
+	config.EventQuit:        true,
+	config.EventKick:        true,
+	config.EventBan:         true,
+	config.EventTopicChange: true,
+}
+
+// applyMessageTemplate returns the text of msg formatted with the MessageTemplate of dest.
+// rmsg is the original message, msg the copy for dest.
+func (gw *Gateway) applyMessageTemplate(rmsg, msg *config.Message, dest *bridge.Bridge) string {
+	if !templatedEvents[msg.Event] || msg.Text == "" {
+		return msg.Text
+	}
+	tmpl := gw.classSetting(rmsg, dest, "MessageTemplate")
+	if tmpl == "" {
+		return msg.Text
+	}
+	br := gw.Bridges[rmsg.Account]
+	return strings.NewReplacer(
+		"{TEXT}", msg.Text,
+		"{NICK}", rmsg.Username,
+		"{PROTOCOL}", br.Protocol,
+		"{BRIDGE}", br.Name,
+		"{CHANNEL}", rmsg.Channel,
+		"{CLASS}", classify(rmsg),
+		"{TIMESTAMP}", gw.formatTimestamp(rmsg, dest),
+		"{PERMALINK}", gw.sourcePermalink(rmsg),
+	).Replace(tmpl)
+}
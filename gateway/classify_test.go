@@ -0,0 +1,75 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigFormat = []byte(`
+[irc.freenode]
+server=""
+RemoteNickFormat="<{NICK}> "
+[irc.freenode.format.bot]
+RemoteNickFormat="[bot] <{NICK}> "
+[irc.freenode.format.action]
+RemoteNickFormat=""
+MessageTemplate="* {NICK} {TEXT}"
+[discord.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+	`)
+
+func TestClassify(t *testing.T) {
+	file := map[string][]interface{}{"file": {config.FileInfo{Name: "a.png"}}}
+	for class, msg := range map[string]*config.Message{
+		classNormal: {Text: "hi"},
+		classAction: {Text: "waves", Event: config.EventUserAction},
+		classNotice: {Text: "hi", Event: config.EventNoticeIRC},
+		classSystem: {Text: "topic", Event: config.EventTopicChange},
+		classBot:    {Text: "hi", Extra: map[string][]interface{}{config.ExtraBot: {true}}},
+		classMedia:  {Extra: file},
+	} {
+		assert.Equal(t, class, classify(msg), msg.Text)
+	}
+	assert.Equal(t, classNormal, classify(&config.Message{Text: "look", Extra: file}))
+}
+
+func TestClassFormat(t *testing.T) {
+	r := maketestRouter(testconfigFormat)
+	gw := r.Gateways["bridge1"]
+	recorder := &recordBridger{}
+	gw.Bridges["irc.freenode"].Bridger = recorder
+	dest := gw.Bridges["irc.freenode"]
+	channel := gw.Channels["#wimtestingirc.freenode"]
+
+	send := func(msg config.Message) config.Message {
+		msg.Channel, msg.Account, msg.Protocol, msg.Gateway = "general", "discord.test", "discord", "bridge1"
+		_, err := gw.SendMessage(&msg, dest, channel, "")
+		assert.NoError(t, err)
+		return recorder.sent[len(recorder.sent)-1]
+	}
+
+	msg := send(config.Message{Text: "hi", Username: "wim"})
+	assert.Equal(t, "<wim> ", msg.Username)
+	assert.Equal(t, "hi", msg.Text)
+
+	msg = send(config.Message{Text: "hi", Username: "hook", Extra: map[string][]interface{}{config.ExtraBot: {true}}})
+	assert.Equal(t, "[bot] <hook> ", msg.Username)
+
+	msg = send(config.Message{Text: "waves", Username: "wim", Event: config.EventUserAction})
+	assert.Equal(t, "", msg.Username)
+	assert.Equal(t, "* wim waves", msg.Text)
+}
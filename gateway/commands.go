@@ -0,0 +1,107 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// RunCommand implements bridge.Commands. The command status shows the bridges of the
+// gateways of the channel and whether they're sending, who shows the users in the other
+// channels of the gateways, for the bridges that know them.
+func (r *Router) RunCommand(account, channel, cmd string) (string, error) {
+	channelID := gatewayChannelID(account, channel)
+	var gws []*Gateway
+	for _, gw := range r.gateways() {
+		if _, ok := gw.Channels[channelID]; ok {
+			gws = append(gws, gw)
+		}
+	}
+	if len(gws) == 0 {
+		return "", fmt.Errorf("channel %s isn't bridged", channel)
+	}
+	sort.Slice(gws, func(i, j int) bool { return gws[i].Name < gws[j].Name })
+
+	switch cmd {
+	case "status":
+		return r.commandStatus(gws), nil
+	case "who":
+		return commandWho(gws, channelID), nil
+	}
+	return "", fmt.Errorf("unknown command %s", cmd)
+}
+
+func (r *Router) commandStatus(gws []*Gateway) string {
+	var sb strings.Builder
+	for _, gw := range gws {
+		fmt.Fprintf(&sb, "%s:\n", gw.Name)
+		for _, ch := range sortedChannels(gw) {
+			status := "ok"
+			if r.breakerOpen(ch.Account) {
+				status = "not sending, circuit breaker open"
+			}
+			fmt.Fprintf(&sb, "- %s %s (%s): %s\n", ch.Account, ch.Name, ch.Direction, status)
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func commandWho(gws []*Gateway, channelID string) string {
+	var sb strings.Builder
+	seen := make(map[string]bool)
+	for _, gw := range gws {
+		for _, ch := range sortedChannels(gw) {
+			if ch.ID == channelID || seen[ch.ID] {
+				continue
+			}
+			seen[ch.ID] = true
+			lister, ok := gw.Bridges[ch.Account].Bridger.(bridge.UserLister)
+			if !ok {
+				continue
+			}
+			users, err := lister.ChannelUsers(ch.Name)
+			if err != nil {
+				fmt.Fprintf(&sb, "%s %s: unknown (%s)\n", ch.Account, ch.Name, err)
+				continue
+			}
+			sort.Strings(users)
+			fmt.Fprintf(&sb, "%s %s (%d): %s\n", ch.Account, ch.Name, len(users), strings.Join(users, ", "))
+		}
+	}
+	if sb.Len() == 0 {
+		return "The users of the other bridged channels aren't known"
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// sortedChannels returns the channels of gw sorted by account and name.
+func sortedChannels(gw *Gateway) []*config.ChannelInfo {
+	channels := make([]*config.ChannelInfo, 0, len(gw.Channels))
+	for _, ch := range gw.Channels {
+		channels = append(channels, ch)
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		if channels[i].Account != channels[j].Account {
+			return channels[i].Account < channels[j].Account
+		}
+		return channels[i].Name < channels[j].Name
+	})
+	return channels
+}
+
+// breakerOpen returns true when the circuit breaker of account is open.
+func (r *Router) breakerOpen(account string) bool {
+	r.breakersMu.Lock()
+	b, ok := r.breakers[account]
+	r.breakersMu.Unlock()
+	if !ok {
+		return false
+	}
+	b.Lock()
+	defer b.Unlock()
+	return !b.openUntil.IsZero() && time.Now().Before(b.openUntil)
+}
@@ -0,0 +1,44 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// listBridger is a Bridger that knows the users of its channels.
+type listBridger struct {
+	recordBridger
+	users []string
+}
+
+func (b *listBridger) ChannelUsers(channel string) ([]string, error) {
+	return b.users, nil
+}
+
+func TestRunCommand(t *testing.T) {
+	r := maketestRouter(testconfig)
+	r.Gateways["bridge1"].Bridges["irc.freenode"].Bridger = &listBridger{users: []string{"wim", "alice"}}
+
+	reply, err := r.RunCommand("discord.test", "general", "who")
+	require.NoError(t, err)
+	assert.Equal(t, "irc.freenode #wimtesting (2): alice, wim", reply)
+
+	// the users of the own channel aren't listed
+	reply, err = r.RunCommand("irc.freenode", "#wimtesting", "who")
+	require.NoError(t, err)
+	assert.Equal(t, "The users of the other bridged channels aren't known", reply)
+
+	r.breaker("slack.test").openUntil = time.Now().Add(time.Minute)
+	reply, err = r.RunCommand("discord.test", "general", "status")
+	require.NoError(t, err)
+	assert.Contains(t, reply, "bridge1:\n- discord.test general (inout): ok\n")
+	assert.Contains(t, reply, "- slack.test testing (inout): not sending, circuit breaker open")
+
+	_, err = r.RunCommand("discord.test", "general", "restart")
+	assert.Error(t, err)
+	_, err = r.RunCommand("discord.test", "offtopic", "who")
+	assert.Error(t, err)
+}
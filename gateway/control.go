@@ -0,0 +1,220 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/gateway/events"
+)
+
+var errBridgeDisabled = errors.New("bridge is disabled")
+
+// controls is the state of the bridges shown and changed by the admin API.
+type controls struct {
+	sync.Mutex
+	connected map[string]bool // by account
+	disabled  map[string]bool // by account, disabled on the admin API
+}
+
+func newControls() *controls {
+	return &controls{connected: make(map[string]bool), disabled: make(map[string]bool)}
+}
+
+func (c *controls) handle(ev events.Event) {
+	c.Lock()
+	defer c.Unlock()
+	c.connected[ev.Account] = ev.Kind == events.BridgeConnected
+}
+
+// isDisabled returns true if account was disabled on the admin API, nothing is sent to it
+// and its messages aren't relayed until it's enabled again.
+func (r *Router) isDisabled(account string) bool {
+	r.controls.Lock()
+	defer r.controls.Unlock()
+	return r.controls.disabled[account]
+}
+
+// SetDisabled makes the router disable the bridge of account, or enable it again. Like
+// Reload it's done by the goroutine routing the messages, which owns the bridges.
+func (r *Router) SetDisabled(account string, disabled bool) {
+	event := config.EventBridgeEnable
+	if disabled {
+		event = config.EventBridgeDisable
+	}
+	r.Message <- config.Message{Event: event, Text: account}
+}
+
+func (r *Router) handleEventBridgeControl(msg *config.Message) bool {
+	if (msg.Event != config.EventBridgeEnable && msg.Event != config.EventBridgeDisable) || msg.Account != "" {
+		return false
+	}
+	if err := r.setDisabled(msg.Text, msg.Event == config.EventBridgeDisable); err != nil {
+		r.logger.Errorf("admin: %s", err)
+	}
+	return true
+}
+
+// setDisabled disconnects the bridge of account when disabled, or connects it again.
+func (r *Router) setDisabled(account string, disabled bool) error {
+	br := r.getBridge(account)
+	if br == nil || br.Bridger == nil {
+		return fmt.Errorf("unknown account %s", account)
+	}
+	r.controls.Lock()
+	if r.controls.disabled[account] == disabled {
+		r.controls.Unlock()
+		return nil
+	}
+	r.controls.disabled[account] = disabled
+	r.controls.Unlock()
+
+	if disabled {
+		r.logger.Infof("admin: disabling %s", account)
+		if err := br.Disconnect(); err != nil {
+			r.logger.Errorf("Disconnect() %s failed: %s", account, err)
+		}
+		r.Events.Publish(events.Event{Kind: events.BridgeDisconnected, Account: account})
+		return nil
+	}
+	r.logger.Infof("admin: enabling %s", account)
+	return r.connectBridge(br)
+}
+
+// connectBridge connects br again after it was disabled and sends it what was queued.
+func (r *Router) connectBridge(br *bridge.Bridge) error {
+	if err := withBridgeLabel(br, br.Connect); err != nil {
+		return fmt.Errorf("connecting %s failed: %s", br.Account, err)
+	}
+	r.Events.Publish(events.Event{Kind: events.BridgeConnected, Account: br.Account})
+	br.Joined = make(map[string]bool)
+	if err := withBridgeLabel(br, br.JoinChannels); err != nil {
+		return fmt.Errorf("joining the channels of %s failed: %s", br.Account, err)
+	}
+	r.requestReplay(br)
+	r.goBridge(r.backfill, br)
+	return nil
+}
+
+// gatewayStatus is a gateway as served on /api/gateways.
+type gatewayStatus struct {
+	Name    string         `json:"name"`
+	Bridges []bridgeStatus `json:"bridges"`
+}
+
+type bridgeStatus struct {
+	Account   string   `json:"account"`
+	Protocol  string   `json:"protocol"`
+	Connected bool     `json:"connected"`
+	Disabled  bool     `json:"disabled"`
+	Channels  []string `json:"channels"`
+	Queued    int      `json:"queued"` // messages in the offline queue
+}
+
+// gatewayStatuses returns the gateways and their bridges, sorted by name.
+func (r *Router) gatewayStatuses() []gatewayStatus {
+	r.controls.Lock()
+	defer r.controls.Unlock()
+	res := []gatewayStatus{}
+	for _, gw := range r.gateways() {
+		status := gatewayStatus{Name: gw.Name, Bridges: []bridgeStatus{}}
+		for account, br := range gw.Bridges {
+			bs := bridgeStatus{
+				Account:   account,
+				Protocol:  br.Protocol,
+				Connected: r.controls.connected[account],
+				Disabled:  r.controls.disabled[account],
+				Channels:  []string{},
+				Queued:    r.queuedCount(account),
+			}
+			for _, channel := range gw.Channels {
+				if channel.Account == account {
+					bs.Channels = append(bs.Channels, channel.Name)
+				}
+			}
+			sort.Strings(bs.Channels)
+			status.Bridges = append(status.Bridges, bs)
+		}
+		sort.Slice(status.Bridges, func(i, j int) bool { return status.Bridges[i].Account < status.Bridges[j].Account })
+		res = append(res, status)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+func (r *Router) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		r.logger.Errorf("admin: failed to write response: %s", err)
+	}
+}
+
+func (r *Router) handleGateways(w http.ResponseWriter, req *http.Request) {
+	r.writeJSON(w, http.StatusOK, r.gatewayStatuses())
+}
+
+// handleBridgeControl handles POST /api/bridges/disable?account= and
+// /api/bridges/enable?account=.
+func (r *Router) handleBridgeControl(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		account := req.URL.Query().Get("account")
+		if account == "" {
+			http.Error(w, "account is required", http.StatusBadRequest)
+			return
+		}
+		if !hasAccountConfig(r.Config, account) {
+			http.Error(w, "unknown account "+account, http.StatusBadRequest)
+			return
+		}
+		go r.SetDisabled(account, disabled)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// handleFlushQueues handles POST /api/queues/flush?account=, which replays the offline
+// queue of account now, or of all the bridges without account. Returns the number of
+// queued messages by account.
+func (r *Router) handleFlushQueues(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	account := req.URL.Query().Get("account")
+	if account != "" && r.getBridge(account) == nil {
+		http.Error(w, "unknown account "+account, http.StatusBadRequest)
+		return
+	}
+	queued := make(map[string]int)
+	for _, gw := range r.gateways() {
+		for _, br := range gw.Bridges {
+			if _, ok := queued[br.Account]; ok || br.Bridger == nil || (account != "" && br.Account != account) {
+				continue
+			}
+			queued[br.Account] = r.queuedCount(br.Account)
+			if queued[br.Account] > 0 {
+				r.requestReplay(br)
+			}
+		}
+	}
+	r.writeJSON(w, http.StatusAccepted, queued)
+}
+
+func (r *Router) handleReload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	r.logger.Infof("admin: reloading the configuration")
+	go r.Reload()
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,96 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/gateway/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func adminPost(h http.Handler, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// handleControl handles the enable or disable event the admin API sent to the router.
+func handleControl(t *testing.T, r *Router, event string) {
+	select {
+	case msg := <-r.Message:
+		require.Equal(t, event, msg.Event)
+		assert.Equal(t, "irc.freenode", msg.Text)
+		assert.True(t, r.handleEventBridgeControl(&msg))
+	case <-time.After(time.Second):
+		t.Fatal("no " + event)
+	}
+}
+
+func TestAdminControl(t *testing.T) {
+	r := maketestRouter(testconfigAdmin)
+	gw := r.Gateways["bridge1"]
+	rec := &recordBridger{}
+	gw.Bridges["irc.freenode"].Bridger = rec
+	h := r.adminHandler()
+
+	r.Events.Publish(events.Event{Kind: events.BridgeConnected, Account: "irc.freenode"})
+	assert.Eventually(t, func() bool {
+		r.controls.Lock()
+		defer r.controls.Unlock()
+		return r.controls.connected["irc.freenode"]
+	}, time.Second, 10*time.Millisecond)
+
+	resp := adminRequest(h, "/api/gateways", "secret")
+	require.Equal(t, http.StatusOK, resp.Code)
+	var gateways []gatewayStatus
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &gateways))
+	require.Len(t, gateways, 1)
+	assert.Equal(t, "bridge1", gateways[0].Name)
+	assert.Equal(t, []bridgeStatus{{
+		Account: "irc.freenode", Protocol: "irc", Connected: true, Channels: []string{"#wimtesting"},
+	}}, gateways[0].Bridges)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, adminRequest(h, "/api/bridges/disable?account=irc.freenode", "secret").Code)
+	assert.Equal(t, http.StatusBadRequest, adminPost(h, "/api/bridges/disable?account=irc.unknown").Code)
+	assert.Equal(t, http.StatusAccepted, adminPost(h, "/api/bridges/disable?account=irc.freenode").Code)
+	// the router does it
+	assert.False(t, r.isDisabled("irc.freenode"))
+	handleControl(t, r, config.EventBridgeDisable)
+	assert.True(t, r.isDisabled("irc.freenode"))
+
+	// nothing is sent to a disabled bridge and its messages aren't relayed
+	_, err := r.send(gw.Bridges["irc.freenode"], config.Message{Text: "hi"})
+	assert.Equal(t, errBridgeDisabled, err)
+	assert.Empty(t, rec.sent)
+	assert.True(t, gw.ignoreMessage(&config.Message{Text: "hi", Channel: "#wimtesting", Account: "irc.freenode"}))
+
+	assert.Equal(t, http.StatusAccepted, adminPost(h, "/api/bridges/enable?account=irc.freenode").Code)
+	handleControl(t, r, config.EventBridgeEnable)
+	assert.False(t, r.isDisabled("irc.freenode"))
+	// the bridges can't send it
+	assert.False(t, r.handleEventBridgeControl(&config.Message{Event: config.EventBridgeDisable, Text: "irc.freenode", Account: "api.local"}))
+	_, err = r.send(gw.Bridges["irc.freenode"], config.Message{Text: "hi"})
+	assert.NoError(t, err)
+	// the replay of the offline queue and the backfill started by enabling it
+	r.tasks.Wait()
+
+	resp = adminPost(h, "/api/queues/flush")
+	assert.Equal(t, http.StatusAccepted, resp.Code)
+	assert.JSONEq(t, `{"irc.freenode":0}`, resp.Body.String())
+	assert.Equal(t, http.StatusBadRequest, adminPost(h, "/api/queues/flush?account=irc.unknown").Code)
+
+	assert.Equal(t, http.StatusAccepted, adminPost(h, "/api/reload").Code)
+	select {
+	case msg := <-r.Message:
+		assert.Equal(t, config.EventReloadConfig, msg.Event)
+	case <-time.After(time.Second):
+		t.Fatal("no reload")
+	}
+}
@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+)
+
+const (
+	defaultCredentialsWarnDays = 7
+	credentialsCheckInterval   = time.Hour
+
+	// credentialsExpiring is the bridge status of bridges whose credentials expire soon.
+	credentialsExpiring = "credentials_expiring"
+)
+
+// credentialsStatus is when the credentials of a bridge expire, as served on /api/credentials.
+type credentialsStatus struct {
+	Account  string    `json:"account"`
+	Expires  time.Time `json:"expires"`
+	Expiring bool      `json:"expiring"` // within CredentialsWarnDays
+
+	br *bridge.Bridge
+}
+
+func (r *Router) credentialsWarnTime() time.Duration {
+	days := r.BridgeValues().General.CredentialsWarnDays
+	if days <= 0 {
+		days = defaultCredentialsWarnDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// credentials returns the status of the bridges that know when their credentials expire.
+func (r *Router) credentials() []credentialsStatus {
+	var res []credentialsStatus
+	seen := make(map[string]bool)
+	for _, gw := range r.gateways() {
+		for _, br := range gw.Bridges {
+			if br.Bridger == nil || seen[br.Account] {
+				continue
+			}
+			seen[br.Account] = true
+			ce, ok := br.Bridger.(bridge.CredentialsExpirer)
+			if !ok {
+				continue
+			}
+			expires, ok := ce.CredentialsExpiry()
+			if !ok {
+				continue
+			}
+			res = append(res, credentialsStatus{
+				Account:  br.Account,
+				Expires:  expires,
+				Expiring: time.Until(expires) < r.credentialsWarnTime(),
+				br:       br,
+			})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Account < res[j].Account })
+	return res
+}
+
+// checkCredentials warns in the LoginChannel and with a bridge status event when the
+// credentials of a bridge expire within CredentialsWarnDays, once for every expiry.
+func (r *Router) checkCredentials() {
+	for _, status := range r.credentials() {
+		if !status.Expiring {
+			continue
+		}
+		r.credentialsMu.Lock()
+		warned := r.credentialsWarned[status.Account].Equal(status.Expires)
+		r.credentialsWarned[status.Account] = status.Expires
+		r.credentialsMu.Unlock()
+		if warned {
+			continue
+		}
+		text := fmt.Sprintf("the credentials expire on %s, log in again before", status.Expires.Format(time.RFC1123))
+		if time.Now().After(status.Expires) {
+			text = fmt.Sprintf("the credentials expired on %s, log in again", status.Expires.Format(time.RFC1123))
+		}
+		r.logger.Warnf("%s: %s", status.Account, text)
+		if err := r.LoginNotice(status.Account, text, nil); err != nil && !errors.Is(err, bridge.ErrNoLoginChannel) {
+			r.logger.Errorf("failed to post the credentials warning of %s: %s", status.Account, err)
+		}
+		r.emitBridgeStatus(status.br, credentialsExpiring)
+	}
+}
+
+func (r *Router) watchCredentials() {
+	ticker := time.NewTicker(credentialsCheckInterval)
+	defer ticker.Stop()
+	for {
+		r.checkCredentials()
+		<-ticker.C
+	}
+}
+
+// handleCredentials serves the expiry of the credentials of the bridges that know it.
+func (r *Router) handleCredentials(w http.ResponseWriter, req *http.Request) {
+	res := r.credentials()
+	if res == nil {
+		res = []credentialsStatus{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		r.logger.Errorf("admin: failed to write credentials: %s", err)
+	}
+}
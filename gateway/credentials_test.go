@@ -0,0 +1,60 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expiringBridger is a Bridger whose credentials expire at expires.
+type expiringBridger struct {
+	chanBridger
+	expires time.Time
+}
+
+func (e *expiringBridger) CredentialsExpiry() (time.Time, bool) {
+	return e.expires, !e.expires.IsZero()
+}
+
+func TestCheckCredentials(t *testing.T) {
+	r := maketestRouter(testconfigLogin)
+	gw := r.Gateways["bridge1"]
+	admin := &chanBridger{sent: make(chan config.Message, 10)}
+	gw.Bridges["slack.test"].Bridger = admin
+	steam := &expiringBridger{expires: time.Now().Add(30 * 24 * time.Hour)}
+	gw.Bridges["steam.test"].Bridger = steam
+
+	status := r.credentials()
+	require.Len(t, status, 1)
+	assert.Equal(t, "steam.test", status[0].Account)
+	assert.False(t, status[0].Expiring)
+	r.checkCredentials()
+	assert.Empty(t, admin.sent)
+
+	// warned once for an expiry
+	steam.expires = time.Now().Add(2 * 24 * time.Hour)
+	r.checkCredentials()
+	notice := <-admin.sent
+	assert.Equal(t, "admin", notice.Channel)
+	assert.Contains(t, notice.Text, "[steam.test] the credentials expire on")
+	event := <-r.Message
+	assert.Equal(t, config.EventBridgeStatus, event.Event)
+	assert.Equal(t, credentialsExpiring, event.Text)
+	assert.Equal(t, "steam.test", event.Account)
+	r.checkCredentials()
+	assert.Empty(t, admin.sent)
+
+	rec := httptest.NewRecorder()
+	r.handleCredentials(rec, httptest.NewRequest(http.MethodGet, "/api/credentials", nil))
+	var res []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Len(t, res, 1)
+	assert.Equal(t, "steam.test", res[0]["account"])
+	assert.Equal(t, true, res[0]["expiring"])
+}
@@ -0,0 +1,240 @@
+package gateway
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/gateway/events"
+)
+
+const (
+	// dashboardRecent is the number of recent messages and errors kept for the dashboard.
+	dashboardRecent = 100
+	// dashboardTextLength is the number of characters of the messages shown in the flow.
+	dashboardTextLength = 100
+)
+
+//go:embed dashboard
+var dashboardAssets embed.FS
+
+// dashboardEvents are the events shown on the dashboard.
+var dashboardEvents = []events.Kind{
+	events.MessageRelayed, events.SendFailed, events.MessageDropped, events.MediaUploaded,
+	events.BridgeConnected, events.BridgeDisconnected,
+}
+
+// dashboard keeps what the web dashboard on the admin listener shows, from the events of
+// the router since it started.
+type dashboard struct {
+	sync.Mutex
+	started  time.Time
+	gateways map[string]*gatewayFlow // by name
+	flow     []dashboardEvent        // the recent messages, oldest first
+	errors   []dashboardEvent        // the recent failed sends, oldest first
+	media    mediaUsage
+}
+
+// gatewayFlow counts the messages sent to the destinations of a gateway.
+type gatewayFlow struct {
+	Relayed     uint64    `json:"relayed"`
+	Failed      uint64    `json:"failed"`
+	Dropped     uint64    `json:"dropped"`
+	LastRelayed time.Time `json:"last_relayed"`
+}
+
+type mediaUsage struct {
+	Backend    string    `json:"backend"` // empty without a MediaServer
+	Files      uint64    `json:"files"`
+	Bytes      int64     `json:"bytes"`
+	LastUpload time.Time `json:"last_upload"`
+}
+
+// dashboardEvent is an event as sent to the dashboard.
+type dashboardEvent struct {
+	Kind     events.Kind `json:"kind"`
+	Time     time.Time   `json:"time"`
+	Gateway  string      `json:"gateway,omitempty"`
+	From     string      `json:"from,omitempty"` // account the message came from
+	Account  string      `json:"account,omitempty"`
+	Channel  string      `json:"channel,omitempty"`
+	Username string      `json:"username,omitempty"`
+	Text     string      `json:"text,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Duration float64     `json:"duration_ms,omitempty"`
+	Bytes    int64       `json:"bytes,omitempty"` // of the uploaded file
+}
+
+func newDashboardEvent(ev events.Event) dashboardEvent {
+	dev := dashboardEvent{
+		Kind:     ev.Kind,
+		Time:     ev.Time,
+		Gateway:  ev.Gateway,
+		Account:  ev.Account,
+		Channel:  ev.Channel,
+		Duration: float64(ev.Duration.Microseconds()) / 1000,
+	}
+	if ev.Message != nil {
+		dev.From = ev.Message.Account
+		dev.Username = ev.Message.Username
+		dev.Text = ev.Message.Text
+		if runes := []rune(dev.Text); len(runes) > dashboardTextLength {
+			dev.Text = string(runes[:dashboardTextLength]) + "…"
+		}
+	}
+	if ev.Err != nil {
+		dev.Error = ev.Err.Error()
+	}
+	if ev.File != nil {
+		dev.Text = ev.File.Name
+		dev.Bytes = ev.File.DataSize()
+	}
+	return dev
+}
+
+func newDashboard(backend string) *dashboard {
+	return &dashboard{started: time.Now(), gateways: make(map[string]*gatewayFlow), media: mediaUsage{Backend: backend}}
+}
+
+func (d *dashboard) handle(ev events.Event) {
+	d.Lock()
+	defer d.Unlock()
+	flow := d.gateways[ev.Gateway]
+	if flow == nil {
+		flow = &gatewayFlow{}
+		if ev.Gateway != "" {
+			d.gateways[ev.Gateway] = flow
+		}
+	}
+	switch ev.Kind {
+	case events.MessageRelayed:
+		flow.Relayed++
+		flow.LastRelayed = ev.Time
+		d.flow = appendRecent(d.flow, newDashboardEvent(ev))
+	case events.SendFailed:
+		flow.Failed++
+		d.errors = appendRecent(d.errors, newDashboardEvent(ev))
+	case events.MessageDropped:
+		flow.Dropped++
+	case events.MediaUploaded:
+		d.media.Files++
+		d.media.Bytes += ev.File.DataSize()
+		d.media.LastUpload = ev.Time
+	}
+}
+
+func appendRecent(recent []dashboardEvent, ev dashboardEvent) []dashboardEvent {
+	if len(recent) == dashboardRecent {
+		recent = append(recent[:0], recent[1:]...)
+	}
+	return append(recent, ev)
+}
+
+// dashboardGateway is a gateway as shown on the dashboard.
+type dashboardGateway struct {
+	gatewayStatus
+	gatewayFlow
+}
+
+type dashboardStatus struct {
+	Started  time.Time          `json:"started"`
+	Gateways []dashboardGateway `json:"gateways"`
+	Flow     []dashboardEvent   `json:"flow"`
+	Errors   []dashboardEvent   `json:"errors"`
+	Media    mediaUsage         `json:"media"`
+}
+
+func (r *Router) dashboardStatus() dashboardStatus {
+	statuses := r.gatewayStatuses()
+	d := r.dashboard
+	d.Lock()
+	defer d.Unlock()
+	res := dashboardStatus{
+		Started:  d.started,
+		Gateways: make([]dashboardGateway, 0, len(statuses)),
+		Flow:     append([]dashboardEvent{}, d.flow...),
+		Errors:   append([]dashboardEvent{}, d.errors...),
+		Media:    d.media,
+	}
+	for _, status := range statuses {
+		gw := dashboardGateway{gatewayStatus: status}
+		if flow := d.gateways[status.Name]; flow != nil {
+			gw.gatewayFlow = *flow
+		}
+		res.Gateways = append(res.Gateways, gw)
+	}
+	return res
+}
+
+func (r *Router) handleDashboard(w http.ResponseWriter, req *http.Request) {
+	r.writeJSON(w, http.StatusOK, r.dashboardStatus())
+}
+
+// handleDashboardEvents streams the events of the dashboard as they happen, as JSON
+// separated by newlines.
+func (r *Router) handleDashboardEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	evs := make(chan dashboardEvent, dashboardRecent)
+	unsubscribe := r.Events.Subscribe(func(ev events.Event) {
+		select {
+		case evs <- newDashboardEvent(ev):
+		default:
+		}
+	}, dashboardEvents...)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case ev := <-evs:
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// dashboardHandler serves the assets of the dashboard. They're served without the
+// AdminToken, the dashboard asks for it and uses it for the requests to the admin API.
+func dashboardHandler() http.Handler {
+	assets, err := fs.Sub(dashboardAssets, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/dashboard/", http.FileServer(http.FS(assets)))
+}
+
+// startDashboard keeps the events for the dashboard of the admin listener, with
+// AdminDashboard.
+func (r *Router) startDashboard() {
+	general := r.BridgeValues().General
+	if !general.AdminDashboard || r.dashboard != nil {
+		return
+	}
+	backend := ""
+	switch {
+	case general.MediaUploadBackend == "s3":
+		backend = "s3"
+	case general.MediaServerUpload != "":
+		backend = "upload"
+	case helper.HasMediaServer(&general):
+		backend = "path"
+	}
+	r.dashboard = newDashboard(backend)
+	r.Events.Subscribe(r.dashboard.handle, dashboardEvents...)
+}
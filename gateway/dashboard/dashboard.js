@@ -0,0 +1,215 @@
+// The dashboard of the admin listener of matterbridge. It asks for the AdminToken, keeps it
+// for the session and uses it for /api/dashboard (the state, polled) and
+// /api/dashboard/events (the events as they happen, JSON separated by newlines).
+"use strict";
+
+const recent = 100;
+const pollInterval = 5000;
+const retryInterval = 5000;
+
+let token = sessionStorage.getItem("matterbridge-token") || "";
+
+function $(id) {
+  return document.getElementById(id);
+}
+
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+  Object.assign(e, attrs || {});
+  for (const child of children) {
+    e.append(child === undefined || child === null ? "" : child);
+  }
+  return e;
+}
+
+function time(t) {
+  const d = new Date(t);
+  return d.getFullYear() < 2 ? "never" : d.toLocaleTimeString();
+}
+
+function bytes(n) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+}
+
+async function api(path, options) {
+  const resp = await fetch(path, Object.assign({headers: {Authorization: "Bearer " + token}}, options));
+  if (resp.status === 401) {
+    throw new Error("unauthorized");
+  }
+  if (!resp.ok) {
+    throw new Error(resp.status + " " + resp.statusText);
+  }
+  return resp;
+}
+
+function showLogin(error) {
+  $("main").hidden = true;
+  $("login").hidden = false;
+  $("login-error").textContent = error || "";
+  setLive(false);
+}
+
+function setLive(on) {
+  $("live").className = on ? "on" : "off";
+  $("live").textContent = on ? "live" : "offline";
+}
+
+function bridgeStatus(br) {
+  if (br.disabled) {
+    return "disabled";
+  }
+  return br.connected ? "connected" : "disconnected";
+}
+
+function renderGateways(gateways) {
+  const rows = gateways.map((gw) => {
+    const table = el("table", {},
+      el("thead", {}, el("tr", {}, el("th", {}, "Account"), el("th", {}, "Status"), el("th", {}, "Queued"), el("th", {}, "Channels"))),
+      el("tbody", {}, ...gw.bridges.map((br) => {
+        const status = bridgeStatus(br);
+        return el("tr", {},
+          el("td", {}, br.account),
+          el("td", {className: "status " + status}, status),
+          el("td", {}, br.queued),
+          el("td", {}, br.channels.join(", ")));
+      })));
+    const counts = `relayed ${gw.relayed}, failed ${gw.failed}, dropped ${gw.dropped}, last message ${time(gw.last_relayed)}`;
+    return el("div", {className: "gateway"}, el("h3", {}, gw.name + " ", el("small", {}, counts)), table);
+  });
+  $("gateways").replaceChildren(...rows);
+}
+
+function renderMedia(media) {
+  if (!media.backend) {
+    $("media").textContent = "No MediaServer is configured.";
+    return;
+  }
+  $("media").textContent = `${media.files} files (${bytes(media.bytes)}) uploaded to ${media.backend} since the start, ` +
+    `last upload ${time(media.last_upload)}.`;
+}
+
+function flowRow(ev) {
+  return el("tr", {},
+    el("td", {}, time(ev.time)),
+    el("td", {}, ev.gateway),
+    el("td", {}, ev.from),
+    el("td", {}, `${ev.account} ${ev.channel}`),
+    el("td", {}, ev.username),
+    el("td", {className: "text", title: ev.text || ""}, ev.text),
+    el("td", {}, ev.duration_ms ? ev.duration_ms.toFixed(0) + " ms" : ""));
+}
+
+function errorRow(ev) {
+  return el("tr", {},
+    el("td", {}, time(ev.time)),
+    el("td", {}, ev.gateway),
+    el("td", {}, `${ev.account} ${ev.channel}`),
+    el("td", {className: "error"}, ev.error));
+}
+
+// prepend adds row at the top of tbody and keeps the recent rows.
+function prepend(tbody, row) {
+  tbody.prepend(row);
+  while (tbody.rows.length > recent) {
+    tbody.deleteRow(-1);
+  }
+}
+
+async function refresh() {
+  const resp = await api("/api/dashboard");
+  const status = await resp.json();
+  $("started").textContent = "running since " + new Date(status.started).toLocaleString();
+  renderGateways(status.gateways);
+  renderMedia(status.media);
+  return status;
+}
+
+async function stream() {
+  const resp = await api("/api/dashboard/events");
+  setLive(true);
+  const reader = resp.body.getReader();
+  const decoder = new TextDecoder();
+  let buf = "";
+  for (;;) {
+    const {value, done} = await reader.read();
+    if (done) {
+      break;
+    }
+    buf += decoder.decode(value, {stream: true});
+    let idx;
+    while ((idx = buf.indexOf("\n")) >= 0) {
+      const line = buf.slice(0, idx);
+      buf = buf.slice(idx + 1);
+      if (line) {
+        handleEvent(JSON.parse(line));
+      }
+    }
+  }
+  setLive(false);
+}
+
+function handleEvent(ev) {
+  switch (ev.kind) {
+  case "message_relayed":
+    prepend($("flow"), flowRow(ev));
+    break;
+  case "send_failed":
+    prepend($("errors"), errorRow(ev));
+    break;
+  case "bridge_connected":
+  case "bridge_disconnected":
+  case "media_uploaded":
+    refresh().catch(() => {});
+    break;
+  }
+}
+
+// keepStreaming streams the events and connects again when the stream ends.
+function keepStreaming() {
+  stream().catch(() => setLive(false)).finally(() => setTimeout(keepStreaming, retryInterval));
+}
+
+async function start() {
+  let status;
+  try {
+    status = await refresh();
+  } catch (err) {
+    if (err.message !== "unauthorized") {
+      showLogin(err.message);
+      return;
+    }
+    token = "";
+    sessionStorage.removeItem("matterbridge-token");
+    showLogin("wrong token");
+    return;
+  }
+  $("login").hidden = true;
+  $("main").hidden = false;
+  $("flow").replaceChildren(...status.flow.reverse().map(flowRow));
+  $("errors").replaceChildren(...status.errors.reverse().map(errorRow));
+  setInterval(() => refresh().catch((err) => {
+    if (err.message === "unauthorized") {
+      location.reload();
+    }
+  }), pollInterval);
+  keepStreaming();
+}
+
+$("login").addEventListener("submit", (e) => {
+  e.preventDefault();
+  token = $("token").value;
+  sessionStorage.setItem("matterbridge-token", token);
+  start();
+});
+
+if (token) {
+  start();
+} else {
+  showLogin();
+}
@@ -0,0 +1,89 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/gateway/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDashboard(t *testing.T) {
+	d := newDashboard("s3")
+	data := []byte("hello")
+	d.handle(events.Event{Kind: events.MessageRelayed, Gateway: "bridge1", Account: "discord.test", Channel: "general",
+		Message: &config.Message{Text: string(make([]rune, 150)), Account: "irc.freenode", Username: "wim"}, Duration: 1500 * time.Microsecond})
+	d.handle(events.Event{Kind: events.SendFailed, Gateway: "bridge1", Account: "discord.test", Channel: "general", Err: errors.New("timeout")})
+	d.handle(events.Event{Kind: events.MessageDropped, Gateway: "bridge1", Account: "discord.test"})
+	d.handle(events.Event{Kind: events.MediaUploaded, Gateway: "bridge1", Account: "irc.freenode", File: &config.FileInfo{Name: "a.png", Data: &data}})
+	d.handle(events.Event{Kind: events.BridgeConnected, Account: "irc.freenode"})
+
+	require.Len(t, d.gateways, 1)
+	flow := d.gateways["bridge1"]
+	assert.Equal(t, []uint64{1, 1, 1}, []uint64{flow.Relayed, flow.Failed, flow.Dropped})
+	require.Len(t, d.flow, 1)
+	assert.Equal(t, "irc.freenode", d.flow[0].From)
+	assert.Equal(t, 1.5, d.flow[0].Duration)
+	assert.Len(t, []rune(d.flow[0].Text), dashboardTextLength+1)
+	require.Len(t, d.errors, 1)
+	assert.Equal(t, "timeout", d.errors[0].Error)
+	assert.Equal(t, uint64(1), d.media.Files)
+	assert.Equal(t, int64(5), d.media.Bytes)
+
+	// only the recent messages are kept
+	for i := 0; i < dashboardRecent+10; i++ {
+		d.handle(events.Event{Kind: events.MessageRelayed, Gateway: "bridge1", Message: &config.Message{Text: "hi"}})
+	}
+	assert.Len(t, d.flow, dashboardRecent)
+	assert.Equal(t, "hi", d.flow[0].Text)
+}
+
+func TestAdminDashboard(t *testing.T) {
+	r := maketestRouter(testconfigAdmin)
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(r.adminHandler(), "/dashboard/", "").Code)
+
+	r.BridgeValues().General.AdminDashboard = true
+	r.startDashboard()
+	h := r.adminHandler()
+
+	// the assets don't need the token, the API does
+	resp := adminRequest(h, "/dashboard/", "")
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `<script src="dashboard.js">`)
+	assert.Equal(t, http.StatusOK, adminRequest(h, "/dashboard/dashboard.js", "").Code)
+	assert.Equal(t, http.StatusMovedPermanently, adminRequest(h, "/dashboard", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, adminRequest(h, "/api/dashboard", "").Code)
+
+	r.Events.Publish(events.Event{Kind: events.MessageRelayed, Gateway: "bridge1", Account: "irc.freenode", Channel: "#wimtesting"})
+	assert.Eventually(t, func() bool {
+		var status dashboardStatus
+		resp := adminRequest(h, "/api/dashboard", "secret")
+		return resp.Code == http.StatusOK && json.Unmarshal(resp.Body.Bytes(), &status) == nil &&
+			len(status.Gateways) == 1 && status.Gateways[0].Relayed == 1 && len(status.Flow) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/dashboard/events", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	stream, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer stream.Body.Close()
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+
+	r.Events.Publish(events.Event{Kind: events.SendFailed, Gateway: "bridge1", Account: "irc.freenode", Err: errors.New("timeout")})
+	line, err := bufio.NewReader(stream.Body).ReadBytes('\n')
+	require.NoError(t, err)
+	var ev dashboardEvent
+	require.NoError(t, json.Unmarshal(line, &ev))
+	assert.Equal(t, events.SendFailed, ev.Kind)
+	assert.Equal(t, "timeout", ev.Error)
+}
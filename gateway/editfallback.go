@@ -0,0 +1,128 @@
+package gateway
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const (
+	editFallbackSuffix = "suffix"
+	editFallbackDiff   = "diff"
+	editFallbackDrop   = "drop"
+
+	defaultEditedSuffix = " (edited)"
+
+	// editTextCacheSize is the number of recent messages kept for the edits.
+	editTextCacheSize = 5000
+)
+
+var (
+	errDroppedEdit    = errors.New("edit fallback")
+	errDroppedOldEdit = errors.New("edit too old")
+)
+
+// relayedText is the text of a relayed message and when it was first relayed.
+type relayedText struct {
+	Text string
+	Time time.Time
+}
+
+// rememberText keeps the text of msg, after it's relayed, so the edits of msg are sent with
+// the text of the previous version.
+func (gw *Gateway) rememberText(msg *config.Message) {
+	if gw.texts == nil || msg.ID == "" || (msg.Event != "" && msg.Event != config.EventUserAction) {
+		return
+	}
+	canonical := msg.Protocol + " " + msg.ID
+	t := relayedText{Text: msg.Text, Time: msg.Timestamp}
+	if v, ok := gw.texts.Get(canonical); ok {
+		t.Time = v.(relayedText).Time
+	}
+	if t.Time.IsZero() {
+		t.Time = time.Now()
+	}
+	gw.texts.Add(canonical, t)
+}
+
+// renderEdit prepares the copy msg of the edit rmsg for dest, and returns the reason when it
+// must not be sent. Edits of messages older than the EditMaxAge seconds of dest aren't sent.
+// The destinations without a copy to edit, like irc, get the EditFallback of dest: the new
+// text again (the default), with the EditSuffix of dest with "suffix", a s/old/new/ with
+// "diff", or nothing with "drop".
+func (gw *Gateway) renderEdit(rmsg, msg *config.Message, dest *bridge.Bridge) error {
+	fallback, maxAge := dest.GetString("EditFallback"), dest.GetInt("EditMaxAge")
+	if (fallback == "" && maxAge <= 0) || !gw.isEdit(rmsg) {
+		return nil
+	}
+	var prev relayedText
+	if v, ok := gw.texts.Get(rmsg.Protocol + " " + rmsg.ID); ok {
+		prev = v.(relayedText)
+	}
+	// the messages that aren't remembered anymore are older than the cache
+	if maxAge > 0 && (prev.Time.IsZero() || time.Since(prev.Time) > time.Duration(maxAge)*time.Second) {
+		gw.logger.Debugf("not sending the edit of %s to %s, the message is too old", rmsg.ID, dest.Account)
+		return errDroppedOldEdit
+	}
+	if msg.ID != "" {
+		return nil
+	}
+	switch fallback {
+	case editFallbackDrop:
+		return errDroppedEdit
+	case editFallbackDiff:
+		if diff, ok := sedDiff(prev.Text, rmsg.Text); ok {
+			msg.Text = diff
+			return nil
+		}
+		fallthrough
+	case editFallbackSuffix:
+		suffix := dest.GetString("EditSuffix")
+		if suffix == "" {
+			suffix = defaultEditedSuffix
+		}
+		if !strings.HasSuffix(msg.Text, suffix) {
+			msg.Text += suffix
+		}
+	}
+	return nil
+}
+
+// sedDiff returns the change of the words of a to b as s/old/new/, false when there's no
+// change of words or the change isn't shorter than b.
+func sedDiff(a, b string) (string, bool) {
+	x, y := strings.Fields(a), strings.Fields(b)
+	if len(x) == 0 {
+		return "", false
+	}
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+	if prefix == len(x) && prefix == len(y) {
+		return "", false
+	}
+	// words added between two words are replaced with one of them
+	if prefix+suffix == len(x) {
+		if prefix > 0 {
+			prefix--
+		} else {
+			suffix--
+		}
+	}
+	escape := strings.NewReplacer("/", `\/`)
+	from := escape.Replace(strings.Join(x[prefix:len(x)-suffix], " "))
+	to := escape.Replace(strings.Join(y[prefix:len(y)-suffix], " "))
+	diff := "s/" + from + "/" + to + "/"
+	if len(diff) >= len(b) {
+		return "", false
+	}
+	return diff, true
+}
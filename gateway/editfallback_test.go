@@ -0,0 +1,106 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigEditFallback = []byte(`
+[slack.test]
+server=""
+[irc.freenode]
+server=""
+EditFallback="diff"
+[discord.test]
+server=""
+EditMaxAge=60
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+`)
+
+// noIDBridger records the messages like a bridge without edits, it returns no IDs.
+type noIDBridger struct {
+	*recordBridger
+}
+
+func (b noIDBridger) Send(msg config.Message) (string, error) {
+	_, err := b.recordBridger.Send(msg)
+	return "", err
+}
+
+func TestSedDiff(t *testing.T) {
+	for _, tc := range []struct {
+		a, b, diff string
+	}{
+		{"we meet at 5pm tomorrow", "we meet at 6pm tomorrow", "s/5pm/6pm/"},
+		{"the quick fox jumps over it", "the quick brown fox jumps over it", "s/quick/quick brown/"},
+		{"hello world and everyone here", "hello world and here", "s/everyone//"},
+		{"see a/b for the details of it", "see c/d for the details of it", `s/a\/b/c\/d/`},
+		{"hello", "bye", ""},
+		{"same text", "same  text", ""},
+		{"", "new", ""},
+	} {
+		diff, ok := sedDiff(tc.a, tc.b)
+		assert.Equal(t, tc.diff != "", ok, tc.b)
+		assert.Equal(t, tc.diff, diff, tc.b)
+	}
+}
+
+func TestRenderEdit(t *testing.T) {
+	gw := maketestRouter(testconfigEditFallback).Gateways["bridge1"]
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+	gw.Bridges["irc.freenode"].Bridger = noIDBridger{recorders["irc.freenode"]}
+	relay := func(text string, timestamp time.Time) {
+		gw.relayMessage(&config.Message{
+			ID: "m1", Text: text, Username: "alice", Channel: "general", Account: "slack.test", Protocol: "slack", Gateway: "bridge1", Timestamp: timestamp,
+		})
+	}
+
+	relay("we meet at 5pm tomorrow", time.Now())
+	relay("we meet at 6pm tomorrow", time.Now())
+	irc := recorders["irc.freenode"].sent
+	require.Len(t, irc, 2)
+	assert.Equal(t, "s/5pm/6pm/", irc[1].Text)
+	// the bridges with edits edit their copy
+	discord := recorders["discord.test"].sent
+	require.Len(t, discord, 2)
+	assert.Equal(t, "1", discord[1].ID)
+	assert.Equal(t, "we meet at 6pm tomorrow", discord[1].Text)
+
+	// a change of every word is sent with the suffix
+	relay("cancelled", time.Now())
+	assert.Equal(t, "cancelled (edited)", recorders["irc.freenode"].sent[2].Text)
+
+	// the edits of messages older than EditMaxAge aren't relayed
+	gw.relayMessage(&config.Message{
+		ID: "m2", Text: "old news", Username: "alice", Channel: "general", Account: "slack.test", Protocol: "slack", Gateway: "bridge1",
+		Timestamp: time.Now().Add(-time.Hour),
+	})
+	gw.relayMessage(&config.Message{
+		ID: "m2", Text: "old news!", Username: "alice", Channel: "general", Account: "slack.test", Protocol: "slack", Gateway: "bridge1",
+	})
+	assert.Len(t, recorders["discord.test"].sent, 4)
+	assert.Len(t, recorders["irc.freenode"].sent, 5)
+}
@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+// deletedTTL is how long deleted messages are remembered to drop edits arriving after the delete.
+const deletedTTL = time.Hour
+
+// edits keeps the edits that are waiting for the EditCoalesceDelay of their destination,
+// keyed by destination account and canonical message ID.
+type edits struct {
+	sync.Mutex
+	pending map[string]*pendingEdit
+}
+
+type pendingEdit struct {
+	msg   config.Message
+	timer *time.Timer
+}
+
+func (e *edits) take(key string) (*pendingEdit, bool) {
+	e.Lock()
+	defer e.Unlock()
+	p, ok := e.pending[key]
+	delete(e.pending, key)
+	return p, ok
+}
+
+func (gw *Gateway) deletedBucket() *store.Bucket {
+	return store.NewBucket(gw.Router.Store, "deleted:"+gw.Name)
+}
+
+// editedBucket keeps the time of the last edit of the relayed messages.
+func (gw *Gateway) editedBucket() *store.Bucket {
+	return store.NewBucket(gw.Router.Store, "edited:"+gw.Name)
+}
+
+// staleEdit returns true if msg, an edit of the message canonical, is older than an edit of
+// it that was already relayed, which happens when a bridge delivers edits out of order.
+// Otherwise the time of msg is recorded. Edits are ordered by their Timestamp, edits with
+// the same Timestamp are relayed in the order they arrive.
+func (gw *Gateway) staleEdit(canonical string, msg *config.Message) bool {
+	gw.edits.Lock()
+	defer gw.edits.Unlock()
+	bucket := gw.editedBucket()
+	if last, ok := bucket.GetString(canonical); ok {
+		if nanos, err := strconv.ParseInt(last, 10, 64); err == nil && msg.Timestamp.UnixNano() < nanos {
+			return true
+		}
+	}
+	if err := bucket.SetStringTTL(canonical, strconv.FormatInt(msg.Timestamp.UnixNano(), 10), deletedTTL); err != nil {
+		gw.logger.Errorf("failed to record the edit of %s: %s", canonical, err)
+	}
+	return false
+}
+
+// isEdit returns true if msg changes a message that was already relayed.
+func (gw *Gateway) isEdit(msg *config.Message) bool {
+	if msg.ID == "" || (msg.Event != "" && msg.Event != config.EventUserAction) {
+		return false
+	}
+	_, ok := gw.getMsgIDs(msg.Protocol + " " + msg.ID)
+	return ok
+}
+
+// coalesceEdit returns true if msg is handled here instead of being sent to dest now.
+// Rapid edits of the same message are squashed into the last one, sent after EditCoalesceDelay
+// milliseconds. Edits arriving after the message was deleted are dropped, as are edits older
+// than an edit already relayed.
+func (gw *Gateway) coalesceEdit(msg *config.Message, dest *bridge.Bridge) bool {
+	canonical := msg.Protocol + " " + msg.ID
+	key := dest.Account + " " + canonical
+	if msg.Event == config.EventMsgDelete {
+		if p, ok := gw.edits.take(key); ok {
+			p.timer.Stop()
+		}
+		if err := gw.deletedBucket().SetStringTTL(canonical, "", deletedTTL); err != nil {
+			gw.logger.Errorf("failed to record deleted message %s: %s", canonical, err)
+		}
+		return false
+	}
+	if !gw.isEdit(msg) {
+		return false
+	}
+	if gw.deletedBucket().Contains(canonical) {
+		gw.logger.Debugf("dropping edit of deleted message %s", canonical)
+		return true
+	}
+	if !msg.Timestamp.IsZero() && gw.staleEdit(canonical, msg) {
+		gw.logger.Debugf("dropping edit of %s older than the last one relayed", canonical)
+		return true
+	}
+	delay := dest.GetInt("EditCoalesceDelay")
+	if delay <= 0 {
+		return false
+	}
+
+	gw.edits.Lock()
+	defer gw.edits.Unlock()
+	if p, ok := gw.edits.pending[key]; ok {
+		gw.logger.Debugf("squashing edit of %s for %s", canonical, dest.Account)
+		p.msg = *msg
+		return true
+	}
+	p := &pendingEdit{msg: *msg}
+	p.timer = time.AfterFunc(time.Duration(delay)*time.Millisecond, func() {
+		p, ok := gw.edits.take(key)
+		if !ok || gw.deletedBucket().Contains(canonical) {
+			return
+		}
+		gw.deliverMessage(&p.msg, dest)
+	})
+	gw.edits.pending[key] = p
+	return true
+}
@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigEdits = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+EditCoalesceDelay=50
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+	`)
+
+func TestCoalesceEdits(t *testing.T) {
+	r := maketestRouter(testconfigEdits)
+	gw := r.Gateways["bridge1"]
+	flaky := &flakyBridger{}
+	gw.Bridges["discord.test"].Bridger = flaky
+	gw.Bridges["irc.freenode"].Bridger = &recordBridger{}
+
+	msg := func(text, event string) *config.Message {
+		return &config.Message{Text: text, Event: event, ID: "1", Username: "wim", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Gateway: "bridge1"}
+	}
+	gw.relayMessage(msg("hi", ""))
+	assert.Equal(t, []string{"hi"}, flaky.texts())
+
+	// rapid edits are squashed into the last one
+	for _, text := range []string{"hi!", "hi!!", "hi!!!"} {
+		gw.relayMessage(msg(text, ""))
+	}
+	assert.Equal(t, []string{"hi"}, flaky.texts())
+	assert.Eventually(t, func() bool { return len(flaky.texts()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "hi!!!", flaky.texts()[1])
+
+	// a pending edit is dropped when the message is deleted, as are later edits
+	gw.relayMessage(msg("edited", ""))
+	gw.relayMessage(msg("msg_delete", config.EventMsgDelete))
+	gw.relayMessage(msg("edited again", ""))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []string{"hi", "hi!!!", "msg_delete"}, flaky.texts())
+}
+
+func TestStaleEdits(t *testing.T) {
+	r := maketestRouter(testconfigEdits)
+	gw := r.Gateways["bridge1"]
+	gw.Bridges["discord.test"].Config.Viper().Set("discord.test.EditCoalesceDelay", 0)
+	flaky := &flakyBridger{}
+	gw.Bridges["discord.test"].Bridger = flaky
+	gw.Bridges["irc.freenode"].Bridger = &recordBridger{}
+
+	start := time.Now()
+	msg := func(text string, at time.Duration) *config.Message {
+		return &config.Message{Text: text, ID: "1", Username: "wim", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Gateway: "bridge1", Timestamp: start.Add(at)}
+	}
+	gw.relayMessage(msg("hi", 0))
+	gw.relayMessage(msg("second edit", 2*time.Second))
+	// the first edit arrives after the second one
+	gw.relayMessage(msg("first edit", time.Second))
+	gw.relayMessage(msg("third edit", 3*time.Second))
+	assert.Equal(t, []string{"hi", "second edit", "third edit"}, flaky.texts())
+}
@@ -0,0 +1,139 @@
+// Package events is the internal event bus of matterbridge. The gateway publishes what
+// happens (messages relayed, failed sends, bridges connecting, media downloads) and
+// extensions subscribe to it instead of hooking into the gateway itself.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// Kind is the type of an event.
+type Kind string
+
+const (
+	// MessageRelayed is published for every destination a message was sent to.
+	MessageRelayed Kind = "message_relayed"
+	// SendFailed is published when sending a message to a destination failed, Err is set.
+	SendFailed Kind = "send_failed"
+	// BridgeConnected is published when a bridge is connected, at start or after a reconnect.
+	BridgeConnected Kind = "bridge_connected"
+	// BridgeDisconnected is published when a bridge is disconnected to reconnect it.
+	BridgeDisconnected Kind = "bridge_disconnected"
+	// MediaDownloaded is published for every file downloaded by a bridge, File is set.
+	MediaDownloaded Kind = "media_downloaded"
+	// MediaUploaded is published for every file uploaded to the MediaServer, File is set.
+	MediaUploaded Kind = "media_uploaded"
+	// MessageDropped is published when a message isn't sent to a destination because of the
+	// content policy or a tengo script, Err is the reason.
+	MessageDropped Kind = "message_dropped"
+)
+
+// subscriptionBuffer is the number of events kept for a subscriber that's busy.
+const subscriptionBuffer = 256
+
+// Event is something that happened in the gateway.
+type Event struct {
+	Kind Kind
+	Time time.Time
+	// Gateway is the name of the gateway, empty for events of a bridge.
+	Gateway string
+	// Account is the bridge the event is about, the destination for MessageRelayed and SendFailed.
+	Account string
+	// Channel is the destination channel for MessageRelayed and SendFailed.
+	Channel string
+	// Message is the message as sent to Account, or as received for MediaDownloaded and
+	// MediaUploaded.
+	// Its Extra map is shared with the gateway and must not be changed.
+	Message *config.Message
+	// MessageID is the ID of the message on Account for MessageRelayed.
+	MessageID string
+	File      *config.FileInfo
+	Err       error
+	// Duration is how long sending took for MessageRelayed and SendFailed.
+	Duration time.Duration
+}
+
+// Bus delivers the published events to the subscribers. Every subscriber gets its events in
+// order in its own goroutine, so a slow subscriber doesn't hold up the gateway or the others.
+// Events for a subscriber that can't keep up are dropped.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*subscription]struct{}
+}
+
+type subscription struct {
+	kinds   map[Kind]bool
+	events  chan Event
+	dropped uint64
+}
+
+// New returns an event bus without subscribers.
+func New() *Bus {
+	return &Bus{subs: make(map[*subscription]struct{})}
+}
+
+// Subscribe calls fn for the events of the given kinds, or all events when no kinds are given.
+// The returned function cancels the subscription.
+func (b *Bus) Subscribe(fn func(Event), kinds ...Kind) func() {
+	s := &subscription{events: make(chan Event, subscriptionBuffer)}
+	if len(kinds) > 0 {
+		s.kinds = make(map[Kind]bool, len(kinds))
+		for _, k := range kinds {
+			s.kinds[k] = true
+		}
+	}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	go func() {
+		for ev := range s.events {
+			fn(ev)
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, s)
+			b.mu.Unlock()
+			close(s.events)
+		})
+	}
+}
+
+// Publish sends ev to the subscribers of its kind, Time is set when it's zero.
+func (b *Bus) Publish(ev Event) {
+	if b == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if s.kinds != nil && !s.kinds[ev.Kind] {
+			continue
+		}
+		select {
+		case s.events <- ev:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// Dropped returns the number of events dropped because a subscriber was too slow.
+func (b *Bus) Dropped() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var n uint64
+	for s := range b.subs {
+		n += atomic.LoadUint64(&s.dropped)
+	}
+	return n
+}
@@ -0,0 +1,68 @@
+package events
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recorder struct {
+	sync.Mutex
+	events []Event
+}
+
+func (r *recorder) add(ev Event) {
+	r.Lock()
+	defer r.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func (r *recorder) kinds() []Kind {
+	r.Lock()
+	defer r.Unlock()
+	var kinds []Kind
+	for _, ev := range r.events {
+		kinds = append(kinds, ev.Kind)
+	}
+	return kinds
+}
+
+func TestBus(t *testing.T) {
+	bus := New()
+	all, failures := &recorder{}, &recorder{}
+	bus.Subscribe(all.add)
+	cancel := bus.Subscribe(failures.add, SendFailed)
+
+	bus.Publish(Event{Kind: BridgeConnected, Account: "irc.libera"})
+	bus.Publish(Event{Kind: SendFailed, Account: "irc.libera", Err: errors.New("down")})
+	assert.Eventually(t, func() bool { return len(all.kinds()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, []Kind{BridgeConnected, SendFailed}, all.kinds())
+	assert.Eventually(t, func() bool { return len(failures.kinds()) == 1 }, time.Second, time.Millisecond)
+	assert.False(t, failures.events[0].Time.IsZero())
+
+	cancel()
+	cancel()
+	bus.Publish(Event{Kind: SendFailed})
+	assert.Eventually(t, func() bool { return len(all.kinds()) == 3 }, time.Second, time.Millisecond)
+	assert.Len(t, failures.kinds(), 1)
+}
+
+func TestBusSlowSubscriber(t *testing.T) {
+	bus := New()
+	block := make(chan struct{})
+	defer close(block)
+	bus.Subscribe(func(Event) { <-block })
+	for i := 0; i < subscriptionBuffer+10; i++ {
+		bus.Publish(Event{Kind: MessageRelayed})
+	}
+	// one event is being handled, the buffer is full and the rest is dropped
+	assert.InDelta(t, 9, bus.Dropped(), 1)
+}
+
+func TestNilBus(t *testing.T) {
+	var bus *Bus
+	bus.Publish(Event{Kind: MessageRelayed})
+}
@@ -0,0 +1,222 @@
+package gateway
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const exportTimeFormat = "2006-01-02 15:04:05"
+
+// ExportOptions selects the messages of an export of the archive.
+type ExportOptions struct {
+	Gateway string
+	Channel string // all the channels of the gateway when empty
+	Format  string // html, json or txt
+	Since   time.Time
+}
+
+// exportMedia is an entry of the media manifest of an export.
+type exportMedia struct {
+	Timestamp time.Time `json:"timestamp"`
+	Account   string    `json:"account"`
+	Channel   string    `json:"channel"`
+	Username  string    `json:"username"`
+	MessageID string    `json:"message_id,omitempty"`
+	archivedFile
+}
+
+// export is an export of the messages of a gateway, with the media manifest listing the
+// files of the messages.
+type export struct {
+	Gateway  string            `json:"gateway"`
+	Channel  string            `json:"channel,omitempty"`
+	Since    *time.Time        `json:"since,omitempty"`
+	Exported time.Time         `json:"exported"`
+	Messages []archivedMessage `json:"messages"`
+	Media    []exportMedia     `json:"media"`
+}
+
+// ExportArchive writes the messages in the archive at path selected by opts to w, oldest
+// first, as a single html, json or txt file. The archive can be in use by a running
+// matterbridge.
+func ExportArchive(path string, opts ExportOptions, w io.Writer) error {
+	if opts.Gateway == "" {
+		return fmt.Errorf("the gateway to export is missing")
+	}
+	write, ok := exportFormats[opts.Format]
+	if !ok {
+		return fmt.Errorf("unknown export format %s, use html, json or txt", opts.Format)
+	}
+	a, err := openArchiveReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	msgs, err := a.history(opts.Gateway, opts.Channel, opts.Since)
+	if err != nil {
+		return err
+	}
+	e := &export{Gateway: opts.Gateway, Channel: opts.Channel, Exported: time.Now().UTC(), Messages: msgs, Media: []exportMedia{}}
+	if !opts.Since.IsZero() {
+		since := opts.Since.UTC()
+		e.Since = &since
+	}
+	for _, m := range msgs {
+		for _, f := range m.Files {
+			e.Media = append(e.Media, exportMedia{
+				Timestamp: m.Timestamp, Account: m.Account, Channel: m.Channel, Username: m.Username, MessageID: m.ID, archivedFile: f,
+			})
+		}
+	}
+	return write(w, e)
+}
+
+// openArchiveReadOnly opens the archive at path without changing it.
+func openArchiveReadOnly(path string) (*archive, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("no archive: %w", err)
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	return &archive{db: db}, nil
+}
+
+// history returns the messages of gateway since since, in channel when it isn't empty, with
+// their files and oldest first.
+func (a *archive) history(gateway, channel string, since time.Time) ([]archivedMessage, error) {
+	where := " WHERE m.gateway = ? AND m.timestamp >= ?"
+	args := []interface{}{gateway, since.UnixMilli()}
+	if channel != "" {
+		where += " AND m.channel = ?"
+		args = append(args, channel)
+	}
+	rows, err := a.db.Query(`SELECT m.rowid, m.gateway, m.account, m.protocol, m.channel, m.username, m.userid, m.id, m.text, m.timestamp
+		FROM messages m`+where+" ORDER BY m.timestamp, m.rowid", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	msgs := []archivedMessage{}
+	index := make(map[int64]int)
+	for rows.Next() {
+		var m archivedMessage
+		var rowid, timestamp int64
+		if err := rows.Scan(&rowid, &m.Gateway, &m.Account, &m.Protocol, &m.Channel, &m.Username, &m.UserID, &m.ID, &m.Text, &timestamp); err != nil {
+			return nil, err
+		}
+		m.Timestamp = time.UnixMilli(timestamp).UTC()
+		index[rowid] = len(msgs)
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	files, err := a.db.Query("SELECT f.message, f.name, f.url, f.size FROM files f JOIN messages m ON m.rowid = f.message"+where+" ORDER BY f.rowid", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer files.Close()
+	for files.Next() {
+		var rowid int64
+		var f archivedFile
+		if err := files.Scan(&rowid, &f.Name, &f.URL, &f.Size); err != nil {
+			return nil, err
+		}
+		if i, ok := index[rowid]; ok {
+			msgs[i].Files = append(msgs[i].Files, f)
+		}
+	}
+	return msgs, files.Err()
+}
+
+var exportFormats = map[string]func(io.Writer, *export) error{
+	"html": writeExportHTML,
+	"json": writeExportJSON,
+	"txt":  writeExportText,
+}
+
+func writeExportJSON(w io.Writer, e *export) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(e)
+}
+
+func writeExportText(w io.Writer, e *export) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# %s, exported %s\n", exportTitle(e), e.Exported.Format(exportTimeFormat))
+	for _, m := range e.Messages {
+		text := strings.ReplaceAll(m.Text, "\n", "\n    ")
+		fmt.Fprintf(&sb, "[%s] [%s %s] <%s> %s\n", m.Timestamp.Format(exportTimeFormat), m.Account, m.Channel, m.Username, text)
+		for _, f := range m.Files {
+			fmt.Fprintf(&sb, "    file: %s %s\n", f.Name, f.URL)
+		}
+	}
+	fmt.Fprintf(&sb, "\n# media (%d files)\n", len(e.Media))
+	for _, f := range e.Media {
+		fmt.Fprintf(&sb, "%s %s %d %s\n", f.Timestamp.Format(exportTimeFormat), f.Name, f.Size, f.URL)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// exportTitle returns what an export is of.
+func exportTitle(e *export) string {
+	title := "gateway " + e.Gateway
+	if e.Channel != "" {
+		title += ", channel " + e.Channel
+	}
+	if e.Since != nil {
+		title += ", since " + e.Since.Format(exportTimeFormat)
+	}
+	return title
+}
+
+var exportHTML = template.Must(template.New("export").Funcs(template.FuncMap{
+	"time":  func(t time.Time) string { return t.Format(exportTimeFormat) },
+	"title": exportTitle,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ title . }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.msg { margin: 0.2em 0; white-space: pre-wrap; }
+.time, .from { color: #666; }
+.nick { font-weight: bold; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>{{ title . }}</h1>
+<p>Exported {{ time .Exported }} UTC, {{ len .Messages }} messages.</p>
+{{- range .Messages }}
+<div class="msg"><span class="time">[{{ time .Timestamp }}]</span> <span class="from">{{ .Account }} {{ .Channel }}</span> <span class="nick">{{ .Username }}</span> {{ .Text }}
+{{- range .Files }}
+<br>file: {{ if .URL }}<a href="{{ .URL }}">{{ .Name }}</a>{{ else }}{{ .Name }}{{ end }}
+{{- end }}</div>
+{{- end }}
+<h2>Media</h2>
+<table>
+<tr><th>Time</th><th>User</th><th>File</th><th>Size</th></tr>
+{{- range .Media }}
+<tr><td>{{ time .Timestamp }}</td><td>{{ .Username }}</td><td>{{ if .URL }}<a href="{{ .URL }}">{{ .Name }}</a>{{ else }}{{ .Name }}{{ end }}</td><td>{{ .Size }}</td></tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
+
+func writeExportHTML(w io.Writer, e *export) error {
+	return exportHTML.Execute(w, e)
+}
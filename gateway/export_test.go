@@ -0,0 +1,92 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testExportArchive(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "archive.db")
+	a, err := newArchive(path, 0)
+	require.NoError(t, err)
+	defer a.Close()
+
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	msgs := []struct {
+		gateway string
+		msg     config.Message
+	}{
+		{"bridge1", config.Message{Account: "irc.freenode", Channel: "#wimtesting", Username: "wim", Text: "old <b>news</b>", Timestamp: day.Add(-48 * time.Hour)}},
+		{"bridge1", config.Message{Account: "discord.test", Channel: "general", Username: "Alice", ID: "1", Text: "a picture", Timestamp: day,
+			Extra: map[string][]interface{}{"file": {
+				config.FileInfo{Name: "cat.jpg", URL: "https://media.example.com/cat.jpg", Size: 1234},
+				config.FileInfo{Name: "avatar.png", Avatar: true},
+			}}}},
+		{"bridge1", config.Message{Account: "irc.freenode", Channel: "#wimtesting", Username: "wim", Text: "nice\ncat", Timestamp: day.Add(time.Minute)}},
+		{"bridge2", config.Message{Account: "irc.freenode", Channel: "#other", Username: "bob", Text: "elsewhere", Timestamp: day}},
+	}
+	for i := range msgs {
+		require.NoError(t, a.add(msgs[i].gateway, &msgs[i].msg))
+	}
+	return path
+}
+
+func TestExportArchiveJSON(t *testing.T) {
+	path := testExportArchive(t)
+	var buf bytes.Buffer
+	since := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, ExportArchive(path, ExportOptions{Gateway: "bridge1", Format: "json", Since: since}, &buf))
+
+	e := &export{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), e))
+	assert.Equal(t, "bridge1", e.Gateway)
+	require.Len(t, e.Messages, 2)
+	assert.Equal(t, "a picture", e.Messages[0].Text)
+	assert.Equal(t, []archivedFile{{Name: "cat.jpg", URL: "https://media.example.com/cat.jpg", Size: 1234}}, e.Messages[0].Files)
+	assert.Equal(t, "nice\ncat", e.Messages[1].Text)
+	require.Len(t, e.Media, 1)
+	assert.Equal(t, "Alice", e.Media[0].Username)
+	assert.Equal(t, "cat.jpg", e.Media[0].Name)
+
+	buf.Reset()
+	require.NoError(t, ExportArchive(path, ExportOptions{Gateway: "bridge1", Channel: "#wimtesting", Format: "json"}, &buf))
+	e = &export{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), e))
+	assert.Len(t, e.Messages, 2)
+	assert.Len(t, e.Media, 0)
+}
+
+func TestExportArchiveFormats(t *testing.T) {
+	path := testExportArchive(t)
+	var buf bytes.Buffer
+	require.NoError(t, ExportArchive(path, ExportOptions{Gateway: "bridge1", Format: "txt"}, &buf))
+	txt := buf.String()
+	assert.Contains(t, txt, "# gateway bridge1, exported ")
+	assert.Contains(t, txt, "[2024-02-28 12:00:00] [irc.freenode #wimtesting] <wim> old <b>news</b>\n")
+	assert.Contains(t, txt, "<wim> nice\n    cat\n")
+	assert.Contains(t, txt, "    file: cat.jpg https://media.example.com/cat.jpg\n")
+	assert.Contains(t, txt, "# media (1 files)\n2024-03-01 12:00:00 cat.jpg 1234 https://media.example.com/cat.jpg\n")
+	assert.NotContains(t, txt, "elsewhere")
+
+	buf.Reset()
+	require.NoError(t, ExportArchive(path, ExportOptions{Gateway: "bridge1", Format: "html"}, &buf))
+	html := buf.String()
+	assert.Contains(t, html, "old &lt;b&gt;news&lt;/b&gt;")
+	assert.Contains(t, html, `<a href="https://media.example.com/cat.jpg">cat.jpg</a>`)
+	assert.NotContains(t, html, "avatar.png")
+}
+
+func TestExportArchiveErrors(t *testing.T) {
+	path := testExportArchive(t)
+	var buf bytes.Buffer
+	assert.Error(t, ExportArchive(path, ExportOptions{Format: "txt"}, &buf))
+	assert.Error(t, ExportArchive(path, ExportOptions{Gateway: "bridge1", Format: "pdf"}, &buf))
+	assert.Error(t, ExportArchive(filepath.Join(t.TempDir(), "missing.db"), ExportOptions{Gateway: "bridge1", Format: "txt"}, &buf))
+}
@@ -0,0 +1,49 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const defaultForwardedFormat = "Forwarded from {FORWARDED}: {MESSAGE}"
+
+// addForwarded returns the text of msg with the source of the forwarded message rmsg,
+// formatted with the ForwardedFormat of dest.
+func (gw *Gateway) addForwarded(rmsg *config.Message, msg *config.Message, dest *bridge.Bridge) string {
+	if rmsg.ForwardedFrom == "" || (msg.Event != "" && msg.Event != config.EventUserAction) {
+		return msg.Text
+	}
+	format := dest.GetString("ForwardedFormat")
+	if format == "" {
+		format = defaultForwardedFormat
+	}
+	return strings.NewReplacer("{FORWARDED}", rmsg.ForwardedFrom, "{MESSAGE}", msg.Text).Replace(format)
+}
+
+// dropForward returns true if msg is a forward that isn't relayed to channel, with its
+// DropForwards, DropUnknownForwards or AllowedForwardSources.
+func dropForward(msg *config.Message, opts *config.ChannelOptions) bool {
+	if !msg.IsForwarded() {
+		return false
+	}
+	if opts.DropForwards {
+		return true
+	}
+	known := msg.ForwardedFrom != "" && msg.ForwardedFrom != config.ForwardedUnknown
+	if opts.DropUnknownForwards && !known {
+		return true
+	}
+	if len(opts.AllowedForwardSources) == 0 {
+		return false
+	}
+	if known {
+		for _, source := range opts.AllowedForwardSources {
+			if strings.EqualFold(source, msg.ForwardedFrom) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigForwards = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+ForwardedFormat="{MESSAGE} (fwd {FORWARDED})"
+[telegram.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "telegram.test"
+    channel = "-100"
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+	`)
+
+func TestForwarded(t *testing.T) {
+	gw := maketestRouter(testconfigForwards).Gateways["bridge1"]
+	bridgers := make(map[string]*chanBridger)
+	for account, br := range gw.Bridges {
+		bridgers[account] = &chanBridger{sent: make(chan config.Message, 10)}
+		br.Bridger = bridgers[account]
+	}
+	relay := func(msg config.Message) {
+		for _, account := range []string{"irc.freenode", "discord.test"} {
+			msg := msg
+			gw.handleMessage(&msg, gw.Bridges[account])
+		}
+	}
+	receive := func(account string) config.Message {
+		select {
+		case msg := <-bridgers[account].sent:
+			return msg
+		case <-time.After(time.Second):
+			t.Fatalf("nothing sent to %s", account)
+		}
+		return config.Message{}
+	}
+
+	relay(config.Message{
+		Text: "release 1.0", Username: "wim", Channel: "-100", Account: "telegram.test", Protocol: "telegram", Gateway: "bridge1",
+		ForwardedFrom: "Announcements", Extra: map[string][]interface{}{config.ExtraForwarded: {true}},
+	})
+	assert.Equal(t, "Forwarded from Announcements: release 1.0", receive("irc.freenode").Text)
+	msg := receive("discord.test")
+	assert.Equal(t, "release 1.0 (fwd Announcements)", msg.Text)
+	assert.Equal(t, "Announcements", msg.ForwardedFrom)
+
+	// messages that aren't forwards are relayed as they are
+	relay(config.Message{
+		Text: "hi", Username: "wim", Channel: "-100", Account: "telegram.test", Protocol: "telegram", Gateway: "bridge1",
+	})
+	assert.Equal(t, "hi", receive("irc.freenode").Text)
+	assert.Equal(t, "hi", receive("discord.test").Text)
+}
+
+func TestDropForward(t *testing.T) {
+	forwarded := map[string][]interface{}{config.ExtraForwarded: {true}}
+	known := &config.Message{ForwardedFrom: "Announcements", Extra: forwarded}
+	hidden := &config.Message{ForwardedFrom: config.ForwardedUnknown, Extra: forwarded}
+	unnamed := &config.Message{Extra: forwarded}
+	plain := &config.Message{Text: "hi"}
+
+	for _, tc := range []struct {
+		opts    config.ChannelOptions
+		dropped []*config.Message
+	}{
+		{config.ChannelOptions{}, nil},
+		{config.ChannelOptions{DropForwards: true}, []*config.Message{known, hidden, unnamed}},
+		{config.ChannelOptions{DropUnknownForwards: true}, []*config.Message{hidden, unnamed}},
+		{config.ChannelOptions{AllowedForwardSources: []string{"announcements"}}, []*config.Message{hidden, unnamed}},
+		{config.ChannelOptions{AllowedForwardSources: []string{"news", config.ForwardedUnknown}}, []*config.Message{known, hidden, unnamed}},
+	} {
+		for _, msg := range []*config.Message{known, hidden, unnamed, plain} {
+			assert.Equal(t, containsMessage(tc.dropped, msg), dropForward(msg, &tc.opts), "%+v %+v", tc.opts, msg)
+		}
+	}
+}
+
+func containsMessage(msgs []*config.Message, msg *config.Message) bool {
+	for _, m := range msgs {
+		if m == msg {
+			return true
+		}
+	}
+	return false
+}
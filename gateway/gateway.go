@@ -1,6 +1,7 @@
 package gateway
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -10,9 +11,11 @@ import (
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/gateway/bridgemap"
+	"github.com/42wim/matterbridge/gateway/events"
 	"github.com/42wim/matterbridge/internal"
 	"github.com/d5/tengo/v2"
-	"github.com/d5/tengo/v2/stdlib"
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/kyokomi/emoji/v2"
 	"github.com/sirupsen/logrus"
@@ -30,7 +33,22 @@ type Gateway struct {
 	Name           string
 	Messages       *lru.Cache
 
-	logger *logrus.Entry
+	moderation *moderation
+	edits      *edits
+	summaries  *summaries
+	noise      *noiseAggregates
+	quiet      *quietHolds
+	summarizer *summarizer
+	alerts     *alerts
+	spam       *spam
+	mentions   *mentions
+	migration  *migration
+	typing     *typingLimits
+	slowmodes  *slowmodes
+	quits      *quits
+	quotes     *lru.Cache
+	texts      *lru.Cache
+	logger     *logrus.Entry
 }
 
 type BrMsgID struct {
@@ -46,15 +64,27 @@ const apiProtocol = "api"
 func New(rootLogger *logrus.Logger, cfg *config.Gateway, r *Router) *Gateway {
 	logger := rootLogger.WithFields(logrus.Fields{"prefix": "gateway"})
 
-	cache, _ := lru.New(5000)
+	general := &r.BridgeValues().General
+	cache, _ := lru.New(helper.CacheSize(general, 5000))
+	quotes, _ := lru.New(helper.CacheSize(general, reactionQuoteCacheSize))
+	texts, _ := lru.New(helper.CacheSize(general, editTextCacheSize))
 	gw := &Gateway{
-		Channels: make(map[string]*config.ChannelInfo),
-		Message:  r.Message,
-		Router:   r,
-		Bridges:  make(map[string]*bridge.Bridge),
-		Config:   r.Config,
-		Messages: cache,
-		logger:   logger,
+		Channels:  make(map[string]*config.ChannelInfo),
+		Message:   r.Message,
+		Router:    r,
+		Bridges:   make(map[string]*bridge.Bridge),
+		Config:    r.Config,
+		Messages:  cache,
+		edits:     &edits{pending: make(map[string]*pendingEdit)},
+		summaries: &summaries{channels: make(map[string]*summary)},
+		noise:     &noiseAggregates{channels: make(map[string]*noiseAggregate)},
+		quiet:     &quietHolds{channels: make(map[string]*quietHold)},
+		typing:    newTypingLimits(),
+		slowmodes: newSlowmodes(),
+		quits:     &quits{accounts: make(map[string][]config.Message)},
+		quotes:    quotes,
+		texts:     texts,
+		logger:    logger,
 	}
 	if err := gw.AddConfig(cfg); err != nil {
 		logger.Errorf("Failed to add configuration to gateway: %#v", err)
@@ -65,7 +95,7 @@ func New(rootLogger *logrus.Logger, cfg *config.Gateway, r *Router) *Gateway {
 // FindCanonicalMsgID returns the ID under which a message was stored in the cache.
 func (gw *Gateway) FindCanonicalMsgID(protocol string, mID string) string {
 	ID := protocol + " " + mID
-	if gw.Messages.Contains(ID) {
+	if _, ok := gw.getMsgIDs(ID); ok {
 		return ID
 	}
 
@@ -79,7 +109,7 @@ func (gw *Gateway) FindCanonicalMsgID(protocol string, mID string) string {
 			}
 		}
 	}
-	return ""
+	return gw.storedCanonicalMsgID(ID)
 }
 
 // AddBridge sets up a new bridge in the gateway object with the specified configuration.
@@ -90,6 +120,10 @@ func (gw *Gateway) AddBridge(cfg *config.Bridge) error {
 		br = bridge.New(cfg)
 		br.Config = gw.Router.Config
 		br.General = &gw.BridgeValues().General
+		br.Store = gw.Router.Store
+		br.MessageMap = gw.Router
+		br.Commands = gw.Router
+		br.Login = gw.Router
 		br.Log = gw.logger.WithFields(logrus.Fields{"prefix": br.Protocol})
 		brconfig := &bridge.Config{
 			Remote: gw.Message,
@@ -97,7 +131,11 @@ func (gw *Gateway) AddBridge(cfg *config.Bridge) error {
 		}
 		// add the actual bridger for this protocol to this bridge using the bridgeMap
 		if _, ok := gw.Router.BridgeMap[br.Protocol]; !ok {
-			gw.logger.Fatalf("Incorrect protocol %s specified in gateway configuration %s, exiting.", br.Protocol, cfg.Account)
+			gw.logger.Fatalf("Incorrect protocol %s specified in gateway configuration %s, this build supports %s, exiting.",
+				br.Protocol, cfg.Account, strings.Join(gw.Router.protocols(), ", "))
+		}
+		if reason, ok := bridgemap.Deprecated[br.Protocol]; ok {
+			gw.logger.Warnf("The %s bridge is deprecated and will be removed in a next release: %s", br.Protocol, reason)
 		}
 		br.Bridger = gw.Router.BridgeMap[br.Protocol](brconfig)
 	}
@@ -107,18 +145,20 @@ func (gw *Gateway) AddBridge(cfg *config.Bridge) error {
 }
 
 func (gw *Gateway) checkConfig(cfg *config.Bridge) {
-	match := false
-	for _, key := range gw.Router.Config.Viper().AllKeys() {
-		if strings.HasPrefix(key, strings.ToLower(cfg.Account)) {
-			match = true
-			break
-		}
-	}
-	if !match {
+	if !hasAccountConfig(gw.Router.Config, cfg.Account) {
 		gw.logger.Fatalf("Account %s defined in gateway %s but no configuration found, exiting.", cfg.Account, gw.Name)
 	}
 }
 
+func hasAccountConfig(cfg config.Config, account string) bool {
+	for _, key := range cfg.Viper().AllKeys() {
+		if strings.HasPrefix(key, strings.ToLower(account)) {
+			return true
+		}
+	}
+	return false
+}
+
 // AddConfig associates a new configuration with the gateway object.
 func (gw *Gateway) AddConfig(cfg *config.Gateway) error {
 	gw.Name = cfg.Name
@@ -133,13 +173,34 @@ func (gw *Gateway) AddConfig(cfg *config.Gateway) error {
 			return err
 		}
 	}
-	return nil
+	if err := gw.addLoadBalancing(); err != nil {
+		return err
+	}
+	if err := gw.addModeration(); err != nil {
+		return err
+	}
+	if err := gw.addSummarizer(); err != nil {
+		return err
+	}
+	if err := gw.addMigration(); err != nil {
+		return err
+	}
+	if err := gw.checkSlowmode(); err != nil {
+		return err
+	}
+	if err := gw.addSpam(); err != nil {
+		return err
+	}
+	if err := gw.addMentions(); err != nil {
+		return err
+	}
+	return gw.addAlerts()
 }
 
 func (gw *Gateway) mapChannelsToBridge(br *bridge.Bridge) {
-	for ID, channel := range gw.Channels {
+	for _, channel := range gw.Channels {
 		if br.Account == channel.Account {
-			br.Channels[ID] = *channel
+			br.SetChannel(*channel)
 		}
 	}
 }
@@ -148,19 +209,23 @@ func (gw *Gateway) reconnectBridge(br *bridge.Bridge) {
 	if err := br.Disconnect(); err != nil {
 		gw.logger.Errorf("Disconnect() %s failed: %s", br.Account, err)
 	}
+	gw.Router.Events.Publish(events.Event{Kind: events.BridgeDisconnected, Gateway: gw.Name, Account: br.Account})
 	time.Sleep(time.Second * 5)
 RECONNECT:
 	gw.logger.Infof("Reconnecting %s", br.Account)
-	err := br.Connect()
+	err := withBridgeLabel(br, br.Connect)
 	if err != nil {
 		gw.logger.Errorf("Reconnection failed: %s. Trying again in 60 seconds", err)
 		time.Sleep(time.Second * 60)
 		goto RECONNECT
 	}
+	gw.Router.Events.Publish(events.Event{Kind: events.BridgeConnected, Gateway: gw.Name, Account: br.Account})
 	br.Joined = make(map[string]bool)
-	if err := br.JoinChannels(); err != nil {
+	if err := withBridgeLabel(br, br.JoinChannels); err != nil {
 		gw.logger.Errorf("JoinChannels() %s failed: %s", br.Account, err)
 	}
+	gw.Router.requestReplay(br)
+	gw.Router.goBridge(gw.Router.backfill, br)
 }
 
 func (gw *Gateway) mapChannelConfig(cfg []config.Bridge, direction string) {
@@ -191,12 +256,14 @@ func (gw *Gateway) mapChannelConfig(cfg []config.Bridge, direction string) {
 				SameChannel: make(map[string]bool),
 			}
 			channel.SameChannel[gw.Name] = br.SameChannel
+			channel.Untrusted = br.Untrusted
 			gw.Channels[channel.ID] = channel
 		} else {
 			// if we already have a key and it's not our current direction it means we have a bidirectional inout
 			if gw.Channels[ID].Direction != direction {
 				gw.Channels[ID].Direction = "inout"
 			}
+			gw.Channels[ID].Untrusted = gw.Channels[ID].Untrusted || br.Untrusted
 		}
 		gw.Channels[ID].SameChannel[gw.Name] = br.SameChannel
 	}
@@ -217,14 +284,21 @@ func (gw *Gateway) getDestChannel(msg *config.Message, dest bridge.Bridge) []con
 		return channels
 	}
 
-	// discord join/leave is for the whole bridge, isn't a per channel join/leave
-	if msg.Event == config.EventJoinLeave && getProtocol(msg) == "discord" && msg.Channel == "" {
+	now := time.Now()
+	// join/leave, calls and nick changes without a channel are for the whole bridge, like
+	// discord joins, irc quits and discord voice channels
+	if bridgeWideEvent(msg.Event) && msg.Channel == "" {
 		for _, channel := range gw.Channels {
-			if channel.Account == dest.Account && strings.Contains(channel.Direction, "out") &&
-				gw.validGatewayDest(msg) {
+			if channel.Account == dest.Account && dest.Account != msg.Account &&
+				strings.Contains(channel.Direction, "out") && gw.validGatewayDest(msg) {
 				channels = append(channels, *channel)
 			}
 		}
+		return gw.migration.filter(channels, now)
+	}
+
+	// the channels of a migration that aren't read have no destinations
+	if !gw.migration.reads(getChannelID(msg), now) {
 		return channels
 	}
 
@@ -255,12 +329,11 @@ func (gw *Gateway) getDestChannel(msg *config.Message, dest bridge.Bridge) []con
 			channels = append(channels, *channel)
 		}
 	}
-	return channels
+	return gw.migration.filter(channels, now)
 }
 
 func (gw *Gateway) getDestMsgID(msgID string, dest *bridge.Bridge, channel *config.ChannelInfo) string {
-	if res, ok := gw.Messages.Get(msgID); ok {
-		IDs := res.([]*BrMsgID)
+	if IDs, ok := gw.getMsgIDs(msgID); ok {
 		for _, id := range IDs {
 			// check protocol, bridge name and channelname
 			// for people that reuse the same bridge multiple times. see #342
@@ -272,6 +345,32 @@ func (gw *Gateway) getDestMsgID(msgID string, dest *bridge.Bridge, channel *conf
 	return ""
 }
 
+// destThreadID returns the ID on dest of the root message of the thread rmsg is in. It's
+// empty when rmsg isn't in a thread, dest doesn't preserve threading or the root message
+// isn't known on dest, the message is then sent in the channel.
+func (gw *Gateway) destThreadID(rmsg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo) string {
+	if rmsg.ThreadID == "" || rmsg.ThreadID == rmsg.ID || !dest.GetBool("PreserveThreading") {
+		return ""
+	}
+	canonical := gw.FindCanonicalMsgID(rmsg.Protocol, rmsg.ThreadID)
+	if canonical == "" {
+		return ""
+	}
+	return gw.translateMsgID(canonical, dest, channel)
+}
+
+// translateMsgID returns the ID on dest of the canonical message, also when the message
+// was sent on dest. It's empty when the message isn't known on dest.
+func (gw *Gateway) translateMsgID(canonical string, dest *bridge.Bridge, channel *config.ChannelInfo) string {
+	if id := gw.getDestMsgID(canonical, dest, channel); id != "" {
+		return id
+	}
+	if strings.HasPrefix(canonical, dest.Protocol+" ") {
+		return strings.TrimPrefix(canonical, dest.Protocol+" ")
+	}
+	return ""
+}
+
 // ignoreTextEmpty returns true if we need to ignore a message with an empty text.
 func (gw *Gateway) ignoreTextEmpty(msg *config.Message) bool {
 	if msg.Text != "" {
@@ -296,13 +395,33 @@ func (gw *Gateway) ignoreMessage(msg *config.Message) bool {
 	if _, ok := gw.Bridges[msg.Account]; !ok {
 		return true
 	}
+	if gw.ignoreBalanced(msg) || gw.Router.isDisabled(msg.Account) {
+		return true
+	}
 
 	igNicks := strings.Fields(gw.Bridges[msg.Account].GetString("IgnoreNicks"))
 	igMessages := strings.Fields(gw.Bridges[msg.Account].GetString("IgnoreMessages"))
-	if gw.ignoreTextEmpty(msg) || gw.ignoreText(msg.Username, igNicks) || gw.ignoreText(msg.Text, igMessages) || gw.ignoreFilesComment(msg.Extra, igMessages) {
+	if gw.ignoreTextEmpty(msg) || gw.ignoreText(msg.Username, igNicks) || gw.ignoreText(msg.Text, igMessages) || gw.ignoreFilesComment(msg.Extra, igMessages) ||
+		gw.ignoreRenamed(msg, igNicks) || gw.ignoreLinked(msg) || gw.Router.isOptedOut(msg) {
 		return true
 	}
 
+	return !gw.isRelayedUser(msg) || !gw.hasRequiredRole(msg)
+}
+
+// isRelayedUser returns false when the gateway relays only the messages of OnlyUsers and
+// msg isn't from one of them. Deletes are relayed, only relayed messages can be deleted.
+func (gw *Gateway) isRelayedUser(msg *config.Message) bool {
+	users := gw.MyConfig.OnlyUsers
+	if len(users) == 0 || msg.Event == config.EventMsgDelete {
+		return true
+	}
+	for _, user := range users {
+		if user == msg.Username || user == msg.UserID {
+			return true
+		}
+	}
+	gw.logger.Debugf("ignoring message from %s on %s, not one of the users of gateway %s", msg.Username, msg.Account, gw.Name)
 	return false
 }
 
@@ -316,7 +435,7 @@ func (gw *Gateway) ignoreFilesComment(extra map[string][]interface{}, igMessages
 		if !ok {
 			continue
 		}
-		if gw.ignoreText(fi.Comment, igMessages) {
+		if gw.ignoreText(fi.CaptionText(), igMessages) {
 			return true
 		}
 	}
@@ -328,7 +447,7 @@ func (gw *Gateway) modifyUsername(msg *config.Message, dest *bridge.Bridge) stri
 		re := regexp.MustCompile("[^a-zA-Z0-9]+")
 		msg.Username = re.ReplaceAllString(msg.Username, "")
 	}
-	nick := dest.GetString("RemoteNickFormat")
+	nick := gw.classSetting(msg, dest, "RemoteNickFormat")
 
 	// loop to replace nicks
 	br := gw.Bridges[msg.Account]
@@ -364,6 +483,7 @@ func (gw *Gateway) modifyUsername(msg *config.Message, dest *bridge.Bridge) stri
 	nick = strings.ReplaceAll(nick, "{NICK}", msg.Username)
 	nick = strings.ReplaceAll(nick, "{USERID}", msg.UserID)
 	nick = strings.ReplaceAll(nick, "{CHANNEL}", msg.Channel)
+	nick = strings.ReplaceAll(nick, "{TIMESTAMP}", gw.formatTimestamp(msg, dest))
 	tengoNick, err := gw.modifyUsernameTengo(msg, br)
 	if err != nil {
 		gw.logger.Errorf("modifyUsernameTengo error: %s", err)
@@ -386,7 +506,7 @@ func (gw *Gateway) modifyMessage(msg *config.Message) {
 		gw.logger.Warnf("General TengoModifyMessage=%s is deprecated and will be removed in v1.20.0, please move to Tengo InMessage=%s", gw.BridgeValues().General.TengoModifyMessage, gw.BridgeValues().General.TengoModifyMessage)
 	}
 
-	if err := modifyInMessageTengo(gw.BridgeValues().General.TengoModifyMessage, msg); err != nil {
+	if err := gw.modifyInMessage(gw.BridgeValues().General.TengoModifyMessage, msg); err != nil {
 		gw.logger.Errorf("TengoModifyMessage failed: %s", err)
 	}
 
@@ -398,7 +518,7 @@ func (gw *Gateway) modifyMessage(msg *config.Message) {
 		}
 	}
 
-	if err := modifyInMessageTengo(inMessage, msg); err != nil {
+	if err := gw.modifyInMessage(inMessage, msg); err != nil {
 		gw.logger.Errorf("Tengo.Message failed: %s", err)
 	}
 
@@ -449,6 +569,10 @@ func (gw *Gateway) SendMessage(
 		}
 	}
 
+	if isTypingOrPresence(rmsg) && !gw.allowTyping(rmsg, channel.ID) {
+		return "", nil
+	}
+
 	// Only send irc notices to irc
 	if msg.Event == config.EventNoticeIRC && dest.Protocol != "irc" {
 		return "", nil
@@ -460,29 +584,67 @@ func (gw *Gateway) SendMessage(
 		debugSendMessage = fmt.Sprintf("=> Sending %#v from %s (%s) to %s (%s)", msg, msg.Account, rmsg.Channel, dest.Account, channel.Name)
 	}
 
+	msg.ParentID = gw.getDestMsgID(canonicalParentMsgID, dest, channel)
+	if msg.ParentID == "" {
+		msg.ParentID = strings.Replace(canonicalParentMsgID, dest.Protocol+" ", "", 1)
+	}
+
+	// if the parentID is still empty and we have a parentID set in the original message
+	// this means that we didn't find it in the cache so set it to a "msg-parent-not-found" constant
+	if msg.ParentID == "" && rmsg.ParentID != "" {
+		msg.ParentID = config.ParentIDNotFound
+	}
+
+	if isReaction(rmsg) && !gw.prepareReaction(rmsg, &msg, dest, canonicalParentMsgID) {
+		return "", nil
+	}
+
+	msg.ThreadID = gw.destThreadID(rmsg, dest, channel)
+
 	msg.Channel = channel.Name
-	msg.Avatar = gw.modifyAvatar(rmsg, dest)
-	msg.Username = gw.modifyUsername(rmsg, dest)
+	msg.Avatar = gw.mediaAvatar(gw.modifyAvatar(rmsg, dest), dest)
+	msg.Username = gw.modifyUsername(gw.withIdentityName(rmsg), dest)
+	formatCall(&msg, dest)
+	formatNickChange(&msg)
+	msg.Text = gw.translateMentions(rmsg, &msg, dest)
+	msg.Text = gw.quoteReply(rmsg, &msg, dest, canonicalParentMsgID)
+	msg.Text = gw.addForwarded(rmsg, &msg, dest)
+	msg.Text = gw.addPermalink(rmsg, &msg)
+	msg.Text = gw.addPriorityMarker(rmsg, &msg, dest, channel)
+	msg.Text = gw.applyMessageTemplate(rmsg, &msg, dest)
+	msg.Text = gw.addDelayedTimestamp(rmsg, &msg, dest)
 
 	// exclude file delete event as the msg ID here is the native file ID that needs to be deleted
 	if msg.Event != config.EventFileDelete {
 		msg.ID = gw.getDestMsgID(rmsg.Protocol+" "+rmsg.ID, dest, channel)
 	}
 
+	if err := gw.renderEdit(rmsg, &msg, dest); err != nil {
+		gw.publishDropped(&msg, dest, channel, err)
+		return "", nil
+	}
+
 	// for api we need originchannel as channel
 	if dest.Protocol == apiProtocol {
 		msg.Channel = rmsg.Channel
 	}
 
-	msg.ParentID = gw.getDestMsgID(canonicalParentMsgID, dest, channel)
-	if msg.ParentID == "" {
-		msg.ParentID = strings.Replace(canonicalParentMsgID, dest.Protocol+" ", "", 1)
+	gw.withThumbnails(&msg, dest)
+	gw.limitMedia(&msg, dest)
+
+	if gw.applyContentPolicy(&msg, channel) {
+		gw.publishDropped(&msg, dest, channel, errDroppedPolicy)
+		return "", nil
 	}
 
-	// if the parentID is still empty and we have a parentID set in the original message
-	// this means that we didn't find it in the cache so set it to a "msg-parent-not-found" constant
-	if msg.ParentID == "" && rmsg.ParentID != "" {
-		msg.ParentID = config.ParentIDNotFound
+	if gw.filterNoise(rmsg, &msg, dest, channel) {
+		gw.publishDropped(&msg, dest, channel, errDroppedNoise)
+		return "", nil
+	}
+
+	if gw.summarize(rmsg, &msg, dest, channel) {
+		gw.publishDropped(&msg, dest, channel, errDroppedSummary)
+		return "", nil
 	}
 
 	drop, err := gw.modifyOutMessageTengo(rmsg, &msg, dest)
@@ -492,6 +654,13 @@ func (gw *Gateway) SendMessage(
 
 	if drop {
 		gw.logger.Debugf("=> Tengo dropping %#v from %s (%s) to %s (%s)", msg, msg.Account, rmsg.Channel, dest.Account, channel.Name)
+		gw.publishDropped(&msg, dest, channel, errDroppedTengo)
+		return "", nil
+	}
+
+	gw.withFileData(&msg, dest)
+
+	if gw.slowdown(rmsg, &msg, dest, channel) || gw.holdQuiet(rmsg, &msg, dest, channel) || gw.rateLimit(rmsg, &msg, dest, channel) {
 		return "", nil
 	}
 
@@ -504,11 +673,23 @@ func (gw *Gateway) SendMessage(
 		gw.Router.MattermostPlugin <- msg
 	}
 
-	defer func(t time.Time) {
-		gw.logger.Debugf("=> Send from %s (%s) to %s (%s) took %s", msg.Account, rmsg.Channel, dest.Account, channel.Name, time.Since(t))
-	}(time.Now())
+	start := time.Now()
+	sender := gw.balance(dest, channel)
+	if captions := gw.renderCaptions(&msg, dest); captions != "" {
+		gw.sendCaptions(&msg, sender, captions)
+	}
+	mID, err := gw.Router.send(sender, msg)
+	if err == nil && mID != "" && sender != dest {
+		gw.sentBalanced(dest, mID)
+	}
+	took := time.Since(start)
+	gw.logger.Debugf("=> Send from %s (%s) to %s (%s) took %s", msg.Account, rmsg.Channel, dest.Account, channel.Name, took)
 
-	mID, err := dest.Send(msg)
+	ev := events.Event{Kind: events.MessageRelayed, Gateway: gw.Name, Account: dest.Account, Channel: channel.Name, Message: &msg, MessageID: mID, Duration: took}
+	if err != nil {
+		ev.Kind, ev.Err = events.SendFailed, err
+	}
+	gw.Router.Events.Publish(ev)
 	if err != nil {
 		return mID, err
 	}
@@ -522,6 +703,18 @@ func (gw *Gateway) SendMessage(
 	return "", nil
 }
 
+var (
+	errDroppedPolicy = errors.New("content policy")
+	errDroppedTengo  = errors.New("tengo script")
+)
+
+// publishDropped publishes that msg wasn't sent to channel of dest because of reason.
+func (gw *Gateway) publishDropped(msg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo, reason error) {
+	gw.Router.Events.Publish(events.Event{
+		Kind: events.MessageDropped, Gateway: gw.Name, Account: dest.Account, Channel: channel.Name, Message: msg, Err: reason,
+	})
+}
+
 func (gw *Gateway) validGatewayDest(msg *config.Message) bool {
 	return msg.Gateway == gw.Name
 }
@@ -554,12 +747,15 @@ func (gw *Gateway) ignoreText(text string, input []string) bool {
 	return false
 }
 
-func getProtocol(msg *config.Message) string {
-	p := strings.Split(msg.Account, ".")
-	return p[0]
+// modifyInMessage runs the InMessage script filename on msg, with lua when it's a .lua file.
+func (gw *Gateway) modifyInMessage(filename string, msg *config.Message) error {
+	if isLuaScript(filename) {
+		return gw.modifyInMessageLua(filename, msg)
+	}
+	return modifyInMessageTengo(filename, msg, gw.tengoImports())
 }
 
-func modifyInMessageTengo(filename string, msg *config.Message) error {
+func modifyInMessageTengo(filename string, msg *config.Message, imports *tengo.ModuleMap) error {
 	if filename == "" {
 		return nil
 	}
@@ -568,7 +764,7 @@ func modifyInMessageTengo(filename string, msg *config.Message) error {
 		return err
 	}
 	s := tengo.NewScript(res)
-	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	s.SetImports(imports)
 	_ = s.Add("msgText", msg.Text)
 	_ = s.Add("msgUsername", msg.Username)
 	_ = s.Add("msgUserID", msg.UserID)
@@ -591,12 +787,15 @@ func (gw *Gateway) modifyUsernameTengo(msg *config.Message, br *bridge.Bridge) (
 	if filename == "" {
 		return "", nil
 	}
+	if isLuaScript(filename) {
+		return gw.modifyUsernameLua(filename, msg, br)
+	}
 	res, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return "", err
 	}
 	s := tengo.NewScript(res)
-	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	s.SetImports(gw.tengoImports())
 	_ = s.Add("result", "")
 	_ = s.Add("msgText", msg.Text)
 	_ = s.Add("msgUsername", msg.Username)
@@ -620,6 +819,15 @@ func (gw *Gateway) modifyUsernameTengo(msg *config.Message, br *bridge.Bridge) (
 	return c.Get("result").String(), nil
 }
 
+// scriptEvent returns event as the scripts see it: with LegacyJoinLeave the join, part, quit,
+// kick and ban events are all join_leave.
+func (gw *Gateway) scriptEvent(event string) string {
+	if gw.BridgeValues().General.LegacyJoinLeave {
+		return config.LegacyEvent(event)
+	}
+	return event
+}
+
 func (gw *Gateway) modifyOutMessageTengo(origmsg *config.Message, msg *config.Message, br *bridge.Bridge) (bool, error) {
 	filename := gw.BridgeValues().Tengo.OutMessage
 	var (
@@ -628,6 +836,10 @@ func (gw *Gateway) modifyOutMessageTengo(origmsg *config.Message, msg *config.Me
 		drop bool
 	)
 
+	if isLuaScript(filename) {
+		return gw.modifyOutMessageLua(filename, origmsg, msg, br)
+	}
+
 	if filename == "" {
 		res, err = internal.Asset("tengo/outmessage.tengo")
 		if err != nil {
@@ -642,17 +854,17 @@ func (gw *Gateway) modifyOutMessageTengo(origmsg *config.Message, msg *config.Me
 
 	s := tengo.NewScript(res)
 
-	s.SetImports(stdlib.GetModuleMap(stdlib.AllModuleNames()...))
+	s.SetImports(gw.tengoImports())
 	_ = s.Add("inAccount", origmsg.Account)
 	_ = s.Add("inProtocol", origmsg.Protocol)
 	_ = s.Add("inChannel", origmsg.Channel)
 	_ = s.Add("inGateway", origmsg.Gateway)
-	_ = s.Add("inEvent", origmsg.Event)
+	_ = s.Add("inEvent", gw.scriptEvent(origmsg.Event))
 	_ = s.Add("outAccount", br.Account)
 	_ = s.Add("outProtocol", br.Protocol)
 	_ = s.Add("outChannel", msg.Channel)
 	_ = s.Add("outGateway", gw.Name)
-	_ = s.Add("outEvent", msg.Event)
+	_ = s.Add("outEvent", gw.scriptEvent(msg.Event))
 	_ = s.Add("msgText", msg.Text)
 	_ = s.Add("msgUsername", msg.Username)
 	_ = s.Add("msgUserID", msg.UserID)
@@ -498,12 +498,81 @@ func (s *ignoreTestSuite) TestIgnoreNicks() {
 	}
 }
 
+func (s *ignoreTestSuite) TestIsRelayedUser() {
+	gw := &Gateway{logger: s.gw.logger, MyConfig: &config.Gateway{}}
+	msgTests := map[string]struct {
+		users  []string
+		input  *config.Message
+		output bool
+	}{
+		"no users": {
+			input:  &config.Message{Username: "user"},
+			output: true,
+		},
+		"username": {
+			users:  []string{"abc", "user"},
+			input:  &config.Message{Username: "user", UserID: "123"},
+			output: true,
+		},
+		"user ID": {
+			users:  []string{"123"},
+			input:  &config.Message{Username: "user", UserID: "123"},
+			output: true,
+		},
+		"other user": {
+			users:  []string{"abc"},
+			input:  &config.Message{Username: "user", UserID: "123"},
+			output: false,
+		},
+		"delete": {
+			users:  []string{"abc"},
+			input:  &config.Message{Event: config.EventMsgDelete},
+			output: true,
+		},
+	}
+	for testname, testcase := range msgTests {
+		gw.MyConfig.OnlyUsers = testcase.users
+		output := gw.isRelayedUser(testcase.input)
+		s.Assert().Equalf(testcase.output, output, "case '%s' failed", testname)
+	}
+}
+
 func BenchmarkTengo(b *testing.B) {
 	msg := &config.Message{Username: "user", Text: "blah testing", Account: "protocol.account", Channel: "mychannel"}
 	for n := 0; n < b.N; n++ {
-		err := modifyInMessageTengo("bench.tengo", msg)
+		err := modifyInMessageTengo("bench.tengo", msg, (&Gateway{}).tengoImports())
 		if err != nil {
 			return
 		}
 	}
 }
+
+func TestDestThreadID(t *testing.T) {
+	r := maketestRouter(append([]byte("[general]\nPreserveThreading=true\n"), testconfig...))
+	gw := r.Gateways["bridge1"]
+	discord, slack := gw.Bridges["discord.test"], gw.Bridges["slack.test"]
+	discordChannel, slackChannel := gw.Channels["generaldiscord.test"], gw.Channels["testingslack.test"]
+
+	// the root of the thread was sent on slack and relayed to discord
+	gw.addMsgIDs("slack 1.1", []*BrMsgID{{discord, "discord 10", discordChannel.ID}})
+
+	reply := &config.Message{ID: "1.2", ThreadID: "1.1", ParentID: "1.1", Protocol: "slack", Account: "slack.test"}
+	assert.Equal(t, "10", gw.destThreadID(reply, discord, discordChannel))
+	assert.Equal(t, "1.1", gw.destThreadID(reply, slack, slackChannel))
+
+	// replies from discord in the thread
+	reply = &config.Message{ID: "11", ThreadID: "10", Protocol: "discord", Account: "discord.test"}
+	assert.Equal(t, "1.1", gw.destThreadID(reply, slack, slackChannel))
+
+	// unknown threads and the root message itself are sent in the channel
+	assert.Empty(t, gw.destThreadID(&config.Message{ID: "2.2", ThreadID: "2.1", Protocol: "slack"}, discord, discordChannel))
+	assert.Empty(t, gw.destThreadID(&config.Message{ID: "1.1", ThreadID: "1.1", Protocol: "slack"}, discord, discordChannel))
+	assert.Empty(t, gw.destThreadID(&config.Message{ID: "1.3", Protocol: "slack"}, discord, discordChannel))
+
+	// threading must be enabled
+	r = maketestRouter(testconfig)
+	gw = r.Gateways["bridge1"]
+	gw.addMsgIDs("slack 1.1", []*BrMsgID{{gw.Bridges["discord.test"], "discord 10", discordChannel.ID}})
+	reply = &config.Message{ID: "1.2", ThreadID: "1.1", Protocol: "slack"}
+	assert.Empty(t, gw.destThreadID(reply, gw.Bridges["discord.test"], gw.Channels["generaldiscord.test"]))
+}
@@ -0,0 +1,331 @@
+package gateway
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/d5/tengo/v2"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// scriptMessage returns the fields of msg the Script of a gateway can read and change. The
+// files of msg are in extra.file, with the index of the file in msg as id.
+func scriptMessage(msg *config.Message) map[string]interface{} {
+	m := map[string]interface{}{
+		"text":           msg.Text,
+		"channel":        msg.Channel,
+		"username":       msg.Username,
+		"userid":         msg.UserID,
+		"avatar":         msg.Avatar,
+		"account":        msg.Account,
+		"event":          msg.Event,
+		"protocol":       msg.Protocol,
+		"gateway":        msg.Gateway,
+		"parent_id":      msg.ParentID,
+		"thread_id":      msg.ThreadID,
+		"id":             msg.ID,
+		"timestamp":      msg.Timestamp.Unix(),
+		"forwarded_from": msg.ForwardedFrom,
+	}
+	extra := make(map[string]interface{})
+	for name, values := range msg.Extra {
+		var items []interface{}
+		for i, v := range values {
+			switch v := v.(type) {
+			case config.FileInfo:
+				items = append(items, map[string]interface{}{
+					"id":      int64(i),
+					"name":    v.Name,
+					"comment": v.CaptionText(),
+					"url":     v.URL,
+					"size":    v.Size,
+					"sha":     v.SHA,
+					"avatar":  v.Avatar,
+				})
+			case string:
+				items = append(items, v)
+			}
+		}
+		if len(items) > 0 {
+			extra[name] = items
+		}
+	}
+	m["extra"] = extra
+	return m
+}
+
+// scriptResult returns the message m returned by a script, orig is the message the script
+// ran on and has the values of the keys missing in m. Files with the id of a file of orig
+// keep its content, other files are sent as a link to their url. The extra entries that
+// aren't files or strings are kept.
+func scriptResult(orig *config.Message, m map[string]interface{}) config.Message {
+	msg := *orig
+	str := func(key string, s *string) {
+		if v, ok := m[key].(string); ok {
+			*s = v
+		}
+	}
+	str("text", &msg.Text)
+	str("channel", &msg.Channel)
+	str("username", &msg.Username)
+	str("userid", &msg.UserID)
+	str("avatar", &msg.Avatar)
+	str("account", &msg.Account)
+	str("event", &msg.Event)
+	str("protocol", &msg.Protocol)
+	str("gateway", &msg.Gateway)
+	str("parent_id", &msg.ParentID)
+	str("thread_id", &msg.ThreadID)
+	str("forwarded_from", &msg.ForwardedFrom)
+	str("id", &msg.ID)
+
+	extra, ok := m["extra"].(map[string]interface{})
+	if !ok {
+		return msg
+	}
+	msg.Extra = make(map[string][]interface{})
+	for name, values := range orig.Extra {
+		for _, v := range values {
+			switch v.(type) {
+			case config.FileInfo, string:
+			default:
+				msg.Extra[name] = append(msg.Extra[name], v)
+			}
+		}
+	}
+	for name, values := range extra {
+		items, _ := values.([]interface{})
+		for _, item := range items {
+			switch item := item.(type) {
+			case string:
+				msg.Extra[name] = append(msg.Extra[name], item)
+			case map[string]interface{}:
+				msg.Extra[name] = append(msg.Extra[name], scriptFile(orig.Extra[name], item))
+			}
+		}
+	}
+	if len(msg.Extra) == 0 {
+		msg.Extra = nil
+	}
+	return msg
+}
+
+func scriptFile(files []interface{}, m map[string]interface{}) config.FileInfo {
+	var fi config.FileInfo
+	if id, ok := scriptInt(m["id"]); ok && id >= 0 && id < int64(len(files)) {
+		fi, _ = files[id].(config.FileInfo)
+	}
+	if s, ok := m["name"].(string); ok {
+		fi.Name = s
+	}
+	if s, ok := m["comment"].(string); ok {
+		fi.Caption, fi.Comment = s, s
+	}
+	if s, ok := m["url"].(string); ok {
+		fi.URL = s
+	}
+	return fi
+}
+
+func scriptInt(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// scriptMessages runs the Script of the gateway on msg and returns the messages to relay
+// instead of msg. Without a Script, or when it fails, msg is relayed as it is.
+func (gw *Gateway) scriptMessages(msg *config.Message) []config.Message {
+	filename := gw.MyConfig.Script
+	if filename == "" {
+		return []config.Message{*msg}
+	}
+	run := gw.runScriptTengo
+	if isLuaScript(filename) {
+		run = gw.runScriptLua
+	}
+	in, out, err := run(filename, scriptMessage(msg))
+	if err != nil {
+		gw.logger.Errorf("Script %s failed: %s", filename, err)
+		return []config.Message{*msg}
+	}
+	if out == nil {
+		return []config.Message{scriptResult(msg, in)}
+	}
+	msgs := make([]config.Message, 0, len(out))
+	for _, m := range out {
+		msgs = append(msgs, scriptResult(msg, m))
+	}
+	if len(msgs) == 0 {
+		gw.logger.Debugf("Script %s dropped message %s from %s", filename, msg.ID, msg.Account)
+	}
+	return msgs
+}
+
+// relayScriptMessages relays the messages the Script of the gateway returns for msg. A
+// message of which the script changed the gateway to another one is rerouted to it.
+func (gw *Gateway) relayScriptMessages(msg *config.Message) {
+	for _, smsg := range gw.scriptMessages(msg) {
+		smsg := smsg // scopelint
+		if smsg.Gateway != msg.Gateway {
+			target, ok := gw.Router.gateways()[smsg.Gateway]
+			if !ok {
+				gw.logger.Errorf("Script %s rerouted a message to unknown gateway %s", gw.MyConfig.Script, smsg.Gateway)
+				continue
+			}
+			target.rerouteMessage(&smsg)
+			continue
+		}
+		gw.checkAndRelay(&smsg)
+	}
+}
+
+// rerouteMessage sends msg, rerouted to the gateway by a Script, to the channels of the
+// gateway that receive messages. It's sent as it is, without RemoteNickFormat, and edits
+// of it aren't relayed.
+func (gw *Gateway) rerouteMessage(msg *config.Message) {
+	for _, ch := range gw.scriptChannels("") {
+		if ch.ID == getChannelID(msg) {
+			continue
+		}
+		rmsg := *msg
+		rmsg.Channel = ch.Name
+		rmsg.Account = ch.Account
+		rmsg.ID = ""
+		rmsg.ParentID = ""
+		rmsg.ThreadID = ""
+		if _, err := gw.Router.send(gw.Bridges[ch.Account], rmsg); err != nil {
+			gw.logger.Errorf("Script: failed to reroute message to %s on %s: %s", ch.Name, ch.Account, err)
+		}
+	}
+}
+
+// scriptMaps returns the maps of the messages array of a script, nil when it's not an array.
+func scriptMaps(v interface{}) ([]map[string]interface{}, error) {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("messages must be an array of messages")
+	}
+	maps := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("messages must be an array of messages")
+		}
+		maps = append(maps, m)
+	}
+	return maps, nil
+}
+
+// runScriptTengo runs the tengo script filename with the global msg and returns msg after
+// the script and the messages array, nil when the script didn't set it.
+func (gw *Gateway) runScriptTengo(filename string, msg map[string]interface{}) (map[string]interface{}, []map[string]interface{}, error) {
+	res, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := tengo.NewScript(res)
+	s.SetImports(gw.tengoImports())
+	if err := s.Add("msg", msg); err != nil {
+		return nil, nil, err
+	}
+	_ = s.Add("messages", nil)
+	c, err := s.Compile()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.Run(); err != nil {
+		return nil, nil, err
+	}
+	in := c.Get("msg").Map()
+	if c.Get("messages").IsUndefined() {
+		return in, nil, nil
+	}
+	out, err := scriptMaps(c.Get("messages").Value())
+	return in, out, err
+}
+
+// runScriptLua is the lua version of runScriptTengo, where messages is a table.
+func (gw *Gateway) runScriptLua(filename string, msg map[string]interface{}) (map[string]interface{}, []map[string]interface{}, error) {
+	L, err := gw.runLuaWith(filename, func(L *lua.LState) {
+		L.SetGlobal("msg", luaValue(L, msg))
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer L.Close()
+	in, _ := goValue(L.GetGlobal("msg")).(map[string]interface{})
+	messages := L.GetGlobal("messages")
+	if messages == lua.LNil {
+		return in, nil, nil
+	}
+	// an empty table is an empty array
+	v := goValue(messages)
+	if m, ok := v.(map[string]interface{}); ok && len(m) == 0 {
+		v = []interface{}{}
+	}
+	out, err := scriptMaps(v)
+	return in, out, err
+}
+
+// luaValue converts v, made of maps, arrays, strings, numbers and bools, to lua.
+func luaValue(L *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case string:
+		return lua.LString(v)
+	case bool:
+		return lua.LBool(v)
+	case int:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LNumber(v)
+	case []interface{}:
+		t := L.NewTable()
+		for _, item := range v {
+			t.Append(luaValue(L, item))
+		}
+		return t
+	case map[string]interface{}:
+		t := L.NewTable()
+		for key, item := range v {
+			t.RawSetString(key, luaValue(L, item))
+		}
+		return t
+	}
+	return lua.LNil
+}
+
+// goValue converts the lua value v to go, tables with only the keys 1 to n are arrays.
+func goValue(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case lua.LString:
+		return string(v)
+	case lua.LBool:
+		return bool(v)
+	case lua.LNumber:
+		return float64(v)
+	case *lua.LTable:
+		if n := v.MaxN(); n > 0 {
+			items := make([]interface{}, 0, n)
+			for i := 1; i <= n; i++ {
+				items = append(items, goValue(v.RawGetInt(i)))
+			}
+			return items
+		}
+		m := make(map[string]interface{})
+		v.ForEach(func(key, value lua.LValue) {
+			if s, ok := key.(lua.LString); ok {
+				m[string(s)] = goValue(value)
+			}
+		})
+		return m
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeScript(t *testing.T, name, script string) string {
+	filename := filepath.Join(t.TempDir(), name)
+	require.NoError(t, ioutil.WriteFile(filename, []byte(script), 0o600))
+	return filename
+}
+
+func scriptTestMessage() *config.Message {
+	data := []byte("hello")
+	return &config.Message{
+		Text: "hi", Username: "user", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Gateway: "bridge1",
+		Extra: map[string][]interface{}{"file": {config.FileInfo{Name: "a.txt", Data: &data, Comment: "a file"}}},
+	}
+}
+
+func TestScriptMessages(t *testing.T) {
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+
+	// without a script the message is relayed as it is
+	msg := scriptTestMessage()
+	assert.Equal(t, []config.Message{*msg}, gw.scriptMessages(msg))
+
+	scripts := map[string]string{
+		"tengo": `text := import("text")
+if msg.text == "drop" {
+	messages = []
+} else if msg.text == "split" {
+	messages = [{text: "one"}, {text: "two", extra: {}}]
+} else {
+	msg.text = text.to_upper(msg.text)
+	msg.event = "user_action"
+	msg.extra.file[0].comment = "renamed"
+	msg.extra.file = append(msg.extra.file, {name: "b.png", url: "https://example.com/b.png"})
+}`,
+		"lua": `if msg.text == "drop" then
+	messages = {}
+elseif msg.text == "split" then
+	messages = {{text = "one"}, {text = "two", extra = {}}}
+else
+	msg.text = string.upper(msg.text)
+	msg.event = "user_action"
+	msg.extra.file[1].comment = "renamed"
+	table.insert(msg.extra.file, {name = "b.png", url = "https://example.com/b.png"})
+end`,
+	}
+	for lang, script := range scripts {
+		gw.MyConfig.Script = writeScript(t, "gateway."+lang, script)
+
+		msgs := gw.scriptMessages(scriptTestMessage())
+		require.Len(t, msgs, 1, lang)
+		assert.Equal(t, "HI", msgs[0].Text, lang)
+		assert.Equal(t, config.EventUserAction, msgs[0].Event, lang)
+		assert.Equal(t, "user", msgs[0].Username, lang)
+		require.Len(t, msgs[0].Extra["file"], 2, lang)
+		fi := msgs[0].Extra["file"][0].(config.FileInfo)
+		assert.Equal(t, "renamed", fi.Comment, lang)
+		data, err := fi.Bytes()
+		assert.NoError(t, err, lang)
+		assert.Equal(t, "hello", string(data), lang)
+		assert.Equal(t, config.FileInfo{Name: "b.png", URL: "https://example.com/b.png"}, msgs[0].Extra["file"][1], lang)
+
+		msg := scriptTestMessage()
+		msg.Text = "split"
+		msgs = gw.scriptMessages(msg)
+		require.Len(t, msgs, 2, lang)
+		assert.Equal(t, "one", msgs[0].Text, lang)
+		assert.Equal(t, "two", msgs[1].Text, lang)
+		assert.Equal(t, "#wimtesting", msgs[1].Channel, lang)
+		assert.Len(t, msgs[0].Extra["file"], 1, lang)
+		assert.Empty(t, msgs[1].Extra, lang)
+
+		msg.Text = "drop"
+		assert.Empty(t, gw.scriptMessages(msg), lang)
+	}
+
+	// a failing script relays the message as it is
+	gw.MyConfig.Script = writeScript(t, "broken.lua", "msg.text = (")
+	msg = scriptTestMessage()
+	assert.Equal(t, []config.Message{*msg}, gw.scriptMessages(msg))
+}
+
+func TestScriptReroute(t *testing.T) {
+	r := maketestRouter(testconfig2)
+	gw := r.Gateways["bridge1"]
+	gw.MyConfig.Script = writeScript(t, "reroute.lua", `if msg.text == "!announce" then
+	msg.gateway = "bridge2"
+	msg.text = "announcement"
+end`)
+	recorders := make(map[string]*recordBridger)
+	for _, g := range r.Gateways {
+		for account, br := range g.Bridges {
+			if recorders[account] == nil {
+				recorders[account] = &recordBridger{}
+			}
+			br.Bridger = recorders[account]
+		}
+	}
+
+	gw.relayScriptMessages(&config.Message{Text: "!announce", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Gateway: "bridge1"})
+	require.Len(t, recorders["discord.test"].sent, 1)
+	assert.Equal(t, "general2", recorders["discord.test"].sent[0].Channel)
+	assert.Equal(t, "announcement", recorders["discord.test"].sent[0].Text)
+	assert.Empty(t, recorders["slack.test"].sent)
+}
@@ -1,10 +1,11 @@
 package gateway
 
 import (
-	"bytes"
 	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"mime"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -14,7 +15,9 @@ import (
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
 	"github.com/42wim/matterbridge/gateway/bridgemap"
+	"github.com/42wim/matterbridge/gateway/events"
 )
 
 // handleEventFailure handles failures and reconnects bridges.
@@ -22,7 +25,11 @@ func (r *Router) handleEventFailure(msg *config.Message) {
 	if msg.Event != config.EventFailure {
 		return
 	}
-	for _, gw := range r.Gateways {
+	// disabled bridges are connected again when they're enabled
+	if r.isDisabled(msg.Account) {
+		return
+	}
+	for _, gw := range r.gateways() {
 		for _, br := range gw.Bridges {
 			if msg.Account == br.Account {
 				go gw.reconnectBridge(br)
@@ -37,7 +44,7 @@ func (r *Router) handleEventGetChannelMembers(msg *config.Message) {
 	if msg.Event != config.EventGetChannelMembers {
 		return
 	}
-	for _, gw := range r.Gateways {
+	for _, gw := range r.gateways() {
 		for _, br := range gw.Bridges {
 			if msg.Account == br.Account {
 				cMembers := msg.Extra[config.EventGetChannelMembers][0].(config.ChannelMembers)
@@ -54,7 +61,7 @@ func (r *Router) handleEventRejoinChannels(msg *config.Message) {
 	if msg.Event != config.EventRejoinChannels {
 		return
 	}
-	for _, gw := range r.Gateways {
+	for _, gw := range r.gateways() {
 		for _, br := range gw.Bridges {
 			if msg.Account == br.Account {
 				br.Joined = make(map[string]bool)
@@ -69,11 +76,10 @@ func (r *Router) handleEventRejoinChannels(msg *config.Message) {
 // handleFiles uploads or places all files on the given msg to the MediaServer and
 // adds the new URL of the file on the MediaServer onto the given msg.
 func (gw *Gateway) handleFiles(msg *config.Message) {
-	reg := regexp.MustCompile("[^a-zA-Z0-9]+")
-
 	// If we don't have a attachfield or we don't have a mediaserver configured return
 	if msg.Extra == nil ||
 		(gw.BridgeValues().General.MediaServerUpload == "" &&
+			gw.BridgeValues().General.MediaUploadBackend != "s3" &&
 			gw.BridgeValues().General.MediaDownloadPath == "") {
 		return
 	}
@@ -84,39 +90,57 @@ func (gw *Gateway) handleFiles(msg *config.Message) {
 	}
 
 	for i, f := range msg.Extra["file"] {
-		fi := f.(config.FileInfo)
-		ext := filepath.Ext(fi.Name)
-		fi.Name = fi.Name[0 : len(fi.Name)-len(ext)]
-		fi.Name = reg.ReplaceAllString(fi.Name, "_")
-		fi.Name += ext
-
-		sha1sum := fmt.Sprintf("%x", sha1.Sum(*fi.Data))[:8] //nolint:gosec
-
-		if gw.BridgeValues().General.MediaServerUpload != "" {
-			// Use MediaServerUpload. Upload using a PUT HTTP request and basicauth.
-			if err := gw.handleFilesUpload(&fi); err != nil {
-				gw.logger.Error(err)
-				continue
-			}
-		} else {
-			// Use MediaServerPath. Place the file on the current filesystem.
-			if err := gw.handleFilesLocal(&fi); err != nil {
-				gw.logger.Error(err)
-				continue
-			}
+		durl, sha1sum, err := gw.uploadMedia(f.(config.FileInfo))
+		if err != nil {
+			gw.logger.Error(err)
+			continue
 		}
 
-		// Download URL.
-		durl := gw.BridgeValues().General.MediaServerDownload + "/" + sha1sum + "/" + fi.Name
-
 		gw.logger.Debugf("mediaserver download URL = %s", durl)
 
 		// We uploaded/placed the file successfully. Add the SHA and URL.
 		extra := msg.Extra["file"][i].(config.FileInfo)
 		extra.URL = durl
 		extra.SHA = sha1sum
+		gw.uploadThumbnail(&extra)
 		msg.Extra["file"][i] = extra
+		if gw.Router != nil {
+			gw.Router.Events.Publish(events.Event{Kind: events.MediaUploaded, Gateway: gw.Name, Account: msg.Account, Message: msg, File: &extra})
+		}
+	}
+}
+
+// uploadMedia uploads or places fi on the MediaServer and returns its download URL and
+// the short sha1 of its content.
+func (gw *Gateway) uploadMedia(fi config.FileInfo) (string, string, error) {
+	reg := regexp.MustCompile("[^a-zA-Z0-9]+")
+	ext := filepath.Ext(fi.Name)
+	fi.Name = fi.Name[0 : len(fi.Name)-len(ext)]
+	fi.Name = reg.ReplaceAllString(fi.Name, "_")
+	fi.Name += ext
+
+	sha1sum, err := fileSHA1(&fi)
+	if err != nil {
+		return "", "", fmt.Errorf("mediaserver could not read %s: %s", fi.Name, err)
+	}
+
+	switch {
+	case gw.BridgeValues().General.MediaUploadBackend == "s3":
+		// Put the file in the S3 bucket, at the same path as on the mediaserver.
+		err = gw.handleFilesS3(&fi, sha1sum)
+	case gw.BridgeValues().General.MediaServerUpload != "":
+		// Use MediaServerUpload. Upload using a PUT HTTP request and basicauth.
+		err = gw.handleFilesUpload(&fi)
+	default:
+		// Use MediaServerPath. Place the file on the current filesystem.
+		err = gw.handleFilesLocal(&fi)
+	}
+	if err != nil {
+		return "", "", err
 	}
+
+	// Download URL.
+	return gw.BridgeValues().General.MediaServerDownload + "/" + sha1sum + "/" + fi.Name, sha1sum, nil
 }
 
 // handleFilesUpload uses MediaServerUpload configuration to upload the file.
@@ -126,16 +150,25 @@ func (gw *Gateway) handleFilesUpload(fi *config.FileInfo) error {
 		Timeout: time.Second * 5,
 	}
 	// Use MediaServerUpload. Upload using a PUT HTTP request and basicauth.
-	sha1sum := fmt.Sprintf("%x", sha1.Sum(*fi.Data))[:8] //nolint:gosec
+	sha1sum, err := fileSHA1(fi)
+	if err != nil {
+		return fmt.Errorf("mediaserver upload failed, could not read file: %#v", err)
+	}
 	url := gw.BridgeValues().General.MediaServerUpload + "/" + sha1sum + "/" + fi.Name
 
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(*fi.Data))
+	r, err := fi.Open()
+	if err != nil {
+		return fmt.Errorf("mediaserver upload failed, could not read file: %#v", err)
+	}
+	defer r.Close()
+	req, err := http.NewRequest("PUT", url, r)
 	if err != nil {
 		return fmt.Errorf("mediaserver upload failed, could not create request: %#v", err)
 	}
 
 	gw.logger.Debugf("mediaserver upload url: %s", url)
 
+	req.ContentLength = fi.DataSize()
 	req.Header.Set("Content-Type", "binary/octet-stream")
 	_, err = client.Do(req)
 	if err != nil {
@@ -144,12 +177,49 @@ func (gw *Gateway) handleFilesUpload(fi *config.FileInfo) error {
 	return nil
 }
 
+// handleFilesS3 uploads the file to the bucket of the MediaS3 configuration.
+// Returns error on failure.
+func (gw *Gateway) handleFilesS3(fi *config.FileInfo, sha1sum string) error {
+	r, err := fi.Open()
+	if err != nil {
+		return fmt.Errorf("s3 upload failed, could not read file: %#v", err)
+	}
+	h := sha256.New()
+	_, err = io.Copy(h, r)
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("s3 upload failed, could not read file: %#v", err)
+	}
+
+	r, err = fi.Open()
+	if err != nil {
+		return fmt.Errorf("s3 upload failed, could not read file: %#v", err)
+	}
+	defer r.Close()
+
+	contentType := fi.MimeType()
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(fi.Name))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	key := sha1sum + "/" + fi.Name
+	gw.logger.Debugf("s3 upload key: %s", key)
+
+	return helper.NewS3Client(&gw.BridgeValues().General).Put(key, r, fi.DataSize(), fmt.Sprintf("%x", h.Sum(nil)), contentType)
+}
+
 // handleFilesLocal use MediaServerPath configuration, places the file on the current filesystem.
 // Returns error on failure.
 func (gw *Gateway) handleFilesLocal(fi *config.FileInfo) error {
-	sha1sum := fmt.Sprintf("%x", sha1.Sum(*fi.Data))[:8] //nolint:gosec
+	sha1sum, err := fileSHA1(fi)
+	if err != nil {
+		return fmt.Errorf("mediaserver path failed, could not read file: %s %#v", err, err)
+	}
 	dir := gw.BridgeValues().General.MediaDownloadPath + "/" + sha1sum
-	err := os.Mkdir(dir, os.ModePerm)
+	err = os.Mkdir(dir, os.ModePerm)
 	if err != nil && !os.IsExist(err) {
 		return fmt.Errorf("mediaserver path failed, could not mkdir: %s %#v", err, err)
 	}
@@ -157,24 +227,153 @@ func (gw *Gateway) handleFilesLocal(fi *config.FileInfo) error {
 	path := dir + "/" + fi.Name
 	gw.logger.Debugf("mediaserver path placing file: %s", path)
 
-	err = ioutil.WriteFile(path, *fi.Data, os.ModePerm)
-	if err != nil {
+	if err = writeFile(path, fi); err != nil {
 		return fmt.Errorf("mediaserver path failed, could not writefile: %s %#v", err, err)
 	}
 	return nil
 }
 
+// fileSHA1 returns the short sha1 of the content of fi used in the mediaserver URLs.
+func fileSHA1(fi *config.FileInfo) (string, error) {
+	r, err := fi.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	h := sha1.New() //nolint:gosec
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))[:8], nil
+}
+
+func writeFile(path string, fi *config.FileInfo) error {
+	r, err := fi.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// withFileData fills Data of the files of msg for destinations that don't read files
+// with FileInfo.Open yet.
+func (gw *Gateway) withFileData(msg *config.Message, dest *bridge.Bridge) {
+	if _, ok := bridgemap.MediaReaderSupport[dest.Protocol]; ok {
+		return
+	}
+	if msg.Extra == nil || len(msg.Extra["file"]) == 0 {
+		return
+	}
+	cloneFiles(msg)
+	files := msg.Extra["file"][:0]
+	for _, f := range msg.Extra["file"] {
+		if fi, ok := f.(config.FileInfo); ok {
+			var err error
+			if f, err = fi.WithData(); err != nil {
+				gw.logger.Errorf("failed to read %s for %s: %s", fi.Name, dest.Account, err)
+				continue
+			}
+		}
+		files = append(files, f)
+	}
+	msg.Extra["file"] = files
+}
+
+// cloneFiles gives msg, a copy for a single destination, its own Extra and lists of files,
+// which are otherwise shared with the other destinations, so its files can be changed.
+func cloneFiles(msg *config.Message) {
+	extra := make(map[string][]interface{}, len(msg.Extra))
+	for k, v := range msg.Extra {
+		extra[k] = v
+	}
+	for _, key := range []string{"file", config.EventFileFailureSize} {
+		if files, ok := msg.Extra[key]; ok {
+			extra[key] = append([]interface{}(nil), files...)
+		}
+	}
+	msg.Extra = extra
+}
+
+// mediaMaxSize returns the size of the biggest file relayed to dest, the smallest of the
+// MediaMaxSize of the gateway and of dest, 0 without limit.
+func (gw *Gateway) mediaMaxSize(dest *bridge.Bridge) int {
+	limit := gw.MyConfig.MediaMaxSize
+	if size := dest.GetInt("MediaMaxSize"); size > 0 && (limit <= 0 || size < limit) {
+		limit = size
+	}
+	return limit
+}
+
+// limitMedia moves the files of msg bigger than the MediaMaxSize for dest to the
+// EventFileFailureSize files, they're relayed as a link to their mediaserver or source URL,
+// with their size, by helper.HandleExtra.
+func (gw *Gateway) limitMedia(msg *config.Message, dest *bridge.Bridge) {
+	limit := gw.mediaMaxSize(dest)
+	if limit <= 0 || msg.Extra == nil || len(msg.Extra["file"]) == 0 {
+		return
+	}
+	var files, failed []interface{}
+	for _, f := range msg.Extra["file"] {
+		fi, ok := f.(config.FileInfo)
+		if !ok || fi.Avatar {
+			files = append(files, f)
+			continue
+		}
+		size := fi.Size
+		if size == 0 {
+			size = fi.DataSize()
+		}
+		if size <= int64(limit) {
+			files = append(files, f)
+			continue
+		}
+		gw.logger.Debugf("relaying %s (%d bytes) to %s as a link, MediaMaxSize is %d", fi.Name, size, dest.Account, limit)
+		failed = append(failed, config.FileInfo{Name: fi.Name, Comment: fi.Comment, Size: size, URL: fi.URL})
+	}
+	if len(failed) == 0 {
+		return
+	}
+	cloneFiles(msg)
+	msg.Extra["file"] = files
+	msg.Extra[config.EventFileFailureSize] = append(msg.Extra[config.EventFileFailureSize], failed...)
+}
+
+// hasFileLink returns true if one of the files of msg too big to relay has a link.
+func hasFileLink(msg *config.Message) bool {
+	for _, f := range msg.Extra[config.EventFileFailureSize] {
+		if fi, ok := f.(config.FileInfo); ok && fi.URL != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// bridgeWideEvent returns true if event is for all the channels of the bridge when it has
+// no channel.
+func bridgeWideEvent(event string) bool {
+	return config.IsJoinLeave(event) || event == config.EventCallStarted || event == config.EventNickChange
+}
+
 // ignoreEvent returns true if we need to ignore this event for the specified destination bridge.
 func (gw *Gateway) ignoreEvent(event string, dest *bridge.Bridge) bool {
 	switch event {
 	case config.EventAvatarDownload:
 		// Avatar downloads are only relevant for telegram and mattermost for now
-		if dest.Protocol != "mattermost" && dest.Protocol != "telegram" && dest.Protocol != "xmpp" {
+		if dest.Protocol != "mattermost" && dest.Protocol != "telegram" && dest.Protocol != "xmpp" && dest.Protocol != "signal" {
 			return true
 		}
-	case config.EventJoinLeave:
+	case config.EventJoinLeave, config.EventJoin, config.EventPart, config.EventQuit, config.EventKick, config.EventBan:
 		// only relay join/part when configured
-		if !dest.GetBool("ShowJoinPart") {
+		if !gw.showJoinLeave(event, dest) {
 			return true
 		}
 	case config.EventTopicChange:
@@ -182,6 +381,28 @@ func (gw *Gateway) ignoreEvent(event string, dest *bridge.Bridge) bool {
 		if !dest.GetBool("ShowTopicChange") && !dest.GetBool("SyncTopic") {
 			return true
 		}
+	case config.EventReactionAdd:
+		// only relay reactions as text when configured
+		if !nativeReactions(dest) && !dest.GetBool("ShowReactions") {
+			return true
+		}
+	case config.EventReactionRemove:
+		if !nativeReactions(dest) {
+			return true
+		}
+	case config.EventCallStarted:
+		// only relay calls when configured
+		if !dest.GetBool("ShowCalls") {
+			return true
+		}
+	case config.EventNickChange:
+		// only relay nick changes when configured
+		if !dest.GetBool("ShowNickChange") {
+			return true
+		}
+	case config.EventUserVerified, config.EventBridgeStatus, config.EventSlowmode, config.EventThreadArchived:
+		// verifications, status, slowmode and archived thread events are handled by the router
+		return true
 	}
 	return false
 }
@@ -198,9 +419,14 @@ func (gw *Gateway) handleMessage(rmsg *config.Message, dest *bridge.Bridge) []*B
 			return nil
 		}
 	}
+	if rmsg.Event == config.EventPresence {
+		if _, ok := bridgemap.PresenceSupport[dest.Protocol]; !ok {
+			return nil
+		}
+	}
 
 	// if we have an attached file, or other info
-	if rmsg.Extra != nil && len(rmsg.Extra[config.EventFileFailureSize]) != 0 && rmsg.Text == "" {
+	if rmsg.Extra != nil && len(rmsg.Extra[config.EventFileFailureSize]) != 0 && rmsg.Text == "" && !hasFileLink(rmsg) {
 		return brMsgIDs
 	}
 
@@ -208,24 +434,54 @@ func (gw *Gateway) handleMessage(rmsg *config.Message, dest *bridge.Bridge) []*B
 		return brMsgIDs
 	}
 
+	if gw.ignoredOn(rmsg, dest) {
+		gw.logger.Debugf("not relaying the message of %s on %s to %s, they're ignored there", rmsg.Username, rmsg.Account, dest.Account)
+		return brMsgIDs
+	}
+
 	// broadcast to every out channel (irc QUIT)
-	if rmsg.Channel == "" && rmsg.Event != config.EventJoinLeave {
+	if rmsg.Channel == "" && !bridgeWideEvent(rmsg.Event) {
 		gw.logger.Debug("empty channel")
 		return brMsgIDs
 	}
 
+	if gw.coalesceEdit(rmsg, dest) {
+		return brMsgIDs
+	}
+	return gw.deliverMessage(rmsg, dest)
+}
+
+// deliverMessage sends rmsg to the destination channels of dest.
+func (gw *Gateway) deliverMessage(rmsg *config.Message, dest *bridge.Bridge) []*BrMsgID {
+	var brMsgIDs []*BrMsgID
+
 	// Get the ID of the parent message in thread
 	var canonicalParentMsgID string
-	if rmsg.ParentID != "" && dest.GetBool("PreserveThreading") {
+	if rmsg.ParentID != "" && (dest.GetBool("PreserveThreading") || nativeReplies(dest) || isReaction(rmsg) || dest.GetBool("ReplyContext")) {
 		canonicalParentMsgID = gw.FindCanonicalMsgID(rmsg.Protocol, rmsg.ParentID)
 	}
 
+	queue := queueable(rmsg, dest)
+	queued := queue && gw.hasQueued(dest)
 	channels := gw.getDestChannel(rmsg, *dest)
 	for idx := range channels {
 		channel := &channels[idx]
+		if queued {
+			gw.enqueue(rmsg, dest, channel, canonicalParentMsgID)
+			continue
+		}
 		msgID, err := gw.SendMessage(rmsg, dest, channel, canonicalParentMsgID)
 		if err != nil {
-			gw.logger.Errorf("SendMessage failed: %s", err)
+			if err == errBreakerOpen || err == errBridgeDisabled {
+				gw.logger.Debugf("not sending to %s: %s", dest.Account, err)
+			} else {
+				gw.logger.Errorf("SendMessage failed: %s", err)
+			}
+			if queue && permanentError(dest, err) {
+				gw.publishDropped(rmsg, dest, channel, err)
+			} else if queue {
+				gw.enqueue(rmsg, dest, channel, canonicalParentMsgID)
+			}
 			continue
 		}
 		if msgID == "" {
@@ -233,6 +489,10 @@ func (gw *Gateway) handleMessage(rmsg *config.Message, dest *bridge.Bridge) []*B
 		}
 		brMsgIDs = append(brMsgIDs, &BrMsgID{dest, dest.Protocol + " " + msgID, channel.ID})
 	}
+	// try to send the queued messages, this succeeds once dest is back
+	if queued {
+		gw.Router.requestReplay(dest)
+	}
 	return brMsgIDs
 }
 
@@ -1,9 +1,15 @@
 package gateway
 
 import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"testing"
 )
@@ -29,6 +35,11 @@ func TestIgnoreEvent(t *testing.T) {
 			dest:   &bridge.Bridge{Protocol: "telegram"},
 			output: false,
 		},
+		"avatar signal": {
+			input:  config.EventAvatarDownload,
+			dest:   &bridge.Bridge{Protocol: "signal"},
+			output: false,
+		},
 	}
 	gw := &Gateway{}
 	for testname, testcase := range eventTests {
@@ -73,3 +84,56 @@ func TestExtractNick(t *testing.T) {
 	}
 
 }
+
+func TestWithFileData(t *testing.T) {
+	media := config.FileInfo{Name: "a.txt", Media: config.NewMemoryMedia([]byte("data"), "")}
+	msg := &config.Message{Extra: map[string][]interface{}{"file": {media}}}
+	gw := &Gateway{}
+
+	streaming := *msg
+	gw.withFileData(&streaming, &bridge.Bridge{Protocol: "discord"})
+	assert.Nil(t, streaming.Extra["file"][0].(config.FileInfo).Data)
+
+	legacy := *msg
+	gw.withFileData(&legacy, &bridge.Bridge{Protocol: "irc"})
+	fi := legacy.Extra["file"][0].(config.FileInfo)
+	if assert.NotNil(t, fi.Data) {
+		assert.Equal(t, "data", string(*fi.Data))
+	}
+	// the message of the other destinations isn't changed
+	assert.Nil(t, msg.Extra["file"][0].(config.FileInfo).Data)
+}
+
+func TestHandleFilesS3(t *testing.T) {
+	uploads := make(map[string]string)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		uploads[r.URL.Path] = string(data)
+		assert.Equal(t, "text/plain; charset=utf-8", r.Header.Get("Content-Type"))
+	}))
+	defer ts.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	cfg := config.NewConfigFromString(logger, []byte(`
+[general]
+MediaUploadBackend="s3"
+MediaS3Bucket="media"
+MediaS3Endpoint="`+ts.URL+`"
+`))
+	require.NoError(t, configureMediaUpload(&cfg.BridgeValues().General))
+	assert.Equal(t, ts.URL+"/media", cfg.BridgeValues().General.MediaServerDownload)
+
+	gw := &Gateway{Config: cfg, logger: logrus.NewEntry(logger)}
+	data := []byte("hello")
+	msg := &config.Message{Extra: map[string][]interface{}{"file": {config.FileInfo{Name: "hello world.txt", Data: &data}}}}
+	gw.handleFiles(msg)
+
+	fi := msg.Extra["file"][0].(config.FileInfo)
+	assert.Equal(t, "aaf4c61d", fi.SHA)
+	assert.Equal(t, ts.URL+"/media/aaf4c61d/hello_world.txt", fi.URL)
+	assert.Equal(t, map[string]string{"/media/aaf4c61d/hello_world.txt": "hello"}, uploads)
+
+	assert.Error(t, configureMediaUpload(&config.Protocol{MediaUploadBackend: "s3"}))
+	assert.Error(t, configureMediaUpload(&config.Protocol{MediaUploadBackend: "ftp"}))
+}
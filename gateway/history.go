@@ -0,0 +1,224 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+const historyTimeFormat = "2006-01-02 15:04:05"
+
+// version is a version of a message in its edit history, the first version is the original.
+type version struct {
+	N        int
+	Username string
+	Text     string
+	Time     time.Time
+}
+
+// historyBucket keeps the versions of the messages of the gateway by canonical ID.
+func (gw *Gateway) historyBucket() *store.Bucket {
+	return store.NewBucket(gw.Router.Store, "history:"+gw.Name)
+}
+
+// historyLinksBucket keeps the canonical ID of the messages of the gateway by the links to
+// the message and its copies.
+func (gw *Gateway) historyLinksBucket() *store.Bucket {
+	return store.NewBucket(gw.Router.Store, "historylinks:"+gw.Name)
+}
+
+func (gw *Gateway) versions(canonical string) []version {
+	data, ok := gw.historyBucket().GetString(canonical)
+	if !ok {
+		return nil
+	}
+	var versions []version
+	if err := json.Unmarshal([]byte(data), &versions); err != nil {
+		gw.logger.Errorf("failed to read edit history of %s: %s", canonical, err)
+		return nil
+	}
+	return versions
+}
+
+// recordHistory adds the relayed msg to the edit history, with EditHistory. The edit
+// history keeps the current version and the EditHistory versions before it, as long as the
+// message map.
+func (gw *Gateway) recordHistory(msg *config.Message, msgIDs []*BrMsgID) {
+	keep := gw.BridgeValues().General.EditHistory
+	if keep <= 0 || msg.ID == "" || msg.Text == "" || (msg.Event != "" && msg.Event != config.EventUserAction) {
+		return
+	}
+	canonical := msg.Protocol + " " + msg.ID
+	versions := gw.versions(canonical)
+	if len(versions) == 0 {
+		gw.recordHistoryLinks(msg, msgIDs, canonical)
+	} else if versions[len(versions)-1].Text == msg.Text {
+		return
+	}
+	n := 1
+	if len(versions) > 0 {
+		n = versions[len(versions)-1].N + 1
+	}
+	versions = append(versions, version{N: n, Username: msg.Username, Text: msg.Text, Time: msg.Timestamp})
+	if len(versions) > keep+1 {
+		versions = versions[len(versions)-keep-1:]
+	}
+	data, err := json.Marshal(versions)
+	if err != nil {
+		return
+	}
+	if err := gw.historyBucket().SetStringTTL(canonical, string(data), msgMapTTL); err != nil {
+		gw.logger.Errorf("failed to store edit history of %s: %s", canonical, err)
+	}
+}
+
+// recordHistoryLinks keeps the links to msg and the copies of msgIDs, for the history command.
+func (gw *Gateway) recordHistoryLinks(msg *config.Message, msgIDs []*BrMsgID, canonical string) {
+	var links []string
+	if link := gw.sourcePermalink(msg); link != "" {
+		links = append(links, link)
+	}
+	for _, id := range msgIDs {
+		channel, ok := gw.Channels[id.ChannelID]
+		if !ok {
+			continue
+		}
+		if link, ok := permalink(id.br, channel.Name, strings.TrimPrefix(id.ID, id.br.Protocol+" ")); ok {
+			links = append(links, link)
+		}
+	}
+	bucket := gw.historyLinksBucket()
+	for _, link := range links {
+		if err := bucket.SetStringTTL(link, canonical, msgMapTTL); err != nil {
+			gw.logger.Errorf("failed to store link %s of %s: %s", link, canonical, err)
+		}
+	}
+}
+
+// isHistoryCommandUser returns true if the sender of msg may use the history command.
+// HistoryCommandUsers entries are account:userid or account:username.
+func (r *Router) isHistoryCommandUser(msg *config.Message) bool {
+	for _, user := range r.BridgeValues().General.HistoryCommandUsers {
+		if user == msg.Account+":"+msg.UserID || user == msg.Account+":"+msg.Username {
+			return true
+		}
+	}
+	return false
+}
+
+// handleHistoryCommand handles "!mb history <link>", which replies with the versions of the
+// linked message, the original or a copy, and what changed in every edit. Returns true if
+// msg was the command, it isn't relayed.
+func (r *Router) handleHistoryCommand(msg *config.Message) bool {
+	if msg.Event != "" || len(r.BridgeValues().General.HistoryCommandUsers) == 0 {
+		return false
+	}
+	fields := strings.Fields(msg.Text)
+	if len(fields) < 2 || fields[0] != r.linkCommandPrefix() || fields[1] != "history" {
+		return false
+	}
+	if !r.isHistoryCommandUser(msg) {
+		r.logger.Warnf("history command from unauthorized user %s (%s)", msg.Username, msg.Account)
+		return true
+	}
+	if len(fields) != 3 {
+		r.reply(msg, fmt.Sprintf("usage: %s history <link>", r.linkCommandPrefix()))
+		return true
+	}
+	// slack sends the links as <link>
+	link := strings.Trim(fields[2], "<>")
+	for _, gw := range r.gateways() {
+		if _, ok := gw.Channels[getChannelID(msg)]; !ok {
+			continue
+		}
+		canonical, ok := gw.historyLinksBucket().GetString(link)
+		if !ok {
+			continue
+		}
+		if versions := gw.versions(canonical); len(versions) > 0 {
+			r.reply(msg, formatHistory(link, versions))
+			return true
+		}
+	}
+	r.reply(msg, fmt.Sprintf("no edit history of %s", link))
+	return true
+}
+
+// formatHistory returns the edit trail of versions, every edit as a word diff of the
+// version before it.
+func formatHistory(link string, versions []version) string {
+	var sb strings.Builder
+	edits := len(versions) - 1
+	if versions[0].N == 1 {
+		fmt.Fprintf(&sb, "%s has %d edits", link, edits)
+	} else {
+		fmt.Fprintf(&sb, "%s has %d edits, the last %d are kept", link, versions[len(versions)-1].N-1, edits)
+	}
+	for i, v := range versions {
+		fmt.Fprintf(&sb, "\n%s ", v.Time.Format(historyTimeFormat))
+		switch {
+		case v.N == 1:
+			fmt.Fprintf(&sb, "original by %s: %s", v.Username, v.Text)
+		case i == 0:
+			fmt.Fprintf(&sb, "edit %d: %s", v.N-1, v.Text)
+		default:
+			fmt.Fprintf(&sb, "edit %d: %s", v.N-1, wordDiff(versions[i-1].Text, v.Text))
+		}
+	}
+	return sb.String()
+}
+
+// wordDiff returns the words of b with the words removed from a as [-words-] and the words
+// added as {+words+}, like git diff --word-diff.
+func wordDiff(a, b string) string {
+	x, y := strings.Fields(a), strings.Fields(b)
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			switch {
+			case x[i] == y[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var out, removed, added []string
+	flush := func() {
+		if len(removed) > 0 {
+			out = append(out, "[-"+strings.Join(removed, " ")+"-]")
+		}
+		if len(added) > 0 {
+			out = append(out, "{+"+strings.Join(added, " ")+"+}")
+		}
+		removed, added = nil, nil
+	}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			flush()
+			out = append(out, x[i])
+			i++
+			j++
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			removed = append(removed, x[i])
+			i++
+		default:
+			added = append(added, y[j])
+			j++
+		}
+	}
+	flush()
+	return strings.Join(out, " ")
+}
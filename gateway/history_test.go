@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigHistory = []byte(`
+[general]
+EditHistory=2
+HistoryCommandUsers=["discord.test:mod"]
+
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+`)
+
+func TestWordDiff(t *testing.T) {
+	assert.Equal(t, "the bridge is {+not+} down", wordDiff("the bridge is down", "the bridge is not down"))
+	assert.Equal(t, "I [-never-] {+always+} said that", wordDiff("I never said that", "I always said that"))
+	assert.Equal(t, "[-ok-]", wordDiff("ok", ""))
+	assert.Equal(t, "same text", wordDiff("same text", "same text"))
+}
+
+func TestEditHistory(t *testing.T) {
+	r := maketestRouter(testconfigHistory)
+	gw := r.Gateways["bridge1"]
+	irc := &linkBridger{}
+	discord := &linkBridger{}
+	gw.Bridges["irc.freenode"].Bridger = irc
+	gw.Bridges["discord.test"].Bridger = discord
+
+	sent := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	for i, text := range []string{"I never said that", "I never said that", "I always said that", "I always said this", "I said this"} {
+		gw.relayMessage(&config.Message{
+			Text: text, Username: "wim", ID: "42", Channel: "general", Account: "discord.test", Protocol: "discord",
+			Gateway: "bridge1", Timestamp: sent.Add(time.Duration(i) * time.Minute),
+		})
+	}
+	// repeated versions aren't kept, and only the current version and the 2 before it
+	versions := gw.versions("discord 42")
+	if assert.Len(t, versions, 3) {
+		assert.Equal(t, 2, versions[0].N)
+		assert.Equal(t, "I said this", versions[2].Text)
+	}
+
+	command := &config.Message{Text: "!mb history https://chat.example.com/#wimtesting/1", Username: "mod", Channel: "general", Account: "discord.test"}
+	assert.True(t, r.handleHistoryCommand(command))
+	if assert.Len(t, discord.sent, 1) {
+		assert.Equal(t, "https://chat.example.com/#wimtesting/1 has 3 edits, the last 2 are kept\n"+
+			"2026-10-14 12:02:00 edit 1: I always said that\n"+
+			"2026-10-14 12:03:00 edit 2: I always said [-that-] {+this+}\n"+
+			"2026-10-14 12:04:00 edit 3: I [-always-] said this", discord.sent[0].Text)
+	}
+
+	// the link of the original works too, unknown links have no history
+	command.Text = "!mb history <https://chat.example.com/general/42>"
+	assert.True(t, r.handleHistoryCommand(command))
+	assert.Contains(t, discord.sent[1].Text, "has 3 edits")
+	command.Text = "!mb history https://chat.example.com/general/43"
+	assert.True(t, r.handleHistoryCommand(command))
+	assert.Equal(t, "no edit history of https://chat.example.com/general/43", discord.sent[2].Text)
+
+	// only the HistoryCommandUsers
+	command.Username = "wim"
+	assert.True(t, r.handleHistoryCommand(command))
+	assert.Len(t, discord.sent, 3)
+	assert.False(t, r.handleHistoryCommand(&config.Message{Text: "!mb test", Username: "mod", Channel: "general", Account: "discord.test"}))
+}
@@ -0,0 +1,325 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+const (
+	identitiesBucket      = "identities"
+	identityAccounts      = "identity-accounts"
+	identityCodesBucket   = "identity-codes"
+	identityLinkCommand   = "!link"
+	identityUnlinkCommand = "!unlink"
+	identityCodeTTL       = 10 * time.Minute
+)
+
+// linkedUser is a user who linked their accounts on the bridges with the link command.
+type linkedUser struct {
+	ID       string
+	Name     string // the display name, the nick of the account that linked first
+	Accounts []linkedAccount
+}
+
+// linkedAccount is an account of a linkedUser.
+type linkedAccount struct {
+	Account string
+	UserID  string
+	Nick    string
+}
+
+func (a linkedAccount) keys() []string {
+	keys := []string{strings.ToLower(a.Account + ":" + a.UserID)}
+	if a.Nick != "" && a.Nick != a.UserID {
+		keys = append(keys, strings.ToLower(a.Account+":"+a.Nick))
+	}
+	return keys
+}
+
+// nameKey is the key of the name of u, the accounts of the bridges are account:user.
+func (u *linkedUser) nameKey() string {
+	return "name:" + strings.ToLower(u.Name)
+}
+
+func (u *linkedUser) account(account string) (linkedAccount, bool) {
+	for _, a := range u.Accounts {
+		if a.Account == account {
+			return a, true
+		}
+	}
+	return linkedAccount{}, false
+}
+
+// pendingLink is a link of two accounts waiting for the confirmation of the account To.
+type pendingLink struct {
+	From linkedAccount
+	To   linkedAccount // the account and the nick or user ID that confirm
+}
+
+func senderAccount(msg *config.Message) linkedAccount {
+	id := msg.UserID
+	if id == "" {
+		id = msg.Username
+	}
+	return linkedAccount{Account: msg.Account, UserID: id, Nick: msg.Username}
+}
+
+// linkedUser returns the user who linked the account, with its user ID or nick.
+func (r *Router) linkedUser(account, user string) (*linkedUser, bool) {
+	if !r.BridgeValues().General.IdentityLinking || user == "" {
+		return nil, false
+	}
+	id, ok := store.NewBucket(r.Store, identityAccounts).GetString(strings.ToLower(account + ":" + user))
+	if !ok {
+		return nil, false
+	}
+	data, ok := store.NewBucket(r.Store, identitiesBucket).GetString(id)
+	if !ok {
+		return nil, false
+	}
+	u := &linkedUser{}
+	if err := json.Unmarshal([]byte(data), u); err != nil {
+		r.logger.Errorf("failed to read linked user %s: %s", id, err)
+		return nil, false
+	}
+	return u, true
+}
+
+// namedUser returns the linked user with the name, see IdentityNames.
+func (r *Router) namedUser(name string) (*linkedUser, bool) {
+	return r.linkedUser("name", name)
+}
+
+// sender returns the linked user who sent msg.
+func (r *Router) sender(msg *config.Message) (*linkedUser, bool) {
+	if u, ok := r.linkedUser(msg.Account, msg.UserID); ok {
+		return u, true
+	}
+	return r.linkedUser(msg.Account, msg.Username)
+}
+
+func (r *Router) saveLinkedUser(u *linkedUser) error {
+	accounts := store.NewBucket(r.Store, identityAccounts)
+	if len(u.Accounts) < 2 {
+		// a user with a single account isn't linked anymore
+		for _, a := range u.Accounts {
+			for _, key := range a.keys() {
+				if err := accounts.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		if err := accounts.Delete(u.nameKey()); err != nil {
+			return err
+		}
+		return store.NewBucket(r.Store, identitiesBucket).Delete(u.ID)
+	}
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	if err := store.NewBucket(r.Store, identitiesBucket).SetString(u.ID, string(data)); err != nil {
+		return err
+	}
+	for _, a := range u.Accounts {
+		for _, key := range a.keys() {
+			if err := accounts.SetString(key, u.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return accounts.SetString(u.nameKey(), u.ID)
+}
+
+// removeAccount removes the account of the bridge account from u.
+func (r *Router) removeAccount(u *linkedUser, account string) error {
+	accounts := store.NewBucket(r.Store, identityAccounts)
+	for i, a := range u.Accounts {
+		if a.Account != account {
+			continue
+		}
+		for _, key := range a.keys() {
+			if err := accounts.Delete(key); err != nil {
+				return err
+			}
+		}
+		u.Accounts = append(u.Accounts[:i], u.Accounts[i+1:]...)
+		break
+	}
+	return nil
+}
+
+// unlinkAccount removes the account a from the user it's linked to.
+func (r *Router) unlinkAccount(a linkedAccount) (bool, error) {
+	u, ok := r.linkedUser(a.Account, a.UserID)
+	if !ok {
+		return false, nil
+	}
+	if err := r.removeAccount(u, a.Account); err != nil {
+		return false, err
+	}
+	return true, r.saveLinkedUser(u)
+}
+
+// linkAccounts links the account to to the user of the account from. An account is linked
+// to one user, and a user has one account on a bridge: the links they had are replaced.
+func (r *Router) linkAccounts(from, to linkedAccount) (*linkedUser, error) {
+	if _, err := r.unlinkAccount(to); err != nil {
+		return nil, err
+	}
+	u, ok := r.linkedUser(from.Account, from.UserID)
+	if !ok {
+		id, err := newCanaryToken()
+		if err != nil {
+			return nil, err
+		}
+		u = &linkedUser{ID: id, Name: from.Nick, Accounts: []linkedAccount{from}}
+	}
+	if err := r.removeAccount(u, to.Account); err != nil {
+		return nil, err
+	}
+	u.Accounts = append(u.Accounts, to)
+	return u, r.saveLinkedUser(u)
+}
+
+// handleIdentityCommand handles "!link <account> <user>", which asks to link the account of
+// the sender to the user on account, "!link <code>", which confirms it from that account,
+// "!link", which lists the linked accounts, and "!unlink". Returns true if msg was a command,
+// these aren't relayed.
+func (r *Router) handleIdentityCommand(msg *config.Message) bool {
+	if msg.Event != "" || !r.BridgeValues().General.IdentityLinking {
+		return false
+	}
+	fields := strings.Fields(msg.Text)
+	if len(fields) == 0 || (fields[0] != identityLinkCommand && fields[0] != identityUnlinkCommand) {
+		return false
+	}
+	sender := senderAccount(msg)
+	var reply string
+	switch {
+	case fields[0] == identityUnlinkCommand:
+		ok, err := r.unlinkAccount(sender)
+		switch {
+		case err != nil:
+			reply = fmt.Sprintf("unlinking failed: %s", err)
+		case !ok:
+			reply = "your account isn't linked"
+		default:
+			reply = fmt.Sprintf("unlinked %s on %s", sender.Nick, sender.Account)
+		}
+	case len(fields) == 1:
+		reply = r.listLinkedAccounts(msg)
+	case len(fields) == 2:
+		reply = r.confirmLink(sender, fields[1])
+	case len(fields) == 3:
+		reply = r.requestLink(sender, fields[1], fields[2])
+	default:
+		reply = fmt.Sprintf("usage: %[1]s <account> <user>, %[1]s <code>, %[1]s, %[2]s", identityLinkCommand, identityUnlinkCommand)
+	}
+	r.reply(msg, reply)
+	return true
+}
+
+func (r *Router) listLinkedAccounts(msg *config.Message) string {
+	u, ok := r.sender(msg)
+	if !ok {
+		return fmt.Sprintf("your account isn't linked, link it with %s <account> <user>", identityLinkCommand)
+	}
+	accounts := make([]string, 0, len(u.Accounts))
+	for _, a := range u.Accounts {
+		accounts = append(accounts, a.Nick+" on "+a.Account)
+	}
+	return fmt.Sprintf("%s is %s", u.Name, strings.Join(accounts, ", "))
+}
+
+// requestLink keeps the link of the account from to user on account, until it's confirmed
+// with the code from that account.
+func (r *Router) requestLink(from linkedAccount, account, user string) string {
+	if r.getBridge(account) == nil {
+		return fmt.Sprintf("unknown account %s", account)
+	}
+	if account == from.Account {
+		return "link an account on another bridge"
+	}
+	code, err := newCanaryToken()
+	if err != nil {
+		return fmt.Sprintf("linking failed: %s", err)
+	}
+	data, err := json.Marshal(pendingLink{From: from, To: linkedAccount{Account: account, UserID: user}})
+	if err != nil {
+		return fmt.Sprintf("linking failed: %s", err)
+	}
+	if err := store.NewBucket(r.Store, identityCodesBucket).SetStringTTL(code, string(data), identityCodeTTL); err != nil {
+		return fmt.Sprintf("linking failed: %s", err)
+	}
+	return fmt.Sprintf("to link your accounts send %s %s as %s on %s within %s", identityLinkCommand, code, user, account, identityCodeTTL)
+}
+
+// confirmLink links the accounts of the code, when to is the account it's for.
+func (r *Router) confirmLink(to linkedAccount, code string) string {
+	codes := store.NewBucket(r.Store, identityCodesBucket)
+	data, ok := codes.GetString(code)
+	if !ok {
+		return "unknown or expired code"
+	}
+	var p pendingLink
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		return "unknown or expired code"
+	}
+	if p.To.Account != to.Account || (!strings.EqualFold(p.To.UserID, to.UserID) && !strings.EqualFold(p.To.UserID, to.Nick)) {
+		r.logger.Warnf("link code for %s on %s used by %s on %s", p.To.UserID, p.To.Account, to.Nick, to.Account)
+		return "this code is for another account"
+	}
+	if err := codes.Delete(code); err != nil {
+		r.logger.Errorf("failed to delete link code: %s", err)
+	}
+	u, err := r.linkAccounts(p.From, to)
+	if err != nil {
+		return fmt.Sprintf("linking failed: %s", err)
+	}
+	r.logger.Infof("linked %s on %s to %s (%d accounts)", to.Nick, to.Account, u.Name, len(u.Accounts))
+	return fmt.Sprintf("linked %s on %s with %s on %s", to.Nick, to.Account, p.From.Nick, p.From.Account)
+}
+
+// withIdentityName returns rmsg with the name of its linked sender, with IdentityNames,
+// so users have the same name on every bridge.
+func (gw *Gateway) withIdentityName(rmsg *config.Message) *config.Message {
+	if !gw.BridgeValues().General.IdentityNames {
+		return rmsg
+	}
+	u, ok := gw.Router.sender(rmsg)
+	if !ok || u.Name == "" || u.Name == rmsg.Username {
+		return rmsg
+	}
+	msg := *rmsg
+	msg.Username = u.Name
+	return &msg
+}
+
+// ignoreLinked returns true if the linked sender of msg is ignored with the IgnoreNicks of
+// the bridge of one of their other accounts, or banned with it.
+func (gw *Gateway) ignoreLinked(msg *config.Message) bool {
+	u, ok := gw.Router.sender(msg)
+	if !ok {
+		return false
+	}
+	for _, a := range u.Accounts {
+		br, ok := gw.Bridges[a.Account]
+		if !ok || a.Account == msg.Account {
+			continue
+		}
+		if gw.ignoreText(a.Nick, strings.Fields(br.GetString("IgnoreNicks"))) {
+			gw.logger.Debugf("ignoring %s on %s, %s is ignored on %s", msg.Username, msg.Account, a.Nick, a.Account)
+			return true
+		}
+		if gw.isBanned(&config.Message{Account: a.Account, UserID: a.UserID, Username: a.Nick}) {
+			return true
+		}
+	}
+	return false
+}
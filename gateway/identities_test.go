@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigIdentities = []byte(`
+[general]
+IdentityLinking=true
+IdentityNames=true
+
+[irc.freenode]
+server=""
+IgnoreNicks="troll"
+[discord.test]
+server=""
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "general"
+`)
+
+var linkCodeRE = regexp.MustCompile(`!link ([0-9a-f]+) `)
+
+func TestIdentityLinking(t *testing.T) {
+	r := maketestRouter(testconfigIdentities)
+	gw := r.Gateways["bridge1"]
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+	last := func(account string) string {
+		sent := recorders[account].sent
+		require.NotEmpty(t, sent)
+		return sent[len(sent)-1].Text
+	}
+	command := func(text, username, userID, account, channel string) {
+		assert.True(t, r.handleIdentityCommand(&config.Message{Text: text, Username: username, UserID: userID, Channel: channel, Account: account}))
+	}
+
+	command("!link discord.test alice_d", "alice", "~alice@host", "irc.freenode", "#wimtesting")
+	m := linkCodeRE.FindStringSubmatch(last("irc.freenode"))
+	require.Len(t, m, 2)
+	code := m[1]
+
+	// the code only links the account it's for
+	command("!link "+code, "mallory", "666", "discord.test", "general")
+	assert.Equal(t, "this code is for another account", last("discord.test"))
+	command("!link "+code, "alice_d", "123", "discord.test", "general")
+	assert.Equal(t, "linked alice_d on discord.test with alice on irc.freenode", last("discord.test"))
+	command("!link "+code, "alice_d", "123", "discord.test", "general")
+	assert.Equal(t, "unknown or expired code", last("discord.test"))
+	command("!link", "alice_d", "123", "discord.test", "general")
+	assert.Equal(t, "alice is alice on irc.freenode, alice_d on discord.test", last("discord.test"))
+
+	// the linked users have the same name everywhere
+	msg := &config.Message{Text: "hi", Username: "alice_d", UserID: "123", Channel: "general", Account: "discord.test"}
+	assert.Equal(t, "alice", gw.withIdentityName(msg).Username)
+	assert.Equal(t, "alice_d", msg.Username)
+
+	// and are mentioned with their account
+	gw.relayMessage(&config.Message{
+		Text: "hey @alice", Username: "bob", Channel: "general", Account: "slack.test", Protocol: "slack", Gateway: "bridge1",
+	})
+	assert.Equal(t, "hey <@123>", recorders["discord.test"].sent[len(recorders["discord.test"].sent)-1].Text)
+
+	// the IgnoreNicks of one account apply to the others
+	command("!link discord.test troll_d", "troll", "~troll@host", "irc.freenode", "#wimtesting")
+	code = linkCodeRE.FindStringSubmatch(last("irc.freenode"))[1]
+	command("!link "+code, "troll_d", "456", "discord.test", "general")
+	assert.True(t, gw.ignoreMessage(&config.Message{Text: "hi", Username: "troll_d", UserID: "456", Channel: "general", Account: "discord.test"}))
+	assert.False(t, gw.ignoreMessage(msg))
+
+	command("!unlink", "alice_d", "123", "discord.test", "general")
+	assert.Equal(t, "unlinked alice_d on discord.test", last("discord.test"))
+	_, ok := r.linkedUser("irc.freenode", "~alice@host")
+	assert.False(t, ok)
+	assert.Equal(t, "alice_d", gw.withIdentityName(msg).Username)
+
+	assert.False(t, r.handleIdentityCommand(&config.Message{Text: "hi !link", Username: "bob", Channel: "general", Account: "slack.test"}))
+}
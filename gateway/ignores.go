@@ -0,0 +1,181 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+const (
+	optOutsBucket   = "optouts"
+	optOutCommand   = "!optout"
+	optInCommand    = "!optin"
+	ignoresPrefix   = "ignores:"
+	ignoreCommand   = "ignore"
+	unignoreCommand = "unignore"
+	ignoresCommand  = "ignores"
+)
+
+// userKeys returns the account:userid and account:username keys of the sender of msg, and
+// the ones of their linked accounts.
+func (r *Router) userKeys(msg *config.Message) []string {
+	var keys []string
+	for _, user := range []string{msg.UserID, msg.Username} {
+		if user != "" {
+			keys = append(keys, strings.ToLower(msg.Account+":"+user))
+		}
+	}
+	if u, ok := r.sender(msg); ok {
+		for _, a := range u.Accounts {
+			keys = append(keys, a.keys()...)
+		}
+	}
+	return keys
+}
+
+// isOptedOut returns true if the sender of msg opted out of being relayed, on this account
+// or one of their linked accounts.
+func (r *Router) isOptedOut(msg *config.Message) bool {
+	if !r.BridgeValues().General.UserOptOut {
+		return false
+	}
+	optOuts := store.NewBucket(r.Store, optOutsBucket)
+	for _, key := range r.userKeys(msg) {
+		if optOuts.Contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleOptOutCommand handles "!optout", after which the messages of the sender aren't
+// relayed anymore, and "!optin", which undoes it. Returns true if msg was a command, these
+// aren't relayed.
+func (r *Router) handleOptOutCommand(msg *config.Message) bool {
+	if msg.Event != "" || !r.BridgeValues().General.UserOptOut {
+		return false
+	}
+	text := strings.TrimSpace(msg.Text)
+	if text != optOutCommand && text != optInCommand {
+		return false
+	}
+	sender := senderAccount(msg)
+	optOuts := store.NewBucket(r.Store, optOutsBucket)
+	var err error
+	if text == optOutCommand {
+		err = optOuts.SetString(strings.ToLower(sender.Account+":"+sender.UserID), sender.Nick)
+	} else {
+		for _, key := range r.userKeys(msg) {
+			if err = optOuts.Delete(key); err != nil {
+				break
+			}
+		}
+	}
+	switch {
+	case err != nil:
+		r.logger.Errorf("failed to save the %s of %s on %s: %s", text, sender.Nick, sender.Account, err)
+		r.reply(msg, fmt.Sprintf("%s failed: %s", text, err))
+	case text == optOutCommand:
+		r.logger.Infof("%s on %s opted out of being relayed", sender.Nick, sender.Account)
+		r.reply(msg, fmt.Sprintf("your messages aren't relayed anymore, send %s to undo it", optInCommand))
+	default:
+		r.logger.Infof("%s on %s opted in to being relayed", sender.Nick, sender.Account)
+		r.reply(msg, "your messages are relayed again")
+	}
+	return true
+}
+
+// ignoresBucket keeps the users whose messages aren't sent to account, added with the
+// ignore command, by lowercase account:user.
+func (r *Router) ignoresBucket(account string) *store.Bucket {
+	return store.NewBucket(r.Store, ignoresPrefix+account)
+}
+
+// isIgnoreCommandUser returns true if the sender of msg may use the ignore commands.
+// IgnoreCommandUsers entries are account:userid or account:username.
+func (r *Router) isIgnoreCommandUser(msg *config.Message) bool {
+	for _, user := range r.BridgeValues().General.IgnoreCommandUsers {
+		if user == msg.Account+":"+msg.UserID || user == msg.Account+":"+msg.Username {
+			return true
+		}
+	}
+	return false
+}
+
+// handleIgnoreCommand handles "!mb ignore <account> <user>", after which the messages of
+// user on account aren't sent to the account of the sender, "!mb unignore <account> <user>"
+// and "!mb ignores", which lists them. Returns true if msg was a command, these aren't
+// relayed.
+func (r *Router) handleIgnoreCommand(msg *config.Message) bool {
+	if msg.Event != "" || len(r.BridgeValues().General.IgnoreCommandUsers) == 0 {
+		return false
+	}
+	fields := strings.Fields(msg.Text)
+	if len(fields) < 2 || fields[0] != r.linkCommandPrefix() ||
+		(fields[1] != ignoreCommand && fields[1] != unignoreCommand && fields[1] != ignoresCommand) {
+		return false
+	}
+	if !r.isIgnoreCommandUser(msg) {
+		r.logger.Warnf("ignore command from unauthorized user %s (%s)", msg.Username, msg.Account)
+		return true
+	}
+	ignores := r.ignoresBucket(msg.Account)
+	switch {
+	case fields[1] == ignoresCommand && len(fields) == 2:
+		users := ignores.Keys()
+		if len(users) == 0 {
+			r.reply(msg, fmt.Sprintf("no users are ignored on %s", msg.Account))
+			break
+		}
+		sort.Strings(users)
+		r.reply(msg, fmt.Sprintf("ignored on %s: %s", msg.Account, strings.Join(users, ", ")))
+	case fields[1] != ignoresCommand && len(fields) == 4:
+		if r.getBridge(fields[2]) == nil {
+			r.reply(msg, fmt.Sprintf("unknown account %s", fields[2]))
+			break
+		}
+		key := strings.ToLower(fields[2] + ":" + fields[3])
+		var err error
+		if fields[1] == ignoreCommand {
+			err = ignores.SetString(key, msg.Account+":"+msg.Username)
+		} else {
+			err = ignores.Delete(key)
+		}
+		if err != nil {
+			r.reply(msg, fmt.Sprintf("%s failed: %s", fields[1], err))
+			break
+		}
+		r.logger.Infof("%s on %s: %s %s", msg.Username, msg.Account, fields[1], key)
+		if fields[1] == ignoreCommand {
+			r.reply(msg, fmt.Sprintf("the messages of %s on %s aren't relayed to %s anymore", fields[3], fields[2], msg.Account))
+		} else {
+			r.reply(msg, fmt.Sprintf("the messages of %s on %s are relayed to %s again", fields[3], fields[2], msg.Account))
+		}
+	default:
+		r.reply(msg, fmt.Sprintf("usage: %[1]s %[2]s <account> <user>, %[1]s %[3]s <account> <user>, %[1]s %[4]s",
+			r.linkCommandPrefix(), ignoreCommand, unignoreCommand, ignoresCommand))
+	}
+	return true
+}
+
+// ignoredOn returns true if the sender of rmsg is ignored on dest, with the IgnoreRemoteUsers
+// of dest or the ignore command. The linked accounts of users are ignored with them.
+func (gw *Gateway) ignoredOn(rmsg *config.Message, dest *bridge.Bridge) bool {
+	configured := dest.GetStringSlice("IgnoreRemoteUsers")
+	ignores := gw.Router.ignoresBucket(dest.Account)
+	for _, key := range gw.Router.userKeys(rmsg) {
+		for _, user := range configured {
+			if strings.EqualFold(user, key) {
+				return true
+			}
+		}
+		if ignores.Contains(key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigIgnores = []byte(`
+[general]
+UserOptOut=true
+IgnoreCommandUsers=["slack.test:U1"]
+
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+IgnoreRemoteUsers=["irc.freenode:spammer"]
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "general"
+`)
+
+func TestOptOut(t *testing.T) {
+	r := maketestRouter(testconfigIgnores)
+	gw := r.Gateways["bridge1"]
+	rec := &recordBridger{}
+	gw.Bridges["irc.freenode"].Bridger = rec
+	msg := &config.Message{Text: "hi", Username: "alice", UserID: "~alice@host", Channel: "#wimtesting", Account: "irc.freenode"}
+
+	assert.False(t, gw.ignoreMessage(msg))
+	assert.True(t, r.handleOptOutCommand(&config.Message{Text: "!optout", Username: "alice", UserID: "~alice@host", Channel: "#wimtesting", Account: "irc.freenode"}))
+	require.Len(t, rec.sent, 1)
+	assert.Equal(t, "your messages aren't relayed anymore, send !optin to undo it", rec.sent[0].Text)
+	assert.True(t, gw.ignoreMessage(msg))
+
+	assert.True(t, r.handleOptOutCommand(&config.Message{Text: "!optin", Username: "alice", UserID: "~alice@host", Channel: "#wimtesting", Account: "irc.freenode"}))
+	assert.Equal(t, "your messages are relayed again", rec.sent[1].Text)
+	assert.False(t, gw.ignoreMessage(msg))
+
+	assert.False(t, r.handleOptOutCommand(&config.Message{Text: "!optout please", Username: "alice", Account: "irc.freenode"}))
+}
+
+func TestIgnoreRemoteUsers(t *testing.T) {
+	r := maketestRouter(testconfigIgnores)
+	gw := r.Gateways["bridge1"]
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+	relay := func(username, userID string) {
+		gw.relayMessage(&config.Message{
+			Text: "hi", Username: username, UserID: userID, Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Gateway: "bridge1",
+		})
+	}
+	command := func(text, userID string) string {
+		assert.True(t, r.handleIgnoreCommand(&config.Message{Text: text, Username: "bob", UserID: userID, Channel: "general", Account: "slack.test"}))
+		sent := recorders["slack.test"].sent
+		require.NotEmpty(t, sent)
+		return sent[len(sent)-1].Text
+	}
+
+	// IgnoreRemoteUsers of discord
+	relay("spammer", "~spam@host")
+	assert.Empty(t, recorders["discord.test"].sent)
+	assert.Len(t, recorders["slack.test"].sent, 1)
+
+	// only the IgnoreCommandUsers can use the commands
+	assert.True(t, r.handleIgnoreCommand(&config.Message{Text: "!mb ignore irc.freenode ~troll@host", Username: "eve", UserID: "U2", Channel: "general", Account: "slack.test"}))
+	assert.Len(t, recorders["slack.test"].sent, 1)
+
+	assert.Equal(t, "the messages of ~troll@host on irc.freenode aren't relayed to slack.test anymore", command("!mb ignore irc.freenode ~troll@host", "U1"))
+	assert.Equal(t, "ignored on slack.test: irc.freenode:~troll@host", command("!mb ignores", "U1"))
+	relay("troll", "~troll@host")
+	assert.Len(t, recorders["discord.test"].sent, 1)
+	assert.Len(t, recorders["slack.test"].sent, 3)
+
+	assert.Equal(t, "the messages of ~troll@host on irc.freenode are relayed to slack.test again", command("!mb unignore irc.freenode ~troll@host", "U1"))
+	assert.Equal(t, "no users are ignored on slack.test", command("!mb ignores", "U1"))
+	relay("troll", "~troll@host")
+	assert.Len(t, recorders["slack.test"].sent, 6)
+	assert.Equal(t, "unknown account irc.other", command("!mb ignore irc.other troll", "U1"))
+}
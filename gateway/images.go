@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+)
+
+// hasImages returns true if msg has images the image pipeline processes.
+func (r *Router) hasImages(msg *config.Message) bool {
+	if msg.Extra == nil || !helper.NewImageOptions(&r.BridgeValues().General).Enabled() {
+		return false
+	}
+	for _, f := range msg.Extra["file"] {
+		if fi, ok := f.(config.FileInfo); ok && !fi.Avatar && helper.IsImage(fi.Name) && (fi.Media != nil || fi.Data != nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// processImages runs the images of msg through the image pipeline of the [general] section,
+// once for all the gateways: they're converted, scaled down and compressed as configured, and
+// get a thumbnail with MediaThumbnailSize. It runs in the background, see queueMessage.
+func (r *Router) processImages(msg *config.Message) {
+	opts := helper.NewImageOptions(&r.BridgeValues().General)
+	if !opts.Enabled() || msg.Extra == nil {
+		return
+	}
+	for i, f := range msg.Extra["file"] {
+		fi, ok := f.(config.FileInfo)
+		if !ok || fi.Avatar || !helper.IsImage(fi.Name) {
+			continue
+		}
+		data, err := fi.Bytes()
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		out, name, err := helper.ProcessImage(data, fi.Name, opts)
+		if err != nil {
+			r.logger.Errorf("processing the image %s of %s failed: %s", fi.Name, msg.Account, err)
+		}
+		if name != fi.Name || len(out) != len(data) {
+			r.logger.Debugf("image %s of %s is now %s, %d bytes instead of %d", fi.Name, msg.Account, name, len(out), len(data))
+			fi.Name, fi.Data, fi.Size = name, nil, int64(len(out))
+			fi.Media = config.NewMemoryMedia(out, "")
+			fi.ContentType = fi.Media.ContentType()
+		}
+		if opts.ThumbnailSize > 0 {
+			thumb, err := helper.Thumbnail(out, fi.Name, opts.ThumbnailSize)
+			if err != nil {
+				r.logger.Errorf("thumbnail of %s of %s failed: %s", fi.Name, msg.Account, err)
+			} else {
+				fi.Thumbnail = config.NewMemoryMedia(thumb, "image/jpeg")
+			}
+		}
+		msg.Extra["file"][i] = fi
+	}
+}
+
+// uploadThumbnail uploads the thumbnail of fi to the mediaserver and sets its ThumbnailURL.
+func (gw *Gateway) uploadThumbnail(fi *config.FileInfo) {
+	if fi.Thumbnail == nil {
+		return
+	}
+	durl, _, err := gw.uploadMedia(config.FileInfo{Name: helper.ThumbnailName(fi.Name), Media: fi.Thumbnail})
+	if err != nil {
+		gw.logger.Errorf("thumbnail of %s: %s", fi.Name, err)
+		return
+	}
+	fi.ThumbnailURL = durl
+}
+
+// withThumbnails replaces the images of msg that have a thumbnail and a mediaserver URL by
+// their thumbnail, with the link to the image in the caption, when dest has MediaThumbnails.
+func (gw *Gateway) withThumbnails(msg *config.Message, dest *bridge.Bridge) {
+	if !dest.GetBool("MediaThumbnails") || msg.Extra == nil || len(msg.Extra["file"]) == 0 {
+		return
+	}
+	cloneFiles(msg)
+	for i, f := range msg.Extra["file"] {
+		if fi, ok := f.(config.FileInfo); ok && fi.Thumbnail != nil && fi.URL != "" {
+			caption := fi.CaptionText()
+			if caption != "" {
+				caption += ": "
+			}
+			msg.Extra["file"][i] = config.FileInfo{
+				Name:        helper.ThumbnailName(fi.Name),
+				Media:       fi.Thumbnail,
+				ContentType: "image/jpeg",
+				Caption:     caption + fi.URL,
+				URL:         fi.ThumbnailURL,
+				Size:        fi.Thumbnail.Size(),
+			}
+		}
+	}
+}
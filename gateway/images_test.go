@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigImages = []byte(`
+[general]
+MediaMaxDimension=100
+MediaThumbnailSize=32
+
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+MediaThumbnails=true
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+`)
+
+func TestProcessImages(t *testing.T) {
+	r := maketestRouter(testconfigImages)
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 300, 150))))
+	data := buf.Bytes()
+	msg := &config.Message{Account: "irc.freenode", Extra: map[string][]interface{}{"file": {
+		config.FileInfo{Name: "a.png", Data: &data, Caption: "look", URL: "https://media.example.com/a.png"},
+		config.FileInfo{Name: "notes.txt", Data: &data},
+	}}}
+	// they're processed in the background
+	assert.True(t, r.needsPreparing(msg))
+	assert.False(t, r.needsPreparing(&config.Message{Account: "irc.freenode", Text: "hi"}))
+
+	r.processImages(msg)
+	fi := msg.Extra["file"][0].(config.FileInfo)
+	content, err := fi.Bytes()
+	require.NoError(t, err)
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(content))
+	require.NoError(t, err)
+	assert.Equal(t, 100, cfg.Width)
+	require.NotNil(t, fi.Thumbnail)
+	assert.Nil(t, msg.Extra["file"][1].(config.FileInfo).Thumbnail)
+
+	// discord gets the thumbnail with the link to the image
+	gw := r.Gateways["bridge1"]
+	out := *msg
+	gw.withThumbnails(&out, gw.Bridges["discord.test"])
+	thumb := out.Extra["file"][0].(config.FileInfo)
+	assert.Equal(t, "thumb_a.jpg", thumb.Name)
+	assert.Equal(t, "look: https://media.example.com/a.png", thumb.Caption)
+	assert.Equal(t, "a.png", msg.Extra["file"][0].(config.FileInfo).Name)
+
+	out = *msg
+	gw.withThumbnails(&out, gw.Bridges["irc.freenode"])
+	assert.Equal(t, "a.png", out.Extra["file"][0].(config.FileInfo).Name)
+}
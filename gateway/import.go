@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// ImportArchive adds the imported messages msgs to the archive at path, as relayed by
+// gateway. Imported messages that are in the archive already keep their place.
+func ImportArchive(path, gateway string, msgs []config.Message) error {
+	a, err := newArchive(path, 0)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	for i := range msgs {
+		if err := a.add(gateway, &msgs[i]); err != nil {
+			return fmt.Errorf("importing message %s: %w", msgs[i].ID, err)
+		}
+	}
+	return nil
+}
+
+// Import relays the imported messages msgs as if they were received by their bridges, with
+// their original timestamps, and returns once they're relayed. Like backfilled messages
+// the ones that were relayed already are dropped. The router must be started.
+func (r *Router) Import(msgs []config.Message) error {
+	for _, msg := range msgs {
+		if r.getBridge(msg.Account) == nil {
+			return fmt.Errorf("%s isn't a bridge of a gateway", msg.Account)
+		}
+	}
+	for _, msg := range msgs {
+		if msg.Extra == nil {
+			msg.Extra = make(map[string][]interface{})
+		}
+		msg.Extra[config.ExtraBackfill] = []interface{}{true}
+		r.Message <- msg
+	}
+	done := make(chan struct{})
+	r.flush <- done
+	<-done
+	return nil
+}
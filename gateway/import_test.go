@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.db")
+	msgs := []config.Message{
+		{Account: "slack.test", Protocol: "slack", Channel: "general", Username: "wim", ID: "1.1", Text: "first", Timestamp: time.Unix(1709251200, 0)},
+		{Account: "slack.test", Protocol: "slack", Channel: "general", Username: "alice", ID: "1.2", Text: "second", Timestamp: time.Unix(1709251300, 0)},
+	}
+	require.NoError(t, ImportArchive(path, "bridge1", msgs))
+	// importing again doesn't add them twice
+	require.NoError(t, ImportArchive(path, "bridge1", msgs))
+
+	a, err := newArchive(path, 0)
+	require.NoError(t, err)
+	defer a.Close()
+	found, err := a.history("bridge1", "", time.Time{})
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, "first", found[0].Text)
+	assert.True(t, found[0].Timestamp.Equal(time.Unix(1709251200, 0)))
+}
+
+func TestRouterImport(t *testing.T) {
+	r := maketestRouter(testconfigBackfill)
+	gw := r.Gateways["bridge1"]
+	irc := &recordBridger{}
+	gw.Bridges["irc.freenode"].Bridger = irc
+	gw.Bridges["discord.test"].Bridger = &recordBridger{}
+	go r.handleReceive()
+
+	// the first message was relayed already
+	gw.addMsgIDs("discord 1", nil)
+	msgs := []config.Message{
+		{Account: "discord.test", Channel: "general", Username: "alice", ID: "1", Text: "relayed", Timestamp: time.Unix(1709251200, 0)},
+		{Account: "discord.test", Channel: "general", Username: "alice", ID: "2", Text: "missed", Timestamp: time.Unix(1709251300, 0)},
+	}
+	require.NoError(t, r.Import(msgs))
+	require.Len(t, irc.sent, 1)
+	assert.Equal(t, "missed", irc.sent[0].Text)
+
+	assert.Error(t, r.Import([]config.Message{{Account: "slack.unknown", Text: "hi"}}))
+}
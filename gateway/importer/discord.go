@@ -0,0 +1,76 @@
+package importer
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// discordExport is the json export of a channel by DiscordChatExporter.
+type discordExport struct {
+	Channel struct {
+		Name string `json:"name"`
+	} `json:"channel"`
+	Messages []struct {
+		ID        string    `json:"id"`
+		Type      string    `json:"type"`
+		Timestamp time.Time `json:"timestamp"`
+		Content   string    `json:"content"`
+		Author    struct {
+			ID       string `json:"id"`
+			Name     string `json:"name"`
+			Nickname string `json:"nickname"`
+		} `json:"author"`
+		Attachments []struct {
+			URL           string `json:"url"`
+			FileName      string `json:"fileName"`
+			FileSizeBytes int64  `json:"fileSizeBytes"`
+		} `json:"attachments"`
+		Reference *struct {
+			MessageID string `json:"messageId"`
+		} `json:"reference"`
+	} `json:"messages"`
+}
+
+// readDiscord reads the json export of a discord channel made by DiscordChatExporter.
+func readDiscord(file string) ([]config.Message, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var export discordExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+	var msgs []config.Message
+	for _, m := range export.Messages {
+		// the others are joins, pins, boosts and so on
+		if m.Type != "Default" && m.Type != "Reply" {
+			continue
+		}
+		msg := config.Message{
+			Channel:   export.Channel.Name,
+			Username:  m.Author.Nickname,
+			UserID:    m.Author.ID,
+			ID:        m.ID,
+			Text:      m.Content,
+			Timestamp: m.Timestamp,
+		}
+		if msg.Username == "" {
+			msg.Username = m.Author.Name
+		}
+		if m.Reference != nil {
+			msg.ParentID = m.Reference.MessageID
+		}
+		for _, a := range m.Attachments {
+			withFile(&msg, a.FileName, a.URL, a.FileSizeBytes)
+		}
+		if msg.Text == "" && len(m.Attachments) == 0 {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
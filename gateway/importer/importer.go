@@ -0,0 +1,40 @@
+// Package importer reads the history of a channel from the exports of the platforms, to
+// import it in the archive of matterbridge.
+package importer
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// Formats are the export formats that can be imported.
+var Formats = map[string]func(path string) ([]config.Message, error){
+	"discord": readDiscord,
+	"matrix":  readMatrix,
+	"slack":   readSlack,
+}
+
+// Read returns the messages of the export at path in format, oldest first. The messages
+// have the channel they were sent in, their Account and Protocol aren't set.
+func Read(format, path string) ([]config.Message, error) {
+	read, ok := Formats[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown import format %s, use discord, matrix or slack", format)
+	}
+	msgs, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(msgs, func(i, j int) bool { return msgs[i].Timestamp.Before(msgs[j].Timestamp) })
+	return msgs, nil
+}
+
+// withFile adds the file name at url to msg.
+func withFile(msg *config.Message, name, url string, size int64) {
+	if msg.Extra == nil {
+		msg.Extra = make(map[string][]interface{})
+	}
+	msg.Extra["file"] = append(msg.Extra["file"], config.FileInfo{Name: name, URL: url, Size: size})
+}
@@ -0,0 +1,108 @@
+package importer
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestReadSlack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.zip")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	files := map[string]string{
+		"users.json":    `[{"id": "U1", "name": "wim", "profile": {"display_name": "Wim"}}, {"id": "U2", "name": "alice", "profile": {}}]`,
+		"channels.json": `[{"id": "C1", "name": "general"}]`,
+		"general/2024-03-02.json": `[
+			{"type": "message", "user": "U2", "text": "later", "ts": "1709337600.000200"},
+			{"type": "message", "subtype": "channel_join", "user": "U1", "text": "<@U1> has joined the channel", "ts": "1709337601.000000"}
+		]`,
+		"general/2024-03-01.json": `[
+			{"type": "message", "user": "U1", "text": "hi <@U2>, see <#C1|general> and <https://example.com|this> &amp; more", "ts": "1709251200.000100",
+			 "files": [{"name": "cat.jpg", "url_private": "https://files.slack.com/cat.jpg", "size": 12}]},
+			{"type": "message", "subtype": "me_message", "user": "U2", "text": "waves", "ts": "1709251300.000000", "thread_ts": "1709251200.000100"}
+		]`,
+	}
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	msgs, err := Read("slack", path)
+	require.NoError(t, err)
+	require.Len(t, msgs, 3)
+	assert.Equal(t, "Wim", msgs[0].Username)
+	assert.Equal(t, "general", msgs[0].Channel)
+	assert.Equal(t, "1709251200.000100", msgs[0].ID)
+	assert.Equal(t, "hi @alice, see #general and https://example.com & more", msgs[0].Text)
+	assert.Equal(t, time.Unix(1709251200, 100000), msgs[0].Timestamp)
+	assert.Equal(t, []interface{}{config.FileInfo{Name: "cat.jpg", URL: "https://files.slack.com/cat.jpg", Size: 12}}, msgs[0].Extra["file"])
+	assert.Equal(t, config.EventUserAction, msgs[1].Event)
+	assert.Equal(t, "1709251200.000100", msgs[1].ParentID)
+	assert.Equal(t, "later", msgs[2].Text)
+}
+
+func TestReadDiscord(t *testing.T) {
+	path := writeFile(t, "general.json", `{
+		"guild": {"name": "test"},
+		"channel": {"id": "10", "name": "general"},
+		"messages": [
+			{"id": "2", "type": "Reply", "timestamp": "2024-03-01T12:01:00+00:00", "content": "yes", "author": {"id": "7", "name": "bob", "nickname": ""}, "reference": {"messageId": "1"}},
+			{"id": "1", "type": "Default", "timestamp": "2024-03-01T12:00:00+00:00", "content": "anyone?", "author": {"id": "6", "name": "alice", "nickname": "Alice"},
+			 "attachments": [{"url": "https://cdn.discordapp.com/a.png", "fileName": "a.png", "fileSizeBytes": 34}]},
+			{"id": "3", "type": "ChannelPinnedMessage", "timestamp": "2024-03-01T12:02:00+00:00", "content": "", "author": {"id": "6", "name": "alice"}}
+		]
+	}`)
+	msgs, err := Read("discord", path)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "Alice", msgs[0].Username)
+	assert.Equal(t, "general", msgs[0].Channel)
+	assert.Equal(t, []interface{}{config.FileInfo{Name: "a.png", URL: "https://cdn.discordapp.com/a.png", Size: 34}}, msgs[0].Extra["file"])
+	assert.Equal(t, "bob", msgs[1].Username)
+	assert.Equal(t, "1", msgs[1].ParentID)
+}
+
+func TestReadMatrix(t *testing.T) {
+	path := writeFile(t, "room.json", `{
+		"room_name": "Test room",
+		"messages": [
+			{"type": "m.room.member", "event_id": "$0", "room_id": "!room:example.com", "sender": "@wim:example.com", "origin_server_ts": 1709294400000, "content": {"membership": "join"}},
+			{"type": "m.room.message", "event_id": "$1", "room_id": "!room:example.com", "sender": "@wim:example.com", "origin_server_ts": 1709294400000, "content": {"msgtype": "m.text", "body": "hello"}},
+			{"type": "m.room.message", "event_id": "$2", "room_id": "!room:example.com", "sender": "@wim:example.com", "origin_server_ts": 1709294401000, "content": {"msgtype": "m.text", "body": "* hallo", "m.new_content": {"body": "hallo"}}},
+			{"type": "m.room.message", "event_id": "$3", "room_id": "!room:example.com", "sender": "@alice:example.com", "origin_server_ts": 1709294402000, "content": {"msgtype": "m.image", "body": "cat.jpg", "url": "mxc://example.com/cat", "info": {"size": 56}}},
+			{"type": "m.room.message", "event_id": "$4", "room_id": "!room:example.com", "sender": "@alice:example.com", "origin_server_ts": 1709294403000, "content": {"msgtype": "m.emote", "body": "waves"}}
+		]
+	}`)
+	msgs, err := Read("matrix", path)
+	require.NoError(t, err)
+	require.Len(t, msgs, 3)
+	assert.Equal(t, "!room:example.com", msgs[0].Channel)
+	assert.Equal(t, "hello", msgs[0].Text)
+	assert.Equal(t, "$1", msgs[0].ID)
+	assert.Equal(t, "", msgs[1].Text)
+	assert.Equal(t, []interface{}{config.FileInfo{Name: "cat.jpg", URL: "mxc://example.com/cat", Size: 56}}, msgs[1].Extra["file"])
+	assert.Equal(t, config.EventUserAction, msgs[2].Event)
+}
+
+func TestReadUnknownFormat(t *testing.T) {
+	_, err := Read("irc", "log.txt")
+	assert.Error(t, err)
+}
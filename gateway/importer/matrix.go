@@ -0,0 +1,80 @@
+package importer
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+type matrixEvent struct {
+	Type           string                 `json:"type"`
+	EventID        string                 `json:"event_id"`
+	RoomID         string                 `json:"room_id"`
+	Sender         string                 `json:"sender"`
+	OriginServerTS int64                  `json:"origin_server_ts"`
+	Content        map[string]interface{} `json:"content"`
+}
+
+// matrixExport is the json export of a room by element, or the response of /messages.
+type matrixExport struct {
+	RoomName string        `json:"room_name"`
+	Messages []matrixEvent `json:"messages"`
+	Chunk    []matrixEvent `json:"chunk"`
+}
+
+// readMatrix reads the json export of a matrix room made by element or a dump of the
+// /messages API. The channel of the messages is the room ID.
+func readMatrix(file string) ([]config.Message, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var export matrixExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+	var msgs []config.Message
+	for _, ev := range append(export.Messages, export.Chunk...) {
+		if ev.Type != "m.room.message" {
+			continue
+		}
+		// edits change messages that are imported already
+		if _, ok := ev.Content["m.new_content"]; ok {
+			continue
+		}
+		body, _ := ev.Content["body"].(string)
+		msgtype, _ := ev.Content["msgtype"].(string)
+		msg := config.Message{
+			Channel:   ev.RoomID,
+			Username:  ev.Sender,
+			UserID:    ev.Sender,
+			ID:        ev.EventID,
+			Text:      body,
+			Timestamp: time.UnixMilli(ev.OriginServerTS),
+		}
+		if msg.Channel == "" {
+			msg.Channel = export.RoomName
+		}
+		switch msgtype {
+		case "m.emote":
+			msg.Event = config.EventUserAction
+		case "m.image", "m.file", "m.video", "m.audio":
+			url, _ := ev.Content["url"].(string)
+			var size int64
+			if info, ok := ev.Content["info"].(map[string]interface{}); ok {
+				if s, ok := info["size"].(float64); ok {
+					size = int64(s)
+				}
+			}
+			withFile(&msg, body, url, size)
+			msg.Text = ""
+		}
+		if msg.Text == "" && len(msg.Extra["file"]) == 0 {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
@@ -0,0 +1,179 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+type slackUser struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Profile struct {
+		DisplayName string `json:"display_name"`
+	} `json:"profile"`
+}
+
+type slackMessage struct {
+	Type     string `json:"type"`
+	SubType  string `json:"subtype"`
+	User     string `json:"user"`
+	Username string `json:"username"` // of bots
+	Text     string `json:"text"`
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts"`
+	Files    []struct {
+		Name       string `json:"name"`
+		URLPrivate string `json:"url_private"`
+		Size       int64  `json:"size"`
+	} `json:"files"`
+}
+
+// the subtypes of the messages sent by users, the others are joins, topic changes and so on
+var slackSubTypes = map[string]bool{"": true, "bot_message": true, "me_message": true, "file_share": true, "thread_broadcast": true}
+
+var slackReference = regexp.MustCompile(`<([@#!])?([^>|]+)(?:\|([^>]*))?>`)
+
+// readSlack reads the zip of a slack workspace export: users.json and a directory with a
+// json file of messages a day for every channel.
+func readSlack(file string) ([]config.Message, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	users := make(map[string]string)
+	for _, f := range zr.File {
+		if f.Name != "users.json" {
+			continue
+		}
+		var list []slackUser
+		if err := readZipJSON(f, &list); err != nil {
+			return nil, err
+		}
+		for _, u := range list {
+			users[u.ID] = u.Name
+			if u.Profile.DisplayName != "" {
+				users[u.ID] = u.Profile.DisplayName
+			}
+		}
+	}
+
+	var msgs []config.Message
+	for _, f := range zr.File {
+		channel, name := path.Split(f.Name)
+		channel = strings.TrimSuffix(channel, "/")
+		if channel == "" || strings.Contains(channel, "/") || path.Ext(name) != ".json" {
+			continue
+		}
+		var day []slackMessage
+		if err := readZipJSON(f, &day); err != nil {
+			return nil, err
+		}
+		for _, m := range day {
+			if msg, ok := slackToMessage(m, channel, users); ok {
+				msgs = append(msgs, msg)
+			}
+		}
+	}
+	return msgs, nil
+}
+
+func readZipJSON(f *zip.File, v interface{}) error {
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%s: %w", f.Name, err)
+	}
+	return nil
+}
+
+func slackToMessage(m slackMessage, channel string, users map[string]string) (config.Message, bool) {
+	if m.Type != "message" || !slackSubTypes[m.SubType] {
+		return config.Message{}, false
+	}
+	timestamp, err := parseSlackTS(m.TS)
+	if err != nil {
+		return config.Message{}, false
+	}
+	msg := config.Message{
+		Channel:   channel,
+		Username:  users[m.User],
+		UserID:    m.User,
+		ID:        m.TS,
+		Text:      slackText(m.Text, users),
+		Timestamp: timestamp,
+	}
+	if msg.Username == "" {
+		msg.Username = m.Username
+	}
+	if m.ThreadTS != "" && m.ThreadTS != m.TS {
+		msg.ParentID = m.ThreadTS
+	}
+	if m.SubType == "me_message" {
+		msg.Event = config.EventUserAction
+	}
+	for _, f := range m.Files {
+		withFile(&msg, f.Name, f.URLPrivate, f.Size)
+	}
+	if msg.Text == "" && len(m.Files) == 0 {
+		return config.Message{}, false
+	}
+	return msg, true
+}
+
+// slackText replaces the references to users, channels and links in text by their names.
+func slackText(text string, users map[string]string) string {
+	text = slackReference.ReplaceAllStringFunc(text, func(ref string) string {
+		parts := slackReference.FindStringSubmatch(ref)
+		kind, target, label := parts[1], parts[2], parts[3]
+		switch kind {
+		case "@":
+			if name, ok := users[target]; ok {
+				return "@" + name
+			}
+			return "@" + target
+		case "#":
+			if label != "" {
+				return "#" + label
+			}
+			return "#" + target
+		case "!":
+			return "@" + target
+		}
+		return target
+	})
+	return html.UnescapeString(text)
+}
+
+func parseSlackTS(ts string) (time.Time, error) {
+	sec, frac, _ := strings.Cut(ts, ".")
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var us int64
+	if frac != "" {
+		if us, err = strconv.ParseInt((frac + "000000")[:6], 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(s, us*1000), nil
+}
@@ -0,0 +1,115 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const (
+	// collapseQuitsWindow is how long quits are collected before they're relayed as one.
+	collapseQuitsWindow = 5 * time.Second
+	// collapseQuitsNames is the number of quits named in a collapsed quit.
+	collapseQuitsNames = 10
+
+	// extraCollapsedQuits is the Message.Extra key of collapsed quits, with the texts of
+	// the quits.
+	extraCollapsedQuits = "collapsed_quits"
+)
+
+// showJoinLeave returns true if the join/leave event is relayed to dest.
+func (gw *Gateway) showJoinLeave(event string, dest *bridge.Bridge) bool {
+	policy := gw.MyConfig.JoinLeave
+	var show *bool
+	switch event {
+	case config.EventJoin:
+		show = policy.ShowJoins
+	case config.EventPart:
+		show = policy.ShowParts
+	case config.EventQuit:
+		show = policy.ShowQuits
+	case config.EventKick:
+		show = policy.ShowKicks
+	case config.EventBan:
+		show = policy.ShowBans
+	}
+	if show != nil {
+		return *show
+	}
+	return dest.GetBool("ShowJoinPart")
+}
+
+// quits collects the quits of the bridges of a gateway with CollapseQuits, by account.
+type quits struct {
+	sync.Mutex
+	accounts map[string][]config.Message
+}
+
+// collapseQuit returns true when msg must not be relayed now: quits are collected for
+// collapseQuitsWindow when CollapseQuits is set and relayed as one message, which is only
+// relayed by this gateway.
+func (gw *Gateway) collapseQuit(msg *config.Message) bool {
+	if texts, ok := msg.Extra[extraCollapsedQuits]; ok && len(texts) > 0 {
+		return msg.Gateway != gw.Name
+	}
+	if msg.Event != config.EventQuit || !gw.MyConfig.JoinLeave.CollapseQuits {
+		return false
+	}
+
+	gw.quits.Lock()
+	defer gw.quits.Unlock()
+	pending := gw.quits.accounts[msg.Account]
+	gw.quits.accounts[msg.Account] = append(pending, *msg)
+	if len(pending) == 0 {
+		time.AfterFunc(collapseQuitsWindow, func() { gw.relayQuits(msg.Account) })
+	}
+	return true
+}
+
+// relayQuits sends the quits collected from account to the router as one message.
+func (gw *Gateway) relayQuits(account string) {
+	gw.quits.Lock()
+	pending := gw.quits.accounts[account]
+	delete(gw.quits.accounts, account)
+	gw.quits.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	msg := pending[0]
+	texts := make([]interface{}, 0, len(pending))
+	for _, quit := range pending {
+		texts = append(texts, quit.Text)
+	}
+	if len(pending) > 1 {
+		msg.Text = collapsedQuitText(pending)
+	}
+	msg.Gateway = gw.Name
+	msg.Extra = map[string][]interface{}{extraCollapsedQuits: texts}
+	gw.Message <- msg
+}
+
+// collapsedQuitText returns the text of a message for the quits: the names of the first
+// collapseQuitsNames users and the number of the others.
+func collapsedQuitText(pending []config.Message) string {
+	names := make([]string, 0, collapseQuitsNames)
+	for _, quit := range pending {
+		if len(names) == collapseQuitsNames {
+			break
+		}
+		name := strings.TrimSuffix(quit.Text, " quits")
+		if quit.Username != "" && quit.Username != "system" {
+			name = quit.Username
+		}
+		names = append(names, name)
+	}
+	text := strings.Join(names, ", ")
+	if others := len(pending) - len(names); others > 0 {
+		text += fmt.Sprintf(" and %d others", others)
+	}
+	return fmt.Sprintf("%s quit (%d users)", text, len(pending))
+}
@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigJoinLeave = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+ShowJoinPart=true
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [gateway.joinleave]
+    ShowJoins=false
+    ShowKicks=true
+    CollapseQuits=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account="slack.test"
+    channel="testing"
+`)
+
+func TestShowJoinLeave(t *testing.T) {
+	r := maketestRouter(testconfigJoinLeave)
+	gw := r.Gateways["bridge1"]
+	discord, slack := gw.Bridges["discord.test"], gw.Bridges["slack.test"]
+
+	assert.False(t, gw.showJoinLeave(config.EventJoin, discord))
+	assert.True(t, gw.showJoinLeave(config.EventKick, slack))
+	// not set in the policy, ShowJoinPart of the destination
+	assert.True(t, gw.showJoinLeave(config.EventPart, discord))
+	assert.False(t, gw.showJoinLeave(config.EventPart, slack))
+	assert.True(t, gw.showJoinLeave(config.EventJoinLeave, discord))
+}
+
+func TestCollapseQuits(t *testing.T) {
+	r := maketestRouter(testconfigJoinLeave)
+	gw := r.Gateways["bridge1"]
+
+	for _, nick := range []string{"alice", "bob", "carol"} {
+		msg := &config.Message{Username: "system", Text: nick + " quits", Account: "irc.freenode", Event: config.EventQuit}
+		assert.True(t, gw.collapseQuit(msg))
+	}
+	assert.False(t, gw.collapseQuit(&config.Message{Text: "hi", Account: "irc.freenode", Channel: "#wimtesting"}))
+
+	go gw.relayQuits("irc.freenode")
+	msg := <-r.Message
+	assert.Equal(t, "alice, bob, carol quit (3 users)", msg.Text)
+	assert.Equal(t, config.EventQuit, msg.Event)
+	require.Len(t, msg.Extra[extraCollapsedQuits], 3)
+
+	// relayed by the gateway that collapsed it only
+	assert.False(t, gw.collapseQuit(&msg))
+	msg.Gateway = "bridge2"
+	assert.True(t, gw.collapseQuit(&msg))
+}
+
+func TestCollapsedQuitText(t *testing.T) {
+	var pending []config.Message
+	for i := 0; i < collapseQuitsNames+2; i++ {
+		pending = append(pending, config.Message{Username: "system", Text: "nick quits"})
+	}
+	assert.Equal(t, "nick, nick, nick, nick, nick, nick, nick, nick, nick, nick and 2 others quit (12 users)", collapsedQuitText(pending))
+}
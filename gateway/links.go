@@ -0,0 +1,287 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+const (
+	linksBucket = "links"
+	linkPrefix  = "link:"
+
+	defaultLinkCommandPrefix = "!mb"
+	defaultLinkTTL           = 24 * time.Hour
+)
+
+// link is a gateway created at runtime with the link command.
+type link struct {
+	Name          string
+	Account       string
+	Channel       string
+	TargetAccount string
+	TargetChannel string
+	Expires       time.Time // zero for persistent links
+
+	timer *time.Timer // removes the link when it expires
+}
+
+func (l *link) gatewayConfig() *config.Gateway {
+	return &config.Gateway{
+		Name:   l.Name,
+		Enable: true,
+		InOut: []config.Bridge{
+			{Account: l.Account, Channel: l.Channel},
+			{Account: l.TargetAccount, Channel: l.TargetChannel},
+		},
+	}
+}
+
+func (r *Router) linksBucket() *store.Bucket {
+	return store.NewBucket(r.Store, linksBucket)
+}
+
+func (r *Router) linkCommandPrefix() string {
+	if prefix := r.BridgeValues().General.LinkCommandPrefix; prefix != "" {
+		return prefix
+	}
+	return defaultLinkCommandPrefix
+}
+
+func (r *Router) linkTTL() time.Duration {
+	if ttl := r.BridgeValues().General.LinkCommandTTL; ttl > 0 {
+		return time.Duration(ttl) * time.Second
+	}
+	return defaultLinkTTL
+}
+
+// isLinkCommandUser returns true if the sender of msg may use the link command.
+// LinkCommandUsers entries are account:userid or account:username.
+func (r *Router) isLinkCommandUser(msg *config.Message) bool {
+	for _, user := range r.BridgeValues().General.LinkCommandUsers {
+		if user == msg.Account+":"+msg.UserID || user == msg.Account+":"+msg.Username {
+			return true
+		}
+	}
+	return false
+}
+
+// handleLinkCommand handles "!mb link account/channel [persistent]", "!mb unlink account/channel"
+// and "!mb links". Returns true if msg was a command, these aren't relayed.
+func (r *Router) handleLinkCommand(msg *config.Message) bool {
+	if msg.Event != "" || len(r.BridgeValues().General.LinkCommandUsers) == 0 {
+		return false
+	}
+	fields := strings.Fields(msg.Text)
+	if len(fields) < 2 || fields[0] != r.linkCommandPrefix() {
+		return false
+	}
+	if !r.isLinkCommandUser(msg) {
+		r.logger.Warnf("link command from unauthorized user %s (%s)", msg.Username, msg.Account)
+		return true
+	}
+
+	var reply string
+	switch {
+	case fields[1] == "link" && len(fields) >= 3:
+		persistent := len(fields) > 3 && fields[3] == "persistent"
+		reply = r.linkCommand(msg, fields[2], persistent)
+	case fields[1] == "unlink" && len(fields) >= 3:
+		reply = r.unlinkCommand(msg, fields[2])
+	case fields[1] == "links":
+		reply = r.listLinks()
+	default:
+		reply = fmt.Sprintf("usage: %[1]s link <account>/<channel> [persistent], %[1]s unlink <account>/<channel>, %[1]s links", r.linkCommandPrefix())
+	}
+	r.reply(msg, reply)
+	return true
+}
+
+// parseTarget splits account/channel, eg irc.libera/#foo.
+func parseTarget(target string) (string, string, error) {
+	idx := strings.Index(target, "/")
+	if idx <= 0 || idx == len(target)-1 {
+		return "", "", fmt.Errorf("invalid target %s, use <account>/<channel>", target)
+	}
+	return target[:idx], target[idx+1:], nil
+}
+
+func linkName(msg *config.Message, account, channel string) string {
+	return linkPrefix + msg.Account + "/" + msg.Channel + "->" + account + "/" + channel
+}
+
+func (r *Router) linkCommand(msg *config.Message, target string, persistent bool) string {
+	account, channel, err := parseTarget(target)
+	if err != nil {
+		return err.Error()
+	}
+	if persistent && !r.BridgeValues().General.LinkCommandPersistent {
+		return "persistent links are not allowed"
+	}
+	l := &link{
+		Name:          linkName(msg, account, channel),
+		Account:       msg.Account,
+		Channel:       msg.Channel,
+		TargetAccount: account,
+		TargetChannel: channel,
+	}
+	if _, ok := r.gateways()[l.Name]; ok {
+		return fmt.Sprintf("already linked to %s", target)
+	}
+	var ttl time.Duration
+	if !persistent {
+		ttl = r.linkTTL()
+		l.Expires = time.Now().Add(ttl)
+	}
+	if err := r.addLink(l); err != nil {
+		return fmt.Sprintf("linking to %s failed: %s", target, err)
+	}
+	data, _ := json.Marshal(l)
+	if err := r.linksBucket().SetStringTTL(l.Name, string(data), ttl); err != nil {
+		r.logger.Errorf("failed to store link %s: %s", l.Name, err)
+	}
+	r.logger.Infof("%s (%s) linked %s/%s to %s", msg.Username, msg.Account, msg.Account, msg.Channel, target)
+	if persistent {
+		return fmt.Sprintf("linked to %s", target)
+	}
+	return fmt.Sprintf("linked to %s until %s", target, l.Expires.Format(time.RFC1123))
+}
+
+func (r *Router) unlinkCommand(msg *config.Message, target string) string {
+	account, channel, err := parseTarget(target)
+	if err != nil {
+		return err.Error()
+	}
+	name := linkName(msg, account, channel)
+	if _, ok := r.gateways()[name]; !ok {
+		return fmt.Sprintf("not linked to %s", target)
+	}
+	r.removeLink(name)
+	r.logger.Infof("%s (%s) unlinked %s/%s from %s", msg.Username, msg.Account, msg.Account, msg.Channel, target)
+	return fmt.Sprintf("unlinked from %s", target)
+}
+
+func (r *Router) listLinks() string {
+	var names []string
+	for name := range r.gateways() {
+		if strings.HasPrefix(name, linkPrefix) {
+			names = append(names, strings.TrimPrefix(name, linkPrefix))
+		}
+	}
+	if len(names) == 0 {
+		return "no links"
+	}
+	sort.Strings(names)
+	return "links: " + strings.Join(names, ", ")
+}
+
+// addLink creates the gateway of l and joins its channels, a temporary link is removed by
+// a timer when it expires. Both accounts must already be running in another gateway.
+func (r *Router) addLink(l *link) error {
+	for _, account := range []string{l.Account, l.TargetAccount} {
+		if br := r.getBridge(account); br == nil || br.Bridger == nil {
+			return fmt.Errorf("account %s is not running", account)
+		}
+	}
+	gw := New(r.rootLogger, l.gatewayConfig(), r)
+	for _, br := range gw.Bridges {
+		if err := br.JoinChannels(); err != nil {
+			return err
+		}
+	}
+	r.setGateway(l.Name, gw)
+	if !l.Expires.IsZero() {
+		l.timer = time.AfterFunc(time.Until(l.Expires), func() { r.expiredLinks <- l })
+	}
+	r.links[l.Name] = l
+	return nil
+}
+
+// removeLink removes the gateway of the link name and the channels only it used from their
+// bridges. The bridges can't leave a channel, they stay in it until they're restarted but
+// nothing is relayed from it anymore.
+func (r *Router) removeLink(name string) {
+	if l, ok := r.links[name]; ok {
+		if l.timer != nil {
+			l.timer.Stop()
+		}
+		delete(r.links, name)
+	}
+	if gw, ok := r.gateways()[name]; ok {
+		r.setGateway(name, nil)
+		r.unmapChannels(gw)
+	}
+	if err := r.linksBucket().Delete(name); err != nil {
+		r.logger.Errorf("failed to delete link %s: %s", name, err)
+	}
+}
+
+// unmapChannels removes the channels of gw, which was removed, from its bridges when no
+// other gateway uses them.
+func (r *Router) unmapChannels(gw *Gateway) {
+	for ID, channel := range gw.Channels {
+		used := false
+		for _, other := range r.gateways() {
+			if _, ok := other.Channels[ID]; ok {
+				used = true
+				break
+			}
+		}
+		if br, ok := gw.Bridges[channel.Account]; ok && !used {
+			br.RemoveChannel(ID)
+		}
+	}
+}
+
+// loadLinks recreates the links stored in the store, for persistent stores links survive a restart.
+func (r *Router) loadLinks() {
+	bucket := r.linksBucket()
+	for _, name := range bucket.Keys() {
+		data, ok := bucket.GetString(name)
+		if !ok {
+			continue
+		}
+		l := &link{}
+		if err := json.Unmarshal([]byte(data), l); err != nil {
+			r.logger.Errorf("failed to decode link %s: %s", name, err)
+			continue
+		}
+		if err := r.addLink(l); err != nil {
+			r.logger.Errorf("failed to restore link %s: %s", name, err)
+			continue
+		}
+		r.logger.Infof("restored link %s", strings.TrimPrefix(name, linkPrefix))
+	}
+}
+
+// expireLink removes the temporary link l when its timer fires, unless it was already
+// unlinked or replaced.
+func (r *Router) expireLink(l *link) {
+	if r.links[l.Name] != l {
+		return
+	}
+	r.logger.Infof("link %s expired", strings.TrimPrefix(l.Name, linkPrefix))
+	r.removeLink(l.Name)
+}
+
+// reply sends text to the channel msg came from.
+func (r *Router) reply(msg *config.Message, text string) {
+	br := r.getBridge(msg.Account)
+	if br == nil || br.Bridger == nil {
+		return
+	}
+	_, err := br.Send(config.Message{
+		Text:     text,
+		Channel:  msg.Channel,
+		Account:  msg.Account,
+		ParentID: msg.ID,
+	})
+	if err != nil {
+		r.logger.Errorf("failed to reply to %s on %s: %s", msg.Username, msg.Account, err)
+	}
+}
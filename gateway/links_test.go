@@ -0,0 +1,113 @@
+package gateway
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigLinks = []byte(`
+[general]
+LinkCommandUsers=["discord.test:42"]
+
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+	`)
+
+func TestLinkCommand(t *testing.T) {
+	r := maketestRouter(testconfigLinks)
+	recorders := make(map[string]*recordBridger)
+	for account, br := range r.Gateways["bridge1"].Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+	command := func(userID, text string) string {
+		msg := &config.Message{Text: text, UserID: userID, Channel: "general", Account: "discord.test"}
+		sent := len(recorders["discord.test"].sent)
+		assert.True(t, r.handleLinkCommand(msg))
+		if len(recorders["discord.test"].sent) == sent {
+			return ""
+		}
+		return recorders["discord.test"].sent[sent].Text
+	}
+
+	// normal messages aren't commands
+	assert.False(t, r.handleLinkCommand(&config.Message{Text: "hello", UserID: "42", Channel: "general", Account: "discord.test"}))
+
+	// unauthorized users are ignored
+	assert.Empty(t, command("1", "!mb link irc.freenode/#foo"))
+	assert.Len(t, r.Gateways, 1)
+
+	assert.True(t, strings.HasPrefix(command("42", "!mb link irc.freenode/#foo"), "linked to irc.freenode/#foo until"))
+	assert.Len(t, r.Gateways, 2)
+	gw := r.Gateways["link:discord.test/general->irc.freenode/#foo"]
+	assert.NotNil(t, gw)
+	assert.Contains(t, gw.Channels, "#fooirc.freenode")
+
+	assert.Equal(t, "persistent links are not allowed", command("42", "!mb link irc.freenode/#bar persistent"))
+	assert.Equal(t, "linking to slack.test/general failed: account slack.test is not running", command("42", "!mb link slack.test/general"))
+	assert.Equal(t, "links: discord.test/general->irc.freenode/#foo", command("42", "!mb links"))
+
+	// temporary links are removed by their timer
+	l := r.links[gw.Name]
+	assert.NotNil(t, l.timer)
+	r.expireLink(&link{Name: gw.Name})
+	assert.Len(t, r.Gateways, 2)
+	r.expireLink(l)
+	assert.Len(t, r.Gateways, 1)
+	assert.Empty(t, r.links)
+	_, ok := r.linksBucket().GetString(gw.Name)
+	assert.False(t, ok)
+	// the channel isn't joined again, the channels of bridge1 stay
+	irc := r.Gateways["bridge1"].Bridges["irc.freenode"]
+	assert.NotContains(t, irc.GetChannels(), "#fooirc.freenode")
+	assert.Contains(t, irc.GetChannels(), "#wimtestingirc.freenode")
+
+	assert.Equal(t, "not linked to irc.freenode/#foo", command("42", "!mb unlink irc.freenode/#foo"))
+
+	// unlinking removes the link before it expires
+	command("42", "!mb link irc.freenode/#foo")
+	assert.Equal(t, "unlinked from irc.freenode/#foo", command("42", "!mb unlink irc.freenode/#foo"))
+	assert.Len(t, r.Gateways, 1)
+	assert.Empty(t, r.links)
+	assert.NotContains(t, irc.GetChannels(), "#fooirc.freenode")
+}
+
+func TestLinkTimer(t *testing.T) {
+	r := maketestRouter(testconfigLinks)
+	for _, br := range r.Gateways["bridge1"].Bridges {
+		br.Bridger = &recordBridger{}
+	}
+	l := &link{
+		Name:          "link:discord.test/general->irc.freenode/#foo",
+		Account:       "discord.test",
+		Channel:       "general",
+		TargetAccount: "irc.freenode",
+		TargetChannel: "#foo",
+		Expires:       time.Now().Add(10 * time.Millisecond),
+	}
+	assert.NoError(t, r.addLink(l))
+	select {
+	case expired := <-r.expiredLinks:
+		assert.Same(t, l, expired)
+	case <-time.After(time.Second):
+		t.Fatal("the link didn't expire")
+	}
+}
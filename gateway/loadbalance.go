@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// addLoadBalancing adds the bridges of the LoadBalanceAccounts of the bridges of the
+// gateway. They join the channels of the account they send for, the messages they receive
+// aren't relayed.
+func (gw *Gateway) addLoadBalancing() error {
+	var accounts []*bridge.Bridge
+	for _, br := range gw.Bridges {
+		accounts = append(accounts, br)
+	}
+	for _, br := range accounts {
+		for _, account := range br.GetStringSlice("LoadBalanceAccounts") {
+			if !strings.HasPrefix(account, br.Protocol+".") {
+				return fmt.Errorf("LoadBalanceAccounts of %s: %s isn't a %s account", br.Account, account, br.Protocol)
+			}
+			if err := gw.AddBridge(&config.Bridge{Account: account}); err != nil {
+				return err
+			}
+			for _, channel := range gw.Channels {
+				if channel.Account != br.Account {
+					continue
+				}
+				c := *channel
+				c.Account = account
+				c.ID = c.Name + account
+				gw.Bridges[account].SetChannel(c)
+			}
+		}
+	}
+	return nil
+}
+
+// loadBalancer returns the bridge dest of which LoadBalanceAccounts is account, or nil if
+// account doesn't send for another bridge of the gateway.
+func (gw *Gateway) loadBalancer(account string) *bridge.Bridge {
+	for _, br := range gw.Bridges {
+		for _, member := range br.GetStringSlice("LoadBalanceAccounts") {
+			if member == account {
+				return br
+			}
+		}
+	}
+	return nil
+}
+
+// balance returns the bridge that sends the messages of dest to channel: dest or one of
+// its LoadBalanceAccounts. A channel always gets the same bridge so its messages stay in
+// order and edits and deletes are sent by the bridge that sent the message.
+func (gw *Gateway) balance(dest *bridge.Bridge, channel *config.ChannelInfo) *bridge.Bridge {
+	senders := []*bridge.Bridge{dest}
+	for _, account := range dest.GetStringSlice("LoadBalanceAccounts") {
+		if br, ok := gw.Bridges[account]; ok {
+			senders = append(senders, br)
+		}
+	}
+	if len(senders) == 1 {
+		return dest
+	}
+	h := fnv.New32a()
+	h.Write([]byte(channel.ID)) //nolint:errcheck
+	return senders[h.Sum32()%uint32(len(senders))]
+}
+
+// sentBalanced remembers that the message with ID mID in the channels of dest was sent by
+// another account, so dest doesn't relay it as a message of another user.
+func (gw *Gateway) sentBalanced(dest *bridge.Bridge, mID string) {
+	gw.Router.balanced.Add(dest.Account+" "+mID, true)
+}
+
+// ignoreBalanced returns true for the messages received by the LoadBalanceAccounts and
+// for the messages they sent, as received by the bridge they send for.
+func (gw *Gateway) ignoreBalanced(msg *config.Message) bool {
+	if gw.loadBalancer(msg.Account) != nil {
+		return true
+	}
+	if msg.ID == "" {
+		return false
+	}
+	if gw.Router.balanced.Contains(msg.Account + " " + msg.ID) {
+		gw.logger.Debugf("ignoring message %s on %s, it was sent by one of its LoadBalanceAccounts", msg.ID, msg.Account)
+		return true
+	}
+	return false
+}
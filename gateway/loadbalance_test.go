@@ -0,0 +1,88 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigLoadBalance = []byte(`
+[irc.test]
+server=""
+[slack.test]
+LoadBalanceAccounts = ["slack.bot2", "slack.bot3"]
+[slack.bot2]
+server=""
+[slack.bot3]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.test"
+    channel = "#test"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "ops"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "news"
+	`)
+
+func TestLoadBalance(t *testing.T) {
+	r := maketestRouter(testconfigLoadBalance)
+	gw := r.Gateways["bridge1"]
+	require.Len(t, gw.Bridges, 4)
+	assert.Contains(t, gw.Bridges["slack.bot2"].Channels, "generalslack.bot2")
+	assert.Len(t, gw.Bridges["slack.bot3"].Channels, 3)
+	assert.Len(t, gw.Channels, 4)
+
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+
+	// every channel always gets the same account
+	senders := make(map[string]string)
+	for _, channel := range []string{"general", "ops", "news"} {
+		senders[channel] = gw.balance(gw.Bridges["slack.test"], gw.Channels[channel+"slack.test"]).Account
+		assert.Equal(t, senders[channel], gw.balance(gw.Bridges["slack.test"], gw.Channels[channel+"slack.test"]).Account)
+	}
+	assert.Equal(t, map[string]string{"general": "slack.bot3", "ops": "slack.test", "news": "slack.bot2"}, senders)
+	assert.Equal(t, "irc.test", gw.balance(gw.Bridges["irc.test"], gw.Channels["#testirc.test"]).Account)
+
+	msg := &config.Message{Text: "hi", Channel: "#test", Account: "irc.test", Gateway: "bridge1", Protocol: "irc", ID: "1"}
+	gw.relayMessage(msg)
+	sent := 0
+	for account, rec := range recorders {
+		sent += len(rec.sent)
+		if account != "irc.test" {
+			for _, m := range rec.sent {
+				assert.Equal(t, account, senders[m.Channel])
+			}
+		}
+	}
+	assert.Equal(t, 3, sent)
+	assert.Empty(t, recorders["irc.test"].sent)
+
+	// the messages of the other accounts aren't relayed, as received by slack.test or by them
+	for account, rec := range recorders {
+		if account == "irc.test" || account == "slack.test" || len(rec.sent) == 0 {
+			continue
+		}
+		assert.True(t, gw.ignoreMessage(&config.Message{Text: "hi", Channel: rec.sent[0].Channel, Account: "slack.test", ID: "1"}))
+	}
+	assert.True(t, gw.ignoreMessage(&config.Message{Text: "hi", Channel: "general", Account: "slack.bot2", ID: "9"}))
+	assert.False(t, gw.ignoreMessage(&config.Message{Text: "hi", Channel: "general", Account: "slack.test", ID: "9"}))
+}
@@ -0,0 +1,217 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// logins are the interactive logins of bridges waiting for an answer in the LoginChannel.
+type logins struct {
+	sync.Mutex
+	pending map[string]chan string // by account
+
+	// the bridges log in while the router starts, before handleReceive reads the messages
+	// of the bridges. Until then a reader takes the answers and holds the other messages.
+	receiving  bool
+	stop, done chan struct{}
+	held       []config.Message
+}
+
+func newLogins() *logins {
+	return &logins{pending: make(map[string]chan string)}
+}
+
+// loginChannel returns the bridge and channel the login prompts are posted in.
+func (r *Router) loginChannel() (*bridge.Bridge, *config.ChannelInfo, error) {
+	general := r.BridgeValues().General
+	if general.LoginAccount == "" || general.LoginChannel == "" {
+		return nil, nil, bridge.ErrNoLoginChannel
+	}
+	br := r.getBridge(general.LoginAccount)
+	if br == nil || br.Bridger == nil {
+		return nil, nil, fmt.Errorf("LoginAccount %s isn't connected", general.LoginAccount)
+	}
+	return br, sideChannel(general.LoginAccount, general.LoginChannel), nil
+}
+
+// addLoginChannel makes the bridge of LoginAccount join the LoginChannel. The channel
+// isn't part of a gateway, nothing is relayed to or from it.
+func (r *Router) addLoginChannel() {
+	general := r.BridgeValues().General
+	if general.LoginAccount == "" || general.LoginChannel == "" {
+		return
+	}
+	br := r.getBridge(general.LoginAccount)
+	if br == nil {
+		r.logger.Errorf("LoginAccount %s isn't used in a gateway, login prompts are shown on the console", general.LoginAccount)
+		return
+	}
+	channel := sideChannel(general.LoginAccount, general.LoginChannel)
+	br.SetChannel(*channel)
+}
+
+// LoginNotice posts text about the login of account in the LoginChannel, with image if
+// it isn't nil.
+func (r *Router) LoginNotice(account, text string, image *config.FileInfo) error {
+	br, channel, err := r.loginChannel()
+	if err != nil {
+		return err
+	}
+	msg := config.Message{
+		Text:     fmt.Sprintf("[%s] %s", account, text),
+		Channel:  channel.Name,
+		Account:  br.Account,
+		Username: "matterbridge",
+	}
+	if image != nil {
+		msg.Extra = map[string][]interface{}{"file": {*image}}
+	}
+	_, err = br.Send(msg)
+	return err
+}
+
+// LoginPrompt posts text about the login of account in the LoginChannel and returns the
+// answer given there with "!mb login <account> <answer>".
+func (r *Router) LoginPrompt(account, text string, timeout time.Duration) (string, error) {
+	answer := make(chan string, 1)
+	r.logins.Lock()
+	r.logins.pending[account] = answer
+	if !r.logins.receiving && r.logins.stop == nil {
+		r.logins.stop, r.logins.done = make(chan struct{}), make(chan struct{})
+		go r.readLoginAnswers(r.logins.stop, r.logins.done)
+	}
+	r.logins.Unlock()
+	defer func() {
+		r.logins.Lock()
+		if r.logins.pending[account] == answer {
+			delete(r.logins.pending, account)
+		}
+		r.logins.Unlock()
+	}()
+
+	text = fmt.Sprintf("%s\nAnswer with: %s login %s <answer>", text, r.linkCommandPrefix(), account)
+	if err := r.LoginNotice(account, text, nil); err != nil {
+		return "", err
+	}
+	select {
+	case a := <-answer:
+		return a, nil
+	case <-time.After(timeout):
+		return "", fmt.Errorf("no answer to the login prompt of %s within %s", account, timeout)
+	}
+}
+
+// readLoginAnswers handles the answers to the login prompts until stop is closed, the
+// other messages are held for handleReceive.
+func (r *Router) readLoginAnswers(stop, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-stop:
+			return
+		case msg := <-r.Message:
+			if r.handleLoginAnswer(&msg) {
+				continue
+			}
+			r.logins.Lock()
+			r.logins.held = append(r.logins.held, msg)
+			r.logins.Unlock()
+		}
+	}
+}
+
+// startReceiving stops the reader of the answers of the login prompts, before
+// handleReceive starts, and sends the messages it held again.
+func (r *Router) startReceiving() {
+	r.logins.Lock()
+	r.logins.receiving = true
+	stop, done := r.logins.stop, r.logins.done
+	r.logins.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+	r.logins.Lock()
+	held := r.logins.held
+	r.logins.held = nil
+	r.logins.Unlock()
+	if len(held) > 0 {
+		go func() {
+			for _, msg := range held {
+				r.Message <- msg
+			}
+		}()
+	}
+}
+
+// handleLoginAnswer handles "!mb login <account> <answer>" in the LoginChannel. Returns
+// true if msg was an answer, these aren't relayed.
+func (r *Router) handleLoginAnswer(msg *config.Message) bool {
+	general := r.BridgeValues().General
+	if msg.Event != "" || general.LoginAccount == "" || msg.Account != general.LoginAccount {
+		return false
+	}
+	if msg.Channel != sideChannel(general.LoginAccount, general.LoginChannel).Name {
+		return false
+	}
+	fields := strings.Fields(msg.Text)
+	if len(fields) < 2 || fields[0] != r.linkCommandPrefix() || fields[1] != "login" {
+		return false
+	}
+	if !r.isLoginUser(msg) {
+		r.logger.Warnf("login answer from unauthorized user %s (%s)", msg.Username, msg.Account)
+		return true
+	}
+	if len(fields) < 4 {
+		r.reply(msg, fmt.Sprintf("usage: %s login <account> <answer>", r.linkCommandPrefix()))
+		return true
+	}
+	account := fields[2]
+	r.logins.Lock()
+	answer, ok := r.logins.pending[account]
+	delete(r.logins.pending, account)
+	r.logins.Unlock()
+	if !ok {
+		r.reply(msg, fmt.Sprintf("%s isn't waiting for a login answer", account))
+		return true
+	}
+	answer <- strings.Join(fields[3:], " ")
+	r.reply(msg, fmt.Sprintf("passed the answer to %s", account))
+	return true
+}
+
+// isLoginUser returns true if the sender of msg is one of the LoginUsers, everyone in the
+// LoginChannel can answer when it's empty.
+func (r *Router) isLoginUser(msg *config.Message) bool {
+	users := r.BridgeValues().General.LoginUsers
+	if len(users) == 0 {
+		return true
+	}
+	for _, user := range users {
+		if user == msg.Account+":"+msg.UserID || user == msg.Account+":"+msg.Username {
+			return true
+		}
+	}
+	return false
+}
+
+// loginFirst returns the bridges of m with the bridge of the LoginAccount first, so it's
+// connected before the other bridges post their login prompts.
+func (r *Router) loginFirst(m map[string]*bridge.Bridge) []*bridge.Bridge {
+	bridges := make([]*bridge.Bridge, 0, len(m))
+	if br, ok := m[r.BridgeValues().General.LoginAccount]; ok {
+		bridges = append(bridges, br)
+	}
+	for account, br := range m {
+		if account != r.BridgeValues().General.LoginAccount {
+			bridges = append(bridges, br)
+		}
+	}
+	return bridges
+}
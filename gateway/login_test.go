@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigLogin = []byte(`
+[general]
+LoginAccount = "slack.test"
+LoginChannel = "admin"
+LoginUsers = ["slack.test:U1"]
+
+[steam.test]
+server=""
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "steam.test"
+    channel = "123"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "general"
+	`)
+
+func TestLoginPrompt(t *testing.T) {
+	r := maketestRouter(testconfigLogin)
+	gw := r.Gateways["bridge1"]
+	admin := &chanBridger{sent: make(chan config.Message, 10)}
+	gw.Bridges["slack.test"].Bridger = admin
+
+	r.addLoginChannel()
+	assert.Contains(t, gw.Bridges["slack.test"].Channels, "adminslack.test")
+	bridges := r.loginFirst(gw.Bridges)
+	assert.Equal(t, "slack.test", bridges[0].Account)
+
+	type result struct {
+		answer string
+		err    error
+	}
+	results := make(chan result)
+	go func() {
+		answer, err := r.LoginPrompt("steam.test", "Enter 2FA code:", 5*time.Second)
+		results <- result{answer, err}
+	}()
+	notice := <-admin.sent
+	assert.Equal(t, "admin", notice.Channel)
+	assert.Equal(t, "[steam.test] Enter 2FA code:\nAnswer with: !mb login steam.test <answer>", notice.Text)
+
+	// while the router starts the answers are read before handleReceive, other messages
+	// are held until it runs
+	r.Message <- config.Message{Text: "hello", Channel: "general", Account: "slack.test"}
+	r.Message <- config.Message{Text: "!mb login steam.test 12345", Channel: "admin", Account: "slack.test", UserID: "U2"}
+	r.Message <- config.Message{Text: "!mb login steam.test 12345", Channel: "admin", Account: "slack.test", UserID: "U1"}
+	res := <-results
+	require.NoError(t, res.err)
+	assert.Equal(t, "12345", res.answer)
+	assert.Equal(t, "passed the answer to steam.test", (<-admin.sent).Text)
+
+	r.startReceiving()
+	held := <-r.Message
+	assert.Equal(t, "hello", held.Text)
+
+	_, err := r.LoginPrompt("steam.test", "Enter 2FA code:", 10*time.Millisecond)
+	assert.Error(t, err)
+	<-admin.sent
+	assert.True(t, r.handleLoginAnswer(&config.Message{Text: "!mb login steam.test 12345", Channel: "admin", Account: "slack.test", UserID: "U1"}))
+	assert.Equal(t, "steam.test isn't waiting for a login answer", (<-admin.sent).Text)
+	assert.False(t, r.handleLoginAnswer(&config.Message{Text: "!mb login steam.test 12345", Channel: "general", Account: "slack.test", UserID: "U1"}))
+}
+
+func TestLoginNoChannel(t *testing.T) {
+	r := maketestRouter(testconfig)
+	assert.Equal(t, bridge.ErrNoLoginChannel, r.LoginNotice("irc.freenode", "hi", nil))
+}
@@ -0,0 +1,173 @@
+package gateway
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// isLuaScript returns true if the script filename must be run with lua instead of tengo.
+func isLuaScript(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".lua")
+}
+
+// runLua runs the lua script filename with globals set and returns its state, which the
+// caller must close.
+func (gw *Gateway) runLua(filename string, globals map[string]lua.LValue) (*lua.LState, error) {
+	return gw.runLuaWith(filename, func(L *lua.LState) {
+		for name, value := range globals {
+			L.SetGlobal(name, value)
+		}
+	})
+}
+
+// runLuaWith is runLua with the globals set by setup, for globals that are tables.
+func (gw *Gateway) runLuaWith(filename string, setup func(L *lua.LState)) (*lua.LState, error) {
+	L := lua.NewState()
+	if gw.Router != nil {
+		L.PreloadModule(scriptModule, gw.luaLoader)
+	}
+	setup(L)
+	if err := L.DoFile(filename); err != nil {
+		L.Close()
+		return nil, err
+	}
+	return L, nil
+}
+
+// modifyInMessageLua is the lua version of modifyInMessageTengo, with the same globals.
+func (gw *Gateway) modifyInMessageLua(filename string, msg *config.Message) error {
+	L, err := gw.runLua(filename, map[string]lua.LValue{
+		"msgText":     lua.LString(msg.Text),
+		"msgUsername": lua.LString(msg.Username),
+		"msgUserID":   lua.LString(msg.UserID),
+		"msgAccount":  lua.LString(msg.Account),
+		"msgChannel":  lua.LString(msg.Channel),
+	})
+	if err != nil {
+		return err
+	}
+	defer L.Close()
+	msg.Text = lua.LVAsString(L.GetGlobal("msgText"))
+	msg.Username = lua.LVAsString(L.GetGlobal("msgUsername"))
+	return nil
+}
+
+// modifyUsernameLua is the lua version of modifyUsernameTengo, with the same globals.
+func (gw *Gateway) modifyUsernameLua(filename string, msg *config.Message, br *bridge.Bridge) (string, error) {
+	L, err := gw.runLua(filename, map[string]lua.LValue{
+		"result":        lua.LString(""),
+		"msgText":       lua.LString(msg.Text),
+		"msgUsername":   lua.LString(msg.Username),
+		"msgUserID":     lua.LString(msg.UserID),
+		"nick":          lua.LString(msg.Username),
+		"msgAccount":    lua.LString(msg.Account),
+		"msgChannel":    lua.LString(msg.Channel),
+		"channel":       lua.LString(msg.Channel),
+		"msgProtocol":   lua.LString(msg.Protocol),
+		"remoteAccount": lua.LString(br.Account),
+		"protocol":      lua.LString(br.Protocol),
+		"bridge":        lua.LString(br.Name),
+		"gateway":       lua.LString(gw.Name),
+	})
+	if err != nil {
+		return "", err
+	}
+	defer L.Close()
+	return lua.LVAsString(L.GetGlobal("result")), nil
+}
+
+// modifyOutMessageLua is the lua version of modifyOutMessageTengo, with the same globals.
+func (gw *Gateway) modifyOutMessageLua(filename string, origmsg *config.Message, msg *config.Message, br *bridge.Bridge) (bool, error) {
+	L, err := gw.runLua(filename, map[string]lua.LValue{
+		"inAccount":   lua.LString(origmsg.Account),
+		"inProtocol":  lua.LString(origmsg.Protocol),
+		"inChannel":   lua.LString(origmsg.Channel),
+		"inGateway":   lua.LString(origmsg.Gateway),
+		"inEvent":     lua.LString(gw.scriptEvent(origmsg.Event)),
+		"outAccount":  lua.LString(br.Account),
+		"outProtocol": lua.LString(br.Protocol),
+		"outChannel":  lua.LString(msg.Channel),
+		"outGateway":  lua.LString(gw.Name),
+		"outEvent":    lua.LString(gw.scriptEvent(msg.Event)),
+		"msgText":     lua.LString(msg.Text),
+		"msgUsername": lua.LString(msg.Username),
+		"msgUserID":   lua.LString(msg.UserID),
+		"msgDrop":     lua.LFalse,
+	})
+	if err != nil {
+		return false, err
+	}
+	defer L.Close()
+	msg.Text = lua.LVAsString(L.GetGlobal("msgText"))
+	msg.Username = lua.LVAsString(L.GetGlobal("msgUsername"))
+	return lua.LVAsBool(L.GetGlobal("msgDrop")), nil
+}
+
+// luaLoader loads the matterbridge module, the lua version of the tengo built-ins.
+// Failing functions return nil and the error message.
+func (gw *Gateway) luaLoader(L *lua.LState) int {
+	mod := L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"http_get":  gw.luaHTTPGet,
+		"kv_get":    gw.luaKVGet,
+		"kv_set":    gw.luaKVSet,
+		"kv_delete": gw.luaKVDelete,
+		"send":      gw.luaSend,
+	})
+	L.Push(mod)
+	return 1
+}
+
+func luaResult(L *lua.LState, err error) int {
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+	L.Push(lua.LTrue)
+	return 1
+}
+
+// luaHTTPGet implements http_get(url), returning a table with the status and body of the response.
+func (gw *Gateway) luaHTTPGet(L *lua.LState) int {
+	status, body, err := gw.scriptHTTPGet(L.CheckString(1))
+	if err != nil {
+		return luaResult(L, err)
+	}
+	res := L.NewTable()
+	res.RawSetString("status", lua.LNumber(status))
+	res.RawSetString("body", lua.LString(body))
+	L.Push(res)
+	return 1
+}
+
+// luaKVGet implements kv_get(key), returning nil when key isn't set.
+func (gw *Gateway) luaKVGet(L *lua.LState) int {
+	v, ok := gw.scriptBucket().GetString(L.CheckString(1))
+	if !ok {
+		L.Push(lua.LNil)
+		return 1
+	}
+	L.Push(lua.LString(v))
+	return 1
+}
+
+// luaKVSet implements kv_set(key, value[, ttl]), the ttl is in seconds.
+func (gw *Gateway) luaKVSet(L *lua.LState) int {
+	ttl := time.Duration(L.OptInt(3, 0)) * time.Second
+	return luaResult(L, gw.scriptBucket().SetStringTTL(L.CheckString(1), L.CheckString(2), ttl))
+}
+
+// luaKVDelete implements kv_delete(key).
+func (gw *Gateway) luaKVDelete(L *lua.LState) int {
+	return luaResult(L, gw.scriptBucket().Delete(L.CheckString(1)))
+}
+
+// luaSend implements send(gateway, text[, channel]).
+func (gw *Gateway) luaSend(L *lua.LState) int {
+	return luaResult(L, gw.scriptSend(L.CheckString(1), L.CheckString(2), L.OptString(3, "")))
+}
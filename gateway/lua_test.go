@@ -0,0 +1,111 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeLua(t *testing.T, script string) string {
+	filename := filepath.Join(t.TempDir(), "test.lua")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(script), 0o600))
+	return filename
+}
+
+func TestIsLuaScript(t *testing.T) {
+	assert.True(t, isLuaScript("filter.lua"))
+	assert.True(t, isLuaScript("/etc/matterbridge/FILTER.LUA"))
+	assert.False(t, isLuaScript("filter.tengo"))
+	assert.False(t, isLuaScript(""))
+}
+
+func TestLuaInMessage(t *testing.T) {
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+
+	filename := writeLua(t, `if string.find(msgText, "blah") then
+	msgText = "replaced by this"
+	msgUsername = msgUsername .. "@" .. msgAccount
+end`)
+	msg := &config.Message{Username: "user", Text: "blah testing", Account: "irc.freenode", Channel: "#wimtesting"}
+	require.NoError(t, gw.modifyInMessage(filename, msg))
+	assert.Equal(t, "replaced by this", msg.Text)
+	assert.Equal(t, "user@irc.freenode", msg.Username)
+
+	assert.Error(t, gw.modifyInMessage(writeLua(t, "msgText = ("), msg))
+}
+
+func TestLuaOutMessage(t *testing.T) {
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+	br := gw.Bridges["slack.test"]
+
+	filename := writeLua(t, `if inProtocol == "irc" and outProtocol == "slack" then
+	msgText = "[" .. inChannel .. "] " .. msgText
+end
+msgDrop = msgUsername == "spammer"`)
+	origmsg := &config.Message{Text: "hi", Username: "user", Account: "irc.freenode", Protocol: "irc", Channel: "#wimtesting"}
+	msg := &config.Message{Text: "hi", Username: "user", Channel: "testing"}
+	drop, err := gw.modifyOutMessageLua(filename, origmsg, msg, br)
+	require.NoError(t, err)
+	assert.False(t, drop)
+	assert.Equal(t, "[#wimtesting] hi", msg.Text)
+
+	msg = &config.Message{Text: "buy now", Username: "spammer", Channel: "testing"}
+	drop, err = gw.modifyOutMessageLua(filename, origmsg, msg, br)
+	require.NoError(t, err)
+	assert.True(t, drop)
+}
+
+func TestLuaLegacyJoinLeave(t *testing.T) {
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+	br := gw.Bridges["slack.test"]
+	filename := writeLua(t, `msgText = inEvent .. " " .. outEvent`)
+	origmsg := &config.Message{Event: config.EventQuit, Account: "irc.freenode"}
+
+	msg := &config.Message{Event: config.EventQuit}
+	_, err := gw.modifyOutMessageLua(filename, origmsg, msg, br)
+	require.NoError(t, err)
+	assert.Equal(t, "quit quit", msg.Text)
+
+	gw.BridgeValues().General.LegacyJoinLeave = true
+	msg = &config.Message{Event: config.EventQuit}
+	_, err = gw.modifyOutMessageLua(filename, origmsg, msg, br)
+	require.NoError(t, err)
+	assert.Equal(t, "join_leave join_leave", msg.Text)
+}
+
+func TestLuaModule(t *testing.T) {
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+	sent := make(chan config.Message, 10)
+	for _, br := range gw.Bridges {
+		br.Bridger = &chanBridger{sent: sent}
+	}
+
+	filename := writeLua(t, `local mb = require("matterbridge")
+local karma = tonumber(mb.kv_get("karma") or "0") + 1
+mb.kv_set("karma", tostring(karma))
+mb.send("bridge1", "karma " .. karma, "general")
+local ok, err = mb.send("nope", "hello")
+msgText = err`)
+	msg := &config.Message{}
+	require.NoError(t, gw.modifyInMessage(filename, msg))
+	assert.Equal(t, "unknown gateway nope", msg.Text)
+	v, _ := gw.scriptBucket().GetString("karma")
+	assert.Equal(t, "1", v)
+
+	select {
+	case m := <-sent:
+		assert.Equal(t, "karma 1", m.Text)
+		assert.Equal(t, "general", m.Channel)
+	case <-time.After(time.Second):
+		t.Fatal("message not sent")
+	}
+}
@@ -0,0 +1,158 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+const defaultMentionFormat = "@{NICK}"
+
+// mentionFormats are the mentions that notify the user on the protocols where @nick doesn't,
+// the MentionFormat of a bridge overrides them.
+var mentionFormats = map[string]string{
+	"discord": "<@{ID}>",
+	"irc":     "{NICK}:",
+	"slack":   "<@{ID}>",
+	"xmpp":    "{NICK}:",
+	"zulip":   "@**{NICK}**",
+}
+
+// identity is a user on a bridge.
+type identity struct {
+	ID   string
+	Nick string
+}
+
+// mentions keeps the identities of the users of the gateway. Identities links the
+// configured account:user entries of a user, the learned identities are in the store.
+type mentions struct {
+	users []map[string]string // the user by account, for every configured user
+	index map[string]int      // the configured user of the lowercase account:user
+}
+
+// addMentions sets up the mention translation of the gateway, if configured.
+func (gw *Gateway) addMentions() error {
+	cfg := gw.MyConfig.Mentions
+	if len(cfg.Identities) == 0 && !cfg.Learn && !gw.BridgeValues().General.IdentityLinking {
+		return nil
+	}
+	m := &mentions{index: make(map[string]int)}
+	for _, entries := range cfg.Identities {
+		users := make(map[string]string)
+		for _, entry := range entries {
+			idx := strings.Index(entry, ":")
+			if idx <= 0 || idx == len(entry)-1 {
+				return fmt.Errorf("mentions of gateway %s: invalid identity %s, use <account>:<user>", gw.Name, entry)
+			}
+			users[entry[:idx]] = entry[idx+1:]
+			m.index[strings.ToLower(entry)] = len(m.users)
+		}
+		m.users = append(m.users, users)
+	}
+	gw.mentions = m
+	return nil
+}
+
+// learnedBucket keeps the identities seen on the bridges of the gateway, by account:nick
+// and account:userid.
+func (gw *Gateway) learnedBucket() *store.Bucket {
+	return store.NewBucket(gw.Router.Store, "mentions:"+gw.Name)
+}
+
+func (gw *Gateway) learned(account, user string) (identity, bool) {
+	data, ok := gw.learnedBucket().GetString(strings.ToLower(account + ":" + user))
+	if !ok {
+		return identity{}, false
+	}
+	var id identity
+	if err := json.Unmarshal([]byte(data), &id); err != nil {
+		return identity{}, false
+	}
+	return id, true
+}
+
+// learnIdentity keeps the identity of the sender of msg, with Learn.
+func (gw *Gateway) learnIdentity(msg *config.Message) {
+	if gw.mentions == nil || !gw.MyConfig.Mentions.Learn || msg.Username == "" || msg.UserID == "" || msg.Event != "" {
+		return
+	}
+	data, err := json.Marshal(identity{ID: msg.UserID, Nick: msg.Username})
+	if err != nil {
+		return
+	}
+	bucket := gw.learnedBucket()
+	for _, user := range []string{msg.Username, msg.UserID} {
+		key := strings.ToLower(msg.Account + ":" + user)
+		if v, ok := bucket.GetString(key); ok && v == string(data) {
+			continue
+		}
+		if err := bucket.SetString(key, string(data)); err != nil {
+			gw.logger.Errorf("mentions: failed to learn %s: %s", key, err)
+		}
+	}
+}
+
+// identityOn returns the identity on dest of the user mentioned as nick on the account of
+// the sender: the account the user linked, the configured one or the learned one.
+func (gw *Gateway) identityOn(account, nick string, dest *bridge.Bridge) (identity, bool) {
+	u, ok := gw.Router.linkedUser(account, nick)
+	if !ok && gw.BridgeValues().General.IdentityNames {
+		u, ok = gw.Router.namedUser(nick)
+	}
+	if ok {
+		if a, ok := u.account(dest.Account); ok {
+			return identity{ID: a.UserID, Nick: a.Nick}, true
+		}
+	}
+	m := gw.mentions
+	i, ok := m.index[strings.ToLower(account+":"+nick)]
+	if !ok {
+		// the mention of a user configured by ID
+		if src, learned := gw.learned(account, nick); learned {
+			i, ok = m.index[strings.ToLower(account+":"+src.ID)]
+		}
+	}
+	if ok {
+		if user, found := m.users[i][dest.Account]; found {
+			if id, learned := gw.learned(dest.Account, user); learned {
+				return id, true
+			}
+			return identity{ID: user, Nick: user}, true
+		}
+	}
+	if !gw.MyConfig.Mentions.Learn {
+		return identity{}, false
+	}
+	return gw.learned(dest.Account, nick)
+}
+
+// translateMentions returns the text of msg with the @nick mentions of users known on dest
+// replaced with their mentions there, formatted with the MentionFormat of dest.
+func (gw *Gateway) translateMentions(rmsg *config.Message, msg *config.Message, dest *bridge.Bridge) string {
+	if gw.mentions == nil || msg.Text == "" || (msg.Event != "" && msg.Event != config.EventUserAction) {
+		return msg.Text
+	}
+	format := dest.GetString("MentionFormat")
+	if format == "" {
+		format = mentionFormats[dest.Protocol]
+	}
+	if format == "" {
+		format = defaultMentionFormat
+	}
+	return mentionRE.ReplaceAllStringFunc(msg.Text, func(mention string) string {
+		if !strings.HasPrefix(mention, "@") {
+			return mention
+		}
+		nick := strings.TrimRight(mention[1:], ".")
+		id, ok := gw.identityOn(rmsg.Account, nick, dest)
+		if !ok {
+			return mention
+		}
+		return strings.NewReplacer("{ID}", id.ID, "{NICK}", id.Nick).Replace(format) + mention[1+len(nick):]
+	})
+}
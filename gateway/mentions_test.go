@@ -0,0 +1,77 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigMentions = []byte(`
+[telegram.main]
+server=""
+[slack.work]
+server=""
+[irc.libera]
+server=""
+[mattermost.test]
+server=""
+MentionFormat="@{NICK} ({ID})"
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [gateway.mentions]
+    identities = [["telegram.main:alice", "slack.work:U123", "irc.libera:alice_"]]
+    learn = true
+
+    [[gateway.inout]]
+    account = "telegram.main"
+    channel = "-100"
+
+    [[gateway.inout]]
+    account = "slack.work"
+    channel = "general"
+
+    [[gateway.inout]]
+    account = "irc.libera"
+    channel = "#general"
+
+    [[gateway.inout]]
+    account = "mattermost.test"
+    channel = "town-square"
+`)
+
+func TestTranslateMentions(t *testing.T) {
+	gw := maketestRouter(testconfigMentions).Gateways["bridge1"]
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+	// bob is learned on slack and mattermost
+	gw.learnIdentity(&config.Message{Text: "hi", Username: "bob", UserID: "U456", Account: "slack.work"})
+	gw.learnIdentity(&config.Message{Text: "hi", Username: "bob", UserID: "m1", Account: "mattermost.test"})
+
+	gw.relayMessage(&config.Message{
+		Text: "@alice and @bob, ask @carol.", Username: "dave", UserID: "1", Channel: "-100", Account: "telegram.main", Protocol: "telegram", Gateway: "bridge1",
+	})
+	assert.Equal(t, "<@U123> and <@U456>, ask @carol.", recorders["slack.work"].sent[0].Text)
+	assert.Equal(t, "alice_: and @bob, ask @carol.", recorders["irc.libera"].sent[0].Text)
+	assert.Equal(t, "@alice and @bob (m1), ask @carol.", recorders["mattermost.test"].sent[0].Text)
+
+	// users configured by ID are mentioned by their nick once learned
+	gw.learnIdentity(&config.Message{Text: "hi", Username: "alice.s", UserID: "U123", Account: "slack.work"})
+	gw.relayMessage(&config.Message{
+		Text: "thanks @alice.s", Username: "bob", UserID: "U456", Channel: "general", Account: "slack.work", Protocol: "slack", Gateway: "bridge1",
+	})
+	assert.Equal(t, "thanks @alice", recorders["telegram.main"].sent[0].Text)
+	assert.Equal(t, "thanks alice_:", recorders["irc.libera"].sent[1].Text)
+}
+
+func TestAddMentionsInvalid(t *testing.T) {
+	gw := maketestRouter(testconfigMentions).Gateways["bridge1"]
+	gw.MyConfig.Mentions.Identities = [][]string{{"alice"}}
+	assert.Error(t, gw.addMentions())
+}
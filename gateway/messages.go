@@ -0,0 +1,118 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+// msgMapTTL is how long message ID mappings are kept in a persistent store.
+const msgMapTTL = 30 * 24 * time.Hour
+
+const (
+	msgIDStoreStore  = "store"
+	msgIDStoreSQLite = "sqlite"
+	msgIDStoreNone   = "none"
+)
+
+// StoredMsgID is the serializable form of BrMsgID.
+type StoredMsgID struct {
+	Account   string
+	ID        string
+	ChannelID string
+}
+
+// MsgIDStore keeps the message ID mappings of the gateways beyond the in-memory cache,
+// so edits and deletes are still relayed after a restart or for old messages.
+type MsgIDStore interface {
+	// Get returns the IDs of the copies of the canonical message of gateway.
+	Get(gateway, canonical string) ([]StoredMsgID, bool, error)
+	// Put replaces the IDs of the copies of the canonical message of gateway.
+	Put(gateway, canonical string, ids []StoredMsgID) error
+	// Canonical returns the canonical message of gateway of which id is a copy.
+	Canonical(gateway, id string) (string, bool, error)
+	Close() error
+}
+
+// newMsgIDStore returns the MsgIDStore configured with MessageIDStore. By default the
+// mappings are kept in the store of the router when it's persistent, nil is returned
+// when they're only kept in memory.
+func newMsgIDStore(general *config.Protocol, st store.Store) (MsgIDStore, error) {
+	switch strings.ToLower(general.MessageIDStore) {
+	case "", msgIDStoreStore:
+		if !store.IsPersistent(general) {
+			return nil, nil
+		}
+		return &kvMsgIDStore{st: st}, nil
+	case msgIDStoreSQLite:
+		if general.MessageIDStorePath == "" {
+			return nil, fmt.Errorf("MessageIDStore %s needs a MessageIDStorePath", msgIDStoreSQLite)
+		}
+		return newSQLiteMsgIDStore(general.MessageIDStorePath, msgMapTTL)
+	case msgIDStoreNone:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown MessageIDStore %s", general.MessageIDStore)
+}
+
+// getMsgIDs returns the downstream message IDs of the canonical msgID. When the
+// message isn't cached in memory anymore it's looked up in the MsgIDStore.
+func (gw *Gateway) getMsgIDs(msgID string) ([]*BrMsgID, bool) {
+	if res, ok := gw.Messages.Get(msgID); ok {
+		return res.([]*BrMsgID), true
+	}
+	if gw.Router == nil || gw.Router.msgIDs == nil {
+		return nil, false
+	}
+	stored, ok, err := gw.Router.msgIDs.Get(gw.Name, msgID)
+	if err != nil {
+		gw.logger.Errorf("failed to read message map entry %s: %s", msgID, err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	ids := make([]*BrMsgID, 0, len(stored))
+	for _, s := range stored {
+		br, ok := gw.Bridges[s.Account]
+		if !ok {
+			continue
+		}
+		ids = append(ids, &BrMsgID{br, s.ID, s.ChannelID})
+	}
+	gw.Messages.Add(msgID, ids)
+	return ids, true
+}
+
+// addMsgIDs records the downstream message IDs of the canonical msgID.
+func (gw *Gateway) addMsgIDs(msgID string, ids []*BrMsgID) {
+	gw.Messages.Add(msgID, ids)
+	if gw.Router == nil || gw.Router.msgIDs == nil {
+		return
+	}
+	stored := make([]StoredMsgID, 0, len(ids))
+	for _, id := range ids {
+		stored = append(stored, StoredMsgID{id.br.Account, id.ID, id.ChannelID})
+	}
+	if err := gw.Router.msgIDs.Put(gw.Name, msgID, stored); err != nil {
+		gw.logger.Errorf("failed to store message map entry %s: %s", msgID, err)
+	}
+}
+
+// storedCanonicalMsgID looks up the canonical message of the downstream ID in the MsgIDStore.
+func (gw *Gateway) storedCanonicalMsgID(id string) string {
+	if gw.Router == nil || gw.Router.msgIDs == nil {
+		return ""
+	}
+	canonical, ok, err := gw.Router.msgIDs.Canonical(gw.Name, id)
+	if err != nil {
+		gw.logger.Errorf("failed to look up message map entry of %s: %s", id, err)
+	}
+	if !ok {
+		return ""
+	}
+	return canonical
+}
@@ -0,0 +1,174 @@
+package gateway
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/listener"
+	"github.com/42wim/matterbridge/gateway/events"
+)
+
+// metricsLatencyBuckets are the upper bounds of the buckets of the send latency histogram, in seconds.
+var metricsLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range metricsLatencyBuckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// accountMetrics are the metrics of a bridge.
+type accountMetrics struct {
+	relayed     uint64
+	failed      uint64
+	dropped     uint64
+	mediaBytes  uint64
+	reconnects  uint64
+	connected   bool
+	lastRelayed time.Time
+	latency     histogram
+}
+
+// metrics counts the events of the router by account for the /metrics endpoint, in the
+// text format of prometheus.
+type metrics struct {
+	sync.Mutex
+	accounts map[string]*accountMetrics
+}
+
+func newMetrics() *metrics {
+	return &metrics{accounts: make(map[string]*accountMetrics)}
+}
+
+func (m *metrics) handle(ev events.Event) {
+	m.Lock()
+	defer m.Unlock()
+	a, ok := m.accounts[ev.Account]
+	if !ok {
+		a = &accountMetrics{latency: histogram{counts: make([]uint64, len(metricsLatencyBuckets))}}
+		m.accounts[ev.Account] = a
+	}
+	switch ev.Kind {
+	case events.MessageRelayed:
+		a.relayed++
+		a.lastRelayed = ev.Time
+		a.latency.observe(ev.Duration.Seconds())
+	case events.SendFailed:
+		a.failed++
+		a.latency.observe(ev.Duration.Seconds())
+	case events.MessageDropped:
+		a.dropped++
+	case events.MediaDownloaded:
+		if ev.File != nil {
+			a.mediaBytes += uint64(ev.File.DataSize())
+		}
+	case events.BridgeConnected:
+		a.connected = true
+	case events.BridgeDisconnected:
+		a.connected = false
+		a.reconnects++
+	}
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	m.write(bw)
+	bw.Flush()
+}
+
+// write writes the metrics in the prometheus text format.
+func (m *metrics) write(w io.Writer) {
+	m.Lock()
+	defer m.Unlock()
+	accounts := make([]string, 0, len(m.accounts))
+	for account := range m.accounts {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	metric := func(name, typ, help string, value func(a *accountMetrics) string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, account := range accounts {
+			fmt.Fprintf(w, "%s{account=\"%s\"} %s\n", name, escapeLabel(account), value(m.accounts[account]))
+		}
+	}
+	metric("matterbridge_messages_relayed_total", "counter", "Messages sent to the bridge.",
+		func(a *accountMetrics) string { return fmt.Sprint(a.relayed) })
+	metric("matterbridge_send_failures_total", "counter", "Messages the bridge failed to send.",
+		func(a *accountMetrics) string { return fmt.Sprint(a.failed) })
+	metric("matterbridge_messages_dropped_total", "counter", "Messages not sent to the bridge because of the content policy or a tengo script.",
+		func(a *accountMetrics) string { return fmt.Sprint(a.dropped) })
+	metric("matterbridge_media_downloaded_bytes_total", "counter", "Bytes of the files downloaded by the bridge.",
+		func(a *accountMetrics) string { return fmt.Sprint(a.mediaBytes) })
+	metric("matterbridge_reconnects_total", "counter", "Reconnects of the bridge.",
+		func(a *accountMetrics) string { return fmt.Sprint(a.reconnects) })
+	metric("matterbridge_bridge_connected", "gauge", "Whether the bridge is connected.",
+		func(a *accountMetrics) string {
+			if a.connected {
+				return "1"
+			}
+			return "0"
+		})
+	metric("matterbridge_last_relayed_timestamp_seconds", "gauge", "Time of the last message sent to the bridge, 0 if none was sent.",
+		func(a *accountMetrics) string {
+			if a.lastRelayed.IsZero() {
+				return "0"
+			}
+			return fmt.Sprint(a.lastRelayed.Unix())
+		})
+
+	fmt.Fprint(w, "# HELP matterbridge_send_duration_seconds Time to send a message to the bridge.\n"+
+		"# TYPE matterbridge_send_duration_seconds histogram\n")
+	for _, account := range accounts {
+		h, label := &m.accounts[account].latency, escapeLabel(account)
+		for i, le := range metricsLatencyBuckets {
+			fmt.Fprintf(w, "matterbridge_send_duration_seconds_bucket{account=\"%s\",le=\"%g\"} %d\n", label, le, h.counts[i])
+		}
+		fmt.Fprintf(w, "matterbridge_send_duration_seconds_bucket{account=\"%s\",le=\"+Inf\"} %d\n", label, h.count)
+		fmt.Fprintf(w, "matterbridge_send_duration_seconds_sum{account=\"%s\"} %g\n", label, h.sum)
+		fmt.Fprintf(w, "matterbridge_send_duration_seconds_count{account=\"%s\"} %d\n", label, h.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// startMetrics serves the metrics on /metrics of MetricsBindAddress, if it's set.
+func (r *Router) startMetrics() {
+	general := r.BridgeValues().General
+	if general.MetricsBindAddress == "" {
+		return
+	}
+	m := newMetrics()
+	r.Events.Subscribe(m.handle,
+		events.MessageRelayed, events.SendFailed, events.MessageDropped,
+		events.MediaDownloaded, events.BridgeConnected, events.BridgeDisconnected)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go func() {
+		cfg := listener.NewConfig(general.MetricsBindAddress, &general)
+		if err := listener.Serve(r.logger, cfg, mux); err != nil {
+			r.logger.Errorf("metrics: listener on %s failed: %s", general.MetricsBindAddress, err)
+		}
+	}()
+}
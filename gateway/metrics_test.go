@@ -0,0 +1,47 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/gateway/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	m := newMetrics()
+	data := []byte("hello")
+	m.handle(events.Event{Kind: events.BridgeConnected, Account: "irc.freenode"})
+	m.handle(events.Event{Kind: events.MessageRelayed, Account: "irc.freenode", Time: time.Unix(1700000000, 0), Duration: 20 * time.Millisecond})
+	m.handle(events.Event{Kind: events.SendFailed, Account: "irc.freenode", Duration: 3 * time.Second})
+	m.handle(events.Event{Kind: events.MessageDropped, Account: "irc.freenode"})
+	m.handle(events.Event{Kind: events.MediaDownloaded, Account: `slack.my"team`, File: &config.FileInfo{Data: &data}})
+	m.handle(events.Event{Kind: events.BridgeDisconnected, Account: "irc.freenode"})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE matterbridge_messages_relayed_total counter",
+		`matterbridge_messages_relayed_total{account="irc.freenode"} 1`,
+		`matterbridge_send_failures_total{account="irc.freenode"} 1`,
+		`matterbridge_messages_dropped_total{account="irc.freenode"} 1`,
+		`matterbridge_media_downloaded_bytes_total{account="slack.my\"team"} 5`,
+		`matterbridge_reconnects_total{account="irc.freenode"} 1`,
+		`matterbridge_bridge_connected{account="irc.freenode"} 0`,
+		`matterbridge_last_relayed_timestamp_seconds{account="irc.freenode"} 1700000000`,
+		`matterbridge_last_relayed_timestamp_seconds{account="slack.my\"team"} 0`,
+		"# TYPE matterbridge_send_duration_seconds histogram",
+		`matterbridge_send_duration_seconds_bucket{account="irc.freenode",le="0.01"} 0`,
+		`matterbridge_send_duration_seconds_bucket{account="irc.freenode",le="0.025"} 1`,
+		`matterbridge_send_duration_seconds_bucket{account="irc.freenode",le="5"} 2`,
+		`matterbridge_send_duration_seconds_bucket{account="irc.freenode",le="+Inf"} 2`,
+		`matterbridge_send_duration_seconds_sum{account="irc.freenode"} 3.02`,
+		`matterbridge_send_duration_seconds_count{account="irc.freenode"} 2`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
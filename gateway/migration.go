@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const migrationDateFormat = "2006-01-02"
+
+// migration dual-writes the messages of a gateway to the channel a community moves from
+// and the channel it moves to, while only the messages of the primary channel are relayed.
+// Reading one channel keeps the copies sent to the other one from being relayed back.
+type migration struct {
+	from    string // channel IDs
+	to      string
+	primary string
+	until   time.Time // zero when the transition doesn't end
+}
+
+func (gw *Gateway) addMigration() error {
+	cfg := gw.MyConfig.Migration
+	if cfg.FromAccount == "" && cfg.ToAccount == "" {
+		return nil
+	}
+	m := &migration{
+		from: migrationChannelID(cfg.FromAccount, cfg.FromChannel),
+		to:   migrationChannelID(cfg.ToAccount, cfg.ToChannel),
+	}
+	for _, ID := range []string{m.from, m.to} {
+		channel, ok := gw.Channels[ID]
+		if !ok || channel.Direction != "inout" {
+			return fmt.Errorf("migration of gateway %s: %s isn't an inout channel of the gateway", gw.Name, ID)
+		}
+	}
+	switch strings.ToLower(cfg.Primary) {
+	case "", "from":
+		m.primary = m.from
+	case "to":
+		m.primary = m.to
+	default:
+		return fmt.Errorf("migration of gateway %s: primary %q isn't from or to", gw.Name, cfg.Primary)
+	}
+	if cfg.Until != "" {
+		until, err := time.ParseInLocation(migrationDateFormat, cfg.Until, time.Local)
+		if err != nil {
+			return fmt.Errorf("migration of gateway %s: %w", gw.Name, err)
+		}
+		m.until = until
+	}
+	gw.logger.Infof("gateway %s migrates %s to %s, relaying the messages of %s", gw.Name, m.from, m.to, m.primary)
+	gw.migration = m
+	return nil
+}
+
+// migrationChannelID is the ID of the channel in gw.Channels, see mapChannelConfig.
+func migrationChannelID(account, channel string) string {
+	if strings.HasPrefix(account, "irc.") {
+		channel = strings.ToLower(channel)
+	}
+	return channel + account
+}
+
+// done returns true when the transition is over and the From channel is left alone.
+func (m *migration) done(now time.Time) bool {
+	return !m.until.IsZero() && !now.Before(m.until)
+}
+
+// reads returns false when the messages of the channel with ID aren't relayed: during the
+// transition those of the channel that isn't the primary, afterwards those of From.
+func (m *migration) reads(ID string, now time.Time) bool {
+	if m == nil {
+		return true
+	}
+	if m.done(now) {
+		return ID != m.from
+	}
+	return ID == m.primary || (ID != m.from && ID != m.to)
+}
+
+// writes returns false when messages aren't sent to the channel with ID anymore.
+func (m *migration) writes(ID string, now time.Time) bool {
+	return m == nil || ID != m.from || !m.done(now)
+}
+
+// filter removes the channels that aren't written to from channels.
+func (m *migration) filter(channels []config.ChannelInfo, now time.Time) []config.ChannelInfo {
+	if m == nil {
+		return channels
+	}
+	filtered := channels[:0]
+	for _, channel := range channels {
+		if m.writes(channel.ID, now) {
+			filtered = append(filtered, channel)
+		}
+	}
+	return filtered
+}
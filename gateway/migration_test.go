@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigMigration = []byte(`
+[irc.freenode]
+server=""
+[slack.test]
+token=""
+[matrix.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [gateway.migration]
+    fromaccount = "slack.test"
+    fromchannel = "general"
+    toaccount = "matrix.test"
+    tochannel = "#general:example.com"
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account = "matrix.test"
+    channel = "#general:example.com"
+`)
+
+// destAccounts returns the accounts msg is relayed to, without its own channel.
+func destAccounts(gw *Gateway, msg *config.Message) []string {
+	var accounts []string
+	for _, account := range []string{"irc.freenode", "slack.test", "matrix.test"} {
+		for _, channel := range gw.getDestChannel(msg, *gw.Bridges[account]) {
+			if channel.ID != getChannelID(msg) {
+				accounts = append(accounts, channel.Account)
+			}
+		}
+	}
+	return accounts
+}
+
+func TestMigration(t *testing.T) {
+	r := maketestRouter(testconfigMigration)
+	gw := r.Gateways["bridge1"]
+	require.NotNil(t, gw.migration)
+
+	irc := &config.Message{Text: "test", Channel: "#wimtesting", Account: "irc.freenode", Gateway: "bridge1"}
+	slack := &config.Message{Text: "test", Channel: "general", Account: "slack.test", Gateway: "bridge1"}
+	matrix := &config.Message{Text: "test", Channel: "#general:example.com", Account: "matrix.test", Gateway: "bridge1"}
+
+	// messages are written to both, only those of the primary are read
+	assert.Equal(t, []string{"slack.test", "matrix.test"}, destAccounts(gw, irc))
+	assert.Equal(t, []string{"irc.freenode", "matrix.test"}, destAccounts(gw, slack))
+	assert.Empty(t, destAccounts(gw, matrix))
+
+	gw.migration.primary = gw.migration.to
+	assert.Equal(t, []string{"irc.freenode", "slack.test"}, destAccounts(gw, matrix))
+	assert.Empty(t, destAccounts(gw, slack))
+
+	// after the transition the old channel is left alone
+	gw.migration.until = time.Now().Add(-time.Minute)
+	assert.Equal(t, []string{"matrix.test"}, destAccounts(gw, irc))
+	assert.Equal(t, []string{"irc.freenode"}, destAccounts(gw, matrix))
+	assert.Empty(t, destAccounts(gw, slack))
+}
+
+func TestMigrationConfig(t *testing.T) {
+	r := maketestRouter(testconfigMigration)
+	gw := r.Gateways["bridge1"]
+	gw.migration = nil
+
+	gw.MyConfig.Migration.Until = "2024-06-01"
+	gw.MyConfig.Migration.Primary = "to"
+	require.NoError(t, gw.addMigration())
+	assert.Equal(t, "#general:example.commatrix.test", gw.migration.primary)
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local), gw.migration.until)
+
+	gw.MyConfig.Migration.Primary = "both"
+	assert.Error(t, gw.addMigration())
+	gw.MyConfig.Migration.Primary = ""
+	gw.MyConfig.Migration.ToChannel = "#random:example.com"
+	assert.Error(t, gw.addMigration())
+}
@@ -0,0 +1,172 @@
+package gateway
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// defaultModerationTimeout is how long a held message waits for approval.
+const defaultModerationTimeout = 24 * time.Hour
+
+var (
+	approveReactions = map[string]bool{"✅": true, "✔️": true, "white_check_mark": true, "heavy_check_mark": true}
+	rejectReactions  = map[string]bool{"❌": true, "x": true}
+)
+
+// moderation keeps the messages from untrusted channels that are waiting for approval,
+// keyed by the ID of the notice posted in the moderation channel.
+type moderation struct {
+	sync.Mutex
+	pending map[string]config.Message
+}
+
+// take removes and returns the held message for the notice with ID id.
+func (m *moderation) take(id string) (config.Message, bool) {
+	m.Lock()
+	defer m.Unlock()
+	msg, ok := m.pending[id]
+	delete(m.pending, id)
+	return msg, ok
+}
+
+func (m *moderation) hold(id string, msg config.Message) {
+	m.Lock()
+	m.pending[id] = msg
+	m.Unlock()
+}
+
+// addModeration sets up the moderation channel of the gateway, if configured.
+// The channel is joined but isn't part of gw.Channels, so nothing is relayed to or from it.
+func (gw *Gateway) addModeration() error {
+	cfg := gw.MyConfig.Moderation
+	if cfg.Account == "" {
+		return nil
+	}
+	if err := gw.AddBridge(&config.Bridge{Account: cfg.Account, Channel: cfg.Channel}); err != nil {
+		return err
+	}
+	channel := gw.moderationChannel()
+	gw.Bridges[cfg.Account].SetChannel(*channel)
+	if len(cfg.Moderators) == 0 {
+		gw.logger.Warnf("gateway %s: moderation has no moderators, the held messages can't be approved", gw.Name)
+	}
+	gw.moderation = &moderation{pending: make(map[string]config.Message)}
+	return nil
+}
+
+func (gw *Gateway) moderationChannel() *config.ChannelInfo {
+	cfg := gw.MyConfig.Moderation
+	return sideChannel(cfg.Account, cfg.Channel)
+}
+
+// sideChannel returns the channel name on account, which the gateway posts in but which
+// isn't part of gw.Channels.
+func sideChannel(account, name string) *config.ChannelInfo {
+	// make sure to lowercase irc channels in config #348
+	if strings.HasPrefix(account, "irc.") {
+		name = strings.ToLower(name)
+	}
+	return &config.ChannelInfo{
+		Name:        name,
+		Account:     account,
+		Direction:   "inout",
+		ID:          name + account,
+		SameChannel: make(map[string]bool),
+	}
+}
+
+func (gw *Gateway) moderationTimeout() time.Duration {
+	if timeout := gw.MyConfig.Moderation.Timeout; timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	return defaultModerationTimeout
+}
+
+// isModerator returns true if the sender of msg may approve or reject held messages.
+// Moderators entries are account:userid or account:username, nobody is a moderator when
+// it's empty.
+func (gw *Gateway) isModerator(msg *config.Message) bool {
+	for _, moderator := range gw.MyConfig.Moderation.Moderators {
+		if moderator == msg.Account+":"+msg.UserID || moderator == msg.Account+":"+msg.Username {
+			return true
+		}
+	}
+	return false
+}
+
+// holdMessage posts msg in the moderation channel and returns true when it comes from
+// an untrusted channel. The message is relayed when a moderator approves it.
+func (gw *Gateway) holdMessage(msg *config.Message) bool {
+	if gw.moderation == nil {
+		return false
+	}
+	channel, ok := gw.Channels[getChannelID(msg)]
+	if !ok || !channel.Untrusted {
+		return false
+	}
+	if msg.Event != "" && msg.Event != config.EventUserAction {
+		return false
+	}
+	gw.hold(msg, "held from "+msg.Channel+" on "+msg.Account)
+	return true
+}
+
+// hold posts msg with reason in the moderation channel and keeps it until it's approved.
+func (gw *Gateway) hold(msg *config.Message, reason string) {
+	dest := gw.Bridges[gw.MyConfig.Moderation.Account]
+	notice := *msg
+	notice.ParentID = ""
+	notice.Text = "[" + reason + ", react with ✅ or reply approve to relay, ❌ or reject to discard] " + msg.Text
+	id, err := gw.SendMessage(&notice, dest, gw.moderationChannel(), "")
+	if err != nil || id == "" {
+		gw.logger.Errorf("moderation: failed to post held message from %s in %s, dropping it: %v", msg.Account, dest.Account, err)
+		return
+	}
+	gw.logger.Debugf("moderation: holding message %s from %s as %s", msg.ID, msg.Account, id)
+	mod := gw.moderation
+	mod.hold(id, *msg)
+
+	time.AfterFunc(gw.moderationTimeout(), func() {
+		if _, ok := mod.take(id); ok {
+			gw.logger.Infof("moderation: discarding message from %s (%s), not approved in time", msg.Username, msg.Account)
+		}
+	})
+}
+
+// handleModeration handles messages from the moderation channel, which are never relayed.
+// Approvals and rejections are reactions on or replies to the held message notice.
+func (gw *Gateway) handleModeration(msg *config.Message) bool {
+	if gw.moderation == nil || getChannelID(msg) != gw.moderationChannel().ID {
+		return false
+	}
+	if msg.ParentID == "" || !gw.isModerator(msg) {
+		return true
+	}
+
+	var approve, reject bool
+	switch msg.Event {
+	case config.EventReactionAdd:
+		approve, reject = approveReactions[msg.Text], rejectReactions[msg.Text]
+	case "":
+		text := strings.ToLower(strings.TrimSpace(msg.Text))
+		approve, reject = text == "approve", text == "reject"
+	}
+	if !approve && !reject {
+		return true
+	}
+
+	held, ok := gw.moderation.take(msg.ParentID)
+	if !ok {
+		return true
+	}
+	if reject {
+		gw.logger.Infof("moderation: %s rejected message from %s (%s)", msg.Username, held.Username, held.Account)
+		return true
+	}
+	gw.logger.Infof("moderation: %s approved message from %s (%s)", msg.Username, held.Username, held.Account)
+	gw.relayMessage(&held)
+	return true
+}
@@ -0,0 +1,105 @@
+package gateway
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigModeration = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [gateway.moderation]
+    account = "slack.test"
+    channel = "moderation"
+    moderators = ["slack.test:mod"]
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+    untrusted = true
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+	`)
+
+// recordBridger is a Bridger that records the messages sent to it.
+type recordBridger struct {
+	sent []config.Message
+}
+
+func (r *recordBridger) Send(msg config.Message) (string, error) {
+	r.sent = append(r.sent, msg)
+	return strconv.Itoa(len(r.sent)), nil
+}
+
+func (r *recordBridger) Connect() error                               { return nil }
+func (r *recordBridger) JoinChannel(channel config.ChannelInfo) error { return nil }
+func (r *recordBridger) Disconnect() error                            { return nil }
+func (r *recordBridger) Reload(cfg config.Config) error               { return nil }
+
+func TestModeration(t *testing.T) {
+	r := maketestRouter(testconfigModeration)
+	gw := r.Gateways["bridge1"]
+	assert.Len(t, gw.Bridges, 3)
+	assert.Len(t, gw.Channels, 2)
+	assert.Contains(t, gw.Bridges["slack.test"].Channels, "moderationslack.test")
+
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+
+	// messages from trusted channels are relayed
+	msg := &config.Message{Text: "hi", Channel: "general", Account: "discord.test", Gateway: "bridge1", Protocol: "discord"}
+	assert.False(t, gw.holdMessage(msg))
+
+	// messages from untrusted channels are held
+	msg = &config.Message{Text: "buy now", Username: "spammer", Channel: "#wimtesting", Account: "irc.freenode", Gateway: "bridge1", Protocol: "irc"}
+	assert.True(t, gw.holdMessage(msg))
+	assert.Len(t, recorders["slack.test"].sent, 1)
+	assert.Equal(t, "moderation", recorders["slack.test"].sent[0].Channel)
+	assert.Empty(t, recorders["discord.test"].sent)
+
+	// only moderators can approve
+	reaction := &config.Message{Text: "white_check_mark", Event: config.EventReactionAdd, Username: "someone", ParentID: "1", Channel: "moderation", Account: "slack.test"}
+	assert.True(t, gw.handleModeration(reaction))
+	assert.Empty(t, recorders["discord.test"].sent)
+
+	// the moderators are account:username or account:userid
+	reaction.Username, reaction.Account = "mod", "discord.test"
+	assert.False(t, gw.isModerator(reaction))
+	reaction.Account = "slack.test"
+	assert.True(t, gw.isModerator(reaction))
+	// nobody is a moderator without moderators
+	gw.MyConfig.Moderation.Moderators = nil
+	assert.False(t, gw.isModerator(reaction))
+	gw.MyConfig.Moderation.Moderators = []string{"slack.test:mod"}
+	assert.True(t, gw.handleModeration(reaction))
+	assert.Len(t, recorders["discord.test"].sent, 1)
+	assert.Contains(t, recorders["discord.test"].sent[0].Text, "buy now")
+
+	// rejected messages are discarded
+	assert.True(t, gw.holdMessage(msg))
+	reply := &config.Message{Text: "reject", Username: "mod", ParentID: "2", Channel: "moderation", Account: "slack.test"}
+	assert.True(t, gw.handleModeration(reply))
+	_, ok := gw.moderation.take("2")
+	assert.False(t, ok)
+	assert.Len(t, recorders["discord.test"].sent, 1)
+
+	// messages from other channels aren't moderation messages
+	assert.False(t, gw.handleModeration(&config.Message{Text: "approve", ParentID: "1", Channel: "general", Account: "discord.test"}))
+}
@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/gateway/bridgemap"
+)
+
+// handleModerationAction relays the bans and removals of moderators to the bridges of the
+// gateways with ApplyModeration. A delete of a relayed copy is a removal: only moderators
+// can delete the messages of the bridge. Returns true if msg was one, these aren't relayed
+// as messages.
+func (r *Router) handleModerationAction(msg *config.Message) bool {
+	switch msg.Event {
+	case config.EventUserBan:
+		for _, gw := range r.gateways() {
+			gw.relayBan(msg)
+		}
+		return true
+	case config.EventMsgDelete, config.EventMessageRemoveRequest:
+		if msg.ID == "" {
+			return false
+		}
+		removal := msg.Event == config.EventMessageRemoveRequest
+		for _, gw := range r.gateways() {
+			if gw.relayRemoval(msg) {
+				removal = true
+			}
+		}
+		return removal
+	}
+	return false
+}
+
+// moderatedBridges returns the bridges of the gateway, other than from, with ApplyModeration.
+func (gw *Gateway) moderatedBridges(from string) []*bridge.Bridge {
+	var dests []*bridge.Bridge
+	for _, dest := range gw.Bridges {
+		if dest.Account != from && dest.GetBool("ApplyModeration") {
+			dests = append(dests, dest)
+		}
+	}
+	return dests
+}
+
+// relayBan bans the user of the EventUserBan msg on the bridges of the gateway with
+// ApplyModeration that can ban.
+func (gw *Gateway) relayBan(msg *config.Message) {
+	if _, ok := gw.Bridges[msg.Account]; !ok {
+		return
+	}
+	if _, ok := gw.Channels[getChannelID(msg)]; msg.Channel != "" && !ok {
+		return
+	}
+	gw.syncBan(msg)
+	for _, dest := range gw.moderatedBridges(msg.Account) {
+		if _, ok := bridgemap.UserBanSupport[dest.Protocol]; !ok {
+			continue
+		}
+		for _, channel := range gw.Channels {
+			if channel.Account != dest.Account || !strings.Contains(channel.Direction, "out") {
+				continue
+			}
+			ban := config.Message{
+				Event: config.EventUserBan, Account: msg.Account, Protocol: msg.Protocol, Gateway: gw.Name,
+				Channel: channel.Name, UserID: msg.UserID, Username: msg.Username, Text: msg.Text,
+			}
+			gw.logger.Infof("moderation: banning %s of %s in %s on %s", msg.Username, msg.Account, channel.Name, dest.Account)
+			if _, err := gw.Router.send(dest, ban); err != nil {
+				gw.logger.Errorf("moderation: failed to ban %s in %s on %s: %s", msg.Username, channel.Name, dest.Account, err)
+			}
+		}
+	}
+}
+
+// relayRemoval removes the message of the removal msg, and its copies, on the bridges of
+// the gateway with ApplyModeration. A delete is a removal when it's the delete of a copy.
+// Returns true if msg was a removal of a message of the gateway, without ApplyModeration
+// the deletes are relayed as usual.
+func (gw *Gateway) relayRemoval(msg *config.Message) bool {
+	if _, ok := gw.Bridges[msg.Account]; !ok {
+		return false
+	}
+	dests := gw.moderatedBridges("")
+	if len(dests) == 0 {
+		return false
+	}
+	id := msg.Protocol + " " + msg.ID
+	canonical := gw.FindCanonicalMsgID(msg.Protocol, msg.ID)
+	if canonical == "" || (canonical == id && msg.Event == config.EventMsgDelete) {
+		return false
+	}
+	// the copies deleted by the bridge, after a delete or a removal, are deleted again
+	deleted := gw.deletedBucket()
+	if deleted.Contains(canonical) {
+		return true
+	}
+	if err := deleted.SetStringTTL(canonical, "", deletedTTL); err != nil {
+		gw.logger.Errorf("failed to record deleted message %s: %s", canonical, err)
+	}
+	for _, dest := range dests {
+		for _, channel := range gw.Channels {
+			if channel.Account != dest.Account {
+				continue
+			}
+			destID := gw.translateMsgID(canonical, dest, channel)
+			if destID == "" || (dest.Account == msg.Account && destID == msg.ID) {
+				continue
+			}
+			del := config.Message{
+				Event: config.EventMsgDelete, Text: config.EventMsgDelete, Account: msg.Account, Protocol: msg.Protocol,
+				Gateway: gw.Name, Channel: channel.Name, ID: destID,
+			}
+			gw.logger.Infof("moderation: removing %s in %s on %s, removed on %s", destID, channel.Name, dest.Account, msg.Account)
+			if _, err := gw.Router.send(dest, del); err != nil {
+				gw.logger.Errorf("moderation: failed to remove %s in %s on %s: %s", destID, channel.Name, dest.Account, err)
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigModRelay = []byte(`
+[discord.test]
+server=""
+[matrix.test]
+server=""
+ApplyModeration=true
+[slack.test]
+server=""
+ApplyModeration=true
+[irc.freenode]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account = "matrix.test"
+    channel = "#room"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "bridge"
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+	`)
+
+func TestModerationAction(t *testing.T) {
+	r := maketestRouter(testconfigModRelay)
+	gw := r.Gateways["bridge1"]
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+
+	// bans are applied on the bridges with ApplyModeration that can ban
+	assert.True(t, r.handleModerationAction(&config.Message{
+		Event: config.EventUserBan, UserID: "1234", Username: "troll", Account: "discord.test", Protocol: "discord",
+	}))
+	assert.Len(t, recorders["matrix.test"].sent, 1)
+	ban := recorders["matrix.test"].sent[0]
+	assert.Equal(t, config.EventUserBan, ban.Event)
+	assert.Equal(t, "#room", ban.Channel)
+	assert.Equal(t, "1234", ban.UserID)
+	assert.Equal(t, "discord.test", ban.Account)
+	assert.Empty(t, recorders["slack.test"].sent)
+	assert.Empty(t, recorders["irc.freenode"].sent)
+
+	// a delete of a relayed copy removes the original and the copies of the bridges with
+	// ApplyModeration
+	gw.addMsgIDs("discord 100", []*BrMsgID{
+		{gw.Bridges["matrix.test"], "matrix $a", "#roommatrix.test"},
+		{gw.Bridges["slack.test"], "slack 1.1", "bridgeslack.test"},
+		{gw.Bridges["irc.freenode"], "irc 5", "#wimtestingirc.freenode"},
+	})
+	removal := &config.Message{Event: config.EventMsgDelete, ID: "1.1", Channel: "bridge", Account: "slack.test", Protocol: "slack"}
+	assert.True(t, r.handleModerationAction(removal))
+	assert.Len(t, recorders["matrix.test"].sent, 2)
+	del := recorders["matrix.test"].sent[1]
+	assert.Equal(t, config.EventMsgDelete, del.Event)
+	assert.Equal(t, "$a", del.ID)
+	assert.Empty(t, recorders["slack.test"].sent)
+	assert.Empty(t, recorders["irc.freenode"].sent)
+
+	// the deletes of the other copies are consumed
+	assert.True(t, r.handleModerationAction(&config.Message{Event: config.EventMsgDelete, ID: "$a", Channel: "#room", Account: "matrix.test", Protocol: "matrix"}))
+	assert.Len(t, recorders["matrix.test"].sent, 2)
+
+	// deletes of an original are relayed as usual
+	gw.addMsgIDs("discord 101", []*BrMsgID{{gw.Bridges["matrix.test"], "matrix $b", "#roommatrix.test"}})
+	assert.False(t, r.handleModerationAction(&config.Message{Event: config.EventMsgDelete, ID: "101", Channel: "general", Account: "discord.test", Protocol: "discord"}))
+	assert.False(t, r.handleModerationAction(&config.Message{Text: "hi", ID: "102", Channel: "general", Account: "discord.test", Protocol: "discord"}))
+}
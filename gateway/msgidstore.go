@@ -0,0 +1,192 @@
+package gateway
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/store"
+	_ "modernc.org/sqlite" // sqlite driver of the sqlite MessageIDStore
+)
+
+// kvMsgIDStore keeps the message ID mappings in the store of the router, the
+// reverse mapping is kept in a second bucket.
+type kvMsgIDStore struct {
+	st store.Store
+}
+
+func msgMapBucket(gateway string) string {
+	return "msgmap:" + gateway
+}
+
+func msgMapReverseBucket(gateway string) string {
+	return "msgmap-rev:" + gateway
+}
+
+func (s *kvMsgIDStore) Get(gateway, canonical string) ([]StoredMsgID, bool, error) {
+	data, err := s.st.Get(msgMapBucket(gateway), canonical)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var ids []StoredMsgID
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, false, err
+	}
+	return ids, true, nil
+}
+
+func (s *kvMsgIDStore) Put(gateway, canonical string, ids []StoredMsgID) error {
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	if err := s.st.Set(msgMapBucket(gateway), canonical, data, msgMapTTL); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := s.st.Set(msgMapReverseBucket(gateway), id.ID, []byte(canonical), msgMapTTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *kvMsgIDStore) Canonical(gateway, id string) (string, bool, error) {
+	data, err := s.st.Get(msgMapReverseBucket(gateway), id)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return string(data), true, nil
+}
+
+// Close doesn't close the store, it's owned by the router.
+func (s *kvMsgIDStore) Close() error {
+	return nil
+}
+
+// sqliteMsgIDStore keeps the message ID mappings in a sqlite database, indexed both
+// ways so lookups stay fast with a large history.
+type sqliteMsgIDStore struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+const sqliteMsgIDSchema = `
+CREATE TABLE IF NOT EXISTS msgids (
+	gateway   TEXT NOT NULL,
+	canonical TEXT NOT NULL,
+	account   TEXT NOT NULL,
+	id        TEXT NOT NULL,
+	channel   TEXT NOT NULL,
+	created   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS msgids_canonical ON msgids (gateway, canonical);
+CREATE INDEX IF NOT EXISTS msgids_id ON msgids (gateway, id);
+CREATE INDEX IF NOT EXISTS msgids_created ON msgids (created);
+`
+
+func newSQLiteMsgIDStore(path string, ttl time.Duration) (*sqliteMsgIDStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only has one writer, a single connection avoids "database is locked"
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteMsgIDSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &sqliteMsgIDStore{db: db, ttl: ttl}
+	if err := s.prune(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// prune removes the mappings older than ttl.
+func (s *sqliteMsgIDStore) prune() error {
+	s.lastPrune = time.Now()
+	_, err := s.db.Exec("DELETE FROM msgids WHERE created < ?", time.Now().Add(-s.ttl).Unix())
+	return err
+}
+
+func (s *sqliteMsgIDStore) Get(gateway, canonical string) ([]StoredMsgID, bool, error) {
+	rows, err := s.db.Query("SELECT account, id, channel FROM msgids WHERE gateway = ? AND canonical = ? AND created >= ? ORDER BY rowid",
+		gateway, canonical, time.Now().Add(-s.ttl).Unix())
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	var ids []StoredMsgID
+	found := false
+	for rows.Next() {
+		var id StoredMsgID
+		if err := rows.Scan(&id.Account, &id.ID, &id.ChannelID); err != nil {
+			return nil, false, err
+		}
+		// an empty ID marks a message without copies
+		if id.ID != "" {
+			ids = append(ids, id)
+		}
+		found = true
+	}
+	return ids, found, rows.Err()
+}
+
+func (s *sqliteMsgIDStore) Put(gateway, canonical string, ids []StoredMsgID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.lastPrune) > time.Hour {
+		if err := s.prune(); err != nil {
+			return err
+		}
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+	if _, err := tx.Exec("DELETE FROM msgids WHERE gateway = ? AND canonical = ?", gateway, canonical); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		ids = []StoredMsgID{{}}
+	}
+	now := time.Now().Unix()
+	for _, id := range ids {
+		if _, err := tx.Exec("INSERT INTO msgids (gateway, canonical, account, id, channel, created) VALUES (?, ?, ?, ?, ?, ?)",
+			gateway, canonical, id.Account, id.ID, id.ChannelID, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteMsgIDStore) Canonical(gateway, id string) (string, bool, error) {
+	var canonical string
+	err := s.db.QueryRow("SELECT canonical FROM msgids WHERE gateway = ? AND id = ? AND created >= ? ORDER BY rowid DESC LIMIT 1",
+		gateway, id, time.Now().Add(-s.ttl).Unix()).Scan(&canonical)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return canonical, true, nil
+}
+
+func (s *sqliteMsgIDStore) Close() error {
+	return s.db.Close()
+}
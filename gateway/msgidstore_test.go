@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMsgIDStore(t *testing.T, s MsgIDStore) {
+	_, ok, err := s.Get("gw", "irc 1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ids := []StoredMsgID{{"discord.test", "discord 10", "general"}, {"slack.test", "slack 20", "random"}}
+	require.NoError(t, s.Put("gw", "irc 1", ids))
+	got, ok, err := s.Get("gw", "irc 1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, ids, got)
+
+	canonical, ok, err := s.Canonical("gw", "slack 20")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "irc 1", canonical)
+
+	// mappings are per gateway
+	_, ok, err = s.Canonical("other", "slack 20")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// a message without copies is still known
+	require.NoError(t, s.Put("gw", "irc 2", nil))
+	got, ok, err = s.Get("gw", "irc 2")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Empty(t, got)
+}
+
+func TestKVMsgIDStore(t *testing.T) {
+	testMsgIDStore(t, &kvMsgIDStore{st: store.NewMemory()})
+}
+
+func TestSQLiteMsgIDStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "msgids.db")
+	s, err := newSQLiteMsgIDStore(path, time.Hour)
+	require.NoError(t, err)
+	testMsgIDStore(t, s)
+	require.NoError(t, s.Close())
+
+	// the mappings survive a restart
+	s, err = newSQLiteMsgIDStore(path, time.Hour)
+	require.NoError(t, err)
+	defer s.Close()
+	_, ok, err := s.Get("gw", "irc 1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// old mappings are dropped
+	s.ttl = -time.Second
+	_, ok, err = s.Get("gw", "irc 1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestMsgIDsAfterRestart(t *testing.T) {
+	cfg := []byte(fmt.Sprintf(`
+[general]
+MessageIDStore="sqlite"
+MessageIDStorePath=%q
+`, filepath.Join(t.TempDir(), "msgids.db")) + string(testconfig))
+	r := maketestRouter(cfg)
+	gw := r.Gateways["bridge1"]
+	gw.addMsgIDs("irc 1", []*BrMsgID{{gw.Bridges["discord.test"], "discord 10", "general"}})
+	require.NoError(t, r.msgIDs.Close())
+
+	r = maketestRouter(cfg)
+	defer r.msgIDs.Close()
+	gw = r.Gateways["bridge1"]
+	ids, ok := gw.getMsgIDs("irc 1")
+	assert.True(t, ok)
+	if assert.Len(t, ids, 1) {
+		assert.Equal(t, "discord 10", ids[0].ID)
+	}
+	assert.Equal(t, "irc 1", gw.FindCanonicalMsgID("discord", "10"))
+}
@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+)
+
+// MessageMappings implements bridge.MessageMap, id can be the ID of the original
+// message or of one of its copies.
+func (r *Router) MessageMappings(account, id string) []bridge.MessageMapping {
+	var res []bridge.MessageMapping
+	for _, gw := range r.gateways() {
+		br, ok := gw.Bridges[account]
+		if !ok {
+			continue
+		}
+		canonical := gw.FindCanonicalMsgID(br.Protocol, id)
+		if canonical == "" {
+			continue
+		}
+		ids, _ := gw.getMsgIDs(canonical)
+		protocol, canonicalID := splitMsgID(canonical)
+		m := bridge.MessageMapping{Gateway: gw.Name, Protocol: protocol, ID: canonicalID}
+		if canonical == br.Protocol+" "+id {
+			m.Account = account
+		}
+		for _, brMsgID := range ids {
+			ref := bridge.MessageRef{Account: brMsgID.br.Account, ID: strings.TrimPrefix(brMsgID.ID, brMsgID.br.Protocol+" ")}
+			if channel, ok := gw.Channels[brMsgID.ChannelID]; ok {
+				ref.Channel = channel.Name
+			}
+			m.Messages = append(m.Messages, ref)
+		}
+		res = append(res, m)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Gateway < res[j].Gateway })
+	return res
+}
+
+// LinkMessages implements bridge.MessageMap. Copies that are already known for a
+// channel are replaced.
+func (r *Router) LinkMessages(m bridge.MessageMapping) error {
+	gw, ok := r.gateways()[m.Gateway]
+	if !ok {
+		return fmt.Errorf("unknown gateway %s", m.Gateway)
+	}
+	br, ok := gw.Bridges[m.Account]
+	if !ok {
+		return fmt.Errorf("account %s is not part of gateway %s", m.Account, m.Gateway)
+	}
+	if m.ID == "" {
+		return fmt.Errorf("message id missing")
+	}
+	canonical := br.Protocol + " " + m.ID
+	existing, _ := gw.getMsgIDs(canonical)
+	ids := append([]*BrMsgID{}, existing...)
+	for _, ref := range m.Messages {
+		dest, ok := gw.Bridges[ref.Account]
+		if !ok {
+			return fmt.Errorf("account %s is not part of gateway %s", ref.Account, m.Gateway)
+		}
+		channelID := gatewayChannelID(ref.Account, ref.Channel)
+		if _, ok := gw.Channels[channelID]; !ok {
+			return fmt.Errorf("channel %s of %s is not part of gateway %s", ref.Channel, ref.Account, m.Gateway)
+		}
+		if ref.ID == "" {
+			return fmt.Errorf("message id for %s missing", ref.Account)
+		}
+		brMsgID := &BrMsgID{dest, dest.Protocol + " " + ref.ID, channelID}
+		replaced := false
+		for i, id := range ids {
+			if id.br.Account == ref.Account && id.ChannelID == channelID {
+				ids[i], replaced = brMsgID, true
+			}
+		}
+		if !replaced {
+			ids = append(ids, brMsgID)
+		}
+	}
+	gw.addMsgIDs(canonical, ids)
+	r.logger.Infof("linked message %s of %s to %d messages in gateway %s", m.ID, m.Account, len(m.Messages), m.Gateway)
+	return nil
+}
+
+// TranslateID implements bridge.MessageMap.
+func (r *Router) TranslateID(gateway, account, id, dest, channel string) (string, bool) {
+	gw, ok := r.gateways()[gateway]
+	if !ok {
+		return "", false
+	}
+	src, ok := gw.Bridges[account]
+	if !ok {
+		return "", false
+	}
+	destBr, ok := gw.Bridges[dest]
+	if !ok {
+		return "", false
+	}
+	ch, ok := gw.Channels[gatewayChannelID(dest, channel)]
+	if !ok {
+		return "", false
+	}
+	canonical := gw.FindCanonicalMsgID(src.Protocol, id)
+	if canonical == "" {
+		return "", false
+	}
+	res := gw.translateMsgID(canonical, destBr, ch)
+	return res, res != ""
+}
+
+// gatewayChannelID returns the ID of channel of account in the Channels of a gateway.
+func gatewayChannelID(account, channel string) string {
+	// irc channels are lowercased in the config too #348
+	if strings.HasPrefix(account, "irc.") {
+		channel = strings.ToLower(channel)
+	}
+	return channel + account
+}
+
+// splitMsgID splits a canonical message ID in its protocol and ID.
+func splitMsgID(msgID string) (string, string) {
+	idx := strings.Index(msgID, " ")
+	if idx < 0 {
+		return "", msgID
+	}
+	return msgID[:idx], msgID[idx+1:]
+}
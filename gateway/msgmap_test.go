@@ -0,0 +1,44 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageMap(t *testing.T) {
+	r := maketestRouter(testconfigEdits)
+	gw := r.Gateways["bridge1"]
+	gw.Bridges["discord.test"].Bridger = &recordBridger{}
+	gw.Bridges["irc.freenode"].Bridger = &recordBridger{}
+
+	gw.relayMessage(&config.Message{Text: "hi", ID: "42", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Gateway: "bridge1"})
+
+	expected := []bridge.MessageMapping{{
+		Gateway: "bridge1", Account: "irc.freenode", Protocol: "irc", ID: "42",
+		Messages: []bridge.MessageRef{{Account: "discord.test", Channel: "general", ID: "1"}},
+	}}
+	assert.Equal(t, expected, r.MessageMappings("irc.freenode", "42"))
+
+	// lookups by a copy find the original
+	expected[0].Account = ""
+	assert.Equal(t, expected, r.MessageMappings("discord.test", "1"))
+	assert.Empty(t, r.MessageMappings("discord.test", "2"))
+
+	// linking replaces the copy in the same channel
+	err := r.LinkMessages(bridge.MessageMapping{
+		Gateway: "bridge1", Account: "irc.freenode", ID: "42",
+		Messages: []bridge.MessageRef{{Account: "discord.test", Channel: "general", ID: "7"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "7", r.MessageMappings("irc.freenode", "42")[0].Messages[0].ID)
+	assert.Len(t, r.MessageMappings("irc.freenode", "42")[0].Messages, 1)
+
+	err = r.LinkMessages(bridge.MessageMapping{
+		Gateway: "bridge1", Account: "irc.freenode", ID: "42",
+		Messages: []bridge.MessageRef{{Account: "discord.test", Channel: "random", ID: "8"}},
+	})
+	assert.EqualError(t, err, "channel random of discord.test is not part of gateway bridge1")
+}
@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+const (
+	// nickChangesBucket keeps the previous nick of account:nick, for the nicks the users
+	// changed to.
+	nickChangesBucket = "nick-changes"
+	nickChangeTTL     = 30 * 24 * time.Hour
+	// nickChangesMax is the number of previous nicks of a user followed at most.
+	nickChangesMax = 10
+)
+
+// trackNickChange keeps the previous nick of the user of the EventNickChange msg, and
+// renames the account of the user in their linked accounts.
+func (r *Router) trackNickChange(msg *config.Message) {
+	if msg.Event != config.EventNickChange || msg.Username == "" || msg.Text == "" || strings.EqualFold(msg.Username, msg.Text) {
+		return
+	}
+	key := strings.ToLower(msg.Account + ":" + msg.Text)
+	if err := store.NewBucket(r.Store, nickChangesBucket).SetStringTTL(key, msg.Username, nickChangeTTL); err != nil {
+		r.logger.Errorf("failed to keep the nick change of %s on %s: %s", msg.Username, msg.Account, err)
+	}
+	if err := r.renameLinked(msg); err != nil {
+		r.logger.Errorf("failed to rename %s on %s in the linked accounts: %s", msg.Username, msg.Account, err)
+	}
+}
+
+// renameLinked changes the nick of the linked account of the user of the EventNickChange msg.
+func (r *Router) renameLinked(msg *config.Message) error {
+	u, ok := r.sender(msg)
+	if !ok {
+		return nil
+	}
+	accounts := store.NewBucket(r.Store, identityAccounts)
+	for i, a := range u.Accounts {
+		if a.Account != msg.Account {
+			continue
+		}
+		for _, key := range a.keys() {
+			if err := accounts.Delete(key); err != nil {
+				return err
+			}
+		}
+		u.Accounts[i].Nick = msg.Text
+	}
+	return r.saveLinkedUser(u)
+}
+
+// previousNicks returns the nicks the user with nick on account had before, the last one first.
+func (r *Router) previousNicks(account, nick string) []string {
+	bucket := store.NewBucket(r.Store, nickChangesBucket)
+	var nicks []string
+	seen := map[string]bool{strings.ToLower(nick): true}
+	for len(nicks) < nickChangesMax {
+		prev, ok := bucket.GetString(strings.ToLower(account + ":" + nick))
+		if !ok || seen[strings.ToLower(prev)] {
+			break
+		}
+		seen[strings.ToLower(prev)] = true
+		nicks = append(nicks, prev)
+		nick = prev
+	}
+	return nicks
+}
+
+// renameIdentity moves the learned identity of the user of the EventNickChange msg to their
+// new nick, the mentions of their previous nick still reach them.
+func (gw *Gateway) renameIdentity(msg *config.Message) {
+	if msg.Event != config.EventNickChange || gw.mentions == nil || !gw.MyConfig.Mentions.Learn || msg.Text == "" {
+		return
+	}
+	id, ok := gw.learned(msg.Account, msg.Username)
+	if !ok {
+		return
+	}
+	if msg.UserID != "" {
+		id.ID = msg.UserID
+	}
+	id.Nick = msg.Text
+	data, err := json.Marshal(id)
+	if err != nil {
+		return
+	}
+	bucket := gw.learnedBucket()
+	for _, user := range []string{msg.Username, msg.Text, id.ID} {
+		key := strings.ToLower(msg.Account + ":" + user)
+		if err := bucket.SetString(key, string(data)); err != nil {
+			gw.logger.Errorf("mentions: failed to rename %s: %s", key, err)
+		}
+	}
+}
+
+// ignoreRenamed returns true if one of the previous nicks of the sender of msg is in the
+// IgnoreNicks of their bridge, the ignored users stay ignored when they change their nick.
+func (gw *Gateway) ignoreRenamed(msg *config.Message, igNicks []string) bool {
+	if len(igNicks) == 0 || msg.Username == "" {
+		return false
+	}
+	for _, nick := range gw.Router.previousNicks(msg.Account, msg.Username) {
+		if gw.ignoreText(nick, igNicks) {
+			gw.logger.Debugf("ignoring %s on %s, they were %s", msg.Username, msg.Account, nick)
+			return true
+		}
+	}
+	return false
+}
+
+// formatNickChange turns the EventNickChange msg into an action announcing the new nick.
+func formatNickChange(msg *config.Message) {
+	if msg.Event != config.EventNickChange {
+		return
+	}
+	msg.Text = "is now known as " + msg.Text
+	msg.Event = config.EventUserAction
+}
@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigNicks = []byte(`
+[general]
+IdentityLinking=true
+
+[irc.freenode]
+server=""
+IgnoreNicks="troll"
+[discord.test]
+server=""
+ShowNickChange=true
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [gateway.mentions]
+    learn = true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "general"
+`)
+
+func TestNickChange(t *testing.T) {
+	r := maketestRouter(testconfigNicks)
+	gw := r.Gateways["bridge1"]
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+	rename := func(from, to, userID string) *config.Message {
+		msg := &config.Message{Username: from, UserID: userID, Text: to, Account: "irc.freenode", Protocol: "irc", Event: config.EventNickChange, Gateway: "bridge1"}
+		r.trackNickChange(msg)
+		gw.renameIdentity(msg)
+		return msg
+	}
+
+	gw.learnIdentity(&config.Message{Text: "hi", Username: "alice", UserID: "~alice@host", Account: "irc.freenode"})
+	_, err := r.linkAccounts(linkedAccount{Account: "irc.freenode", UserID: "~alice@host", Nick: "alice"}, linkedAccount{Account: "discord.test", UserID: "123", Nick: "alice_d"})
+	require.NoError(t, err)
+
+	// the rename is only relayed with ShowNickChange, for the whole bridge
+	gw.relayMessage(rename("alice", "alice_", "~alice@host"))
+	if assert.Len(t, recorders["discord.test"].sent, 1) {
+		msg := recorders["discord.test"].sent[0]
+		assert.Equal(t, config.EventUserAction, msg.Event)
+		assert.Equal(t, "is now known as alice_", msg.Text)
+		assert.Equal(t, "general", msg.Channel)
+	}
+	assert.Empty(t, recorders["slack.test"].sent)
+
+	// the mentions of the previous nick follow the user
+	gw.relayMessage(&config.Message{
+		Text: "@alice hi", Username: "bob", Channel: "general", Account: "slack.test", Protocol: "slack", Gateway: "bridge1",
+	})
+	if assert.Len(t, recorders["irc.freenode"].sent, 1) {
+		assert.Equal(t, "alice_: hi", recorders["irc.freenode"].sent[0].Text)
+	}
+
+	// and so do the linked accounts
+	u, ok := r.linkedUser("irc.freenode", "alice_")
+	require.True(t, ok)
+	a, _ := u.account("irc.freenode")
+	assert.Equal(t, "alice_", a.Nick)
+	_, ok = r.linkedUser("irc.freenode", "alice")
+	assert.False(t, ok)
+
+	// the ignored nicks stay ignored
+	rename("troll", "nice", "~troll@host")
+	rename("nice", "nicer", "~troll@host")
+	assert.Equal(t, []string{"nice", "troll"}, r.previousNicks("irc.freenode", "nicer"))
+	assert.True(t, gw.ignoreMessage(&config.Message{Text: "hi", Username: "nicer", Channel: "#wimtesting", Account: "irc.freenode"}))
+	assert.False(t, gw.ignoreMessage(&config.Message{Text: "hi", Username: "alice_", Channel: "#wimtesting", Account: "irc.freenode"}))
+}
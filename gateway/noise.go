@@ -0,0 +1,211 @@
+package gateway
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const (
+	noiseModeAggregate = "aggregate"
+
+	noiseEmoji   = "emoji"
+	noiseSticker = "sticker"
+	noiseGIF     = "gif"
+
+	// noiseWindow is how long the noise messages are aggregated before they're sent.
+	noiseWindow = time.Minute
+	// noiseMaxAggregated is the number of messages aggregated for a channel at most, newer
+	// messages are dropped.
+	noiseMaxAggregated = 100
+)
+
+var (
+	errDroppedNoise = errors.New("noise filter")
+
+	// customEmojiRE matches the custom emoji of discord (<:name:id>, <a:name:id>) and the
+	// :shortcodes: of slack, mattermost and others.
+	customEmojiRE = regexp.MustCompile(`<a?:\w+:\d+>|:[\w+-]+:`)
+	gifLinkRE     = regexp.MustCompile(`^https?://(([\w-]+\.)*(tenor\.com|giphy\.com|gfycat\.com)/\S*|\S+\.gif(\?\S*)?)$`)
+
+	stickerExtensions = map[string]bool{".tgs": true, ".webp": true}
+)
+
+// noiseAggregates are the noise messages waiting to be sent to the channels with
+// NoiseFilter aggregate, keyed by channel ID.
+type noiseAggregates struct {
+	sync.Mutex
+	channels map[string]*noiseAggregate
+}
+
+// noiseAggregate are the noise messages of a channel, as the name of their sender and
+// their text.
+type noiseAggregate struct {
+	dest    *bridge.Bridge
+	channel config.ChannelInfo
+	users   []string
+	texts   map[string][]string
+}
+
+// noiseKind returns the kind of noise rmsg is, emoji, sticker or gif, or "" if it isn't
+// only emoji, a sticker or a GIF.
+func noiseKind(rmsg *config.Message) string {
+	text := strings.TrimSpace(rmsg.Text)
+	var files []config.FileInfo
+	if rmsg.Extra != nil {
+		for _, f := range rmsg.Extra["file"] {
+			if fi, ok := f.(config.FileInfo); ok {
+				files = append(files, fi)
+			}
+		}
+	}
+	if len(files) == 1 {
+		fi := files[0]
+		if caption := strings.TrimSpace(fi.CaptionText()); caption != "" && caption != text {
+			text = strings.TrimSpace(text + " " + caption)
+		}
+		if text != "" && !isEmojiOnly(text) {
+			return ""
+		}
+		switch ext := strings.ToLower(filepath.Ext(fi.Name)); {
+		case stickerExtensions[ext]:
+			return noiseSticker
+		case ext == ".gif":
+			return noiseGIF
+		}
+		return ""
+	}
+	if len(files) > 1 || text == "" {
+		return ""
+	}
+	if gifLinkRE.MatchString(text) {
+		return noiseGIF
+	}
+	if isEmojiOnly(text) {
+		return noiseEmoji
+	}
+	return ""
+}
+
+// isEmojiOnly returns true if text only has emoji, custom emoji and spaces.
+func isEmojiOnly(text string) bool {
+	text = customEmojiRE.ReplaceAllString(text, "")
+	found := false
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+		case r == '\u200d' || r == '\u20e3' || unicode.Is(unicode.Variation_Selector, r):
+			// joiners of the emoji sequences, keycaps and the emoji presentation
+		case r >= 0x1f3fb && r <= 0x1f3ff:
+			// skin tones
+		case unicode.Is(unicode.So, r):
+			found = true
+		default:
+			return false
+		}
+	}
+	return found || strings.TrimSpace(text) == ""
+}
+
+// filtersNoise returns true if the noise kind is filtered with opts, all kinds are when
+// NoiseKinds isn't set.
+func filtersNoise(opts *config.ChannelOptions, kind string) bool {
+	if len(opts.NoiseKinds) == 0 {
+		return true
+	}
+	for _, k := range opts.NoiseKinds {
+		if strings.EqualFold(k, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterNoise returns true when msg must not be sent to channel because it's only emoji, a
+// sticker or a GIF, for the channels with a NoiseFilter. With NoiseFilter aggregate these
+// messages are sent as one message a minute later.
+func (gw *Gateway) filterNoise(rmsg, msg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo) bool {
+	opts := channel.Options
+	if opts.NoiseFilter == "" || rmsg.Event != "" || msg.ID != "" {
+		return false
+	}
+	kind := noiseKind(rmsg)
+	if kind == "" || !filtersNoise(&opts, kind) {
+		return false
+	}
+	if opts.NoiseFilter != noiseModeAggregate {
+		gw.logger.Debugf("noise filter: dropping %s of %s to %s on %s", kind, rmsg.Username, channel.Name, dest.Account)
+		return true
+	}
+
+	text := strings.TrimSpace(rmsg.Text)
+	switch kind {
+	case noiseSticker:
+		text = "[sticker]"
+	case noiseGIF:
+		text = "[GIF]"
+	}
+	gw.noise.Lock()
+	defer gw.noise.Unlock()
+	a, ok := gw.noise.channels[channel.ID]
+	if !ok {
+		a = &noiseAggregate{dest: dest, channel: *channel, texts: make(map[string][]string)}
+		gw.noise.channels[channel.ID] = a
+		id := channel.ID
+		time.AfterFunc(noiseWindow, func() { gw.flushNoise(id) })
+	}
+	if a.count() >= noiseMaxAggregated {
+		gw.logger.Debugf("noise filter: dropping %s of %s to %s on %s", kind, rmsg.Username, channel.Name, dest.Account)
+		return true
+	}
+	// the name is formatted with the RemoteNickFormat of dest
+	name := msg.Username
+	if name == "" {
+		name = rmsg.Username + ": "
+	}
+	if _, ok := a.texts[name]; !ok {
+		a.users = append(a.users, name)
+	}
+	a.texts[name] = append(a.texts[name], text)
+	return true
+}
+
+func (a *noiseAggregate) count() int {
+	n := 0
+	for _, texts := range a.texts {
+		n += len(texts)
+	}
+	return n
+}
+
+// flushNoise sends the aggregated noise messages of the channel with ID channelID as one
+// message, a line for every sender.
+func (gw *Gateway) flushNoise(channelID string) {
+	gw.noise.Lock()
+	a, ok := gw.noise.channels[channelID]
+	delete(gw.noise.channels, channelID)
+	gw.noise.Unlock()
+	if !ok {
+		return
+	}
+	lines := make([]string, 0, len(a.users))
+	for _, user := range a.users {
+		lines = append(lines, user+strings.Join(a.texts[user], " "))
+	}
+	msg := config.Message{
+		Text:    strings.Join(lines, "\n"),
+		Channel: a.channel.Name,
+		Account: a.dest.Account,
+		Gateway: gw.Name,
+	}
+	if _, err := gw.Router.send(a.dest, msg); err != nil {
+		gw.logger.Errorf("noise filter: failed to send %d aggregated messages to %s on %s: %s", a.count(), a.channel.Name, a.dest.Account, err)
+	}
+}
@@ -0,0 +1,73 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoiseKind(t *testing.T) {
+	file := func(name, caption string) map[string][]interface{} {
+		return map[string][]interface{}{"file": {config.FileInfo{Name: name, Caption: caption}}}
+	}
+	for _, tc := range []struct {
+		msg  config.Message
+		kind string
+	}{
+		{config.Message{Text: "👍"}, noiseEmoji},
+		{config.Message{Text: "😂 😂 👍🏽 ❤️ 👨‍👩‍👧"}, noiseEmoji},
+		{config.Message{Text: ":tada: <:pepe:1234> :+1:"}, noiseEmoji},
+		{config.Message{Text: "nice 👍"}, ""},
+		{config.Message{Text: ":)"}, ""},
+		{config.Message{Text: "https://tenor.com/view/cat-123"}, noiseGIF},
+		{config.Message{Text: "https://example.com/funny.gif"}, noiseGIF},
+		{config.Message{Text: "look https://tenor.com/view/cat-123"}, ""},
+		{config.Message{Extra: file("sticker.webp", "")}, noiseSticker},
+		{config.Message{Extra: file("AnimatedSticker.tgs", "😺")}, noiseSticker},
+		{config.Message{Extra: file("cat.gif", "")}, noiseGIF},
+		{config.Message{Extra: file("cat.gif", "the cat I told you about")}, ""},
+		{config.Message{Extra: file("report.pdf", "")}, ""},
+		{config.Message{}, ""},
+	} {
+		assert.Equal(t, tc.kind, noiseKind(&tc.msg), tc.msg.Text)
+	}
+}
+
+func TestFilterNoise(t *testing.T) {
+	gw := maketestRouter(testconfig).Gateways["bridge1"]
+	rec := &recordBridger{}
+	dest := gw.Bridges["slack.test"]
+	dest.Bridger = rec
+	channel := gw.Channels["testingslack.test"]
+	send := func(text, username string) {
+		msg := &config.Message{Text: text, Username: username, Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc"}
+		_, err := gw.SendMessage(msg, dest, channel, "")
+		require.NoError(t, err)
+	}
+
+	channel.Options.NoiseFilter = "drop"
+	channel.Options.NoiseKinds = []string{"gif"}
+	send("👍", "alice")
+	send("https://giphy.com/gifs/cat", "alice")
+	require.Len(t, rec.sent, 1)
+	assert.Equal(t, "👍", rec.sent[0].Text)
+
+	channel.Options.NoiseFilter = "aggregate"
+	channel.Options.NoiseKinds = nil
+	send("👍", "alice")
+	send("hello", "bob")
+	send("😂", "bob")
+	send("https://tenor.com/view/cat-123", "alice")
+	require.Len(t, rec.sent, 2)
+	assert.Equal(t, "hello", rec.sent[1].Text)
+
+	gw.flushNoise(channel.ID)
+	require.Len(t, rec.sent, 3)
+	assert.Equal(t, "alice: 👍 [GIF]\nbob: 😂", rec.sent[2].Text)
+	assert.Equal(t, channel.Name, rec.sent[2].Channel)
+	// flushed only once
+	gw.flushNoise(channel.ID)
+	assert.Len(t, rec.sent, 3)
+}
@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// permalink returns the link to the message id in channel on br, for the bridges that
+// have links.
+func permalink(br *bridge.Bridge, channel, id string) (string, bool) {
+	if br == nil || id == "" {
+		return "", false
+	}
+	linker, ok := br.Bridger.(bridge.Permalinker)
+	if !ok {
+		return "", false
+	}
+	return linker.Permalink(channel, id)
+}
+
+// sourcePermalink returns the link to rmsg on its bridge, or an empty string.
+func (gw *Gateway) sourcePermalink(rmsg *config.Message) string {
+	link, _ := permalink(gw.Bridges[rmsg.Account], rmsg.Channel, rmsg.ID)
+	return link
+}
+
+// addPermalink returns the text of msg with the link to rmsg on its bridge, formatted with
+// the PermalinkFormat of that bridge. The text is left as is when it's not set or the
+// bridge has no link to the message.
+func (gw *Gateway) addPermalink(rmsg, msg *config.Message) string {
+	if msg.Text == "" || (rmsg.Event != "" && rmsg.Event != config.EventUserAction) {
+		return msg.Text
+	}
+	src, ok := gw.Bridges[rmsg.Account]
+	if !ok || src.GetString("PermalinkFormat") == "" {
+		return msg.Text
+	}
+	link := gw.sourcePermalink(rmsg)
+	if link == "" {
+		return msg.Text
+	}
+	return strings.NewReplacer("{MESSAGE}", msg.Text, "{PERMALINK}", link).Replace(src.GetString("PermalinkFormat"))
+}
@@ -0,0 +1,54 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigPermalinks = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+PermalinkFormat="{MESSAGE} <{PERMALINK}>"
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+`)
+
+func TestAddPermalink(t *testing.T) {
+	r := maketestRouter(testconfigPermalinks)
+	gw := r.Gateways["bridge1"]
+	irc := &linkBridger{}
+	discord := &linkBridger{}
+	gw.Bridges["irc.freenode"].Bridger = irc
+	gw.Bridges["discord.test"].Bridger = discord
+
+	gw.relayMessage(&config.Message{Text: "hello", Username: "wim", ID: "42", Channel: "general", Account: "discord.test", Protocol: "discord", Gateway: "bridge1"})
+	if assert.Len(t, irc.sent, 1) {
+		assert.Equal(t, "hello <https://chat.example.com/general/42>", irc.sent[0].Text)
+	}
+
+	// irc has no PermalinkFormat
+	gw.relayMessage(&config.Message{Text: "hi", Username: "bob", ID: "7", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Gateway: "bridge1"})
+	if assert.Len(t, discord.sent, 1) {
+		assert.Equal(t, "hi", discord.sent[0].Text)
+	}
+
+	// messages without an ID and events have no link
+	msg := &config.Message{Text: "hello", Channel: "general", Account: "discord.test"}
+	assert.Equal(t, "hello", gw.addPermalink(msg, msg))
+	msg = &config.Message{Text: "topic", ID: "43", Event: config.EventTopicChange, Channel: "general", Account: "discord.test"}
+	assert.Equal(t, "topic", gw.addPermalink(msg, msg))
+}
@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+var (
+	linkRE = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+	imageExtensions = map[string]bool{
+		".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true, ".bmp": true, ".svg": true,
+	}
+)
+
+const (
+	imageOmitted = "[image omitted]"
+	fileOmitted  = "[file omitted]"
+	linkOmitted  = "[link omitted]"
+)
+
+// applyContentPolicy enforces the content policy of the destination channel on msg, after
+// limitMedia and withThumbnails so the links they add are covered too.
+// Returns true if the message must be dropped.
+func (gw *Gateway) applyContentPolicy(msg *config.Message, channel *config.ChannelInfo) bool {
+	opts := channel.Options
+	if dropForward(msg, &opts) {
+		gw.logger.Debugf("content policy: dropping forwarded message to %s", channel.ID)
+		return true
+	}
+	if opts.StripImages || opts.StripFiles {
+		stripFiles(msg, opts.StripFiles)
+	}
+	if opts.StripLinks || len(opts.AllowedLinkDomains) > 0 {
+		msg.Text = stripLinks(msg.Text, opts.AllowedLinkDomains)
+		stripFileLinks(msg, opts.AllowedLinkDomains)
+	}
+	if len(opts.Redact) > 0 {
+		gw.redactMessage(msg, opts.Redact, opts.RedactReplacement)
+	}
+	return false
+}
+
+// stripFiles removes the images (or all files when all is true) from msg, the ones too big
+// to relay included, and adds a placeholder to the text instead.
+func stripFiles(msg *config.Message, all bool) {
+	if msg.Extra == nil || len(msg.Extra["file"])+len(msg.Extra[config.EventFileFailureSize]) == 0 {
+		return
+	}
+	cloneFiles(msg)
+	var notes []string
+	for _, key := range []string{"file", config.EventFileFailureSize} {
+		files, ok := msg.Extra[key]
+		if !ok {
+			continue
+		}
+		kept := files[:0]
+		for _, f := range files {
+			fi, ok := f.(config.FileInfo)
+			if !ok {
+				kept = append(kept, f)
+				continue
+			}
+			isImage := imageExtensions[strings.ToLower(filepath.Ext(fi.Name))]
+			if !all && !isImage {
+				kept = append(kept, f)
+				continue
+			}
+			note := fileOmitted
+			if isImage {
+				note = imageOmitted
+			}
+			if caption := fi.CaptionText(); caption != "" && caption != msg.Text {
+				note += " " + caption
+			}
+			notes = append(notes, note)
+		}
+		msg.Extra[key] = kept
+	}
+	if len(notes) > 0 {
+		if msg.Text != "" {
+			notes = append([]string{msg.Text}, notes...)
+		}
+		msg.Text = strings.Join(notes, "\n")
+	}
+}
+
+// stripFileLinks replaces the links in the captions of the files of msg that aren't on one
+// of the allowed domains, and drops the links to the files too big to relay that aren't.
+func stripFileLinks(msg *config.Message, allowed []string) {
+	if msg.Extra == nil || len(msg.Extra["file"])+len(msg.Extra[config.EventFileFailureSize]) == 0 {
+		return
+	}
+	cloneFiles(msg)
+	for _, key := range []string{"file", config.EventFileFailureSize} {
+		for i, f := range msg.Extra[key] {
+			if fi, ok := f.(config.FileInfo); ok {
+				fi.Caption = stripLinks(fi.Caption, allowed)
+				fi.Comment = stripLinks(fi.Comment, allowed)
+				if key == config.EventFileFailureSize && fi.URL != "" && !onDomain(fi.URL, allowed) {
+					fi.URL = ""
+				}
+				msg.Extra[key][i] = fi
+			}
+		}
+	}
+}
+
+// stripLinks replaces the links in text that aren't on one of the allowed domains (or their subdomains).
+func stripLinks(text string, allowed []string) string {
+	return linkRE.ReplaceAllStringFunc(text, func(link string) string {
+		if onDomain(link, allowed) {
+			return link
+		}
+		return linkOmitted
+	})
+}
+
+// onDomain returns true if link is on one of domains or their subdomains.
+func onDomain(link string, domains []string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range domains {
+		domain = strings.ToLower(domain)
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripLinks(t *testing.T) {
+	text := "see https://github.com/42wim/matterbridge and https://evil.example.com/x and http://docs.github.com"
+	assert.Equal(t, "see https://github.com/42wim/matterbridge and [link omitted] and http://docs.github.com",
+		stripLinks(text, []string{"github.com"}))
+	assert.Equal(t, "see [link omitted] and [link omitted] and [link omitted]", stripLinks(text, nil))
+}
+
+func TestApplyContentPolicy(t *testing.T) {
+	gw := maketestRouter(testconfig).Gateways["bridge1"]
+	data := []byte("x")
+	files := []interface{}{
+		config.FileInfo{Name: "cat.png", Data: &data},
+		config.FileInfo{Name: "notes.txt", Data: &data},
+	}
+
+	msg := &config.Message{Text: "look", Extra: map[string][]interface{}{"file": files}}
+	channel := &config.ChannelInfo{Options: config.ChannelOptions{StripImages: true}}
+	assert.False(t, gw.applyContentPolicy(msg, channel))
+	assert.Equal(t, "look\n[image omitted]", msg.Text)
+	assert.Len(t, msg.Extra["file"], 1)
+	assert.Equal(t, "notes.txt", msg.Extra["file"][0].(config.FileInfo).Name)
+
+	extra := map[string][]interface{}{"file": files}
+	msg = &config.Message{Extra: extra}
+	channel.Options = config.ChannelOptions{StripFiles: true}
+	assert.False(t, gw.applyContentPolicy(msg, channel))
+	assert.Equal(t, "[image omitted]\n[file omitted]", msg.Text)
+	assert.Empty(t, msg.Extra["file"])
+	// the original files are untouched for other destinations
+	assert.Len(t, extra["file"], 2)
+
+	msg = &config.Message{Text: "fwd", Extra: map[string][]interface{}{config.ExtraForwarded: {true}}}
+	channel.Options = config.ChannelOptions{DropForwards: true}
+	assert.True(t, gw.applyContentPolicy(msg, channel))
+}
+
+func TestContentPolicyFileLinks(t *testing.T) {
+	gw := maketestRouter(testconfig).Gateways["bridge1"]
+	failed := map[string][]interface{}{
+		"file":                      {config.FileInfo{Name: "a.txt", Caption: "from https://evil.example.com/a"}},
+		config.EventFileFailureSize: {config.FileInfo{Name: "big.png", Size: 200, URL: "https://evil.example.com/big.png"}},
+	}
+
+	// the links of the files too big to relay are stripped too
+	msg := &config.Message{Extra: failed}
+	channel := &config.ChannelInfo{Options: config.ChannelOptions{StripLinks: true}}
+	assert.False(t, gw.applyContentPolicy(msg, channel))
+	assert.Equal(t, "from [link omitted]", msg.Extra["file"][0].(config.FileInfo).Caption)
+	assert.Empty(t, msg.Extra[config.EventFileFailureSize][0].(config.FileInfo).URL)
+	assert.False(t, hasFileLink(msg))
+
+	// unless they're on an allowed domain
+	msg = &config.Message{Extra: failed}
+	channel.Options = config.ChannelOptions{AllowedLinkDomains: []string{"example.com"}}
+	assert.False(t, gw.applyContentPolicy(msg, channel))
+	assert.Equal(t, "https://evil.example.com/big.png", msg.Extra[config.EventFileFailureSize][0].(config.FileInfo).URL)
+
+	// and StripImages drops the images too big to relay
+	msg = &config.Message{Extra: failed}
+	channel.Options = config.ChannelOptions{StripImages: true}
+	assert.False(t, gw.applyContentPolicy(msg, channel))
+	assert.Equal(t, "[image omitted]", msg.Text)
+	assert.Empty(t, msg.Extra[config.EventFileFailureSize])
+	assert.Len(t, msg.Extra["file"], 1)
+
+	// the other destinations still get everything
+	assert.Equal(t, "https://evil.example.com/big.png", failed[config.EventFileFailureSize][0].(config.FileInfo).URL)
+	assert.Equal(t, "from https://evil.example.com/a", failed["file"][0].(config.FileInfo).Caption)
+}
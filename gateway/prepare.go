@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+)
+
+// preparedMessage is a message of which the files were fetched and the images processed in
+// the background.
+type preparedMessage struct {
+	key string // account and channel, see queueMessage
+	msg config.Message
+}
+
+// needsPreparing returns true if the files of msg must be fetched or its images processed
+// before it's routed.
+func (r *Router) needsPreparing(msg *config.Message) bool {
+	return r.needsFetch(msg) || r.hasImages(msg)
+}
+
+// prepare fetches the files of msg and processes its images in the download pool, so a slow
+// download or a big image doesn't hold the messages of the other channels, and sends it back
+// to handleReceive.
+func (r *Router) prepare(key string, msg config.Message) {
+	helper.Downloads.Go(msg.Account, func() {
+		r.fetchFiles(&msg)
+		r.processImages(&msg)
+		r.prepared <- preparedMessage{key: key, msg: msg}
+	})
+}
+
+// queueMessage routes msg, once its files are fetched and its images processed when needed.
+// The messages of a channel keep their order: the ones received while a message is prepared
+// wait until it's routed.
+func (r *Router) queueMessage(msg config.Message) {
+	key := msg.Account + " " + msg.Channel
+	if len(r.preparing[key]) > 0 {
+		r.preparing[key] = append(r.preparing[key], msg)
+		return
+	}
+	if !r.needsPreparing(&msg) {
+		r.routeMessage(&msg)
+		return
+	}
+	r.preparing[key] = []config.Message{msg}
+	r.prepare(key, msg)
+}
+
+// handlePrepared routes the prepared message p and the messages of its channel that waited
+// for it, until one of them needs preparing too.
+func (r *Router) handlePrepared(p preparedMessage) {
+	waiting := r.preparing[p.key][1:]
+	r.routeMessage(&p.msg)
+	for len(waiting) > 0 {
+		msg := waiting[0]
+		if r.needsPreparing(&msg) {
+			r.preparing[p.key] = waiting
+			r.prepare(p.key, msg)
+			return
+		}
+		waiting = waiting[1:]
+		r.routeMessage(&msg)
+	}
+	delete(r.preparing, p.key)
+	if len(r.preparing) == 0 {
+		for _, done := range r.flushing {
+			close(done)
+		}
+		r.flushing = nil
+	}
+}
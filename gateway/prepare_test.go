@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueMessage(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+		w.Write([]byte("image"))
+	}))
+	defer srv.Close()
+	r := maketestRouter(testconfigUploads)
+	gw := r.Gateways["bridge1"]
+	irc, slack := &recordBridger{}, &recordBridger{}
+	gw.Bridges["irc.freenode"].Bridger = irc
+	gw.Bridges["slack.test"].Bridger = slack
+
+	file := config.Message{Account: "irc.freenode", Channel: "#wimtesting", Username: "bob", Text: "look", Extra: map[string][]interface{}{
+		"file": {config.FileInfo{Name: "a.png", URL: srv.URL + "/a.png"}},
+	}}
+	r.queueMessage(file)
+	// the next message of the channel waits for the file, the other channels don't
+	r.queueMessage(config.Message{Account: "irc.freenode", Channel: "#wimtesting", Username: "bob", Text: "after"})
+	r.queueMessage(config.Message{Account: "slack.test", Channel: "general", Username: "alice", Text: "other"})
+	assert.Empty(t, slack.sent)
+	require.Len(t, irc.sent, 1)
+	assert.Equal(t, "other", irc.sent[0].Text)
+
+	close(release)
+	r.handlePrepared(<-r.prepared)
+	require.Len(t, slack.sent, 2)
+	assert.Equal(t, "look", slack.sent[0].Text)
+	fi := slack.sent[0].Extra["file"][0].(config.FileInfo)
+	assert.Equal(t, int64(5), fi.Size)
+	assert.Equal(t, "after", slack.sent[1].Text)
+	assert.Empty(t, r.preparing)
+}
@@ -0,0 +1,31 @@
+package gateway
+
+import (
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/gateway/bridgemap"
+)
+
+// nativePriority returns true if dest sends the priority of messages natively.
+func nativePriority(dest *bridge.Bridge) bool {
+	_, ok := bridgemap.PrioritySupport[dest.Protocol]
+	return ok
+}
+
+// addPriorityMarker returns the text of msg with a marker of the priority of rmsg for dest
+// without message priorities, and with the UrgentMention of channel for urgent messages.
+func (gw *Gateway) addPriorityMarker(rmsg *config.Message, msg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo) string {
+	if msg.Event != "" && msg.Event != config.EventUserAction {
+		return msg.Text
+	}
+	priority, ack := helper.MessagePriority(rmsg)
+	text := msg.Text
+	if !nativePriority(dest) {
+		text = helper.PriorityMarker(priority, ack) + text
+	}
+	if priority == config.PriorityUrgent && channel.Options.UrgentMention != "" {
+		text = channel.Options.UrgentMention + " " + text
+	}
+	return text
+}
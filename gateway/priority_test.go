@@ -0,0 +1,40 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddPriorityMarker(t *testing.T) {
+	gw := &Gateway{}
+	discord := &bridge.Bridge{Protocol: "discord"}
+	mattermost := &bridge.Bridge{Protocol: "mattermost"}
+	channel := &config.ChannelInfo{}
+	urgent := &config.Message{Text: "fire", Extra: map[string][]interface{}{
+		config.ExtraPriority:     {config.PriorityUrgent},
+		config.ExtraRequestedAck: {true},
+	}}
+
+	msg := *urgent
+	assert.Equal(t, "[URGENT] [ACK REQUESTED] fire", gw.addPriorityMarker(urgent, &msg, discord, channel))
+	assert.Equal(t, "fire", gw.addPriorityMarker(urgent, &msg, mattermost, channel))
+
+	channel.Options.UrgentMention = "<@&1234>"
+	assert.Equal(t, "<@&1234> [URGENT] [ACK REQUESTED] fire", gw.addPriorityMarker(urgent, &msg, discord, channel))
+	assert.Equal(t, "<@&1234> fire", gw.addPriorityMarker(urgent, &msg, mattermost, channel))
+
+	important := &config.Message{Text: "note", Extra: map[string][]interface{}{config.ExtraPriority: {config.PriorityImportant}}}
+	msg = *important
+	assert.Equal(t, "[IMPORTANT] note", gw.addPriorityMarker(important, &msg, discord, channel))
+
+	plain := &config.Message{Text: "hi", Extra: map[string][]interface{}{}}
+	msg = *plain
+	assert.Equal(t, "hi", gw.addPriorityMarker(plain, &msg, discord, channel))
+
+	deleted := *urgent
+	deleted.Event = config.EventMsgDelete
+	assert.Equal(t, "fire", gw.addPriorityMarker(&deleted, &deleted, discord, channel))
+}
@@ -0,0 +1,103 @@
+package gateway
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+const (
+	quarantineDrop     = "drop"
+	quarantineFlag     = "flag"
+	quarantineModerate = "moderate"
+
+	quarantineFlagText = "[new account] "
+)
+
+func (gw *Gateway) quarantineEnabled() bool {
+	q := gw.MyConfig.Quarantine
+	return q.MinAccountAge > 0 || q.MinMessages > 0
+}
+
+func (gw *Gateway) msgCountBucket() *store.Bucket {
+	return store.NewBucket(gw.Router.Store, "msgcount:"+gw.Name)
+}
+
+// userKey identifies the sender of msg in the store.
+func userKey(msg *config.Message) string {
+	user := msg.UserID
+	if user == "" {
+		user = msg.Username
+	}
+	return msg.Account + " " + user
+}
+
+// countMessage increments the number of messages seen from the sender of msg
+// and returns the number of messages seen before this one.
+func (gw *Gateway) countMessage(msg *config.Message) int {
+	key := userKey(msg)
+	bucket := gw.msgCountBucket()
+	count := 0
+	if v, ok := bucket.GetString(key); ok {
+		count, _ = strconv.Atoi(v)
+	}
+	if err := bucket.SetString(key, strconv.Itoa(count+1)); err != nil {
+		gw.logger.Errorf("quarantine: failed to store message count: %s", err)
+	}
+	return count
+}
+
+// userCreated returns the creation time of the account of the sender, if the bridge provided it.
+func userCreated(msg *config.Message) (time.Time, bool) {
+	if msg.Extra == nil || len(msg.Extra[config.ExtraUserCreated]) == 0 {
+		return time.Time{}, false
+	}
+	created, ok := msg.Extra[config.ExtraUserCreated][0].(time.Time)
+	return created, ok
+}
+
+// isNewAccount returns true if the sender of msg doesn't meet the quarantine requirements.
+func (gw *Gateway) isNewAccount(msg *config.Message) bool {
+	q := gw.MyConfig.Quarantine
+	prior := gw.countMessage(msg)
+	if q.MinMessages > 0 && prior < q.MinMessages {
+		return true
+	}
+	if created, ok := userCreated(msg); ok && q.MinAccountAge > 0 {
+		return time.Since(created) < time.Duration(q.MinAccountAge)*24*time.Hour
+	}
+	return false
+}
+
+// quarantineMessage applies the quarantine policy of the gateway to messages from new accounts.
+// Returns true if the message must not be relayed.
+func (gw *Gateway) quarantineMessage(msg *config.Message) bool {
+	if !gw.quarantineEnabled() {
+		return false
+	}
+	if msg.Event != "" && msg.Event != config.EventUserAction {
+		return false
+	}
+	if !gw.isNewAccount(msg) {
+		return false
+	}
+	switch strings.ToLower(gw.MyConfig.Quarantine.Action) {
+	case quarantineFlag:
+		msg.Text = quarantineFlagText + msg.Text
+		return false
+	case quarantineModerate:
+		if gw.moderation != nil {
+			gw.hold(msg, "new account "+msg.Username+" on "+msg.Account)
+			return true
+		}
+		gw.logger.Errorf("quarantine: action moderate needs a [gateway.moderation] channel, dropping message")
+	case "", quarantineDrop:
+	default:
+		gw.logger.Errorf("quarantine: unknown action %s, dropping message", gw.MyConfig.Quarantine.Action)
+	}
+	gw.logger.Infof("quarantine: not relaying message from new account %s (%s)", msg.Username, msg.Account)
+	return true
+}
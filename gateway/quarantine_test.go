@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigQuarantine = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [gateway.quarantine]
+    minaccountage = 7
+    minmessages = 2
+    action = "flag"
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+	`)
+
+func TestQuarantine(t *testing.T) {
+	r := maketestRouter(testconfigQuarantine)
+	gw := r.Gateways["bridge1"]
+
+	newMsg := func(created time.Time) *config.Message {
+		msg := &config.Message{Text: "hi", UserID: "1", Channel: "general", Account: "discord.test"}
+		if !created.IsZero() {
+			msg.Extra = map[string][]interface{}{config.ExtraUserCreated: {created}}
+		}
+		return msg
+	}
+	old := time.Now().Add(-30 * 24 * time.Hour)
+
+	// the first 2 messages are flagged
+	for i := 0; i < 2; i++ {
+		msg := newMsg(old)
+		assert.False(t, gw.quarantineMessage(msg))
+		assert.Equal(t, quarantineFlagText+"hi", msg.Text)
+	}
+	msg := newMsg(old)
+	assert.False(t, gw.quarantineMessage(msg))
+	assert.Equal(t, "hi", msg.Text)
+
+	// accounts that are too young stay flagged
+	msg = newMsg(time.Now().Add(-time.Hour))
+	assert.False(t, gw.quarantineMessage(msg))
+	assert.Equal(t, quarantineFlagText+"hi", msg.Text)
+
+	// without account age only the message count is used
+	msg = newMsg(time.Time{})
+	assert.False(t, gw.quarantineMessage(msg))
+	assert.Equal(t, "hi", msg.Text)
+
+	gw.MyConfig.Quarantine.Action = ""
+	msg = newMsg(time.Now())
+	assert.True(t, gw.quarantineMessage(msg))
+}
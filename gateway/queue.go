@@ -0,0 +1,411 @@
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+const (
+	offlineReplayMarker = "[offline replay] "
+
+	defaultRetryDelay    = 5 * time.Second
+	defaultRetryMaxDelay = 5 * time.Minute
+	defaultMaxAttempts   = 10
+)
+
+// retry is the next replay of the offline queue of a bridge, the delay doubles after every
+// failed replay.
+type retry struct {
+	delay time.Duration
+	timer *time.Timer
+}
+
+// queuedMessage is a message that couldn't be sent to a destination channel,
+// kept until the destination bridge is back.
+type queuedMessage struct {
+	Gateway  string
+	Channel  string // ID of the destination channel
+	ParentID string // canonical ID of the parent message
+	Message  config.Message
+	// Files are kept apart from Message.Extra so they decode as config.FileInfo again
+	Files []config.FileInfo
+	// Links are the files queued without their content with LowMemory, they're relayed as
+	// files too big to relay, with their URL when they have one.
+	Links []config.FileInfo
+	// Attempts is the number of failed replays, the message is dropped after
+	// OfflineQueueMaxAttempts
+	Attempts int
+}
+
+func newQueuedMessage(gw *Gateway, msg *config.Message, channel *config.ChannelInfo, parentID string) *queuedMessage {
+	q := &queuedMessage{Gateway: gw.Name, Channel: channel.ID, ParentID: parentID, Message: *msg}
+	if msg.Extra != nil {
+		q.Message.Extra = make(map[string][]interface{}, len(msg.Extra))
+		for k, v := range msg.Extra {
+			if k == "file" {
+				for _, f := range v {
+					if fi, ok := f.(config.FileInfo); ok {
+						// with LowMemory the files on disk aren't read back in memory to be queued
+						if fi.Data == nil && fi.Media != nil && fi.Media.OnDisk() && gw.BridgeValues().General.LowMemory {
+							q.Links = append(q.Links, config.FileInfo{Name: fi.Name, Comment: fi.Comment, Size: fi.DataSize(), URL: fi.URL})
+							continue
+						}
+						// Media isn't encoded, the queue keeps the content in Data
+						if fi, err := fi.WithData(); err == nil {
+							q.Files = append(q.Files, fi)
+						}
+					}
+				}
+				continue
+			}
+			q.Message.Extra[k] = v
+		}
+	}
+	return q
+}
+
+func (q *queuedMessage) message() config.Message {
+	msg := q.Message
+	if len(q.Files) > 0 {
+		if msg.Extra == nil {
+			msg.Extra = make(map[string][]interface{})
+		}
+		for _, fi := range q.Files {
+			msg.Extra["file"] = append(msg.Extra["file"], fi)
+		}
+	}
+	if len(q.Links) > 0 {
+		if msg.Extra == nil {
+			msg.Extra = make(map[string][]interface{})
+		}
+		for _, fi := range q.Links {
+			msg.Extra[config.EventFileFailureSize] = append(msg.Extra[config.EventFileFailureSize], fi)
+		}
+	}
+	return msg
+}
+
+func offlineQueue(s store.Store, account string) *store.Bucket {
+	return store.NewBucket(s, "offline:"+account)
+}
+
+// queueable returns true for the messages that are kept when dest is offline.
+func queueable(msg *config.Message, dest *bridge.Bridge) bool {
+	if dest.GetInt("OfflineQueueSize") <= 0 {
+		return false
+	}
+	return msg.Event == "" || msg.Event == config.EventUserAction
+}
+
+// queuedKeys returns the keys of the messages queued for account, oldest first.
+func (r *Router) queuedKeys(account string) []string {
+	keys := offlineQueue(r.Store, account).Keys()
+	sort.Strings(keys)
+	return keys
+}
+
+// queuedAt returns when the message of key was queued.
+func queuedAt(key string) time.Time {
+	nanos, _ := strconv.ParseInt(strings.SplitN(key, " ", 2)[0], 10, 64)
+	return time.Unix(0, nanos)
+}
+
+// queuedCount returns the number of messages queued for account. It's counted once from the
+// queue and then kept up to date, the messages that expired with OfflineQueueTTL are only
+// taken off by the next replay.
+func (r *Router) queuedCount(account string) int {
+	r.replayMu.Lock()
+	defer r.replayMu.Unlock()
+	n, ok := r.queued[account]
+	if !ok {
+		n = len(offlineQueue(r.Store, account).Keys())
+		r.queued[account] = n
+	}
+	return n
+}
+
+// setQueued sets the number of messages queued for account to n.
+func (r *Router) setQueued(account string, n int) {
+	r.replayMu.Lock()
+	defer r.replayMu.Unlock()
+	r.queued[account] = n
+}
+
+// hasQueued returns true if messages for dest are waiting to be replayed,
+// newer messages are queued behind them to keep the order.
+func (gw *Gateway) hasQueued(dest *bridge.Bridge) bool {
+	return gw.Router.queuedCount(dest.Account) > 0
+}
+
+// permanentError returns true if dest tells err, returned by Send, fails every time.
+func permanentError(dest *bridge.Bridge, err error) bool {
+	c, ok := dest.Bridger.(bridge.ErrorClassifier)
+	return ok && c.PermanentError(err)
+}
+
+// enqueue keeps msg for channel of dest until it can be replayed. When the queue is
+// full (OfflineQueueSize) the oldest message is dropped.
+func (gw *Gateway) enqueue(msg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo, parentID string) {
+	data, err := json.Marshal(newQueuedMessage(gw, msg, channel, parentID))
+	if err != nil {
+		gw.logger.Errorf("failed to encode message for the offline queue of %s: %s", dest.Account, err)
+		return
+	}
+	queue := offlineQueue(gw.Router.Store, dest.Account)
+	key := fmt.Sprintf("%020d %s %s", time.Now().UnixNano(), gw.Name, channel.ID)
+	ttl := time.Duration(dest.GetInt("OfflineQueueTTL")) * time.Second
+	if err := queue.SetStringTTL(key, string(data), ttl); err != nil {
+		gw.logger.Errorf("failed to queue message for %s: %s", dest.Account, err)
+		return
+	}
+	gw.logger.Debugf("%s is offline, queued message %s for %s", dest.Account, msg.ID, channel.Name)
+
+	n := gw.Router.queuedCount(dest.Account) + 1
+	if size := dest.GetInt("OfflineQueueSize"); n > size {
+		keys := gw.Router.queuedKeys(dest.Account)
+		n = len(keys)
+		for _, key := range keys[:max(len(keys)-size, 0)] {
+			gw.logger.Warnf("offline queue of %s is full, dropping the oldest message", dest.Account)
+			if err := queue.Delete(key); err != nil {
+				gw.logger.Errorf("failed to delete queued message of %s: %s", dest.Account, err)
+				continue
+			}
+			n--
+		}
+		if gw.Router.startDropping(gw.Name, dest.Account) {
+			gw.sendDropNotice(dest, fmt.Sprintf("%s can't be reached and its queue is full, the oldest messages to it are being dropped", dest.Account))
+		}
+	}
+	gw.Router.setQueued(dest.Account, n)
+	gw.Router.scheduleReplay(dest)
+}
+
+// startDropping returns true the first time the offline queue of account drops messages of
+// gateway, until the queue is replayed.
+func (r *Router) startDropping(gateway, account string) bool {
+	r.replayMu.Lock()
+	defer r.replayMu.Unlock()
+	if r.dropping[account] == nil {
+		r.dropping[account] = make(map[string]bool)
+	}
+	if r.dropping[account][gateway] {
+		return false
+	}
+	r.dropping[account][gateway] = true
+	return true
+}
+
+// sendDropNotice tells the other channels of the gateway that messages to dest are dropped,
+// text says which and why.
+func (gw *Gateway) sendDropNotice(dest *bridge.Bridge, text string) {
+	for _, channel := range sortedChannels(gw) {
+		br, ok := gw.Bridges[channel.Account]
+		if !ok || channel.Account == dest.Account || channel.Direction == "in" || br.Bridger == nil {
+			continue
+		}
+		notice := config.Message{
+			Text:     text,
+			Channel:  channel.Name,
+			Account:  channel.Account,
+			Username: "system",
+			Gateway:  gw.Name,
+		}
+		if _, err := gw.Router.send(br, notice); err != nil {
+			gw.logger.Errorf("failed to send the drop notice of %s to %s on %s: %s", dest.Account, channel.Name, channel.Account, err)
+		}
+	}
+}
+
+// scheduleReplay replays the offline queue of dest after OfflineQueueRetryDelay seconds, the
+// delay doubles after every failed replay up to OfflineQueueRetryMaxDelay seconds.
+func (r *Router) scheduleReplay(dest *bridge.Bridge) {
+	initial, maxDelay := defaultRetryDelay, defaultRetryMaxDelay
+	if delay := dest.GetInt("OfflineQueueRetryDelay"); delay > 0 {
+		initial = time.Duration(delay) * time.Second
+	}
+	if delay := dest.GetInt("OfflineQueueRetryMaxDelay"); delay > 0 {
+		maxDelay = time.Duration(delay) * time.Second
+	}
+
+	r.replayMu.Lock()
+	defer r.replayMu.Unlock()
+	rt, ok := r.retries[dest.Account]
+	if !ok {
+		rt = &retry{}
+		r.retries[dest.Account] = rt
+	}
+	if rt.timer != nil {
+		return
+	}
+	switch {
+	case rt.delay == 0:
+		rt.delay = initial
+	case rt.delay*2 > maxDelay:
+		rt.delay = maxDelay
+	default:
+		rt.delay *= 2
+	}
+	r.logger.Debugf("replaying the offline queue of %s in %s", dest.Account, rt.delay)
+	rt.timer = time.AfterFunc(rt.delay, func() {
+		r.replayMu.Lock()
+		rt.timer = nil
+		r.replayMu.Unlock()
+		r.requestReplay(dest)
+	})
+}
+
+// requestReplay makes handleReceive replay the offline queue of br: the replay reads the
+// gateways and sends like the other messages, which only the router goroutine may do as a
+// reload replaces the gateways, their bridges and their channels.
+func (r *Router) requestReplay(br *bridge.Bridge) {
+	// it's also called from the router itself, don't block it
+	go func() { r.replays <- br }()
+}
+
+// replayed resets the retry delay of the offline queue of account once it's empty.
+func (r *Router) replayed(account string) {
+	r.replayMu.Lock()
+	defer r.replayMu.Unlock()
+	if rt, ok := r.retries[account]; ok && rt.timer == nil {
+		delete(r.retries, account)
+	}
+	delete(r.dropping, account)
+}
+
+// replayQueue sends the queued messages of br in order, with an offline replay marker.
+// It stops at the first failure, the remaining messages stay queued for the next try. The
+// messages br rejects (see bridge.ErrorClassifier) and the ones that failed
+// OfflineQueueMaxAttempts times are dropped, so they don't hold the queue.
+// It runs on the router goroutine, see requestReplay.
+func (r *Router) replayQueue(br *bridge.Bridge) {
+	if r.isDisabled(br.Account) {
+		return
+	}
+	defer func() { r.setQueued(br.Account, len(r.queuedKeys(br.Account))) }()
+
+	queue := offlineQueue(r.Store, br.Account)
+	keys := r.queuedKeys(br.Account)
+	if len(keys) > 0 {
+		r.logger.Infof("replaying %d queued messages to %s", len(keys), br.Account)
+	}
+	for _, key := range keys {
+		data, ok := queue.GetString(key)
+		if !ok {
+			continue
+		}
+		q := &queuedMessage{}
+		if err := json.Unmarshal([]byte(data), q); err != nil {
+			r.logger.Errorf("failed to decode queued message of %s, dropping it: %s", br.Account, err)
+		} else if err := r.replayMessage(br, q); err != nil && !r.dropFailed(br, key, q, err) {
+			r.logger.Errorf("replaying queued messages to %s failed, trying again later: %s", br.Account, err)
+			r.scheduleReplay(br)
+			return
+		}
+		if err := queue.Delete(key); err != nil {
+			r.logger.Errorf("failed to delete queued message of %s: %s", br.Account, err)
+		}
+	}
+	r.replayed(br.Account)
+}
+
+// dropFailed counts the failed replay of q, the message of key, and returns true if it must
+// be dropped: when br rejects it or it failed OfflineQueueMaxAttempts times. The other
+// channels of its gateway are told with a drop notice.
+func (r *Router) dropFailed(br *bridge.Bridge, key string, q *queuedMessage, err error) bool {
+	// the bridge isn't tried, that's no attempt
+	if err == errBreakerOpen || err == errBridgeDisabled {
+		return false
+	}
+	maxAttempts := br.GetInt("OfflineQueueMaxAttempts")
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+	q.Attempts++
+	permanent := permanentError(br, err)
+	if !permanent && q.Attempts < maxAttempts {
+		data, jerr := json.Marshal(q)
+		if jerr != nil {
+			r.logger.Errorf("failed to encode message for the offline queue of %s: %s", br.Account, jerr)
+			return false
+		}
+		var ttl time.Duration
+		if t := br.GetInt("OfflineQueueTTL"); t > 0 {
+			// keep the expiry of when it was queued
+			ttl = max(time.Duration(t)*time.Second-time.Since(queuedAt(key)), time.Millisecond)
+		}
+		if serr := offlineQueue(r.Store, br.Account).SetStringTTL(key, string(data), ttl); serr != nil {
+			r.logger.Errorf("failed to update queued message of %s: %s", br.Account, serr)
+		}
+		return false
+	}
+
+	reason := fmt.Sprintf("it failed %d times", q.Attempts)
+	if permanent {
+		reason = "it was rejected"
+	}
+	r.logger.Warnf("dropping a queued message to %s, %s: %s", br.Account, reason, err)
+	gw, ok := r.gateways()[q.Gateway]
+	if !ok {
+		return true
+	}
+	dest, ok := gw.Bridges[br.Account]
+	if !ok {
+		return true
+	}
+	channel := gw.Channels[q.Channel]
+	if channel != nil {
+		msg := q.message()
+		gw.publishDropped(&msg, dest, channel, err)
+	}
+	gw.sendDropNotice(dest, fmt.Sprintf("a message to %s couldn't be sent and was dropped, %s", br.Account, reason))
+	return true
+}
+
+// replayMessage sends the queued message q, messages of gateways or channels that don't
+// exist anymore are dropped.
+func (r *Router) replayMessage(br *bridge.Bridge, q *queuedMessage) error {
+	gw, ok := r.gateways()[q.Gateway]
+	if !ok {
+		return nil
+	}
+	channel, ok := gw.Channels[q.Channel]
+	dest, ok2 := gw.Bridges[br.Account]
+	if !ok || !ok2 {
+		return nil
+	}
+	msg := q.message()
+	msg.Text = offlineReplayMarker + msg.Text
+	mID, err := gw.SendMessage(&msg, dest, channel, q.ParentID)
+	if err != nil {
+		return err
+	}
+	if mID != "" && msg.ID != "" {
+		canonical := msg.Protocol + " " + msg.ID
+		ids, _ := gw.getMsgIDs(canonical)
+		ids = append(append([]*BrMsgID{}, ids...), &BrMsgID{dest, dest.Protocol + " " + mID, channel.ID})
+		gw.addMsgIDs(canonical, ids)
+	}
+	return nil
+}
+
+// replayQueues replays the messages that were queued before a restart.
+func (r *Router) replayQueues() {
+	seen := make(map[string]bool)
+	for _, gw := range r.gateways() {
+		for _, br := range gw.Bridges {
+			if seen[br.Account] || br.Bridger == nil {
+				continue
+			}
+			seen[br.Account] = true
+			r.requestReplay(br)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package gateway
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigQueue = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+OfflineQueueSize=2
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+	`)
+
+var testconfigRetry = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+OfflineQueueSize=2
+OfflineQueueRetryDelay=1
+OfflineQueueRetryMaxDelay=3
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+	`)
+
+// flakyBridger is a Bridger that fails to send while it's down.
+type flakyBridger struct {
+	sync.Mutex
+	down bool
+	sent []config.Message
+}
+
+func (f *flakyBridger) Send(msg config.Message) (string, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.down {
+		return "", errors.New("connection lost")
+	}
+	f.sent = append(f.sent, msg)
+	return "x", nil
+}
+
+func (f *flakyBridger) texts() []string {
+	f.Lock()
+	defer f.Unlock()
+	var texts []string
+	for _, msg := range f.sent {
+		texts = append(texts, msg.Text)
+	}
+	return texts
+}
+
+func (f *flakyBridger) Connect() error                               { return nil }
+func (f *flakyBridger) JoinChannel(channel config.ChannelInfo) error { return nil }
+func (f *flakyBridger) Disconnect() error                            { return nil }
+func (f *flakyBridger) Reload(cfg config.Config) error               { return nil }
+
+func TestOfflineQueue(t *testing.T) {
+	r := maketestRouter(testconfigQueue)
+	gw := r.Gateways["bridge1"]
+	flaky := &flakyBridger{down: true}
+	dest := gw.Bridges["discord.test"]
+	dest.Bridger = flaky
+	irc := &recordBridger{}
+	gw.Bridges["irc.freenode"].Bridger = irc
+
+	for _, text := range []string{"one", "two", "three", "four"} {
+		msg := &config.Message{Text: text, Username: "wim", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Gateway: "bridge1"}
+		gw.handleMessage(msg, dest)
+	}
+	// the queue is bounded, the oldest messages are dropped and the senders are told once
+	assert.Len(t, r.queuedKeys("discord.test"), 2)
+	assert.Empty(t, flaky.texts())
+	if assert.Len(t, irc.sent, 1) {
+		assert.Equal(t, "#wimtesting", irc.sent[0].Channel)
+		assert.Contains(t, irc.sent[0].Text, "discord.test can't be reached")
+	}
+
+	flaky.Lock()
+	flaky.down = false
+	flaky.Unlock()
+	r.replayQueue(dest)
+	assert.Eventually(t, func() bool { return len(r.queuedKeys("discord.test")) == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{offlineReplayMarker + "three", offlineReplayMarker + "four"}, flaky.texts())
+	assert.Eventually(t, func() bool {
+		r.replayMu.Lock()
+		defer r.replayMu.Unlock()
+		return len(r.dropping) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// irc has no queue configured
+	assert.False(t, queueable(&config.Message{Text: "hi"}, gw.Bridges["irc.freenode"]))
+}
+
+func TestQueuedMessageFiles(t *testing.T) {
+	data := []byte("data")
+	msg := &config.Message{Text: "file", Extra: map[string][]interface{}{"file": {config.FileInfo{Name: "a.txt", Data: &data}}}}
+	q := newQueuedMessage(&Gateway{Name: "gw"}, msg, &config.ChannelInfo{ID: "chan"}, "")
+	assert.Empty(t, q.Message.Extra["file"])
+	assert.Len(t, msg.Extra["file"], 1)
+	fi, ok := q.message().Extra["file"][0].(config.FileInfo)
+	assert.True(t, ok)
+	assert.Equal(t, "a.txt", fi.Name)
+}
+
+func TestQueuedMessageLowMemory(t *testing.T) {
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+	gw.BridgeValues().General.LowMemory = true
+	media, err := config.NewTempMedia([]byte("on disk"), "")
+	require.NoError(t, err)
+	data := []byte("data")
+	msg := &config.Message{Text: "files", Extra: map[string][]interface{}{"file": {
+		config.FileInfo{Name: "a.txt", Media: media, URL: "https://example.com/a.txt"},
+		config.FileInfo{Name: "b.txt", Data: &data},
+	}}}
+
+	// the files on disk aren't read back in memory, they're relayed as links
+	q := newQueuedMessage(gw, msg, &config.ChannelInfo{ID: "chan"}, "")
+	require.Len(t, q.Files, 1)
+	assert.Equal(t, "b.txt", q.Files[0].Name)
+	require.Len(t, q.Links, 1)
+	assert.Nil(t, q.Links[0].Media)
+	replayed := q.message()
+	require.Len(t, replayed.Extra[config.EventFileFailureSize], 1)
+	fi := replayed.Extra[config.EventFileFailureSize][0].(config.FileInfo)
+	assert.Equal(t, "https://example.com/a.txt", fi.URL)
+	assert.Equal(t, int64(7), fi.Size)
+}
+
+func TestScheduleReplay(t *testing.T) {
+	r := maketestRouter(testconfigRetry)
+	gw := r.Gateways["bridge1"]
+	flaky := &flakyBridger{down: true}
+	dest := gw.Bridges["discord.test"]
+	dest.Bridger = flaky
+	// the timers make the router replay the queue
+	go r.handleReceive()
+
+	gw.handleMessage(&config.Message{Text: "one", Username: "wim", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Gateway: "bridge1"}, dest)
+	r.replayMu.Lock()
+	assert.Equal(t, time.Second, r.retries["discord.test"].delay)
+	r.replayMu.Unlock()
+
+	// the failed replay doubles the delay
+	assert.Eventually(t, func() bool {
+		r.replayMu.Lock()
+		defer r.replayMu.Unlock()
+		return r.retries["discord.test"].delay == 2*time.Second
+	}, 3*time.Second, 10*time.Millisecond)
+
+	flaky.Lock()
+	flaky.down = false
+	flaky.Unlock()
+	assert.Eventually(t, func() bool { return len(flaky.texts()) == 1 }, 4*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{offlineReplayMarker + "one"}, flaky.texts())
+	r.replayMu.Lock()
+	assert.NotContains(t, r.retries, "discord.test")
+	r.replayMu.Unlock()
+}
+
+// rejectingBridger rejects the messages of which the text contains "rejected" for good and
+// fails to send the ones with "failing".
+type rejectingBridger struct {
+	flakyBridger
+}
+
+var errRejected = errors.New("message too long")
+
+func (b *rejectingBridger) Send(msg config.Message) (string, error) {
+	switch {
+	case strings.Contains(msg.Text, "rejected"):
+		return "", errRejected
+	case strings.Contains(msg.Text, "failing"):
+		return "", errors.New("timeout")
+	}
+	return b.flakyBridger.Send(msg)
+}
+
+func (b *rejectingBridger) PermanentError(err error) bool {
+	return err == errRejected
+}
+
+func TestOfflineQueueDrops(t *testing.T) {
+	r := maketestRouter(testconfigQueue)
+	gw := r.Gateways["bridge1"]
+	dest := gw.Bridges["discord.test"]
+	rejecting := &rejectingBridger{}
+	dest.Bridger = rejecting
+	irc := &recordBridger{}
+	gw.Bridges["irc.freenode"].Bridger = irc
+	send := func(text string) {
+		gw.handleMessage(&config.Message{Text: text, Username: "wim", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Gateway: "bridge1"}, dest)
+	}
+
+	// a message that's rejected isn't queued
+	send("rejected")
+	assert.Zero(t, r.queuedCount("discord.test"))
+
+	// a message rejected when it's replayed is dropped and doesn't hold the queue
+	dest.Bridger = &flakyBridger{down: true}
+	send("rejected later")
+	send("one")
+	assert.Equal(t, 2, r.queuedCount("discord.test"))
+	dest.Bridger = rejecting
+	r.replayQueue(dest)
+	assert.Equal(t, []string{offlineReplayMarker + "one"}, rejecting.texts())
+	assert.Zero(t, r.queuedCount("discord.test"))
+	require.Len(t, irc.sent, 1)
+	assert.Contains(t, irc.sent[0].Text, "a message to discord.test couldn't be sent and was dropped, it was rejected")
+
+	// a message that keeps failing is dropped after OfflineQueueMaxAttempts
+	dest.Bridger = &flakyBridger{down: true}
+	send("failing")
+	send("two")
+	dest.Bridger = rejecting
+	for i := 0; i < defaultMaxAttempts-1; i++ {
+		r.replayQueue(dest)
+	}
+	assert.Equal(t, 2, r.queuedCount("discord.test"))
+	assert.Len(t, irc.sent, 1)
+	r.replayQueue(dest)
+	assert.Equal(t, []string{offlineReplayMarker + "one", offlineReplayMarker + "two"}, rejecting.texts())
+	assert.Zero(t, r.queuedCount("discord.test"))
+	require.Len(t, irc.sent, 2)
+	assert.Contains(t, irc.sent[1].Text, "it failed 10 times")
+}
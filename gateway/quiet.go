@@ -0,0 +1,226 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const (
+	quietDrop = "drop"
+
+	// quietMaxHeld is the number of messages held for a channel at most, newer messages are
+	// only counted in the digest.
+	quietMaxHeld = 200
+
+	defaultQuietNotice = "Messages during the quiet hours of this channel:"
+)
+
+var errDroppedQuiet = errors.New("quiet hours")
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// quietPeriod is an entry of the QuietHours of a channel, from start to end minutes after
+// midnight, the next day when end is before start, on days (all days when it's empty).
+type quietPeriod struct {
+	days       map[time.Weekday]bool
+	start, end int
+}
+
+// parseQuietHours parses the QuietHours entries "[days ]HH:MM-HH:MM", days are a weekday,
+// a range like Mon-Fri or a list like Sat,Sun.
+func parseQuietHours(entries []string) ([]quietPeriod, error) {
+	periods := make([]quietPeriod, 0, len(entries))
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid quiet hours %q, use [days ]HH:MM-HH:MM", entry)
+		}
+		var p quietPeriod
+		if len(fields) == 2 {
+			days, err := parseWeekdays(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quiet hours %q: %s", entry, err)
+			}
+			p.days = days
+		}
+		start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+		var err1, err2 error
+		p.start, err1 = parseClock(start)
+		p.end, err2 = parseClock(end)
+		if !ok || err1 != nil || err2 != nil || p.start == 24*60 || p.start == p.end {
+			return nil, fmt.Errorf("invalid quiet hours %q, use [days ]HH:MM-HH:MM", entry)
+		}
+		periods = append(periods, p)
+	}
+	return periods, nil
+}
+
+func parseWeekdays(s string) (map[time.Weekday]bool, error) {
+	days := make(map[time.Weekday]bool)
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok1 := weekdays[from]
+		last, ok2 := weekdays[to]
+		if !ok1 || (isRange && !ok2) {
+			return nil, fmt.Errorf("unknown days %s", s)
+		}
+		if !isRange {
+			last = first
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock returns the minutes after midnight of HH:MM, 24:00 is the end of the day.
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid time %s", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// quietUntil returns the end of the quiet hours now is in, false if it isn't in quiet hours.
+// The periods start on the days of the clock of now.
+func quietUntil(now time.Time, periods []quietPeriod) (time.Time, bool) {
+	var until time.Time
+	for _, p := range periods {
+		// a period that started yesterday can last until today
+		for _, day := range []time.Time{now.AddDate(0, 0, -1), now} {
+			midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, now.Location())
+			if len(p.days) > 0 && !p.days[midnight.Weekday()] {
+				continue
+			}
+			start := midnight.Add(time.Duration(p.start) * time.Minute)
+			end := midnight.Add(time.Duration(p.end) * time.Minute)
+			if p.end < p.start {
+				end = end.AddDate(0, 0, 1)
+			}
+			if !now.Before(start) && now.Before(end) && end.After(until) {
+				until = end
+			}
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// quietHolds are the messages held for the channels in quiet hours, keyed by channel ID.
+type quietHolds struct {
+	sync.Mutex
+	channels map[string]*quietHold
+}
+
+// quietHold are the messages held for a channel until the end of its quiet hours.
+type quietHold struct {
+	dest    *bridge.Bridge
+	channel config.ChannelInfo
+	lines   []string
+	more    int
+}
+
+// quietPeriods returns the QuietHours of channel in the QuietTimezone.
+func (gw *Gateway) quietPeriods(channel *config.ChannelInfo) ([]quietPeriod, *time.Location) {
+	opts := channel.Options
+	if len(opts.QuietHours) == 0 {
+		return nil, nil
+	}
+	periods, err := parseQuietHours(opts.QuietHours)
+	if err != nil {
+		gw.logger.Errorf("%s of %s on %s", err, channel.Name, channel.Account)
+		return nil, nil
+	}
+	loc := time.Local
+	if tz := opts.QuietTimezone; tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			gw.logger.Errorf("invalid QuietTimezone %s of %s on %s: %s", tz, channel.Name, channel.Account, err)
+			loc = time.Local
+		}
+	}
+	return periods, loc
+}
+
+// holdQuiet returns true when msg must not be sent to channel now because it's in its
+// QuietHours. The messages are held and sent as one digest when the quiet hours end, or
+// dropped with QuietMode drop. Like the coalesced messages of the rate limits, their
+// edits, deletes and replies aren't relayed.
+func (gw *Gateway) holdQuiet(rmsg, msg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo) bool {
+	if (msg.Event != "" && msg.Event != config.EventUserAction) || msg.ID != "" {
+		return false
+	}
+	periods, loc := gw.quietPeriods(channel)
+	if len(periods) == 0 {
+		return false
+	}
+	until, quiet := quietUntil(time.Now().In(loc), periods)
+	if !quiet {
+		return false
+	}
+	if channel.Options.QuietMode == quietDrop {
+		gw.logger.Debugf("quiet hours: dropping message of %s to %s on %s", rmsg.Username, channel.Name, dest.Account)
+		gw.publishDropped(msg, dest, channel, errDroppedQuiet)
+		return true
+	}
+
+	gw.quiet.Lock()
+	defer gw.quiet.Unlock()
+	h, ok := gw.quiet.channels[channel.ID]
+	if !ok {
+		h = &quietHold{dest: dest, channel: *channel}
+		gw.quiet.channels[channel.ID] = h
+		gw.logger.Debugf("quiet hours: holding the messages to %s on %s until %s", channel.Name, dest.Account, until.Format("15:04"))
+		id := channel.ID
+		time.AfterFunc(time.Until(until), func() { gw.flushQuiet(id) })
+	}
+	if len(h.lines) >= quietMaxHeld {
+		h.more++
+		return true
+	}
+	h.lines = append(h.lines, digestLine(msg))
+	return true
+}
+
+// flushQuiet sends the messages held for the channel with ID channelID as one digest.
+func (gw *Gateway) flushQuiet(channelID string) {
+	gw.quiet.Lock()
+	h, ok := gw.quiet.channels[channelID]
+	delete(gw.quiet.channels, channelID)
+	gw.quiet.Unlock()
+	if !ok {
+		return
+	}
+	notice := h.channel.Options.QuietNotice
+	if notice == "" {
+		notice = defaultQuietNotice
+	}
+	lines := append([]string{notice}, h.lines...)
+	if h.more > 0 {
+		lines = append(lines, fmt.Sprintf("(and %d more messages)", h.more))
+	}
+	msg := config.Message{
+		Text:    strings.Join(lines, "\n"),
+		Channel: h.channel.Name,
+		Account: h.dest.Account,
+		Gateway: gw.Name,
+	}
+	if _, err := gw.Router.send(h.dest, msg); err != nil {
+		gw.logger.Errorf("quiet hours: failed to send the digest of %d messages to %s on %s: %s", len(h.lines)+h.more, h.channel.Name, h.dest.Account, err)
+	}
+}
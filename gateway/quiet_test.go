@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	periods, err := parseQuietHours([]string{"22:00-07:00", "Sat,Sun 00:00-24:00", "Fri-Mon 12:30-13:00"})
+	require.NoError(t, err)
+	require.Len(t, periods, 3)
+	assert.Equal(t, quietPeriod{start: 22 * 60, end: 7 * 60}, periods[0])
+	assert.Equal(t, map[time.Weekday]bool{time.Saturday: true, time.Sunday: true}, periods[1].days)
+	assert.Len(t, periods[2].days, 4)
+	assert.Equal(t, 12*60+30, periods[2].start)
+
+	for _, entry := range []string{"", "22:00", "25:00-07:00", "7-8", "Xyz 10:00-11:00", "10:00-10:00", "24:00-01:00"} {
+		_, err := parseQuietHours([]string{entry})
+		assert.Error(t, err, entry)
+	}
+}
+
+func TestQuietUntil(t *testing.T) {
+	periods, err := parseQuietHours([]string{"22:00-07:00", "Sat 09:00-12:00"})
+	require.NoError(t, err)
+	at := func(day, hour, minute int) time.Time {
+		// October 2026, the 10th is a Saturday
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	until, ok := quietUntil(at(9, 23, 0), periods)
+	assert.True(t, ok)
+	assert.Equal(t, at(10, 7, 0), until)
+	until, ok = quietUntil(at(10, 6, 59), periods)
+	assert.True(t, ok)
+	assert.Equal(t, at(10, 7, 0), until)
+	_, ok = quietUntil(at(10, 7, 0), periods)
+	assert.False(t, ok)
+	until, ok = quietUntil(at(10, 10, 0), periods)
+	assert.True(t, ok)
+	assert.Equal(t, at(10, 12, 0), until)
+	_, ok = quietUntil(at(11, 10, 0), periods)
+	assert.False(t, ok)
+}
+
+func TestHoldQuiet(t *testing.T) {
+	gw := maketestRouter(testconfig).Gateways["bridge1"]
+	rec := &recordBridger{}
+	dest := gw.Bridges["slack.test"]
+	dest.Bridger = rec
+	channel := gw.Channels["testingslack.test"]
+	send := func(text string) {
+		msg := &config.Message{Text: text, Username: "user", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc"}
+		_, err := gw.SendMessage(msg, dest, channel, "")
+		require.NoError(t, err)
+	}
+	// always quiet
+	channel.Options.QuietHours = []string{"00:00-24:00"}
+
+	send("one")
+	send("two")
+	assert.Empty(t, rec.sent)
+	gw.flushQuiet(channel.ID)
+	require.Len(t, rec.sent, 1)
+	assert.Equal(t, defaultQuietNotice+"\none\ntwo", rec.sent[0].Text)
+	assert.Equal(t, channel.Name, rec.sent[0].Channel)
+
+	channel.Options.QuietMode = quietDrop
+	send("three")
+	gw.flushQuiet(channel.ID)
+	assert.Len(t, rec.sent, 1)
+
+	channel.Options.QuietHours = nil
+	send("four")
+	require.Len(t, rec.sent, 2)
+	assert.Equal(t, "four", rec.sent[1].Text)
+}
@@ -0,0 +1,169 @@
+package gateway
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+const (
+	quotaDrop     = "drop"
+	quotaThrottle = "throttle"
+	quotaNotify   = "notify"
+
+	// quotaThrottleInterval is how often a user over the message quota can still send with action throttle.
+	quotaThrottleInterval = time.Minute
+
+	messageWindow = time.Hour
+	mediaWindow   = 24 * time.Hour
+)
+
+func (gw *Gateway) quotaEnabled() bool {
+	q := gw.MyConfig.Quota
+	return q.UserMessages > 0 || q.UserMedia > 0 || q.ChannelMessages > 0 || q.ChannelMedia > 0
+}
+
+func (gw *Gateway) quotaBucket() *store.Bucket {
+	return store.NewBucket(gw.Router.Store, "quota:"+gw.Name)
+}
+
+// usage returns the usage counted under key in the current window and adds n to it.
+// Nothing is added when adding n would exceed limit, so dropped messages don't count.
+func (gw *Gateway) usage(key string, window time.Duration, n, limit int64) (int64, bool) {
+	now := time.Now()
+	key = fmt.Sprintf("%s %d", key, now.Unix()/int64(window.Seconds()))
+	bucket := gw.quotaBucket()
+	var used int64
+	if v, ok := bucket.GetString(key); ok {
+		used, _ = strconv.ParseInt(v, 10, 64)
+	}
+	if used+n > limit {
+		return used, false
+	}
+	if err := bucket.SetStringTTL(key, strconv.FormatInt(used+n, 10), window); err != nil {
+		gw.logger.Errorf("quota: failed to store usage: %s", err)
+	}
+	return used + n, true
+}
+
+// mediaSize returns the size of the files of msg.
+func mediaSize(msg *config.Message) int64 {
+	if msg.Extra == nil {
+		return 0
+	}
+	var size int64
+	for _, f := range msg.Extra["file"] {
+		fi, ok := f.(config.FileInfo)
+		if !ok {
+			continue
+		}
+		if fi.Size > 0 {
+			size += fi.Size
+		} else {
+			size += fi.DataSize()
+		}
+	}
+	return size
+}
+
+// overQuota returns which quota msg exceeds, if any. Usage is only counted for the quotas
+// that aren't exceeded.
+func (gw *Gateway) overQuota(msg *config.Message) (messages, media bool) {
+	q := gw.MyConfig.Quota
+	user, channel := "user "+userKey(msg), "channel "+msg.Account+" "+msg.Channel
+	for _, l := range []struct {
+		key   string
+		limit int
+	}{{user, q.UserMessages}, {channel, q.ChannelMessages}} {
+		if l.limit > 0 {
+			if _, ok := gw.usage("messages "+l.key, messageWindow, 1, int64(l.limit)); !ok {
+				messages = true
+			}
+		}
+	}
+	size := mediaSize(msg)
+	if size == 0 {
+		return messages, false
+	}
+	for _, l := range []struct {
+		key   string
+		limit int
+	}{{user, q.UserMedia}, {channel, q.ChannelMedia}} {
+		if l.limit > 0 {
+			if _, ok := gw.usage("media "+l.key, mediaWindow, size, int64(l.limit)*1024*1024); !ok {
+				media = true
+			}
+		}
+	}
+	return messages, media
+}
+
+// quotaMessage applies the quota of the gateway. Returns true if the message must not be relayed.
+func (gw *Gateway) quotaMessage(msg *config.Message) bool {
+	if !gw.quotaEnabled() {
+		return false
+	}
+	if msg.Event != "" && msg.Event != config.EventUserAction {
+		return false
+	}
+	messages, media := gw.overQuota(msg)
+	if !messages && !media {
+		return false
+	}
+	action := strings.ToLower(gw.MyConfig.Quota.Action)
+	switch action {
+	case quotaThrottle:
+		if messages && !gw.throttleAllows(msg) {
+			gw.logger.Debugf("quota: throttling %s (%s)", msg.Username, msg.Account)
+			return true
+		}
+		if media {
+			stripFiles(msg, true)
+		}
+		return false
+	case quotaNotify:
+		gw.notifyQuota(msg, messages)
+	case "", quotaDrop:
+	default:
+		gw.logger.Errorf("quota: unknown action %s, dropping message", gw.MyConfig.Quota.Action)
+	}
+	gw.logger.Infof("quota: not relaying message from %s (%s), quota exceeded", msg.Username, msg.Account)
+	return true
+}
+
+// throttleAllows returns true if the sender of msg, who is over the message quota,
+// didn't send a message in the last quotaThrottleInterval.
+func (gw *Gateway) throttleAllows(msg *config.Message) bool {
+	key := "throttle " + userKey(msg)
+	bucket := gw.quotaBucket()
+	if bucket.Contains(key) {
+		return false
+	}
+	if err := bucket.SetStringTTL(key, "", quotaThrottleInterval); err != nil {
+		gw.logger.Errorf("quota: failed to store throttle: %s", err)
+	}
+	return true
+}
+
+// notifyQuota tells the sender of msg their message wasn't relayed, once per window.
+func (gw *Gateway) notifyQuota(msg *config.Message, messages bool) {
+	kind, window := "media", mediaWindow
+	text := "you've reached the file upload limit, your message was not relayed"
+	if messages {
+		kind, window = "messages", messageWindow
+		text = "you've reached the message limit, your messages are not relayed for now"
+	}
+	key := "notified " + kind + " " + userKey(msg)
+	bucket := gw.quotaBucket()
+	if bucket.Contains(key) {
+		return
+	}
+	if err := bucket.SetStringTTL(key, "", window); err != nil {
+		gw.logger.Errorf("quota: failed to store notification: %s", err)
+	}
+	gw.notifyUser(msg, text)
+}
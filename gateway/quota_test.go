@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigQuota = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [gateway.quota]
+    usermessages = 2
+    usermedia = 1
+    action = "throttle"
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+	`)
+
+func TestQuota(t *testing.T) {
+	r := maketestRouter(testconfigQuota)
+	gw := r.Gateways["bridge1"]
+
+	newMsg := func(user string) *config.Message {
+		return &config.Message{Text: "hi", UserID: user, Channel: "general", Account: "discord.test"}
+	}
+	assert.False(t, gw.quotaMessage(newMsg("1")))
+	assert.False(t, gw.quotaMessage(newMsg("1")))
+	// over the quota one message per minute is relayed
+	assert.False(t, gw.quotaMessage(newMsg("1")))
+	assert.True(t, gw.quotaMessage(newMsg("1")))
+	// other users have their own quota
+	assert.False(t, gw.quotaMessage(newMsg("2")))
+
+	// files over the media quota are removed
+	msg := newMsg("3")
+	msg.Extra = map[string][]interface{}{"file": {config.FileInfo{Name: "video.mp4", Size: 2 * 1024 * 1024}}}
+	assert.False(t, gw.quotaMessage(msg))
+	assert.Empty(t, msg.Extra["file"])
+	assert.Contains(t, msg.Text, "hi")
+
+	gw.MyConfig.Quota.Action = "drop"
+	assert.False(t, gw.quotaMessage(newMsg("2")))
+	assert.True(t, gw.quotaMessage(newMsg("2")))
+}
@@ -0,0 +1,200 @@
+package gateway
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultRateLimitBurst = 5
+
+	// rateLimitMaxCoalesced is the number of messages coalesced for a channel at most, newer
+	// messages are dropped.
+	rateLimitMaxCoalesced = 50
+)
+
+var errDroppedRateLimit = errors.New("rate limit")
+
+// rateLimits are the token buckets of the RateLimit of the bridges, the RateLimit of the
+// channels and the UserRateLimit of the bridges, shared by the gateways. The messages over
+// a limit are coalesced by channel and sent as one message when the limits allow it.
+type rateLimits struct {
+	sync.Mutex
+	limiters  map[string]*rate.Limiter // by "bridge <account>", "channel <id>" and "user <account> <user>"
+	coalesced map[string]*coalesced    // by channel ID
+}
+
+func newRateLimits() *rateLimits {
+	return &rateLimits{limiters: make(map[string]*rate.Limiter), coalesced: make(map[string]*coalesced)}
+}
+
+// coalesced are the messages over the rate limits of a channel, waiting to be sent.
+type coalesced struct {
+	dest    *bridge.Bridge
+	channel config.ChannelInfo
+	msgs    []config.Message
+}
+
+// limiter returns the limiter of key for perMinute messages, the rateLimits must be locked.
+func (l *rateLimits) limiter(key string, perMinute, burst int) *rate.Limiter {
+	lim, ok := l.limiters[key]
+	if !ok {
+		lim = rate.NewLimiter(rate.Limit(float64(perMinute)/60), burst)
+		l.limiters[key] = lim
+	}
+	return lim
+}
+
+// rateLimiters returns the limiters of the messages sent to channel on dest, with the one of
+// the sender of rmsg when it isn't nil. The rateLimits must be locked.
+func (gw *Gateway) rateLimiters(rmsg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo) []*rate.Limiter {
+	limits := gw.Router.rateLimits
+	burst := dest.GetInt("RateLimitBurst")
+	if burst <= 0 {
+		burst = defaultRateLimitBurst
+	}
+	var lims []*rate.Limiter
+	if limit := dest.GetInt("RateLimit"); limit > 0 {
+		lims = append(lims, limits.limiter("bridge "+dest.Account, limit, burst))
+	}
+	if limit := channel.Options.RateLimit; limit > 0 {
+		lims = append(lims, limits.limiter("channel "+channel.ID, limit, burst))
+	}
+	if limit := dest.GetInt("UserRateLimit"); limit > 0 && rmsg != nil {
+		lims = append(lims, limits.limiter("user "+dest.Account+" "+userKey(rmsg), limit, burst))
+	}
+	return lims
+}
+
+// reserve takes a token of all lims and returns 0, or takes none and returns how long
+// until they all have one.
+func reserve(now time.Time, lims []*rate.Limiter) time.Duration {
+	var wait time.Duration
+	reservations := make([]*rate.Reservation, 0, len(lims))
+	for _, lim := range lims {
+		res := lim.ReserveN(now, 1)
+		reservations = append(reservations, res)
+		if delay := res.DelayFrom(now); delay > wait {
+			wait = delay
+		}
+	}
+	if wait > 0 {
+		for _, res := range reservations {
+			res.CancelAt(now)
+		}
+	}
+	return wait
+}
+
+// rateLimit applies the rate limits of channel on dest to msg. It returns true when msg
+// must not be sent now: it's over a limit, or older messages are still waiting, and it's
+// coalesced with the other messages for the channel. Coalesced messages are sent without
+// keeping their ID, so their edits, deletes and replies aren't relayed.
+func (gw *Gateway) rateLimit(rmsg, msg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo) bool {
+	if (msg.Event != "" && msg.Event != config.EventUserAction) || msg.ID != "" {
+		return false
+	}
+	limits := gw.Router.rateLimits
+	limits.Lock()
+	defer limits.Unlock()
+	lims := gw.rateLimiters(rmsg, dest, channel)
+	if len(lims) == 0 {
+		return false
+	}
+	now := time.Now()
+	c, waiting := limits.coalesced[channel.ID]
+	if !waiting {
+		wait := reserve(now, lims)
+		if wait == 0 {
+			return false
+		}
+		c = &coalesced{dest: dest, channel: *channel}
+		limits.coalesced[channel.ID] = c
+		gw.logger.Debugf("rate limit: coalescing the messages to %s on %s for %s", channel.Name, dest.Account, wait)
+		time.AfterFunc(wait, func() { gw.flushCoalesced(channel.ID) })
+	}
+	if len(c.msgs) >= rateLimitMaxCoalesced {
+		gw.logger.Debugf("rate limit: dropping message of %s to %s on %s", rmsg.Username, channel.Name, dest.Account)
+		gw.publishDropped(msg, dest, channel, errDroppedRateLimit)
+		return true
+	}
+	c.msgs = append(c.msgs, *msg)
+	return true
+}
+
+// flushCoalesced sends the coalesced messages of the channel with ID channelID as one
+// message, when the limits of the bridge and the channel allow it.
+func (gw *Gateway) flushCoalesced(channelID string) {
+	limits := gw.Router.rateLimits
+	limits.Lock()
+	c, ok := limits.coalesced[channelID]
+	if !ok {
+		limits.Unlock()
+		return
+	}
+	if wait := reserve(time.Now(), gw.rateLimiters(nil, c.dest, &c.channel)); wait > 0 {
+		limits.Unlock()
+		time.AfterFunc(wait, func() { gw.flushCoalesced(channelID) })
+		return
+	}
+	delete(limits.coalesced, channelID)
+	limits.Unlock()
+
+	msg := c.message()
+	sender := gw.balance(c.dest, &c.channel)
+	if captions := gw.renderCaptions(&msg, c.dest); captions != "" {
+		gw.sendCaptions(&msg, sender, captions)
+	}
+	if _, err := gw.Router.send(sender, msg); err != nil {
+		gw.logger.Errorf("rate limit: failed to send %d coalesced messages to %s on %s: %s", len(c.msgs), c.channel.Name, c.dest.Account, err)
+	}
+}
+
+// message returns the coalesced messages as one message, a line for every message with
+// the name of its sender and links to its files.
+func (c *coalesced) message() config.Message {
+	if len(c.msgs) == 1 {
+		return c.msgs[0]
+	}
+	lines := make([]string, 0, len(c.msgs))
+	for i := range c.msgs {
+		lines = append(lines, digestLine(&c.msgs[i]))
+	}
+	msg := c.msgs[0]
+	msg.Text = strings.Join(lines, "\n")
+	msg.Username = ""
+	msg.Avatar = ""
+	msg.UserID = ""
+	msg.Event = ""
+	msg.Extra = nil
+	msg.ParentID = ""
+	return msg
+}
+
+// digestLine returns msg as a line of a message combining several messages, with the name
+// of its sender and links to its files.
+func digestLine(msg *config.Message) string {
+	text := msg.Text
+	if msg.Extra != nil {
+		for _, f := range msg.Extra["file"] {
+			fi, ok := f.(config.FileInfo)
+			if !ok {
+				continue
+			}
+			fi.Comment = ""
+			link := helper.FileText(fi)
+			if link == "" {
+				link = "(" + fi.Name + ")"
+			}
+			text = strings.TrimSpace(text + " " + link)
+		}
+	}
+	return msg.Username + text
+}
@@ -0,0 +1,93 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigRateLimit = []byte(`
+[general]
+RemoteNickFormat="[{PROTOCOL}] <{NICK}> "
+
+[irc.freenode]
+server=""
+RateLimit=60
+RateLimitBurst=2
+[discord.test]
+server=""
+UserRateLimit=60
+RateLimitBurst=1
+[telegram.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "telegram.test"
+    channel = "-100"
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+        [gateway.inout.options]
+        RateLimit=600
+	`)
+
+func TestRateLimit(t *testing.T) {
+	r := maketestRouter(testconfigRateLimit)
+	gw := r.Gateways["bridge1"]
+	bridgers := make(map[string]*chanBridger)
+	for account, br := range gw.Bridges {
+		bridgers[account] = &chanBridger{sent: make(chan config.Message, 10)}
+		br.Bridger = bridgers[account]
+	}
+	relay := func(user, text, account string) {
+		gw.handleMessage(&config.Message{
+			Text: text, Username: user, UserID: user, Channel: "-100", Account: "telegram.test", Protocol: "telegram", Gateway: "bridge1",
+		}, gw.Bridges[account])
+	}
+	receive := func(account string) config.Message {
+		select {
+		case msg := <-bridgers[account].sent:
+			return msg
+		case <-time.After(3 * time.Second):
+			t.Fatalf("nothing sent to %s", account)
+		}
+		return config.Message{}
+	}
+
+	r.rateLimits.Lock()
+	assert.Len(t, gw.rateLimiters(&config.Message{Account: "telegram.test", UserID: "wim"}, gw.Bridges["discord.test"], gw.Channels["generaldiscord.test"]), 2)
+	r.rateLimits.Unlock()
+
+	// the burst of the bridge is sent, the messages over it are coalesced
+	for _, text := range []string{"one", "two", "three", "four"} {
+		relay("wim", text, "irc.freenode")
+	}
+	assert.Equal(t, "one", receive("irc.freenode").Text)
+	assert.Equal(t, "two", receive("irc.freenode").Text)
+	assert.Empty(t, bridgers["irc.freenode"].sent)
+	coalesced := receive("irc.freenode")
+	assert.Equal(t, "[telegram] <wim> three\n[telegram] <wim> four", coalesced.Text)
+	assert.Empty(t, coalesced.Username)
+
+	// a user over the limit doesn't hold up the others, but the order is kept while their
+	// messages are coalesced
+	relay("spammer", "buy", "discord.test")
+	relay("spammer", "buy now", "discord.test")
+	relay("wim", "hi", "discord.test")
+	assert.Equal(t, "buy", receive("discord.test").Text)
+	coalesced = receive("discord.test")
+	require.NotEmpty(t, coalesced.Text)
+	assert.Equal(t, "[telegram] <spammer> buy now\n[telegram] <wim> hi", coalesced.Text)
+}
@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/gateway/bridgemap"
+)
+
+const (
+	// reactionQuoteCacheSize is the number of recent messages kept for the text of reactions.
+	reactionQuoteCacheSize = 1000
+	// reactionQuoteLimit is the length of the quote of a reaction when QuoteLengthLimit isn't set.
+	reactionQuoteLimit = 50
+)
+
+// nativeReactions returns true if dest adds and removes reactions on its copies of messages.
+func nativeReactions(dest *bridge.Bridge) bool {
+	if c, ok := dest.Bridger.(bridge.Capabilities); ok {
+		return c.NativeReactions()
+	}
+	_, ok := bridgemap.ReactionSupport[dest.Protocol]
+	return ok
+}
+
+// isReaction returns true if msg adds or removes a reaction, the emoji is the text of msg
+// and ParentID the message reacted to.
+func isReaction(msg *config.Message) bool {
+	return msg.Event == config.EventReactionAdd || msg.Event == config.EventReactionRemove
+}
+
+// rememberQuote keeps the nick and text of msg, which are quoted by the text sent for a
+// reaction on msg to bridges without reactions.
+func (gw *Gateway) rememberQuote(msg *config.Message) {
+	if gw.quotes == nil || msg.ID == "" || msg.Text == "" || (msg.Event != "" && msg.Event != config.EventUserAction) {
+		return
+	}
+	gw.quotes.Add(msg.Protocol+" "+msg.ID, config.Quote{Username: msg.Username, Text: msg.Text})
+}
+
+// prepareReaction prepares the copy msg of the reaction rmsg for dest and returns false
+// when it can't be sent. Bridges with reactions need the copy of the message reacted to,
+// the others get an added reaction as a text message quoting that message.
+func (gw *Gateway) prepareReaction(rmsg, msg *config.Message, dest *bridge.Bridge, canonicalParentMsgID string) bool {
+	if nativeReactions(dest) {
+		return msg.ParentValid()
+	}
+	if rmsg.Event != config.EventReactionAdd {
+		return false
+	}
+	text := "reacted " + rmsg.Text
+	if gw.quotes != nil {
+		if v, ok := gw.quotes.Get(canonicalParentMsgID); ok {
+			quote := v.(config.Quote)
+			src := gw.Bridges[rmsg.Account]
+			limit := src.GetInt("QuoteLengthLimit")
+			if limit == 0 {
+				limit = reactionQuoteLimit
+			}
+			text = helper.FormatQuote(src.GetString("QuoteFormat"), text, quote.Username, quote.Text, limit)
+		}
+	}
+	msg.Event = ""
+	msg.Text = text
+	msg.ParentID = ""
+	return true
+}
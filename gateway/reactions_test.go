@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigReactions = []byte(`
+[irc.freenode]
+server=""
+ShowReactions=true
+[discord.test]
+server=""
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account="slack.test"
+    channel="testing"
+	`)
+
+func TestReactions(t *testing.T) {
+	r := maketestRouter(testconfigReactions)
+	gw := r.Gateways["bridge1"]
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+
+	gw.relayMessage(&config.Message{Text: "hello world", Username: "wim", ID: "42", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Gateway: "bridge1"})
+	slackID, ok := r.TranslateID("bridge1", "irc.freenode", "42", "slack.test", "testing")
+	assert.True(t, ok)
+
+	reaction := config.Message{
+		Event: config.EventReactionAdd, Text: "\U0001f44d", Username: "bob", ParentID: slackID,
+		Channel: "testing", Account: "slack.test", Protocol: "slack", Gateway: "bridge1",
+	}
+	gw.relayMessage(&reaction)
+
+	// discord reacts natively on its copy of the message
+	if assert.Len(t, recorders["discord.test"].sent, 2) {
+		sent := recorders["discord.test"].sent[1]
+		assert.Equal(t, config.EventReactionAdd, sent.Event)
+		assert.Equal(t, "1", sent.ParentID)
+		assert.Equal(t, "\U0001f44d", sent.Text)
+	}
+	// irc gets a text quoting the message
+	if assert.Len(t, recorders["irc.freenode"].sent, 1) {
+		sent := recorders["irc.freenode"].sent[0]
+		assert.Equal(t, "", sent.Event)
+		assert.Equal(t, "reacted \U0001f44d (re @wim: hello world)", sent.Text)
+		assert.Equal(t, "", sent.ParentID)
+	}
+
+	// removals are only sent to the bridges with reactions
+	removal := reaction
+	removal.Event = config.EventReactionRemove
+	gw.relayMessage(&removal)
+	if assert.Len(t, recorders["discord.test"].sent, 3) {
+		assert.Equal(t, config.EventReactionRemove, recorders["discord.test"].sent[2].Event)
+	}
+	assert.Len(t, recorders["irc.freenode"].sent, 1)
+
+	// reactions on unknown messages can't be added natively
+	unknown := reaction
+	unknown.ParentID = "unknown"
+	gw.relayMessage(&unknown)
+	assert.Len(t, recorders["discord.test"].sent, 3)
+	if assert.Len(t, recorders["irc.freenode"].sent, 2) {
+		assert.Equal(t, "reacted \U0001f44d", recorders["irc.freenode"].sent[1].Text)
+	}
+}
+
+func TestReactionsIgnored(t *testing.T) {
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+
+	assert.False(t, gw.ignoreEvent(config.EventReactionAdd, gw.Bridges["discord.test"]))
+	assert.False(t, gw.ignoreEvent(config.EventReactionRemove, gw.Bridges["slack.test"]))
+	assert.True(t, gw.ignoreEvent(config.EventReactionAdd, gw.Bridges["irc.freenode"]))
+	assert.True(t, gw.ignoreEvent(config.EventReactionRemove, gw.Bridges["irc.freenode"]))
+}
@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const defaultRedactReplacement = "[redacted]"
+
+// redactPatterns are the builtin redaction rules. Credit cards go before phone
+// numbers as both are digit sequences. A phone number starts with a + and a country code,
+// or is grouped like (555) 123-4567 or 555-123-4567, so dates, versions and order numbers
+// aren't redacted.
+var redactPatterns = map[string]string{
+	"email":      `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"creditcard": `\b(?:\d[ -]?){12,18}\d\b`,
+	"phone":      `(?:\+\d{1,3}[ .-]?(?:\(\d{1,4}\)[ .-]?)?\d{2,4}(?:[ .-]?\d{2,4}){1,3}|\(\d{3}\) ?\d{3}[ .-]\d{4}|\b\d{3}[.-]\d{3}[.-]\d{4})\b`,
+	"ip":         `\b(?:(?:\d{1,3}\.){3}\d{1,3}|(?:[0-9A-Fa-f]{1,4}:){7}[0-9A-Fa-f]{1,4}|(?:[0-9A-Fa-f]{1,4}:){1,7}:(?:[0-9A-Fa-f]{1,4}(?::[0-9A-Fa-f]{1,4})*)?)`,
+}
+
+var redactOrder = []string{"email", "creditcard", "ip", "phone"}
+
+// redactCache keeps the compiled redaction rules.
+var redactCache sync.Map
+
+func redactRegexp(rule string) (*regexp.Regexp, error) {
+	if re, ok := redactCache.Load(rule); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	pattern := rule
+	if builtin, ok := redactPatterns[strings.ToLower(rule)]; ok {
+		pattern = builtin
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	redactCache.Store(rule, re)
+	return re, nil
+}
+
+// sortRedactRules puts the builtin rules in redactOrder, followed by the custom regular expressions.
+func sortRedactRules(rules []string) []string {
+	var sorted []string
+	for _, name := range redactOrder {
+		for _, rule := range rules {
+			if strings.ToLower(rule) == name {
+				sorted = append(sorted, rule)
+				break
+			}
+		}
+	}
+	for _, rule := range rules {
+		if _, ok := redactPatterns[strings.ToLower(rule)]; !ok {
+			sorted = append(sorted, rule)
+		}
+	}
+	return sorted
+}
+
+// redact replaces everything in text matching the rules with replacement.
+func (gw *Gateway) redact(text string, rules []string, replacement string) string {
+	if replacement == "" {
+		replacement = defaultRedactReplacement
+	}
+	for _, rule := range sortRedactRules(rules) {
+		re, err := redactRegexp(rule)
+		if err != nil {
+			gw.logger.Errorf("incorrect redact regexp %s: %s", rule, err)
+			continue
+		}
+		text = re.ReplaceAllLiteralString(text, replacement)
+	}
+	return text
+}
+
+// redactMessage applies the redaction rules to the text and file comments of msg.
+func (gw *Gateway) redactMessage(msg *config.Message, rules []string, replacement string) {
+	msg.Text = gw.redact(msg.Text, rules, replacement)
+	if msg.Extra == nil || len(msg.Extra["file"]) == 0 {
+		return
+	}
+	cloneFiles(msg)
+	for i, f := range msg.Extra["file"] {
+		if fi, ok := f.(config.FileInfo); ok {
+			fi.Caption = gw.redact(fi.Caption, rules, replacement)
+			fi.Comment = gw.redact(fi.Comment, rules, replacement)
+			msg.Extra["file"][i] = fi
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedact(t *testing.T) {
+	gw := maketestRouter(testconfig).Gateways["bridge1"]
+	for _, tc := range []struct {
+		rule, in, out string
+	}{
+		{"email", "mail me at john.doe@example.com please", "mail me at [redacted] please"},
+		{"phone", "call +1 (555) 123-4567 now", "call [redacted] now"},
+		{"phone", "call +44 20 7946 0958 or +31612345678", "call [redacted] or [redacted]"},
+		{"phone", "call (555) 123-4567 or 555.123.4567", "call [redacted] or [redacted]"},
+		// dates, times, versions and order numbers aren't phone numbers
+		{"phone", "on 2024-01-15 10:30:00 or 15.01.2024", "on 2024-01-15 10:30:00 or 15.01.2024"},
+		{"phone", "upgrade to 1.21.13 or v10.15.7", "upgrade to 1.21.13 or v10.15.7"},
+		{"phone", "order #123456789 and 1234-5678-90", "order #123456789 and 1234-5678-90"},
+		{"phone", "ISBN 978-3-16-148410-0, +1 from me", "ISBN 978-3-16-148410-0, +1 from me"},
+		{"ip", "server is 192.168.1.10", "server is [redacted]"},
+		{"ip", "v6 2001:db8::1 here", "v6 [redacted] here"},
+		{"creditcard", "card 4111 1111 1111 1111 ok", "card [redacted] ok"},
+		{"secret-[0-9]+", "token secret-1234", "token [redacted]"},
+		{"email", "nothing to see", "nothing to see"},
+	} {
+		assert.Equal(t, tc.out, gw.redact(tc.in, []string{tc.rule}, ""), tc.rule)
+	}
+	assert.Equal(t, "a *** b ***", gw.redact("a a@b.cd b 10.0.0.1", []string{"ip", "email"}, "***"))
+}
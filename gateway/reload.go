@@ -0,0 +1,194 @@
+package gateway
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/gateway/events"
+	"github.com/42wim/matterbridge/gateway/samechannel"
+)
+
+// Reload makes the router read the configuration file again, eg on SIGHUP. The reload is
+// done by the goroutine routing the messages, so no message is routed halfway a reload.
+func (r *Router) Reload() {
+	r.Message <- config.Message{Event: config.EventReloadConfig}
+}
+
+func (r *Router) handleEventReloadConfig(msg *config.Message) bool {
+	if msg.Event != config.EventReloadConfig || msg.Account != "" {
+		return false
+	}
+	r.reloadConfig()
+	return true
+}
+
+// reloadConfig applies the reloaded configuration to the gateways. The bridges that are
+// still used stay connected and join the channels added to their gateways, new bridges
+// are started and bridges that aren't used anymore are stopped.
+func (r *Router) reloadConfig() {
+	if err := r.Config.Reload(); err != nil {
+		r.logger.Errorf("Reloading the configuration failed, keeping the running gateways: %s", err)
+		return
+	}
+	sgw := samechannel.New(r.Config)
+	gwconfigs := append(sgw.GetConfig(), r.BridgeValues().Gateway...)
+	if err := r.checkGatewayConfigs(gwconfigs); err != nil {
+		r.logger.Errorf("Reloading the configuration failed, keeping the running gateways: %s", err)
+		return
+	}
+
+	// the gateways reuse the running bridges, with the channels of the new configuration.
+	// A bridge reconnecting meanwhile joins only part of them, the others are joined below.
+	r.reloaded = make(map[string]*bridge.Bridge)
+	for _, gw := range r.gateways() {
+		for account, br := range gw.Bridges {
+			br.ClearChannels()
+			r.reloaded[account] = br
+		}
+	}
+	defer func() { r.reloaded = nil }()
+
+	seen := make(map[string]bool)
+	for idx := range gwconfigs {
+		entry := &gwconfigs[idx]
+		if !entry.Enable {
+			continue
+		}
+		seen[entry.Name] = true
+		if gw, ok := r.gateways()[entry.Name]; ok {
+			r.logger.Infof("Reloading gateway %s", entry.Name)
+			gw.reload(entry)
+			continue
+		}
+		r.logger.Infof("Adding gateway %s", entry.Name)
+		r.setGateway(entry.Name, New(r.rootLogger, entry, r))
+	}
+	for name := range r.gateways() {
+		if !seen[name] {
+			r.logger.Infof("Removing gateway %s", name)
+			r.setGateway(name, nil)
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, gw := range r.gateways() {
+		for account, br := range gw.Bridges {
+			if used[account] {
+				continue
+			}
+			used[account] = true
+			if old, ok := r.reloaded[account]; ok && old == br {
+				r.reloadBridge(br)
+				continue
+			}
+			r.startBridge(br)
+		}
+	}
+	for account, br := range r.reloaded {
+		if used[account] || br.Bridger == nil {
+			continue
+		}
+		r.logger.Infof("Stopping bridge %s", account)
+		if err := br.Disconnect(); err != nil {
+			r.logger.Errorf("Disconnect() %s failed: %s", account, err)
+		}
+		r.Events.Publish(events.Event{Kind: events.BridgeDisconnected, Account: account})
+	}
+}
+
+// checkGatewayConfigs checks the gateways like NewRouter and AddBridge do at startup, so a
+// mistake in the reloaded configuration doesn't stop matterbridge.
+func (r *Router) checkGatewayConfigs(gwconfigs []config.Gateway) error {
+	names := make(map[string]bool)
+	for _, entry := range gwconfigs {
+		if !entry.Enable {
+			continue
+		}
+		if entry.Name == "" {
+			return fmt.Errorf("%s", "Gateway without name found")
+		}
+		if names[entry.Name] {
+			return fmt.Errorf("Gateway with name %s already exists", entry.Name)
+		}
+		names[entry.Name] = true
+		accounts := append(entry.In, append(entry.InOut, entry.Out...)...)
+		if entry.Moderation.Account != "" {
+			accounts = append(accounts, config.Bridge{Account: entry.Moderation.Account})
+		}
+		for _, br := range accounts {
+			protocol := strings.Split(br.Account, ".")[0]
+			if _, ok := r.BridgeMap[protocol]; !ok || !strings.Contains(br.Account, ".") {
+				return fmt.Errorf("incorrect account %s in gateway %s, this build supports %s",
+					br.Account, entry.Name, strings.Join(r.protocols(), ", "))
+			}
+			if !hasAccountConfig(r.Config, br.Account) {
+				return fmt.Errorf("account %s defined in gateway %s but no configuration found", br.Account, entry.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// reloadBridge tells the running bridge br about the new configuration and joins the
+// channels that were added.
+func (r *Router) reloadBridge(br *bridge.Bridge) {
+	if br.Bridger == nil {
+		return
+	}
+	err := withBridgeLabel(br, func() error { return br.Reload(r.Config) })
+	if err != nil {
+		r.logger.Errorf("Reload() %s failed: %s", br.Account, err)
+	}
+	channels := br.GetChannels()
+	for ID := range br.Joined {
+		if _, ok := channels[ID]; !ok {
+			r.logger.Infof("%s: channel %s was removed, it stays joined until %s is restarted", br.Account, ID, br.Account)
+			delete(br.Joined, ID)
+		}
+	}
+	if err := withBridgeLabel(br, br.JoinChannels); err != nil {
+		r.logger.Errorf("JoinChannels() %s failed: %s", br.Account, err)
+	}
+}
+
+// startBridge connects a bridge added by the reloaded configuration.
+func (r *Router) startBridge(br *bridge.Bridge) {
+	r.logger.Infof("Starting bridge: %s ", br.Account)
+	if err := withBridgeLabel(br, br.Connect); err != nil {
+		r.logger.Errorf("Bridge %s failed to start: %v", br.Account, err)
+		r.removeBridge(br.Account)
+		return
+	}
+	r.Events.Publish(events.Event{Kind: events.BridgeConnected, Account: br.Account})
+	if err := withBridgeLabel(br, br.JoinChannels); err != nil {
+		r.logger.Errorf("Bridge %s failed to join channel: %v", br.Account, err)
+	}
+}
+
+func (r *Router) removeBridge(account string) {
+	for _, gw := range r.gateways() {
+		delete(gw.Bridges, account)
+	}
+}
+
+// reload replaces the configuration of the gateway by cfg, keeping the messages waiting
+// for moderation or for the next summary.
+func (gw *Gateway) reload(cfg *config.Gateway) {
+	mod, sum := gw.moderation, gw.summarizer
+	gw.Bridges = make(map[string]*bridge.Bridge)
+	gw.Channels = make(map[string]*config.ChannelInfo)
+	gw.moderation = nil
+	gw.summarizer = nil
+	gw.alerts = nil
+	if err := gw.AddConfig(cfg); err != nil {
+		gw.logger.Errorf("Failed to add configuration to gateway: %#v", err)
+	}
+	if mod != nil && gw.moderation != nil {
+		gw.moderation = mod
+	}
+	if sum != nil && gw.summarizer != nil {
+		gw.summarizer = sum
+	}
+}
@@ -0,0 +1,189 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigReload = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+RemoteNickFormat="{NICK}: "
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+	`)
+
+var testconfigReloaded = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+RemoteNickFormat="<{NICK}> "
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "offtopic"
+
+[[gateway]]
+    name = "bridge2"
+    enable=true
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "random"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "testing"
+	`)
+
+// reloadBridger is a Bridger that records the calls of the router.
+type reloadBridger struct {
+	connects, disconnects, reloads int
+	joined                         []string
+}
+
+func (b *reloadBridger) Send(msg config.Message) (string, error) { return "", nil }
+func (b *reloadBridger) Connect() error                          { b.connects++; return nil }
+func (b *reloadBridger) Disconnect() error                       { b.disconnects++; return nil }
+func (b *reloadBridger) Reload(cfg config.Config) error          { b.reloads++; return nil }
+
+func (b *reloadBridger) JoinChannel(channel config.ChannelInfo) error {
+	b.joined = append(b.joined, channel.Name)
+	return nil
+}
+
+func TestReloadConfig(t *testing.T) {
+	cfgfile := filepath.Join(t.TempDir(), "matterbridge.toml")
+	require.NoError(t, ioutil.WriteFile(cfgfile, testconfigReload, 0600))
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	bridgers := make(map[string]*reloadBridger)
+	factory := func(cfg *bridge.Config) bridge.Bridger {
+		b := &reloadBridger{}
+		bridgers[cfg.Account] = b
+		return b
+	}
+	bridgeMap := map[string]bridge.Factory{"irc": factory, "discord": factory, "slack": factory}
+	r, err := NewRouter(logger, config.NewConfig(logger, cfgfile), bridgeMap)
+	require.NoError(t, err)
+	for _, br := range r.Gateways["bridge1"].Bridges {
+		require.NoError(t, br.Connect())
+		require.NoError(t, br.JoinChannels())
+	}
+	discord := r.Gateways["bridge1"].Bridges["discord.test"]
+	assert.Equal(t, "{NICK}: ", discord.GetString("RemoteNickFormat"))
+
+	// a broken file keeps the running gateways
+	require.NoError(t, ioutil.WriteFile(cfgfile, []byte("[[gateway]\n"), 0600))
+	r.reloadConfig()
+	assert.Len(t, r.Gateways, 1)
+	assert.Equal(t, "{NICK}: ", discord.GetString("RemoteNickFormat"))
+
+	require.NoError(t, ioutil.WriteFile(cfgfile, testconfigReloaded, 0600))
+	r.reloadConfig()
+	require.Len(t, r.Gateways, 2)
+	assert.Len(t, r.Gateways["bridge1"].Channels, 3)
+	assert.Len(t, r.Gateways["bridge2"].Channels, 2)
+	assert.Equal(t, "<{NICK}> ", discord.GetString("RemoteNickFormat"))
+
+	// the running bridges are reused and join the added channels
+	assert.Same(t, discord, r.Gateways["bridge1"].Bridges["discord.test"])
+	assert.Same(t, discord, r.Gateways["bridge2"].Bridges["discord.test"])
+	assert.Equal(t, 1, bridgers["discord.test"].connects)
+	assert.Equal(t, 1, bridgers["discord.test"].reloads)
+	assert.ElementsMatch(t, []string{"general", "offtopic", "random"}, bridgers["discord.test"].joined)
+	assert.Equal(t, []string{"#wimtesting"}, bridgers["irc.freenode"].joined)
+	assert.Len(t, discord.Channels, 3)
+
+	// new bridges are started
+	assert.Equal(t, 1, bridgers["slack.test"].connects)
+	assert.Equal(t, []string{"testing"}, bridgers["slack.test"].joined)
+
+	// removing a gateway stops the bridges only it used
+	require.NoError(t, ioutil.WriteFile(cfgfile, testconfigReload, 0600))
+	r.reloadConfig()
+	assert.Len(t, r.Gateways, 1)
+	assert.Equal(t, 1, bridgers["slack.test"].disconnects)
+	assert.Equal(t, 0, bridgers["discord.test"].disconnects)
+	assert.Len(t, discord.Channels, 1)
+	assert.Len(t, discord.Joined, 1)
+}
+
+// TestReloadConfigReaders reads the gateways and the channels of a bridge while the
+// configuration is reloaded, like the admin API and a reconnecting bridge do. go test
+// -race reports it if they aren't safe.
+func TestReloadConfigReaders(t *testing.T) {
+	cfgfile := filepath.Join(t.TempDir(), "matterbridge.toml")
+	require.NoError(t, ioutil.WriteFile(cfgfile, testconfigReload, 0600))
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	factory := func(cfg *bridge.Config) bridge.Bridger { return &reloadBridger{} }
+	bridgeMap := map[string]bridge.Factory{"irc": factory, "discord": factory, "slack": factory}
+	r, err := NewRouter(logger, config.NewConfig(logger, cfgfile), bridgeMap)
+	require.NoError(t, err)
+	discord := r.Gateways["bridge1"].Bridges["discord.test"]
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for name := range r.gateways() {
+				assert.NotEmpty(t, name)
+			}
+			for ID := range discord.GetChannels() {
+				assert.NotEmpty(t, ID)
+			}
+		}
+	}()
+	for _, cfg := range [][]byte{testconfigReloaded, testconfigReload, testconfigReloaded} {
+		require.NoError(t, ioutil.WriteFile(cfgfile, cfg, 0600))
+		r.reloadConfig()
+	}
+	close(done)
+	<-stopped
+	assert.Len(t, r.gateways(), 2)
+	assert.Len(t, discord.GetChannels(), 3)
+}
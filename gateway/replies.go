@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/gateway/bridgemap"
+)
+
+const (
+	defaultReplyContextFormat = "> {QUOTENICK}: {QUOTEMESSAGE}\n{MESSAGE}"
+	defaultReplyContextLength = 80
+)
+
+// nativeReplies returns true if dest sends messages with a ParentID as a reply to that message.
+func nativeReplies(dest *bridge.Bridge) bool {
+	if c, ok := dest.Bridger.(bridge.Capabilities); ok {
+		return c.NativeReplies()
+	}
+	_, ok := bridgemap.ReplySupport[dest.Protocol]
+	return ok
+}
+
+// replyQuote returns the quote of the message msg replies to, if the bridge of msg quoted it.
+func replyQuote(msg *config.Message) (config.Quote, bool) {
+	if msg.Extra == nil || len(msg.Extra[config.ExtraQuote]) == 0 {
+		return config.Quote{}, false
+	}
+	quote, ok := msg.Extra[config.ExtraQuote][0].(config.Quote)
+	return quote, ok
+}
+
+// quoteReply returns the text of msg with the quote of the message rmsg replies to, formatted
+// with the QuoteFormat of the bridge of rmsg. The quote is left out when dest replies to
+// the copy of the parent message natively. Without a quote of the bridge of rmsg dest gets
+// the excerpt of the parent message of its ReplyContext.
+func (gw *Gateway) quoteReply(rmsg *config.Message, msg *config.Message, dest *bridge.Bridge, canonicalParentMsgID string) string {
+	if nativeReplies(dest) && msg.ParentValid() {
+		return msg.Text
+	}
+	quote, ok := replyQuote(rmsg)
+	if !ok {
+		return gw.replyContext(rmsg, msg, dest, canonicalParentMsgID)
+	}
+	src, ok := gw.Bridges[rmsg.Account]
+	if !ok {
+		return msg.Text
+	}
+	return helper.FormatQuote(src.GetString("QuoteFormat"), msg.Text, quote.Username, quote.Text, src.GetInt("QuoteLengthLimit"))
+}
+
+// replyContext returns the text of msg with an excerpt of the parent message of the reply
+// rmsg, formatted with the ReplyContextFormat of dest. The parent is looked up in the
+// recent messages and the archive, the text is left as is when it's not found.
+func (gw *Gateway) replyContext(rmsg *config.Message, msg *config.Message, dest *bridge.Bridge, canonicalParentMsgID string) string {
+	if !dest.GetBool("ReplyContext") || canonicalParentMsgID == "" || !rmsg.ParentValid() ||
+		(rmsg.Event != "" && rmsg.Event != config.EventUserAction) {
+		return msg.Text
+	}
+	quote, ok := gw.parentQuote(canonicalParentMsgID)
+	if !ok {
+		return msg.Text
+	}
+	format := dest.GetString("ReplyContextFormat")
+	if format == "" {
+		format = defaultReplyContextFormat
+	}
+	limit := dest.GetInt("ReplyContextLength")
+	if limit == 0 {
+		limit = defaultReplyContextLength
+	}
+	// the excerpt is on one line, the reply itself follows it
+	text := strings.Join(strings.Fields(quote.Text), " ")
+	return helper.FormatQuote(format, msg.Text, quote.Username, text, limit)
+}
+
+// parentQuote returns the nick and text of the canonical message.
+func (gw *Gateway) parentQuote(canonical string) (config.Quote, bool) {
+	if gw.quotes != nil {
+		if v, ok := gw.quotes.Get(canonical); ok {
+			return v.(config.Quote), true
+		}
+	}
+	if gw.Router == nil || gw.Router.archive == nil {
+		return config.Quote{}, false
+	}
+	protocol, id, ok := strings.Cut(canonical, " ")
+	if !ok {
+		return config.Quote{}, false
+	}
+	quote, ok, err := gw.Router.archive.quote(gw.Name, protocol, id)
+	if err != nil {
+		gw.logger.Errorf("archive: failed to read message %s: %s", canonical, err)
+	}
+	return quote, ok
+}
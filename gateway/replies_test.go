@@ -0,0 +1,163 @@
+package gateway
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigReplies = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+[slack.test]
+server=""
+QuoteFormat="{MESSAGE} > {QUOTENICK}: {QUOTEMESSAGE}"
+QuoteLengthLimit=5
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+    [[gateway.inout]]
+    account="slack.test"
+    channel="testing"
+	`)
+
+func TestNativeReplies(t *testing.T) {
+	r := maketestRouter(testconfigReplies)
+	gw := r.Gateways["bridge1"]
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+
+	gw.relayMessage(&config.Message{Text: "hello world", Username: "wim", ID: "42", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Gateway: "bridge1"})
+	id, ok := r.TranslateID("bridge1", "irc.freenode", "42", "discord.test", "general")
+	assert.True(t, ok)
+	assert.Equal(t, "1", id)
+	slackID, ok := r.TranslateID("bridge1", "irc.freenode", "42", "slack.test", "testing")
+	assert.True(t, ok)
+	id, ok = r.TranslateID("bridge1", "slack.test", slackID, "discord.test", "general")
+	assert.True(t, ok)
+	assert.Equal(t, "1", id)
+	id, ok = r.TranslateID("bridge1", "discord.test", "1", "irc.freenode", "#WimTesting")
+	assert.True(t, ok)
+	assert.Equal(t, "42", id)
+	_, ok = r.TranslateID("bridge1", "irc.freenode", "43", "discord.test", "general")
+	assert.False(t, ok)
+
+	reply := &config.Message{
+		Text: "yes", Username: "bob", ID: "2", ParentID: slackID, Channel: "testing", Account: "slack.test", Protocol: "slack", Gateway: "bridge1",
+		Extra: map[string][]interface{}{config.ExtraQuote: {config.Quote{Username: "wim", Text: "hello world"}}},
+	}
+	gw.relayMessage(reply)
+
+	// discord replies natively to its copy of the parent
+	if assert.Len(t, recorders["discord.test"].sent, 2) {
+		sent := recorders["discord.test"].sent[1]
+		assert.Equal(t, "1", sent.ParentID)
+		assert.Equal(t, "yes", sent.Text)
+	}
+	// irc gets the quote, formatted by the slack bridge settings
+	if assert.Len(t, recorders["irc.freenode"].sent, 1) {
+		assert.Equal(t, "yes > wim: hello...", recorders["irc.freenode"].sent[0].Text)
+	}
+}
+
+// capableBridger is a recordBridger that replies natively, like IRC with message-tags.
+type capableBridger struct {
+	recordBridger
+}
+
+func (c *capableBridger) NativeReplies() bool   { return true }
+func (c *capableBridger) NativeReactions() bool { return true }
+
+func TestCapabilities(t *testing.T) {
+	r := maketestRouter(testconfigReplies)
+	gw := r.Gateways["bridge1"]
+	irc := &capableBridger{}
+	gw.Bridges["irc.freenode"].Bridger = irc
+	gw.Bridges["discord.test"].Bridger = &recordBridger{}
+	gw.Bridges["slack.test"].Bridger = &recordBridger{}
+	assert.True(t, nativeReplies(gw.Bridges["irc.freenode"]))
+	assert.True(t, nativeReactions(gw.Bridges["irc.freenode"]))
+
+	gw.relayMessage(&config.Message{Text: "hello world", Username: "wim", ID: "msgid1", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Gateway: "bridge1"})
+	gw.relayMessage(&config.Message{
+		Text: "yes", Username: "bob", ID: "2", ParentID: "1", Channel: "general", Account: "discord.test", Protocol: "discord", Gateway: "bridge1",
+		Extra: map[string][]interface{}{config.ExtraQuote: {config.Quote{Username: "wim", Text: "hello world"}}},
+	})
+	gw.relayMessage(&config.Message{Text: "👍", Username: "bob", ParentID: "1", Event: config.EventReactionAdd, Channel: "general", Account: "discord.test", Protocol: "discord", Gateway: "bridge1"})
+
+	// irc replies and reacts to its own message, without the quote
+	if assert.Len(t, irc.sent, 2) {
+		assert.Equal(t, "msgid1", irc.sent[0].ParentID)
+		assert.Equal(t, "yes", irc.sent[0].Text)
+		assert.Equal(t, config.EventReactionAdd, irc.sent[1].Event)
+		assert.Equal(t, "msgid1", irc.sent[1].ParentID)
+	}
+}
+
+func TestReplyContext(t *testing.T) {
+	r := maketestRouter([]byte(strings.Replace(string(testconfigReplies), "[irc.freenode]\nserver=\"\"\n",
+		"[irc.freenode]\nserver=\"\"\nReplyContext=true\nReplyContextLength=8\n", 1)))
+	gw := r.Gateways["bridge1"]
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+
+	root := &config.Message{Text: "hello\nworld", Username: "wim", ID: "1.1", Channel: "testing", Account: "slack.test", Protocol: "slack", Gateway: "bridge1"}
+	gw.relayMessage(root)
+	parentID, ok := r.TranslateID("bridge1", "slack.test", "1.1", "discord.test", "general")
+	require.True(t, ok)
+
+	// the discord reply has no quote, irc gets the excerpt of the parent on its own line
+	reply := func(id string) *config.Message {
+		return &config.Message{Text: "yes", Username: "bob", ID: id, ParentID: parentID, Channel: "general", Account: "discord.test", Protocol: "discord", Gateway: "bridge1"}
+	}
+	gw.relayMessage(reply("2"))
+	if assert.Len(t, recorders["irc.freenode"].sent, 2) {
+		assert.Equal(t, "> wim: hello wo...\nyes", recorders["irc.freenode"].sent[1].Text)
+	}
+	// ReplyContext is only set for irc
+	if assert.Len(t, recorders["slack.test"].sent, 1) {
+		assert.Equal(t, "yes", recorders["slack.test"].sent[0].Text)
+	}
+
+	// older messages are found in the archive
+	a, err := newArchive(filepath.Join(t.TempDir(), "archive.db"), 0)
+	require.NoError(t, err)
+	defer a.Close()
+	r.archive = a
+	require.NoError(t, a.add("bridge1", root))
+	gw.quotes.Purge()
+	gw.relayMessage(reply("3"))
+	if assert.Len(t, recorders["irc.freenode"].sent, 3) {
+		assert.Equal(t, "> wim: hello wo...\nyes", recorders["irc.freenode"].sent[2].Text)
+	}
+
+	// unknown parents are left out
+	gw.quotes.Purge()
+	r.archive = nil
+	gw.relayMessage(reply("4"))
+	if assert.Len(t, recorders["irc.freenode"].sent, 4) {
+		assert.Equal(t, "yes", recorders["irc.freenode"].sent[3].Text)
+	}
+}
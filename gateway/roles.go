@@ -0,0 +1,39 @@
+package gateway
+
+import (
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// senderRoles returns the roles the bridge of msg set for its sender.
+func senderRoles(msg *config.Message) []string {
+	if msg.Extra == nil {
+		return nil
+	}
+	var roles []string
+	for _, v := range msg.Extra[config.ExtraRoles] {
+		if role, ok := v.(string); ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// hasRequiredRole returns false when the channel of msg has RequireRoles and its sender
+// has none of them. Roles are compared case-insensitively, deletes are always relayed.
+func (gw *Gateway) hasRequiredRole(msg *config.Message) bool {
+	channel, ok := gw.Channels[getChannelID(msg)]
+	if !ok || len(channel.Options.RequireRoles) == 0 || msg.Event == config.EventMsgDelete {
+		return true
+	}
+	for _, role := range senderRoles(msg) {
+		for _, required := range channel.Options.RequireRoles {
+			if strings.EqualFold(role, required) {
+				return true
+			}
+		}
+	}
+	gw.logger.Debugf("ignoring message from %s on %s, without one of the roles %v", msg.Username, channel.ID, channel.Options.RequireRoles)
+	return false
+}
@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigRoles = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+        [gateway.inout.options]
+        requireroles = ["voice", "op"]
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+`)
+
+func TestHasRequiredRole(t *testing.T) {
+	r := maketestRouter(testconfigRoles)
+	gw := r.Gateways["bridge1"]
+	withRoles := func(account, channel string, roles ...interface{}) *config.Message {
+		msg := &config.Message{Text: "test", Username: "user", Account: account, Channel: channel, Gateway: "bridge1"}
+		if roles != nil {
+			msg.Extra = map[string][]interface{}{config.ExtraRoles: roles}
+		}
+		return msg
+	}
+
+	// only voiced users and ops on IRC are relayed
+	assert.False(t, gw.hasRequiredRole(withRoles("irc.freenode", "#wimtesting")))
+	assert.False(t, gw.hasRequiredRole(withRoles("irc.freenode", "#wimtesting", "halfop")))
+	assert.True(t, gw.hasRequiredRole(withRoles("irc.freenode", "#wimtesting", "voice")))
+	assert.True(t, gw.hasRequiredRole(withRoles("irc.freenode", "#wimtesting", "halfop", "OP")))
+	assert.True(t, gw.ignoreMessage(withRoles("irc.freenode", "#wimtesting")))
+	assert.False(t, gw.ignoreMessage(withRoles("irc.freenode", "#wimtesting", "op")))
+
+	// deletes are always relayed
+	del := withRoles("irc.freenode", "#wimtesting")
+	del.Event = config.EventMsgDelete
+	assert.True(t, gw.hasRequiredRole(del))
+
+	// everyone on discord is relayed
+	assert.True(t, gw.hasRequiredRole(withRoles("discord.test", "general")))
+}
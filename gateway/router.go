@@ -2,25 +2,87 @@ package gateway
 
 import (
 	"fmt"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/42wim/matterbridge/bridge"
 	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/store"
+	"github.com/42wim/matterbridge/gateway/events"
 	"github.com/42wim/matterbridge/gateway/samechannel"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
+// lowMemoryGCPercent is the GOGC of LowMemory mode, the default is 100.
+const lowMemoryGCPercent = 50
+
 type Router struct {
 	config.Config
 	sync.RWMutex
 
 	BridgeMap        map[string]bridge.Factory
-	Gateways         map[string]*Gateway
+	Gateways         map[string]*Gateway // replaced by setGateway once the router is started, read it with gateways
 	Message          chan config.Message
 	MattermostPlugin chan config.Message
+	Store            store.Store
+	Events           *events.Bus
+
+	logger     *logrus.Entry
+	rootLogger *logrus.Logger
+
+	gatewaysMu sync.RWMutex
+
+	tasks sync.WaitGroup // the replays and backfills started by goBridge
+
+	replayMu sync.Mutex
+	retries  map[string]*retry          // next replays of the offline queues, by account
+	dropping map[string]map[string]bool // gateways of which the offline queue of an account drops messages
+	queued   map[string]int             // number of messages in the offline queue, by account
+	replays  chan *bridge.Bridge        // the bridges of which handleReceive replays the offline queue, see requestReplay
+
+	breakersMu sync.Mutex
+	breakers   map[string]*breaker
+
+	msgIDs  MsgIDStore
+	history *history
+	archive *archive
+	flush   chan chan struct{} // closed by handleReceive once the messages before it are handled
+
+	// preparing are the messages waiting for their files, by account and channel, only used by
+	// handleReceive. See queueMessage.
+	preparing map[string][]config.Message
+	prepared  chan preparedMessage
+	flushing  []chan struct{} // flushes waiting for the messages being prepared
+
+	// links are the gateways created with the link command, only used by handleReceive
+	links        map[string]*link
+	expiredLinks chan *link // sent by the timers of the temporary links
+
+	// reloaded are the bridges of before a reload of the configuration, reused by the gateways
+	reloaded map[string]*bridge.Bridge
+
+	scriptLimiter *rate.Limiter
+	logins        *logins
+
+	credentialsMu     sync.Mutex
+	credentialsWarned map[string]time.Time // expiry of the credentials of the last warning, by account
 
-	logger *logrus.Entry
+	// balanced are the messages sent by LoadBalanceAccounts, as "<account> <id>" of the
+	// account they sent for
+	balanced *lru.Cache
+	controls *controls
+	canaries *canaries
+	// rateLimits are the RateLimit and UserRateLimit of the bridges and channels
+	rateLimits *rateLimits
+	// dashboard is nil without AdminDashboard
+	dashboard *dashboard
 }
 
 // NewRouter initializes a new Matterbridge router for the specified configuration and
@@ -28,14 +90,63 @@ type Router struct {
 func NewRouter(rootLogger *logrus.Logger, cfg config.Config, bridgeMap map[string]bridge.Factory) (*Router, error) {
 	logger := rootLogger.WithFields(logrus.Fields{"prefix": "router"})
 
+	helper.ConfigureDownloads(&cfg.BridgeValues().General)
+	if cfg.BridgeValues().General.LowMemory && os.Getenv("GOGC") == "" {
+		// collect garbage sooner, so relaying a video doesn't grow the heap to twice its size
+		debug.SetGCPercent(lowMemoryGCPercent)
+	}
+
+	if err := configureMediaUpload(&cfg.BridgeValues().General); err != nil {
+		return nil, err
+	}
+
+	st, err := store.New(&cfg.BridgeValues().General)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %s", err)
+	}
+	msgIDs, err := newMsgIDStore(&cfg.BridgeValues().General, st)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message ID store: %s", err)
+	}
+	var arch *archive
+	if path := cfg.BridgeValues().General.ArchivePath; path != "" {
+		if arch, err = newArchive(path, cfg.BridgeValues().General.ArchiveDays); err != nil {
+			return nil, fmt.Errorf("failed to open archive: %s", err)
+		}
+	}
+
 	r := &Router{
-		Config:           cfg,
-		BridgeMap:        bridgeMap,
-		Message:          make(chan config.Message),
-		MattermostPlugin: make(chan config.Message),
-		Gateways:         make(map[string]*Gateway),
-		logger:           logger,
+		Config:            cfg,
+		BridgeMap:         bridgeMap,
+		Message:           make(chan config.Message),
+		MattermostPlugin:  make(chan config.Message),
+		Gateways:          make(map[string]*Gateway),
+		Store:             st,
+		Events:            events.New(),
+		logger:            logger,
+		rootLogger:        rootLogger,
+		retries:           make(map[string]*retry),
+		dropping:          make(map[string]map[string]bool),
+		queued:            make(map[string]int),
+		breakers:          make(map[string]*breaker),
+		msgIDs:            msgIDs,
+		history:           newHistory(),
+		archive:           arch,
+		flush:             make(chan chan struct{}),
+		links:             make(map[string]*link),
+		preparing:         make(map[string][]config.Message),
+		prepared:          make(chan preparedMessage),
+		expiredLinks:      make(chan *link),
+		replays:           make(chan *bridge.Bridge),
+		scriptLimiter:     newScriptLimiter(cfg.BridgeValues().Tengo.RateLimit),
+		logins:            newLogins(),
+		credentialsWarned: make(map[string]time.Time),
+		controls:          newControls(),
+		canaries:          newCanaries(),
+		rateLimits:        newRateLimits(),
 	}
+	r.Events.Subscribe(r.controls.handle, events.BridgeConnected, events.BridgeDisconnected)
+	r.balanced, _ = lru.New(helper.CacheSize(&cfg.BridgeValues().General, 5000))
 	sgw := samechannel.New(cfg)
 	gwconfigs := append(sgw.GetConfig(), cfg.BridgeValues().Gateway...)
 
@@ -59,10 +170,13 @@ func NewRouter(rootLogger *logrus.Logger, cfg config.Config, bridgeMap map[strin
 // between them.
 func (r *Router) Start() error {
 	m := make(map[string]*bridge.Bridge)
-	if len(r.Gateways) == 0 {
+	if len(r.gateways()) == 0 {
 		return fmt.Errorf("no [[gateway]] configured. See https://github.com/42wim/matterbridge/wiki/How-to-create-your-config for more info")
 	}
-	for _, gw := range r.Gateways {
+	r.warnChaos()
+	// before connecting, so the metrics see the bridges connect
+	r.startMetrics()
+	for _, gw := range r.gateways() {
 		r.logger.Infof("Parsing gateway %s", gw.Name)
 		if len(gw.Bridges) == 0 {
 			return fmt.Errorf("no bridges configured for gateway %s. See https://github.com/42wim/matterbridge/wiki/How-to-create-your-config for more info", gw.Name)
@@ -71,9 +185,10 @@ func (r *Router) Start() error {
 			m[br.Account] = br
 		}
 	}
-	for _, br := range m {
+	r.addLoginChannel()
+	for _, br := range r.loginFirst(m) {
 		r.logger.Infof("Starting bridge: %s ", br.Account)
-		err := br.Connect()
+		err := withBridgeLabel(br, br.Connect)
 		if err != nil {
 			e := fmt.Errorf("Bridge %s failed to start: %v", br.Account, err)
 			if r.disableBridge(br, e) {
@@ -81,7 +196,8 @@ func (r *Router) Start() error {
 			}
 			return e
 		}
-		err = br.JoinChannels()
+		r.Events.Publish(events.Event{Kind: events.BridgeConnected, Account: br.Account})
+		err = withBridgeLabel(br, br.JoinChannels)
 		if err != nil {
 			e := fmt.Errorf("Bridge %s failed to join channel: %v", br.Account, err)
 			if r.disableBridge(br, e) {
@@ -91,7 +207,7 @@ func (r *Router) Start() error {
 		}
 	}
 	// remove unused bridges
-	for _, gw := range r.Gateways {
+	for _, gw := range r.gateways() {
 		for i, br := range gw.Bridges {
 			if br.Bridger == nil {
 				r.logger.Errorf("removing failed bridge %s", i)
@@ -99,7 +215,14 @@ func (r *Router) Start() error {
 			}
 		}
 	}
+	r.loadLinks()
+	r.replayQueues()
+	r.backfills()
+	r.startAdmin()
+	go r.selfReport()
+	r.startReceiving()
 	go r.handleReceive()
+	go r.watchCredentials()
 	//go r.updateChannelMembers()
 	return nil
 }
@@ -118,54 +241,226 @@ func (r *Router) disableBridge(br *bridge.Bridge, err error) bool {
 	return false
 }
 
+// gateways returns the gateways by name. The map is replaced, never modified, when a reload
+// or a link changes the gateways, so it can be ranged over while they change.
+func (r *Router) gateways() map[string]*Gateway {
+	r.gatewaysMu.RLock()
+	defer r.gatewaysMu.RUnlock()
+	return r.Gateways
+}
+
+// setGateway adds gw as the gateway name, or removes the gateway name if gw is nil.
+func (r *Router) setGateway(name string, gw *Gateway) {
+	r.gatewaysMu.Lock()
+	defer r.gatewaysMu.Unlock()
+	gateways := make(map[string]*Gateway, len(r.Gateways)+1)
+	for k, v := range r.Gateways {
+		gateways[k] = v
+	}
+	if gw == nil {
+		delete(gateways, name)
+	} else {
+		gateways[name] = gw
+	}
+	r.Gateways = gateways
+}
+
+// goBridge runs f for br in the background, like the backfill of its channels after it
+// reconnects. r.tasks waits for them.
+func (r *Router) goBridge(f func(*bridge.Bridge), br *bridge.Bridge) {
+	r.tasks.Add(1)
+	go func() {
+		defer r.tasks.Done()
+		f(br)
+	}()
+}
+
 func (r *Router) getBridge(account string) *bridge.Bridge {
-	for _, gw := range r.Gateways {
+	for _, gw := range r.gateways() {
 		if br, ok := gw.Bridges[account]; ok {
 			return br
 		}
 	}
+	return r.reloaded[account]
+}
+
+// configureMediaUpload checks the MediaUploadBackend of general, files uploaded to S3 are
+// downloaded from the bucket unless MediaServerDownload is set, eg to a CDN in front of it.
+func configureMediaUpload(general *config.Protocol) error {
+	switch general.MediaUploadBackend {
+	case "":
+	case "s3":
+		if general.MediaS3Bucket == "" {
+			return fmt.Errorf("MediaUploadBackend s3 needs MediaS3Bucket")
+		}
+		if general.MediaServerDownload == "" {
+			general.MediaServerDownload = strings.TrimSuffix(helper.NewS3Client(general).URL(""), "/")
+		}
+	default:
+		return fmt.Errorf("unknown MediaUploadBackend %s", general.MediaUploadBackend)
+	}
 	return nil
 }
 
+// protocols returns the sorted protocols of the bridge map of the router.
+func (r *Router) protocols() []string {
+	protocols := make([]string, 0, len(r.BridgeMap))
+	for protocol := range r.BridgeMap {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+	return protocols
+}
+
 func (r *Router) handleReceive() {
-	for msg := range r.Message {
-		msg := msg // scopelint
+	for {
+		var msg config.Message
+		select {
+		case done := <-r.flush:
+			// the messages before it are handled, or wait for their files
+			if len(r.preparing) > 0 {
+				r.flushing = append(r.flushing, done)
+			} else {
+				close(done)
+			}
+			continue
+		case p := <-r.prepared:
+			r.handlePrepared(p)
+			continue
+		case l := <-r.expiredLinks:
+			r.expireLink(l)
+			continue
+		case br := <-r.replays:
+			r.replayQueue(br)
+			continue
+		case m, ok := <-r.Message:
+			if !ok {
+				return
+			}
+			msg = m
+		}
 		r.handleEventGetChannelMembers(&msg)
 		r.handleEventFailure(&msg)
 		r.handleEventRejoinChannels(&msg)
+		r.handleEventUserVerified(&msg)
+		r.handleEventBridgeStatus(&msg)
+		if r.handleEventReloadConfig(&msg) || r.handleEventBridgeControl(&msg) {
+			continue
+		}
+		if r.handleLoginAnswer(&msg) {
+			continue
+		}
+		if r.handleCanaryEcho(&msg) || r.handleTestCommand(&msg) || r.handleHistoryCommand(&msg) || r.handleIdentityCommand(&msg) ||
+			r.handleOptOutCommand(&msg) || r.handleIgnoreCommand(&msg) {
+			continue
+		}
+		if r.handleLinkCommand(&msg) {
+			continue
+		}
 
 		// Set message protocol based on the account it came from
 		msg.Protocol = r.getBridge(msg.Account).Protocol
+		if r.handleModerationAction(&msg) {
+			continue
+		}
+		if r.isBackfilled(&msg) {
+			continue
+		}
+		r.recordSeen(&msg)
+		r.trackNickChange(&msg)
+		r.publishDownloads(&msg)
+		r.queueMessage(msg)
+	}
+}
 
-		filesHandled := false
-		for _, gw := range r.Gateways {
-			// record all the message ID's of the different bridges
-			var msgIDs []*BrMsgID
-			if gw.ignoreMessage(&msg) {
-				continue
-			}
+// routeMessage sends msg to the gateways of its channel.
+func (r *Router) routeMessage(msg *config.Message) {
+	setCaptions(msg)
+
+	filesHandled := false
+	for _, gw := range r.gateways() {
+		if gw.handleSlowmode(msg) {
+			continue
+		}
+		if gw.handleThreadArchived(msg) {
+			continue
+		}
+		gw.syncBan(msg)
+		gw.renameIdentity(msg)
+		if gw.ignoreMessage(msg) || gw.isBanned(msg) {
+			continue
+		}
+		if gw.handleModeration(msg) || gw.handleSpamNotice(msg) {
+			continue
+		}
+		if gw.collapseQuit(msg) {
+			continue
+		}
+		// keep the original time set by the bridge, used for delayed messages
+		if msg.Timestamp.IsZero() {
 			msg.Timestamp = time.Now()
-			gw.modifyMessage(&msg)
-			if !filesHandled {
-				gw.handleFiles(&msg)
-				filesHandled = true
-			}
-			for _, br := range gw.Bridges {
-				msgIDs = append(msgIDs, gw.handleMessage(&msg, br)...)
-			}
+		}
+		gw.modifyMessage(msg)
+		if !filesHandled {
+			gw.handleFiles(msg)
+			filesHandled = true
+		}
+		if gw.MyConfig.Script != "" {
+			gw.relayScriptMessages(msg)
+			continue
+		}
+		gw.checkAndRelay(msg)
+	}
+}
 
-			if msg.ID != "" {
-				_, exists := gw.Messages.Get(msg.Protocol + " " + msg.ID)
+// checkAndRelay relays msg unless it's held or dropped by the spam filter, verification,
+// quarantine, quota or moderation of the gateway.
+func (gw *Gateway) checkAndRelay(msg *config.Message) {
+	if gw.filterSpam(msg) || gw.verifyMessage(msg) || gw.quarantineMessage(msg) || gw.quotaMessage(msg) || gw.holdMessage(msg) {
+		return
+	}
+	gw.relayMessage(msg)
+}
 
-				// Only add the message ID if it doesn't already exist
-				//
-				// For some bridges we always add/update the message ID.
-				// This is necessary as msgIDs will change if a bridge returns
-				// a different ID in response to edits.
-				if !exists {
-					gw.Messages.Add(msg.Protocol+" "+msg.ID, msgIDs)
-				}
-			}
+// publishDownloads publishes a MediaDownloaded event for the files of msg.
+func (r *Router) publishDownloads(msg *config.Message) {
+	if msg.Extra == nil {
+		return
+	}
+	for _, f := range msg.Extra["file"] {
+		fi, ok := f.(config.FileInfo)
+		if !ok || (fi.Data == nil && fi.Media == nil) {
+			continue
+		}
+		r.Events.Publish(events.Event{Kind: events.MediaDownloaded, Account: msg.Account, Message: msg, File: &fi})
+	}
+}
+
+// relayMessage sends msg to all bridges of the gateway and records the message ID's.
+func (gw *Gateway) relayMessage(msg *config.Message) {
+	// record all the message ID's of the different bridges
+	var msgIDs []*BrMsgID
+	gw.rememberQuote(msg)
+	gw.learnIdentity(msg)
+	gw.collectSummary(msg)
+	for _, br := range gw.Bridges {
+		msgIDs = append(msgIDs, gw.handleMessage(msg, br)...)
+	}
+	gw.checkAlerts(msg, msgIDs)
+	gw.archiveMessage(msg)
+	gw.recordHistory(msg, msgIDs)
+	gw.rememberText(msg)
+
+	if msg.ID != "" {
+		_, exists := gw.getMsgIDs(msg.Protocol + " " + msg.ID)
+
+		// Only add the message ID if it doesn't already exist
+		//
+		// For some bridges we always add/update the message ID.
+		// This is necessary as msgIDs will change if a bridge returns
+		// a different ID in response to edits.
+		if !exists {
+			gw.addMsgIDs(msg.Protocol+" "+msg.ID, msgIDs)
 		}
 	}
 }
@@ -176,7 +471,7 @@ func (r *Router) updateChannelMembers() {
 	// fix this by having actually connectionDone events send to the router
 	time.Sleep(time.Minute)
 	for {
-		for _, gw := range r.Gateways {
+		for _, gw := range r.gateways() {
 			for _, br := range gw.Bridges {
 				// only for slack now
 				if br.Protocol != "slack" {
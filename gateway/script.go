@@ -0,0 +1,122 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// scriptModule is the name scripts import the matterbridge built-ins with.
+	scriptModule = "matterbridge"
+	// scriptBucket is the store bucket of kv_get, kv_set and kv_delete, shared by all scripts.
+	scriptBucket = "scripts"
+
+	defaultScriptRateLimit = 30
+
+	scriptHTTPTimeout = 5 * time.Second
+	scriptHTTPMaxBody = 1024 * 1024
+)
+
+var (
+	errScriptRateLimited = errors.New("rate limited")
+
+	scriptHTTPClient = &http.Client{Timeout: scriptHTTPTimeout}
+)
+
+// newScriptLimiter returns the limiter of http_get and send, allowing limit calls per minute.
+func newScriptLimiter(limit int) *rate.Limiter {
+	if limit <= 0 {
+		limit = defaultScriptRateLimit
+	}
+	return rate.NewLimiter(rate.Every(time.Minute/time.Duration(limit)), limit)
+}
+
+// scriptHTTPGet does a GET request of rawurl for http_get and returns the status and body
+// of the response. Only http and https URLs are allowed and the body is truncated at
+// scriptHTTPMaxBody.
+func (gw *Gateway) scriptHTTPGet(rawurl string) (int, string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return 0, "", fmt.Errorf("invalid url %s", rawurl)
+	}
+	if !gw.Router.scriptLimiter.Allow() {
+		return 0, "", errScriptRateLimited
+	}
+	resp, err := scriptHTTPClient.Get(u.String())
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, scriptHTTPMaxBody))
+	if err != nil {
+		return 0, "", err
+	}
+	return resp.StatusCode, string(body), nil
+}
+
+func (gw *Gateway) scriptBucket() *store.Bucket {
+	return store.NewBucket(gw.Router.Store, scriptBucket)
+}
+
+// scriptSend sends text as the bot to the channels of gateway that receive messages, or only
+// to channel when it's not empty. The message is sent in the background, after the script
+// has finished.
+func (gw *Gateway) scriptSend(gateway, text, channel string) error {
+	dest, ok := gw.Router.gateways()[gateway]
+	if !ok {
+		return fmt.Errorf("unknown gateway %s", gateway)
+	}
+	channels := dest.scriptChannels(channel)
+	if len(channels) == 0 {
+		return fmt.Errorf("no channel to send to on gateway %s", gateway)
+	}
+	if !gw.Router.scriptLimiter.Allow() {
+		return errScriptRateLimited
+	}
+	go dest.sendScriptMessage(channels, text)
+	return nil
+}
+
+// scriptChannels returns the channels messages are relayed to, only those named name
+// when it's not empty.
+func (gw *Gateway) scriptChannels(name string) []*config.ChannelInfo {
+	var channels []*config.ChannelInfo
+	for _, ch := range gw.Channels {
+		if !strings.Contains(ch.Direction, "out") {
+			continue
+		}
+		if name != "" && ch.Name != name {
+			continue
+		}
+		if _, ok := gw.Bridges[ch.Account]; !ok {
+			continue
+		}
+		channels = append(channels, ch)
+	}
+	return channels
+}
+
+func (gw *Gateway) sendScriptMessage(channels []*config.ChannelInfo, text string) {
+	for _, ch := range channels {
+		br := gw.Bridges[ch.Account]
+		_, err := br.Send(config.Message{
+			Text:    text,
+			Channel: ch.Name,
+			Account: ch.Account,
+			Gateway: gw.Name,
+		})
+		if err != nil {
+			gw.logger.Errorf("script: failed to send to %s on %s: %s", ch.Name, ch.Account, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+)
+
+// bridgeLabel is the pprof label with the account of the bridge that started a goroutine.
+const bridgeLabel = "bridge"
+
+// otherGoroutines is the report entry of goroutines that weren't started by a bridge.
+const otherGoroutines = "other"
+
+// withBridgeLabel runs fn with the pprof label of br, goroutines started by fn inherit it
+// so they can be counted per bridge and found in the goroutine profile.
+func withBridgeLabel(br *bridge.Bridge, fn func() error) error {
+	var err error
+	pprof.Do(context.Background(), pprof.Labels(bridgeLabel, br.Account), func(context.Context) {
+		err = fn()
+	})
+	return err
+}
+
+// selfReport is a summary of the memory and goroutines in use.
+type selfReport struct {
+	HeapAlloc  uint64         `json:"heap_alloc"`
+	HeapSys    uint64         `json:"heap_sys"`
+	Goroutines int            `json:"goroutines"`
+	PerBridge  map[string]int `json:"per_bridge"`
+}
+
+func newSelfReport() *selfReport {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return &selfReport{
+		HeapAlloc:  ms.HeapAlloc,
+		HeapSys:    ms.HeapSys,
+		Goroutines: runtime.NumGoroutine(),
+		PerBridge:  goroutinesPerBridge(),
+	}
+}
+
+// goroutinesPerBridge counts the goroutines by bridge label, using the text goroutine profile
+// which has a "# labels:" line after the header of each group of goroutines.
+func goroutinesPerBridge() map[string]int {
+	var buf bytes.Buffer
+	counts := make(map[string]int)
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return counts
+	}
+	var (
+		group   int
+		labeled bool
+		account string
+	)
+	flush := func() {
+		if group == 0 {
+			return
+		}
+		if !labeled {
+			account = otherGoroutines
+		}
+		counts[account] += group
+		group, labeled = 0, false
+	}
+	scanner := bufio.NewScanner(&buf)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "# labels: "):
+			labels := map[string]string{}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "# labels: ")), &labels); err == nil {
+				account, labeled = labels[bridgeLabel]
+			}
+		case strings.Contains(line, " @ "):
+			flush()
+			if n, err := strconv.Atoi(strings.SplitN(line, " ", 2)[0]); err == nil {
+				group = n
+			}
+		}
+	}
+	flush()
+	return counts
+}
+
+func (s *selfReport) String() string {
+	accounts := make([]string, 0, len(s.PerBridge))
+	for account := range s.PerBridge {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	perBridge := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		perBridge = append(perBridge, fmt.Sprintf("%s: %d", account, s.PerBridge[account]))
+	}
+	return fmt.Sprintf("heap %d MB in use (%d MB reserved), %d goroutines (%s)",
+		s.HeapAlloc/1024/1024, s.HeapSys/1024/1024, s.Goroutines, strings.Join(perBridge, ", "))
+}
+
+// selfReport logs a selfReport every SelfReportInterval seconds.
+func (r *Router) selfReport() {
+	interval := r.BridgeValues().General.SelfReportInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.logger.Infof("selfreport: %s", newSelfReport())
+	}
+}
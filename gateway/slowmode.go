@@ -0,0 +1,155 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const (
+	slowmodeQueue = "queue"
+	slowmodeDrop  = "drop"
+
+	// slowmodeMaxQueued is the number of messages of a user that are queued for a channel at
+	// most, newer messages are dropped.
+	slowmodeMaxQueued = 5
+)
+
+var errDroppedSlowmode = errors.New("slowmode")
+
+// slowmodes keeps the slowmode the bridges reported for the channels of the gateway, and
+// when the relayed users can send to them again.
+type slowmodes struct {
+	sync.Mutex
+	delays map[string]time.Duration // keyed by channel ID
+	next   map[string]time.Time     // keyed by channel ID and user
+}
+
+func newSlowmodes() *slowmodes {
+	return &slowmodes{delays: make(map[string]time.Duration), next: make(map[string]time.Time)}
+}
+
+func (gw *Gateway) slowmodeAction() string {
+	return strings.ToLower(gw.MyConfig.Slowmode)
+}
+
+func (gw *Gateway) checkSlowmode() error {
+	switch gw.slowmodeAction() {
+	case "", slowmodeQueue, slowmodeDrop:
+		return nil
+	}
+	return fmt.Errorf("gateway %s: unknown Slowmode %s, use queue or drop", gw.Name, gw.MyConfig.Slowmode)
+}
+
+// handleSlowmode records the slowmode the bridge of msg reported for its channel and tells
+// the other channels when it changes. Returns true for slowmode events, they aren't relayed.
+func (gw *Gateway) handleSlowmode(msg *config.Message) bool {
+	if msg.Event != config.EventSlowmode {
+		return false
+	}
+	channel, ok := gw.Channels[getChannelID(msg)]
+	if gw.slowmodeAction() == "" || !ok {
+		return true
+	}
+	seconds, err := strconv.Atoi(msg.Text)
+	if err != nil || seconds < 0 {
+		gw.logger.Errorf("slowmode: invalid delay %q for %s on %s", msg.Text, msg.Channel, msg.Account)
+		return true
+	}
+	delay := time.Duration(seconds) * time.Second
+
+	gw.slowmodes.Lock()
+	prev, known := gw.slowmodes.delays[channel.ID]
+	gw.slowmodes.delays[channel.ID] = delay
+	gw.slowmodes.Unlock()
+	if prev == delay && (known || delay == 0) {
+		return true
+	}
+	gw.logger.Infof("slowmode: %s on %s is now %s", channel.Name, channel.Account, delay)
+	gw.sendSlowmodeNotice(channel, delay)
+	return true
+}
+
+// sendSlowmodeNotice tells the other channels of the gateway the slowmode of channel.
+func (gw *Gateway) sendSlowmodeNotice(channel *config.ChannelInfo, delay time.Duration) {
+	text := fmt.Sprintf("slowmode is off in %s on %s", channel.Name, channel.Account)
+	if delay > 0 {
+		verb := "queued"
+		if gw.slowmodeAction() == slowmodeDrop {
+			verb = "dropped"
+		}
+		text = fmt.Sprintf("slowmode is on in %s on %s: one message every %s per user, faster messages are %s", channel.Name, channel.Account, delay, verb)
+	}
+	for _, other := range sortedChannels(gw) {
+		br, ok := gw.Bridges[other.Account]
+		if !ok || other.ID == channel.ID || other.Direction == "in" || br.Bridger == nil {
+			continue
+		}
+		notice := config.Message{
+			Text:     text,
+			Channel:  other.Name,
+			Account:  other.Account,
+			Username: "system",
+			Gateway:  gw.Name,
+		}
+		if _, err := gw.Router.send(br, notice); err != nil {
+			gw.logger.Errorf("slowmode: failed to send notice to %s on %s: %s", other.Name, other.Account, err)
+		}
+	}
+}
+
+// slowdown applies the slowmode of channel to msg: the sender of rmsg can send a message to
+// it every slowmode delay. Faster messages are queued, or dropped with Slowmode drop, and
+// true is returned: msg must not be sent now. Queued messages are sent without keeping
+// their ID, so their edits, deletes and replies aren't relayed.
+func (gw *Gateway) slowdown(rmsg, msg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo) bool {
+	action := gw.slowmodeAction()
+	if action == "" || (msg.Event != "" && msg.Event != config.EventUserAction) || msg.ID != "" {
+		return false
+	}
+	now := time.Now()
+	key := channel.ID + " " + userKey(rmsg)
+
+	gw.slowmodes.Lock()
+	delay := gw.slowmodes.delays[channel.ID]
+	if delay <= 0 {
+		gw.slowmodes.Unlock()
+		return false
+	}
+	at := now
+	if next, ok := gw.slowmodes.next[key]; ok && next.After(now) {
+		at = next
+	}
+	wait := at.Sub(now)
+	if wait > 0 && (action == slowmodeDrop || wait >= slowmodeMaxQueued*delay) {
+		gw.slowmodes.Unlock()
+		gw.logger.Debugf("slowmode: dropping message of %s to %s on %s", rmsg.Username, channel.Name, dest.Account)
+		gw.publishDropped(msg, dest, channel, errDroppedSlowmode)
+		return true
+	}
+	for k, next := range gw.slowmodes.next {
+		if !next.After(now) {
+			delete(gw.slowmodes.next, k)
+		}
+	}
+	gw.slowmodes.next[key] = at.Add(delay)
+	gw.slowmodes.Unlock()
+	if wait == 0 {
+		return false
+	}
+
+	gw.logger.Debugf("slowmode: queueing message of %s to %s on %s for %s", rmsg.Username, channel.Name, dest.Account, wait)
+	queued := *msg
+	time.AfterFunc(wait, func() {
+		if _, err := gw.Router.send(dest, queued); err != nil {
+			gw.logger.Errorf("slowmode: failed to send queued message to %s on %s: %s", channel.Name, dest.Account, err)
+		}
+	})
+	return true
+}
@@ -0,0 +1,106 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigSlowmode = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+    slowmode = "queue"
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+`)
+
+func TestSlowmode(t *testing.T) {
+	r := maketestRouter(testconfigSlowmode)
+	gw := r.Gateways["bridge1"]
+	irc := &chanBridger{sent: make(chan config.Message, 10)}
+	discord := &chanBridger{sent: make(chan config.Message, 10)}
+	gw.Bridges["irc.freenode"].Bridger = irc
+	gw.Bridges["discord.test"].Bridger = discord
+
+	// the slowmode of discord is announced on IRC
+	slowmode := &config.Message{Event: config.EventSlowmode, Text: "30", Channel: "general", Account: "discord.test"}
+	assert.True(t, gw.handleSlowmode(slowmode))
+	require.Len(t, irc.sent, 1)
+	assert.Contains(t, (<-irc.sent).Text, "slowmode is on in general on discord.test: one message every 30s per user")
+	assert.True(t, gw.handleSlowmode(slowmode))
+	assert.Empty(t, irc.sent)
+	assert.Empty(t, discord.sent)
+
+	dest := gw.Bridges["discord.test"]
+	channel := gw.Channels["generaldiscord.test"]
+	msg := func(user string) *config.Message {
+		return &config.Message{Text: "hi", Username: user, Channel: "#wimtesting", Account: "irc.freenode"}
+	}
+	assert.False(t, gw.slowdown(msg("alice"), msg("alice"), dest, channel))
+	assert.False(t, gw.slowdown(msg("bob"), msg("bob"), dest, channel))
+
+	// the next messages of alice are queued, until she's slowmodeMaxQueued delays behind
+	gw.slowmodes.Lock()
+	gw.slowmodes.delays[channel.ID] = 50 * time.Millisecond
+	gw.slowmodes.next[channel.ID+" irc.freenode alice"] = time.Now().Add(50 * time.Millisecond)
+	gw.slowmodes.Unlock()
+	for i := 1; i < slowmodeMaxQueued; i++ {
+		assert.True(t, gw.slowdown(msg("alice"), msg("alice"), dest, channel))
+	}
+	gw.slowmodes.Lock()
+	gw.slowmodes.next[channel.ID+" irc.freenode alice"] = time.Now().Add((slowmodeMaxQueued + 1) * 50 * time.Millisecond)
+	gw.slowmodes.Unlock()
+	assert.True(t, gw.slowdown(msg("alice"), msg("alice"), dest, channel))
+	for i := 1; i < slowmodeMaxQueued; i++ {
+		select {
+		case sent := <-discord.sent:
+			assert.Equal(t, "alice", sent.Username)
+		case <-time.After(time.Second):
+			t.Fatal("queued message wasn't sent")
+		}
+	}
+	select {
+	case m := <-discord.sent:
+		t.Fatalf("message over the queue was sent: %s", m.Text)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// messages from discord itself and edits aren't slowed down
+	edit := msg("alice")
+	edit.ID = "1"
+	assert.False(t, gw.slowdown(msg("alice"), edit, dest, channel))
+	assert.False(t, gw.slowdown(msg("alice"), msg("alice"), gw.Bridges["irc.freenode"], gw.Channels["#wimtestingirc.freenode"]))
+
+	// with drop they're dropped
+	gw.MyConfig.Slowmode = "drop"
+	assert.True(t, gw.slowdown(msg("bob"), msg("bob"), dest, channel))
+
+	slowmode.Text = "0"
+	assert.True(t, gw.handleSlowmode(slowmode))
+	require.Len(t, irc.sent, 1)
+	assert.Equal(t, "slowmode is off in general on discord.test", (<-irc.sent).Text)
+	assert.False(t, gw.slowdown(msg("bob"), msg("bob"), dest, channel))
+}
+
+func TestSlowmodeConfig(t *testing.T) {
+	r := maketestRouter(testconfigSlowmode)
+	gw := r.Gateways["bridge1"]
+	assert.NoError(t, gw.checkSlowmode())
+	gw.MyConfig.Slowmode = "throttle"
+	assert.Error(t, gw.checkSlowmode())
+}
@@ -0,0 +1,342 @@
+package gateway
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/bridge/store"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	spamDrop    = "drop"
+	spamReplace = "replace"
+	spamNotify  = "notify"
+
+	defaultSpamReplacement = "[removed]"
+	// spamNotices is the number of recent notices in the notify channel that can be
+	// replied to with ban.
+	spamNotices = 100
+)
+
+// mentionRE matches the @nick mentions and the mentions discord sends as <@id>.
+var mentionRE = regexp.MustCompile(`<@[!&]?\d+>|\B@[\pL\pN_.\-]+`)
+
+// spam keeps the filters of the gateway, for spam and abuse, and the users banned in its
+// config.
+type spam struct {
+	keywords *regexp.Regexp // nil without keywords
+	patterns []*regexp.Regexp
+	domains  []string
+	banned   map[string]bool // by account:userid and account:username
+	notices  *lru.Cache      // the account:user of the sender by the ID of the notice
+}
+
+// addSpam sets up the spam filters of the gateway, if configured. Like the moderation
+// channel the notify channel isn't part of gw.Channels.
+func (gw *Gateway) addSpam() error {
+	cfg := gw.MyConfig.Spam
+	if len(cfg.Patterns) == 0 && len(cfg.Keywords) == 0 && cfg.KeywordsFile == "" && len(cfg.BlockedDomains) == 0 &&
+		cfg.BlockedDomainsFile == "" && cfg.MaxMentions == 0 && len(cfg.Banned) == 0 && cfg.BanList == "" && !cfg.SyncBans {
+		return nil
+	}
+	s, err := newSpam(cfg)
+	if err != nil {
+		return fmt.Errorf("spam filter of gateway %s: %w", gw.Name, err)
+	}
+	switch strings.ToLower(cfg.Action) {
+	case "", spamDrop, spamReplace:
+	case spamNotify:
+		if cfg.Account == "" || cfg.Channel == "" {
+			return fmt.Errorf("spam filter of gateway %s needs an Account and a Channel to notify", gw.Name)
+		}
+		if err := gw.AddBridge(&config.Bridge{Account: cfg.Account, Channel: cfg.Channel}); err != nil {
+			return err
+		}
+		channel := sideChannel(cfg.Account, cfg.Channel)
+		gw.Bridges[cfg.Account].SetChannel(*channel)
+		s.notices, _ = lru.New(spamNotices)
+	default:
+		return fmt.Errorf("spam filter of gateway %s: unknown action %s", gw.Name, cfg.Action)
+	}
+	gw.spam = s
+	return nil
+}
+
+func newSpam(cfg config.Spam) (*spam, error) {
+	s := &spam{banned: make(map[string]bool)}
+	keywords := cfg.Keywords
+	if cfg.KeywordsFile != "" {
+		lines, err := readKeywords(cfg.KeywordsFile)
+		if err != nil {
+			return nil, err
+		}
+		keywords = append(append([]string{}, keywords...), lines...)
+	}
+	if len(keywords) > 0 {
+		re, err := keywordsRE(keywords)
+		if err != nil {
+			return nil, err
+		}
+		s.keywords = re
+	}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		s.patterns = append(s.patterns, re)
+	}
+	s.domains = cfg.BlockedDomains
+	if cfg.BlockedDomainsFile != "" {
+		lines, err := readKeywords(cfg.BlockedDomainsFile)
+		if err != nil {
+			return nil, err
+		}
+		s.domains = append(append([]string{}, s.domains...), lines...)
+	}
+	for _, user := range cfg.Banned {
+		s.banned[user] = true
+	}
+	return s, nil
+}
+
+// match returns why text is spam: "keyword", "pattern", "blocked link" or "mentions", or
+// an empty string when it isn't.
+func (s *spam) match(text string, maxMentions int) string {
+	if s.keywords != nil && s.keywords.MatchString(text) {
+		return "keyword"
+	}
+	for _, re := range s.patterns {
+		if re.MatchString(text) {
+			return "pattern"
+		}
+	}
+	if len(s.domains) > 0 {
+		for _, link := range linkRE.FindAllString(text, -1) {
+			if onDomain(link, s.domains) {
+				return "blocked link"
+			}
+		}
+	}
+	if maxMentions > 0 && len(mentionRE.FindAllStringIndex(text, maxMentions+1)) > maxMentions {
+		return "mentions"
+	}
+	return ""
+}
+
+// replace returns text with the keywords, the matches of the patterns, the links to the
+// blocked domains and the mentions over maxMentions replaced with replacement.
+func (s *spam) replace(text string, maxMentions int, replacement string) string {
+	if s.keywords != nil {
+		text = replaceGroup(s.keywords, text, replacement)
+	}
+	for _, re := range s.patterns {
+		text = re.ReplaceAllLiteralString(text, replacement)
+	}
+	if len(s.domains) > 0 {
+		text = linkRE.ReplaceAllStringFunc(text, func(link string) string {
+			if onDomain(link, s.domains) {
+				return replacement
+			}
+			return link
+		})
+	}
+	if maxMentions > 0 {
+		mentions := 0
+		text = mentionRE.ReplaceAllStringFunc(text, func(mention string) string {
+			if mentions++; mentions > maxMentions {
+				return replacement
+			}
+			return mention
+		})
+	}
+	return text
+}
+
+// replaceGroup replaces the first group of the matches of re in text with replacement.
+func replaceGroup(re *regexp.Regexp, text, replacement string) string {
+	var sb strings.Builder
+	last := 0
+	for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+		sb.WriteString(text[last:m[2]])
+		sb.WriteString(replacement)
+		last = m[3]
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
+
+// filterSpam applies the spam filters of the gateway to msg. Returns true if the message
+// must not be relayed.
+func (gw *Gateway) filterSpam(msg *config.Message) bool {
+	s := gw.spam
+	if s == nil || msg.Text == "" || (msg.Event != "" && msg.Event != config.EventUserAction) {
+		return false
+	}
+	cfg := gw.MyConfig.Spam
+	reason := s.match(msg.Text, cfg.MaxMentions)
+	if reason == "" {
+		return false
+	}
+	switch strings.ToLower(cfg.Action) {
+	case spamReplace:
+		replacement := cfg.Replacement
+		if replacement == "" {
+			replacement = defaultSpamReplacement
+		}
+		gw.logger.Debugf("spam: replacing %s in message from %s (%s)", reason, msg.Username, msg.Account)
+		msg.Text = s.replace(msg.Text, cfg.MaxMentions, replacement)
+		return false
+	case spamNotify:
+		gw.notifySpam(msg, reason)
+	}
+	gw.logger.Infof("spam: not relaying message from %s (%s): %s", msg.Username, msg.Account, reason)
+	return true
+}
+
+// notifySpam posts a notice of the spam msg in the notify channel, a reply ban to it bans
+// the sender.
+func (gw *Gateway) notifySpam(msg *config.Message, reason string) {
+	cfg := gw.MyConfig.Spam
+	dest, ok := gw.Bridges[cfg.Account]
+	if !ok {
+		return
+	}
+	user := banKey(msg)
+	notice := config.Message{
+		Text: fmt.Sprintf("spam (%s) from %s in %s on %s, reply ban to ban %s: %s", reason, msg.Username, msg.Channel,
+			msg.Account, user, helper.ClipMessage(msg.Text, alertTextLength, "...")),
+		Channel:  sideChannel(cfg.Account, cfg.Channel).Name,
+		Account:  cfg.Account,
+		Username: "spam",
+		Gateway:  gw.Name,
+	}
+	id, err := gw.Router.send(dest, notice)
+	if err != nil {
+		gw.logger.Errorf("spam: failed to post notice in %s on %s: %s", cfg.Channel, cfg.Account, err)
+		return
+	}
+	if id != "" {
+		gw.spam.notices.Add(id, user)
+	}
+}
+
+// handleSpamNotice handles the messages from the notify channel, which are never relayed.
+// A moderator bans the sender of the spam with a reply ban to its notice, and unbans them
+// with unban.
+func (gw *Gateway) handleSpamNotice(msg *config.Message) bool {
+	s := gw.spam
+	if s == nil || s.notices == nil || getChannelID(msg) != sideChannel(gw.MyConfig.Spam.Account, gw.MyConfig.Spam.Channel).ID {
+		return false
+	}
+	if msg.ParentID == "" || msg.Event != "" || !gw.isSpamModerator(msg) {
+		return true
+	}
+	v, ok := s.notices.Get(msg.ParentID)
+	if !ok {
+		return true
+	}
+	user := v.(string)
+	switch strings.ToLower(strings.TrimSpace(msg.Text)) {
+	case "ban":
+		gw.ban(user, "banned by "+msg.Username+" on "+msg.Account)
+		gw.Router.reply(msg, "banned "+user)
+	case "unban":
+		if err := gw.banListBucket().Delete(user); err != nil {
+			gw.logger.Errorf("spam: failed to unban %s: %s", user, err)
+			return true
+		}
+		gw.logger.Infof("spam: %s unbanned %s", msg.Username, user)
+		gw.Router.reply(msg, "unbanned "+user)
+	}
+	return true
+}
+
+func (gw *Gateway) isSpamModerator(msg *config.Message) bool {
+	moderators := gw.MyConfig.Spam.Moderators
+	if len(moderators) == 0 {
+		return true
+	}
+	for _, moderator := range moderators {
+		if moderator == msg.Username || moderator == msg.UserID {
+			return true
+		}
+	}
+	return false
+}
+
+// banListBucket returns the ban list of the gateway, which is shared by the gateways with
+// the same BanList.
+func (gw *Gateway) banListBucket() *store.Bucket {
+	name := gw.MyConfig.Spam.BanList
+	if name == "" {
+		name = gw.Name
+	}
+	return store.NewBucket(gw.Router.Store, "banlist:"+name)
+}
+
+// banKey is the sender of msg on the ban list, account:userid or account:username.
+func banKey(msg *config.Message) string {
+	user := msg.UserID
+	if user == "" {
+		user = msg.Username
+	}
+	return msg.Account + ":" + user
+}
+
+func (gw *Gateway) ban(user, reason string) {
+	if err := gw.banListBucket().SetString(user, reason); err != nil {
+		gw.logger.Errorf("spam: failed to ban %s: %s", user, err)
+		return
+	}
+	gw.logger.Infof("spam: %s is on the ban list, %s", user, reason)
+}
+
+// isBanned returns true if the sender of msg is on the ban list of the gateway, the
+// messages of banned users are dropped without notice.
+func (gw *Gateway) isBanned(msg *config.Message) bool {
+	s := gw.spam
+	if s == nil {
+		return false
+	}
+	bucket := gw.banListBucket()
+	for _, user := range []string{msg.UserID, msg.Username} {
+		if user == "" {
+			continue
+		}
+		if key := msg.Account + ":" + user; s.banned[key] || bucket.Contains(key) {
+			gw.logger.Debugf("spam: ignoring message from %s on %s, %s is banned", msg.Username, msg.Account, key)
+			return true
+		}
+	}
+	return false
+}
+
+// syncBan adds the user banned by the ban event msg to the ban list, with SyncBans. The
+// bridges set the banned user in config.ExtraBanned, or send a config.EventUserBan.
+func (gw *Gateway) syncBan(msg *config.Message) {
+	if gw.spam == nil || !gw.MyConfig.Spam.SyncBans {
+		return
+	}
+	if _, ok := gw.Bridges[msg.Account]; !ok {
+		return
+	}
+	var users []interface{}
+	switch msg.Event {
+	case config.EventBan:
+		if msg.Extra != nil {
+			users = msg.Extra[config.ExtraBanned]
+		}
+	case config.EventUserBan:
+		users = []interface{}{msg.UserID, msg.Username}
+	}
+	for _, v := range users {
+		if user, ok := v.(string); ok && user != "" {
+			gw.ban(msg.Account+":"+user, "banned on "+msg.Account)
+		}
+	}
+}
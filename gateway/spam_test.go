@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigSpam = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [gateway.spam]
+    keywords = ["free nitro"]
+    patterns = ["(?i)crypto\\s+giveaway"]
+    blockeddomains = ["spam.example"]
+    maxmentions = 2
+    action = "notify"
+    account = "slack.test"
+    channel = "spam"
+    moderators = ["mod"]
+    banlist = "shared"
+    syncbans = true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+
+[[gateway]]
+    name = "bridge2"
+    enable=true
+
+    [gateway.spam]
+    keywords = ["free nitro"]
+    action = "replace"
+    banlist = "shared"
+    banned = ["irc.freenode:troll"]
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#other"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "other"
+	`)
+
+func TestSpamFilter(t *testing.T) {
+	s, err := newSpam(config.Spam{
+		Keywords: []string{"free nitro"}, Patterns: []string{`bit\.ly/\w+`}, BlockedDomains: []string{"spam.example"},
+	})
+	assert.NoError(t, err)
+	for text, reason := range map[string]string{
+		"get FREE NITRO now":          "keyword",
+		"see bit.ly/abc":              "pattern",
+		"https://www.spam.example/x":  "blocked link",
+		"@a @b @c look":               "mentions",
+		"<@123> <@!456> <@&789> look": "mentions",
+		"freenitro.com is a word":     "",
+		"@a @b and mail@example.com":  "",
+		"https://example.com/spam":    "",
+	} {
+		assert.Equal(t, reason, s.match(text, 2), text)
+	}
+	assert.Equal(t, "get [removed] now, see [removed] at [removed] @a @b [removed]",
+		s.replace("get free nitro now, see bit.ly/abc at https://spam.example/x @a @b @c", 2, "[removed]"))
+}
+
+func TestSpam(t *testing.T) {
+	r := maketestRouter(testconfigSpam)
+	gw1, gw2 := r.Gateways["bridge1"], r.Gateways["bridge2"]
+	assert.Contains(t, gw1.Bridges["slack.test"].Channels, "spamslack.test")
+	recorders := make(map[string]*recordBridger)
+	for account, br := range gw1.Bridges {
+		recorders[account] = &recordBridger{}
+		br.Bridger = recorders[account]
+	}
+
+	// with action notify the spam is dropped and a notice is posted
+	msg := &config.Message{Text: "free nitro at https://spam.example", Username: "spammer", UserID: "spammer@host", Channel: "#wimtesting", Account: "irc.freenode"}
+	assert.True(t, gw1.filterSpam(msg))
+	assert.Len(t, recorders["slack.test"].sent, 1)
+	notice := recorders["slack.test"].sent[0]
+	assert.Equal(t, "spam", notice.Channel)
+	assert.Contains(t, notice.Text, "irc.freenode:spammer@host")
+	assert.False(t, gw1.filterSpam(&config.Message{Text: "hi", Username: "wim", Channel: "#wimtesting", Account: "irc.freenode"}))
+
+	// with action replace the spam is relayed without it
+	msg = &config.Message{Text: "FREE NITRO!", Username: "spammer", Channel: "#other", Account: "irc.freenode"}
+	assert.False(t, gw2.filterSpam(msg))
+	assert.Equal(t, "[removed]!", msg.Text)
+
+	// only moderators ban with a reply to the notice, and the ban list is shared
+	sender := &config.Message{Text: "hi", Username: "spammer", UserID: "spammer@host", Channel: "#other", Account: "irc.freenode"}
+	reply := &config.Message{Text: "ban", Username: "someone", ParentID: "1", Channel: "spam", Account: "slack.test"}
+	assert.True(t, gw1.handleSpamNotice(reply))
+	assert.False(t, gw2.isBanned(sender))
+	reply.Username = "mod"
+	assert.True(t, gw1.handleSpamNotice(reply))
+	assert.True(t, gw1.isBanned(sender))
+	assert.True(t, gw2.isBanned(sender))
+	assert.Equal(t, "banned irc.freenode:spammer@host", recorders["slack.test"].sent[1].Text)
+
+	reply.Text = "unban"
+	assert.True(t, gw1.handleSpamNotice(reply))
+	assert.False(t, gw2.isBanned(sender))
+
+	// messages from other channels aren't notify channel messages
+	assert.False(t, gw1.handleSpamNotice(&config.Message{Text: "ban", ParentID: "1", Channel: "general", Account: "discord.test"}))
+
+	// the banned users of the config are only banned on their gateway
+	troll := &config.Message{Text: "hi", Username: "troll", Channel: "#other", Account: "irc.freenode"}
+	assert.True(t, gw2.isBanned(troll))
+	assert.False(t, gw1.isBanned(troll))
+
+	// with SyncBans the users banned on a bridge are banned on all gateways of the ban list
+	gw1.syncBan(&config.Message{
+		Event: config.EventBan, Username: "system", Text: "bob is banned", Account: "discord.test",
+		Extra: map[string][]interface{}{config.ExtraBanned: {"1234", "bob"}},
+	})
+	assert.True(t, gw2.isBanned(&config.Message{Text: "hi", Username: "bobby", UserID: "1234", Channel: "other", Account: "discord.test"}))
+	assert.False(t, gw2.isBanned(&config.Message{Text: "hi", Username: "bob", Channel: "#other", Account: "irc.freenode"}))
+	gw2.syncBan(&config.Message{
+		Event: config.EventBan, Username: "system", Account: "irc.freenode",
+		Extra: map[string][]interface{}{config.ExtraBanned: {"eve"}},
+	})
+	assert.False(t, gw1.isBanned(&config.Message{Text: "hi", Username: "eve", Channel: "#wimtesting", Account: "irc.freenode"}))
+}
@@ -0,0 +1,184 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const (
+	defaultSummaryInterval    = time.Hour
+	defaultSummaryMinMessages = 10
+	defaultSummaryPrompt      = "Summarize this chat conversation in a few sentences."
+
+	// summaryMaxLines is the number of messages a summary is made of at most, the oldest
+	// messages are dropped.
+	summaryMaxLines = 500
+	summaryTimeout  = 2 * time.Minute
+)
+
+// defaultSummaryRedact are the redaction rules of the summaries when Redact isn't set, no
+// message leaves matterbridge without redaction.
+var defaultSummaryRedact = []string{"email", "phone", "ip", "creditcard"}
+
+// summarizer keeps the redacted messages of the gateway for the next summary.
+type summarizer struct {
+	sync.Mutex
+	lines  []string
+	timer  *time.Timer
+	client *http.Client
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model    string        `json:"model,omitempty"`
+	Messages []chatMessage `json:"messages"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+// addSummarizer sets up the summaries of the gateway, if a Summary URL is configured. Like
+// the moderation channel the summary channel isn't part of gw.Channels.
+func (gw *Gateway) addSummarizer() error {
+	cfg := gw.MyConfig.Summary
+	if cfg.URL == "" {
+		return nil
+	}
+	if cfg.Account == "" || cfg.Channel == "" {
+		return fmt.Errorf("summary of gateway %s needs an Account and a Channel", gw.Name)
+	}
+	if err := gw.AddBridge(&config.Bridge{Account: cfg.Account, Channel: cfg.Channel}); err != nil {
+		return err
+	}
+	channel := sideChannel(cfg.Account, cfg.Channel)
+	gw.Bridges[cfg.Account].SetChannel(*channel)
+	gw.summarizer = &summarizer{client: &http.Client{Timeout: summaryTimeout}}
+	return nil
+}
+
+func (gw *Gateway) summaryInterval() time.Duration {
+	if interval := gw.MyConfig.Summary.Interval; interval > 0 {
+		return time.Duration(interval) * time.Minute
+	}
+	return defaultSummaryInterval
+}
+
+// collectSummary adds the redacted text of msg to the next summary. The first message
+// after a summary starts the interval of the next one.
+func (gw *Gateway) collectSummary(msg *config.Message) {
+	s := gw.summarizer
+	if s == nil || msg.Text == "" || (msg.Event != "" && msg.Event != config.EventUserAction) || gw.isEdit(msg) {
+		return
+	}
+	cfg := gw.MyConfig.Summary
+	rules := cfg.Redact
+	if len(rules) == 0 {
+		rules = defaultSummaryRedact
+	}
+	line := gw.redact(msg.Username+": "+msg.Text, rules, cfg.RedactReplacement)
+
+	s.Lock()
+	defer s.Unlock()
+	s.lines = append(s.lines, line)
+	if len(s.lines) > summaryMaxLines {
+		s.lines = s.lines[len(s.lines)-summaryMaxLines:]
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(gw.summaryInterval(), func() { gw.postSummary(s) })
+	}
+}
+
+// postSummary posts the summary of the messages of s. With fewer than MinMessages the
+// messages are kept for the next summary.
+func (gw *Gateway) postSummary(s *summarizer) {
+	cfg := gw.MyConfig.Summary
+	minMessages := cfg.MinMessages
+	if minMessages <= 0 {
+		minMessages = defaultSummaryMinMessages
+	}
+	s.Lock()
+	s.timer = nil
+	lines := s.lines
+	if len(lines) < minMessages {
+		s.Unlock()
+		return
+	}
+	s.lines = nil
+	s.Unlock()
+
+	text, err := s.summarize(cfg, lines)
+	if err != nil {
+		gw.logger.Errorf("summary: failed to summarize %d messages: %s", len(lines), err)
+		return
+	}
+	dest, ok := gw.Bridges[cfg.Account]
+	if !ok {
+		return
+	}
+	msg := config.Message{
+		Text:     text,
+		Channel:  sideChannel(cfg.Account, cfg.Channel).Name,
+		Account:  cfg.Account,
+		Username: "summary",
+		Gateway:  gw.Name,
+	}
+	if _, err := gw.Router.send(dest, msg); err != nil {
+		gw.logger.Errorf("summary: failed to post summary in %s on %s: %s", cfg.Channel, cfg.Account, err)
+	}
+}
+
+// summarize returns the summary of lines made by the chat completions endpoint cfg.URL.
+func (s *summarizer) summarize(cfg config.Summary, lines []string) (string, error) {
+	prompt := cfg.Prompt
+	if prompt == "" {
+		prompt = defaultSummaryPrompt
+	}
+	body, err := json.Marshal(chatRequest{
+		Model: cfg.Model,
+		Messages: []chatMessage{
+			{Role: "system", Content: prompt},
+			{Role: "user", Content: strings.Join(lines, "\n")},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+	var res chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	if len(res.Choices) == 0 || res.Choices[0].Message.Content == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+	return strings.TrimSpace(res.Choices[0].Message.Content), nil
+}
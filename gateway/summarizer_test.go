@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizer(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		got chatRequest
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+		mu.Lock()
+		defer mu.Unlock()
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&got))
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": " People said hi. "}}]}`)) //nolint:errcheck
+	}))
+	defer srv.Close()
+
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+	gw.MyConfig.Summary = config.Summary{URL: srv.URL, Token: "secret", Model: "test", MinMessages: 2, Account: "slack.test", Channel: "summaries"}
+	require.NoError(t, gw.addSummarizer())
+	rec := &recordBridger{}
+	gw.Bridges["slack.test"].Bridger = rec
+
+	gw.collectSummary(&config.Message{Text: "hi, mail me at wim@example.com", Username: "wim"})
+	gw.collectSummary(&config.Message{Text: "", Username: "wim", Event: config.EventJoinLeave})
+	// too few messages are kept for the next summary
+	gw.postSummary(gw.summarizer)
+	assert.Empty(t, rec.sent)
+
+	gw.collectSummary(&config.Message{Text: "hello", Username: "alice"})
+	gw.summarizer.timer.Stop()
+	gw.postSummary(gw.summarizer)
+	require.Len(t, rec.sent, 1)
+	assert.Equal(t, "People said hi.", rec.sent[0].Text)
+	assert.Equal(t, "summaries", rec.sent[0].Channel)
+	assert.Empty(t, gw.summarizer.lines)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, "test", got.Model)
+	require.Len(t, got.Messages, 2)
+	assert.Equal(t, defaultSummaryPrompt, got.Messages[0].Content)
+	assert.Equal(t, "wim: hi, mail me at [redacted]\nalice: hello", got.Messages[1].Content)
+	assert.False(t, strings.Contains(got.Messages[1].Content, "example.com"))
+}
@@ -0,0 +1,137 @@
+package gateway
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const (
+	summarySample = "sample"
+	summaryDigest = "digest"
+
+	summaryWindow = time.Minute
+	// summaryMaxEvery is the largest 1 in N messages relayed in summary mode.
+	summaryMaxEvery = 1024
+
+	defaultSummaryNotice = "This channel is busy, only some of its messages are relayed here."
+)
+
+var errDroppedSummary = errors.New("summary mode")
+
+// summaries keeps the traffic of the channels with a SummaryThreshold, keyed by channel ID.
+type summaries struct {
+	sync.Mutex
+	channels map[string]*summary
+}
+
+// summary is the traffic of a channel, counted in windows of a minute.
+type summary struct {
+	start     time.Time
+	count     int
+	prevCount int
+	active    bool
+	seen      int
+}
+
+// rate returns the number of messages a minute, counting a message at now.
+func (s *summary) rate(now time.Time) int {
+	if elapsed := now.Sub(s.start); elapsed >= summaryWindow {
+		s.prevCount = s.count
+		if elapsed >= 2*summaryWindow {
+			s.prevCount = 0
+		}
+		s.start, s.count = now, 0
+	}
+	s.count++
+	if s.prevCount > s.count {
+		return s.prevCount
+	}
+	return s.count
+}
+
+// summaryEvery returns N for relaying 1 in N messages at rate messages a minute: 2 up to
+// twice the threshold, 4 up to four times the threshold and so on.
+func summaryEvery(rate, threshold int) int {
+	every := 2
+	for rate > threshold*every && every < summaryMaxEvery {
+		every *= 2
+	}
+	return every
+}
+
+// summarize returns true when msg must not be sent to channel because it's in summary mode.
+// Above SummaryThreshold messages a minute only 1 in N messages is relayed, N doubles each
+// time the rate doubles, with SummaryMode digest only their first line. A SummaryNotice is
+// sent when the summary mode starts.
+func (gw *Gateway) summarize(rmsg, msg *config.Message, dest *bridge.Bridge, channel *config.ChannelInfo) bool {
+	opts := channel.Options
+	if opts.SummaryThreshold <= 0 || (msg.Event != "" && msg.Event != config.EventUserAction) {
+		return false
+	}
+	// edits of messages that weren't relayed
+	if rmsg.ID != "" && msg.ID == "" && gw.isEdit(rmsg) {
+		return true
+	}
+	if msg.ID != "" {
+		return false
+	}
+
+	gw.summaries.Lock()
+	s, ok := gw.summaries.channels[channel.ID]
+	if !ok {
+		s = &summary{}
+		gw.summaries.channels[channel.ID] = s
+	}
+	rate := s.rate(time.Now())
+	if rate <= opts.SummaryThreshold {
+		if s.active {
+			gw.logger.Infof("summary: %s on %s relays all messages again", channel.Name, dest.Account)
+		}
+		s.active = false
+		gw.summaries.Unlock()
+		return false
+	}
+	started := !s.active
+	if started {
+		s.active, s.seen = true, 0
+	}
+	skip := s.seen%summaryEvery(rate, opts.SummaryThreshold) != 0
+	s.seen++
+	gw.summaries.Unlock()
+
+	if started {
+		gw.logger.Infof("summary: %s on %s gets %d messages a minute, relaying only some of them", channel.Name, dest.Account, rate)
+		gw.sendSummaryNotice(dest, channel)
+	}
+	if skip {
+		return true
+	}
+	if opts.SummaryMode == summaryDigest {
+		if i := strings.IndexByte(msg.Text, '\n'); i >= 0 {
+			msg.Text = msg.Text[:i] + " [...]"
+		}
+	}
+	return false
+}
+
+func (gw *Gateway) sendSummaryNotice(dest *bridge.Bridge, channel *config.ChannelInfo) {
+	text := channel.Options.SummaryNotice
+	if text == "" {
+		text = defaultSummaryNotice
+	}
+	notice := config.Message{
+		Text:     text,
+		Channel:  channel.Name,
+		Account:  dest.Account,
+		Username: "system",
+		Gateway:  gw.Name,
+	}
+	if _, err := gw.Router.send(dest, notice); err != nil {
+		gw.logger.Errorf("summary: failed to send notice to %s on %s: %s", channel.Name, dest.Account, err)
+	}
+}
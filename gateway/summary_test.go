@@ -0,0 +1,55 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummaryEvery(t *testing.T) {
+	assert.Equal(t, 2, summaryEvery(11, 10))
+	assert.Equal(t, 2, summaryEvery(20, 10))
+	assert.Equal(t, 4, summaryEvery(21, 10))
+	assert.Equal(t, 8, summaryEvery(80, 10))
+	assert.Equal(t, summaryMaxEvery, summaryEvery(1000000, 1))
+}
+
+func TestSummaryRate(t *testing.T) {
+	now := time.Now()
+	s := &summary{start: now}
+	for i := 0; i < 5; i++ {
+		s.rate(now)
+	}
+	// the previous minute counts until the current one is busier
+	assert.Equal(t, 5, s.rate(now.Add(summaryWindow)))
+	assert.Equal(t, 1, s.rate(now.Add(4*summaryWindow)))
+}
+
+func TestSummarize(t *testing.T) {
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+	rec := &recordBridger{}
+	dest := gw.Bridges["slack.test"]
+	dest.Bridger = rec
+	channel := gw.Channels["testingslack.test"]
+	channel.Options.SummaryThreshold = 2
+	channel.Options.SummaryMode = summaryDigest
+
+	for i := 0; i < 8; i++ {
+		msg := &config.Message{Text: "line\nmore", Username: "user", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc"}
+		_, err := gw.SendMessage(msg, dest, channel, "")
+		require.NoError(t, err)
+	}
+	var texts []string
+	for _, msg := range rec.sent {
+		texts = append(texts, msg.Text)
+	}
+	// 2 messages, the notice, then 1 in 2 and 1 in 4 messages above 4 a minute
+	require.Len(t, texts, 5)
+	assert.Equal(t, defaultSummaryNotice, texts[2])
+	assert.Equal(t, "line [...]", texts[3])
+	assert.Equal(t, "line [...]", texts[4])
+}
@@ -0,0 +1,129 @@
+package gateway
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/d5/tengo/v2"
+	"github.com/d5/tengo/v2/stdlib"
+)
+
+// tengoImports returns the modules available to the scripts of the gateway: the tengo
+// standard library and the matterbridge built-ins.
+func (gw *Gateway) tengoImports() *tengo.ModuleMap {
+	modules := stdlib.GetModuleMap(stdlib.AllModuleNames()...)
+	if gw.Router == nil {
+		return modules
+	}
+	modules.AddBuiltinModule(scriptModule, map[string]tengo.Object{
+		"http_get":  &tengo.UserFunction{Name: "http_get", Value: gw.tengoHTTPGet},
+		"kv_get":    &tengo.UserFunction{Name: "kv_get", Value: gw.tengoKVGet},
+		"kv_set":    &tengo.UserFunction{Name: "kv_set", Value: gw.tengoKVSet},
+		"kv_delete": &tengo.UserFunction{Name: "kv_delete", Value: gw.tengoKVDelete},
+		"send":      &tengo.UserFunction{Name: "send", Value: gw.tengoSend},
+	})
+	return modules
+}
+
+func tengoError(format string, args ...interface{}) tengo.Object {
+	return &tengo.Error{Value: &tengo.String{Value: fmt.Sprintf(format, args...)}}
+}
+
+// tengoStringArgs returns args as strings, between min and max of them.
+func tengoStringArgs(args []tengo.Object, min, max int) ([]string, error) {
+	if len(args) < min || len(args) > max {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	res := make([]string, len(args))
+	for i, arg := range args {
+		s, ok := tengo.ToString(arg)
+		if !ok {
+			return nil, tengo.ErrInvalidArgumentType{
+				Name:     fmt.Sprintf("argument %d", i+1),
+				Expected: "string",
+				Found:    arg.TypeName(),
+			}
+		}
+		res[i] = s
+	}
+	return res, nil
+}
+
+// tengoHTTPGet implements http_get(url), returning a map with the status and body of the response.
+func (gw *Gateway) tengoHTTPGet(args ...tengo.Object) (tengo.Object, error) {
+	s, err := tengoStringArgs(args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	status, body, err := gw.scriptHTTPGet(s[0])
+	if err != nil {
+		return tengoError("http_get: %s", err), nil
+	}
+	return &tengo.ImmutableMap{Value: map[string]tengo.Object{
+		"status": &tengo.Int{Value: int64(status)},
+		"body":   &tengo.String{Value: body},
+	}}, nil
+}
+
+// tengoKVGet implements kv_get(key), returning undefined when key isn't set.
+func (gw *Gateway) tengoKVGet(args ...tengo.Object) (tengo.Object, error) {
+	s, err := tengoStringArgs(args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := gw.scriptBucket().GetString(s[0])
+	if !ok {
+		return tengo.UndefinedValue, nil
+	}
+	return &tengo.String{Value: v}, nil
+}
+
+// tengoKVSet implements kv_set(key, value[, ttl]), the ttl is in seconds.
+func (gw *Gateway) tengoKVSet(args ...tengo.Object) (tengo.Object, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, tengo.ErrWrongNumArguments
+	}
+	s, err := tengoStringArgs(args[:2], 2, 2)
+	if err != nil {
+		return nil, err
+	}
+	var ttl int64
+	if len(args) == 3 {
+		var ok bool
+		if ttl, ok = tengo.ToInt64(args[2]); !ok {
+			return nil, tengo.ErrInvalidArgumentType{Name: "ttl", Expected: "int", Found: args[2].TypeName()}
+		}
+	}
+	if err := gw.scriptBucket().SetStringTTL(s[0], s[1], time.Duration(ttl)*time.Second); err != nil {
+		return tengoError("kv_set: %s", err), nil
+	}
+	return tengo.TrueValue, nil
+}
+
+// tengoKVDelete implements kv_delete(key).
+func (gw *Gateway) tengoKVDelete(args ...tengo.Object) (tengo.Object, error) {
+	s, err := tengoStringArgs(args, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := gw.scriptBucket().Delete(s[0]); err != nil {
+		return tengoError("kv_delete: %s", err), nil
+	}
+	return tengo.TrueValue, nil
+}
+
+// tengoSend implements send(gateway, text[, channel]).
+func (gw *Gateway) tengoSend(args ...tengo.Object) (tengo.Object, error) {
+	s, err := tengoStringArgs(args, 2, 3)
+	if err != nil {
+		return nil, err
+	}
+	var channel string
+	if len(s) == 3 {
+		channel = s[2]
+	}
+	if err := gw.scriptSend(s[0], s[1], channel); err != nil {
+		return tengoError("send: %s", err), nil
+	}
+	return tengo.TrueValue, nil
+}
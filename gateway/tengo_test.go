@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chanBridger is a Bridger that passes the messages sent to it to a channel.
+type chanBridger struct {
+	sent chan config.Message
+}
+
+func (c *chanBridger) Send(msg config.Message) (string, error) {
+	c.sent <- msg
+	return "1", nil
+}
+
+func (c *chanBridger) Connect() error                               { return nil }
+func (c *chanBridger) JoinChannel(channel config.ChannelInfo) error { return nil }
+func (c *chanBridger) Disconnect() error                            { return nil }
+func (c *chanBridger) Reload(cfg config.Config) error               { return nil }
+
+func runTengo(t *testing.T, gw *Gateway, script string, msg *config.Message) {
+	filename := filepath.Join(t.TempDir(), "test.tengo")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(script), 0o600))
+	require.NoError(t, modifyInMessageTengo(filename, msg, gw.tengoImports()))
+}
+
+func TestTengoKV(t *testing.T) {
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+
+	karma := `mb := import("matterbridge")
+text := import("text")
+count := mb.kv_get("karma")
+if is_undefined(count) { count = "0" }
+count = text.itoa(text.atoi(count) + 1)
+mb.kv_set("karma", count)
+msgText = "karma " + count
+`
+	msg := &config.Message{Text: "++", Account: "irc.freenode", Channel: "#wimtesting"}
+	runTengo(t, gw, karma, msg)
+	assert.Equal(t, "karma 1", msg.Text)
+	runTengo(t, gw, karma, msg)
+	assert.Equal(t, "karma 2", msg.Text)
+
+	v, ok := gw.scriptBucket().GetString("karma")
+	assert.True(t, ok)
+	assert.Equal(t, "2", v)
+
+	runTengo(t, gw, `mb := import("matterbridge"); mb.kv_delete("karma")`, msg)
+	assert.False(t, gw.scriptBucket().Contains("karma"))
+}
+
+func TestTengoHTTPGet(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "archived")
+	}))
+	defer ts.Close()
+
+	r := maketestRouter(testconfig)
+	r.scriptLimiter = newScriptLimiter(1)
+	gw := r.Gateways["bridge1"]
+
+	script := fmt.Sprintf(`mb := import("matterbridge")
+res := mb.http_get(%q)
+if is_error(res) {
+	msgText = "error: " + res.value
+} else {
+	msgText = string(res.status) + " " + res.body
+}
+`, ts.URL)
+	msg := &config.Message{}
+	runTengo(t, gw, script, msg)
+	assert.Equal(t, "200 archived", msg.Text)
+
+	// only 1 call per minute is allowed
+	runTengo(t, gw, script, msg)
+	assert.Equal(t, "error: http_get: rate limited", msg.Text)
+
+	runTengo(t, gw, `mb := import("matterbridge"); res := mb.http_get("file:///etc/passwd"); msgText = res.value`, msg)
+	assert.Equal(t, "http_get: invalid url file:///etc/passwd", msg.Text)
+}
+
+func TestTengoSend(t *testing.T) {
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+	sent := make(chan config.Message, 10)
+	for _, br := range gw.Bridges {
+		br.Bridger = &chanBridger{sent: sent}
+	}
+
+	msg := &config.Message{}
+	runTengo(t, gw, `mb := import("matterbridge"); mb.send("bridge1", "hello", "general")`, msg)
+	select {
+	case m := <-sent:
+		assert.Equal(t, "hello", m.Text)
+		assert.Equal(t, "general", m.Channel)
+		assert.Equal(t, "discord.test", m.Account)
+	case <-time.After(time.Second):
+		t.Fatal("message not sent")
+	}
+
+	runTengo(t, gw, `mb := import("matterbridge"); mb.send("bridge1", "to all")`, msg)
+	for i := 0; i < 3; i++ {
+		select {
+		case m := <-sent:
+			assert.Equal(t, "to all", m.Text)
+		case <-time.After(time.Second):
+			t.Fatal("message not sent")
+		}
+	}
+
+	runTengo(t, gw, `mb := import("matterbridge"); msgText = mb.send("nope", "hello").value`, msg)
+	assert.Equal(t, "send: unknown gateway nope", msg.Text)
+}
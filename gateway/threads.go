@@ -0,0 +1,46 @@
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+// handleThreadArchived posts a notice in the counterparts of the thread the bridge of msg
+// reported as archived. Replying there reopens the thread, the bridges unarchive or start
+// it again when they get a message for it. Returns true for thread_archived events, they
+// aren't relayed.
+func (gw *Gateway) handleThreadArchived(msg *config.Message) bool {
+	if msg.Event != config.EventThreadArchived {
+		return false
+	}
+	channel, ok := gw.Channels[getChannelID(msg)]
+	if !ok || msg.ThreadID == "" {
+		return true
+	}
+	gw.logger.Debugf("thread %s in %s on %s was archived", msg.ThreadID, channel.Name, channel.Account)
+	text := fmt.Sprintf("this thread was archived in %s on %s, replying here reopens it", channel.Name, channel.Account)
+	for _, other := range sortedChannels(gw) {
+		br, ok := gw.Bridges[other.Account]
+		if !ok || other.ID == channel.ID || other.Direction == "in" || br.Bridger == nil {
+			continue
+		}
+		// only the channels that have the thread get the notice
+		threadID := gw.destThreadID(msg, br, other)
+		if threadID == "" {
+			continue
+		}
+		notice := config.Message{
+			Text:     text,
+			Channel:  other.Name,
+			Account:  other.Account,
+			Username: "system",
+			Gateway:  gw.Name,
+			ThreadID: threadID,
+		}
+		if _, err := gw.Router.send(br, notice); err != nil {
+			gw.logger.Errorf("failed to send the archived thread notice to %s on %s: %s", other.Name, other.Account, err)
+		}
+	}
+	return true
+}
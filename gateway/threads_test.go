@@ -0,0 +1,42 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleThreadArchived(t *testing.T) {
+	r := maketestRouter(append([]byte("[general]\nPreserveThreading=true\n"), testconfig...))
+	gw := r.Gateways["bridge1"]
+	irc := &chanBridger{sent: make(chan config.Message, 10)}
+	discord := &chanBridger{sent: make(chan config.Message, 10)}
+	slack := &chanBridger{sent: make(chan config.Message, 10)}
+	gw.Bridges["irc.freenode"].Bridger = irc
+	gw.Bridges["discord.test"].Bridger = discord
+	gw.Bridges["slack.test"].Bridger = slack
+
+	// the root of the thread was sent on slack and relayed to discord only
+	gw.addMsgIDs("slack 1.1", []*BrMsgID{{gw.Bridges["discord.test"], "discord 10", "generaldiscord.test"}})
+
+	archived := &config.Message{Event: config.EventThreadArchived, ThreadID: "10", Channel: "general", Account: "discord.test", Protocol: "discord"}
+	assert.True(t, gw.handleThreadArchived(archived))
+	require.Len(t, slack.sent, 1)
+	notice := <-slack.sent
+	assert.Equal(t, "1.1", notice.ThreadID)
+	assert.Equal(t, "testing", notice.Channel)
+	assert.Equal(t, "this thread was archived in general on discord.test, replying here reopens it", notice.Text)
+	// irc doesn't have the thread
+	assert.Empty(t, irc.sent)
+	assert.Empty(t, discord.sent)
+
+	// unknown threads don't get a notice
+	archived.ThreadID = "20"
+	assert.True(t, gw.handleThreadArchived(archived))
+	assert.Empty(t, slack.sent)
+	assert.Empty(t, irc.sent)
+
+	assert.False(t, gw.handleThreadArchived(&config.Message{Text: "hi", Channel: "general", Account: "discord.test"}))
+}
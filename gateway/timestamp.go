@@ -0,0 +1,51 @@
+package gateway
+
+import (
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+)
+
+const defaultTimestampFormat = "2006-01-02 15:04 MST"
+
+// formatTimestamp returns the original time of msg formatted with the TimestampFormat
+// and TimestampTimezone settings of dest.
+func (gw *Gateway) formatTimestamp(msg *config.Message, dest *bridge.Bridge) string {
+	if msg.Timestamp.IsZero() {
+		return ""
+	}
+	ts := msg.Timestamp
+	if tz := dest.GetString("TimestampTimezone"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			gw.logger.Errorf("invalid TimestampTimezone %s for %s: %s", tz, dest.Account, err)
+		} else {
+			ts = ts.In(loc)
+		}
+	}
+	format := dest.GetString("TimestampFormat")
+	if format == "" {
+		format = defaultTimestampFormat
+	}
+	return ts.Format(format)
+}
+
+// isDelayed returns true if msg is relayed to dest more than TimestampDelay seconds
+// after it was sent, eg when it was queued while a bridge was offline or is backfilled history.
+func isDelayed(msg *config.Message, dest *bridge.Bridge) bool {
+	delay := dest.GetInt("TimestampDelay")
+	if delay <= 0 || msg.Timestamp.IsZero() {
+		return false
+	}
+	return time.Since(msg.Timestamp) > time.Duration(delay)*time.Second
+}
+
+// addDelayedTimestamp prefixes the text of msg with the original time of rmsg
+// when it's delayed.
+func (gw *Gateway) addDelayedTimestamp(rmsg, msg *config.Message, dest *bridge.Bridge) string {
+	if !templatedEvents[msg.Event] || msg.Text == "" || !isDelayed(rmsg, dest) {
+		return msg.Text
+	}
+	return "[" + gw.formatTimestamp(rmsg, dest) + "] " + msg.Text
+}
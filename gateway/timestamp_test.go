@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigTimestamp = []byte(`
+[irc.freenode]
+server=""
+RemoteNickFormat="<{NICK}> "
+TimestampDelay=60
+TimestampFormat="15:04"
+TimestampTimezone="UTC"
+[discord.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+	`)
+
+func TestDelayedTimestamp(t *testing.T) {
+	r := maketestRouter(testconfigTimestamp)
+	gw := r.Gateways["bridge1"]
+	recorder := &recordBridger{}
+	gw.Bridges["irc.freenode"].Bridger = recorder
+	dest := gw.Bridges["irc.freenode"]
+	channel := gw.Channels["#wimtestingirc.freenode"]
+
+	send := func(ts time.Time) string {
+		msg := config.Message{Text: "hi", Username: "wim", Channel: "general", Account: "discord.test", Protocol: "discord", Gateway: "bridge1", Timestamp: ts}
+		_, err := gw.SendMessage(&msg, dest, channel, "")
+		assert.NoError(t, err)
+		return recorder.sent[len(recorder.sent)-1].Text
+	}
+
+	assert.Equal(t, "hi", send(time.Now()))
+	old := time.Now().Add(-time.Hour).UTC()
+	assert.Equal(t, "["+old.Format("15:04")+"] hi", send(old))
+}
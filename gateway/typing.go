@@ -0,0 +1,68 @@
+package gateway
+
+import (
+	"sync"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultTypingRate = 30
+	typingBurst       = 5
+	// typingInterval is how often the typing or presence of the same user is sent to a
+	// channel, typing indicators last longer than that.
+	typingInterval = 5 * time.Second
+)
+
+// typingLimits keeps the typing and presence events sent to the channels of the gateway.
+type typingLimits struct {
+	sync.Mutex
+	channels map[string]*rate.Limiter
+	users    map[string]time.Time
+}
+
+func newTypingLimits() *typingLimits {
+	return &typingLimits{channels: make(map[string]*rate.Limiter), users: make(map[string]time.Time)}
+}
+
+func isTypingOrPresence(msg *config.Message) bool {
+	return msg.Event == config.EventUserTyping || msg.Event == config.EventPresence
+}
+
+// allowTyping returns true if the typing or presence event msg can be sent to the channel
+// channelID: at most TypingRate events a minute are sent to a channel, and one every
+// typingInterval for a user and presence.
+func (gw *Gateway) allowTyping(msg *config.Message, channelID string) bool {
+	limit := gw.MyConfig.TypingRate
+	if limit <= 0 {
+		limit = defaultTypingRate
+	}
+	now := time.Now()
+	user := channelID + " " + msg.Account + " " + msg.UserID + " " + msg.Username
+	if msg.Event == config.EventPresence {
+		user += " " + msg.Text
+	}
+
+	gw.typing.Lock()
+	defer gw.typing.Unlock()
+	if last, ok := gw.typing.users[user]; ok && now.Sub(last) < typingInterval {
+		return false
+	}
+	lim, ok := gw.typing.channels[channelID]
+	if !ok {
+		lim = rate.NewLimiter(rate.Limit(float64(limit)/60), typingBurst)
+		gw.typing.channels[channelID] = lim
+	}
+	if !lim.AllowN(now, 1) {
+		return false
+	}
+	for key, last := range gw.typing.users {
+		if now.Sub(last) >= typingInterval {
+			delete(gw.typing.users, key)
+		}
+	}
+	gw.typing.users[user] = now
+	return true
+}
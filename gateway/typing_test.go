@@ -0,0 +1,50 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowTyping(t *testing.T) {
+	gw := &Gateway{MyConfig: &config.Gateway{}, typing: newTypingLimits()}
+	msg := &config.Message{Username: "user", Account: "discord.test", Event: config.EventUserTyping}
+
+	assert.True(t, gw.allowTyping(msg, "general"))
+	// the same user typing again is dropped for typingInterval, in another channel it isn't
+	assert.False(t, gw.allowTyping(msg, "general"))
+	assert.True(t, gw.allowTyping(msg, "random"))
+
+	presence := &config.Message{Username: "user", Account: "discord.test", Event: config.EventPresence, Text: "away"}
+	assert.True(t, gw.allowTyping(presence, "general"))
+	presence.Text = "online"
+	assert.True(t, gw.allowTyping(presence, "general"))
+
+	// by then the burst of the channel is used
+	allowed := 0
+	for _, user := range []string{"a", "b", "c", "d"} {
+		if gw.allowTyping(&config.Message{Username: user, Event: config.EventUserTyping}, "general") {
+			allowed++
+		}
+	}
+	assert.Equal(t, typingBurst-3, allowed)
+}
+
+func TestSendTyping(t *testing.T) {
+	r := maketestRouter(testconfig)
+	gw := r.Gateways["bridge1"]
+	rec := &recordBridger{}
+	dest := gw.Bridges["slack.test"]
+	dest.Bridger = rec
+	channel := gw.Channels["testingslack.test"]
+
+	for i := 0; i < 2; i++ {
+		msg := &config.Message{Username: "user", Channel: "#wimtesting", Account: "irc.freenode", Protocol: "irc", Event: config.EventUserTyping}
+		_, err := gw.SendMessage(msg, dest, channel, "")
+		require.NoError(t, err)
+	}
+	require.Len(t, rec.sent, 1)
+	assert.Equal(t, config.EventUserTyping, rec.sent[0].Event)
+}
@@ -0,0 +1,76 @@
+package gateway
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/helper"
+	"github.com/42wim/matterbridge/gateway/bridgemap"
+)
+
+// urlOnly returns true if fi is a link to a file without its content, like the files sent
+// with only a URL to the api.
+func urlOnly(fi config.FileInfo) bool {
+	return fi.URL != "" && fi.Media == nil && (fi.Data == nil || len(*fi.Data) == 0)
+}
+
+// uploadsFiles returns true if msg is relayed to a destination that uploads the files
+// natively.
+func (r *Router) uploadsFiles(msg *config.Message) bool {
+	for _, gw := range r.gateways() {
+		if _, ok := gw.Bridges[msg.Account]; !ok {
+			continue
+		}
+		for _, br := range gw.Bridges {
+			if _, ok := bridgemap.FileUploadSupport[br.Protocol]; ok && br.Account != msg.Account {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// needsFetch returns true if msg has files fetchFiles downloads.
+func (r *Router) needsFetch(msg *config.Message) bool {
+	if msg.Extra == nil {
+		return false
+	}
+	for _, f := range msg.Extra["file"] {
+		if fi, ok := f.(config.FileInfo); ok && urlOnly(fi) {
+			return r.uploadsFiles(msg)
+		}
+	}
+	return false
+}
+
+// fetchFiles downloads the files of msg that only have a URL when it's relayed to a
+// destination that uploads the files natively, so they're shown inline there instead of as a
+// link. The files bigger than MediaDownloadSize keep only their URL, they aren't downloaded
+// further than the limit. It runs in the background, see queueMessage.
+func (r *Router) fetchFiles(msg *config.Message) {
+	if !r.needsFetch(msg) {
+		return
+	}
+	general := r.BridgeValues().General
+	client := &http.Client{Timeout: time.Second * 5}
+	for i, f := range msg.Extra["file"] {
+		fi, ok := f.(config.FileInfo)
+		if !ok || !urlOnly(fi) {
+			continue
+		}
+		media, err := helper.DownloadMedia(client, fi.URL, nil, int64(general.MediaDownloadSize), &general)
+		if err == helper.ErrFileTooBig {
+			r.logger.Debugf("not uploading %s of %s, it's bigger than MediaDownloadSize", fi.URL, msg.Account)
+			continue
+		}
+		if err != nil {
+			r.logger.Errorf("failed to download %s of %s: %s", fi.URL, msg.Account, err)
+			continue
+		}
+		fi.Data = nil
+		fi.Media = media
+		fi.Size = media.Size()
+		msg.Extra["file"][i] = fi
+	}
+}
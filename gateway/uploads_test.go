@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testconfigUploads = []byte(`
+[general]
+MediaDownloadSize=10
+
+[irc.freenode]
+server=""
+[irc.other]
+server=""
+[slack.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "slack.test"
+    channel = "general"
+
+[[gateway]]
+    name = "bridge2"
+    enable=true
+
+    [[gateway.inout]]
+    account = "irc.other"
+    channel = "#test"
+`)
+
+func TestFetchFiles(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/big.png" {
+			w.Write([]byte("more than ten bytes"))
+			return
+		}
+		w.Write([]byte("image"))
+	}))
+	defer srv.Close()
+	r := maketestRouter(testconfigUploads)
+	files := func(account string, names ...string) *config.Message {
+		msg := &config.Message{Account: account, Extra: make(map[string][]interface{})}
+		for _, name := range names {
+			msg.Extra["file"] = append(msg.Extra["file"], config.FileInfo{Name: name, URL: srv.URL + "/" + name})
+		}
+		return msg
+	}
+
+	// slack uploads the files
+	msg := files("irc.freenode", "a.png", "big.png")
+	r.fetchFiles(msg)
+	fi := msg.Extra["file"][0].(config.FileInfo)
+	data, err := fi.Bytes()
+	require.NoError(t, err)
+	assert.Equal(t, "image", string(data))
+	assert.Equal(t, int64(5), fi.Size)
+	// bigger than MediaDownloadSize
+	assert.True(t, urlOnly(msg.Extra["file"][1].(config.FileInfo)))
+
+	// irc only sends the links
+	msg = files("irc.other", "a.png")
+	r.fetchFiles(msg)
+	assert.True(t, urlOnly(msg.Extra["file"][0].(config.FileInfo)))
+}
+
+func TestLimitMedia(t *testing.T) {
+	gw := maketestRouter(testconfigUploads).Gateways["bridge1"]
+	gw.MyConfig.MediaMaxSize = 100
+	dest := gw.Bridges["slack.test"]
+	small, big := []byte("small"), make([]byte, 200)
+	msg := &config.Message{Extra: map[string][]interface{}{"file": {
+		config.FileInfo{Name: "a.png", Data: &small},
+		config.FileInfo{Name: "b.png", Data: &big, URL: "https://media.example.com/b.png"},
+	}}}
+	shared := msg.Extra
+
+	gw.limitMedia(msg, dest)
+	require.Len(t, msg.Extra["file"], 1)
+	assert.Equal(t, "a.png", msg.Extra["file"][0].(config.FileInfo).Name)
+	require.Len(t, msg.Extra[config.EventFileFailureSize], 1)
+	fi := msg.Extra[config.EventFileFailureSize][0].(config.FileInfo)
+	assert.Equal(t, int64(200), fi.Size)
+	assert.Equal(t, "https://media.example.com/b.png", fi.URL)
+	assert.True(t, hasFileLink(msg))
+	// the other destinations still get both files
+	assert.Len(t, shared["file"], 2)
+	assert.Empty(t, shared[config.EventFileFailureSize])
+}
@@ -0,0 +1,157 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/42wim/matterbridge/bridge"
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+)
+
+const (
+	verifyChallenge = "challenge"
+	verifyURL       = "url"
+
+	// verifyTTL is how long a challenge or verification token is valid.
+	verifyTTL = 24 * time.Hour
+
+	verifiedBucket  = "verified"
+	challengeBucket = "verify:challenge"
+	tokenBucket     = "verify:token"
+)
+
+// challengeEmojis are the emojis users are asked to reply with.
+var challengeEmojis = [][2]string{
+	{"apple", "🍎"}, {"banana", "🍌"}, {"grapes", "🍇"}, {"pizza", "🍕"},
+	{"cat", "🐱"}, {"dog", "🐶"}, {"rocket", "🚀"}, {"snowman", "⛄"},
+}
+
+func (gw *Gateway) verificationEnabled() bool {
+	return gw.MyConfig.Verification.Mode != ""
+}
+
+func (r *Router) isVerified(key string) bool {
+	return store.NewBucket(r.Store, verifiedBucket).Contains(key)
+}
+
+func (r *Router) setVerified(key string) {
+	if err := store.NewBucket(r.Store, verifiedBucket).SetString(key, time.Now().Format(time.RFC3339)); err != nil {
+		r.logger.Errorf("verification: failed to store verified user %s: %s", key, err)
+	}
+}
+
+// verifyMessage returns true if msg must not be relayed because its sender isn't verified yet.
+// Unverified users get a challenge (or verification URL) on their first message, in a private
+// message when their bridge supports it.
+func (gw *Gateway) verifyMessage(msg *config.Message) bool {
+	if !gw.verificationEnabled() {
+		return false
+	}
+	if msg.Event != "" && msg.Event != config.EventUserAction {
+		return false
+	}
+	key := userKey(msg)
+	if gw.Router.isVerified(key) {
+		return false
+	}
+
+	challenges := store.NewBucket(gw.Router.Store, challengeBucket)
+	if answer, ok := challenges.GetString(key); ok {
+		if answer != "" && strings.TrimSpace(msg.Text) == answer {
+			gw.Router.setVerified(key)
+			_ = challenges.Delete(key)
+			gw.logger.Infof("verification: %s (%s) passed the challenge", msg.Username, msg.Account)
+			gw.notifyUser(msg, "Thanks, you're verified. Your messages will be relayed from now on.")
+		}
+		return true
+	}
+
+	var text string
+	switch strings.ToLower(gw.MyConfig.Verification.Mode) {
+	case verifyChallenge:
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(challengeEmojis))))
+		if err != nil {
+			gw.logger.Errorf("verification: %s", err)
+			return true
+		}
+		emoji := challengeEmojis[n.Int64()]
+		if err := challenges.SetStringTTL(key, emoji[1], verifyTTL); err != nil {
+			gw.logger.Errorf("verification: failed to store challenge: %s", err)
+			return true
+		}
+		text = "Your messages are not relayed to the other side of this bridge until you're verified. " +
+			"Reply in the channel with the " + emoji[0] + " emoji to verify."
+	case verifyURL:
+		token, err := newVerifyToken()
+		if err != nil {
+			gw.logger.Errorf("verification: %s", err)
+			return true
+		}
+		if err := store.NewBucket(gw.Router.Store, tokenBucket).SetStringTTL(token, key, verifyTTL); err != nil {
+			gw.logger.Errorf("verification: failed to store token: %s", err)
+			return true
+		}
+		// remember that we've sent the URL so we don't send it for every message
+		_ = challenges.SetStringTTL(key, "", verifyTTL)
+		text = "Your messages are not relayed to the other side of this bridge until you're verified. " +
+			"Verify at " + strings.ReplaceAll(gw.MyConfig.Verification.URL, "{TOKEN}", token)
+	default:
+		gw.logger.Errorf("verification: unknown mode %s", gw.MyConfig.Verification.Mode)
+		return true
+	}
+	gw.logger.Infof("verification: not relaying message from unverified user %s (%s)", msg.Username, msg.Account)
+	gw.notifyUser(msg, text)
+	return true
+}
+
+// notifyUser sends text to the sender of msg, as a private message if the bridge supports it,
+// otherwise as a reply in the channel the message came from.
+func (gw *Gateway) notifyUser(msg *config.Message, text string) {
+	br := gw.Bridges[msg.Account]
+	if dm, ok := br.Bridger.(bridge.DirectMessenger); ok && msg.UserID != "" {
+		err := dm.SendDirect(msg.UserID, text)
+		if err == nil {
+			return
+		}
+		gw.logger.Warnf("private message to %s on %s failed: %s", msg.Username, msg.Account, err)
+	}
+	_, err := br.Send(config.Message{
+		Text:     msg.Username + ": " + text,
+		Channel:  msg.Channel,
+		Account:  msg.Account,
+		ParentID: msg.ID,
+	})
+	if err != nil {
+		gw.logger.Errorf("failed to notify %s on %s: %s", msg.Username, msg.Account, err)
+	}
+}
+
+func newVerifyToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// handleEventUserVerified marks the user of the verification token in msg as verified,
+// the token is sent by the external verification service through the API.
+func (r *Router) handleEventUserVerified(msg *config.Message) {
+	if msg.Event != config.EventUserVerified {
+		return
+	}
+	tokens := store.NewBucket(r.Store, tokenBucket)
+	key, ok := tokens.GetString(msg.Text)
+	if !ok {
+		r.logger.Warnf("verification: unknown or expired token from %s", msg.Account)
+		return
+	}
+	_ = tokens.Delete(msg.Text)
+	_ = store.NewBucket(r.Store, challengeBucket).Delete(key)
+	r.setVerified(key)
+	r.logger.Infof("verification: %s is verified", key)
+}
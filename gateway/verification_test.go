@@ -0,0 +1,72 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/bridge/store"
+	"github.com/stretchr/testify/assert"
+)
+
+var testconfigVerification = []byte(`
+[irc.freenode]
+server=""
+[discord.test]
+server=""
+
+[[gateway]]
+    name = "bridge1"
+    enable=true
+
+    [gateway.verification]
+    mode = "challenge"
+
+    [[gateway.inout]]
+    account = "irc.freenode"
+    channel = "#wimtesting"
+
+    [[gateway.inout]]
+    account = "discord.test"
+    channel = "general"
+	`)
+
+func TestVerificationChallenge(t *testing.T) {
+	r := maketestRouter(testconfigVerification)
+	gw := r.Gateways["bridge1"]
+	irc := &recordBridger{}
+	gw.Bridges["irc.freenode"].Bridger = irc
+
+	msg := &config.Message{Text: "hi", Username: "joe", Channel: "#wimtesting", Account: "irc.freenode"}
+	assert.True(t, gw.verifyMessage(msg))
+	assert.Len(t, irc.sent, 1)
+	assert.Equal(t, "#wimtesting", irc.sent[0].Channel)
+
+	// wrong answers are dropped silently
+	assert.True(t, gw.verifyMessage(msg))
+	assert.Len(t, irc.sent, 1)
+
+	answer, ok := store.NewBucket(r.Store, challengeBucket).GetString(userKey(msg))
+	assert.True(t, ok)
+	assert.True(t, gw.verifyMessage(&config.Message{Text: answer, Username: "joe", Channel: "#wimtesting", Account: "irc.freenode"}))
+	assert.Len(t, irc.sent, 2)
+	assert.False(t, gw.verifyMessage(msg))
+}
+
+func TestVerificationURL(t *testing.T) {
+	r := maketestRouter(testconfigVerification)
+	gw := r.Gateways["bridge1"]
+	gw.MyConfig.Verification.Mode = verifyURL
+	gw.MyConfig.Verification.URL = "https://verify.example.com/{TOKEN}"
+	irc := &recordBridger{}
+	gw.Bridges["irc.freenode"].Bridger = irc
+
+	msg := &config.Message{Text: "hi", Username: "joe", Channel: "#wimtesting", Account: "irc.freenode"}
+	assert.True(t, gw.verifyMessage(msg))
+	assert.Len(t, irc.sent, 1)
+	assert.Contains(t, irc.sent[0].Text, "https://verify.example.com/")
+
+	tokens := store.NewBucket(r.Store, tokenBucket).Keys()
+	assert.Len(t, tokens, 1)
+	r.handleEventUserVerified(&config.Message{Event: config.EventUserVerified, Text: tokens[0], Account: "api.local"})
+	assert.False(t, gw.verifyMessage(msg))
+}
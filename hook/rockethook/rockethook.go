@@ -3,11 +3,13 @@ package rockethook
 import (
 	"crypto/tls"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
 	"log"
 	"net"
 	"net/http"
 	"regexp"
+
+	"github.com/42wim/matterbridge/hook/signature"
 )
 
 // Message for rocketchat outgoing webhook.
@@ -30,9 +32,10 @@ type Client struct {
 
 // Config for client.
 type Config struct {
-	BindAddress        string // Address to listen on
-	Token              string // Only allow this token from Rocketchat. (Allow everything when empty)
-	InsecureSkipVerify bool   // disable certificate checking
+	BindAddress        string             // Address to listen on
+	Token              string             // Only allow this token from Rocketchat. (Allow everything when empty)
+	InsecureSkipVerify bool               // disable certificate checking
+	Verifier           signature.Verifier // Only allow correctly signed requests. (Allow everything when nil)
 }
 
 // New Rocketchat client.
@@ -68,13 +71,22 @@ func (c *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	msg := Message{}
-	body, err := ioutil.ReadAll(r.Body)
+	body, err := signature.ReadAndVerify(c.Verifier, w, r, signature.MaxBodySize)
+	if errors.Is(err, signature.ErrTooLarge) {
+		log.Println("rejecting request from " + r.RemoteAddr + ": " + err.Error())
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if errors.Is(err, signature.ErrMissing) || errors.Is(err, signature.ErrInvalid) {
+		log.Println("rejecting request from " + r.RemoteAddr + ": " + err.Error())
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
 	if err != nil {
 		log.Println(err)
 		http.NotFound(w, r)
 		return
 	}
-	defer r.Body.Close()
 	err = json.Unmarshal(body, &msg)
 	if err != nil {
 		log.Println(err)
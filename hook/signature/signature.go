@@ -0,0 +1,180 @@
+// Package signature verifies the signatures platforms add to their webhook requests.
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ModeSlack  = "slack"
+	ModeGitHub = "github"
+	ModeToken  = "token"
+
+	// MaxBodySize is the default size limit of the request bodies read by ReadAndVerify.
+	MaxBodySize = 1 << 20
+
+	// slackMaxAge is the maximum age of a slack request, to prevent replay attacks.
+	slackMaxAge = 5 * time.Minute
+)
+
+var (
+	ErrMissing  = errors.New("signature missing")
+	ErrInvalid  = errors.New("signature invalid")
+	ErrTooLarge = errors.New("request body too large")
+)
+
+// Verifier checks the signature of a webhook request.
+type Verifier interface {
+	// Verify returns an error if body isn't correctly signed.
+	Verify(header http.Header, body []byte) error
+}
+
+// New returns the verifier for mode, using secret as the signing secret.
+// Returns nil when no secret is configured, which disables verification.
+func New(mode, secret string) (Verifier, error) {
+	if secret == "" {
+		return nil, nil
+	}
+	switch strings.ToLower(mode) {
+	case ModeSlack:
+		return &Slack{Secret: secret}, nil
+	case "", ModeGitHub:
+		return &GitHub{Secret: secret}, nil
+	case ModeToken:
+		return &Token{Token: secret}, nil
+	}
+	return nil, fmt.Errorf("unknown webhook signature mode %s", mode)
+}
+
+// ReadAndVerify reads the body of r, verifies it with v and restores the body
+// so that it can be read again by the caller. Bodies bigger than limit bytes are
+// rejected with ErrTooLarge.
+func ReadAndVerify(v Verifier, w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, ErrTooLarge
+		}
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if v == nil {
+		return body, nil
+	}
+	return body, v.Verify(r.Header, body)
+}
+
+// Slack verifies requests signed with a slack signing secret.
+// See https://api.slack.com/authentication/verifying-requests-from-slack
+type Slack struct {
+	Secret string
+}
+
+func (s *Slack) Verify(header http.Header, body []byte) error {
+	sig := header.Get("X-Slack-Signature")
+	ts := header.Get("X-Slack-Request-Timestamp")
+	if sig == "" || ts == "" {
+		return ErrMissing
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	if math.Abs(float64(time.Now().Unix()-unix)) > slackMaxAge.Seconds() {
+		return fmt.Errorf("%w: timestamp too old", ErrInvalid)
+	}
+	mac := hmac.New(sha256.New, []byte(s.Secret))
+	fmt.Fprintf(mac, "v0:%s:", ts)
+	mac.Write(body)
+	return compare(sig, "v0=", mac)
+}
+
+// GitHub verifies requests signed like GitHub webhooks, using the
+// X-Hub-Signature-256 header or the legacy sha1 X-Hub-Signature header.
+type GitHub struct {
+	Secret string
+}
+
+func (g *GitHub) Verify(header http.Header, body []byte) error {
+	if sig := header.Get("X-Hub-Signature-256"); sig != "" {
+		mac := hmac.New(sha256.New, []byte(g.Secret))
+		mac.Write(body)
+		return compare(sig, "sha256=", mac)
+	}
+	if sig := header.Get("X-Hub-Signature"); sig != "" {
+		mac := hmac.New(sha1.New, []byte(g.Secret))
+		mac.Write(body)
+		return compare(sig, "sha1=", mac)
+	}
+	return ErrMissing
+}
+
+// Sign adds the X-Hub-Signature-256 header of body to header, for the requests
+// matterbridge sends itself.
+func (g *GitHub) Sign(header http.Header, body []byte) {
+	mac := hmac.New(sha256.New, []byte(g.Secret))
+	mac.Write(body)
+	header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Token verifies the token field that mattermost and rocketchat outgoing webhooks add
+// to their form or json payload.
+type Token struct {
+	Token string
+}
+
+func (t *Token) Verify(header http.Header, body []byte) error {
+	var token string
+	if ct, _, _ := mime.ParseMediaType(header.Get("Content-Type")); ct == "application/json" {
+		var payload struct {
+			Token string `json:"token"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return ErrInvalid
+		}
+		token = payload.Token
+	} else {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return ErrInvalid
+		}
+		token = values.Get("token")
+	}
+	if token == "" {
+		return ErrMissing
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) != 1 {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func compare(sig, prefix string, mac hash.Hash) error {
+	if !strings.HasPrefix(sig, prefix) {
+		return ErrInvalid
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(sig, prefix))
+	if err != nil || !hmac.Equal(got, mac.Sum(nil)) {
+		return ErrInvalid
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sign(secret, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSlack(t *testing.T) {
+	v, err := New(ModeSlack, "secret")
+	assert.NoError(t, err)
+	body := []byte("token=abc&text=hello")
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	header := http.Header{}
+	assert.ErrorIs(t, v.Verify(header, body), ErrMissing)
+
+	header.Set("X-Slack-Request-Timestamp", ts)
+	header.Set("X-Slack-Signature", "v0="+sign("secret", fmt.Sprintf("v0:%s:%s", ts, body)))
+	assert.NoError(t, v.Verify(header, body))
+
+	header.Set("X-Slack-Signature", "v0="+sign("wrong", fmt.Sprintf("v0:%s:%s", ts, body)))
+	assert.ErrorIs(t, v.Verify(header, body), ErrInvalid)
+
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	header.Set("X-Slack-Request-Timestamp", old)
+	header.Set("X-Slack-Signature", "v0="+sign("secret", fmt.Sprintf("v0:%s:%s", old, body)))
+	assert.ErrorIs(t, v.Verify(header, body), ErrInvalid)
+}
+
+func TestGitHub(t *testing.T) {
+	v, err := New(ModeGitHub, "secret")
+	assert.NoError(t, err)
+	body := []byte(`{"text":"hello"}`)
+
+	header := http.Header{}
+	assert.ErrorIs(t, v.Verify(header, body), ErrMissing)
+
+	header.Set("X-Hub-Signature-256", "sha256="+sign("secret", string(body)))
+	assert.NoError(t, v.Verify(header, body))
+
+	header.Set("X-Hub-Signature-256", "sha256="+sign("secret", "tampered"))
+	assert.ErrorIs(t, v.Verify(header, body), ErrInvalid)
+
+	header = http.Header{}
+	(&GitHub{Secret: "secret"}).Sign(header, body)
+	assert.Equal(t, "sha256="+sign("secret", string(body)), header.Get("X-Hub-Signature-256"))
+	assert.NoError(t, v.Verify(header, body))
+}
+
+func TestToken(t *testing.T) {
+	v, err := New(ModeToken, "secret")
+	assert.NoError(t, err)
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+	assert.NoError(t, v.Verify(header, []byte("token=secret&text=hello")))
+	assert.ErrorIs(t, v.Verify(header, []byte("token=wrong&text=hello")), ErrInvalid)
+	assert.ErrorIs(t, v.Verify(header, []byte("text=hello")), ErrMissing)
+
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	assert.NoError(t, v.Verify(header, []byte(`{"token":"secret","text":"hello"}`)))
+	assert.ErrorIs(t, v.Verify(header, []byte(`{"token":"wrong","text":"hello"}`)), ErrInvalid)
+	assert.ErrorIs(t, v.Verify(header, []byte(`{"text":"hello"}`)), ErrMissing)
+	assert.ErrorIs(t, v.Verify(header, []byte(`token=secret`)), ErrInvalid)
+}
+
+func TestReadAndVerify(t *testing.T) {
+	v, err := New(ModeToken, "secret")
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("token=secret&text=hello"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := ReadAndVerify(v, httptest.NewRecorder(), r, MaxBodySize)
+	assert.NoError(t, err)
+	assert.Equal(t, "token=secret&text=hello", string(body))
+	// the body can be read again
+	assert.NoError(t, r.ParseForm())
+	assert.Equal(t, "hello", r.PostForm.Get("text"))
+
+	r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(make([]byte, 100)))
+	_, err = ReadAndVerify(nil, httptest.NewRecorder(), r, 10)
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestNew(t *testing.T) {
+	v, err := New(ModeSlack, "")
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+
+	_, err = New("unknown", "secret")
+	assert.Error(t, err)
+}
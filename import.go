@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/42wim/matterbridge/bridge/config"
+	"github.com/42wim/matterbridge/gateway"
+	"github.com/42wim/matterbridge/gateway/bridgemap"
+	"github.com/42wim/matterbridge/gateway/importer"
+	"github.com/sirupsen/logrus"
+)
+
+// runImport runs "matterbridge import", which adds the history in the export of a platform
+// to the archive (ArchivePath), or relays it through the gateways with -relay.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	conf := flags.String("conf", "matterbridge.toml", "config file")
+	format := flags.String("format", "", "format of the export: discord, matrix or slack")
+	file := flags.String("file", "", "export to import")
+	account := flags.String("account", "", "account the export is of, eg slack.myteam")
+	channel := flags.String("channel", "", "only import this channel of a slack export, the channel of a discord or matrix export")
+	gw := flags.String("gateway", "", "gateway the messages are archived as")
+	relay := flags.Bool("relay", false, "relay the messages to the channels of the gateways too, they're archived by the gateways")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" || *account == "" {
+		return fmt.Errorf("-file and -account are needed")
+	}
+	if !*relay && *gw == "" {
+		return fmt.Errorf("-gateway is needed without -relay")
+	}
+
+	msgs, err := importer.Read(*format, *file)
+	if err != nil {
+		return err
+	}
+	protocol := strings.Split(*account, ".")[0]
+	imported := msgs[:0]
+	for _, msg := range msgs {
+		if *channel != "" {
+			if *format == "slack" && msg.Channel != *channel {
+				continue
+			}
+			msg.Channel = *channel
+		}
+		msg.Account, msg.Protocol = *account, protocol
+		imported = append(imported, msg)
+	}
+
+	logger := setupLogger()
+	logger.SetOutput(os.Stderr)
+	if !*relay {
+		logger.SetLevel(logrus.WarnLevel)
+	}
+	cfg := config.NewConfig(logger, *conf)
+	if !*relay {
+		path := cfg.BridgeValues().General.ArchivePath
+		if path == "" {
+			return fmt.Errorf("there's no archive to import in, ArchivePath isn't set in %s", *conf)
+		}
+		if err := gateway.ImportArchive(path, *gw, imported); err != nil {
+			return err
+		}
+		fmt.Printf("imported %d messages\n", len(imported))
+		return nil
+	}
+
+	r, err := gateway.NewRouter(logger, cfg, bridgemap.FullMap)
+	if err != nil {
+		return err
+	}
+	if err := r.Start(); err != nil {
+		return err
+	}
+	if err := r.Import(imported); err != nil {
+		return err
+	}
+	fmt.Printf("relayed %d messages\n", len(imported))
+	return nil
+}
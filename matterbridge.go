@@ -4,8 +4,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"syscall"
 
 	"github.com/42wim/matterbridge/bridge/config"
 	"github.com/42wim/matterbridge/gateway"
@@ -21,16 +23,39 @@ var (
 	flagDebug   = flag.Bool("debug", false, "enable debug")
 	flagVersion = flag.Bool("version", false, "show version")
 	flagGops    = flag.Bool("gops", false, "enable gops agent")
+
+	flagRegistration = flag.String("registration", "", "print the appservice registration file of this account (matrix) and exit")
 )
 
+// commands are the subcommands of matterbridge, "matterbridge export -gateway ..."
+var commands = map[string]func(args []string) error{
+	"ctl":    runCtl,
+	"export": runExport,
+	"import": runImport,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s failed: %s\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
 	flag.Parse()
 	if *flagVersion {
 		fmt.Printf("version: %s %s\n", version.Release, version.GitHash)
+		fmt.Printf("bridges: %s\n", strings.Join(bridgemap.Protocols(), ", "))
 		return
 	}
 
 	rootLogger := setupLogger()
+	if *flagRegistration != "" {
+		// stdout is for the registration file
+		rootLogger.SetOutput(os.Stderr)
+	}
 	logger := rootLogger.WithFields(logrus.Fields{"prefix": "main"})
 
 	if *flagGops {
@@ -49,6 +74,19 @@ func main() {
 	cfg := config.NewConfig(rootLogger, *flagConfig)
 	cfg.BridgeValues().General.Debug = *flagDebug
 
+	if *flagRegistration != "" {
+		generate, ok := bridgemap.Registrations[strings.Split(*flagRegistration, ".")[0]]
+		if !ok {
+			logger.Fatalf("%s can't run as an appservice", *flagRegistration)
+		}
+		registration, err := generate(*flagRegistration, cfg)
+		if err != nil {
+			logger.Fatalf("Generating the registration file failed: %s", err)
+		}
+		fmt.Print(registration)
+		return
+	}
+
 	// if logging to a file, ensure it is closed when the program terminates
 	// nolint:errcheck
 	defer func() {
@@ -66,7 +104,17 @@ func main() {
 		logger.Fatalf("Starting gateway failed: %s", err)
 	}
 	logger.Printf("Gateway(s) started successfully. Now relaying messages")
-	select {}
+	if cfg.BridgeValues().General.ReloadOnConfigChange {
+		if err := config.WatchFile(logger, *flagConfig, r.Reload); err != nil {
+			logger.Errorf("Watching %s for changes failed: %s", *flagConfig, err)
+		}
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		logger.Printf("Received SIGHUP, reloading %s", *flagConfig)
+		r.Reload()
+	}
 }
 
 func setupLogger() *logrus.Logger {
@@ -1,10 +1,11 @@
-//Package matterhook provides interaction with mattermost incoming/outgoing webhooks
+// Package matterhook provides interaction with mattermost incoming/outgoing webhooks
 package matterhook
 
 import (
 	"bytes"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,6 +14,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/42wim/matterbridge/hook/signature"
 	"github.com/gorilla/schema"
 	"github.com/slack-go/slack"
 )
@@ -61,10 +63,11 @@ type Client struct {
 
 // Config for client.
 type Config struct {
-	BindAddress        string // Address to listen on
-	Token              string // Only allow this token from Mattermost. (Allow everything when empty)
-	InsecureSkipVerify bool   // disable certificate checking
-	DisableServer      bool   // Do not start server for outgoing webhooks from Mattermost.
+	BindAddress        string             // Address to listen on
+	Token              string             // Only allow this token from Mattermost. (Allow everything when empty)
+	InsecureSkipVerify bool               // disable certificate checking
+	DisableServer      bool               // Do not start server for outgoing webhooks from Mattermost.
+	Verifier           signature.Verifier // Only allow correctly signed requests. (Allow everything when nil)
 }
 
 // New Mattermost client.
@@ -107,6 +110,17 @@ func (c *Client) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if c.Verifier != nil {
+		if _, err := signature.ReadAndVerify(c.Verifier, w, r, signature.MaxBodySize); err != nil {
+			log.Println("rejecting request from " + r.RemoteAddr + ": " + err.Error())
+			if errors.Is(err, signature.ErrTooLarge) {
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
 	msg := IMessage{}
 	err := r.ParseForm()
 	if err != nil {